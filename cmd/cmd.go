@@ -2,7 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 const (
@@ -20,6 +24,14 @@ func ExitOnRuntimeError(err error) {
 	}
 }
 
+// ExitOnInvalidArgument checks for an invalid flag, then quits, returning a non-zero exit code.
+func ExitOnInvalidArgument(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(InvalidArgument)
+	}
+}
+
 // ExitOnMissingFlag checks for an empty value, then quits, returning a non-zero exit code.
 func ExitOnMissingFlag(value, flag string) {
 	if value == "" {
@@ -27,3 +39,32 @@ func ExitOnMissingFlag(value, flag string) {
 		os.Exit(InvalidArgument)
 	}
 }
+
+// ReadSecretFile reads a secret, such as a token mounted from a Kubernetes secret.
+// Surrounding whitespace is trimmed. The secret is never included in the error.
+func ReadSecretFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to read secret file %s", path)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// LookupSecret returns the secret value when set, otherwise it is read from the file.
+func LookupSecret(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	return ReadSecretFile(file)
+}
+
+// SplitList splits a comma separated flag value, ignoring empty entries.
+func SplitList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
@@ -1,11 +1,42 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 
+	"github.com/carolynvs/handbrk8s/cmd"
 	"github.com/carolynvs/handbrk8s/internal/dashboard"
+	"github.com/pkg/errors"
 )
 
 func main() {
-	log.Fatal(dashboard.Serve())
+	cfg := parseArgs()
+	log.Fatal(dashboard.Serve(cfg))
+}
+
+// parseArgs reads and validates flags and environment variables.
+func parseArgs() (cfg dashboard.Config) {
+	fs := flag.NewFlagSet("dashboard", flag.ExitOnError)
+
+	var tokenFile string
+	fs.StringVar(&cfg.Addr, "addr", ":80", "Address to listen on")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", "", "TLS certificate file, used with -tls-key to serve https")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", "", "TLS private key file, used with -tls-cert to serve https")
+	fs.StringVar(&cfg.Token, "token", os.Getenv("DASHBOARD_TOKEN"),
+		"Token required to use the dashboard, as a bearer token or the basic auth password. "+
+			"Health checks are always allowed. Disabled by default. [DASHBOARD_TOKEN]")
+	fs.StringVar(&tokenFile, "token-file", os.Getenv("DASHBOARD_TOKEN_FILE"),
+		"File containing the dashboard token, used when -token is not set [DASHBOARD_TOKEN_FILE]")
+	fs.Parse(os.Args[1:])
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		cmd.ExitOnInvalidArgument(errors.New("-tls-cert and -tls-key must be used together"))
+	}
+
+	var err error
+	cfg.Token, err = cmd.LookupSecret(cfg.Token, tokenFile)
+	cmd.ExitOnRuntimeError(err)
+
+	return cfg
 }
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/cmd"
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+// presetsample -i VIDEO -o SAMPLE -preset PRESET [-start 5m] [-duration 30s]
+// Transcode a short segment of a video, to try out a preset before using it for a library.
+func main() {
+	encoder, inputPath, outputPath, ffprobeCLI := parseArgs()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	started := time.Now()
+	err := encoder.Transcode(ctx, inputPath, outputPath)
+	cmd.ExitOnRuntimeError(err)
+	elapsed := time.Since(started)
+
+	info, err := os.Stat(outputPath)
+	cmd.ExitOnRuntimeError(errors.Wrapf(err, "unable to stat the sample %s", outputPath))
+
+	// The sample is shorter than requested when the video ends first
+	duration := encoder.Duration
+	if ffprobeCLI != "" {
+		m, err := ffprobe.NewProber(ffprobeCLI).Probe(outputPath)
+		cmd.ExitOnRuntimeError(err)
+		duration = m.Duration
+	}
+
+	fmt.Printf("encoded a %s sample of %s with the %s preset in %s\n", duration, inputPath, encoder.Preset, elapsed.Round(time.Second))
+	fmt.Printf("%s: %s", outputPath, humanize.Bytes(uint64(info.Size())))
+	if duration > 0 {
+		kbps := float64(info.Size()) * 8 / 1000 / duration.Seconds()
+		fmt.Printf(", %.0f kbps, about %s per hour", kbps, humanize.Bytes(uint64(float64(info.Size())*float64(time.Hour)/float64(duration))))
+	}
+	fmt.Println()
+}
+
+// parseArgs reads and validates flags.
+func parseArgs() (encoder handbrake.Encoder, inputPath, outputPath, ffprobeCLI string) {
+	fs := flag.NewFlagSet("presetsample", flag.ExitOnError)
+	fs.StringVar(&inputPath, "i", "", "video to take the sample from")
+	fs.StringVar(&outputPath, "o", "", "where to write the transcoded sample")
+	fs.StringVar(&encoder.Preset, "preset", "", "Name of the HandBrake preset to try")
+	fs.StringVar(&encoder.PresetFile, "preset-file", "", "File of custom presets, exported from HandBrake, that defines -preset")
+	fs.StringVar(&encoder.CLI, "handbrakecli", "HandBrakeCLI", "Path to HandBrakeCLI")
+	fs.DurationVar(&encoder.StartAt, "start", 0, "How far into the video the sample starts, for example 5m to skip the opening credits")
+	fs.DurationVar(&encoder.Duration, "duration", 30*time.Second, "Length of the sample")
+	fs.StringVar(&ffprobeCLI, "ffprobe", "",
+		"Path to ffprobe, used to read the actual length of the sample, when the video ends before -duration. Disabled by default.")
+	fs.Parse(os.Args[1:])
+
+	cmd.ExitOnMissingFlag(inputPath, "-i")
+	cmd.ExitOnMissingFlag(outputPath, "-o")
+	cmd.ExitOnMissingFlag(encoder.Preset, "-preset")
+	if encoder.StartAt < 0 || encoder.Duration <= 0 {
+		cmd.ExitOnInvalidArgument(errors.New("invalid -start or -duration, -start must not be negative and -duration must be positive"))
+	}
+	if encoder.PresetFile != "" {
+		err := handbrake.ValidatePreset(encoder.PresetFile, encoder.Preset)
+		cmd.ExitOnInvalidArgument(err)
+	}
+	return encoder, inputPath, outputPath, ffprobeCLI
+}
@@ -4,162 +4,118 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
-	"time"
 
 	"github.com/carolynvs/handbrk8s/cmd"
-	"github.com/carolynvs/handbrk8s/internal/fs"
-	"github.com/carolynvs/handbrk8s/internal/plex"
-	"github.com/dustin/go-humanize"
+	hfs "github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
+	"github.com/carolynvs/handbrk8s/internal/s3"
+	"github.com/carolynvs/handbrk8s/internal/uploader"
 	"github.com/pkg/errors"
 )
 
-// Gracefully handle restarts between upload steps, continuing to the next step
-// when the previous is already complete:
-// 1. Upload the transcoded video file to the Plex library share
-// 2. Refresh the Plex library to include the new video.
-// 3. Remove the transcoded video file.
-// 4. Remove the original raw video file.
 func main() {
-	libCfg, transcodedPath, pathSuffix, rawPath := parseArgs()
+	opts := parseArgs()
 
-	uploadPath := filepath.Join(libCfg.Share, pathSuffix)
-
-	// Determine if the file should be uploaded
-	shouldUpload := false
-	destStat, destErr := os.Stat(uploadPath)
-	if destErr != nil {
-		if os.IsNotExist(destErr) {
-			fmt.Println("the video is not in on the Plex share and must be uploaded.")
-			shouldUpload = true
-		} else {
-			err := errors.Wrapf(destErr, "cannot stat %s", uploadPath)
-			cmd.ExitOnRuntimeError(err)
-		}
+	err := uploader.Upload(opts)
+	// Retrying won't make an oversized video any smaller, it's already flagged for review
+	if oversized, ok := errors.Cause(err).(uploader.OversizedError); ok {
+		fmt.Printf("%s: %s. Skipping upload.\n", opts.TranscodedPath, oversized)
+		return
 	}
+	// Fail the job of a video that failed in its batch, so that its status shows what happened
+	cmd.ExitOnRuntimeError(err)
+}
 
-	srcStat, srcErr := os.Stat(transcodedPath)
-	if srcErr != nil {
-		if os.IsNotExist(srcErr) {
-			if shouldUpload {
-				fmt.Println(errors.Wrapf(srcErr, "cannot stat the transcoded video file '%s'", transcodedPath))
-				os.Exit(cmd.RuntimeError)
-			}
-			fmt.Println("the transcoded video file is gone and was found on the Plex share. Skipping upload.")
-		} else {
-			err := errors.Wrapf(destErr, "cannot stat %s", uploadPath)
-			cmd.ExitOnRuntimeError(err)
-		}
-	} else if !shouldUpload {
-		destSize := uint64(destStat.Size())
-		srcSize := uint64(srcStat.Size())
-		if destSize != srcSize {
-			shouldUpload = true
-			fmt.Printf("an existing video file was found on the Plex share, and is a different size than the source video file (%s != %s) and must be re-uploaded.",
-				humanize.Bytes(destSize), humanize.Bytes(srcSize))
-		}
-	}
+// parseArgs reads and validates flags and environment variables.
+func parseArgs() (opts uploader.Options) {
+	fs := flag.NewFlagSet("uploader", flag.ExitOnError)
 
-	shouldRefresh := true
-	if shouldUpload {
-		shouldRefresh = true
-		fmt.Println("uploading the video to Plex...")
-		err := fs.CopyFile(transcodedPath, uploadPath)
-		cmd.ExitOnRuntimeError(err)
-	}
+	var plexTokenFile, allowedDirs, outputSecretKeyFile, outputPrefix string
+	var outputBucket s3.Config
+	fs.StringVar(&opts.TranscodedPath, "f", "", "transcoded video file to upload to Plex")
+	fs.StringVar(&opts.PathSuffix, "suffix", "", "relative path of the destination file")
+	fs.StringVar(&opts.RawPath, "raw", "", "original raw video file to cleanup")
+	fs.StringVar(&opts.ArchivePath, "archive", "",
+		"move the original raw video file here, after it is uploaded, instead of removing it")
+	fs.BoolVar(&opts.ArchiveRollback, "archive-rollback", true,
+		"move the original raw video file back out of the archive when the Plex library can't be refreshed")
+	fs.BoolVar(&opts.VerifyArchive, "verify-archive", false,
+		"checksum the original raw video file before archiving it, and only remove it once the archived copy matches")
+	fs.Float64Var(&opts.MaxSizeRatio, "max-size-ratio", 0,
+		"skip uploading a transcoded video larger than this ratio of the raw video's size, 0 disables the check")
+	fs.StringVar(&opts.FailedPath, "failed", "",
+		"move the original raw video file here for review when the video failed to transcode in its batch, or the transcoded video is too large")
+	fs.BoolVar(&opts.Subtitles, "subtitles", false,
+		"also remove, or archive next to the raw video, the subtitle files that were transcoded with it")
+	fs.BoolVar(&opts.KeepRaw, "keep-raw", false,
+		"leave the original raw video file in place once the video is uploaded, for a video with several outputs that is cleaned up by its last upload")
+	fs.BoolVar(&opts.Checksum, "checksum", false, "log the SHA-256 checksum of the uploaded video")
+	fs.BoolVar(&opts.ChecksumSidecar, "checksum-sidecar", false,
+		"write the SHA-256 checksum of the uploaded video to a .sha256 file next to it, implies -checksum")
+
+	fs.StringVar(&opts.Library.URL, "plex-server", "",
+		"Base URL of the Plex server, for example http://192.168.0.105:32400")
+	fs.StringVar(&opts.Library.Token, "plex-token", os.Getenv("PLEX_TOKEN"), "Plex authentication token [PLEX_TOKEN]")
+	fs.StringVar(&plexTokenFile, "plex-token-file", os.Getenv("PLEX_TOKEN_FILE"),
+		"File containing the Plex authentication token, used when -plex-token is not set [PLEX_TOKEN_FILE]")
+	fs.StringVar(&opts.Library.Name, "plex-library", "", "Name of a Plex library")
+	fs.StringVar(&opts.Library.Share, "plex-share", "", "Location of the Plex share")
+	fs.DurationVar(&opts.Library.HTTP.Timeout, "plex-timeout", httpclient.DefaultPolicy.Timeout,
+		"How long to wait for each request to the Plex server, such as the library refresh")
+	fs.IntVar(&opts.Library.HTTP.Retries, "plex-retries", httpclient.DefaultPolicy.Retries,
+		"How many times to retry a request to the Plex server that fails or times out")
+	fs.DurationVar(&opts.RefreshDebounce, "refresh-debounce", 0,
+		"Wait this long before refreshing the Plex library, and skip the refresh when another upload already refreshed it")
+	fs.DurationVar(&opts.MinScanInterval, "min-scan-interval", 0,
+		"Never refresh the Plex library again within this long of its last scan, waiting until then instead, and skip the refresh "+
+			"when the library was scanned meanwhile")
+
+	fs.StringVar(&outputBucket.Bucket, "output-s3-bucket", "",
+		"Upload the video to this S3 compatible bucket, instead of the Plex share")
+	fs.StringVar(&outputBucket.Endpoint, "output-s3-endpoint", "https://s3.amazonaws.com",
+		"Base URL of the object store of -output-s3-bucket, for example http://minio:9000")
+	fs.StringVar(&outputBucket.Region, "output-s3-region", "us-east-1", "Region of -output-s3-bucket")
+	fs.StringVar(&outputPrefix, "output-s3-prefix", "", "Prefix of the key of the video, for example videos/")
+	fs.StringVar(&outputBucket.AccessKey, "output-s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"),
+		"Access key for -output-s3-bucket [AWS_ACCESS_KEY_ID]")
+	fs.StringVar(&outputBucket.SecretKey, "output-s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		"Secret key for -output-s3-bucket [AWS_SECRET_ACCESS_KEY]")
+	fs.StringVar(&outputSecretKeyFile, "output-s3-secret-key-file", os.Getenv("AWS_SECRET_ACCESS_KEY_FILE"),
+		"File containing the secret key for -output-s3-bucket, used when -output-s3-secret-key is not set [AWS_SECRET_ACCESS_KEY_FILE]")
+
+	fs.StringVar(&allowedDirs, "allowed-dirs", "",
+		"Comma separated directories where files may be moved, written or removed, and any other file operation is refused. Unrestricted by default.")
 
-	plexC := plex.NewClient(libCfg.ServerConfig)
-	lib, err := plexC.FindLibrary(libCfg.Name)
-	cmd.ExitOnRuntimeError(err)
+	fs.Parse(os.Args[1:])
 
-	// Determine if the Plex library should be refreshed
-	dirName := parentDir(pathSuffix)
-	filename := filepath.Base(pathSuffix)
-	if !shouldRefresh {
-		fmt.Println("checking for the video in the Plex library...")
-		exists, err := lib.HasVideo(dirName, filename)
-		cmd.ExitOnRuntimeError(err)
-		shouldRefresh = !exists
+	var err error
+	if roots := cmd.SplitList(allowedDirs); len(roots) > 0 {
+		opts.Sandbox, err = hfs.NewSandbox(roots...)
+		cmd.ExitOnInvalidArgument(err)
 	}
 
-	if shouldRefresh {
-		fmt.Println("updating the Plex library index...")
-		err := lib.Update()
-		cmd.ExitOnRuntimeError(err)
+	opts.Library.Token, err = cmd.LookupSecret(opts.Library.Token, plexTokenFile)
+	cmd.ExitOnRuntimeError(err)
 
-		fmt.Println("checking that the video in now in the Plex library...")
-		exists := false
-		for i := 0; i < 3; i++ {
-			time.Sleep(1 * time.Second)
-			exists, err = lib.HasVideo(dirName, filename)
-			if err != nil {
-				continue
-			}
-			if exists {
-				break
-			}
-		}
-		if !exists {
-			err = errors.New("plex was updated but the video is still not in the library")
-			cmd.ExitOnRuntimeError(err)
-		}
-	} else {
-		fmt.Println("the video is already in the Plex library. Skipping update.")
+	cmd.ExitOnMissingFlag(opts.TranscodedPath, "-f")
+	cmd.ExitOnMissingFlag(opts.RawPath, "-raw")
+	cmd.ExitOnMissingFlag(opts.Library.URL, "-plex-server")
+	cmd.ExitOnMissingFlag(opts.Library.Token, "-plex-token or -plex-token-file")
+	cmd.ExitOnMissingFlag(opts.Library.Name, "-plex-library")
+	opts.Library.HTTP.Backoff = httpclient.DefaultPolicy.Backoff
+	if err := opts.Library.HTTP.Validate(); err != nil {
+		cmd.ExitOnInvalidArgument(errors.Wrap(err, "invalid -plex-timeout or -plex-retries"))
 	}
 
-	// Determine if the transcoded file should be removed
-	_, err = os.Stat(transcodedPath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			err = errors.Wrapf(err, "cannot stat %s", transcodedPath)
-			cmd.ExitOnRuntimeError(err)
-		}
-	} else {
-		fmt.Printf("removing %s\n", transcodedPath)
-		err = os.Remove(transcodedPath)
+	if outputBucket.Bucket != "" {
+		outputBucket.SecretKey, err = cmd.LookupSecret(outputBucket.SecretKey, outputSecretKeyFile)
 		cmd.ExitOnRuntimeError(err)
-	}
-
-	// Determine if the original raw file should be removed
-	_, err = os.Stat(rawPath)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			err = errors.Wrapf(err, "cannot stat %s", transcodedPath)
-			cmd.ExitOnRuntimeError(err)
-		}
+		cmd.ExitOnMissingFlag(outputBucket.AccessKey, "-output-s3-access-key")
+		cmd.ExitOnMissingFlag(outputBucket.SecretKey, "-output-s3-secret-key or -output-s3-secret-key-file")
+		opts.Destination = uploader.BucketDestination{Client: s3.NewClient(outputBucket), Prefix: outputPrefix}
 	} else {
-		fmt.Printf("removing %s\n", rawPath)
-		err = os.Remove(rawPath)
-		cmd.ExitOnRuntimeError(err)
+		cmd.ExitOnMissingFlag(opts.Library.Share, "-plex-share")
 	}
-}
-
-// parseArgs reads and validates flags and environment variables.
-func parseArgs() (plexCfg plex.LibraryConfig, transcodedPath, destinationSuffix, rawPath string) {
-	fs := flag.NewFlagSet("uploader", flag.ExitOnError)
-
-	fs.StringVar(&transcodedPath, "f", "", "transcoded video file to upload to Plex")
-	fs.StringVar(&destinationSuffix, "suffix", "", "relative path of the destination file")
-	fs.StringVar(&rawPath, "raw", "", "original raw video file to cleanup")
-
-	fs.StringVar(&plexCfg.URL, "plex-server", "",
-		"Base URL of the Plex server, for example http://192.168.0.105:32400")
-	fs.StringVar(&plexCfg.Token, "plex-token", os.Getenv("PLEX_TOKEN"), "Plex authentication token [PLEX_TOKEN]")
-	fs.StringVar(&plexCfg.Name, "plex-library", "", "Name of a Plex library")
-	fs.StringVar(&plexCfg.Share, "plex-share", "", "Location of the Plex share")
-
-	fs.Parse(os.Args[1:])
-
-	cmd.ExitOnMissingFlag(transcodedPath, "-f")
-	cmd.ExitOnMissingFlag(rawPath, "-raw")
-	cmd.ExitOnMissingFlag(plexCfg.URL, "-plex-server")
-	cmd.ExitOnMissingFlag(plexCfg.Token, "-plex-token")
-	cmd.ExitOnMissingFlag(plexCfg.Name, "-plex-library")
-	cmd.ExitOnMissingFlag(plexCfg.Share, "-plex-share")
-
-	return plexCfg, transcodedPath, destinationSuffix, rawPath
-}
 
-func parentDir(path string) string {
-	return filepath.Base(filepath.Dir(path))
+	return opts
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+)
+
+// describe prints which files the watcher picks up, and which preset it selects for
+// them, from the loaded configuration, without watching for videos.
+func describe(w io.Writer, opts options) {
+	fmt.Fprintln(w, "Files:")
+	if opts.s3Cfg.Bucket != "" {
+		fmt.Fprintf(w, "  downloaded from the bucket %s/%s\n", opts.s3Cfg.Bucket, opts.s3Prefix)
+	} else {
+		fmt.Fprintf(w, "  found in %s\n", filepath.Join(watchVolume, "watch"))
+	}
+	if opts.watchDirectories {
+		fmt.Fprintln(w, "  each directory in a library is a single video")
+	}
+	if opts.pathRegex != "" {
+		fmt.Fprintf(w, "  only paths matching -path-regex %s\n", opts.pathRegex)
+	} else {
+		fmt.Fprintln(w, "  every file extension, set -path-regex to pick up only some")
+	}
+	fmt.Fprintln(w, "  skipping hidden files, named with a leading dot, and the raw args files ending in "+watcher.RawArgsSuffix)
+	if opts.marker.Suffix != "" {
+		fmt.Fprintf(w, "  skipping the processing markers ending in %s\n", opts.marker.Suffix)
+	}
+	if opts.processed.IsSet() {
+		fmt.Fprintf(w, "  skipping the processed videos named %s\n", opts.processed.String())
+	}
+	if opts.subtitles {
+		fmt.Fprintf(w, "  transcoding the subtitles ending in %s with their videos\n", strings.Join(handbrake.SubtitleExtensions(), ", "))
+	}
+
+	fmt.Fprintln(w, "\nPreset rules, the first match is used:")
+	for i, rule := range opts.presetRules {
+		rules := watcher.PresetRules{rule}
+		fmt.Fprintf(w, "  %d. %s\n", i+1, rules.String())
+	}
+	for _, profile := range opts.jobProfiles {
+		if profile.Preset != "" && len(profile.Libraries) > 0 {
+			fmt.Fprintf(w, "  then the %s profile for %s => %s\n", profile.Name, strings.Join(profile.Libraries, ", "), profile.Preset)
+		}
+	}
+	fmt.Fprintf(w, "  otherwise => %s\n", opts.videoPreset)
+	if len(opts.defaultArgs) > 0 {
+		fmt.Fprintf(w, "  with the default args %s, before the args of the profile and the raw args of the video\n", opts.defaultArgs.String())
+	}
+
+	known := make(map[string]bool)
+	if opts.presetFile != "" {
+		presets, err := handbrake.LoadPresetFile(opts.presetFile)
+		if err != nil {
+			fmt.Fprintf(w, "\nUnable to read the presets: %s\n", err)
+		} else {
+			printPresets(w, "Presets in "+opts.presetFile, presets.Names(), known)
+		}
+	}
+	builtin, err := handbrake.BuiltinPresets(opts.handbrakeCLI)
+	if err != nil {
+		fmt.Fprintf(w, "\nUnable to list the presets built into HandBrakeCLI, is -handbrakecli %s installed?\n", opts.handbrakeCLI)
+	} else {
+		printPresets(w, "Presets built into "+opts.handbrakeCLI, builtin, known)
+	}
+
+	if len(known) > 0 {
+		presets := append([]string{opts.videoPreset}, opts.presetRules.Presets()...)
+		presets = append(presets, opts.devices.Presets()...)
+		for _, preset := range append(presets, opts.jobProfiles.Presets()...) {
+			if !known[preset] {
+				fmt.Fprintf(w, "\nThe preset %q is not defined by any of the presets above\n", preset)
+			}
+		}
+	}
+}
+
+func printPresets(w io.Writer, title string, names []string, known map[string]bool) {
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s\n", name)
+		known[name] = true
+	}
+}
@@ -2,54 +2,1171 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/carolynvs/handbrk8s/cmd"
+	"github.com/carolynvs/handbrk8s/internal/bus"
+	"github.com/carolynvs/handbrk8s/internal/dashboard"
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/notify"
 	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/carolynvs/handbrk8s/internal/s3"
 	"github.com/carolynvs/handbrk8s/internal/watcher"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 )
 
 var configVolume = "/config"
 var watchVolume = "/watch"
 var workVolume = "/work"
 var plexVolume = "/plex"
-var videoPreset = "tivo"
+
+const (
+	// kubernetesMode creates jobs to transcode and upload each video.
+	kubernetesMode = "kubernetes"
+
+	// localMode transcodes and uploads each video on the current host.
+	localMode = "local"
+)
+
+// options are the watcher settings, read from flags and environment variables.
+type options struct {
+	mode                string
+	handbrakeCLI        string
+	ffprobeCLI          string
+	integrity           bool
+	integrityTolerance  time.Duration
+	plexCfg             plex.LibraryConfig
+	plexTokenSecret     string
+	plexRefreshDebounce watcher.LibraryDurations
+	plexMinScanInterval watcher.LibraryDurations
+	cooldown            time.Duration
+	startupJitter       time.Duration
+	maxConcurrentWaits  int
+	initialConcurrency  int
+	emitTimeout         time.Duration
+	maxStabilizeWait    time.Duration
+	unstablePolicy      fs.UnstablePolicy
+	quarantineDir       string
+	quarantineEmpty     bool
+	initialIgnore       []string
+	junkPatterns        []string
+	dedupeHardLinks     bool
+	watchDirectories    bool
+	stabilityMode       fs.StabilityMode
+	checkOpenWriters    bool
+	detectStalls        bool
+	expectedSizeXattr   string
+	pathRegex           string
+	rewatchBackoff      fs.Backoff
+	watchReconcile      time.Duration
+	videoPreset         string
+	presetRules         watcher.PresetRules
+	presetFile          string
+	queueSpec           *handbrake.QueueSpec
+	restartPolicy       string
+	backoffLimit        int
+	maxRequeues         int
+	archiveDir          string
+	deletionGrace       time.Duration
+	processingDir       string
+	archiveRollback     bool
+	verifyArchive       bool
+	maxSizeRatio        float64
+	checksum            bool
+	spaceCheck          watcher.SpaceCheck
+	marker              watcher.ProcessingMarker
+	stateKey            string
+	workQueueDir        string
+	lockDir             string
+	lockStaleAfter      time.Duration
+	timings             bool
+	encodeHistory       string
+	historyRetention    time.Duration
+	historyMaxRecords   int
+	checksumSidecar     bool
+	skipUpToDate        bool
+	verifyUpToDate      bool
+	organize            watcher.OrganizeTemplate
+	processed           watcher.ProcessedName
+	companionMarkers    watcher.CompanionMarkers
+	outputs             watcher.LibraryOutputs
+	batchMaxFileSize    int64
+	fastLane            watcher.FastLane
+	batchSize           int
+	batchWindow         time.Duration
+	submitInterval      time.Duration
+	schedule            watcher.Schedule
+	maxPending          int
+	subtitles           bool
+	subtitleGrace       time.Duration
+	burnSubtitles       bool
+	preHook, postHook   watcher.Hook
+	s3Cfg               s3.Config
+	s3Prefix            string
+	s3PollInterval      time.Duration
+	s3ResyncInterval    time.Duration
+	s3Delete            bool
+	outputBucket        watcher.OutputBucket
+	scratchDir          string
+	scratchBudget       int64
+	jobTargets          watcher.JobTargets
+	jobProfiles         watcher.JobProfiles
+	resourceTiers       watcher.ResourceTiers
+	devices             watcher.DeviceProfiles
+	titleRule           handbrake.TitleRule
+	kubeconfig          string
+	pathRewrites        watcher.PathRewrites
+	transcodeDeadline   time.Duration
+	successExitCodes    handbrake.ExitCodes
+	notifyWebhook       string
+	notifyFlushTimeout  time.Duration
+	notifyHTTP          httpclient.Policy
+	eventBus            string
+	eventBusSubject     string
+	eventBusBuffer      int
+	completionDir       string
+	completionName      string
+	encodeAlertAfter    time.Duration
+	encodeStallTimeout  time.Duration
+	admin               dashboard.AdminConfig
+	jobLogDir           string
+	allowedDirs         []string
+	describe            bool
+	defaultArgs         handbrake.Args
+	scanTimeout         time.Duration
+	selfTest            bool
+	reconcileLibrary    bool
+	selfTestTimeout     time.Duration
+}
 
 func main() {
-	plexCfg := parseArgs()
+	opts := parseArgs()
+	if opts.describe {
+		describe(os.Stdout, opts)
+		return
+	}
+
+	if opts.presetFile != "" {
+		presets := append([]string{opts.videoPreset}, opts.presetRules.Presets()...)
+		presets = append(presets, opts.devices.Presets()...)
+		for _, preset := range append(presets, opts.jobProfiles.Presets()...) {
+			err := handbrake.ValidatePreset(opts.presetFile, preset)
+			cmd.ExitOnRuntimeError(err)
+		}
+	}
+
+	if opts.selfTest {
+		if !selfTest(os.Stdout, opts) {
+			os.Exit(cmd.RuntimeError)
+		}
+		return
+	}
+
+	var scratch *fs.Scratch
+	if opts.scratchDir != "" {
+		var err error
+		scratch, err = fs.NewScratch(opts.scratchDir, opts.scratchBudget)
+		cmd.ExitOnRuntimeError(err)
+	}
+
+	if opts.stateKey != "" {
+		var err error
+		opts.marker.Cipher, err = fs.NewCipher(opts.stateKey)
+		cmd.ExitOnInvalidArgument(err)
+	}
 
-	w, err := watcher.NewVideoWatcher(configVolume, watchVolume, workVolume, videoPreset, plexCfg)
-	if err != nil {
+	sandbox, err := fs.NewSandbox(opts.allowedDirs...)
+	cmd.ExitOnRuntimeError(err)
+	log.Printf("only modifying files in %s\n", strings.Join(sandbox.Roots(), ", "))
+
+	var sink watcher.EventSink
+	var watchDir string
+	var notifier notify.Notifier
+	var notifications *notify.Queue
+	if opts.notifyWebhook != "" {
+		notifications = notify.NewQueue(notify.NewWebhook(opts.notifyWebhook, opts.notifyHTTP), 100)
+		notifier = notifications
+	}
+
+	var deletions *watcher.DeletionQueue
+	if opts.deletionGrace > 0 {
+		deletions, err = watcher.NewDeletionQueue(filepath.Join(workVolume, "pending-deletion"), opts.deletionGrace, opts.marker.Cipher)
 		cmd.ExitOnRuntimeError(err)
+		deletions.Sandbox = sandbox
 	}
+
+	var requeueErrs, monitorErrs, logErrs <-chan error
+	done := make(chan struct{})
+	defer close(done)
+	if opts.mode == localMode {
+		localSink := newLocalSink(opts)
+		localSink.Scratch = scratch
+		localSink.Sandbox = sandbox
+		localSink.Marker.ClaimDir, localSink.Marker.Sandbox = localSink.ClaimDir, sandbox
+		localSink.DeletionQueue = deletions
+		localSink.Notifier = notifier
+		localSink.SpaceCheck.Notifier = notifier
+		sink, watchDir = localSink, localSink.WatchDir
+
+		// Nothing else processes the claimed videos, so any left from before a restart were interrupted
+		localSink.Marker.Sweep(watchDir, true)
+	} else {
+		jobSink := newJobSink(opts)
+		jobSink.SpaceCheck.Notifier = notifier
+		jobSink.Notifier = notifier
+		jobSink.Sandbox = sandbox
+		jobSink.Marker.ClaimDir, jobSink.Marker.Sandbox = jobSink.ClaimDir, sandbox
+		jobSink.DeletionQueue = deletions
+		sink, watchDir = jobSink, jobSink.WatchDir
+		go jobSink.Marker.SweepUntil(done, watchDir)
+		if opts.maxRequeues > 0 {
+			requeueErrs = jobSink.WatchTargets(func(target watcher.JobTarget, client jobs.Client) <-chan error {
+				return client.RequeueDisrupted(done, target.Namespace, opts.maxRequeues)
+			})
+		}
+		if opts.jobLogDir != "" {
+			logErrs = jobSink.ArchiveLogs(done)
+		}
+		if notifier != nil {
+			monitorErrs = jobSink.WatchTargets(func(target watcher.JobTarget, client jobs.Client) <-chan error {
+				return client.MonitorTranscodes(done, target.Namespace, opts.encodeAlertAfter, opts.encodeStallTimeout, notifier)
+			})
+		}
+	}
+
+	var source fs.Watcher
+	if opts.s3Cfg.Bucket != "" {
+		source = newBucketWatcher(opts, watchDir, scratch)
+	} else {
+		source = newDirWatcher(opts, watchDir, notifier, sandbox)
+	}
+	w := watcher.NewVideoWatcher(source, watcher.LogSink{}, sink)
 	defer w.Close()
+	var reconciled <-chan watcher.LibraryReport
+	if opts.reconcileLibrary {
+		log.Printf("reconciling the library in %s, exiting once every video that wasn't uploaded is processed\n", watchDir)
+		reconciled = w.WaitForLibrary()
+	}
+	if opts.eventBus != "" {
+		publisher, err := bus.New(opts.eventBus)
+		cmd.ExitOnInvalidArgument(err)
+		w.UseEventBus(publisher, opts.eventBusSubject, opts.eventBusBuffer)
+	}
+	if deletions != nil {
+		log.Printf("keeping the original videos in %s for %s once they are uploaded\n", deletions.Dir, deletions.Grace)
+		w.UseDeletionQueue(deletions)
+	}
+	if opts.completionDir != "" {
+		markers, err := watcher.NewCompletionMarkers(opts.completionDir, opts.completionName)
+		cmd.ExitOnInvalidArgument(err)
+		markers.Sandbox = sandbox
+		w.UseCompletionMarkers(markers)
+	}
+	if opts.encodeHistory != "" {
+		estimator, err := watcher.NewEncodeEstimator(opts.encodeHistory, opts.videoPreset, opts.marker.Cipher)
+		cmd.ExitOnRuntimeError(err)
+		estimator.Retention, estimator.MaxRecords = opts.historyRetention, opts.historyMaxRecords
+		cmd.ExitOnRuntimeError(estimator.Compact())
+		w.UseEncodeEstimator(estimator)
+	}
+	// Keep going when a cluster can't be reached, its jobs are still processed by the cluster
+	if err := w.Reconcile(); err != nil {
+		log.Println(err)
+	}
+	if len(opts.schedule) > 0 {
+		log.Printf("only processing videos during %s\n", opts.schedule.String())
+		w.UseSchedule(opts.schedule)
+	}
+	if opts.subtitles {
+		w.UseSubtitles(opts.subtitleGrace)
+	}
+	if opts.maxPending > 0 {
+		w.UseBackpressure(opts.maxPending)
+	}
+	if opts.lockDir != "" {
+		locks, err := fs.NewLockDir(opts.lockDir, opts.lockStaleAfter)
+		cmd.ExitOnRuntimeError(err)
+		w.UseLocks(locks, watchDir)
+	}
+	if opts.workQueueDir != "" {
+		queue, err := watcher.NewWorkQueue(opts.workQueueDir)
+		cmd.ExitOnRuntimeError(err)
+		queue.Cipher = opts.marker.Cipher
+		cmd.ExitOnRuntimeError(w.UseWorkQueue(queue))
+	}
+
+	adminErrs := make(chan error, 1)
+	if opts.admin.Addr != "" {
+		log.Printf("serving the admin api on %s\n", opts.admin.Addr)
+		go func() { adminErrs <- dashboard.ServeAdmin(opts.admin, w) }()
+	}
 
 	// Only stop watching when our process is killed
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt)
-	for range signals {
-		// Do any cleanup before being shut down
-		log.Println("done watching for videos!")
-		return
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-w.Errors:
+			log.Println(err)
+		case r := <-w.Rejected:
+			log.Printf("skipped %s: %s\n", r.Path, r.Reason)
+		case err, ok := <-requeueErrs:
+			if !ok {
+				requeueErrs = nil
+				continue
+			}
+			log.Println(err)
+		case err, ok := <-monitorErrs:
+			if !ok {
+				monitorErrs = nil
+				continue
+			}
+			log.Println(err)
+		case err, ok := <-logErrs:
+			if !ok {
+				logErrs = nil
+				continue
+			}
+			log.Println(err)
+		case err := <-adminErrs:
+			log.Println(errors.Wrap(err, "stopped serving the admin api"))
+		case report := <-reconciled:
+			log.Printf("reconciled the library: %d uploaded, %d failed, %d skipped\n", report.Uploaded, report.Failed, report.Skipped)
+			if notifications != nil {
+				err := notifications.Flush(opts.notifyFlushTimeout)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			if report.Failed > 0 {
+				w.Close()
+				os.Exit(cmd.RuntimeError)
+			}
+			return
+		case <-signals:
+			// Do any cleanup before being shut down
+			log.Println("done watching for videos!")
+			if notifications != nil {
+				err := notifications.Flush(opts.notifyFlushTimeout)
+				if err != nil {
+					log.Println(err)
+				}
+			}
+			return
+		}
+	}
+}
+
+// newDirWatcher watches the watch directory for new videos.
+func newDirWatcher(opts options, watchDir string, notifier notify.Notifier, sandbox *fs.Sandbox) *fs.StableFileWatcher {
+	watchOpts := []fs.Option{fs.WithSandbox(sandbox), fs.WithCooldown(opts.cooldown), fs.WithStabilityMode(opts.stabilityMode), fs.WithStartupJitter(opts.startupJitter),
+		fs.WithEmitTimeout(opts.emitTimeout), fs.WithMaxStabilizeWait(opts.maxStabilizeWait, opts.unstablePolicy),
+		fs.WithMaxConcurrentWaits(opts.maxConcurrentWaits), fs.WithInitialScanConcurrency(opts.initialConcurrency)}
+	if opts.quarantineDir != "" {
+		watchOpts = append(watchOpts, fs.WithQuarantineDir(opts.quarantineDir))
+	}
+	if opts.quarantineEmpty {
+		watchOpts = append(watchOpts, fs.WithQuarantineEmpty())
+	}
+	if opts.checkOpenWriters {
+		watchOpts = append(watchOpts, fs.WithOpenWriterCheck())
+	}
+	if opts.detectStalls {
+		watchOpts = append(watchOpts, fs.WithStallDetection(opts.expectedSizeXattr, ffprobe.CheckCopiedMP4))
+	}
+	if opts.detectStalls && notifier != nil {
+		watchOpts = append(watchOpts, fs.WithStallAlert(func(path, reason string) {
+			err := notifier.Notify(fmt.Sprintf("%s stopped growing, but %s, holding it as a stalled copy", path, reason))
+			if err != nil {
+				log.Println(err)
+			}
+		}))
+	}
+	if notifier != nil {
+		watchOpts = append(watchOpts, fs.WithHoldAlert(func(path string) {
+			err := notifier.Notify(fmt.Sprintf("%s is still changing after %s, holding it for manual review", path, opts.maxStabilizeWait))
+			if err != nil {
+				log.Println(err)
+			}
+		}))
+	}
+	if opts.dedupeHardLinks {
+		watchOpts = append(watchOpts, fs.WithHardLinkDedupe())
+	}
+	if len(opts.initialIgnore) > 0 {
+		watchOpts = append(watchOpts, fs.WithInitialIgnore(opts.initialIgnore...))
+	}
+	if opts.completionDir != "" {
+		watchOpts = append(watchOpts, fs.WithIgnoredDirs(opts.completionDir))
+	}
+	if opts.processingDir != "" {
+		watchOpts = append(watchOpts, fs.WithIgnoredDirs(opts.processingDir))
+	}
+	if opts.watchDirectories {
+		// Each directory in the watch directory is a library, so the directories in a library are the videos
+		watchOpts = append(watchOpts, fs.WithDirectoryEvents(2))
+	}
+	watchOpts = append(watchOpts, fs.WithJunkPatterns(opts.junkPatterns...))
+	if opts.pathRegex != "" {
+		watchOpts = append(watchOpts, fs.WithPathRegex(opts.pathRegex))
+	}
+	backoff := opts.rewatchBackoff
+	backoff.Jitter = fs.DefaultRewatchBackoff.Jitter
+	cmd.ExitOnInvalidArgument(errors.Wrap(backoff.Validate(), "invalid -rewatch-initial-delay or -rewatch-max-delay"))
+	watchOpts = append(watchOpts, fs.WithRewatchBackoff(backoff))
+	watchOpts = append(watchOpts, fs.WithWatchReconcile(opts.watchReconcile))
+	if opts.ffprobeCLI != "" {
+		watchOpts = append(watchOpts, fs.WithProber(ffprobe.NewProber(opts.ffprobeCLI)))
+	}
+	dirWatcher, err := fs.NewStableFileWatcher(watchDir, 5*time.Second, watchOpts...)
+	cmd.ExitOnRuntimeError(err)
+
+	log.Printf("watching %s for new videos\n", watchDir)
+	return dirWatcher
+}
+
+// newBucketWatcher polls a bucket for new videos, downloading them into the watch directory.
+func newBucketWatcher(opts options, watchDir string, scratch *fs.Scratch) *s3.Watcher {
+	watchOpts := []s3.Option{s3.WithPollInterval(opts.s3PollInterval), s3.WithResyncInterval(opts.s3ResyncInterval), s3.WithStateFile(filepath.Join(workVolume, ".handbrk8s-s3-downloaded.json"), opts.marker.Cipher)}
+	if opts.ffprobeCLI != "" {
+		watchOpts = append(watchOpts, s3.WithProber(ffprobe.NewProber(opts.ffprobeCLI)))
+	}
+	if opts.s3Delete {
+		watchOpts = append(watchOpts, s3.WithDelete())
+	}
+	if scratch != nil {
+		watchOpts = append(watchOpts, s3.WithScratch(scratch))
+	}
+	bucketWatcher, err := s3.NewWatcher(s3.NewClient(opts.s3Cfg), opts.s3Prefix, watchDir, 5*time.Second, watchOpts...)
+	cmd.ExitOnRuntimeError(err)
+
+	log.Printf("watching %s/%s for new videos\n", opts.s3Cfg.Bucket, opts.s3Prefix)
+	return bucketWatcher
+}
+
+// newJobSink creates jobs on the cluster to transcode and upload videos.
+func newJobSink(opts options) *watcher.JobSink {
+	jobSink, err := watcher.NewJobSink(configVolume, watchVolume, workVolume, opts.videoPreset, opts.plexCfg)
+	cmd.ExitOnRuntimeError(err)
+	jobSink.Targets = opts.jobTargets
+	jobSink.Profiles = opts.jobProfiles
+	jobSink.ResourceTiers = opts.resourceTiers
+	jobSink.Devices = opts.devices
+	jobSink.Kubeconfig = opts.kubeconfig
+	jobSink.PlexTokenSecret = opts.plexTokenSecret
+	jobSink.PresetFile = opts.presetFile
+	jobSink.QueueSpec = opts.queueSpec
+	jobSink.Title = opts.titleRule
+	jobSink.PresetRules = opts.presetRules
+	jobSink.PlexRefreshDebounce = opts.plexRefreshDebounce
+	jobSink.PlexMinScanInterval = opts.plexMinScanInterval
+	jobSink.RestartPolicy = corev1.RestartPolicy(opts.restartPolicy)
+	jobSink.BackoffLimit = int32(opts.backoffLimit)
+	jobSink.TranscodeDeadline = opts.transcodeDeadline
+	jobSink.SuccessExitCodes = opts.successExitCodes
+	jobSink.DefaultArgs = opts.defaultArgs
+	jobSink.ScanTimeout = opts.scanTimeout
+	jobSink.ArchiveDir = opts.archiveDir
+	jobSink.Processed = opts.processed
+	jobSink.CompanionMarkers = opts.companionMarkers
+	jobSink.ArchiveRollback = opts.archiveRollback
+	jobSink.VerifyArchive = opts.verifyArchive
+	jobSink.PathRewrites = opts.pathRewrites
+	jobSink.MaxSizeRatio = opts.maxSizeRatio
+	jobSink.SpaceCheck = opts.spaceCheck
+	jobSink.Integrity = newIntegrityCheck(opts)
+	jobSink.Marker = sourceMarker(opts, jobSink.ReadOnlySource, jobSink.WatchDir)
+	jobSink.Timings = opts.timings
+	jobSink.LogDir = opts.jobLogDir
+	jobSink.Checksum = opts.checksum
+	jobSink.ChecksumSidecar = opts.checksumSidecar
+	jobSink.SkipUpToDate = opts.skipUpToDate
+	jobSink.VerifyUpToDate = opts.verifyUpToDate
+	jobSink.Organize = opts.organize
+	jobSink.Outputs = opts.outputs
+	jobSink.OutputBucket = opts.outputBucket
+	jobSink.BatchMaxFileSize = opts.batchMaxFileSize
+	jobSink.BatchSize = opts.batchSize
+	jobSink.BatchWindow = opts.batchWindow
+	jobSink.SubmitInterval = opts.submitInterval
+	jobSink.PreHook = opts.preHook
+	jobSink.PostHook = opts.postHook
+	jobSink.BurnSubtitles = opts.burnSubtitles
+	if opts.processingDir != "" {
+		cmd.ExitOnInvalidArgument(jobSink.UseProcessingDir(opts.processingDir))
 	}
+	return jobSink
+}
+
+// newLocalSink transcodes and uploads videos on the current host.
+func newLocalSink(opts options) *watcher.LocalSink {
+	localSink, err := watcher.NewLocalSink(watchVolume, workVolume, opts.videoPreset, opts.plexCfg)
+	cmd.ExitOnRuntimeError(err)
+	localSink.Encoder.CLI = opts.handbrakeCLI
+	localSink.Encoder.PresetFile = opts.presetFile
+	localSink.Encoder.QueueSpec = opts.queueSpec
+	localSink.Encoder.Title = opts.titleRule
+	localSink.Encoder.SuccessExitCodes = opts.successExitCodes
+	localSink.Encoder.DefaultArgs = opts.defaultArgs
+	localSink.Encoder.ScanTimeout = opts.scanTimeout
+	localSink.Encoder.BurnSubtitles = opts.burnSubtitles
+	localSink.PresetRules = opts.presetRules
+	localSink.PlexRefreshDebounce = opts.plexRefreshDebounce
+	localSink.PlexMinScanInterval = opts.plexMinScanInterval
+	localSink.ArchiveDir = opts.archiveDir
+	localSink.Processed = opts.processed
+	localSink.CompanionMarkers = opts.companionMarkers
+	localSink.ArchiveRollback = opts.archiveRollback
+	localSink.VerifyArchive = opts.verifyArchive
+	localSink.MaxSizeRatio = opts.maxSizeRatio
+	localSink.SpaceCheck = opts.spaceCheck
+	localSink.Integrity = newIntegrityCheck(opts)
+	localSink.Marker = sourceMarker(opts, localSink.ReadOnlySource, localSink.WatchDir)
+	localSink.Timings = opts.timings
+	localSink.Checksum = opts.checksum
+	localSink.ChecksumSidecar = opts.checksumSidecar
+	localSink.SkipUpToDate = opts.skipUpToDate
+	localSink.VerifyUpToDate = opts.verifyUpToDate
+	localSink.Organize = opts.organize
+	localSink.Outputs = opts.outputs
+	localSink.OutputBucket = opts.outputBucket
+	localSink.Devices = opts.devices
+	localSink.EncodeAlertAfter = opts.encodeAlertAfter
+	localSink.EncodeStallTimeout = opts.encodeStallTimeout
+	localSink.PreHook = opts.preHook
+	localSink.PostHook = opts.postHook
+	localSink.FastLane = opts.fastLane
+	if opts.processingDir != "" {
+		cmd.ExitOnInvalidArgument(localSink.UseProcessingDir(opts.processingDir))
+	}
+	return localSink
+}
+
+// sourceMarker marks the videos that are being processed. When the watch directory is
+// read-only, the markers are kept on the work volume instead of next to the videos.
+func sourceMarker(opts options, readOnly bool, watchDir string) watcher.ProcessingMarker {
+	if !readOnly {
+		return opts.marker
+	}
+	if opts.processed.IsSet() {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -processed-name, the watch directory %s is read-only", watchDir))
+	}
+	log.Printf("the watch directory %s is read-only, copying the videos instead of moving them, and keeping the failed videos and markers in %s\n", watchDir, workVolume)
+	marker := opts.marker
+	marker.Dir, marker.WatchDir = filepath.Join(workVolume, "markers"), watchDir
+	return marker
+}
+
+// newIntegrityCheck checks that claimed videos are complete with ffprobe, when enabled.
+func newIntegrityCheck(opts options) watcher.IntegrityCheck {
+	if !opts.integrity {
+		return watcher.IntegrityCheck{}
+	}
+	return watcher.IntegrityCheck{Prober: ffprobe.NewProber(opts.ffprobeCLI), Tolerance: opts.integrityTolerance}
 }
 
 // parseArgs reads and validates flags and environment variables.
-func parseArgs() (plexCfg plex.LibraryConfig) {
+func parseArgs() (opts options) {
+	defaultJunkPatterns := strings.Join(fs.DefaultJunkPatterns, ",")
+	defaultWatchReconcile := fs.DefaultWatchReconcileInterval
+	opts.unstablePolicy = fs.UnstableEmit
+	quarantinePolicy := fs.UnstableQuarantine
+	sizeMode, defaultStabilityMode := fs.StabilitySize, fs.DefaultStabilityMode
 	fs := flag.NewFlagSet("watcher", flag.ExitOnError)
 
-	fs.StringVar(&plexCfg.URL, "plex-server", "",
+	var plexTokenFile, notifyWebhookFile, eventBusFile, stateKeyFile, jobProfilesFile, batchMaxFileSize, fastLaneMaxSize, s3SecretKeyFile, outputSecretKeyFile, scratchBudget, adminTokenFile, minFreeSpace, allowedDirs string
+	var preHook, postHook, initialIgnore, junkPatterns, queueSpecFile, resourceTiersFile, deviceProfilesFile string
+	var hookTimeout time.Duration
+	fs.StringVar(&opts.mode, "mode", kubernetesMode,
+		"Where videos are transcoded: kubernetes creates a job for each video, local runs HandBrakeCLI on this host")
+	fs.StringVar(&opts.handbrakeCLI, "handbrakecli", "HandBrakeCLI", "Path to HandBrakeCLI, used in local mode")
+	fs.StringVar(&opts.ffprobeCLI, "ffprobe", "",
+		"Path to ffprobe, used to read the metadata of each video before it is processed. Disabled by default.")
+	fs.BoolVar(&opts.integrity, "integrity-check", false,
+		"Check that each video can be read to the end of its duration with ffprobe, before it is transcoded, moving an "+
+			"incomplete video, such as an interrupted download, to the fail directory with a .reason file. Requires -ffprobe.")
+	fs.DurationVar(&opts.integrityTolerance, "integrity-tolerance", watcher.DefaultIntegrityTolerance,
+		"How much shorter than its reported duration a video may be read by -integrity-check")
+	fs.StringVar(&watchVolume, "watch-volume", watchVolume, "Location of the watch volume, with the watch and fail directories")
+	fs.StringVar(&workVolume, "work-volume", workVolume, "Location of the work volume, with the claim and work directories")
+	fs.StringVar(&opts.plexCfg.URL, "plex-server", "",
 		"Base URL of the Plex server, for example http://192.168.0.105:32400")
-	fs.StringVar(&plexCfg.Token, "plex-token", os.Getenv("PLEX_TOKEN"), "Plex authentication token [PLEX_TOKEN]")
-	fs.StringVar(&plexCfg.Share, "plex-share", "", "Location of the Plex share")
+	fs.StringVar(&opts.plexCfg.Token, "plex-token", os.Getenv("PLEX_TOKEN"), "Plex authentication token [PLEX_TOKEN]")
+	fs.StringVar(&plexTokenFile, "plex-token-file", os.Getenv("PLEX_TOKEN_FILE"),
+		"File containing the Plex authentication token, used when -plex-token is not set [PLEX_TOKEN_FILE]")
+	fs.StringVar(&opts.plexTokenSecret, "plex-token-secret", "",
+		"Name of a Kubernetes secret, with the Plex authentication token in the 'token' key, used by upload jobs")
+	fs.StringVar(&opts.plexCfg.Share, "plex-share", plexVolume,
+		"Location of the Plex share, used in local mode. Upload jobs always mount the share at "+plexVolume)
+	fs.DurationVar(&opts.plexCfg.HTTP.Timeout, "plex-timeout", httpclient.DefaultPolicy.Timeout,
+		"How long to wait for each request to the Plex server, such as a library refresh, also used by upload jobs")
+	fs.IntVar(&opts.plexCfg.HTTP.Retries, "plex-retries", httpclient.DefaultPolicy.Retries,
+		"How many times to retry a request to the Plex server that fails or times out, also used by upload jobs")
+	fs.Var(&opts.plexRefreshDebounce, "plex-refresh-debounce",
+		"How long to wait before refreshing a Plex library, so that uploads completed around the same time share a refresh. "+
+			"Override the default for a library with LIBRARY=DURATION, for example 30s,TV=2m")
+	fs.Var(&opts.plexMinScanInterval, "plex-min-scan-interval",
+		"Never scan a Plex library again within this long of its last scan, even for separate bursts of uploads, "+
+			"holding the refresh until then instead. Override the default for a library with LIBRARY=DURATION, for example 10m,Movies=1h")
+	fs.DurationVar(&opts.cooldown, "cooldown", 0,
+		"Ignore a video for this long after it is processed, unless it is modified. Disabled by default.")
+	fs.DurationVar(&opts.startupJitter, "startup-jitter", 0,
+		"Delay checking each video that is already in the watch directory, when the watcher starts, randomly by up to this long, "+
+			"so that a large backlog isn't submitted all at once. Disabled by default.")
+	fs.IntVar(&opts.maxConcurrentWaits, "max-concurrent-waits", 0,
+		"Wait on at most this many new files at once for them to stop changing, leaving headroom once the watcher is live. "+
+			"Doesn't apply to the videos already in the watch directory on startup, see -initial-scan-concurrency. Unlimited by default.")
+	fs.IntVar(&opts.initialConcurrency, "initial-scan-concurrency", 0,
+		"Wait on at most this many of the videos already in the watch directory on startup at once, to drain a backlog "+
+			"faster or slower than -max-concurrent-waits. Unlimited by default.")
+	fs.StringVar(&initialIgnore, "initial-ignore", "",
+		"Comma separated files, relative to the watch directory unless absolute, that are skipped when they are already "+
+			"there on startup, such as files still being written by another process. Their later changes are still processed.")
+	fs.DurationVar(&opts.emitTimeout, "emit-timeout", 0,
+		"Skip a stable video when it isn't picked up for processing within this long, instead of waiting for its turn, "+
+			"until it changes again. Disabled by default.")
+	fs.DurationVar(&opts.maxStabilizeWait, "max-stabilize-wait", 0,
+		"Stop waiting for a video that is still changing after this long, and handle it with -unstable-policy instead. "+
+			"Disabled by default, a video is waited on for as long as it keeps changing.")
+	fs.Var(&opts.unstablePolicy, "unstable-policy",
+		"What to do with a video that is still changing after -max-stabilize-wait: emit processes it anyway, "+
+			"quarantine moves it to -quarantine-dir, and hold ignores it, alerting -notify-webhook, until it is "+
+			"released with POST /release?path=PATH on the admin api.")
+	fs.StringVar(&opts.quarantineDir, "quarantine-dir", "",
+		"Directory where -unstable-policy quarantine moves the videos that never stop changing, and -quarantine-empty moves the empty videos")
+	fs.BoolVar(&opts.quarantineEmpty, "quarantine-empty", false,
+		"Move the videos that are still empty once they stop changing, such as placeholders or interrupted writes, to -quarantine-dir. "+
+			"Empty videos are always skipped, by default they are left in place.")
+	fs.Var(&opts.stabilityMode, "stability-mode",
+		"How to decide that a video is completely written: events waits until no file system events are received, "+
+			"size waits until its size stops changing and it is no longer locked, close-write also signals a video as soon as its "+
+			"writer closes it, on Linux only, and hybrid waits until both its events and its size stop changing. "+
+			"Defaults to size on Windows, otherwise events.")
+	fs.BoolVar(&opts.checkOpenWriters, "check-open-writers", false,
+		"Once a video is stable, confirm that no process still has it open for writing, waiting for it to be stable again otherwise, "+
+			"for writers that buffer their writes. Reads the open files of the processes visible to the watcher from /proc on Linux, "+
+			"on other platforms only the lock check is used.")
+	fs.BoolVar(&opts.detectStalls, "detect-stalled-copies", false,
+		"With -stability-mode size, check that a video that stopped growing is complete before it is processed: an MP4 must have "+
+			"all of its boxes and its index, and a video must reach the size in -expected-size-xattr. A stalled copy is held, and "+
+			"alerts -notify-webhook, until it grows again or it is released.")
+	fs.StringVar(&opts.expectedSizeXattr, "expected-size-xattr", "",
+		"Extended attribute, such as user.expected_size, that the program copying a video sets to its final size in bytes, "+
+			"checked by -detect-stalled-copies")
+	fs.StringVar(&opts.pathRegex, "path-regex", "",
+		"Only process videos whose full path matches this regular expression, using forward slashes, "+
+			`for example '.*/Season \d+/.*\.mkv'. Every video is processed by default.`)
+	fs.StringVar(&junkPatterns, "junk-patterns", defaultJunkPatterns,
+		"Skip the files, or the files in the directories, whose names match these comma separated glob patterns, ignoring case, "+
+			"such as sample clips and release info. Replaces the defaults, set it to an empty string to skip nothing.")
+	fs.DurationVar(&opts.rewatchBackoff.Initial, "rewatch-initial-delay", time.Second,
+		"How long to wait before watching the watch directory again after it disappears, such as when its mount drops")
+	fs.DurationVar(&opts.rewatchBackoff.Max, "rewatch-max-delay", 5*time.Minute,
+		"Longest wait between attempts to watch the watch directory again, the wait doubles after each failed attempt")
+	fs.DurationVar(&opts.watchReconcile, "watch-reconcile-interval", defaultWatchReconcile,
+		"How often to compare the watched directories with the directories in the watch directory, watching the missed ones "+
+			"and checking their videos, and no longer watching the removed ones. Set to 0 to disable.")
+	fs.BoolVar(&opts.dedupeHardLinks, "dedupe-hard-links", false,
+		"Skip a video that is a hard link to a video that was already processed, "+
+			"for example when a download client links the same video into multiple watched directories")
+	fs.BoolVar(&opts.describe, "describe", false,
+		"Print which files are picked up, the preset rules in the order that they are evaluated, and the available presets, then exit")
+	fs.BoolVar(&opts.selfTest, "selftest", false,
+		"Transcode a tiny synthetic video with the encoder, or a transcode job in kubernetes mode, check the output "+
+			"and the connection to Plex, report how long each step took, and then clean up and exit. Nothing is uploaded to Plex")
+	fs.BoolVar(&opts.reconcileLibrary, "reconcile-library", false,
+		"Process the videos in the watch directory that weren't uploaded yet, skipping the rest as in -skip-up-to-date, "+
+			"and exit once they have all finished, instead of watching for new videos. Safe to run again, such as from a cron job. "+
+			"Exits with an error when a video failed.")
+	fs.DurationVar(&opts.selfTestTimeout, "selftest-timeout", 10*time.Minute,
+		"How long -selftest waits for the synthetic video to be transcoded")
+	fs.BoolVar(&opts.watchDirectories, "watch-directories", false,
+		"Process each directory in a library as a single video once all of its files are stable, "+
+			"such as a disc structure, instead of processing the files inside it")
+	fs.StringVar(&opts.videoPreset, "preset", "tivo", "Name of the HandBrake preset used to transcode videos")
+	fs.Var(&opts.presetRules, "preset-rule",
+		"Use a different preset for videos matching all the conditions, CONDITION[,CONDITION...]=>[PRESET][@PROFILE][:CONTAINER], "+
+			"for example 'path=TV/*/*,height>=2160=>H.265 MKV 1080p30'. Conditions compare the path relative to the watch directory, "+
+			"or the width, height or codec read by -ffprobe. @PROFILE selects a job from -job-profiles. "+
+			":CONTAINER writes the mp4, m4v, mkv or webm container, and that extension, instead of keeping the extension of the video. "+
+			"May be repeated, and the first matching rule is used.")
+	fs.StringVar(&opts.presetFile, "preset-file", "",
+		"Custom HandBrake presets file, such as presets.json exported from the HandBrake GUI, which must define -preset. "+
+			"The file must be at the same path in the watcher and the transcode jobs.")
+	fs.StringVar(&queueSpecFile, "queue-spec", "",
+		"Queue exported from the HandBrake GUI with a single job, whose settings are imported with --queue-import-file instead of -preset, "+
+			"with its source and destination replaced for each video. Raw args of a video still take precedence.")
+	fs.Var(&opts.titleRule, "title-rule",
+		"Which title of a source with several titles, such as a disc structure, is transcoded: longest, a title number such as 2, "+
+			"or a duration range such as 40m-3h, which selects the longest title within it. The titles are scanned with HandBrakeCLI, "+
+			"except for a title number. Defaults to longest, which only scans the disc structures.")
+	fs.StringVar(&opts.restartPolicy, "restart-policy", string(corev1.RestartPolicyOnFailure),
+		"Restart policy of the transcode pods. OnFailure restarts the container in the same pod, "+
+			"Never creates a new pod for each attempt, keeping the failed pods to inspect their logs.")
+	fs.IntVar(&opts.backoffLimit, "backoff-limit", 20,
+		"Number of failed attempts, either container restarts or new pods depending on -restart-policy, before a transcode job fails")
+	fs.DurationVar(&opts.transcodeDeadline, "transcode-deadline", 0,
+		"Stop a transcode job that runs longer than this, failing it, with the job's activeDeadlineSeconds. Disabled by default.")
+	fs.Var(&opts.successExitCodes, "success-exit-codes",
+		"Comma separated nonzero exit codes of HandBrakeCLI that are treated as a successful transcode, for example when "+
+			"an encoder wrapper exits with a warning. By default only 0 is a success.")
+	fs.Var(&opts.defaultArgs, "handbrake-arg",
+		"A HandBrakeCLI argument for every video, such as --all-audio or --markers. May be repeated, once per argument. "+
+			"The default args come first, so the args of a job profile, and then the raw args of a video, override them. "+
+			"Videos are not batched when set.")
+	fs.DurationVar(&opts.scanTimeout, "scan-timeout", 0,
+		"Stop HandBrakeCLI when it hasn't started encoding within this long, failing the transcode, such as when its scan "+
+			"of a malformed video hangs. Videos are not batched when set. Disabled by default.")
+	fs.DurationVar(&opts.submitInterval, "submit-interval", 0,
+		"Wait at least this long between creating jobs, to smooth the load on the cluster when many videos are found at once. "+
+			"Disabled by default.")
+	fs.IntVar(&opts.maxPending, "max-pending", 0,
+		"Stop checking new files while this many videos are still changing, queued, or waiting to be handled, such as during "+
+			"a large import, and check them once fewer are pending. Disabled by default.")
+	fs.Var(&opts.schedule, "process-window",
+		"Only hand videos to be transcoded during this window, in the local time zone, such as '22:00-06:00', "+
+			"'mon-fri 22:00-06:00' or 'sat,sun 00:00-24:00'. Outside of the windows, stable videos are queued until the next window. "+
+			"May be repeated. Always processing by default.")
+	fs.BoolVar(&opts.subtitles, "subtitles", false,
+		"Transcode each video with the external subtitle files next to it, with the same base name, such as foo.srt "+
+			"or foo.en.srt for foo.mkv. -path-regex must match the subtitle files too.")
+	fs.DurationVar(&opts.subtitleGrace, "subtitle-grace", time.Minute,
+		"How long a video waits for its subtitle files to be stable, after which it is transcoded with the stable ones")
+	fs.BoolVar(&opts.burnSubtitles, "burn-subtitles", false,
+		"Burn the first subtitle file into the video, instead of adding it as a subtitle track")
+	fs.StringVar(&preHook, "pre-hook", "",
+		"Command to run for each claimed video before it is transcoded, such as a virus scan. The arguments may use "+
+			"{{.Input}}, the claimed video, and {{.Output}}, the transcoded video. When the command fails, the video is moved to the failed directory.")
+	fs.StringVar(&postHook, "post-hook", "",
+		"Command to run for each video once it is uploaded. The arguments may use {{.Input}}, where the video was found, "+
+			"and {{.Output}}, the uploaded video. When the command fails, it is logged and sent to -notify-webhook, and the upload is kept.")
+	fs.DurationVar(&hookTimeout, "hook-timeout", 10*time.Minute,
+		"Stop a -pre-hook or -post-hook command that runs longer than this, failing it. Set to 0 to disable.")
+	fs.IntVar(&opts.maxRequeues, "max-requeues", 3,
+		"Recreate a failed transcode job up to this many times, when it failed only because its pods were evicted or preempted. "+
+			"Set to 0 to disable.")
+	fs.StringVar(&opts.archiveDir, "archive-dir", "",
+		"Move the original videos to this directory, as seen by the upload jobs, once they are uploaded to Plex, instead of removing them")
+	fs.StringVar(&opts.processingDir, "processing-dir", "",
+		"Claim the videos into this directory in the watch directory while they are processed, such as .processing, instead of the "+
+			"claim directory of the work volume. The watcher skips the files in it, and processes the videos left in it again when it restarts. Disabled by default.")
+	fs.DurationVar(&opts.deletionGrace, "deletion-grace", 0,
+		"Keep the original videos for this long once they are uploaded, in the pending-deletion directory of the work volume, "+
+			"instead of removing them right away. A video flagged for review, with a VIDEO.review file next to it, is kept. Disabled by default.")
+	fs.Var(&opts.processed, "processed-name",
+		"Rename the original videos in the watch directory with this template once they are uploaded, instead of removing them, "+
+			"for example '{{.Name}}{{.Ext}}.done'. The template may use {{.Name}} and {{.Ext}}, and the renamed videos are skipped.")
+	fs.Var(&opts.companionMarkers, "skip-marker",
+		"Skip the videos that another tool marked as already processed: suffix:SUFFIX for a video whose name ends with the suffix, "+
+			"sidecar:SUFFIX for a video with a file named with the suffix next to it, or xattr:NAME or xattr:NAME=VALUE for a video "+
+			"with the extended attribute. May be repeated. Extended attributes are ignored where they aren't supported.")
+	fs.BoolVar(&opts.archiveRollback, "archive-rollback", true,
+		"Move an archived video back when the Plex library can't be refreshed")
+	fs.BoolVar(&opts.verifyArchive, "verify-archive", false,
+		"Checksum each original video before it is archived, or renamed with -processed-name, and only remove it once the copy matches. "+
+			"A mismatch fails the upload and leaves the original in place.")
+	fs.Float64Var(&opts.maxSizeRatio, "max-size-ratio", 0,
+		"Treat a transcoded video larger than this ratio of the original video's size as a failed transcode, "+
+			"for example 1.0 when a video should never grow, and move the original video to the failed directory for review. "+
+			"Disabled by default.")
+	fs.StringVar(&minFreeSpace, "min-free-space", "",
+		"Hold new videos in the watch directory until the work volume has this much free space, for example 20GB, "+
+			"plus the estimated size of the transcoded video, checking again every minute. Disabled by default.")
+	fs.Float64Var(&opts.spaceCheck.EstimateRatio, "free-space-estimate-ratio", 1.0,
+		"Estimate the size of a transcoded video as this ratio of the original video's size, used by -min-free-space")
+	fs.StringVar(&opts.marker.Suffix, "processing-marker", "",
+		"Create a marker file next to each video in the watch directory while it is processed, named with this suffix, "+
+			"for example .processing. The marker contains the path of the claimed video, and is removed once the video is uploaded. "+
+			"In local mode, videos with a marker left from before a restart are processed again. Disabled by default.")
+	fs.StringVar(&stateKeyFile, "state-key-file", os.Getenv("WATCHER_STATE_KEY_FILE"),
+		"File containing a base64 encoded AES key, for example from openssl rand -base64 32, "+
+			"used to encrypt the files the watcher keeps: the processing markers, work queue, encode history, deletion queue "+
+			"and the objects downloaded from the bucket. "+
+			"Unencrypted files are refused once it is set. Plaintext by default [WATCHER_STATE_KEY_FILE]")
+	fs.StringVar(&opts.lockDir, "lock-dir", "",
+		"Lock each video with a file in this directory before processing it, so that multiple watchers sharing the watch "+
+			"directory don't process the same video twice, for example /watch/.handbrk8s-locks. Disabled by default.")
+	fs.DurationVar(&opts.lockStaleAfter, "lock-stale-after", 10*time.Minute,
+		"Reclaim a lock in -lock-dir that its watcher hasn't refreshed for this long, because the watcher stopped")
+	fs.StringVar(&opts.workQueueDir, "work-queue-dir", "",
+		"Keep the videos that were found in this directory until their jobs are created, or they are transcoded in local mode, "+
+			"so that the videos that were waiting are handled after a restart. Kept in memory by default.")
+	fs.BoolVar(&opts.timings, "log-timings", false,
+		"Log how long each step took for every video, as a line of JSON: stabilizing, waiting to be queued, "+
+			"waiting for the transcode to start, encoding, and uploading. In kubernetes mode, the jobs of each video "+
+			"are checked every 30s until they complete.")
+	fs.StringVar(&opts.encodeHistory, "encode-history", "",
+		"Keep the encode time of every video in this file, as lines of JSON, and estimate how long the queued videos take "+
+			"to encode from it, for each preset. Implies -log-timings. Disabled by default.")
+	fs.DurationVar(&opts.historyRetention, "encode-history-retention", 0,
+		"Drop the videos detected longer ago than this from -encode-history, such as 2160h, compacting the file daily. Kept forever by default.")
+	fs.IntVar(&opts.historyMaxRecords, "encode-history-max-records", 0,
+		"Keep at most this many of the newest videos in -encode-history, compacting the file as it grows past it. Unlimited by default.")
+	fs.StringVar(&opts.jobLogDir, "job-log-dir", "",
+		"Archive the logs of every attempt of each job to NAMESPACE/JOB.log in this directory once the job finishes, or @CONTEXT/NAMESPACE/JOB.log for a -job-targets context, "+
+			"so that the HandBrakeCLI output is kept after its pods are removed. Only used in kubernetes mode. Disabled by default.")
+	fs.BoolVar(&opts.checksum, "checksum", false,
+		"Log the SHA-256 checksum of each video uploaded to the Plex share, to verify the archive later. "+
+			"Reads the whole video again, so it is disabled by default.")
+	fs.BoolVar(&opts.checksumSidecar, "checksum-sidecar", false,
+		"Write the SHA-256 checksum of each uploaded video to a .sha256 file next to it, "+
+			"which can be verified with sha256sum -c. Implies -checksum.")
+	fs.BoolVar(&opts.skipUpToDate, "skip-up-to-date", false,
+		"Skip videos that are already on the Plex share and newer than the original video. "+
+			"In kubernetes mode, the Plex share must be mounted in the watcher at "+plexVolume)
+	fs.BoolVar(&opts.verifyUpToDate, "verify-up-to-date", false,
+		"With -skip-up-to-date, also hash each up-to-date video that has a checksum file from -checksum-sidecar, "+
+			"and process it again when it doesn't match. Reads every uploaded video again on each scan, so it is disabled by default. "+
+			"The videos in -output-s3-bucket aren't verified.")
+	fs.Var(&opts.organize, "organize",
+		"Template for where videos are uploaded in the Plex share, for example "+
+			"'{{.Library}}/{{.Title}} ({{.Year}})/{{.Title}} ({{.Year}}){{.Ext}}'. "+
+			"The template has the Path, Library, Dir, Name, Ext, Title, Year, Season, Episode and probed Metadata of the video. "+
+			"By default videos keep the same path that they had in the watch directory.")
+	fs.Var(&opts.outputs, "library-output",
+		"Upload the videos of a library to its own directory instead of the Plex share, optionally with its own -organize template, "+
+			"LIBRARY=DIR[=TEMPLATE], for example 'TV=/plex/tv={{.Dir}}/{{.Name}}{{.Ext}}'. "+
+			"In kubernetes mode the directory must be on the plex volume. May be repeated.")
+	fs.StringVar(&batchMaxFileSize, "batch-max-file-size", "",
+		"Transcode videos up to this size, for example 200MB, in batches with a single pod. Disabled by default.")
+	fs.IntVar(&opts.batchSize, "batch-size", 10, "Maximum number of videos in a batch")
+	fs.StringVar(&fastLaneMaxSize, "fast-lane-max-size", "",
+		"In local mode, transcode videos up to this size, for example 1GB, alongside a larger video instead of waiting for it to finish. Disabled by default.")
+	fs.IntVar(&opts.fastLane.Slots, "fast-lane-slots", 1,
+		"How many small videos may be transcoded at once alongside a larger video, with -fast-lane-max-size")
+	fs.DurationVar(&opts.batchWindow, "batch-window", 30*time.Second,
+		"How long to wait for more small videos before transcoding a batch")
+	fs.StringVar(&opts.s3Cfg.Bucket, "s3-bucket", "",
+		"Watch this S3 compatible bucket for new videos, instead of the watch directory, downloading them into the watch directory")
+	fs.StringVar(&opts.s3Cfg.Endpoint, "s3-endpoint", "https://s3.amazonaws.com",
+		"Base URL of the S3 compatible object store, for example http://minio:9000")
+	fs.StringVar(&opts.s3Cfg.Region, "s3-region", "us-east-1", "Region of the bucket")
+	fs.StringVar(&opts.s3Prefix, "s3-prefix", "", "Only watch the objects with this prefix, for example incoming/")
+	fs.StringVar(&opts.s3Cfg.AccessKey, "s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"),
+		"Access key for the bucket [AWS_ACCESS_KEY_ID]")
+	fs.StringVar(&opts.s3Cfg.SecretKey, "s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		"Secret key for the bucket [AWS_SECRET_ACCESS_KEY]")
+	fs.StringVar(&s3SecretKeyFile, "s3-secret-key-file", os.Getenv("AWS_SECRET_ACCESS_KEY_FILE"),
+		"File containing the secret key for the bucket, used when -s3-secret-key is not set [AWS_SECRET_ACCESS_KEY_FILE]")
+	fs.DurationVar(&opts.s3PollInterval, "s3-poll-interval", 30*time.Second, "How often to list the new objects in the bucket")
+	fs.DurationVar(&opts.s3ResyncInterval, "s3-resync-interval", time.Hour,
+		"How often to list every object in the bucket, instead of only the objects after the last key that was handled, "+
+			"to find the new objects whose keys sort before it")
+	fs.BoolVar(&opts.s3Delete, "s3-delete", false,
+		"Delete each object from the bucket once it is downloaded, so that it isn't downloaded again after a restart")
+	fs.StringVar(&opts.outputBucket.Bucket, "output-s3-bucket", "",
+		"Upload the transcoded videos to this S3 compatible bucket, instead of the Plex share")
+	fs.StringVar(&opts.outputBucket.Endpoint, "output-s3-endpoint", "https://s3.amazonaws.com",
+		"Base URL of the object store of -output-s3-bucket, for example http://minio:9000")
+	fs.StringVar(&opts.outputBucket.Region, "output-s3-region", "us-east-1", "Region of -output-s3-bucket")
+	fs.StringVar(&opts.outputBucket.Prefix, "output-s3-prefix", "",
+		"Prefix of the key of each video uploaded to -output-s3-bucket, for example videos/")
+	fs.StringVar(&opts.outputBucket.AccessKey, "output-s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"),
+		"Access key for -output-s3-bucket [AWS_ACCESS_KEY_ID]")
+	fs.StringVar(&opts.outputBucket.SecretKey, "output-s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		"Secret key for -output-s3-bucket [AWS_SECRET_ACCESS_KEY]")
+	fs.StringVar(&outputSecretKeyFile, "output-s3-secret-key-file", os.Getenv("AWS_SECRET_ACCESS_KEY_FILE"),
+		"File containing the secret key for -output-s3-bucket, used when -output-s3-secret-key is not set [AWS_SECRET_ACCESS_KEY_FILE]")
+	fs.StringVar(&opts.outputBucket.Secret, "output-s3-secret", "",
+		"Name of a secret with the access-key and secret-key of -output-s3-bucket, read by the upload jobs instead of embedding the keys")
+	opts.jobTargets.Default.Namespace = watcher.Namespace
+	fs.Var(&opts.jobTargets, "job-targets",
+		"Namespace where jobs are created, with optional overrides per library, for example handbrk8s,TV=tv@gpu-cluster. "+
+			"A target with @CONTEXT creates the jobs on the cluster of that context in the kubeconfig, where they are requeued, archived and monitored like those on the current cluster. "+
+			"The namespaces must have the handbrk8s and plex volume claims, and the watcher must be allowed to manage jobs in them.")
+	fs.StringVar(&jobProfilesFile, "job-profiles", "",
+		"YAML file with named job profiles: the image, resources, preset, HandBrakeCLI args, labels and namespace of the transcode jobs. "+
+			"A profile is selected by the libraries it lists, or by a preset rule ending in @PROFILE.")
+	fs.StringVar(&resourceTiersFile, "resource-tiers", "",
+		"YAML file with resource tiers, which size the transcode pods of the videos that are at least a minimum size, or -ffprobe height, "+
+			"overriding the resources of their job profile. The first matching tier is used.")
+	fs.StringVar(&deviceProfilesFile, "device-profiles", "",
+		"YAML file with device profiles, each a preset, container and output directory, and rules that select the devices of a video. "+
+			"A matching video is transcoded and uploaded once for each of its devices, and only cleaned up once every device succeeded.")
+	fs.StringVar(&opts.kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"),
+		"Kubeconfig with the contexts used by -job-targets. Defaults to the standard kubeconfig locations [KUBECONFIG]")
+	fs.Var(&opts.pathRewrites, "job-path-rewrite",
+		"In kubernetes mode, rewrite a prefix of the paths passed to the jobs, WATCHER_PREFIX=JOB_PREFIX, for example /mnt/media=/watch, "+
+			"when the media is mounted at a different path in the pods of the jobs than in the watcher. May be repeated.")
+	fs.StringVar(&opts.scratchDir, "scratch-dir", "",
+		"Directory for temporary files, such as the videos transcoded in local mode and the objects downloaded from -s3-bucket. "+
+			"Stale files are removed on startup and after each video. Disabled by default.")
+	fs.StringVar(&scratchBudget, "scratch-budget", "",
+		"Maximum size of the files in -scratch-dir, for example 50GB, before new videos wait for space. Unlimited by default.")
+	fs.StringVar(&opts.notifyWebhook, "notify-webhook", os.Getenv("NOTIFY_WEBHOOK_URL"),
+		"Post alerts to this webhook, such as a Slack incoming webhook, as JSON with a text field [NOTIFY_WEBHOOK_URL]")
+	fs.StringVar(&notifyWebhookFile, "notify-webhook-file", os.Getenv("NOTIFY_WEBHOOK_URL_FILE"),
+		"File containing the URL of -notify-webhook, which embeds its credentials, used when -notify-webhook is not set [NOTIFY_WEBHOOK_URL_FILE]")
+	fs.DurationVar(&opts.notifyFlushTimeout, "notify-flush-timeout", 10*time.Second,
+		"How long to keep sending the pending alerts to -notify-webhook when shutting down")
+	fs.DurationVar(&opts.notifyHTTP.Timeout, "notify-timeout", httpclient.DefaultPolicy.Timeout,
+		"How long to wait for -notify-webhook to accept an alert")
+	fs.IntVar(&opts.notifyHTTP.Retries, "notify-retries", httpclient.DefaultPolicy.Retries,
+		"How many times to retry an alert when -notify-webhook is rate limited or unavailable")
+	fs.StringVar(&opts.eventBus, "event-bus", os.Getenv("EVENT_BUS_URL"),
+		"Publish every pipeline event as JSON to this message bus, nats://[USER:PASSWORD@]HOST:PORT for NATS, "+
+			"or kafka+http(s)://HOST:PORT for the REST proxy of a Kafka cluster. Disabled by default [EVENT_BUS_URL]")
+	fs.StringVar(&eventBusFile, "event-bus-file", os.Getenv("EVENT_BUS_URL_FILE"),
+		"File containing the URL of -event-bus, with its password, used when -event-bus is not set [EVENT_BUS_URL_FILE]")
+	fs.StringVar(&opts.eventBusSubject, "event-bus-subject", "handbrk8s.events",
+		"NATS subject, or Kafka topic, of the events published to -event-bus")
+	fs.IntVar(&opts.eventBusBuffer, "event-bus-buffer", 1000,
+		"Maximum number of events waiting to be published to -event-bus, before new events are dropped")
+	fs.StringVar(&opts.completionDir, "completion-dir", "",
+		"Write a JSON completion marker to this directory when each video is uploaded or fails, for another tool watching the directory. "+
+			"The watcher skips the files in the directory, even when it is in the watch directory. Disabled by default.")
+	fs.StringVar(&opts.completionName, "completion-name", watcher.DefaultCompletionName,
+		"Template for the name of each -completion-dir marker, with the Name, Ext, ID and Status of the video")
+	fs.DurationVar(&opts.encodeAlertAfter, "encode-alert-after", 0,
+		"Alert -notify-webhook when a transcode runs longer than this. "+
+			"In kubernetes mode, defaults to 80% of -transcode-deadline, or the activeDeadlineSeconds of the job.")
+	fs.DurationVar(&opts.encodeStallTimeout, "encode-stall-timeout", 0,
+		"Alert -notify-webhook when the progress of a transcode hasn't advanced for this long. "+
+			"Only applies once HandBrakeCLI reports progress. Disabled by default.")
+	fs.StringVar(&opts.admin.Addr, "admin-addr", "",
+		"Address to serve the admin api on, for example :8080, to pause and resume the pipeline. Disabled by default.")
+	fs.StringVar(&opts.admin.Token, "admin-token", os.Getenv("WATCHER_ADMIN_TOKEN"),
+		"Token required to use the admin api, as a bearer token or the basic auth password [WATCHER_ADMIN_TOKEN]")
+	fs.StringVar(&adminTokenFile, "admin-token-file", os.Getenv("WATCHER_ADMIN_TOKEN_FILE"),
+		"File containing the admin api token, used when -admin-token is not set [WATCHER_ADMIN_TOKEN_FILE]")
+	fs.StringVar(&allowedDirs, "allowed-dirs", "",
+		"Comma separated directories where videos may be moved, written or removed, and any other file operation is refused. "+
+			"Defaults to the watch and work volumes, the Plex share, -library-output, -archive-dir and -scratch-dir.")
 	fs.Parse(os.Args[1:])
 
-	cmd.ExitOnMissingFlag(plexCfg.URL, "-plex-server")
-	cmd.ExitOnMissingFlag(plexCfg.Token, "-plex-token")
+	if opts.mode != kubernetesMode && opts.mode != localMode {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -mode %q, must be %s or %s", opts.mode, kubernetesMode, localMode))
+	}
+	if batchMaxFileSize != "" {
+		size, err := humanize.ParseBytes(batchMaxFileSize)
+		cmd.ExitOnInvalidArgument(errors.Wrapf(err, "invalid -batch-max-file-size %q", batchMaxFileSize))
+		opts.batchMaxFileSize = int64(size)
+	}
+	if fastLaneMaxSize != "" {
+		size, err := humanize.ParseBytes(fastLaneMaxSize)
+		cmd.ExitOnInvalidArgument(errors.Wrapf(err, "invalid -fast-lane-max-size %q", fastLaneMaxSize))
+		opts.fastLane.MaxSize = int64(size)
+		if opts.fastLane.Slots < 1 {
+			cmd.ExitOnInvalidArgument(errors.Errorf("invalid -fast-lane-slots %d, must be at least 1", opts.fastLane.Slots))
+		}
+	}
+	if scratchBudget != "" {
+		cmd.ExitOnMissingFlag(opts.scratchDir, "-scratch-dir")
+		size, err := humanize.ParseBytes(scratchBudget)
+		cmd.ExitOnInvalidArgument(errors.Wrapf(err, "invalid -scratch-budget %q", scratchBudget))
+		opts.scratchBudget = int64(size)
+	}
+	if minFreeSpace != "" {
+		size, err := humanize.ParseBytes(minFreeSpace)
+		cmd.ExitOnInvalidArgument(errors.Wrapf(err, "invalid -min-free-space %q", minFreeSpace))
+		opts.spaceCheck.MinFree = int64(size)
+	}
+	if opts.spaceCheck.EstimateRatio < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -free-space-estimate-ratio %v, must not be negative", opts.spaceCheck.EstimateRatio))
+	}
+	if opts.deletionGrace < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -deletion-grace %s, must not be negative", opts.deletionGrace))
+	}
+	if opts.deletionGrace > 0 && (opts.archiveDir != "" || opts.processed.IsSet()) {
+		cmd.ExitOnInvalidArgument(errors.New("invalid -deletion-grace, the original videos are already kept by -archive-dir or -processed-name"))
+	}
+	if opts.archiveDir != "" && opts.processed.IsSet() {
+		cmd.ExitOnInvalidArgument(errors.New("invalid -processed-name, -archive-dir is already set"))
+	}
+	if opts.maxSizeRatio < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -max-size-ratio %v, must not be negative", opts.maxSizeRatio))
+	}
+	if opts.transcodeDeadline < 0 || opts.encodeAlertAfter < 0 || opts.encodeStallTimeout < 0 {
+		cmd.ExitOnInvalidArgument(errors.New("invalid -transcode-deadline, -encode-alert-after or -encode-stall-timeout, must not be negative"))
+	}
+	if opts.scanTimeout != 0 && opts.scanTimeout < time.Second {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -scan-timeout %s, must be at least 1s", opts.scanTimeout))
+	}
+	cmd.ExitOnInvalidArgument(watcher.CheckDefaultArgs(opts.defaultArgs))
+	if opts.submitInterval < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -submit-interval %s, must not be negative", opts.submitInterval))
+	}
+	if opts.integrity {
+		cmd.ExitOnMissingFlag(opts.ffprobeCLI, "-ffprobe")
+	}
+	if opts.integrityTolerance < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -integrity-tolerance %s, must not be negative", opts.integrityTolerance))
+	}
+	if hookTimeout < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -hook-timeout %s, must not be negative", hookTimeout))
+	}
+	var err error
+	opts.preHook, err = watcher.ParseHook(preHook)
+	cmd.ExitOnInvalidArgument(errors.Wrap(err, "invalid -pre-hook"))
+	opts.postHook, err = watcher.ParseHook(postHook)
+	cmd.ExitOnInvalidArgument(errors.Wrap(err, "invalid -post-hook"))
+	opts.preHook.Timeout, opts.postHook.Timeout = hookTimeout, hookTimeout
+	if queueSpecFile != "" {
+		opts.queueSpec, err = handbrake.LoadQueueSpec(queueSpecFile)
+		cmd.ExitOnInvalidArgument(errors.Wrap(err, "invalid -queue-spec"))
+	}
+	if deviceProfilesFile != "" {
+		opts.devices, err = watcher.LoadDeviceProfiles(deviceProfilesFile)
+		cmd.ExitOnInvalidArgument(err)
+		if opts.queueSpec != nil {
+			cmd.ExitOnInvalidArgument(errors.New("-device-profiles cannot be used with -queue-spec, which replaces the preset of every video"))
+		}
+		if opts.outputBucket.Bucket != "" {
+			cmd.ExitOnInvalidArgument(errors.New("-device-profiles cannot be used with -output-s3-bucket, which replaces the output of every device"))
+		}
+	}
+	opts.initialIgnore = cmd.SplitList(initialIgnore)
+	opts.junkPatterns = cmd.SplitList(junkPatterns)
+	opts.allowedDirs = cmd.SplitList(allowedDirs)
+	if len(opts.allowedDirs) == 0 {
+		opts.allowedDirs = append([]string{watchVolume, workVolume, opts.plexCfg.Share}, opts.outputs.Dirs()...)
+		opts.allowedDirs = append(opts.allowedDirs, opts.devices.Dirs()...)
+		if opts.archiveDir != "" {
+			opts.allowedDirs = append(opts.allowedDirs, opts.archiveDir)
+		}
+		if opts.scratchDir != "" {
+			opts.allowedDirs = append(opts.allowedDirs, opts.scratchDir)
+		}
+		if opts.completionDir != "" {
+			opts.allowedDirs = append(opts.allowedDirs, opts.completionDir)
+		}
+		if opts.quarantineDir != "" {
+			opts.allowedDirs = append(opts.allowedDirs, opts.quarantineDir)
+		}
+	}
+	if opts.encodeHistory != "" {
+		opts.timings = true
+	}
+	if opts.reconcileLibrary {
+		opts.skipUpToDate = true
+	}
+	if opts.historyRetention < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -encode-history-retention %s, must be at least 0", opts.historyRetention))
+	}
+	if opts.historyMaxRecords < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -encode-history-max-records %d, must be at least 0", opts.historyMaxRecords))
+	}
+	if opts.batchSize < 1 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -batch-size %d, must be at least 1", opts.batchSize))
+	}
+	if opts.completionDir != "" && !filepath.IsAbs(opts.completionDir) {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -completion-dir %q, must be an absolute path", opts.completionDir))
+	}
+	if opts.maxConcurrentWaits < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -max-concurrent-waits %d, must not be negative", opts.maxConcurrentWaits))
+	}
+	if opts.initialConcurrency < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -initial-scan-concurrency %d, must not be negative", opts.initialConcurrency))
+	}
+	if opts.maxStabilizeWait < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -max-stabilize-wait %s, must not be negative", opts.maxStabilizeWait))
+	}
+	if opts.maxStabilizeWait > 0 && opts.unstablePolicy == quarantinePolicy && opts.quarantineDir == "" {
+		cmd.ExitOnInvalidArgument(errors.Errorf("-unstable-policy %s requires -quarantine-dir", quarantinePolicy))
+	}
+	if opts.detectStalls && opts.stabilityMode != sizeMode && (opts.stabilityMode != "" || defaultStabilityMode != sizeMode) {
+		cmd.ExitOnInvalidArgument(errors.Errorf("-detect-stalled-copies requires -stability-mode %s", sizeMode))
+	}
+	if opts.expectedSizeXattr != "" && !opts.detectStalls {
+		cmd.ExitOnInvalidArgument(errors.New("-expected-size-xattr requires -detect-stalled-copies"))
+	}
+	if opts.quarantineEmpty && opts.quarantineDir == "" {
+		cmd.ExitOnInvalidArgument(errors.New("-quarantine-empty requires -quarantine-dir"))
+	}
+	if opts.maxPending < 0 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -max-pending %d, must not be negative", opts.maxPending))
+	}
+	if opts.eventBusBuffer < 1 {
+		cmd.ExitOnInvalidArgument(errors.Errorf("invalid -event-bus-buffer %d, must be at least 1", opts.eventBusBuffer))
+	}
+
+	err = jobs.ValidateRetries(corev1.RestartPolicy(opts.restartPolicy), int32(opts.backoffLimit))
+	cmd.ExitOnInvalidArgument(err)
+
+	if jobProfilesFile != "" {
+		opts.jobProfiles, err = watcher.LoadJobProfiles(jobProfilesFile)
+		cmd.ExitOnInvalidArgument(err)
+	}
+	if resourceTiersFile != "" {
+		opts.resourceTiers, err = watcher.LoadResourceTiers(resourceTiersFile)
+		cmd.ExitOnInvalidArgument(err)
+	}
+	for _, profile := range opts.presetRules.Profiles() {
+		if _, ok := opts.jobProfiles.Named(profile); !ok {
+			cmd.ExitOnInvalidArgument(errors.Errorf("invalid -preset-rule, profile %s is not defined in -job-profiles", profile))
+		}
+	}
+
+	opts.plexCfg.Token, err = cmd.LookupSecret(opts.plexCfg.Token, plexTokenFile)
+	cmd.ExitOnRuntimeError(err)
+
+	opts.admin.Token, err = cmd.LookupSecret(opts.admin.Token, adminTokenFile)
+	cmd.ExitOnRuntimeError(err)
 
-	plexCfg.Share = plexVolume
+	opts.notifyWebhook, err = cmd.LookupSecret(opts.notifyWebhook, notifyWebhookFile)
+	cmd.ExitOnRuntimeError(err)
+
+	opts.eventBus, err = cmd.LookupSecret(opts.eventBus, eventBusFile)
+	cmd.ExitOnRuntimeError(err)
+	if opts.encodeAlertAfter > 0 || opts.encodeStallTimeout > 0 {
+		cmd.ExitOnMissingFlag(opts.notifyWebhook, "-notify-webhook or -notify-webhook-file")
+	}
+
+	if stateKeyFile != "" {
+		opts.stateKey, err = cmd.ReadSecretFile(stateKeyFile)
+		cmd.ExitOnRuntimeError(err)
+	}
+
+	cmd.ExitOnMissingFlag(opts.plexCfg.URL, "-plex-server")
+	cmd.ExitOnMissingFlag(opts.plexCfg.Token, "-plex-token or -plex-token-file")
+	opts.plexCfg.HTTP.Backoff = httpclient.DefaultPolicy.Backoff
+	if err := opts.plexCfg.HTTP.Validate(); err != nil {
+		cmd.ExitOnInvalidArgument(errors.Wrap(err, "invalid -plex-timeout or -plex-retries"))
+	}
+	opts.notifyHTTP.Backoff = httpclient.DefaultPolicy.Backoff
+	if err := opts.notifyHTTP.Validate(); err != nil {
+		cmd.ExitOnInvalidArgument(errors.Wrap(err, "invalid -notify-timeout or -notify-retries"))
+	}
+
+	if opts.s3Cfg.Bucket != "" {
+		opts.s3Cfg.SecretKey, err = cmd.LookupSecret(opts.s3Cfg.SecretKey, s3SecretKeyFile)
+		cmd.ExitOnRuntimeError(err)
+		cmd.ExitOnMissingFlag(opts.s3Cfg.AccessKey, "-s3-access-key")
+		cmd.ExitOnMissingFlag(opts.s3Cfg.SecretKey, "-s3-secret-key or -s3-secret-key-file")
+		if opts.s3PollInterval <= 0 {
+			cmd.ExitOnInvalidArgument(errors.Errorf("invalid -s3-poll-interval %s, must be positive", opts.s3PollInterval))
+		}
+		if opts.s3ResyncInterval <= 0 {
+			cmd.ExitOnInvalidArgument(errors.Errorf("invalid -s3-resync-interval %s, must be positive", opts.s3ResyncInterval))
+		}
+	}
+
+	if opts.outputBucket.IsSet() {
+		opts.outputBucket.SecretKey, err = cmd.LookupSecret(opts.outputBucket.SecretKey, outputSecretKeyFile)
+		cmd.ExitOnRuntimeError(err)
+		// The upload jobs can read the keys from the secret instead
+		if opts.mode == localMode || opts.outputBucket.Secret == "" {
+			cmd.ExitOnMissingFlag(opts.outputBucket.AccessKey, "-output-s3-access-key or -output-s3-secret")
+			cmd.ExitOnMissingFlag(opts.outputBucket.SecretKey, "-output-s3-secret-key, -output-s3-secret-key-file or -output-s3-secret")
+		}
+	}
+
+	if opts.mode == kubernetesMode {
+		opts.plexCfg.Share = plexVolume
+	}
 
-	return plexCfg
+	return opts
 }
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+)
+
+// selfTest transcodes a synthetic video with the configured sink, printing each step and
+// how long it took, and returns if every step passed. Nothing is uploaded to Plex.
+func selfTest(w io.Writer, opts options) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.selfTestTimeout)
+	defer cancel()
+
+	var report watcher.SelfTestReport
+	if opts.mode == localMode {
+		report = newLocalSink(opts).SelfTest(ctx)
+	} else {
+		report = newJobSink(opts).SelfTest(ctx)
+	}
+
+	for _, step := range report.Steps {
+		if step.Err != nil {
+			fmt.Fprintf(w, "FAIL %s (%s): %s\n", step.Name, step.Duration, step.Err)
+		} else {
+			fmt.Fprintf(w, "ok   %s (%s)\n", step.Name, step.Duration)
+		}
+	}
+	if !report.Passed() {
+		fmt.Fprintln(w, "the self-test failed")
+		return false
+	}
+	fmt.Fprintln(w, "the self-test passed")
+	return true
+}
@@ -0,0 +1,43 @@
+// Package bus publishes messages to a message bus, such as NATS or Kafka, for other services to consume.
+package bus
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Publisher sends messages to a subject, or topic, of a message bus.
+type Publisher interface {
+	// Publish sends the message, returning an error when the bus did not accept it.
+	Publish(subject string, message []byte) error
+
+	// Close disconnects from the bus.
+	Close() error
+}
+
+// New creates a publisher for the bus at the URL, selected by its scheme:
+// nats://[USER:PASSWORD@]HOST:PORT for NATS, or kafka+http(s)://HOST:PORT for
+// the REST proxy of a Kafka cluster.
+func New(rawURL string) (Publisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid message bus url %q", rawURL)
+	}
+	switch {
+	case u.Scheme == "nats":
+		if u.Host == "" {
+			return nil, errors.Errorf("invalid message bus url %q, missing the host", rawURL)
+		}
+		return NewNATS(u), nil
+	case strings.HasPrefix(u.Scheme, "kafka+"):
+		u.Scheme = strings.TrimPrefix(u.Scheme, "kafka+")
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, errors.Errorf("invalid message bus url %q, the kafka rest proxy must use kafka+http or kafka+https", rawURL)
+		}
+		return NewKafkaREST(u.String()), nil
+	default:
+		return nil, errors.Errorf("unsupported message bus url %q, must be nats:// or kafka+http(s)://", rawURL)
+	}
+}
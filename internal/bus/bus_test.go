@@ -0,0 +1,44 @@
+package bus
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	testcases := []struct {
+		Name, URL string
+		WantNATS  bool
+		WantKafka string
+		WantErr   bool
+	}{
+		{Name: "nats", URL: "nats://nats:4222", WantNATS: true},
+		{Name: "kafka", URL: "kafka+http://kafka-rest:8082/", WantKafka: "http://kafka-rest:8082"},
+		{Name: "kafka tls", URL: "kafka+https://kafka-rest:8082", WantKafka: "https://kafka-rest:8082"},
+		{Name: "nats without host", URL: "nats://", WantErr: true},
+		{Name: "kafka without http", URL: "kafka+tcp://kafka:9092", WantErr: true},
+		{Name: "unsupported", URL: "amqp://rabbit:5672", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			p, err := New(tc.URL)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatalf("expected %s to be rejected", tc.URL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			switch p := p.(type) {
+			case *NATS:
+				if !tc.WantNATS {
+					t.Fatalf("expected a kafka publisher, got %#v", p)
+				}
+			case *KafkaREST:
+				if p.URL != tc.WantKafka {
+					t.Fatalf("expected the proxy at %s, got %s", tc.WantKafka, p.URL)
+				}
+			}
+		})
+	}
+}
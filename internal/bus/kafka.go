@@ -0,0 +1,88 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// kafkaJSONContentType posts the records of a message as JSON to the v2 api of the REST proxy.
+const kafkaJSONContentType = "application/vnd.kafka.json.v2+json"
+
+type kafkaRecord struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// kafkaOffsets is the response of the REST proxy, with an error for each record that wasn't written.
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// KafkaREST publishes messages to the topics of a Kafka cluster through its REST proxy,
+// such as the Confluent REST Proxy, so that no Kafka client library is needed. Each
+// message must be JSON, and is the value of a single record.
+type KafkaREST struct {
+	URL  string
+	http *http.Client
+}
+
+// NewKafkaREST creates a publisher for the REST proxy at the url.
+func NewKafkaREST(proxyURL string) *KafkaREST {
+	return &KafkaREST{
+		URL:  strings.TrimSuffix(proxyURL, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Publish posts the message to the topic.
+func (k *KafkaREST) Publish(topic string, message []byte) error {
+	if !json.Valid(message) {
+		return errors.Errorf("unable to publish to the kafka topic %s, the message is not JSON", topic)
+	}
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Value: message}}})
+	if err != nil {
+		return errors.Wrapf(err, "unable to serialize the message for the kafka topic %s", topic)
+	}
+
+	resp, err := k.http.Post(k.URL+"/topics/"+url.PathEscape(topic), kafkaJSONContentType, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "unable to publish to the kafka topic %s", topic)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("unable to publish to the kafka topic %s, the proxy returned %s: %s",
+			topic, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	// The proxy accepts the request even when it couldn't write the record
+	var offsets kafkaOffsets
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&offsets); err == nil {
+		for _, o := range offsets.Offsets {
+			if o.ErrorCode != nil || o.Error != "" {
+				return errors.Errorf("unable to publish to the kafka topic %s: %s", topic, o.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// Close does nothing, each message is posted with its own request.
+func (k *KafkaREST) Close() error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package bus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKafkaREST_Publish(t *testing.T) {
+	var gotPath, gotType, gotBody string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		gotPath, gotType, gotBody = r.URL.Path, r.Header.Get("Content-Type"), string(body)
+		if strings.Contains(gotBody, "too-large") {
+			w.Write([]byte(`{"offsets":[{"partition":null,"offset":null,"error_code":50002,"error":"record too large"}]}`))
+			return
+		}
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":12}]}`))
+	}))
+	defer proxy.Close()
+
+	k := NewKafkaREST(proxy.URL + "/")
+	err := k.Publish("handbrk8s.events", []byte(`{"type":"detected"}`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if gotPath != "/topics/handbrk8s.events" || gotType != kafkaJSONContentType {
+		t.Fatalf("expected a post of JSON records to the topic, got %s %s", gotType, gotPath)
+	}
+	var records kafkaRecords
+	if err := json.Unmarshal([]byte(gotBody), &records); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if len(records.Records) != 1 || string(records.Records[0].Value) != `{"type":"detected"}` {
+		t.Fatalf("expected the message to be the value of a record, got %s", gotBody)
+	}
+
+	err = k.Publish("handbrk8s.events", []byte(`{"type":"too-large"}`))
+	if err == nil || !strings.Contains(err.Error(), "record too large") {
+		t.Fatalf("expected the record error from the proxy, got %v", err)
+	}
+
+	err = k.Publish("handbrk8s.events", []byte("not json"))
+	if err == nil {
+		t.Fatal("expected a message that isn't JSON to be rejected")
+	}
+}
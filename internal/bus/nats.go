@@ -0,0 +1,157 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// natsTimeout is how long to wait for the NATS server to respond.
+const natsTimeout = 10 * time.Second
+
+// NATS publishes messages to a NATS server with its text protocol. Each message
+// is followed by a PING, so that it is only reported as published once the server
+// has processed it. The connection is made when the first message is published,
+// and made again after it fails.
+type NATS struct {
+	addr                  string
+	user, password, token string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// NewNATS creates a publisher for the server at the url. The user info of the url,
+// when set, is either a user and password, or only a token.
+func NewNATS(u *url.URL) *NATS {
+	n := &NATS{addr: u.Host}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			n.user, n.password = u.User.Username(), password
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	return n
+}
+
+// Publish sends the message to the subject.
+func (n *NATS) Publish(subject string, message []byte) error {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return errors.Errorf("invalid NATS subject %q", subject)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	err := n.publish(subject, message)
+	if err != nil {
+		n.closeLocked()
+		return errors.Wrapf(err, "unable to publish to %s on the NATS server %s", subject, n.addr)
+	}
+	return nil
+}
+
+func (n *NATS) publish(subject string, message []byte) error {
+	if n.conn == nil {
+		err := n.connect()
+		if err != nil {
+			return err
+		}
+	}
+
+	n.conn.SetDeadline(time.Now().Add(natsTimeout))
+	_, err := fmt.Fprintf(n.conn, "PUB %s %d\r\n%s\r\nPING\r\n", subject, len(message), message)
+	if err != nil {
+		return err
+	}
+	return n.waitForPong()
+}
+
+// connect to the server, reading its INFO and sending the CONNECT options.
+func (n *NATS) connect() error {
+	conn, err := net.DialTimeout("tcp", n.addr, natsTimeout)
+	if err != nil {
+		return err
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	n.conn.SetDeadline(time.Now().Add(natsTimeout))
+
+	line, err := n.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return errors.Errorf("unexpected greeting %q, is it a NATS server?", line)
+	}
+
+	options, err := json.Marshal(struct {
+		Verbose  bool   `json:"verbose"`
+		Pedantic bool   `json:"pedantic"`
+		Name     string `json:"name"`
+		User     string `json:"user,omitempty"`
+		Password string `json:"pass,omitempty"`
+		Token    string `json:"auth_token,omitempty"`
+	}{Name: "handbrk8s", User: n.user, Password: n.password, Token: n.token})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(n.conn, "CONNECT %s\r\nPING\r\n", options)
+	if err != nil {
+		return err
+	}
+	return n.waitForPong()
+}
+
+// waitForPong reads until the server responds to a PING, answering the server's
+// own PINGs, and returning the first error sent by the server.
+func (n *NATS) waitForPong() error {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.Errorf("the server returned %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func (n *NATS) readLine() (string, error) {
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Close disconnects from the server.
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.closeLocked()
+}
+
+func (n *NATS) closeLocked() error {
+	if n.conn == nil {
+		return nil
+	}
+	err := n.conn.Close()
+	n.conn, n.r = nil, nil
+	return err
+}
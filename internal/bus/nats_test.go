@@ -0,0 +1,133 @@
+package bus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeNATS accepts connections, and signals the options and message of each PUB.
+// When reject is set, every PUB is answered with an error.
+type fakeNATS struct {
+	l        net.Listener
+	connects chan string
+	messages chan string
+	reject   bool
+}
+
+func newFakeNATS(t *testing.T, reject bool) *fakeNATS {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	s := &fakeNATS{l: l, reject: reject, connects: make(chan string, 10), messages: make(chan string, 10)}
+	go s.serve()
+	return s
+}
+
+func (s *fakeNATS) serve() {
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeNATS) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"fake\"}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "CONNECT":
+			s.connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+		case fields[0] == "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case fields[0] == "PUB" && len(fields) == 3:
+			n, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, n+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			if s.reject {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish'\r\n")
+				continue
+			}
+			// Check that the publisher answers the server's pings while it waits
+			fmt.Fprint(conn, "PING\r\n")
+			s.messages <- fields[1] + " " + string(payload[:n])
+		}
+	}
+}
+
+func TestNATS_Publish(t *testing.T) {
+	server := newFakeNATS(t, false)
+	defer server.l.Close()
+
+	u, _ := url.Parse("nats://abc123@" + server.l.Addr().String())
+	n := NewNATS(u)
+	defer n.Close()
+
+	for _, message := range []string{`{"type":"detected"}`, `{"type":"failed"}`} {
+		err := n.Publish("handbrk8s.events", []byte(message))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if got := <-server.messages; got != "handbrk8s.events "+message {
+			t.Fatalf("expected the message to be published to the subject, got %q", got)
+		}
+	}
+	if got := <-server.connects; !strings.Contains(got, `"auth_token":"abc123"`) {
+		t.Fatalf("expected the token to be sent when connecting, got %s", got)
+	}
+	if len(server.connects) != 0 {
+		t.Fatal("expected the connection to be reused")
+	}
+
+	err := n.Publish("handbrk8s events", []byte("{}"))
+	if err == nil {
+		t.Fatal("expected a subject with spaces to be rejected")
+	}
+}
+
+func TestNATS_PublishRejected(t *testing.T) {
+	server := newFakeNATS(t, true)
+	defer server.l.Close()
+
+	u, _ := url.Parse("nats://" + server.l.Addr().String())
+	n := NewNATS(u)
+	defer n.Close()
+
+	err := n.Publish("handbrk8s.events", []byte("{}"))
+	if err == nil || !strings.Contains(err.Error(), "Permissions Violation") {
+		t.Fatalf("expected the error from the server, got %v", err)
+	}
+}
+
+func TestNATS_PublishUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	u, _ := url.Parse("nats://" + addr)
+	err = NewNATS(u).Publish("handbrk8s.events", []byte("{}"))
+	if err == nil {
+		t.Fatal("expected an error when the server is unavailable")
+	}
+}
@@ -0,0 +1,318 @@
+package dashboard
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+)
+
+// Pipeline is the part of the watcher controlled by the admin api.
+type Pipeline interface {
+	Pause()
+	Resume()
+	Status() watcher.Status
+	Rescan(pathRegex string) (int, error)
+	Release(path string) (bool, error)
+	Queue() []watcher.QueuedVideo
+	Dequeue(id string) bool
+	Prioritize(id string, position int) bool
+	Latency() watcher.Latency
+	Load() watcher.Load
+	EventBus() watcher.EventBusStats
+	History(q watcher.HistoryQuery) (watcher.HistoryResult, error)
+}
+
+// AdminConfig of the watcher's admin http server.
+type AdminConfig struct {
+	// Addr is the address to listen on, e.g. :8080
+	Addr string
+
+	// Token is required on every request except health checks, when set.
+	Token string
+}
+
+// ServeAdmin serves the admin api, to pause and resume the pipeline.
+func ServeAdmin(cfg AdminConfig, p Pipeline) error {
+	return http.ListenAndServe(cfg.Addr, adminRoutes(p, cfg.Token))
+}
+
+// adminRoutes to the admin handlers. Every route except the health and readiness checks requires the token, when set.
+func adminRoutes(p Pipeline, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/status", requireToken(token, handleStatus(p)))
+	mux.Handle("/pause", requireToken(token, handlePause(p)))
+	mux.Handle("/resume", requireToken(token, handleResume(p)))
+	mux.Handle("/rescan", requireToken(token, handleRescan(p)))
+	mux.Handle("/release", requireToken(token, handleRelease(p)))
+	mux.Handle("/queue", requireToken(token, handleQueue(p)))
+	mux.Handle("/queue/", requireToken(token, handleQueuedVideo(p)))
+	mux.Handle("/metrics", requireToken(token, handleMetrics(p)))
+	mux.Handle("/history", requireToken(token, handleHistory(p)))
+	mux.HandleFunc("/healthz", handleHealth)
+	mux.Handle("/readyz", handleReady(p))
+	return mux
+}
+
+// handleStatus reports if the pipeline is paused.
+// GET /status
+func handleStatus(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.Status())
+	})
+}
+
+// handlePause stops handing new videos to the sinks, returning the status.
+// POST /pause
+func handlePause(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		p.Pause()
+		writeJSON(w, p.Status())
+	})
+}
+
+// handleResume processes the queued videos, returning the status.
+// POST /resume
+func handleResume(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		p.Resume()
+		writeJSON(w, p.Status())
+	})
+}
+
+// rescanResult reports how many videos were found by a rescan.
+type rescanResult struct {
+	Found int `json:"found"`
+}
+
+// handleRescan changes the path regex, and checks the videos already in the watch
+// directory against it. An empty pathRegex watches every video.
+// POST /rescan?pathRegex=REGEX
+func handleRescan(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		found, err := p.Rescan(req.URL.Query().Get("pathRegex"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, rescanResult{Found: found})
+	})
+}
+
+// handleReady reports if the pipeline has handled the backlog of videos found on startup,
+// see Status.BacklogDrained, so that a readiness probe passes once it is caught up.
+// GET /readyz
+func handleReady(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !p.Status().BacklogDrained {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("draining the backlog"))
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+// handleRelease checks a file that was held for manual review again, see Status.Held,
+// returning the status. A file that isn't held is not found.
+// POST /release?path=PATH
+func handleRelease(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		path := req.URL.Query().Get("path")
+		released, err := p.Release(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !released {
+			http.Error(w, fmt.Sprintf("%s is not held", path), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, p.Status())
+	})
+}
+
+// handleQueue lists the videos queued while the pipeline is paused, or outside of its schedule.
+// GET /queue
+func handleQueue(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, p.Queue())
+	})
+}
+
+// handleQueuedVideo removes a video from the queue, or moves it to a position in the queue,
+// where 0 is the front, returning the queue.
+// DELETE /queue/{id}
+// POST /queue/{id}?position=N
+func handleQueuedVideo(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := strings.TrimPrefix(req.URL.Path, "/queue/")
+		if id == "" || strings.Contains(id, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var found bool
+		switch req.Method {
+		case http.MethodDelete:
+			found = p.Dequeue(id)
+		case http.MethodPost:
+			position, err := strconv.Atoi(req.URL.Query().Get("position"))
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid position %q", req.URL.Query().Get("position")), http.StatusBadRequest)
+				return
+			}
+			found = p.Prioritize(id, position)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !found {
+			http.Error(w, fmt.Sprintf("%s is not queued", id), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, p.Queue())
+	})
+}
+
+// handleHistory selects the videos in the encode history, and summarizes how long they took
+// and how much space the transcodes saved. Since and until are either a time, in RFC 3339,
+// or a duration before now, such as 168h.
+// GET /history?status=completed|failed&since=TIME&until=TIME&path=PATTERN&sort=newest|slowest|savings&limit=N
+func handleHistory(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, err := parseHistoryQuery(req.URL.Query(), time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result, err := p.History(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, result)
+	})
+}
+
+// parseHistoryQuery reads the query of GET /history.
+func parseHistoryQuery(values url.Values, now time.Time) (watcher.HistoryQuery, error) {
+	q := watcher.HistoryQuery{
+		Status: values.Get("status"),
+		Path:   values.Get("path"),
+		Sort:   values.Get("sort"),
+	}
+	var err error
+	if q.Since, err = parseHistoryTime(values.Get("since"), now); err != nil {
+		return q, err
+	}
+	if q.Until, err = parseHistoryTime(values.Get("until"), now); err != nil {
+		return q, err
+	}
+	if limit := values.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return q, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	return q, nil
+}
+
+// parseHistoryTime reads a time, in RFC 3339, or a duration before now. Empty is the zero time.
+func parseHistoryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, must be RFC 3339 or a duration such as 168h", value)
+	}
+	return t, nil
+}
+
+// handleMetrics reports how long videos wait to be submitted, how much work is in the
+// pipeline and how long it is estimated to take, and how many events were sent to the message bus, in the Prometheus text format, so that an alert can be set on the latency of
+// the pipeline, or the cluster scaled with its load.
+// GET /metrics
+func handleMetrics(p Pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		l := p.Latency()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP handbrk8s_oldest_waiting_video_seconds How long the oldest stable video has waited to be submitted.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_oldest_waiting_video_seconds gauge")
+		fmt.Fprintf(w, "handbrk8s_oldest_waiting_video_seconds %g\n", l.OldestWaiting.Seconds())
+		fmt.Fprintln(w, "# HELP handbrk8s_waiting_videos Number of stable videos that haven't been submitted.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_waiting_videos gauge")
+		fmt.Fprintf(w, "handbrk8s_waiting_videos %d\n", l.Waiting)
+		fmt.Fprintln(w, "# HELP handbrk8s_submit_latency_seconds Time from when a video was found until it was submitted.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_submit_latency_seconds summary")
+		fmt.Fprintf(w, "handbrk8s_submit_latency_seconds_sum %g\n", l.SubmitSeconds)
+		fmt.Fprintf(w, "handbrk8s_submit_latency_seconds_count %d\n", l.Submitted)
+
+		load := p.Load()
+		fmt.Fprintln(w, "# HELP handbrk8s_stabilizing_files Number of files that are waiting to stop changing.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_stabilizing_files gauge")
+		fmt.Fprintf(w, "handbrk8s_stabilizing_files %d\n", load.Stabilizing)
+		fmt.Fprintln(w, "# HELP handbrk8s_queued_videos Number of stable videos held while paused or outside of the processing windows.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_queued_videos gauge")
+		fmt.Fprintf(w, "handbrk8s_queued_videos %d\n", load.Queued)
+		fmt.Fprintln(w, "# HELP handbrk8s_handling_videos Number of stable videos that the sinks haven't finished with, such as videos waiting for a local transcode.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_handling_videos gauge")
+		fmt.Fprintf(w, "handbrk8s_handling_videos %d\n", load.Handling)
+		fmt.Fprintln(w, "# HELP handbrk8s_in_flight_jobs Number of jobs, or transcodes on the current host, that haven't finished.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_in_flight_jobs gauge")
+		fmt.Fprintf(w, "handbrk8s_in_flight_jobs %d\n", load.InFlight)
+		fmt.Fprintln(w, "# HELP handbrk8s_queue_eta_seconds Estimated time to encode the stable videos that haven't been submitted, from the encode history.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_queue_eta_seconds gauge")
+		fmt.Fprintf(w, "handbrk8s_queue_eta_seconds %g\n", load.QueueETA.Seconds())
+
+		events := p.EventBus()
+		fmt.Fprintln(w, "# HELP handbrk8s_bus_events_published_total Number of pipeline events accepted by the message bus.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_bus_events_published_total counter")
+		fmt.Fprintf(w, "handbrk8s_bus_events_published_total %d\n", events.Published)
+		fmt.Fprintln(w, "# HELP handbrk8s_bus_events_dropped_total Number of pipeline events dropped because too many were pending for the message bus.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_bus_events_dropped_total counter")
+		fmt.Fprintf(w, "handbrk8s_bus_events_dropped_total %d\n", events.Dropped)
+		fmt.Fprintln(w, "# HELP handbrk8s_bus_events_failed_total Number of pipeline events that the message bus did not accept.")
+		fmt.Fprintln(w, "# TYPE handbrk8s_bus_events_failed_total counter")
+		fmt.Fprintf(w, "handbrk8s_bus_events_failed_total %d\n", events.Failed)
+	})
+}
@@ -0,0 +1,314 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	"github.com/pkg/errors"
+)
+
+type fakePipeline struct {
+	status  watcher.Status
+	queue   []watcher.QueuedVideo
+	history watcher.HistoryQuery
+}
+
+func (p *fakePipeline) Pause()                 { p.status.Paused = true }
+func (p *fakePipeline) Resume()                { p.status.Paused = false }
+func (p *fakePipeline) Status() watcher.Status { return p.status }
+func (p *fakePipeline) Latency() watcher.Latency {
+	return watcher.Latency{OldestWaiting: 90 * time.Second, Waiting: 2, SubmitSeconds: 12.5, Submitted: 3}
+}
+func (p *fakePipeline) Load() watcher.Load {
+	return watcher.Load{Stabilizing: 4, Queued: 1, Handling: 2, InFlight: 6, QueueETA: 90 * time.Second}
+}
+func (p *fakePipeline) EventBus() watcher.EventBusStats {
+	return watcher.EventBusStats{Published: 10, Dropped: 1, Failed: 2}
+}
+func (p *fakePipeline) History(q watcher.HistoryQuery) (watcher.HistoryResult, error) {
+	p.history = q
+	return watcher.HistoryResult{Summary: watcher.HistorySummary{Videos: 2, Failed: 1}}, nil
+}
+func (p *fakePipeline) Rescan(pathRegex string) (int, error) {
+	if pathRegex == "(" {
+		return 0, errors.New("invalid path regex")
+	}
+	return 2, nil
+}
+
+func (p *fakePipeline) Release(path string) (bool, error) {
+	for i, held := range p.status.Held {
+		if held == path {
+			p.status.Held = append(p.status.Held[:i], p.status.Held[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (p *fakePipeline) Queue() []watcher.QueuedVideo { return p.queue }
+func (p *fakePipeline) Dequeue(id string) bool {
+	for i, v := range p.queue {
+		if v.ID == id {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+func (p *fakePipeline) Prioritize(id string, position int) bool {
+	for i, v := range p.queue {
+		if v.ID == id {
+			p.queue = append(p.queue[:i], p.queue[i+1:]...)
+			p.queue = append([]watcher.QueuedVideo{v}, p.queue...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestAdminRoutes(t *testing.T) {
+	p := &fakePipeline{}
+	handler := adminRoutes(p, "abc123")
+
+	testcases := []struct {
+		Name       string
+		Method     string
+		Path       string
+		WantStatus int
+		WantPaused bool
+	}{
+		{Name: "pause", Method: http.MethodPost, Path: "/pause", WantStatus: http.StatusOK, WantPaused: true},
+		{Name: "status", Method: http.MethodGet, Path: "/status", WantStatus: http.StatusOK, WantPaused: true},
+		{Name: "pause with GET", Method: http.MethodGet, Path: "/pause", WantStatus: http.StatusMethodNotAllowed, WantPaused: true},
+		{Name: "resume", Method: http.MethodPost, Path: "/resume", WantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.Method, tc.Path, nil)
+			req.Header.Set("Authorization", "Bearer abc123")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.WantStatus {
+				t.Fatalf("expected status %d, got %d", tc.WantStatus, w.Code)
+			}
+			if p.status.Paused != tc.WantPaused {
+				t.Fatalf("expected paused to be %t", tc.WantPaused)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var got watcher.Status
+			err := json.NewDecoder(w.Body).Decode(&got)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if got.Paused != tc.WantPaused {
+				t.Fatalf("expected the status to report paused %t, got %#v", tc.WantPaused, got)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/pause", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected pausing without the token to be unauthorized, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutes_Rescan(t *testing.T) {
+	handler := adminRoutes(&fakePipeline{}, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/rescan?pathRegex=.*%5C.mkv", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var got rescanResult
+	err := json.NewDecoder(w.Body).Decode(&got)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if got.Found != 2 {
+		t.Fatalf("expected the rescan to report 2 videos found, got %#v", got)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/rescan?pathRegex=(", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid path regex to be a bad request, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutes_Release(t *testing.T) {
+	p := &fakePipeline{status: watcher.Status{Held: []string{"/watch/Movies/foo.mkv"}}}
+	handler := adminRoutes(p, "")
+
+	req := httptest.NewRequest(http.MethodPost, "/release?path=%2Fwatch%2FMovies%2Ffoo.mkv", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if len(p.status.Held) != 0 {
+		t.Fatalf("expected foo to be released, got %v", p.status.Held)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected a file that isn't held to be not found, got %d", w.Code)
+	}
+}
+
+func TestAdminRoutes_Queue(t *testing.T) {
+	p := &fakePipeline{queue: []watcher.QueuedVideo{{ID: "aaaa", Path: "/watch/foo.mkv"}, {ID: "bbbb", Path: "/watch/bar.mkv"}, {ID: "cccc", Path: "/watch/baz.mkv"}}}
+	handler := adminRoutes(p, "")
+
+	testcases := []struct {
+		Name       string
+		Method     string
+		Path       string
+		WantStatus int
+		WantQueue  string
+	}{
+		{Name: "list", Method: http.MethodGet, Path: "/queue", WantStatus: http.StatusOK, WantQueue: "aaaa,bbbb,cccc"},
+		{Name: "prioritize", Method: http.MethodPost, Path: "/queue/cccc?position=0", WantStatus: http.StatusOK, WantQueue: "cccc,aaaa,bbbb"},
+		{Name: "invalid position", Method: http.MethodPost, Path: "/queue/cccc?position=first", WantStatus: http.StatusBadRequest, WantQueue: "cccc,aaaa,bbbb"},
+		{Name: "dequeue", Method: http.MethodDelete, Path: "/queue/aaaa", WantStatus: http.StatusOK, WantQueue: "cccc,bbbb"},
+		{Name: "not queued", Method: http.MethodDelete, Path: "/queue/aaaa", WantStatus: http.StatusNotFound, WantQueue: "cccc,bbbb"},
+		{Name: "list with DELETE", Method: http.MethodDelete, Path: "/queue", WantStatus: http.StatusMethodNotAllowed, WantQueue: "cccc,bbbb"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.Method, tc.Path, nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.WantStatus {
+				t.Fatalf("expected status %d, got %d", tc.WantStatus, w.Code)
+			}
+			var ids []string
+			for _, v := range p.queue {
+				ids = append(ids, v.ID)
+			}
+			if got := strings.Join(ids, ","); got != tc.WantQueue {
+				t.Fatalf("expected the queue %s, got %s", tc.WantQueue, got)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var got []watcher.QueuedVideo
+			err := json.NewDecoder(w.Body).Decode(&got)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if len(got) != len(p.queue) {
+				t.Fatalf("expected the queue to be returned, got %#v", got)
+			}
+		})
+	}
+}
+
+func TestAdminRoutes_Ready(t *testing.T) {
+	p := &fakePipeline{}
+	handler := adminRoutes(p, "abc123")
+
+	for _, drained := range []bool{false, true} {
+		p.status.BacklogDrained = drained
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		want := http.StatusServiceUnavailable
+		if drained {
+			want = http.StatusOK
+		}
+		if w.Code != want {
+			t.Fatalf("expected status %d when the backlog drained is %t, got %d", want, drained, w.Code)
+		}
+	}
+}
+
+func TestAdminRoutes_Metrics(t *testing.T) {
+	handler := adminRoutes(&fakePipeline{}, "abc123")
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer abc123")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		"handbrk8s_oldest_waiting_video_seconds 90\n",
+		"handbrk8s_waiting_videos 2\n",
+		"handbrk8s_submit_latency_seconds_sum 12.5\n",
+		"handbrk8s_submit_latency_seconds_count 3\n",
+		"handbrk8s_stabilizing_files 4\n",
+		"handbrk8s_queued_videos 1\n",
+		"handbrk8s_handling_videos 2\n",
+		"handbrk8s_in_flight_jobs 6\n",
+		"handbrk8s_queue_eta_seconds 90\n",
+		"handbrk8s_bus_events_published_total 10\n",
+		"handbrk8s_bus_events_dropped_total 1\n",
+		"handbrk8s_bus_events_failed_total 2\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected the metrics to include %q, got\n%s", want, body)
+		}
+	}
+}
+
+func TestAdminRoutes_History(t *testing.T) {
+	p := &fakePipeline{}
+	handler := adminRoutes(p, "")
+
+	req := httptest.NewRequest(http.MethodGet, "/history?status=failed&since=2019-01-01T00:00:00Z&path=%2Fwatch%2FTV%2F*&sort=slowest&limit=5", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	want := watcher.HistoryQuery{Status: "failed", Since: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), Path: "/watch/TV/*", Sort: "slowest", Limit: 5}
+	if !p.history.Since.Equal(want.Since) || p.history.Status != want.Status || p.history.Path != want.Path ||
+		p.history.Sort != want.Sort || p.history.Limit != want.Limit || !p.history.Until.IsZero() {
+		t.Fatalf("expected the query %#v, got %#v", want, p.history)
+	}
+	var result watcher.HistoryResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if result.Summary.Videos != 2 || result.Summary.Failed != 1 {
+		t.Fatalf("expected the summary of the history, got %#v", result.Summary)
+	}
+
+	// A duration is before now
+	req = httptest.NewRequest(http.MethodGet, "/history?since=1h", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if ago := time.Since(p.history.Since); w.Code != http.StatusOK || ago < time.Hour || ago > time.Hour+time.Minute {
+		t.Fatalf("expected since to be an hour ago, got %s with status %d", p.history.Since, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/history?until=yesterday", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid time to be rejected, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// JobStatus is the representation of a job returned by the api.
+type JobStatus struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+}
+
+// CancelResult lists the jobs that were deleted when a job is cancelled.
+type CancelResult struct {
+	Cancelled []string `json:"cancelled"`
+}
+
+// handleJobs lists the jobs.
+// GET /jobs
+func (s server) handleJobs(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := s.listJobs()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := make([]JobStatus, len(data.Jobs))
+	for i, j := range data.Jobs {
+		result[i] = JobStatus{Name: j.Name, Status: j.StatusDescription(), Duration: j.Duration()}
+	}
+	writeJSON(w, result)
+}
+
+// handleJob cancels a job, or streams its progress. The video of a cancelled job is moved
+// to the failed directory, or with video=requeue, back to the watch directory.
+// DELETE /jobs/{name}?video=requeue|drop
+// GET /jobs/{name}/progress
+func (s server) handleJob(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/jobs/")
+	if n := strings.TrimSuffix(name, "/progress"); n != name && n != "" && !strings.Contains(n, "/") {
+		s.handleJobProgress(w, req, n)
+		return
+	}
+	if name == "" || strings.Contains(name, "/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if req.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	choice := req.URL.Query().Get("video")
+	if choice == "" {
+		choice = jobs.CancelDrop
+	}
+	if err := jobs.ValidateCancel(choice); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.cancelJob(name, choice)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// cancelJob deletes a job and its pods, along with any jobs waiting for it
+// to complete, such as the upload job for a transcode job. The watcher then
+// requeues or drops the video, as chosen, and records it in the history.
+func (s server) cancelJob(name, choice string) (CancelResult, error) {
+	cancelled, err := jobs.Cancel(s.client, watcher.Namespace, name, choice)
+	return CancelResult{Cancelled: cancelled}, err
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	log.Println(err)
+	if apierrors.IsNotFound(errors.Cause(err)) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
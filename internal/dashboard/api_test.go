@@ -0,0 +1,17 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleJob_InvalidCancelChoice(t *testing.T) {
+	s := server{}
+	req := httptest.NewRequest(http.MethodDelete, "/jobs/foo-mkv-transcode?video=archive", nil)
+	w := httptest.NewRecorder()
+	s.routes("").ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid choice for the video to be rejected, got %d", w.Code)
+	}
+}
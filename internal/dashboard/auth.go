@@ -0,0 +1,40 @@
+package dashboard
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// requireToken rejects requests that don't include the token, either as a bearer
+// token or as the password for basic auth, so that the dashboard works in a browser.
+// When the token is empty, every request is allowed.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !hasToken(req, token) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="handbrk8s"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// hasToken checks the request for the token, using a constant time comparison.
+func hasToken(req *http.Request, token string) bool {
+	var got string
+	if _, password, ok := req.BasicAuth(); ok {
+		got = password
+	} else {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return false
+		}
+		got = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
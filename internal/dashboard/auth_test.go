@@ -0,0 +1,44 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoutes_RequireToken(t *testing.T) {
+	s := server{}
+	handler := s.routes("abc123")
+
+	testcases := []struct {
+		Name       string
+		Path       string
+		Auth       func(req *http.Request)
+		WantStatus int
+	}{
+		{Name: "no token", Path: "/jobs/foo", WantStatus: http.StatusUnauthorized},
+		{Name: "wrong bearer token", Path: "/jobs/foo", WantStatus: http.StatusUnauthorized,
+			Auth: func(req *http.Request) { req.Header.Set("Authorization", "Bearer wrong") }},
+		{Name: "bearer token", Path: "/jobs/foo", WantStatus: http.StatusMethodNotAllowed,
+			Auth: func(req *http.Request) { req.Header.Set("Authorization", "Bearer abc123") }},
+		{Name: "basic auth", Path: "/jobs/foo", WantStatus: http.StatusMethodNotAllowed,
+			Auth: func(req *http.Request) { req.SetBasicAuth("admin", "abc123") }},
+		{Name: "health check", Path: "/healthz", WantStatus: http.StatusOK},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			// GET on a job isn't allowed, which is only reached after authenticating
+			req := httptest.NewRequest(http.MethodGet, tc.Path, nil)
+			if tc.Auth != nil {
+				tc.Auth(req)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tc.WantStatus {
+				t.Fatalf("expected status %d, got %d", tc.WantStatus, w.Code)
+			}
+		})
+	}
+}
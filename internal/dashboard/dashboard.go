@@ -14,7 +14,25 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func Serve() error {
+type server struct {
+	client    kubernetes.Interface
+	dashboard *template.Template
+}
+
+// Config of the dashboard http server.
+type Config struct {
+	// Addr is the address to listen on, e.g. :80
+	Addr string
+
+	// TLSCertFile and TLSKeyFile serve the dashboard over https, when both are set.
+	TLSCertFile, TLSKeyFile string
+
+	// Token is required on every request except health checks, when set.
+	Token string
+}
+
+// Serve the dashboard and the jobs api.
+func Serve(cfg Config) error {
 	var config *rest.Config
 	var err error
 
@@ -35,14 +53,40 @@ func Serve() error {
 		return err
 	}
 
-	jobs, err := client.BatchV1().Jobs(watcher.Namespace).List(metav1.ListOptions{})
+	t, err := template.New("dashboard").Parse(dashboardTemplate)
 	if err != nil {
 		return err
 	}
 
-	t, err := template.New("dashboard").Parse(dashboardTemplate)
+	s := server{client: client, dashboard: t}
+	handler := s.routes(cfg.Token)
+	if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+		return http.ListenAndServeTLS(cfg.Addr, cfg.TLSCertFile, cfg.TLSKeyFile, handler)
+	}
+	return http.ListenAndServe(cfg.Addr, handler)
+}
+
+// routes to the dashboard handlers. Every route except the health check requires the token, when set.
+func (s server) routes(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", requireToken(token, http.HandlerFunc(s.handleDashboard)))
+	mux.Handle("/jobs", requireToken(token, http.HandlerFunc(s.handleJobs)))
+	mux.Handle("/jobs/", requireToken(token, http.HandlerFunc(s.handleJob)))
+	mux.HandleFunc("/healthz", handleHealth)
+	return mux
+}
+
+// handleHealth reports that the dashboard is running.
+// GET /healthz
+func handleHealth(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// listJobs retrieves the current jobs.
+func (s server) listJobs() (Data, error) {
+	jobs, err := s.client.BatchV1().Jobs(watcher.Namespace).List(metav1.ListOptions{})
 	if err != nil {
-		return err
+		return Data{}, err
 	}
 
 	data := Data{
@@ -51,19 +95,23 @@ func Serve() error {
 	for i, j := range jobs.Items {
 		data.Jobs[i] = DisplayJob(j)
 	}
+	return data, nil
+}
 
-	helloHandler := func(w http.ResponseWriter, req *http.Request) {
-		b := &bytes.Buffer{}
-		err := t.Execute(b, data)
-		if err != nil {
-			fmt.Println(err)
-			w.WriteHeader(500)
-		} else {
-			w.Write(b.Bytes())
-		}
-
+func (s server) handleDashboard(w http.ResponseWriter, req *http.Request) {
+	data, err := s.listJobs()
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(500)
+		return
 	}
 
-	http.HandleFunc("/", helloHandler)
-	return http.ListenAndServe(":80", nil)
+	b := &bytes.Buffer{}
+	err = s.dashboard.Execute(b, data)
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(500)
+	} else {
+		w.Write(b.Bytes())
+	}
 }
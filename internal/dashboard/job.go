@@ -12,7 +12,15 @@ type Data struct {
 type DisplayJob v1.Job
 
 func (j DisplayJob) Duration() string {
-	d := time.Now().Sub(j.Status.StartTime.Time)
+	if j.Status.StartTime == nil {
+		return "0s"
+	}
+
+	end := time.Now()
+	if j.Status.CompletionTime != nil {
+		end = j.Status.CompletionTime.Time
+	}
+	d := end.Sub(j.Status.StartTime.Time)
 
 	return d.String()
 }
@@ -0,0 +1,111 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/watcher"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// handleJobProgress streams the progress of a running transcode job as server-sent events,
+// a "progress" event with the percent, fps and ETA each time it changes, and a "done" event
+// once the pod stops logging, because the encode finished or the pod stopped.
+// GET /jobs/{name}/progress
+func (s server) handleJobProgress(w http.ResponseWriter, req *http.Request, name string) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	logs, err := s.followTranscodeLogs(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer logs.Close()
+
+	// Stop following the logs when the client disconnects, which unblocks the read of the logs
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-req.Context().Done():
+			logs.Close()
+		case <-done:
+		}
+	}()
+
+	streamProgress(w, logs)
+}
+
+// followTranscodeLogs follows the HandBrakeCLI logs of the running pod of the job.
+func (s server) followTranscodeLogs(name string) (io.ReadCloser, error) {
+	podclient := s.client.CoreV1().Pods(watcher.Namespace)
+	podSelector := labels.SelectorFromSet(labels.Set{"job-name": name})
+	pods, err := podclient.List(metav1.ListOptions{LabelSelector: podSelector.String()})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the pods for %s/%s", watcher.Namespace, name)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		logs, err := podclient.GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: "handbrake",
+			Follow:    true,
+		}).Stream()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to follow the logs of %s/%s", watcher.Namespace, pod.Name)
+		}
+		return logs, nil
+	}
+	return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "pods"}, fmt.Sprintf("running pod for job %s", name))
+}
+
+// streamProgress writes an event each time the progress parsed from the logs changes,
+// until the logs end.
+func streamProgress(w http.ResponseWriter, logs io.Reader) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	flush()
+
+	var last *handbrake.Progress
+	report := func(p handbrake.Progress) {
+		if last != nil && *last == p {
+			return
+		}
+		last = &p
+		data, err := json.Marshal(p)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+		flush()
+	}
+
+	_, err := io.Copy(handbrake.NewProgressWriter(report), logs)
+	if err != nil {
+		// The logs are closed when the client disconnects
+		fmt.Println(errors.Wrap(err, "stopped following the transcode logs"))
+		return
+	}
+	fmt.Fprint(w, "event: done\ndata: {}\n\n")
+	flush()
+}
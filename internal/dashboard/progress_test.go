@@ -0,0 +1,54 @@
+package dashboard
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamProgress(t *testing.T) {
+	logs := "[12:00:00] starting job\n" +
+		"\rEncoding: task 1 of 1, 10.00 % (60.00 fps, avg 61.00 fps, ETA 00h10m00s)" +
+		"\rEncoding: task 1 of 1, 10.00 % (60.00 fps, avg 61.00 fps, ETA 00h10m00s)" +
+		"\rEncoding: task 1 of 1, 20.50 % (58.00 fps, avg 60.00 fps, ETA 00h09m10s)\n" +
+		"[12:10:00] Finished work\n"
+
+	w := httptest.NewRecorder()
+	streamProgress(w, strings.NewReader(logs))
+
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", got)
+	}
+	want := "event: progress\n" +
+		`data: {"task":1,"tasks":1,"percent":20.5,"fps":58,"avgFps":60,"eta":550000000000}` + "\n\n" +
+		"event: done\ndata: {}\n\n"
+	if got := w.Body.String(); got != want {
+		t.Fatalf("expected the events:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestStreamProgress_EachWrite(t *testing.T) {
+	r, pw := io.Pipe()
+	go func() {
+		for _, line := range []string{
+			"\rEncoding: task 1 of 2, 10.00 % (60.00 fps, avg 61.00 fps, ETA 00h10m00s)",
+			"\rEncoding: task 1 of 2, 10.00 % (60.00 fps, avg 61.00 fps, ETA 00h10m00s)",
+			"\rEncoding: task 2 of 2, 1.00 % (30.00 fps, avg 30.00 fps, ETA 00h20m00s)",
+		} {
+			io.WriteString(pw, line)
+		}
+		pw.Close()
+	}()
+
+	w := httptest.NewRecorder()
+	streamProgress(w, r)
+
+	got := w.Body.String()
+	if n := strings.Count(got, "event: progress"); n != 2 {
+		t.Fatalf("expected an event each time the progress changed, got %d:\n%s", n, got)
+	}
+	if !strings.HasSuffix(got, "event: done\ndata: {}\n\n") {
+		t.Fatalf("expected the stream to end with a done event, got:\n%s", got)
+	}
+}
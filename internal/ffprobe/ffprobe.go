@@ -0,0 +1,133 @@
+package ffprobe
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// maxCachedFiles limits how many probe results are remembered.
+const maxCachedFiles = 1000
+
+// Metadata describes the contents of a video file.
+type Metadata = fs.Metadata
+
+// Track is an audio or subtitle stream in a video file.
+type Track = fs.Track
+
+// Prober reads the metadata of video files with ffprobe. Results are cached
+// until the file is modified, so that a file is only probed once.
+type Prober struct {
+	// CLI is the path to ffprobe.
+	CLI string
+
+	mu    sync.Mutex
+	cache map[string]cachedMetadata
+}
+
+// cachedMetadata is the metadata for a version of a file.
+type cachedMetadata struct {
+	size     int64
+	modTime  time.Time
+	metadata *Metadata
+}
+
+// NewProber creates a prober that runs the ffprobe command.
+func NewProber(cli string) *Prober {
+	return &Prober{
+		CLI:   cli,
+		cache: make(map[string]cachedMetadata),
+	}
+}
+
+// Probe reads the metadata of a video file.
+func (p *Prober) Probe(path string) (*Metadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to stat %s", path)
+	}
+
+	p.mu.Lock()
+	cached, ok := p.cache[path]
+	p.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.metadata, nil
+	}
+
+	output, err := exec.Command(p.CLI, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path).Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to probe %s", path)
+	}
+
+	m, err := parseMetadata(output)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse the ffprobe output for %s", path)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cache) >= maxCachedFiles {
+		p.cache = make(map[string]cachedMetadata)
+	}
+	p.cache[path] = cachedMetadata{size: info.Size(), modTime: info.ModTime(), metadata: m}
+
+	return m, nil
+}
+
+// probeResult is the subset of the ffprobe json output that is used.
+type probeResult struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+		Tags      struct {
+			Language string `json:"language"`
+		} `json:"tags"`
+	} `json:"streams"`
+	Format struct {
+		Duration  string `json:"duration"`
+		StartTime string `json:"start_time"`
+	} `json:"format"`
+}
+
+func parseMetadata(output []byte) (*Metadata, error) {
+	var result probeResult
+	err := json.Unmarshal(output, &result)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	m := &Metadata{}
+	if result.Format.Duration != "" {
+		seconds, err := strconv.ParseFloat(result.Format.Duration, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid duration %q", result.Format.Duration)
+		}
+		m.Duration = time.Duration(seconds * float64(time.Second))
+	}
+
+	for _, s := range result.Streams {
+		switch s.CodecType {
+		case "video":
+			// Only use the first video stream, others are usually cover art
+			if m.VideoCodec == "" {
+				m.VideoCodec = s.CodecName
+				m.Width = s.Width
+				m.Height = s.Height
+			}
+		case "audio":
+			m.AudioTracks = append(m.AudioTracks, Track{Codec: s.CodecName, Language: s.Tags.Language})
+		case "subtitle":
+			m.SubtitleTracks = append(m.SubtitleTracks, Track{Codec: s.CodecName, Language: s.Tags.Language})
+		}
+	}
+
+	return m, nil
+}
@@ -0,0 +1,98 @@
+package ffprobe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleOutput = `{
+  "streams": [
+    {"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080},
+    {"codec_type": "audio", "codec_name": "ac3", "tags": {"language": "eng"}},
+    {"codec_type": "audio", "codec_name": "aac", "tags": {"language": "fre"}},
+    {"codec_type": "subtitle", "codec_name": "subrip", "tags": {"language": "eng"}},
+    {"codec_type": "video", "codec_name": "mjpeg", "width": 600, "height": 800}
+  ],
+  "format": {"duration": "5400.500000"}
+}`
+
+func TestParseMetadata(t *testing.T) {
+	m, err := parseMetadata([]byte(sampleOutput))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if m.Duration != 5400*time.Second+500*time.Millisecond {
+		t.Fatalf("unexpected duration %s", m.Duration)
+	}
+	if m.VideoCodec != "h264" || m.Width != 1920 || m.Height != 1080 {
+		t.Fatalf("expected the first video stream, got %s %dx%d", m.VideoCodec, m.Width, m.Height)
+	}
+	if len(m.AudioTracks) != 2 || m.AudioTracks[1] != (Track{Codec: "aac", Language: "fre"}) {
+		t.Fatalf("unexpected audio tracks %#v", m.AudioTracks)
+	}
+	if len(m.SubtitleTracks) != 1 || m.SubtitleTracks[0].Language != "eng" {
+		t.Fatalf("unexpected subtitle tracks %#v", m.SubtitleTracks)
+	}
+}
+
+func TestProber_Cache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake ffprobe is a shell script")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The fake ffprobe records each time it is called
+	calls := filepath.Join(tmpDir, "calls")
+	cli := filepath.Join(tmpDir, "ffprobe")
+	script := "#!/bin/sh\necho x >> '" + calls + "'\necho '" + strings.Replace(sampleOutput, "\n", " ", -1) + "'\n"
+	err = ioutil.WriteFile(cli, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(video, []byte("video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	p := NewProber(cli)
+	for i := 0; i < 2; i++ {
+		m, err := p.Probe(video)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if m.VideoCodec != "h264" {
+			t.Fatalf("unexpected metadata %s", m)
+		}
+	}
+
+	// Modifying the file invalidates the cache
+	err = ioutil.WriteFile(video, []byte("modified video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = p.Probe(video)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	contents, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if n := strings.Count(string(contents), "x"); n != 2 {
+		t.Fatalf("expected the video to be probed once per version, got %d", n)
+	}
+}
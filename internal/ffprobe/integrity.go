@@ -0,0 +1,125 @@
+package ffprobe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tailWindow is how far before the end of its reported duration the packets of a video are read.
+const tailWindow = time.Minute
+
+// IncompleteError is a video that is structurally incomplete, such as an interrupted
+// download that stopped changing, which would fail or be cut short when transcoded.
+type IncompleteError struct {
+	Path   string
+	Reason string
+}
+
+func (e IncompleteError) Error() string {
+	return fmt.Sprintf("%s is incomplete: %s", e.Path, e.Reason)
+}
+
+// CheckIntegrity verifies that the video can be read, including the index that an MP4
+// needs in its moov atom, and that its packets reach the end of its reported duration,
+// within the tolerance. Returns an IncompleteError when the video is incomplete.
+func (p *Prober) CheckIntegrity(path string, tolerance time.Duration) error {
+	output, err := p.run("-v", "error", "-print_format", "json", "-show_format", path)
+	if err != nil {
+		return err
+	}
+
+	var result probeResult
+	err = json.Unmarshal(output, &result)
+	if err != nil {
+		return errors.Wrapf(err, "unable to parse the ffprobe output for %s", path)
+	}
+	duration := parseSeconds(result.Format.Duration)
+	if duration <= 0 {
+		return IncompleteError{Path: path, Reason: "no duration is reported"}
+	}
+	startTime := parseSeconds(result.Format.StartTime)
+
+	// Only read the end of the video, reading all of it takes as long as a transcode
+	readFrom := startTime + duration - tailWindow
+	if readFrom < startTime {
+		readFrom = startTime
+	}
+	output, err = p.run("-v", "error", "-read_intervals", fmt.Sprintf("%.3f%%", readFrom.Seconds()),
+		"-show_entries", "packet=pts_time", "-of", "csv=p=0", path)
+	if err != nil {
+		return err
+	}
+
+	last, ok := parseLastPacketTime(output)
+	if !ok {
+		return IncompleteError{Path: path, Reason: fmt.Sprintf("no packets were read near the end of its %s duration", duration)}
+	}
+	return checkLength(path, duration, last-startTime, tolerance)
+}
+
+// run ffprobe, returning an IncompleteError with its errors when it can't read the video.
+func (p *Prober) run(args ...string) ([]byte, error) {
+	path := args[len(args)-1]
+	var stderr bytes.Buffer
+	cmd := exec.Command(p.CLI, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if _, ok := err.(*exec.ExitError); ok {
+		reason := strings.TrimSpace(stderr.String())
+		if reason == "" {
+			reason = err.Error()
+		}
+		return nil, IncompleteError{Path: path, Reason: reason}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to probe %s", path)
+	}
+	return output, nil
+}
+
+// checkLength compares how far the packets of the video reach to its reported duration.
+func checkLength(path string, duration, decodable, tolerance time.Duration) error {
+	if decodable < duration-tolerance {
+		return IncompleteError{Path: path, Reason: fmt.Sprintf("only %s of its %s duration can be read",
+			decodable.Round(time.Second), duration.Round(time.Second))}
+	}
+	return nil
+}
+
+// parseLastPacketTime returns the latest timestamp in the csv output of packet=pts_time,
+// ignoring packets without a timestamp.
+func parseLastPacketTime(output []byte) (time.Duration, bool) {
+	var last time.Duration
+	var found bool
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), ","))
+		if line == "" || line == "N/A" {
+			continue
+		}
+		seconds, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			continue
+		}
+		t := time.Duration(seconds * float64(time.Second))
+		if !found || t > last {
+			last, found = t, true
+		}
+	}
+	return last, found
+}
+
+// parseSeconds parses a duration in seconds from ffprobe, which is 0 when it is missing or invalid.
+func parseSeconds(s string) time.Duration {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
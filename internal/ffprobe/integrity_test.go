@@ -0,0 +1,70 @@
+package ffprobe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCheckLength(t *testing.T) {
+	testcases := []struct {
+		Name                string
+		Duration, Decodable time.Duration
+		WantIncomplete      bool
+	}{
+		{Name: "complete", Duration: time.Hour, Decodable: time.Hour - time.Second},
+		{Name: "truncated", Duration: time.Hour, Decodable: 20 * time.Minute, WantIncomplete: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := checkLength("foo.mkv", tc.Duration, tc.Decodable, 5*time.Second)
+			_, incomplete := err.(IncompleteError)
+			if incomplete != tc.WantIncomplete {
+				t.Fatalf("expected incomplete to be %t, got %v", tc.WantIncomplete, err)
+			}
+		})
+	}
+}
+
+func TestParseLastPacketTime(t *testing.T) {
+	last, ok := parseLastPacketTime([]byte("3540.020000\n3540.000000,\nN/A\n3599.980000\n\n"))
+	if !ok || last != 3599980*time.Millisecond {
+		t.Fatalf("expected the latest packet time, got %s", last)
+	}
+
+	if _, ok := parseLastPacketTime([]byte("\n")); ok {
+		t.Fatal("expected no packet time")
+	}
+}
+
+func TestProber_CheckIntegrity(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake ffprobe is a shell script")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// The fake ffprobe fails like it does for an MP4 without its moov atom
+	cli := filepath.Join(tmpDir, "ffprobe")
+	script := "#!/bin/sh\necho 'moov atom not found' >&2\nexit 1\n"
+	err = ioutil.WriteFile(cli, []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	err = NewProber(cli).CheckIntegrity(filepath.Join(tmpDir, "foo.mp4"), time.Second)
+	incomplete, ok := errors.Cause(err).(IncompleteError)
+	if !ok || incomplete.Reason != "moov atom not found" {
+		t.Fatalf("expected the video to be incomplete because of the ffprobe error, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package ffprobe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IsMP4 determines if the file is an MP4, or another container with the same boxes, by its extension.
+func IsMP4(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".mov":
+		return true
+	}
+	return false
+}
+
+// CheckCopiedMP4 checks that a file that stopped changing isn't an MP4 whose copy stopped
+// partway, see CheckMP4Index and fs.WithStallDetection. Returns an error with why the MP4 is
+// incomplete, or nil when it is complete, isn't an MP4, or can't be checked.
+func CheckCopiedMP4(path string) error {
+	if !IsMP4(path) {
+		return nil
+	}
+	err := CheckMP4Index(path)
+	if incomplete, ok := err.(IncompleteError); ok {
+		return errors.New(incomplete.Reason)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return nil
+}
+
+// CheckMP4Index reads the top-level boxes of an MP4 without ffprobe, which is cheap enough to
+// check a file that is still being copied. Returns an IncompleteError when a box is cut off
+// by the end of the file, or the file doesn't have the moov box with its index.
+func CheckMP4Index(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open %s", path)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "unable to stat %s", path)
+	}
+	size := info.Size()
+
+	var hasIndex bool
+	header := make([]byte, 16)
+	for offset := int64(0); offset < size; {
+		if size-offset < 8 {
+			return IncompleteError{Path: path, Reason: fmt.Sprintf("it ends partway through a box header at %d bytes", offset)}
+		}
+		_, err = f.ReadAt(header[:8], offset)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read the box at %d bytes of %s", offset, path)
+		}
+		boxType := string(header[4:8])
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header[:4])), int64(8)
+		switch boxSize {
+		case 0:
+			// The last box extends to the end of the file
+			boxSize = size - offset
+		case 1:
+			_, err = f.ReadAt(header[8:16], offset+8)
+			if err == io.EOF {
+				return IncompleteError{Path: path, Reason: fmt.Sprintf("it ends partway through the header of its %s box", boxType)}
+			}
+			if err != nil {
+				return errors.Wrapf(err, "unable to read the box at %d bytes of %s", offset, path)
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(header[8:16])), 16
+		}
+		if boxSize < headerSize {
+			return IncompleteError{Path: path, Reason: fmt.Sprintf("it has an invalid %s box at %d bytes", boxType, offset)}
+		}
+		if offset+boxSize > size {
+			return IncompleteError{Path: path, Reason: fmt.Sprintf("its %s box is cut off at %d of %d bytes", boxType, size-offset, boxSize)}
+		}
+		if boxType == "moov" {
+			hasIndex = true
+		}
+		offset += boxSize
+	}
+
+	if !hasIndex {
+		return IncompleteError{Path: path, Reason: "it doesn't have the moov box with its index"}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package ffprobe
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mp4Box builds a box with the type, whose header claims the size, followed by the data.
+func mp4Box(boxType string, size uint32, data int) []byte {
+	b := make([]byte, 8+data)
+	binary.BigEndian.PutUint32(b, size)
+	copy(b[4:], boxType)
+	return b
+}
+
+func TestCheckMP4Index(t *testing.T) {
+	ftyp := mp4Box("ftyp", 16, 8)
+	moov := mp4Box("moov", 24, 16)
+	testcases := []struct {
+		Name           string
+		Boxes          [][]byte
+		WantIncomplete bool
+	}{
+		{Name: "index at the end", Boxes: [][]byte{ftyp, mp4Box("mdat", 108, 100), moov}},
+		{Name: "index at the start", Boxes: [][]byte{ftyp, moov, mp4Box("mdat", 0, 100)}},
+		{Name: "missing index", Boxes: [][]byte{ftyp, mp4Box("mdat", 108, 100)}, WantIncomplete: true},
+		{Name: "cut off data", Boxes: [][]byte{ftyp, moov, mp4Box("mdat", 1000, 100)}, WantIncomplete: true},
+		{Name: "cut off header", Boxes: [][]byte{ftyp, moov, []byte("mda")}, WantIncomplete: true},
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var contents []byte
+			for _, box := range tc.Boxes {
+				contents = append(contents, box...)
+			}
+			path := filepath.Join(tmpDir, "foo.mp4")
+			err := ioutil.WriteFile(path, contents, 0644)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+
+			err = CheckMP4Index(path)
+			_, incomplete := err.(IncompleteError)
+			if incomplete != tc.WantIncomplete {
+				t.Fatalf("expected incomplete to be %t, got %v", tc.WantIncomplete, err)
+			}
+			if !incomplete && err != nil {
+				t.Fatalf("%+v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package fs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumExt is the extension of the sidecar file with the checksum of a video.
+const ChecksumExt = ".sha256"
+
+// Checksum returns the hex encoded SHA-256 of the file, streaming it so that
+// large files aren't loaded into memory.
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot open %s", path)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to checksum %s", path)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ChecksumLine is the contents of the sidecar file with the checksum of the file.
+func ChecksumLine(path, sum string) string {
+	return sum + "  " + filepath.Base(path) + "\n"
+}
+
+// WriteChecksumFile writes the checksum of the file to a sidecar file next to it,
+// in the format used by sha256sum, so that it can be verified later with
+// sha256sum -c. Returns the path of the sidecar file.
+func WriteChecksumFile(path, sum string) (string, error) {
+	sidecarPath := path + ChecksumExt
+	err := ioutil.WriteFile(sidecarPath, []byte(ChecksumLine(path, sum)), 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to write the checksum to %s", sidecarPath)
+	}
+	return sidecarPath, nil
+}
+
+// ChecksumMismatchError is returned when a copied file doesn't have the checksum of the original.
+type ChecksumMismatchError struct {
+	Path, CopyPath string
+	Want, Got      string
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("the copy %s doesn't match %s, its checksum is %s instead of %s", e.CopyPath, e.Path, e.Got, e.Want)
+}
+
+// VerifiedMoveFile moves the source path to the destination path, like MoveFile, but
+// only removes the source once the checksum of every copied file matches the original.
+// When a copy doesn't match, the copy is removed, the source is left in place, and
+// a ChecksumMismatchError is returned.
+func VerifiedMoveFile(src, dest string) error {
+	return verifiedMove(src, dest, CopyFile)
+}
+
+func verifiedMove(src, dest string, copy func(src, dest string) error) error {
+	want, err := checksums(src)
+	if err != nil {
+		return err
+	}
+
+	err = copy(src, dest)
+	if err != nil {
+		return err
+	}
+
+	got, err := checksums(dest)
+	if err != nil {
+		return err
+	}
+	for rel, wantSum := range want {
+		if got[rel] != wantSum {
+			mismatch := ChecksumMismatchError{
+				Path: filepath.Join(src, rel), CopyPath: filepath.Join(dest, rel),
+				Want: wantSum, Got: got[rel],
+			}
+			if removeErr := os.RemoveAll(dest); removeErr != nil {
+				return errors.Wrapf(mismatch, "unable to remove the copy %s", dest)
+			}
+			return mismatch
+		}
+	}
+	return os.RemoveAll(src)
+}
+
+// checksums of the file, or of every file in the directory, by their path relative to it.
+func checksums(path string) (map[string]string, error) {
+	sums := make(map[string]string)
+	err := filepath.Walk(path, func(file string, item os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "unable to checksum %s", file)
+		}
+		if item.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(path, file)
+		if err != nil {
+			return errors.Wrapf(err, "unable to checksum %s", file)
+		}
+		sums[rel], err = Checksum(file)
+		return err
+	})
+	return sums, err
+}
@@ -0,0 +1,95 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksum(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	sum, err := Checksum(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	wantSum := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	if sum != wantSum {
+		t.Fatalf("expected checksum %s, got %s", wantSum, sum)
+	}
+
+	sidecarPath, err := WriteChecksumFile(path, sum)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	got, err := ioutil.ReadFile(sidecarPath)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if want := wantSum + "  foo.mkv\n"; string(got) != want {
+		t.Fatalf("expected the sidecar file to have %q, got %q", want, string(got))
+	}
+}
+
+func TestVerifiedMoveFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	src := filepath.Join(tmpDir, "foo.mkv")
+	dest := filepath.Join(tmpDir, "archive", "foo.mkv")
+	err = ioutil.WriteFile(src, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		corrupt := func(src, dest string) error {
+			err := CopyFile(src, dest)
+			if err != nil {
+				return err
+			}
+			return ioutil.WriteFile(dest, []byte("f00"), 0644)
+		}
+		err := verifiedMove(src, dest, corrupt)
+		if _, ok := err.(ChecksumMismatchError); !ok {
+			t.Fatalf("expected a ChecksumMismatchError, got %#v", err)
+		}
+		if _, err := os.Stat(src); err != nil {
+			t.Fatalf("expected the original to be left in place, got %#v", err)
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Fatalf("expected the corrupted copy to be removed, got %#v", err)
+		}
+	})
+
+	t.Run("match", func(t *testing.T) {
+		err := VerifiedMoveFile(src, dest)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Fatalf("expected the original to be removed, got %#v", err)
+		}
+		got, err := ioutil.ReadFile(dest)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		if string(got) != "foo" {
+			t.Fatalf("expected the copy to contain foo, got %q", got)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// closeWritePollTimeout is how long, in milliseconds, to wait for an inotify
+// event before checking if the watch was stopped.
+const closeWritePollTimeout = 200
+
+// closeWriteSupported is true when StabilityCloseWrite can be used on this platform.
+const closeWriteSupported = true
+
+// watchCloseWrite watches for a writer to close the file with raw inotify, which
+// fsnotify doesn't expose. The returned channel is closed once the file is closed
+// after being written, and stop releases the watch.
+func watchCloseWrite(path string) (closed <-chan struct{}, stop func(), err error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to create an inotify instance")
+	}
+	_, err = unix.InotifyAddWatch(fd, path, unix.IN_CLOSE_WRITE)
+	if err != nil {
+		unix.Close(fd)
+		return nil, nil, errors.Wrapf(err, "unable to watch %s for close events", path)
+	}
+
+	closedChan := make(chan struct{})
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer unix.Close(fd)
+
+		buf := make([]byte, 4096)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+			n, err := unix.Poll(fds, closeWritePollTimeout)
+			if err != nil && err != unix.EINTR {
+				return
+			}
+			if n == 0 {
+				continue
+			}
+
+			n, err = unix.Read(fd, buf)
+			if err != nil {
+				continue
+			}
+			if hasCloseWrite(buf[:n]) {
+				close(closedChan)
+				<-done
+				return
+			}
+		}
+	}()
+
+	stop = func() {
+		close(done)
+		<-stopped
+	}
+	return closedChan, stop, nil
+}
+
+// hasCloseWrite determines if any of the inotify events is IN_CLOSE_WRITE.
+func hasCloseWrite(buf []byte) bool {
+	for offset := 0; offset+unix.SizeofInotifyEvent <= len(buf); {
+		e := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		if e.Mask&unix.IN_CLOSE_WRITE != 0 {
+			return true
+		}
+		offset += unix.SizeofInotifyEvent + int(e.Len)
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFileWatcher_CloseWrite(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Wait much longer for events to stop than for the close
+	w, err := NewStableFileWatcher(tmpDir, time.Minute, WithStabilityMode(StabilityCloseWrite))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	f, err := os.Create(filepath.Join(tmpDir, "foo.mkv"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	_, err = f.WriteString("foo")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		t.Fatalf("expected the file to be signaled once it was closed, got %v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if filepath.Base(e.Path) != "foo.mkv" {
+			t.Fatalf("expected foo.mkv to be signaled, got %v", e)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the file to be signaled as soon as it was closed")
+	}
+}
+
+func TestCopyFileWatcher_CloseWrite_StillOpen(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithStabilityMode(StabilityCloseWrite), WithOpenWriterCheck())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// The writer has the file open twice, and closes one of them first
+	path := filepath.Join(tmpDir, "foo.mkv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	other, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	_, err = f.WriteString("foo")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		t.Fatalf("expected the file to not be signaled while it is still open for writing, got %v", e)
+	case <-time.After(testStableThreshold * 3):
+	}
+
+	err = other.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if filepath.Base(e.Path) != "foo.mkv" {
+			t.Fatalf("expected foo.mkv to be signaled, got %v", e)
+		}
+	case <-time.After(testStableThreshold * 5):
+		t.Fatal("expected the file to be signaled once every writer closed it")
+	}
+}
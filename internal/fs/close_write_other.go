@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+import "github.com/pkg/errors"
+
+// closeWriteSupported is true when StabilityCloseWrite can be used on this platform.
+const closeWriteSupported = false
+
+// watchCloseWrite isn't supported without inotify.
+func watchCloseWrite(path string) (closed <-chan struct{}, stop func(), err error) {
+	return nil, nil, errors.Errorf("the %s stability mode is only supported on Linux", StabilityCloseWrite)
+}
@@ -0,0 +1,62 @@
+package fs
+
+import "github.com/pkg/errors"
+
+// WithMaxConcurrentWaits limits how many files are waited on at once, once the watcher
+// started. The other files wait for a slot before they are checked. A limit of 0 doesn't
+// limit them.
+func WithMaxConcurrentWaits(limit int) Option {
+	return func(w *StableFileWatcher) error {
+		if limit < 0 {
+			return errors.Errorf("invalid max concurrent waits %d, must not be negative", limit)
+		}
+		w.MaxConcurrentWaits = limit
+		return nil
+	}
+}
+
+// WithInitialScanConcurrency limits how many of the files that were already in the watch
+// directory on startup are waited on at once, independently of the MaxConcurrentWaits, so
+// that a backlog can be drained faster, or slower, than the new files. Once the existing
+// files are checked, only the MaxConcurrentWaits applies. A limit of 0 doesn't limit them.
+func WithInitialScanConcurrency(limit int) Option {
+	return func(w *StableFileWatcher) error {
+		if limit < 0 {
+			return errors.Errorf("invalid initial scan concurrency %d, must not be negative", limit)
+		}
+		w.InitialScanConcurrency = limit
+		return nil
+	}
+}
+
+// initWaitSlots creates the slots of the limits, once the options are set.
+func (w *StableFileWatcher) initWaitSlots() {
+	if w.MaxConcurrentWaits > 0 {
+		w.waitSlots = make(chan struct{}, w.MaxConcurrentWaits)
+	}
+	if w.InitialScanConcurrency > 0 {
+		w.initialScanSlots = make(chan struct{}, w.InitialScanConcurrency)
+	}
+}
+
+// acquireWaitSlot waits for a slot to wait on a file, from the InitialScanConcurrency when
+// the file was in the watch directory on startup, or else from the MaxConcurrentWaits.
+// Returns a func to release the slot, or false when the wait was canceled, or the watcher closed.
+func (w *StableFileWatcher) acquireWaitSlot(initial bool, canceled <-chan struct{}) (func(), bool) {
+	slots := w.waitSlots
+	if initial {
+		slots = w.initialScanSlots
+	}
+	if slots == nil {
+		return func() {}, true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	case <-canceled:
+		return nil, false
+	case <-w.done:
+		return nil, false
+	}
+}
@@ -0,0 +1,61 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStableFileWatcher_InitialScanConcurrency(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"bar.mkv", "baz.mkv", "foo.mkv"} {
+		err = ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	threshold := 300 * time.Millisecond
+	start := time.Now()
+	w, err := NewStableFileWatcher(tmpDir, threshold, WithInitialScanConcurrency(1), WithMaxConcurrentWaits(1))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	// A new file isn't held back by the backlog
+	newPath := filepath.Join(tmpDir, "new.mkv")
+	err = ioutil.WriteFile(newPath, []byte("new"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var got []string
+	for len(got) < 4 {
+		select {
+		case e := <-w.Events:
+			got = append(got, e.Path)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected every file to be signaled, got %v", got)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 3*threshold {
+		t.Fatalf("expected the existing files to be waited on one at a time, got them all after %s", elapsed)
+	}
+	if got[0] != newPath && got[1] != newPath {
+		t.Fatalf("expected the new file to be signaled while the existing files were still checked, got %v", got)
+	}
+
+	if _, err := NewStableFileWatcher(tmpDir, threshold, WithInitialScanConcurrency(-1)); err == nil {
+		t.Fatal("expected a negative concurrency to be rejected")
+	}
+}
@@ -9,11 +9,15 @@ import (
 )
 
 // CopyFile copies the source path to the destination path.
+// A directory is copied along with everything in it.
 func CopyFile(src, dest string) error {
 	srcStat, err := os.Stat(src)
 	if err != nil {
 		return errors.Wrapf(err, "cannot stat %s", src)
 	}
+	if srcStat.IsDir() {
+		return copyDir(src, dest)
+	}
 	srcSize := srcStat.Size()
 
 	srcFile, err := os.Open(src)
@@ -52,10 +56,55 @@ func CopyFile(src, dest string) error {
 }
 
 // MoveFile copies the source path to the destination path, and then removes it.
+// A directory is moved along with everything in it.
 func MoveFile(src, dest string) error {
-	err := CopyFile(src, dest)
+	srcStat, err := os.Stat(src)
+	if err != nil {
+		return errors.Wrapf(err, "cannot stat %s", src)
+	}
+	if srcStat.IsDir() {
+		err = copyDir(src, dest)
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(src)
+	}
+
+	err = CopyFile(src, dest)
 	if err != nil {
 		return err
 	}
 	return os.Remove(src)
 }
+
+// copyDir copies every file in the source directory to the same relative path in the destination directory.
+func copyDir(src, dest string) error {
+	return filepath.Walk(src, func(path string, item os.FileInfo, err error) error {
+		if err != nil {
+			return errors.Wrapf(err, "unable to copy %s", path)
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return errors.Wrapf(err, "unable to copy %s", path)
+		}
+		if item.IsDir() {
+			return os.MkdirAll(filepath.Join(dest, rel), 0755)
+		}
+		return CopyFile(path, filepath.Join(dest, rel))
+	})
+}
+
+// Size of the file, or of every file in the directory.
+func Size(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(path string, item os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !item.IsDir() {
+			size += item.Size()
+		}
+		return nil
+	})
+	return size, err
+}
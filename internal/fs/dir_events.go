@@ -0,0 +1,131 @@
+package fs
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithDirectoryEvents signals each directory at the depth in the watch directory as a
+// single event, once nothing in it has changed for the threshold, instead of
+// signaling the files in it. For example, a depth of 1 signals /watch/Disc for
+// /watch/Disc/VIDEO_TS/VTS_01_1.VOB, and a depth of 2 signals /watch/Movies/Disc
+// in a watch directory of libraries. Files above the depth are signaled as usual.
+func WithDirectoryEvents(depth int) Option {
+	return func(w *StableFileWatcher) error {
+		if depth < 1 {
+			return errors.Errorf("invalid directory depth %d, must be at least 1", depth)
+		}
+		w.WatchDirectories = true
+		w.DirectoryDepth = depth
+		return nil
+	}
+}
+
+// unitOf returns the directory that is signaled for the path, or the path itself
+// when it is such a directory. Returns false when the path is signaled on its own.
+func (w *StableFileWatcher) unitOf(path string, isDir bool) (string, bool) {
+	if !w.WatchDirectories {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(w.watchDir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	parts := strings.Split(rel, string(os.PathSeparator))
+	if len(parts) > w.DirectoryDepth {
+		return filepath.Join(append([]string{w.watchDir}, parts[:w.DirectoryDepth]...)...), true
+	}
+	if len(parts) == w.DirectoryDepth && isDir {
+		return path, true
+	}
+	return "", false
+}
+
+// isUnit determines if the path is a directory that is signaled as a single event.
+func (w *StableFileWatcher) isUnit(path string) bool {
+	if !w.WatchDirectories {
+		return false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return false
+	}
+	unit, ok := w.unitOf(path, true)
+	return ok && unit == path
+}
+
+// dirSnapshot summarizes the files in a directory, to detect when any of them change.
+type dirSnapshot struct {
+	files   int
+	size    int64
+	modTime time.Time
+}
+
+// snapshotDir summarizes the files in the directory and its subdirectories.
+func snapshotDir(dir string) (dirSnapshot, error) {
+	var s dirSnapshot
+	err := filepath.Walk(dir, func(path string, item os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if item.ModTime().After(s.modTime) {
+			s.modTime = item.ModTime()
+		}
+		if !item.IsDir() {
+			s.files++
+			s.size += item.Size()
+		}
+		return nil
+	})
+	return s, err
+}
+
+// pollDirUntilStable waits until none of the files in the directory have changed
+// for the threshold, and there is at least one file, or the wait expires. Returns
+// stabilizeStopped, after untracking the directory when necessary, when the directory won't be signaled.
+func (w *StableFileWatcher) pollDirUntilStable(dir string, threshold time.Duration, canceled <-chan struct{}, expired <-chan time.Time, untrack func()) stabilizeResult {
+	interval := threshold
+	if interval > sizePollInterval {
+		interval = sizePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last dirSnapshot
+	var unchangedSince time.Time
+	for {
+		select {
+		case <-w.done:
+			untrack()
+			return stabilizeStopped
+		case <-canceled:
+			log.Printf("%s was removed while waiting for it to be stable\n", dir)
+			return stabilizeStopped
+		case <-expired:
+			return stabilizeExpired
+		case <-ticker.C:
+			s, err := snapshotDir(dir)
+			if os.IsNotExist(errors.Cause(err)) {
+				untrack()
+				log.Printf("%s was removed while waiting for it to be stable\n", dir)
+				return stabilizeStopped
+			}
+
+			// Start the wait over again, a file was changed, or is still being created
+			now := time.Now()
+			if err != nil || s != last || s.files == 0 {
+				last, unchangedSince = s, now
+				continue
+			}
+			if now.Sub(unchangedSince) >= threshold {
+				return stabilizeStable
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package fs
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// RejectEmpty is a file that was still empty once it stopped changing, such as a
+// placeholder that was touched, or a write that was interrupted before any data.
+const RejectEmpty RejectReason = "empty"
+
+// WithQuarantineEmpty moves the files that are still empty once they stop changing to the
+// QuarantineDir, instead of only skipping them. Requires WithQuarantineDir.
+func WithQuarantineEmpty() Option {
+	return func(w *StableFileWatcher) error {
+		w.QuarantineEmpty = true
+		return nil
+	}
+}
+
+// validateQuarantineEmpty checks that the empty files can be quarantined, once the options are set.
+func (w *StableFileWatcher) validateQuarantineEmpty() error {
+	if w.QuarantineEmpty && w.QuarantineDir == "" {
+		return errors.New("quarantining the empty files requires a quarantine directory")
+	}
+	return nil
+}
+
+// handleEmpty skips a file that is still empty once it stopped changing, which is never
+// signaled, quarantining it when QuarantineEmpty is set. It is waited on again when it is
+// written to.
+func (w *StableFileWatcher) handleEmpty(path string) {
+	if !w.QuarantineEmpty {
+		log.Printf("%s is empty, skipping\n", path)
+		w.reject(path, RejectEmpty)
+		return
+	}
+
+	dest, err := w.quarantine(path)
+	if err != nil {
+		log.Println(errors.Wrapf(err, "%s is empty, unable to quarantine it", path))
+	} else {
+		log.Printf("%s is empty, quarantined it to %s\n", path, dest)
+	}
+	w.reject(path, RejectEmpty)
+}
@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStableFileWatcher_EmptyFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(path, nil, 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != path || r.Reason != RejectEmpty {
+			t.Fatalf("expected %s to be rejected as empty, got %#v", path, r)
+		}
+	case e := <-w.Events:
+		t.Fatalf("expected the empty file to not be signaled, got %s", e.Path)
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the empty file to be rejected")
+	}
+
+	// The file is signaled once it is written to
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	select {
+	case e := <-w.Events:
+		if e.Path != path || e.Size != 3 {
+			t.Fatalf("expected %s to be signaled once it was written, got %#v", path, e)
+		}
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the file to be signaled once it was written")
+	}
+}
+
+func TestStableFileWatcher_QuarantineEmpty(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithQuarantineEmpty()); err == nil {
+		t.Fatal("expected quarantining the empty files to require a quarantine directory")
+	}
+
+	quarantineDir := filepath.Join(tmpDir, "quarantine")
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithQuarantineEmpty(), WithQuarantineDir(quarantineDir))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join(tmpDir, "Movies", "foo.mkv")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	err = ioutil.WriteFile(path, nil, 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != path || r.Reason != RejectEmpty {
+			t.Fatalf("expected %s to be rejected as empty, got %#v", path, r)
+		}
+	case e := <-w.Events:
+		t.Fatalf("expected the empty file to not be signaled, got %s", e.Path)
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the empty file to be rejected")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected the empty file to be moved out of the watch directory")
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, "Movies", "foo.mkv")); err != nil {
+		t.Fatalf("expected the empty file to be quarantined: %#v", err)
+	}
+}
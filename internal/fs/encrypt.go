@@ -0,0 +1,132 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// encryptedHeader starts every file written by a Cipher, so that encrypted files
+// can be told apart from the plaintext files written before encryption was enabled.
+var encryptedHeader = []byte("handbrk8s-aes-gcm-v1\n")
+
+// Cipher encrypts the files that the watcher persists, such as processing markers,
+// with AES-GCM. A nil Cipher reads and writes plaintext.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher creates a cipher from a base64 encoded 16, 24 or 32 byte key,
+// for example the output of `openssl rand -base64 32`.
+func NewCipher(encodedKey string) (*Cipher, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encryption key, must be base64 encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid encryption key, must be 16, 24 or 32 bytes")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the AES-GCM cipher")
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal encrypts the data. A nil Cipher returns the data as is.
+func (c *Cipher) Seal(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate a nonce")
+	}
+	sealed := append(append([]byte{}, encryptedHeader...), nonce...)
+	return c.aead.Seal(sealed, nonce, data, encryptedHeader), nil
+}
+
+// Open decrypts the data. A nil Cipher returns plaintext data, without the encrypted header,
+// as is. Plaintext data is refused once there is a key, so that a file that anyone could have
+// written isn't trusted as one that the watcher wrote.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedHeader) {
+		if c != nil {
+			return nil, errors.New("refusing to read an unencrypted file, the files are encrypted")
+		}
+		return data, nil
+	}
+	if c == nil {
+		return nil, errors.New("unable to read an encrypted file without the encryption key")
+	}
+
+	data = data[len(encryptedHeader):]
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("unable to decrypt, the file is truncated")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, sealed, encryptedHeader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt, the file was modified or encrypted with a different key")
+	}
+	return plaintext, nil
+}
+
+// SealLine encrypts the data as a line of base64, without a trailing newline, for the files
+// that are appended to a line at a time. A nil Cipher returns the data as is.
+func (c *Cipher) SealLine(data []byte) ([]byte, error) {
+	if c == nil {
+		return data, nil
+	}
+	sealed, err := c.Seal(data)
+	if err != nil {
+		return nil, err
+	}
+	line := make([]byte, base64.StdEncoding.EncodedLen(len(sealed)))
+	base64.StdEncoding.Encode(line, sealed)
+	return line, nil
+}
+
+// OpenLine decrypts a line written by SealLine. A line that isn't base64, such as a line of
+// JSON, is plaintext, see Open.
+func (c *Cipher) OpenLine(line []byte) ([]byte, error) {
+	sealed := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(sealed, line)
+	if err != nil {
+		return c.Open(line)
+	}
+	return c.Open(sealed[:n])
+}
+
+// WriteFile encrypts the data, and writes it to the file.
+func (c *Cipher) WriteFile(path string, data []byte, perm os.FileMode) error {
+	sealed, err := c.Seal(data)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encrypt %s", path)
+	}
+	return ioutil.WriteFile(path, sealed, perm)
+}
+
+// ReadFile reads the file, and decrypts it.
+func (c *Cipher) ReadFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := c.Open(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", path)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,121 @@
+package fs
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+func TestCipher_WriteFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	c, err := NewCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	path := filepath.Join(tmpDir, "foo.mkv.processing")
+	err = c.WriteFile(path, []byte("/work/claimed/foo.mkv\n"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if bytes.Contains(raw, []byte("claimed")) {
+		t.Fatalf("expected the file to be encrypted, got %q", raw)
+	}
+
+	got, err := c.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if string(got) != "/work/claimed/foo.mkv\n" {
+		t.Fatalf("expected the file to be decrypted, got %q", got)
+	}
+
+	var noKey *Cipher
+	_, err = noKey.ReadFile(path)
+	if err == nil {
+		t.Fatal("expected reading an encrypted file without the key to fail")
+	}
+
+	other, err := NewCipher("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = other.ReadFile(path)
+	if err == nil {
+		t.Fatal("expected reading a file encrypted with a different key to fail")
+	}
+}
+
+func TestCipher_OpenPlaintext(t *testing.T) {
+	var noKey *Cipher
+	got, err := noKey.Open([]byte("/work/claimed/foo.mkv\n"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if string(got) != "/work/claimed/foo.mkv\n" {
+		t.Fatalf("expected a plaintext file to be read as is without a key, got %q", got)
+	}
+
+	c, err := NewCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = c.Open([]byte("/work/claimed/foo.mkv\n"))
+	if err == nil {
+		t.Fatal("expected a plaintext file to be refused once there is a key")
+	}
+}
+
+func TestCipher_SealLine(t *testing.T) {
+	c, err := NewCipher(testEncryptionKey)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	line, err := c.SealLine([]byte(`{"path":"/watch/foo.mkv"}`))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if bytes.Contains(line, []byte("foo")) || bytes.ContainsAny(line, "\n") {
+		t.Fatalf("expected a single encrypted line, got %q", line)
+	}
+	got, err := c.OpenLine(line)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if string(got) != `{"path":"/watch/foo.mkv"}` {
+		t.Fatalf("expected the line to be decrypted, got %q", got)
+	}
+
+	_, err = c.OpenLine([]byte(`{"path":"/watch/foo.mkv"}`))
+	if err == nil {
+		t.Fatal("expected a plaintext line to be refused once there is a key")
+	}
+	var noKey *Cipher
+	if _, err = noKey.OpenLine(line); err == nil {
+		t.Fatal("expected reading an encrypted line without the key to fail")
+	}
+}
+
+func TestNewCipher_InvalidKey(t *testing.T) {
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		_, err := NewCipher(key)
+		if err == nil {
+			t.Fatalf("expected the key %q to be rejected", key)
+		}
+	}
+}
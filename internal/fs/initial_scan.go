@@ -0,0 +1,43 @@
+package fs
+
+// InitialScanDone is closed once every file that was already in the watch directory when
+// the watcher started was signaled, and received by the consumer, or skipped. A file that
+// is found while the watcher is paused is checked on resume, along with the new files,
+// and doesn't hold up the initial scan.
+func (w *StableFileWatcher) InitialScanDone() <-chan struct{} {
+	return w.initialScanDone
+}
+
+// startInitialScan records the existing files as pending, before they are scheduled.
+func (w *StableFileWatcher) startInitialScan(files []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.initialPending = make(map[string]bool, len(files))
+	for _, file := range files {
+		w.initialPending[file] = true
+	}
+	if len(files) == 0 {
+		close(w.initialScanDone)
+	}
+}
+
+// finishInitialFile records that an existing file is no longer pending.
+func (w *StableFileWatcher) finishInitialFile(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.finishInitialFileLocked(path)
+}
+
+// finishInitialFileLocked records that an existing file is no longer pending, closing
+// InitialScanDone after the last one. A file is only pending once, even when it is found
+// again after the watch directory is recreated. The caller must hold mu.
+func (w *StableFileWatcher) finishInitialFileLocked(path string) {
+	if !w.initialPending[path] {
+		return
+	}
+	delete(w.initialPending, path)
+	if len(w.initialPending) == 0 {
+		close(w.initialScanDone)
+	}
+}
@@ -0,0 +1,73 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStableFileWatcher_InitialScanDone(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	existing := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(existing, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(tmpDir, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	select {
+	case <-w.InitialScanDone():
+		t.Fatal("expected the initial scan to wait for the existing file")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	select {
+	case e := <-w.Events:
+		if e.Path != existing {
+			t.Fatalf("expected the existing file to be signaled, got %s", e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the existing file to be signaled")
+	}
+	select {
+	case <-w.InitialScanDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the initial scan to be done once the existing file was received")
+	}
+}
+
+func TestStableFileWatcher_InitialScanDone_Empty(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	select {
+	case <-w.InitialScanDone():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the initial scan of an empty directory to be done")
+	}
+}
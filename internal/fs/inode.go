@@ -0,0 +1,60 @@
+package fs
+
+import (
+	"os"
+)
+
+// RejectHardLink is a file that is a hard link to a file that was already signaled.
+const RejectHardLink RejectReason = "hard-link"
+
+// maxLinkedFiles is how many signaled files are remembered, before starting over.
+const maxLinkedFiles = 10000
+
+// fileID identifies a file on disk, shared by all of its hard links.
+type fileID struct {
+	dev, ino uint64
+}
+
+// WithHardLinkDedupe skips a file that is a hard link to a file that was already signaled,
+// for example when a download client links the same video into multiple watched directories.
+// On platforms without inodes, files are never considered hard links.
+func WithHardLinkDedupe() Option {
+	return func(w *StableFileWatcher) error {
+		w.DedupeHardLinks = true
+		return nil
+	}
+}
+
+// isLinkedToSignaled determines if the file is a hard link to another path that was
+// already signaled. Otherwise the file is recorded as signaled. Since a file system reuses
+// the inode of a removed file, a file is only a hard link when it has more than one link,
+// and the signaled path still exists with the same inode.
+func (w *StableFileWatcher) isLinkedToSignaled(path string, info os.FileInfo) bool {
+	if !w.DedupeHardLinks {
+		return false
+	}
+
+	id, ok := getFileID(info)
+	if !ok {
+		return false
+	}
+
+	w.mu.Lock()
+	signaledPath, signaled := w.linkedFiles[id]
+	w.mu.Unlock()
+	if signaled && signaledPath != path && linkCount(info) > 1 {
+		if signaledInfo, err := os.Lstat(signaledPath); err == nil {
+			if signaledID, ok := getFileID(signaledInfo); ok && signaledID == id {
+				return true
+			}
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.linkedFiles) >= maxLinkedFiles {
+		w.linkedFiles = make(map[fileID]string)
+	}
+	w.linkedFiles[id] = path
+	return false
+}
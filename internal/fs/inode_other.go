@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package fs
+
+import (
+	"os"
+)
+
+// getFileID is not supported without inodes, so every file is unique.
+func getFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
+
+// linkCount is not supported without inodes, so every file has a single link.
+func linkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,127 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFileWatcher_HardLinkDedupe(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Log("watching ", tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithHardLinkDedupe())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var gotEvents counter
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			gotEvents.increment()
+		}
+		done <- true
+	}()
+
+	// Link the same file into two directories, like a download client
+	seeding := filepath.Join(tmpDir, "seeding")
+	library := filepath.Join(tmpDir, "library")
+	os.MkdirAll(seeding, 0755)
+	os.MkdirAll(library, 0755)
+	time.Sleep(50 * time.Millisecond)
+
+	tmpfile := filepath.Join(seeding, "foo.mkv")
+	err = ioutil.WriteFile(tmpfile, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = os.Link(tmpfile, filepath.Join(library, "foo.mkv"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Give the files time to be considered stable
+	time.Sleep(w.StableThreshold * 2)
+	w.Close()
+	<-done
+
+	var wantEvents int32 = 1
+	if gotEvents.value() != wantEvents {
+		t.Fatalf("expected %d events, got %d", wantEvents, gotEvents)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Reason != RejectHardLink {
+			t.Fatalf("expected the second link to be rejected as a hard link, got %#v", r)
+		}
+	default:
+		t.Fatal("expected the second link to be rejected")
+	}
+}
+
+func TestStableFileWatcher_IsLinkedToSignaled(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w := &StableFileWatcher{DedupeHardLinks: true, linkedFiles: make(map[fileID]string)}
+
+	signaled := filepath.Join(tmpDir, "foo.mkv")
+	if err := ioutil.WriteFile(signaled, []byte("foo"), 0644); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	info, _ := os.Stat(signaled)
+	if w.isLinkedToSignaled(signaled, info) {
+		t.Fatal("expected the first file to be signaled")
+	}
+
+	// A file with a single link isn't a hard link, even with the inode of a signaled file
+	reused := filepath.Join(tmpDir, "bar.mkv")
+	if err := ioutil.WriteFile(reused, []byte("bar"), 0644); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	reusedInfo, _ := os.Stat(reused)
+	id, _ := getFileID(reusedInfo)
+	w.linkedFiles[id] = signaled
+	if w.isLinkedToSignaled(reused, reusedInfo) {
+		t.Fatal("expected a file with a single link to be signaled")
+	}
+
+	// A hard link to a signaled path that was removed, so the inode was reused, isn't rejected
+	link := filepath.Join(tmpDir, "library.mkv")
+	if err := os.Link(reused, link); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	linkInfo, _ := os.Stat(link)
+	w.linkedFiles[id] = filepath.Join(tmpDir, "removed.mkv")
+	if w.isLinkedToSignaled(link, linkInfo) {
+		t.Fatal("expected a link to a removed path to be signaled")
+	}
+	if w.linkedFiles[id] != link {
+		t.Fatalf("expected the removed path to be replaced with %s, got %s", link, w.linkedFiles[id])
+	}
+
+	// A hard link to a signaled path that still exists is rejected
+	reusedInfo, _ = os.Stat(reused)
+	if !w.isLinkedToSignaled(reused, reusedInfo) {
+		t.Fatal("expected a link to a signaled path to be rejected")
+	}
+}
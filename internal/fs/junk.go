@@ -0,0 +1,111 @@
+package fs
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultJunkPatterns are the names of the files, and directories, that download
+// directories accumulate next to the videos, and that are never worth transcoding:
+// sample clips and the sample or proof directories that hold them, release info,
+// checksum and scene files, the promotional files added by release groups, and the
+// thumbnails added by Windows. They are glob patterns, matched case-insensitively
+// against the name of each file and of each directory above it in the watch directory.
+// A sample clip is only matched when sample is its whole name, before its extension,
+// or the end of it, so that a title such as Sample.2015.mkv isn't skipped.
+var DefaultJunkPatterns = []string{
+	"sample", "samples", "proof",
+	"sample.???", "sample.????",
+	"*-sample.???", "*-sample.????", "*.sample.???", "*.sample.????", "*_sample.???", "*_sample.????",
+	"*.nfo", "*.sfv", "*.srr", "*.nzb", "*.torrent", "*.url",
+	"rarbg*.txt", "rarbg*.exe",
+	"thumbs.db", "desktop.ini",
+}
+
+// WithJunkPatterns skips the files matching the glob patterns, instead of DefaultJunkPatterns.
+// A pattern matches the name of a file, or of any directory above it in the watch directory,
+// ignoring case. No patterns skips nothing.
+func WithJunkPatterns(patterns ...string) Option {
+	return func(w *StableFileWatcher) error {
+		w.JunkPatterns = make([]string, 0, len(patterns))
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(pattern)
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return errors.Wrapf(err, "invalid junk pattern %q", pattern)
+			}
+			w.JunkPatterns = append(w.JunkPatterns, pattern)
+		}
+		return nil
+	}
+}
+
+// skipJunk logs that the file is skipped because it is junk, the first time that it is,
+// so that a sample clip that is still downloading isn't logged for each of its writes.
+func (w *StableFileWatcher) skipJunk(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.skippedJunk[path] {
+		return
+	}
+	if w.skippedJunk == nil {
+		w.skippedJunk = make(map[string]bool)
+	}
+	w.skippedJunk[path] = true
+	log.Printf("skipping %s, it matches a junk pattern\n", path)
+}
+
+// forgetJunk forgets that the removed file was skipped, so that it is logged again when it comes back.
+func (w *StableFileWatcher) forgetJunk(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.skippedJunk, path)
+}
+
+// isJunk determines if the file, or any directory above it in the watch directory, matches a JunkPattern.
+func (w *StableFileWatcher) isJunk(path string) bool {
+	if len(w.JunkPatterns) == 0 {
+		return false
+	}
+	rel, err := filepath.Rel(w.watchDir, path)
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		name = strings.ToLower(name)
+		for _, pattern := range w.JunkPatterns {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithIgnoredDirs skips the files in the directories, which are relative to the watch
+// directory unless they are absolute, such as a directory that the watcher writes to.
+func WithIgnoredDirs(dirs ...string) Option {
+	return func(w *StableFileWatcher) error {
+		for _, dir := range dirs {
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(w.watchDir, dir)
+			}
+			w.IgnoredDirs = append(w.IgnoredDirs, filepath.Clean(dir))
+		}
+		return nil
+	}
+}
+
+// isIgnored determines if the file is in one of the IgnoredDirs.
+func (w *StableFileWatcher) isIgnored(path string) bool {
+	for _, dir := range w.IgnoredDirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStableFileWatcher_isJunk(t *testing.T) {
+	watchDir := filepath.FromSlash("/watch")
+	testcases := []struct {
+		path string
+		want bool
+	}{
+		{"movies/Foo (2019)/Foo.mkv", false},
+		{"movies/Foo (2019)/Sample.mkv", true},
+		{"movies/Foo (2019)/foo-sample.mkv", true},
+		{"movies/Foo (2019)/Sample/foo.mkv", true},
+		{"movies/Foo (2019)/Proof/foo.jpg", true},
+		{"movies/Foo (2019)/Foo.NFO", true},
+		{"movies/Foo (2019)/RARBG.txt", true},
+		{"movies/Foo (2019)/Foo.en.srt", false},
+		{"movies/Samples of the Sea/Samples of the Sea.mkv", false},
+		{"movies/Sample (2015)/Sample.2015.mkv", false},
+		{"movies/Foo (2019)/foo.sample.mp4", true},
+		{"movies/Foo (2019)/Sample.webm", true},
+	}
+
+	w := &StableFileWatcher{watchDir: watchDir}
+	err := WithJunkPatterns(DefaultJunkPatterns...)(w)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for _, tc := range testcases {
+		t.Run(tc.path, func(t *testing.T) {
+			path := filepath.Join(watchDir, filepath.FromSlash(tc.path))
+			if got := w.isJunk(path); got != tc.want {
+				t.Fatalf("expected junk to be %v, got %v", tc.want, got)
+			}
+		})
+	}
+
+	// The defaults are replaced, not extended
+	err = WithJunkPatterns("*.JPG")(w)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if w.isJunk(filepath.Join(watchDir, "movies", "Foo.nfo")) {
+		t.Fatal("expected the default patterns to be replaced")
+	}
+	if !w.isJunk(filepath.Join(watchDir, "movies", "foo.jpg")) {
+		t.Fatal("expected the pattern to match ignoring case")
+	}
+
+	err = WithJunkPatterns("[")(w)
+	if err == nil {
+		t.Fatal("expected an invalid pattern to be rejected")
+	}
+}
+
+func TestStableFileWatcher_isIgnored(t *testing.T) {
+	watchDir := filepath.FromSlash("/watch")
+	testcases := []struct {
+		path string
+		want bool
+	}{
+		{"movies/Foo.mkv", false},
+		{"completed/Foo.json", true},
+		{"completed/nested/Foo.json", true},
+		{"completed-videos/Foo.mkv", false},
+		{"../done/Foo.json", true},
+	}
+
+	w := &StableFileWatcher{watchDir: watchDir}
+	err := WithIgnoredDirs("completed", filepath.FromSlash("/done"))(w)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for _, tc := range testcases {
+		t.Run(tc.path, func(t *testing.T) {
+			path := filepath.Join(watchDir, filepath.FromSlash(tc.path))
+			if got := w.isIgnored(path); got != tc.want {
+				t.Fatalf("expected ignored to be %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
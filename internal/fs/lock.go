@@ -0,0 +1,214 @@
+package fs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LockExt is the extension of the lock files in a LockDir.
+const LockExt = ".lock"
+
+// LockDir coordinates processing files between processes, even on different hosts, that
+// share the directory. Each lock is a file that is created atomically, so only one process
+// holds it. While a lock is held its file is touched regularly, so a lock that hasn't been
+// touched within Stale belongs to a process that died, and is reclaimed.
+type LockDir struct {
+	// Dir holds the lock files.
+	Dir string
+
+	// Stale is how long a lock may go without being touched before it is reclaimed.
+	Stale time.Duration
+}
+
+// Lock is held on a key of a LockDir until it is released.
+type Lock struct {
+	path, token string
+	stop        chan struct{}
+	once        sync.Once
+}
+
+// LockedError is returned when another process holds the lock.
+type LockedError struct {
+	Key string
+
+	// Holder is the host and pid of the process that holds the lock.
+	Holder string
+
+	// TouchedAt is when the holder last touched the lock.
+	TouchedAt time.Time
+}
+
+func (e LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by %s", e.Key, e.Holder)
+}
+
+// NewLockDir creates the lock directory.
+func NewLockDir(dir string, stale time.Duration) (*LockDir, error) {
+	if stale <= 0 {
+		return nil, errors.Errorf("invalid stale lock threshold %s, must be positive", stale)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create the lock directory %s", dir)
+	}
+	return &LockDir{Dir: dir, Stale: stale}, nil
+}
+
+// IsLock determines if the path is a file in the lock directory, such as a lock file.
+func (d *LockDir) IsLock(path string) bool {
+	return filepath.Dir(filepath.Clean(path)) == filepath.Clean(d.Dir)
+}
+
+// Acquire the lock on a key, reclaiming it when it is stale. Returns a LockedError when
+// another process holds it.
+func (d *LockDir) Acquire(key string) (*Lock, error) {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(d.Dir, hex.EncodeToString(sum[:16])+LockExt)
+
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	contents := fmt.Sprintf("%s %d %s\n%s\n", host, os.Getpid(), token, key)
+
+	// Try again once after reclaiming a stale lock
+	for attempt := 0; attempt < 2; attempt++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.WriteString(contents)
+			closeErr := f.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(path)
+				return nil, errors.Wrapf(err, "unable to write the lock file %s for %s", path, key)
+			}
+			l := &Lock{path: path, token: token, stop: make(chan struct{})}
+			go l.touch(d.Stale / 4)
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrapf(err, "unable to create the lock file %s for %s", path, key)
+		}
+
+		err = d.reclaim(key, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, LockedError{Key: key, Holder: "another process", TouchedAt: time.Now()}
+}
+
+// reclaim removes a stale lock, so that it can be acquired again. Returns a LockedError
+// when the lock is still held.
+func (d *LockDir) reclaim(key, path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to stat the lock file %s", path)
+	}
+	stale, _ := ioutil.ReadFile(path)
+	holder, _ := parseLockFile(stale)
+	if time.Since(info.ModTime()) < d.Stale {
+		return LockedError{Key: key, Holder: holder, TouchedAt: info.ModTime()}
+	}
+
+	// Move the lock aside before removing it, so that a lock that was just reclaimed
+	// by another process isn't removed by mistake
+	aside := fmt.Sprintf("%s.reclaim-%d", path, os.Getpid())
+	err = os.Rename(path, aside)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to reclaim the stale lock file %s", path)
+	}
+	defer os.Remove(aside)
+	moved, _ := ioutil.ReadFile(aside)
+	if string(moved) != string(stale) {
+		os.Link(aside, path)
+		return nil
+	}
+	log.Printf("reclaimed the stale lock on %s held by %s, untouched since %s\n", key, holder, info.ModTime().Format(time.RFC3339))
+	return nil
+}
+
+// touch the lock file regularly, until it is released, so that it isn't stale.
+func (l *Lock) touch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case now := <-ticker.C:
+			if !l.owned() {
+				log.Printf("the lock file %s was reclaimed by another process\n", l.path)
+				return
+			}
+			err := os.Chtimes(l.path, now, now)
+			if err != nil {
+				log.Println(errors.Wrapf(err, "unable to touch the lock file %s", l.path))
+			}
+		}
+	}
+}
+
+// owned determines if the lock file still belongs to this lock.
+func (l *Lock) owned() bool {
+	b, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return false
+	}
+	_, token := parseLockFile(b)
+	return token == l.token
+}
+
+// Release the lock, unless it was already reclaimed by another process.
+func (l *Lock) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		if !l.owned() {
+			return
+		}
+		removeErr := os.Remove(l.path)
+		if removeErr != nil && !os.IsNotExist(removeErr) {
+			err = errors.Wrapf(removeErr, "unable to remove the lock file %s", l.path)
+		}
+	})
+	return err
+}
+
+// parseLockFile returns the holder, the host and pid, and the token of a lock file.
+func parseLockFile(b []byte) (holder, token string) {
+	fields := strings.Fields(strings.SplitN(string(b), "\n", 2)[0])
+	if len(fields) != 3 {
+		return "an unknown process", ""
+	}
+	return fmt.Sprintf("%s (pid %s)", fields[0], fields[1]), fields[2]
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to generate a lock token")
+	}
+	return hex.EncodeToString(b), nil
+}
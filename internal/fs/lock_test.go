@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockDir_Acquire(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	d, err := NewLockDir(filepath.Join(tmpDir, "locks"), time.Minute)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	lock, err := d.Acquire("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = d.Acquire("Movies/foo.mkv")
+	if _, ok := err.(LockedError); !ok {
+		t.Fatalf("expected a LockedError while the lock is held, got %#v", err)
+	}
+	other, err := d.Acquire("Movies/bar.mkv")
+	if err != nil {
+		t.Fatalf("expected a different key to be locked separately, got %#v", err)
+	}
+	defer other.Release()
+
+	err = lock.Release()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	lock, err = d.Acquire("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("expected the released lock to be acquired again, got %#v", err)
+	}
+	lock.Release()
+}
+
+func TestLockDir_ReclaimStale(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	d, err := NewLockDir(tmpDir, time.Minute)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	stale, err := d.Acquire("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	// Stop touching the lock without removing it, as if its watcher died
+	stale.once.Do(func() { close(stale.stop) })
+	old := time.Now().Add(-2 * time.Minute)
+	os.Chtimes(stale.path, old, old)
+
+	lock, err := d.Acquire("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("expected the stale lock to be reclaimed, got %#v", err)
+	}
+	defer lock.Release()
+	if stale.owned() || !lock.owned() {
+		t.Fatal("expected the lock file to belong to the new lock")
+	}
+}
@@ -0,0 +1,32 @@
+package fs
+
+import (
+	"fmt"
+	"time"
+)
+
+// Metadata describes the contents of a video file, as read by a Prober.
+type Metadata struct {
+	Duration       time.Duration
+	Width, Height  int
+	VideoCodec     string
+	AudioTracks    []Track
+	SubtitleTracks []Track
+}
+
+// Track is an audio or subtitle stream in a video file.
+type Track struct {
+	Codec    string
+	Language string
+}
+
+// String summarizes the metadata, e.g. 1h30m0s 1920x1080 h264, 2 audio, 1 subtitle tracks.
+func (m Metadata) String() string {
+	return fmt.Sprintf("%s %dx%d %s, %d audio, %d subtitle tracks",
+		m.Duration, m.Width, m.Height, m.VideoCodec, len(m.AudioTracks), len(m.SubtitleTracks))
+}
+
+// Prober reads the metadata of a file before its event is signaled, such as with ffprobe.
+type Prober interface {
+	Probe(path string) (*Metadata, error)
+}
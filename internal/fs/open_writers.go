@@ -0,0 +1,27 @@
+package fs
+
+import "log"
+
+// WithOpenWriterCheck confirms that no process still has a file open for writing once it
+// is stable, waiting for it to be stable again otherwise. This catches a writer that buffers
+// its writes, so that the file stops changing before it is completely written. On Linux, the
+// open files of the processes visible to the watcher are read from /proc; on other platforms
+// only the lock check of the platform is used, such as on Windows.
+func WithOpenWriterCheck() Option {
+	return func(w *StableFileWatcher) error {
+		if !openWriterCheckSupported {
+			log.Println("checking for open writers is only supported on Linux, falling back to checking if the files are locked")
+		}
+		w.CheckOpenWriters = true
+		return nil
+	}
+}
+
+// stillOpen determines if the file is still open for writing, when CheckOpenWriters is set.
+func (w *StableFileWatcher) stillOpen(path string) bool {
+	if !w.CheckOpenWriters || !hasOpenWriter(path) {
+		return false
+	}
+	log.Printf("%s is still open for writing, waiting for it to be stable again\n", path)
+	return true
+}
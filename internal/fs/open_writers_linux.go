@@ -0,0 +1,94 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openWriterCheckSupported is true when the open writers of a file can be found on this platform.
+const openWriterCheckSupported = true
+
+// procDir is where the processes and their open files are listed.
+const procDir = "/proc"
+
+// hasOpenWriter determines if a process has the file open for writing, by resolving the
+// open file descriptors of each process in /proc. The processes whose file descriptors
+// can't be read, such as those of another user, are skipped.
+func hasOpenWriter(path string) bool {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+
+	pids, err := readDirNames(procDir)
+	if err != nil {
+		return false
+	}
+	for _, pid := range pids {
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procDir, pid, "fd")
+		fds, err := readDirNames(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd))
+			if err != nil || link != target {
+				continue
+			}
+			if openForWriting(filepath.Join(procDir, pid, "fdinfo", fd)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// openForWriting determines if the flags in the fdinfo of a file descriptor, such as
+// "flags:	0100001", include write access.
+func openForWriting(fdinfo string) bool {
+	f, err := os.Open(fdinfo)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		line := lines.Text()
+		if !strings.HasPrefix(line, "flags:") {
+			continue
+		}
+		flags, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "flags:")), 8, 64)
+		if err != nil {
+			return false
+		}
+		mode := flags & unix.O_ACCMODE
+		return mode == unix.O_WRONLY || mode == unix.O_RDWR
+	}
+	return false
+}
+
+// readDirNames lists the names in a directory, unsorted.
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(-1)
+}
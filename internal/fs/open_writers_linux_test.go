@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHasOpenWriter(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo.mkv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if !hasOpenWriter(path) {
+		t.Fatal("expected the file to be open for writing")
+	}
+	f.Close()
+	if hasOpenWriter(path) {
+		t.Fatal("expected the file to be closed")
+	}
+
+	f, err = os.Open(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer f.Close()
+	if hasOpenWriter(path) {
+		t.Fatal("expected a file that is only open for reading to not have a writer")
+	}
+}
+
+func TestCopyFileWatcher_OpenWriterCheck(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithOpenWriterCheck())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// The writer stops writing without closing the file, as if its writes were buffered
+	f, err := os.Create(filepath.Join(tmpDir, "foo.mkv"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = f.WriteString("foo")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		t.Fatalf("expected the file to not be signaled while it is open for writing, got %v", e)
+	case <-time.After(testStableThreshold * 3):
+	}
+
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if filepath.Base(e.Path) != "foo.mkv" {
+			t.Fatalf("expected foo.mkv to be signaled, got %v", e)
+		}
+	case <-time.After(testStableThreshold * 5):
+		t.Fatal("expected the file to be signaled once it was closed")
+	}
+}
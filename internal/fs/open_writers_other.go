@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+// openWriterCheckSupported is true when the open writers of a file can be found on this platform.
+const openWriterCheckSupported = false
+
+// hasOpenWriter can't find the open writers without /proc, only the lock check of the platform is used.
+func hasOpenWriter(path string) bool {
+	return false
+}
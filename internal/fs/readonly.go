@@ -0,0 +1,27 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// IsReadOnly determines if files can't be created in the directory, such as on a read-only
+// mount or share, by trying to create a temporary file in it.
+func IsReadOnly(dir string) (bool, error) {
+	f, err := ioutil.TempFile(dir, ".handbrk8s-write-check-")
+	if err == nil {
+		f.Close()
+		os.Remove(f.Name())
+		return false, nil
+	}
+	if os.IsPermission(err) {
+		return true, nil
+	}
+	if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == syscall.EROFS {
+		return true, nil
+	}
+	return false, errors.Wrapf(err, "unable to check if %s is writable", dir)
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestIsReadOnly(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("skipping: root may write to a read-only directory")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	readOnly, err := IsReadOnly(tmpDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if readOnly {
+		t.Fatal("expected the directory to be writable")
+	}
+	files, _ := ioutil.ReadDir(tmpDir)
+	if len(files) != 0 {
+		t.Fatal("expected the write check to be removed")
+	}
+
+	err = os.Chmod(tmpDir, 0555)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.Chmod(tmpDir, 0755)
+
+	readOnly, err = IsReadOnly(tmpDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if !readOnly {
+		t.Fatal("expected the directory to be read-only")
+	}
+}
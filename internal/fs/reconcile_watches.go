@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultWatchReconcileInterval is how often the watched directories are compared
+// against the directories that are actually in the watch directory.
+const DefaultWatchReconcileInterval = 5 * time.Minute
+
+// WithWatchReconcile overrides how often the watcher walks the watch directory to watch
+// the directories that it missed, and stop watching the directories that are gone,
+// which defaults to DefaultWatchReconcileInterval. An interval of 0 disables it.
+func WithWatchReconcile(interval time.Duration) Option {
+	return func(w *StableFileWatcher) error {
+		if interval < 0 {
+			return errors.Errorf("invalid watch reconcile interval %s, must not be negative", interval)
+		}
+		w.WatchReconcileInterval = interval
+		return nil
+	}
+}
+
+// reconcileTicks ticks each WatchReconcileInterval, or never when reconciling is disabled,
+// or only a single file is watched.
+func (w *StableFileWatcher) reconcileTicks() (<-chan time.Time, func()) {
+	if w.WatchReconcileInterval <= 0 || w.watchFile != "" {
+		return nil, func() {}
+	}
+	t := time.NewTicker(w.WatchReconcileInterval)
+	return t.C, t.Stop
+}
+
+// maxWatchRetryBackoff is the longest that reconciling waits before it tries again to watch a
+// directory that couldn't be watched, such as when the limit of inotify watches is reached.
+const maxWatchRetryBackoff = time.Hour
+
+// watchRetry is when a directory that couldn't be watched is tried again.
+type watchRetry struct {
+	backoff time.Duration
+	at      time.Time
+}
+
+// reconcileWatches walks the watch directory, and compares its directories against the watched
+// directories, see applyReconcile.
+func (w *StableFileWatcher) reconcileWatches() (added, removed []string) {
+	found, ok := w.findDirs()
+	if !ok {
+		return nil, nil
+	}
+	return w.applyReconcile(found, time.Now())
+}
+
+// startReconcile walks the watch directory off the event loop, sending the directories that it
+// found on the channel once it is done, or nil when the watch directory is gone, see findDirs.
+func (w *StableFileWatcher) startReconcile(results chan<- map[string]bool) {
+	go func() {
+		found, _ := w.findDirs()
+		results <- found
+	}()
+}
+
+// findDirs walks the watch directory, returning every directory in it. It isn't walked while the
+// watch directory is gone, which is watched again once it comes back, see rewatch.
+func (w *StableFileWatcher) findDirs() (map[string]bool, bool) {
+	if _, err := os.Stat(w.watchDir); err != nil {
+		return nil, false
+	}
+
+	found := make(map[string]bool)
+	walkLinkedDir(w.watchDir, func(path string, item os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if item.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil {
+				item = target
+			}
+		}
+		if item.IsDir() {
+			found[path] = true
+		}
+		return nil
+	})
+	return found, true
+}
+
+// applyReconcile compares the directories found in the watch directory against the watched
+// directories, in case the events of a directory were missed, such as when directories are created
+// and removed faster than they are watched. A directory that isn't watched is watched, and its files
+// are scheduled, since their events were missed too. A directory that can't be watched is tried again
+// on a later reconcile, backing off up to maxWatchRetryBackoff. A watched directory that is gone is no
+// longer watched. Watching a directory that's already watched, or scheduling a file that is already
+// waited on, has no effect, so reconciling the watches again changes nothing. Only called from the
+// event loop, which is the only user of watchRetries.
+func (w *StableFileWatcher) applyReconcile(found map[string]bool, now time.Time) (added, removed []string) {
+	watched := make(map[string]bool)
+	for _, dir := range w.WatchedDirs() {
+		watched[dir] = true
+	}
+
+	var missed []string
+	for dir := range found {
+		if !watched[dir] {
+			missed = append(missed, dir)
+		}
+	}
+	sort.Strings(missed)
+
+	for dir := range watched {
+		// A directory created while the watch directory was walked is kept
+		if _, err := os.Stat(dir); !found[dir] && os.IsNotExist(err) {
+			w.removeWatch(dir)
+			removed = append(removed, dir)
+		}
+	}
+	sort.Strings(removed)
+
+	if w.watchRetries == nil {
+		w.watchRetries = make(map[string]watchRetry)
+	}
+	for dir := range w.watchRetries {
+		if !found[dir] {
+			delete(w.watchRetries, dir)
+		}
+	}
+
+	for _, dir := range missed {
+		retry, failed := w.watchRetries[dir]
+		if failed && now.Before(retry.at) {
+			continue
+		}
+		err := w.addWatch(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			retry.backoff = nextWatchRetryBackoff(retry.backoff, w.WatchReconcileInterval)
+			retry.at = now.Add(retry.backoff)
+			w.watchRetries[dir] = retry
+			log.Println(errors.Wrapf(err, "unable to watch the missed directory %s, trying again in %s", dir, retry.backoff))
+			continue
+		}
+		delete(w.watchRetries, dir)
+		added = append(added, dir)
+		for _, path := range w.walkDir(dir, w.matches) {
+			w.schedule(path)
+		}
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("reconciled the watches of %s, watching %d missed directories and no longer watching %d removed directories\n",
+			w.watchDir, len(added), len(removed))
+	}
+	return added, removed
+}
+
+// nextWatchRetryBackoff doubles the backoff of a directory that couldn't be watched again, starting
+// at the reconcile interval.
+func nextWatchRetryBackoff(backoff, interval time.Duration) time.Duration {
+	if backoff <= 0 {
+		backoff = interval
+	} else {
+		backoff *= 2
+	}
+	if backoff > maxWatchRetryBackoff {
+		backoff = maxWatchRetryBackoff
+	}
+	return backoff
+}
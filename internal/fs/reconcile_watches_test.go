@@ -0,0 +1,138 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFileWatcher_ReconcileWatches(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithWatchReconcile(0))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	season := filepath.Join(tmpDir, "TV", "Season 1")
+	err = os.MkdirAll(season, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForWatchedDirs(t, w, season, true)
+
+	// Miss the watch of the season, while a video is added to it, and keep the watch of a removed directory
+	w.removeWatch(season)
+	video := filepath.Join(season, "foo.mkv")
+	err = ioutil.WriteFile(video, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	removed := filepath.Join(tmpDir, "Movies")
+	w.mu.Lock()
+	w.watchedDirs[removed] = true
+	w.mu.Unlock()
+
+	gotAdded, gotRemoved := w.reconcileWatches()
+	if fmt.Sprint(gotAdded) != fmt.Sprint([]string{season}) || fmt.Sprint(gotRemoved) != fmt.Sprint([]string{removed}) {
+		t.Fatalf("expected to watch %s and stop watching %s, got %v and %v", season, removed, gotAdded, gotRemoved)
+	}
+	want := fmt.Sprint([]string{tmpDir, filepath.Join(tmpDir, "TV"), season})
+	if got := fmt.Sprint(w.WatchedDirs()); got != want {
+		t.Fatalf("expected the watched directories to be %s, got %s", want, got)
+	}
+
+	gotAdded, gotRemoved = w.reconcileWatches()
+	if len(gotAdded) > 0 || len(gotRemoved) > 0 {
+		t.Fatalf("expected reconciling again to change nothing, got %v and %v", gotAdded, gotRemoved)
+	}
+
+	select {
+	case e := <-w.Events:
+		if e.Path != video {
+			t.Fatalf("expected an event for %s, got %s", video, e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event for the video in the missed directory")
+	}
+}
+
+func TestCopyFileWatcher_ReconcileWatchesRetry(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithWatchReconcile(0))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	season := filepath.Join(tmpDir, "TV", "Season 1")
+	err = os.MkdirAll(season, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForWatchedDirs(t, w, season, true)
+	w.removeWatch(season)
+
+	// The season couldn't be watched on the last reconcile, and isn't tried again until its backoff passes
+	now := time.Now()
+	found, ok := w.findDirs()
+	if !ok || !found[season] {
+		t.Fatalf("expected to find %s, got %v", season, found)
+	}
+	w.watchRetries = map[string]watchRetry{season: {backoff: time.Minute, at: now.Add(time.Minute)}}
+	gotAdded, _ := w.applyReconcile(found, now)
+	if len(gotAdded) > 0 {
+		t.Fatalf("expected the season to not be watched again during its backoff, got %v", gotAdded)
+	}
+
+	gotAdded, _ = w.applyReconcile(found, now.Add(time.Minute))
+	if fmt.Sprint(gotAdded) != fmt.Sprint([]string{season}) {
+		t.Fatalf("expected the season to be watched once its backoff passed, got %v", gotAdded)
+	}
+	if _, ok := w.watchRetries[season]; ok {
+		t.Fatalf("expected the retry of the season to be cleared once it is watched, got %v", w.watchRetries)
+	}
+}
+
+func TestNextWatchRetryBackoff(t *testing.T) {
+	backoff := nextWatchRetryBackoff(0, 5*time.Minute)
+	if backoff != 5*time.Minute {
+		t.Fatalf("expected the first backoff to be the reconcile interval, got %s", backoff)
+	}
+	backoff = nextWatchRetryBackoff(backoff, 5*time.Minute)
+	if backoff != 10*time.Minute {
+		t.Fatalf("expected the backoff to double, got %s", backoff)
+	}
+	backoff = nextWatchRetryBackoff(40*time.Minute, 5*time.Minute)
+	if backoff != maxWatchRetryBackoff {
+		t.Fatalf("expected the backoff to be at most %s, got %s", maxWatchRetryBackoff, backoff)
+	}
+}
+
+func TestWithWatchReconcile_Invalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, err = NewStableFileWatcher(tmpDir, testStableThreshold, WithWatchReconcile(-time.Minute))
+	if err == nil {
+		t.Fatal("expected a negative watch reconcile interval to be rejected")
+	}
+}
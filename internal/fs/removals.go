@@ -0,0 +1,61 @@
+package fs
+
+import (
+	"log"
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// WithRemovals signals the files, and directories, that are removed or renamed away from the
+// watch directory on Removals, which holds up to buffer removals. Removals are dropped when it
+// is full, so that they never block watching. The removals include the files that the consumer
+// moves out of the watch directory itself, such as when it claims a file to process it.
+func WithRemovals(buffer int) Option {
+	return func(w *StableFileWatcher) error {
+		if buffer < 1 {
+			return errors.Errorf("invalid removals buffer %d, must be at least 1", buffer)
+		}
+		w.Removals = make(chan FileEvent, buffer)
+		return nil
+	}
+}
+
+// Removed signals the files that were removed from the watch directory, see WithRemovals.
+// It is nil when removals aren't signaled, and is closed when the watcher is closed.
+func (w *StableFileWatcher) Removed() <-chan FileEvent {
+	return w.Removals
+}
+
+// signalRemoval signals that the file, or the watched directory, of the event was removed or
+// renamed away, after any wait for it to be stable was canceled. A file that was already replaced
+// by another at the same path, such as by an atomic rename, wasn't removed.
+func (w *StableFileWatcher) signalRemoval(e fsnotify.Event) {
+	if w.Removals == nil || e.Op&(fsnotify.Remove|fsnotify.Rename) == 0 {
+		return
+	}
+	if _, err := os.Lstat(e.Name); err == nil {
+		return
+	}
+
+	w.mu.Lock()
+	isDir := w.watchedDirs[e.Name]
+	w.mu.Unlock()
+	if isDir && (w.isJunk(e.Name) || w.isIgnored(e.Name)) || !isDir && !w.matches(e.Name) {
+		return
+	}
+
+	select {
+	case w.Removals <- FileEvent{Path: e.Name, WatchRoot: w.watchDir, IsDir: isDir}:
+	default:
+		log.Printf("dropped the removal of %s, the removals buffer is full\n", e.Name)
+	}
+}
+
+// closeRemovals is called once nothing else can send on the Removals.
+func (w *StableFileWatcher) closeRemovals() {
+	if w.Removals != nil {
+		close(w.Removals)
+	}
+}
@@ -0,0 +1,82 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCopyFileWatcher_Removals(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	season := filepath.Join(watchDir, "TV", "Season 1")
+	err = os.MkdirAll(season, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(watchDir, testStableThreshold, WithRemovals(10))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	waitForWatchedDirs(t, w, season, true)
+
+	// Remove a video while it is still being waited on, and move one out of the watch directory
+	removed := filepath.Join(season, "removed.mkv")
+	moved := filepath.Join(season, "moved.mkv")
+	junk := filepath.Join(season, "sample.mkv")
+	for _, path := range []string{removed, moved, junk} {
+		err = ioutil.WriteFile(path, []byte("foo"), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, path := range []string{removed, junk} {
+		err = os.Remove(path)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+	err = os.Rename(moved, filepath.Join(tmpDir, "moved.mkv"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	got := make(map[string]bool)
+	for len(got) < 2 {
+		select {
+		case r := <-w.Removed():
+			if r.IsDir || r.WatchRoot != watchDir {
+				t.Fatalf("expected a removed file in %s, got %#v", watchDir, r)
+			}
+			got[r.Path] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the removals of %s and %s, got %v", removed, moved, got)
+		}
+	}
+	if !got[removed] || !got[moved] {
+		t.Fatalf("expected the removals of %s and %s, got %v", removed, moved, got)
+	}
+
+	// The waits for the removed videos were canceled, so they are never signaled
+	select {
+	case e := <-w.Events:
+		t.Fatalf("expected the removed videos to not be signaled, got %s", e.Path)
+	case <-time.After(2 * testStableThreshold):
+	}
+
+	w.Close()
+	for r := range w.Removed() {
+		t.Fatalf("expected no other removals, got %#v", r)
+	}
+}
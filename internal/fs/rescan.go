@@ -0,0 +1,45 @@
+package fs
+
+import (
+	"log"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// Rescan replaces the PathRegex, while the watcher is running, and checks the files
+// already in the watch directory against it. Only the files that the new regular
+// expression matches, and the previous one didn't, are scheduled, because the other
+// files were already signaled or are still being processed. An empty pattern matches
+// every file. Returns the number of files that were scheduled.
+func (w *StableFileWatcher) Rescan(pathRegex string) (int, error) {
+	var r *regexp.Regexp
+	if pathRegex != "" {
+		var err error
+		r, err = regexp.Compile(pathRegex)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid path regex %q", pathRegex)
+		}
+	}
+
+	w.mu.Lock()
+	previous := w.PathRegex
+	w.PathRegex = r
+	w.mu.Unlock()
+
+	files := w.walk(func(path string) bool {
+		return matchesRegex(r, path) && !matchesRegex(previous, path)
+	})
+
+	log.Printf("rescanned %s with the path regex %q, found %d newly matching videos\n", w.watchDir, pathRegex, len(files))
+	for _, file := range files {
+		w.schedule(file)
+	}
+	return len(files), nil
+}
+
+// matchesRegex determines if the full path matches, using forward slashes. A nil regex matches every path.
+func matchesRegex(r *regexp.Regexp, path string) bool {
+	return r == nil || r.MatchString(filepath.ToSlash(path))
+}
@@ -0,0 +1,112 @@
+package fs
+
+import (
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Backoff is how long to wait between attempts, doubling from Initial up to Max,
+// each varied randomly by up to the Jitter fraction, so that retries don't hammer
+// a struggling server in lockstep.
+type Backoff struct {
+	Initial, Max time.Duration
+	Jitter       float64
+}
+
+// DefaultRewatchBackoff is how often the watcher tries to watch the watch directory
+// again after it disappears, such as when a network mount drops.
+var DefaultRewatchBackoff = Backoff{Initial: time.Second, Max: 5 * time.Minute, Jitter: 0.2}
+
+// Validate checks that the backoff has a positive initial delay, at most the
+// maximum delay, and a jitter between 0 and 1.
+func (b Backoff) Validate() error {
+	if b.Initial <= 0 || b.Max < b.Initial {
+		return errors.Errorf("invalid backoff from %s to %s, the initial delay must be positive and at most the maximum", b.Initial, b.Max)
+	}
+	if b.Jitter < 0 || b.Jitter > 1 {
+		return errors.Errorf("invalid backoff jitter %v, must be between 0 and 1", b.Jitter)
+	}
+	return nil
+}
+
+// Next returns the delay after the delay, doubling it up to the maximum.
+func (b Backoff) Next(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// jitter randomly varies the delay by up to the Jitter fraction.
+func (b Backoff) jitter(r *rand.Rand, delay time.Duration) time.Duration {
+	if b.Jitter == 0 {
+		return delay
+	}
+	return delay + time.Duration((r.Float64()*2-1)*b.Jitter*float64(delay))
+}
+
+// WithRewatchBackoff overrides how often the watcher tries to watch the watch
+// directory again after it disappears, which defaults to DefaultRewatchBackoff.
+func WithRewatchBackoff(b Backoff) Option {
+	return func(w *StableFileWatcher) error {
+		err := b.Validate()
+		if err != nil {
+			return err
+		}
+		w.RewatchBackoff = b
+		return nil
+	}
+}
+
+// rewatch waits for the watch directory to come back after it disappeared, retrying
+// with the RewatchBackoff, and then checks the files that are in it. Returns false
+// when the watcher is closed first.
+func (w *StableFileWatcher) rewatch() bool {
+	log.Printf("the watch directory %s disappeared, waiting for it to come back\n", w.watchDir)
+	w.removeWatch(w.watchDir)
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	lostAt := time.Now()
+	delay := w.RewatchBackoff.Initial
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-w.done:
+			return false
+		case <-time.After(w.RewatchBackoff.jitter(r, delay)):
+		}
+
+		files, err := w.watchAgain()
+		if err != nil {
+			delay = w.RewatchBackoff.Next(delay)
+			log.Printf("attempt %d to watch %s again failed, retrying in about %s: %s\n", attempt, w.watchDir, delay, err)
+			continue
+		}
+
+		log.Printf("recovered the watch directory %s after %d attempts over %s\n",
+			w.watchDir, attempt, time.Since(lostAt).Round(time.Second))
+		w.scheduleExisting(files)
+		return true
+	}
+}
+
+// watchAgain starts watching the watch directory again, returning the files in it.
+func (w *StableFileWatcher) watchAgain() ([]string, error) {
+	info, err := os.Stat(w.watchDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", w.watchDir)
+	}
+
+	err = w.addWatch(w.watchDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to start watching %s", w.watchDir)
+	}
+	return w.readFiles(false)
+}
@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Sandbox is a set of allowed root directories. File operations on paths outside of the
+// roots are refused, guarding against a misconfigured path moving, removing or overwriting
+// files anywhere on disk. Symbolic links are resolved, so a link can't escape the roots.
+// A nil Sandbox allows every path.
+type Sandbox struct {
+	roots []string
+}
+
+// WithSandbox refuses to move the files that are quarantined, such as by the UnstableQuarantine
+// policy, when the file or its quarantined path is outside of the sandbox.
+func WithSandbox(s *Sandbox) Option {
+	return func(w *StableFileWatcher) error {
+		w.Sandbox = s
+		return nil
+	}
+}
+
+// OutsideSandboxError is returned for a file operation on a path outside of the sandbox.
+type OutsideSandboxError struct {
+	Path  string
+	Roots []string
+}
+
+func (e OutsideSandboxError) Error() string {
+	return "refusing to modify " + e.Path + ", it is outside of the allowed directories " + strings.Join(e.Roots, ", ")
+}
+
+// NewSandbox allows the file operations in the root directories, and their subdirectories.
+func NewSandbox(roots ...string) (*Sandbox, error) {
+	s := &Sandbox{}
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to resolve the absolute path of the allowed directory %s", root)
+		}
+		s.roots = append(s.roots, resolveExisting(abs))
+	}
+	if len(s.roots) == 0 {
+		return nil, errors.New("a sandbox must have at least one allowed directory")
+	}
+	return s, nil
+}
+
+// Roots are the allowed directories.
+func (s *Sandbox) Roots() []string {
+	if s == nil {
+		return nil
+	}
+	return s.roots
+}
+
+// Check returns an OutsideSandboxError when the path is outside of the allowed directories.
+func (s *Sandbox) Check(path string) error {
+	if s == nil {
+		return nil
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to resolve the absolute path of %s", path)
+	}
+	resolved := resolveExisting(abs)
+	for _, root := range s.roots {
+		if resolved == root || strings.HasPrefix(resolved, root+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return OutsideSandboxError{Path: path, Roots: s.roots}
+}
+
+// CopyFile copies the source path to the destination path, when the destination is in the sandbox.
+func (s *Sandbox) CopyFile(src, dest string) error {
+	err := s.Check(dest)
+	if err != nil {
+		return err
+	}
+	return CopyFile(src, dest)
+}
+
+// MoveFile moves the source path to the destination path, when both are in the sandbox.
+func (s *Sandbox) MoveFile(src, dest string) error {
+	err := s.Check(src)
+	if err != nil {
+		return err
+	}
+	err = s.Check(dest)
+	if err != nil {
+		return err
+	}
+	return MoveFile(src, dest)
+}
+
+// VerifiedMoveFile moves the source path to the destination path, when both are in the sandbox,
+// only removing the source once the checksums of the copy match. See fs.VerifiedMoveFile.
+func (s *Sandbox) VerifiedMoveFile(src, dest string) error {
+	err := s.Check(src)
+	if err != nil {
+		return err
+	}
+	err = s.Check(dest)
+	if err != nil {
+		return err
+	}
+	return VerifiedMoveFile(src, dest)
+}
+
+// Remove the path, when it is in the sandbox. A directory is removed along with everything in it.
+func (s *Sandbox) Remove(path string) error {
+	err := s.Check(path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return os.RemoveAll(path)
+	}
+	return os.Remove(path)
+}
+
+// resolveExisting resolves the symbolic links in the part of the path that exists,
+// so that a path that will be created is resolved along with its parent directories.
+func resolveExisting(path string) string {
+	var rest []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path
+		}
+		rest = append([]string{filepath.Base(dir)}, rest...)
+	}
+}
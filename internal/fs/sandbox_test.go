@@ -0,0 +1,65 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandbox_Check(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	allowed := filepath.Join(tmpDir, "allowed")
+	outside := filepath.Join(tmpDir, "outside")
+	for _, dir := range []string{allowed, outside} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+	err = os.Symlink(outside, filepath.Join(allowed, "link"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	s, err := NewSandbox(allowed)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	testcases := []struct {
+		Name    string
+		Path    string
+		WantErr bool
+	}{
+		{Name: "root", Path: allowed},
+		{Name: "new file", Path: filepath.Join(allowed, "Movies", "foo.mkv")},
+		{Name: "outside", Path: filepath.Join(outside, "foo.mkv"), WantErr: true},
+		{Name: "parent", Path: filepath.Join(allowed, "..", "outside", "foo.mkv"), WantErr: true},
+		{Name: "sibling prefix", Path: allowed + "-other", WantErr: true},
+		{Name: "symlink", Path: filepath.Join(allowed, "link", "foo.mkv"), WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := s.Check(tc.Path)
+			if tc.WantErr {
+				if _, ok := err.(OutsideSandboxError); !ok {
+					t.Fatalf("expected an OutsideSandboxError, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("%+v", err)
+			}
+		})
+	}
+
+	var unrestricted *Sandbox
+	if err := unrestricted.Check(outside); err != nil {
+		t.Fatalf("expected a nil sandbox to allow every path, got %v", err)
+	}
+}
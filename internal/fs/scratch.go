@@ -0,0 +1,156 @@
+package fs
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Scratch manages a directory of temporary files used while processing videos.
+// Every file in the directory belongs to a job in progress, anything else is
+// stale, left behind by a failed run, and is removed.
+type Scratch struct {
+	// Dir holds the temporary files.
+	Dir string
+
+	// Budget is the maximum size in bytes of the temporary files, before new jobs
+	// wait for space to be released. Defaults to 0, which is unlimited.
+	Budget int64
+
+	mu       sync.Mutex
+	inUse    map[string]struct{}
+	released chan struct{}
+}
+
+// scratchPollInterval is how often a job waiting for space checks the usage again,
+// in case the space was freed outside of Release.
+const scratchPollInterval = 5 * time.Second
+
+// NewScratch creates the scratch directory, removing any stale files from a previous run.
+func NewScratch(dir string, budget int64) (*Scratch, error) {
+	if budget < 0 {
+		return nil, errors.Errorf("invalid scratch budget %d, must not be negative", budget)
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to resolve the absolute path of the scratch directory %s", dir)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create the scratch directory %s", dir)
+	}
+
+	s := &Scratch{
+		Dir:      dir,
+		Budget:   budget,
+		inUse:    make(map[string]struct{}),
+		released: make(chan struct{}),
+	}
+	s.Clean()
+	return s, nil
+}
+
+// Acquire waits until the scratch usage is under the budget, and then returns the path
+// for a temporary file, relative to the scratch directory, which is kept until it is released.
+func (s *Scratch) Acquire(ctx context.Context, name string) (string, error) {
+	path := filepath.Join(s.Dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, s.Dir+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid scratch file %s, must be in %s", name, s.Dir)
+	}
+
+	for {
+		s.mu.Lock()
+		usage, err := s.usage()
+		if err != nil {
+			s.mu.Unlock()
+			return "", err
+		}
+		if s.Budget == 0 || usage < s.Budget {
+			s.inUse[path] = struct{}{}
+			s.mu.Unlock()
+
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+			if err != nil {
+				s.Release(path)
+				return "", errors.Wrapf(err, "unable to create directory %s", filepath.Dir(path))
+			}
+			return path, nil
+		}
+		released := s.released
+		s.mu.Unlock()
+
+		log.Printf("scratch usage %d is over the budget %d, waiting to create %s\n", usage, s.Budget, name)
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrapf(ctx.Err(), "gave up waiting for scratch space for %s", name)
+		case <-released:
+		case <-time.After(scratchPollInterval):
+		}
+	}
+}
+
+// Release removes a temporary file, along with any stale files, waking the jobs waiting for space.
+func (s *Scratch) Release(path string) {
+	s.mu.Lock()
+	delete(s.inUse, path)
+	close(s.released)
+	s.released = make(chan struct{})
+	s.mu.Unlock()
+
+	s.Clean()
+}
+
+// Clean removes the files in the scratch directory that aren't in use.
+func (s *Scratch) Clean() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if _, ok := s.inUse[path]; ok {
+			return nil
+		}
+		log.Printf("removing stale scratch file %s\n", path)
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(errors.Wrapf(err, "unable to remove %s", path))
+		}
+		return nil
+	})
+}
+
+// Usage is the total size in bytes of the files in the scratch directory.
+func (s *Scratch) Usage() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.usage()
+}
+
+func (s *Scratch) usage() (int64, error) {
+	var total int64
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to measure the scratch usage in %s", s.Dir)
+	}
+	return total, nil
+}
@@ -0,0 +1,107 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewScratch_RemovesStaleFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	stale := filepath.Join(tmpDir, "Movies", "foo.mkv")
+	os.MkdirAll(filepath.Dir(stale), 0755)
+	ioutil.WriteFile(stale, []byte("foo"), 0644)
+
+	_, err = NewScratch(tmpDir, 0)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale file to be removed, got %#v", err)
+	}
+}
+
+func TestScratch_Budget(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewScratch(tmpDir, 3)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	first, err := s.Acquire(context.Background(), "Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	ioutil.WriteFile(first, []byte("foo"), 0644)
+
+	// The budget is used up, so the next file must wait
+	acquired := make(chan string)
+	go func() {
+		path, err := s.Acquire(context.Background(), "Movies/bar.mkv")
+		if err != nil {
+			t.Errorf("%#v", err)
+		}
+		acquired <- path
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected the next file to wait for space")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.Release(first)
+	select {
+	case path := <-acquired:
+		if path != filepath.Join(tmpDir, "Movies", "bar.mkv") {
+			t.Fatalf("unexpected scratch path %s", path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the next file to be acquired once space was released")
+	}
+
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("expected the released file to be removed, got %#v", err)
+	}
+}
+
+func TestScratch_Canceled(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s, err := NewScratch(tmpDir, 1)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	first, _ := s.Acquire(context.Background(), "foo.mkv")
+	ioutil.WriteFile(first, []byte("foo"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Acquire(ctx, "bar.mkv")
+	if err == nil {
+		t.Fatal("expected waiting for space to be canceled")
+	}
+
+	_, err = s.Acquire(context.Background(), "../bar.mkv")
+	if err == nil {
+		t.Fatal("expected a file outside of the scratch directory to be rejected")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package fs
+
+import (
+	"github.com/pkg/errors"
+)
+
+// FreeSpace is not supported on this platform.
+func FreeSpace(path string) (int64, error) {
+	return 0, errors.Errorf("unable to read the free space of %s, not supported on this platform", path)
+}
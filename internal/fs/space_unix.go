@@ -0,0 +1,20 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package fs
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// FreeSpace returns the bytes available to an unprivileged user on the file system containing the path.
+func FreeSpace(path string) (int64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to read the free space of %s", path)
+	}
+	return int64(uint64(stat.Bavail) * uint64(stat.Bsize)), nil
+}
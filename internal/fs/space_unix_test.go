@@ -0,0 +1,19 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package fs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(os.TempDir())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if free <= 0 {
+		t.Fatalf("expected the temp directory to have free space, got %d", free)
+	}
+}
@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCopyFileWatcher_SpecialFiles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = syscall.Mkfifo(filepath.Join(tmpDir, "existing.pipe"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var mu sync.Mutex
+	var gotEvents []string
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			mu.Lock()
+			gotEvents = append(gotEvents, filepath.Base(e.Path))
+			mu.Unlock()
+		}
+		done <- true
+	}()
+
+	err = syscall.Mkfifo(filepath.Join(tmpDir, "new.pipe"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	time.Sleep(w.StableThreshold * 2)
+	w.Close()
+	<-done
+
+	if want := []string{"foo.mkv"}; fmt.Sprint(gotEvents) != fmt.Sprint(want) {
+		t.Fatalf("expected events for %v, got %v", want, gotEvents)
+	}
+
+	rejected := make(map[string]RejectReason)
+	for len(w.Rejected) > 0 {
+		r := <-w.Rejected
+		rejected[filepath.Base(r.Path)] = r.Reason
+	}
+	for _, pipe := range []string{"existing.pipe", "new.pipe"} {
+		if rejected[pipe] != RejectSpecialFile {
+			t.Fatalf("expected %s to be rejected as a special file, got %v", pipe, rejected)
+		}
+	}
+}
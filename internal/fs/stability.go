@@ -0,0 +1,178 @@
+package fs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StabilityMode is how a StableFileWatcher decides that a file is completely written.
+// It may be used as a flag.
+type StabilityMode string
+
+const (
+	// StabilityEvents waits until no file system events are received for the file.
+	StabilityEvents StabilityMode = "events"
+
+	// StabilitySize polls the size and modification time of the file, waiting
+	// until they stop changing and the file is no longer in use. Used by default
+	// on Windows, where a file being written is often locked and its events are unreliable.
+	StabilitySize StabilityMode = "size"
+
+	// StabilityCloseWrite is StabilityEvents, except that the file is signaled as soon
+	// as its writer closes it. A file that was closed before it was watched is signaled
+	// once no events are received for it, as in StabilityEvents. Only supported on Linux.
+	StabilityCloseWrite StabilityMode = "close-write"
+
+	// StabilityHybrid is StabilityEvents, except that the file is also statted with each
+	// event, and once no events are received for it, so that it is only stable once its
+	// events stopped and its size and modification time didn't change for the threshold.
+	// This catches the writes whose events were coalesced, and the rate of the writes is logged.
+	StabilityHybrid StabilityMode = "hybrid"
+)
+
+// String returns the name of the mode.
+func (m *StabilityMode) String() string {
+	if m == nil {
+		return ""
+	}
+	return string(*m)
+}
+
+// Set validates the name of the mode.
+func (m *StabilityMode) Set(value string) error {
+	mode := StabilityMode(value)
+	if mode != StabilityEvents && mode != StabilitySize && mode != StabilityCloseWrite && mode != StabilityHybrid {
+		return errors.Errorf("invalid stability mode %q, must be %s, %s, %s or %s", value, StabilityEvents, StabilitySize, StabilityCloseWrite, StabilityHybrid)
+	}
+	if mode == StabilityCloseWrite && !closeWriteSupported {
+		return errors.Errorf("the %s stability mode is only supported on Linux", StabilityCloseWrite)
+	}
+	*m = mode
+	return nil
+}
+
+// WithStabilityMode overrides how the watcher decides that a file is completely written,
+// which defaults to DefaultStabilityMode.
+func WithStabilityMode(mode StabilityMode) Option {
+	return func(w *StableFileWatcher) error {
+		if mode == "" {
+			return nil
+		}
+		err := mode.Set(string(mode))
+		if err != nil {
+			return err
+		}
+		w.StabilityMode = mode
+		return nil
+	}
+}
+
+// sizePollInterval is the longest time between checks of a file's size, in StabilitySize mode.
+var sizePollInterval = time.Second
+
+// pollUntilStable waits until the size and modification time of the file haven't
+// changed for the threshold, and the file is no longer in use, or the wait expires. Returns
+// stabilizeStopped, after untracking the file when necessary, when the file won't be signaled,
+// and stabilizeStalled when the file stopped changing while it is incomplete, see WithStallDetection.
+func (w *StableFileWatcher) pollUntilStable(path string, threshold time.Duration, canceled <-chan struct{}, expired <-chan time.Time, untrack func()) stabilizeResult {
+	interval := threshold
+	if interval > sizePollInterval {
+		interval = sizePollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last os.FileInfo
+	var unchangedSince time.Time
+	var grew growth
+	for {
+		select {
+		case <-w.done:
+			untrack()
+			return stabilizeStopped
+		case <-canceled:
+			log.Printf("%s was removed while waiting for it to be stable\n", path)
+			return stabilizeStopped
+		case <-expired:
+			return stabilizeExpired
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				untrack()
+				log.Printf("%s was removed while waiting for it to be stable\n", path)
+				return stabilizeStopped
+			}
+			if err != nil && !isFileInUse(err) {
+				untrack()
+				log.Println(errors.Wrapf(err, "unable to stat %s, skipping", path))
+				w.reject(path, RejectUnreadable)
+				return stabilizeStopped
+			}
+
+			// Start the wait over again, the file was changed or is locked by the writer
+			now := time.Now()
+			if err == nil {
+				grew.observe(info.Size(), now)
+			}
+			if err != nil || last == nil || info.Size() != last.Size() || !info.ModTime().Equal(last.ModTime()) {
+				last, unchangedSince = info, now
+				continue
+			}
+			if now.Sub(unchangedSince) < threshold || fileInUse(path) {
+				continue
+			}
+			if w.stillOpen(path) {
+				unchangedSince = now
+				continue
+			}
+			if reason, ok := w.incomplete(path, info.Size()); ok {
+				log.Printf("%s stopped growing for %s, but %s, after it %s\n", path, threshold, reason, grew.String())
+				return stabilizeStalled
+			}
+			return stabilizeStable
+		}
+	}
+}
+
+// writeStats are the writes to a file observed in StabilityHybrid mode, by statting it.
+type writeStats struct {
+	events    int
+	startedAt time.Time
+	startSize int64
+	size      int64
+	modTime   time.Time
+}
+
+// newWriteStats starts observing the writes to the file.
+func newWriteStats(path string) *writeStats {
+	s := &writeStats{startedAt: time.Now()}
+	s.observe(path)
+	s.startSize = s.size
+	return s
+}
+
+// observe stats the file, returning true when its size or modification time changed since
+// it was last observed. A file that can't be statted is left to its events.
+func (s *writeStats) observe(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	changed := info.Size() != s.size || !info.ModTime().Equal(s.modTime)
+	s.size, s.modTime = info.Size(), info.ModTime()
+	return changed
+}
+
+// String summarizes the writes, such as "12 events, 1048576 bytes at 524288 bytes/s".
+func (s *writeStats) String() string {
+	written := s.size - s.startSize
+	if written < 0 {
+		written = 0
+	}
+	rate := float64(written) / time.Since(s.startedAt).Seconds()
+	return fmt.Sprintf("%d events, %d bytes at %.0f bytes/s", s.events, written, rate)
+}
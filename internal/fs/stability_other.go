@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package fs
+
+// DefaultStabilityMode is how a StableFileWatcher decides that a file is completely written on this platform.
+const DefaultStabilityMode = StabilityEvents
+
+// isFileInUse determines if the error is because another process has the file locked.
+// Files can't be locked against reading on this platform.
+func isFileInUse(err error) bool {
+	return false
+}
+
+// fileInUse determines if another process has the file locked.
+func fileInUse(path string) bool {
+	return false
+}
@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package fs
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultStabilityMode is how a StableFileWatcher decides that a file is completely written on this platform.
+// A file that is being copied is locked, and only stops changing size once the copy is done.
+const DefaultStabilityMode = StabilitySize
+
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// isFileInUse determines if the error is because another process has the file locked.
+func isFileInUse(err error) bool {
+	if pathErr, ok := errors.Cause(err).(*os.PathError); ok {
+		err = pathErr.Err
+	}
+	return err == errorSharingViolation || err == errorLockViolation
+}
+
+// fileInUse determines if another process has the file locked, such as
+// while it's being copied into the watch directory.
+func fileInUse(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return isFileInUse(err)
+	}
+	f.Close()
+	return false
+}
@@ -2,8 +2,11 @@ package fs
 
 import (
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
@@ -13,36 +16,358 @@ import (
 // StableFile watches for new files, waiting for the file to be completely
 // written before signaling an event.
 type StableFileWatcher struct {
-	watchDir      string
-	dirWatcher    *fsnotify.Watcher
-	done          chan struct{}
-	unstableFiles map[string]struct{}
+	watchDir   string
+	dirWatcher *fsnotify.Watcher
+
+	// watchFile is the file that is watched for replacement, instead of the files in the
+	// watch directory, which is its directory, see NewStableFileWatcher.
+	watchFile string
+	done      chan struct{}
+
+	// mu protects unstableFiles, signaledFiles, linkedFiles, heldFiles, stalledFiles, paused, deferredFiles, watchedDirs,
+	// skippedJunk, stopped, StableThreshold once the watcher is started, and PathRegex, which may be replaced by Rescan
+	mu            sync.Mutex
+	watchedDirs   map[string]bool
+	skippedJunk   map[string]bool
+	unstableFiles map[string]chan struct{}
+	signaledFiles map[string]signaledFile
+	linkedFiles   map[fileID]string
+	heldFiles     map[string]bool
+	stalledFiles  map[string]int64
+	paused        bool
+	deferredFiles []string
+
+	// stopped is set once Events is being closed, after which no more waits are started, see closeEvents.
+	stopped bool
+
+	// waits tracks the goroutines waiting for a file to be stable, so that
+	// Events is only closed once nothing else can send on it
+	waits sync.WaitGroup
 
 	// StableThreshold is the duration that a file must not change
-	// before a signaling an event for the file.
+	// before a signaling an event for the file. Once the watcher is started,
+	// change it with SetStableThreshold.
 	StableThreshold time.Duration
 
+	// StabilityMode is how the watcher decides that a file is completely written.
+	// Defaults to DefaultStabilityMode.
+	StabilityMode StabilityMode
+
+	// CheckOpenWriters confirms that no process has a file open for writing once it is stable,
+	// see WithOpenWriterCheck.
+	CheckOpenWriters bool
+
+	// WatchReconcileInterval is how often the watched directories are reconciled with the
+	// directories in the watch directory, see WithWatchReconcile. Defaults to DefaultWatchReconcileInterval.
+	WatchReconcileInterval time.Duration
+
+	// watchRetries are the missed directories that couldn't be watched when the watches were
+	// reconciled, and when they are tried again. Only used by the event loop.
+	watchRetries map[string]watchRetry
+
+	// RewatchBackoff is how often to try watching the watch directory again
+	// after it disappears. Defaults to DefaultRewatchBackoff.
+	RewatchBackoff Backoff
+
+	// Cooldown is the duration after an event is signaled for a file, that
+	// the file is ignored unless its modification time has advanced.
+	// Defaults to 0, which disables the cooldown.
+	Cooldown time.Duration
+
+	// StartupJitter randomly delays checking each file that is already in the watch
+	// directory by up to this long, so that a large backlog isn't signaled all at once.
+	// Defaults to 0, which checks every existing file immediately.
+	StartupJitter time.Duration
+
+	// MaxConcurrentWaits limits how many files are waited on at once, once the watcher
+	// started, see WithMaxConcurrentWaits. InitialScanConcurrency limits how many of the
+	// files that were already in the watch directory are waited on at once, separately.
+	// Defaults to 0, which doesn't limit them.
+	MaxConcurrentWaits, InitialScanConcurrency int
+
+	// waitSlots and initialScanSlots hold a slot for each file that is waited on, when limited.
+	waitSlots, initialScanSlots chan struct{}
+
+	// initialScanDone is closed once none of the files that were already in the watch directory
+	// on startup are pending, see InitialScanDone. initialPending is protected by mu.
+	initialScanDone chan struct{}
+	initialPending  map[string]bool
+
+	// InitialIgnore are the absolute paths of files that are already in the watch directory,
+	// such as files still being produced by another process, that are not checked when the
+	// watcher starts. Their events after the watcher starts are handled as usual.
+	InitialIgnore []string
+
+	// MaxStabilizeWait is how long to wait for a file to be stable, before it is handled
+	// with the UnstablePolicy instead, see WithMaxStabilizeWait. Defaults to 0, which
+	// waits for as long as the file keeps changing.
+	MaxStabilizeWait time.Duration
+	UnstablePolicy   UnstablePolicy
+
+	// QuarantineDir is where the UnstableQuarantine policy moves the files, see WithQuarantineDir.
+	QuarantineDir string
+
+	// Sandbox optionally refuses to quarantine a file outside of its allowed directories, see WithSandbox.
+	Sandbox *Sandbox
+
+	// QuarantineEmpty moves the files that are still empty once they stop changing to the
+	// QuarantineDir, see WithQuarantineEmpty. Empty files are never signaled.
+	QuarantineEmpty bool
+
+	// OnHold is optionally called with each file held by the UnstableHold policy, to alert
+	// that it needs a manual review.
+	OnHold func(path string)
+
+	// DetectStalls holds the files that stop changing while they are clearly incomplete, in
+	// StabilitySize mode, instead of signaling them, see WithStallDetection. ExpectedSizeXattr
+	// is the optional extended attribute with the size that a file must reach, and CheckComplete
+	// optionally returns why a file is incomplete, or nil when it is complete or can't be checked.
+	DetectStalls      bool
+	ExpectedSizeXattr string
+	CheckComplete     func(path string) error
+
+	// OnStall is optionally called with each file held as a stalled copy, and why it is incomplete.
+	OnStall func(path, reason string)
+
+	// EmitTimeout is how long to wait for the consumer to receive the event of a stable
+	// file, before the file is rejected instead. Defaults to 0, which waits until the
+	// watcher is closed.
+	EmitTimeout time.Duration
+
+	// DedupeHardLinks skips a file that is a hard link to a file that was already signaled.
+	// Defaults to false.
+	DedupeHardLinks bool
+
+	// WatchDirectories signals each directory at the DirectoryDepth in the watch directory
+	// as a single event, instead of the files in it, see WithDirectoryEvents. Defaults to false.
+	WatchDirectories bool
+	DirectoryDepth   int
+
+	// PathRegex optionally limits the events to files whose full path matches.
+	// Defaults to nil, which watches every file.
+	PathRegex *regexp.Regexp
+
+	// JunkPatterns are the names of the files, and directories, that are never signaled,
+	// regardless of the PathRegex, see WithJunkPatterns. Defaults to DefaultJunkPatterns.
+	JunkPatterns []string
+
+	// IgnoredDirs are the absolute paths of the directories whose files are never signaled,
+	// such as where the watcher writes its own files, see WithIgnoredDirs.
+	IgnoredDirs []string
+
+	// Prober optionally reads the metadata of each file before signaling its event.
+	// Defaults to nil, which disables probing.
+	Prober Prober
+
 	// Events signal when a file has stabilized.
 	Events chan FileEvent
+
+	// Rejected signals when a file was skipped instead of signaled. Rejections
+	// are dropped when the channel is full, so they never block watching.
+	Rejected chan RejectedFile
+
+	// Removals optionally signals when a file was removed from the watch directory, see WithRemovals.
+	Removals chan FileEvent
 }
 
 // FileEvent signals that a file is in the watch directory is ready to be
 // processed.
 type FileEvent struct {
+	// Path to the file, under the watch directory as it was given. When the watch directory,
+	// or a directory in it, is a symbolic link, the path is under the link, not its target.
+	Path string
+
+	// WatchRoot is the watch directory that the file was found under, so that it can be
+	// routed without matching the prefix of its path. When a single file is watched, it
+	// is the directory of the file.
+	WatchRoot string
+
+	// IsDir is true when the path is a directory that is signaled as a single event,
+	// or a watched directory that was removed, see WithRemovals.
+	IsDir bool
+
+	// Metadata of the video, when probing is enabled and the file could be probed.
+	Metadata *Metadata
+
+	// DetectedAt is when the file was first found, and StableAt is when it stopped changing.
+	DetectedAt, StableAt time.Time
+
+	// Size of the file, or of every file in the directory, once it was stable. 0 when unknown.
+	Size int64
+
+	// Subtitles are the stable external subtitle files next to the video, when the
+	// video watcher groups the videos with their subtitles.
+	Subtitles []string
+}
+
+// RejectReason explains why a file was skipped.
+type RejectReason string
+
+const (
+	// RejectUnreadable is a file that could not be watched or read.
+	RejectUnreadable RejectReason = "unreadable"
+
+	// RejectCooldown is a file that was signaled recently and has not changed.
+	RejectCooldown RejectReason = "cooldown"
+
+	// RejectEmitTimeout is a stable file whose event was not received within the EmitTimeout.
+	RejectEmitTimeout RejectReason = "emit-timeout"
+
+	// RejectSpecialFile is a named pipe, socket, device or other file that isn't a regular file.
+	RejectSpecialFile RejectReason = "special-file"
+)
+
+// RejectedFile signals that a file was skipped.
+type RejectedFile struct {
 	// Path to the file
 	Path string
+
+	// Reason the file was skipped
+	Reason RejectReason
+}
+
+// signaledFile records when an event was signaled for a file.
+type signaledFile struct {
+	signaledAt time.Time
+	modTime    time.Time
+}
+
+// Option configures a StableFileWatcher before it starts watching.
+type Option func(w *StableFileWatcher) error
+
+// WithCooldown ignores a file for the duration after an event is signaled for it,
+// for example when a sync tool deletes and recreates the same file several times.
+// The file is not ignored if its modification time has advanced since the event.
+func WithCooldown(cooldown time.Duration) Option {
+	return func(w *StableFileWatcher) error {
+		if cooldown < 0 {
+			return errors.Errorf("invalid cooldown %s, must not be negative", cooldown)
+		}
+		w.Cooldown = cooldown
+		return nil
+	}
+}
+
+// WithStartupJitter spreads out checking the files that are already in the watch
+// directory, delaying each one randomly by up to the jitter.
+func WithStartupJitter(jitter time.Duration) Option {
+	return func(w *StableFileWatcher) error {
+		if jitter < 0 {
+			return errors.Errorf("invalid startup jitter %s, must not be negative", jitter)
+		}
+		w.StartupJitter = jitter
+		return nil
+	}
+}
+
+// WithInitialIgnore skips the files at the paths, which are relative to the watch directory
+// unless they are absolute, when they are already in the watch directory on startup.
+func WithInitialIgnore(paths ...string) Option {
+	return func(w *StableFileWatcher) error {
+		for _, path := range paths {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(w.watchDir, path)
+			}
+			w.InitialIgnore = append(w.InitialIgnore, filepath.Clean(path))
+		}
+		return nil
+	}
+}
+
+// WithEmitTimeout rejects a stable file, instead of waiting for the consumer to receive
+// its event, when the event isn't received within the timeout.
+func WithEmitTimeout(timeout time.Duration) Option {
+	return func(w *StableFileWatcher) error {
+		if timeout < 0 {
+			return errors.Errorf("invalid emit timeout %s, must not be negative", timeout)
+		}
+		w.EmitTimeout = timeout
+		return nil
+	}
 }
 
-// NewStableFileWatcher watcher for a directory.
-func NewStableFileWatcher(watchDir string, stableThreshold time.Duration) (*StableFileWatcher, error) {
+// WithProber reads the metadata of each file with the prober, such as ffprobe, including it in
+// the event. Probing may run a process for each file, so it is disabled by default.
+func WithProber(p Prober) Option {
+	return func(w *StableFileWatcher) error {
+		w.Prober = p
+		return nil
+	}
+}
+
+// WithPathRegex only signals files whose full path matches the regular
+// expression, for example `.*/Season \d+/.*\.mkv`. The path always uses
+// forward slashes, even on Windows.
+func WithPathRegex(pattern string) Option {
+	return func(w *StableFileWatcher) error {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.Wrapf(err, "invalid path regex %q", pattern)
+		}
+		w.PathRegex = r
+		return nil
+	}
+}
+
+// NewStableFileWatcher watcher for a directory. A relative watch directory is
+// resolved to an absolute path, which is used for the paths in events. A watch directory
+// that is a symbolic link, such as a stable link to a mounted share, is watched through
+// the link, and the paths in events stay under the link. When the watch directory is a
+// file instead, only that file is watched, and it is signaled each time that it is
+// rewritten or replaced, see watchFileEvent.
+func NewStableFileWatcher(watchDir string, stableThreshold time.Duration, opts ...Option) (*StableFileWatcher, error) {
+	watchDir, err := filepath.Abs(watchDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to resolve the absolute path of the watch directory %s", watchDir)
+	}
+	var watchFile string
+	if info, err := os.Stat(watchDir); err == nil && info.Mode().IsRegular() {
+		watchFile, watchDir = watchDir, filepath.Dir(watchDir)
+	}
+	if target, err := filepath.EvalSymlinks(watchDir); err == nil && target != watchDir {
+		log.Printf("watching %s, which links to %s, reporting the files under %s\n", watchDir, target, watchDir)
+	}
+
 	w := &StableFileWatcher{
-		watchDir:        watchDir,
-		done:            make(chan struct{}),
-		unstableFiles:   make(map[string]struct{}),
-		StableThreshold: stableThreshold,
-		Events:          make(chan FileEvent),
+		watchDir:               watchDir,
+		watchFile:              watchFile,
+		done:                   make(chan struct{}),
+		initialScanDone:        make(chan struct{}),
+		unstableFiles:          make(map[string]chan struct{}),
+		signaledFiles:          make(map[string]signaledFile),
+		linkedFiles:            make(map[fileID]string),
+		heldFiles:              make(map[string]bool),
+		stalledFiles:           make(map[string]int64),
+		watchedDirs:            make(map[string]bool),
+		StableThreshold:        stableThreshold,
+		StabilityMode:          DefaultStabilityMode,
+		RewatchBackoff:         DefaultRewatchBackoff,
+		WatchReconcileInterval: DefaultWatchReconcileInterval,
+		JunkPatterns:           DefaultJunkPatterns,
+		Events:                 make(chan FileEvent),
+		Rejected:               make(chan RejectedFile, 100),
 	}
 
+	for _, opt := range opts {
+		err = opt(w)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = w.validateUnstablePolicy()
+	if err != nil {
+		return nil, err
+	}
+	err = w.validateQuarantineEmpty()
+	if err != nil {
+		return nil, err
+	}
+	err = w.validateStallDetection()
+	if err != nil {
+		return nil, err
+	}
+	w.initWaitSlots()
+
 	dw, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to create a file system watcher")
@@ -50,13 +375,13 @@ func NewStableFileWatcher(watchDir string, stableThreshold time.Duration) (*Stab
 	w.dirWatcher = dw
 
 	// Note any preexisting files
-	existingFiles, err := w.readFiles()
+	existingFiles, err := w.readFiles(true)
 	if err != nil {
 		return nil, err
 	}
 
 	// Start watching for new files
-	err = w.dirWatcher.Add(w.watchDir)
+	err = w.addWatch(w.watchDir)
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to start watching %s", watchDir)
 	}
@@ -66,50 +391,344 @@ func NewStableFileWatcher(watchDir string, stableThreshold time.Duration) (*Stab
 	return w, nil
 }
 
-func (w *StableFileWatcher) readFiles() ([]string, error) {
-	var files []string
+// readFiles returns the files that are already in the watch directory, skipping
+// the InitialIgnore files when the watcher is starting.
+func (w *StableFileWatcher) readFiles(starting bool) ([]string, error) {
+	ignore := make(map[string]bool)
+	if starting {
+		for _, path := range w.InitialIgnore {
+			ignore[path] = true
+		}
+	}
+	files := w.walk(func(path string) bool {
+		if ignore[path] {
+			log.Printf("ignoring existing file on startup: %s\n", path)
+			return false
+		}
+		return w.matches(path)
+	})
+	for _, file := range files {
+		log.Printf("found existing video: %s\n", file)
+	}
+	return files, nil
+}
 
-	filepath.Walk(w.watchDir, func(path string, item os.FileInfo, err error) error {
+// walk watches every directory in the watch directory, returning the files, and
+// the directories signaled as single events, that should be signaled.
+func (w *StableFileWatcher) walk(include func(path string) bool) []string {
+	if w.watchFile != "" {
+		return w.walkWatchFile(include)
+	}
+	return w.walkDir(w.watchDir, include)
+}
+
+// walkDir watches the directory, and every directory in it, returning the files, and
+// the directories signaled as single events, that should be signaled.
+func (w *StableFileWatcher) walkDir(dir string, include func(path string) bool) []string {
+	var files []string
+	walkLinkedDir(dir, func(path string, item os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		// Check what a symbolic link points to, the same as for the events of new files
+		if item.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil {
+				item = target
+			}
+		}
 		if item.IsDir() {
-			w.dirWatcher.Add(path)
-		} else {
-			log.Printf("found existing video: %s\n", path)
+			w.addWatch(path)
+		}
+		if unit, ok := w.unitOf(path, item.IsDir()); ok {
+			if unit == path && include(path) {
+				files = append(files, path)
+			}
+			return nil
+		}
+		if w.isSpecialFile(path, item) || item.IsDir() {
+			return nil
+		}
+		if include(path) {
 			files = append(files, path)
 		}
 		return nil
 	})
+	return files
+}
 
-	return files, nil
+// walkLinkedDir walks the directory, the same as filepath.Walk, except that when the
+// directory is a symbolic link, the target of the link is walked, and the paths are
+// reported under the link. filepath.Walk doesn't follow a link to a directory, so the
+// files of a linked watch directory would never be found.
+func walkLinkedDir(dir string, walkFn filepath.WalkFunc) error {
+	target, err := filepath.EvalSymlinks(dir)
+	if err != nil || target == dir {
+		return filepath.Walk(dir, walkFn)
+	}
+	return filepath.Walk(target, func(path string, item os.FileInfo, err error) error {
+		rel, relErr := filepath.Rel(target, path)
+		if relErr != nil {
+			return walkFn(path, item, err)
+		}
+		return walkFn(filepath.Join(dir, rel), item, err)
+	})
 }
 
-func (w *StableFileWatcher) start(existingFiles []string) {
-	for _, file := range existingFiles {
-		go w.waitUntilFileIsStable(file)
+// isSpecialFile determines if the file is a named pipe, socket, device or another file
+// that isn't a regular file or a directory, logging and rejecting it. Waiting for such
+// a file to be stable may never finish, or read from a device.
+func (w *StableFileWatcher) isSpecialFile(path string, info os.FileInfo) bool {
+	if info.IsDir() || info.Mode().IsRegular() {
+		return false
 	}
+	log.Printf("%s is not a regular file (%s), skipping\n", path, info.Mode())
+	w.reject(path, RejectSpecialFile)
+	return true
+}
+
+func (w *StableFileWatcher) start(existingFiles []string) {
+	defer w.closeRemovals()
+	w.startInitialScan(existingFiles)
+	w.scheduleExisting(existingFiles)
 
+	reconcile, stopReconcile := w.reconcileTicks()
+	defer stopReconcile()
+	// The watch directory is walked off the event loop, one walk at a time, so that a large
+	// watch directory doesn't hold up its events
+	reconciled := make(chan map[string]bool, 1)
+	reconciling := false
+
+	errs := w.dirWatcher.Errors
 	for {
 		select {
 		case <-w.done:
-			close(w.Events)
+			w.closeEvents()
 			return
+		case <-reconcile:
+			if !reconciling {
+				reconciling = true
+				w.startReconcile(reconciled)
+			}
+		case found := <-reconciled:
+			reconciling = false
+			if found != nil {
+				w.applyReconcile(found, time.Now())
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Println(errors.Wrapf(err, "error watching %s", w.watchDir))
 		case e := <-w.dirWatcher.Events:
+			// Start over when the file is recreated, instead of waiting on the deleted file
+			if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				w.cancelWait(e.Name)
+			}
+
+			if e.Name == w.watchDir && e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				if !w.rewatch() {
+					w.closeEvents()
+					return
+				}
+				continue
+			}
+			if w.watchFile != "" {
+				w.watchFileEvent(e)
+				continue
+			}
+
 			info, err := os.Stat(e.Name)
+			if isFileInUse(err) {
+				// The file is still being written, and is locked by the writer
+				path := e.Name
+				if unit, ok := w.unitOf(path, false); ok {
+					path = unit
+				}
+				if w.matches(path) {
+					w.schedule(path)
+				}
+				continue
+			}
 			if err != nil {
 				// Attempt to stop watching a deleted directory or file
-				w.dirWatcher.Remove(e.Name)
-				delete(w.unstableFiles, e.Name)
+				w.signalRemoval(e)
+				w.removeWatch(e.Name)
+				w.forgetJunk(e.Name)
 				continue
 			}
 
-			if info.IsDir() {
-				w.dirWatcher.Add(e.Name)
-			} else {
-				go w.waitUntilFileIsStable(e.Name)
+			if unit, ok := w.unitOf(e.Name, info.IsDir()); ok {
+				if info.IsDir() {
+					w.addWatch(e.Name)
+				}
+				// Wait for the whole directory instead, the wait is not restarted when it is already waiting
+				if w.matches(unit) {
+					w.schedule(unit)
+				}
+			} else if info.IsDir() {
+				// A directory that was moved in already has files, which don't have events of their
+				// own, so watch it and the directories in it, and wait for the files in them
+				for _, path := range w.walkDir(e.Name, w.matches) {
+					w.schedule(path)
+				}
+			} else if !w.isSpecialFile(e.Name, info) && w.matches(e.Name) {
+				w.schedule(e.Name)
 			}
 		}
 	}
 }
 
+// matches determines if the file should be signaled, based on the JunkPatterns, IgnoredDirs and PathRegex.
+func (w *StableFileWatcher) matches(path string) bool {
+	if w.isJunk(path) {
+		w.skipJunk(path)
+		return false
+	}
+	if w.isIgnored(path) {
+		return false
+	}
+
+	w.mu.Lock()
+	r := w.PathRegex
+	w.mu.Unlock()
+	return matchesRegex(r, path)
+}
+
+// Files signals when a file has stabilized.
+func (w *StableFileWatcher) Files() <-chan FileEvent {
+	return w.Events
+}
+
+// Rejections signals when a file was skipped.
+func (w *StableFileWatcher) Rejections() <-chan RejectedFile {
+	return w.Rejected
+}
+
+// SetStableThreshold changes how long a file must not change before it is signaled,
+// while the watcher is running. The files that are already being waited on keep the
+// threshold that they started with.
+func (w *StableFileWatcher) SetStableThreshold(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.StableThreshold = d
+}
+
+func (w *StableFileWatcher) stableThreshold() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.StableThreshold
+}
+
+// Pause stops checking new files until the watcher is resumed. Files that were
+// already being checked are still signaled once they are stable.
+func (w *StableFileWatcher) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+}
+
+// Resume checks the files found while the watcher was paused, and any new files.
+func (w *StableFileWatcher) Resume() {
+	w.mu.Lock()
+	files := w.deferredFiles
+	w.paused, w.deferredFiles = false, nil
+	w.mu.Unlock()
+
+	for _, path := range files {
+		// Skip the files that were removed while paused
+		if _, err := os.Stat(path); err == nil && w.addWait() {
+			go w.waitUntilFileIsStable(path, false)
+		}
+	}
+}
+
+// addWait tracks another goroutine waiting for a file to be stable, returning false once
+// the watcher stopped, because Events may already be closed.
+func (w *StableFileWatcher) addWait() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return false
+	}
+	w.waits.Add(1)
+	return true
+}
+
+// closeEvents closes Events once the files that are being waited on are signaled. The files
+// scheduled afterwards, such as by Resume from another goroutine, are no longer waited on.
+func (w *StableFileWatcher) closeEvents() {
+	w.mu.Lock()
+	w.stopped = true
+	w.mu.Unlock()
+
+	w.waits.Wait()
+	close(w.Events)
+}
+
+// schedule waits for the file to be stable, unless the watcher is paused,
+// in which case the file is checked once the watcher is resumed.
+func (w *StableFileWatcher) schedule(path string) {
+	w.scheduleWait(path, false)
+}
+
+// scheduleWait schedules the file, which is limited by the InitialScanConcurrency, instead
+// of the MaxConcurrentWaits, when it was already in the watch directory on startup. Once the
+// watcher stopped, the file is dropped, such as when Rescan runs while it is closed.
+func (w *StableFileWatcher) scheduleWait(path string, initial bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		if initial {
+			w.finishInitialFileLocked(path)
+		}
+		return
+	}
+	if w.paused {
+		if initial {
+			w.finishInitialFileLocked(path)
+		}
+		for _, p := range w.deferredFiles {
+			if p == path {
+				return
+			}
+		}
+		w.deferredFiles = append(w.deferredFiles, path)
+		return
+	}
+
+	w.waits.Add(1)
+	go w.waitUntilFileIsStable(path, initial)
+}
+
+// scheduleExisting waits for the files that were already in the watch directory,
+// each after a random delay of up to the StartupJitter.
+func (w *StableFileWatcher) scheduleExisting(files []string) {
+	if w.StartupJitter <= 0 {
+		for _, file := range files {
+			w.scheduleWait(file, true)
+		}
+		return
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, file := range files {
+		delay := time.Duration(r.Int63n(int64(w.StartupJitter)))
+		w.waits.Add(1)
+		go func(file string) {
+			defer w.waits.Done()
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				w.scheduleWait(file, true)
+			case <-w.done:
+				w.finishInitialFile(file)
+			}
+		}(file)
+	}
+}
+
 // Close all channels.
 func (w *StableFileWatcher) Close() {
 	w.dirWatcher.Close()
@@ -118,48 +737,289 @@ func (w *StableFileWatcher) Close() {
 
 // waitUntilFileIsStable waits until the file doesn't change for a set amount of
 // time. This prevents acting on a file that is still copying, being written.
-func (w *StableFileWatcher) waitUntilFileIsStable(path string) {
-	if _, ok := w.unstableFiles[path]; ok {
+// Changes are detected with file system events, or by polling the file's size,
+// depending on the StabilityMode. Only a limited number of files are waited on
+// at once, when set, and initial is the file was in the watch directory on startup.
+func (w *StableFileWatcher) waitUntilFileIsStable(path string, initial bool) {
+	defer w.waits.Done()
+	if initial {
+		defer w.finishInitialFile(path)
+	}
+	w.resumeStalled(path)
+	if w.isHeld(path) {
+		return
+	}
+	canceled, ok := w.track(path)
+	if !ok {
+		return
+	}
+	detectedAt := time.Now()
+	untrack := func() { w.untrack(path, canceled) }
+
+	release, ok := w.acquireWaitSlot(initial, canceled)
+	if !ok {
+		untrack()
+		return
+	}
+	threshold := w.stableThreshold()
+
+	// Only a directory that is signaled as a single event is waited on, such as when
+	// a file was replaced by a directory after it was scheduled
+	if info, err := os.Stat(path); err == nil && info.IsDir() && !w.isUnit(path) {
+		release()
+		untrack()
 		return
 	}
 
+	expired, stop := w.maxStabilizeWait()
+	defer stop()
+
+	var result stabilizeResult
+	if w.isUnit(path) {
+		result = w.pollDirUntilStable(path, threshold, canceled, expired, untrack)
+	} else if w.StabilityMode == StabilitySize {
+		result = w.pollUntilStable(path, threshold, canceled, expired, untrack)
+	} else {
+		result = w.watchUntilStable(path, threshold, canceled, expired, untrack)
+	}
+	release()
+	if result == stabilizeStopped {
+		return
+	}
+
+	untrack()
+	if result == stabilizeExpired && !w.handleUnstable(path) {
+		return
+	}
+	if result == stabilizeStalled {
+		w.handleStalled(path)
+		return
+	}
+	// Make sure the file is still present
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to stat %s, skipping", path))
+		w.reject(path, RejectUnreadable)
+	} else if info.Size() == 0 && !info.IsDir() {
+		w.handleEmpty(path)
+	} else if w.inCooldown(path, info) {
+		log.Printf("%s was signaled recently and has not changed, skipping\n", path)
+		w.reject(path, RejectCooldown)
+	} else if w.isLinkedToSignaled(path, info) {
+		log.Printf("%s is a hard link to a file that was already signaled, skipping\n", path)
+		w.reject(path, RejectHardLink)
+	} else {
+		e := w.newEvent(path)
+		e.DetectedAt = detectedAt
+		w.emit(e)
+	}
+}
+
+// emit sends the event to the consumer, until the watcher is closed or the EmitTimeout
+// elapses. A file whose event times out is rejected, and may be signaled again by its next change.
+func (w *StableFileWatcher) emit(e FileEvent) {
+	var timeout <-chan time.Time
+	if w.EmitTimeout > 0 {
+		timer := time.NewTimer(w.EmitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case w.Events <- e:
+	case <-w.done:
+	case <-timeout:
+		log.Printf("the event for %s was not received within %s, skipping\n", e.Path, w.EmitTimeout)
+		w.forgetSignaled(e.Path)
+		w.reject(e.Path, RejectEmitTimeout)
+	}
+}
+
+// watchUntilStable waits until no events are received for the file for the threshold,
+// or the wait expires. Returns stabilizeStopped, after untracking the file when necessary,
+// when the file won't be signaled.
+func (w *StableFileWatcher) watchUntilStable(path string, threshold time.Duration, canceled <-chan struct{}, expired <-chan time.Time, untrack func()) stabilizeResult {
 	fw, err := fsnotify.NewWatcher()
 	if err != nil {
+		untrack()
 		log.Println(errors.Wrapf(err, "unable to create watcher, skipping %s", path))
-		return
+		w.reject(path, RejectUnreadable)
+		return stabilizeStopped
 	}
 	defer fw.Close()
 	err = fw.Add(path)
 	if err != nil {
+		untrack()
 		log.Println(errors.Wrapf(err, "unable to watch %s, skipping", path))
-		return
+		w.reject(path, RejectUnreadable)
+		return stabilizeStopped
 	}
-	w.unstableFiles[path] = struct{}{}
 
-	timer := time.NewTimer(w.StableThreshold)
+	// Signal the file as soon as it is closed, when supported
+	var closed <-chan struct{}
+	if w.StabilityMode == StabilityCloseWrite {
+		var stop func()
+		closed, stop, err = watchCloseWrite(path)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "waiting for %s to be stable instead", path))
+		} else {
+			defer stop()
+		}
+	}
+
+	// Also check the size of the file, when its events may have been coalesced
+	var writes *writeStats
+	if w.StabilityMode == StabilityHybrid {
+		writes = newWriteStats(path)
+	}
+
+	timer := time.NewTimer(threshold)
 	defer timer.Stop()
 
 	for {
 		select {
 		case <-w.done:
-			delete(w.unstableFiles, path)
-			return
+			untrack()
+			return stabilizeStopped
+		case <-canceled:
+			log.Printf("%s was removed while waiting for it to be stable\n", path)
+			return stabilizeStopped
+		case <-closed:
+			// Another writer, or another descriptor of the writer, may still have the file open,
+			// so keep waiting for it to be stable instead, closed only signals the first close
+			if fileInUse(path) || w.stillOpen(path) {
+				closed = nil
+				continue
+			}
+			return stabilizeStable
+		case <-expired:
+			return stabilizeExpired
 		case <-fw.Events:
+			if writes != nil {
+				writes.events++
+				writes.observe(path)
+			}
 			// Start the wait over again, the file was changed
 			if !timer.Stop() {
 				<-timer.C
 			}
-			timer.Reset(w.StableThreshold)
+			timer.Reset(threshold)
 		case <-timer.C:
-			delete(w.unstableFiles, path)
-			// Make sure the file is still present
-			_, err := os.Stat(path)
-			if err != nil {
-				log.Println(errors.Wrapf(err, "unable to stat %s, skipping", path))
-			} else {
-				w.Events <- FileEvent{Path: path}
+			// Keep waiting while the file is locked or open for writing, or was written without an event
+			if fileInUse(path) || w.stillOpen(path) || (writes != nil && writes.observe(path)) {
+				timer.Reset(threshold)
+				continue
 			}
-			return
+			if writes != nil {
+				log.Printf("%s is stable after %s\n", path, writes)
+			}
+			return stabilizeStable
+		}
+	}
+}
+
+// reject signals that the file was skipped, dropping the rejection when nothing is receiving them.
+func (w *StableFileWatcher) reject(path string, reason RejectReason) {
+	select {
+	case w.Rejected <- RejectedFile{Path: path, Reason: reason}:
+	default:
+	}
+}
+
+// newEvent creates the event for a stable file, probing it when enabled.
+// A file that can't be probed is still signaled, without metadata.
+func (w *StableFileWatcher) newEvent(path string) FileEvent {
+	e := FileEvent{Path: path, WatchRoot: w.watchDir, StableAt: time.Now()}
+	e.Size, _ = Size(path)
+	if w.isUnit(path) {
+		e.IsDir = true
+		return e
+	}
+	if w.Prober != nil {
+		m, err := w.Prober.Probe(path)
+		if err != nil {
+			log.Println(err)
+		} else {
+			e.Metadata = m
 		}
 	}
+	return e
+}
+
+// Stabilizing is the number of files that are being watched until they are stable.
+func (w *StableFileWatcher) Stabilizing() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.unstableFiles)
+}
+
+// track records that the file is being watched until it is stable, returning
+// a channel that is closed when the wait is canceled, or false when it is already being watched.
+func (w *StableFileWatcher) track(path string) (<-chan struct{}, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.unstableFiles[path]; ok {
+		return nil, false
+	}
+	canceled := make(chan struct{})
+	w.unstableFiles[path] = canceled
+	return canceled, true
+}
+
+// untrack records that the file is no longer being watched, unless the
+// wait was already canceled and the file is being watched again.
+func (w *StableFileWatcher) untrack(path string, canceled <-chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.unstableFiles[path] == canceled {
+		delete(w.unstableFiles, path)
+	}
+}
+
+// cancelWait stops waiting for a removed file to be stable, so that
+// the file is watched from the beginning if it is recreated.
+func (w *StableFileWatcher) cancelWait(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if canceled, ok := w.unstableFiles[path]; ok {
+		close(canceled)
+		delete(w.unstableFiles, path)
+	}
+	delete(w.heldFiles, path)
+}
+
+// forgetSignaled removes the file from the cooldown, because its event wasn't received.
+func (w *StableFileWatcher) forgetSignaled(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.signaledFiles, path)
+}
+
+// inCooldown determines if an event was signaled for the file within the
+// cooldown, and the file hasn't been modified since. Otherwise the file
+// is recorded as signaled.
+func (w *StableFileWatcher) inCooldown(path string, info os.FileInfo) bool {
+	if w.Cooldown == 0 {
+		return false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	for p, sf := range w.signaledFiles {
+		if now.Sub(sf.signaledAt) > w.Cooldown {
+			delete(w.signaledFiles, p)
+		}
+	}
+
+	if sf, ok := w.signaledFiles[path]; ok && !info.ModTime().After(sf.modTime) {
+		return true
+	}
+
+	w.signaledFiles[path] = signaledFile{signaledAt: now, modTime: info.ModTime()}
+	return false
 }
@@ -5,6 +5,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -122,6 +125,10 @@ func TestCopyFileWatcher_ExistingFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("%#v", err)
 	}
+	_, err = f.WriteString("foo")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
 	err = f.Close()
 	if err != nil {
 		t.Fatalf("%#v", err)
@@ -234,6 +241,9 @@ func TestCopyFileWatcher_NestedFile(t *testing.T) {
 	go func() {
 		for e := range w.Events {
 			t.Log(e)
+			if e.WatchRoot != tmpDir {
+				t.Errorf("expected the event to be found under %s, got %q", tmpDir, e.WatchRoot)
+			}
 			gotEvents.increment()
 		}
 
@@ -301,3 +311,1022 @@ func TestCopyFileWatcher_NestedFile(t *testing.T) {
 		t.Fatalf("expected %d events, got %d", wantEvents, gotEvents)
 	}
 }
+
+func TestCopyFileWatcher_MovedInDirectory(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watchDir := filepath.Join(tmpDir, "watch")
+	err = os.Mkdir(watchDir, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(watchDir, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// Drop in a folder that already has a video, in a nested directory
+	dropped := filepath.Join(tmpDir, "Foo (2019)")
+	err = os.MkdirAll(filepath.Join(dropped, "Extras"), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dropped, "Extras", "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = os.Rename(dropped, filepath.Join(watchDir, "Foo (2019)"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	want := filepath.Join(watchDir, "Foo (2019)", "Extras", "foo.mkv")
+	select {
+	case e := <-w.Events:
+		if e.Path != want {
+			t.Fatalf("expected an event for %s, got %s", want, e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event for the video in the moved in directory")
+	}
+
+	// The nested directory is watched for new files too
+	err = ioutil.WriteFile(filepath.Join(watchDir, "Foo (2019)", "Extras", "bar.mkv"), []byte("bar"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	select {
+	case e := <-w.Events:
+		if filepath.Base(e.Path) != "bar.mkv" {
+			t.Fatalf("expected an event for bar.mkv, got %s", e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an event for the new video in the nested directory")
+	}
+}
+
+func TestCopyFileWatcher_Cooldown(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Log("watching ", tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithCooldown(time.Minute))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Track how many times an event is raised
+	var gotEvents counter
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			gotEvents.increment()
+		}
+
+		// Stop the goroutine once the events has been closed
+		done <- true
+	}()
+
+	// Create a file in the watched directory
+	tmpfile := filepath.Join(tmpDir, "foo.txt")
+	modTime := time.Now().Add(-time.Hour)
+	for i := 0; i < 2; i++ {
+		f, err := os.Create(tmpfile)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		_, err = f.WriteString("foo")
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		err = f.Close()
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+
+		// Preserve the modification time, like a sync tool
+		err = os.Chtimes(tmpfile, modTime, modTime)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+
+		// Give the file time to be considered stable
+		time.Sleep(w.StableThreshold * 2)
+
+		// Recreate the file
+		err = os.Remove(tmpfile)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Stop listening for events
+	w.Close()
+
+	// Wait for all the events to be processed
+	t.Log("wait for all events to be processed")
+	<-done
+
+	var wantEvents int32 = 1
+	if gotEvents.value() != wantEvents {
+		t.Fatalf("expected %d events, got %d", wantEvents, gotEvents)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != tmpfile || r.Reason != RejectCooldown {
+			t.Fatalf("expected %s to be rejected for the cooldown, got %#v", tmpfile, r)
+		}
+	default:
+		t.Fatal("expected the recreated file to be rejected")
+	}
+}
+
+func TestCopyFileWatcher_RelativeWatchDir(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	relDir, err := filepath.Rel(wd, tmpDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	tmpfile := filepath.Join(tmpDir, "foo.txt")
+	err = ioutil.WriteFile(tmpfile, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(relDir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	select {
+	case e := <-w.Events:
+		if e.Path != tmpfile {
+			t.Fatalf("expected the absolute path %s, got %s", tmpfile, e.Path)
+		}
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected an event for the existing file")
+	}
+}
+
+func TestCopyFileWatcher_SymlinkedWatchDir(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	share := filepath.Join(tmpDir, "share")
+	err = os.MkdirAll(filepath.Join(share, "movies"), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(share, "movies", "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	watchDir := filepath.Join(tmpDir, "watch")
+	err = os.Symlink(share, watchDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(watchDir, testStableThreshold, WithPathRegex(`^`+regexp.QuoteMeta(watchDir)+`/movies/`))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// The existing file in a directory of the link is found, and the directory is watched
+	existing := filepath.Join(watchDir, "movies", "foo.mkv")
+	select {
+	case e := <-w.Events:
+		if e.Path != existing || e.WatchRoot != watchDir {
+			t.Fatalf("expected the existing file under the link, %s, got %s under %s", existing, e.Path, e.WatchRoot)
+		}
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected an event for the existing file in the linked watch directory")
+	}
+
+	newFile := filepath.Join(watchDir, "movies", "bar.mkv")
+	err = ioutil.WriteFile(filepath.Join(share, "movies", "bar.mkv"), []byte("bar"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	select {
+	case e := <-w.Events:
+		if e.Path != newFile {
+			t.Fatalf("expected the new file under the link, %s, got %s", newFile, e.Path)
+		}
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected an event for the new file in the linked watch directory")
+	}
+}
+
+func TestCopyFileWatcher_RecreatedFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Log("watching ", tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Track how many times an event is raised
+	var gotEvents counter
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			gotEvents.increment()
+		}
+
+		// Stop the goroutine once the events has been closed
+		done <- true
+	}()
+
+	// Create a file, and then delete and recreate it before it is stable, like a sync tool
+	tmpfile := filepath.Join(tmpDir, "foo.txt")
+	err = ioutil.WriteFile(tmpfile, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(w.StableThreshold / 2)
+
+	err = os.Remove(tmpfile)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	err = ioutil.WriteFile(tmpfile, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Give the recreated file time to be considered stable
+	time.Sleep(w.StableThreshold * 2)
+
+	// Stop listening for events
+	w.Close()
+
+	// Wait for all the events to be processed
+	t.Log("wait for all events to be processed")
+	<-done
+
+	var wantEvents int32 = 1
+	if gotEvents.value() != wantEvents {
+		t.Fatalf("expected %d events, got %d", wantEvents, gotEvents)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		t.Fatalf("expected the recreated file to not be rejected, got %#v", r)
+	default:
+	}
+}
+
+func TestCopyFileWatcher_SizeStability(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Log("watching ", tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithStabilityMode(StabilitySize))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Track how many times an event is raised
+	var gotEvents counter
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			gotEvents.increment()
+		}
+
+		// Stop the goroutine once the events has been closed
+		done <- true
+	}()
+
+	// Keep growing the file for longer than the StableThreshold
+	tmpfile := filepath.Join(tmpDir, "foo.txt")
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err = f.WriteString(fmt.Sprintf("%d", i))
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		time.Sleep(testStableThreshold / 5)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if gotEvents.value() > 0 {
+		t.Fatalf("expected no events to be raised while the file's size was changing but got %d events", gotEvents.value())
+	}
+
+	// Give the file time to be considered stable
+	time.Sleep(w.StableThreshold * 3)
+
+	// Stop listening for events
+	w.Close()
+
+	// Wait for all the events to be processed
+	<-done
+
+	var wantEvents int32 = 1
+	if gotEvents.value() != wantEvents {
+		t.Fatalf("expected %d events, got %d", wantEvents, gotEvents.value())
+	}
+}
+
+func TestWriteStats_Observe(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo.txt")
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	writes := newWriteStats(path)
+	if writes.observe(path) {
+		t.Fatal("expected an unchanged file to not be reported as changed")
+	}
+
+	// A write whose event was coalesced is still seen by its size
+	err = ioutil.WriteFile(path, []byte("foobar"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if !writes.observe(path) {
+		t.Fatal("expected the write to be observed")
+	}
+	if writes.size-writes.startSize != 3 {
+		t.Fatalf("expected 3 bytes to be written, got %d", writes.size-writes.startSize)
+	}
+}
+
+func TestCopyFileWatcher_HybridStability(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithStabilityMode(StabilityHybrid))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	tmpfile := filepath.Join(tmpDir, "foo.txt")
+	f, err := os.Create(tmpfile)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err = f.WriteString(fmt.Sprintf("%d", i))
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		time.Sleep(testStableThreshold / 5)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if e.Size != 10 {
+			t.Fatalf("expected the file to be signaled once it was completely written, got %#v", e)
+		}
+	case <-time.After(w.StableThreshold * 5):
+		t.Fatal("expected the file to be signaled once it was stable")
+	}
+}
+
+func TestStabilityMode_Set(t *testing.T) {
+	var mode StabilityMode
+	err := mode.Set("size")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if mode != StabilitySize {
+		t.Fatalf("expected the size mode, got %q", mode)
+	}
+
+	err = mode.Set("hybrid")
+	if err != nil || mode != StabilityHybrid {
+		t.Fatalf("expected the hybrid mode, got %q: %v", mode, err)
+	}
+
+	err = mode.Set("inotify")
+	if err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+}
+
+func TestCopyFileWatcher_PathRegex(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	t.Log("watching ", tmpDir)
+
+	createFile := func(name string) {
+		path := filepath.Join(tmpDir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		err = ioutil.WriteFile(path, []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	// Only the existing file matching the pattern is signaled
+	createFile("tv/Foo/Season 1/foo.mkv")
+	createFile("tv/Foo/Extras/foo.mkv")
+	err = os.MkdirAll(filepath.Join(tmpDir, "tv/Bar/Season 2"), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithPathRegex(`.*/Season \d+/.*\.mkv$`))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var gotEvents []string
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			rel, _ := filepath.Rel(tmpDir, e.Path)
+			gotEvents = append(gotEvents, filepath.ToSlash(rel))
+		}
+		done <- true
+	}()
+
+	// As are only the new files matching the pattern
+	time.Sleep(50 * time.Millisecond)
+	createFile("tv/Bar/Season 2/bar.mkv")
+	createFile("tv/Bar/Season 2/bar.nfo")
+
+	// Give the files time to be considered stable
+	time.Sleep(w.StableThreshold * 2)
+	w.Close()
+	<-done
+
+	if len(gotEvents) != 2 {
+		t.Fatalf("expected events for the 2 files matching the pattern, got %v", gotEvents)
+	}
+	for _, got := range gotEvents {
+		if got != "tv/Foo/Season 1/foo.mkv" && got != "tv/Bar/Season 2/bar.mkv" {
+			t.Fatalf("expected only files matching the pattern to be signaled, got %v", gotEvents)
+		}
+	}
+}
+
+func TestNewStableFileWatcher_InvalidPathRegex(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, err = NewStableFileWatcher(tmpDir, testStableThreshold, WithPathRegex(`Season (\d+`))
+	if err == nil {
+		t.Fatal("expected an invalid path regex to fail")
+	}
+}
+
+func TestCopyFileWatcher_WatchDirRecreated(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watchDir := filepath.Join(tmpDir, "watch")
+	err = os.Mkdir(watchDir, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w, err := NewStableFileWatcher(watchDir, testStableThreshold/2,
+		WithRewatchBackoff(Backoff{Initial: 10 * time.Millisecond, Max: 50 * time.Millisecond, Jitter: 0.2}))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var gotEvents counter
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			gotEvents.increment()
+		}
+		done <- true
+	}()
+
+	// The mount drops, and comes back a little later with a video on it
+	err = os.RemoveAll(watchDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	err = os.Mkdir(watchDir, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(watchDir, "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// New videos are also found once the directory is watched again
+	time.Sleep(w.StableThreshold * 2)
+	err = ioutil.WriteFile(filepath.Join(watchDir, "bar.mkv"), []byte("bar"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(w.StableThreshold * 2)
+
+	w.Close()
+	<-done
+
+	var wantEvents int32 = 2
+	if gotEvents.value() != wantEvents {
+		t.Fatalf("expected %d events, got %d", wantEvents, gotEvents.value())
+	}
+}
+
+func TestBackoff_Next(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 5 * time.Second}
+	var got []time.Duration
+	for delay := b.Initial; len(got) < 5; delay = b.Next(delay) {
+		got = append(got, delay)
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	if err := (Backoff{Initial: time.Minute, Max: time.Second}).Validate(); err == nil {
+		t.Fatal("expected a maximum delay shorter than the initial delay to be invalid")
+	}
+}
+
+func TestCopyFileWatcher_WatchedDirs(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// Create each directory after its parent is watched, like a slow copy would
+	tv := filepath.Join(tmpDir, "TV")
+	show := filepath.Join(tv, "Show")
+	season := filepath.Join(show, "Season 1")
+	for _, dir := range []string{tv, show, season} {
+		err = os.Mkdir(dir, 0755)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		waitForWatchedDirs(t, w, dir, true)
+	}
+
+	err = os.RemoveAll(show)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForWatchedDirs(t, w, show, false)
+
+	want := fmt.Sprint([]string{tmpDir, tv})
+	if got := fmt.Sprint(w.WatchedDirs()); got != want {
+		t.Fatalf("expected the watched directories to be %s, got %s", want, got)
+	}
+}
+
+// waitForWatchedDirs waits until the directory is, or is no longer, watched.
+func waitForWatchedDirs(t *testing.T, w *StableFileWatcher, dir string, wantWatched bool) {
+	for start := time.Now(); time.Since(start) < 2*time.Second; time.Sleep(10 * time.Millisecond) {
+		watched := false
+		for _, d := range w.WatchedDirs() {
+			if d == dir {
+				watched = true
+			}
+		}
+		if watched == wantWatched {
+			return
+		}
+	}
+	t.Fatalf("expected %s to be watched %t, got %v", dir, wantWatched, w.WatchedDirs())
+}
+
+func TestCopyFileWatcher_ResumeAfterClose(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	done := make(chan bool)
+	go func() {
+		for range w.Events {
+		}
+		done <- true
+	}()
+
+	// The file found while paused is deferred until the watcher is resumed, after it was closed
+	w.Pause()
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(w.StableThreshold)
+	w.Close()
+	<-done
+
+	w.Resume()
+	time.Sleep(w.StableThreshold * 2)
+	if w.addWait() {
+		t.Fatal("expected no more waits once the watcher is closed")
+	}
+}
+
+func TestCopyFileWatcher_Rescan(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"foo.mkv", "bar.mp4"} {
+		err = ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithPathRegex(`\.mkv$`))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var mu sync.Mutex
+	var gotEvents []string
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			mu.Lock()
+			gotEvents = append(gotEvents, filepath.Base(e.Path))
+			mu.Unlock()
+		}
+		done <- true
+	}()
+
+	// Relaxing the filter only schedules the file that wasn't matched before
+	if _, err := w.Rescan("("); err == nil {
+		t.Fatal("expected an invalid path regex to be rejected")
+	}
+	found, err := w.Rescan(`\.(mkv|mp4)$`)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if found != 1 {
+		t.Fatalf("expected the rescan to find 1 newly matching file, found %d", found)
+	}
+
+	time.Sleep(w.StableThreshold * 2)
+	w.Close()
+	<-done
+
+	sort.Strings(gotEvents)
+	if want := []string{"bar.mp4", "foo.mkv"}; fmt.Sprint(gotEvents) != fmt.Sprint(want) {
+		t.Fatalf("expected events for %v, got %v", want, gotEvents)
+	}
+
+	// A rescan once the watcher is closed doesn't wait for the newly matching file
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "baz.avi"), []byte("baz"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if _, err := w.Rescan(""); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(w.StableThreshold * 2)
+}
+
+func TestCopyFileWatcher_DirectoryEvents(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if _, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithDirectoryEvents(0)); err == nil {
+		t.Fatal("expected an invalid directory depth to be rejected")
+	}
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithDirectoryEvents(1))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var mu sync.Mutex
+	var gotEvents []string
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			mu.Lock()
+			gotEvents = append(gotEvents, fmt.Sprintf("%s:%t", filepath.Base(e.Path), e.IsDir))
+			mu.Unlock()
+		}
+		done <- true
+	}()
+
+	// The files of a disc are written one after another, and only the disc is signaled
+	discDir := filepath.Join(tmpDir, "Disc", "VIDEO_TS")
+	err = os.MkdirAll(discDir, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for _, name := range []string{"VIDEO_TS.IFO", "VTS_01_1.VOB", "VTS_01_2.VOB"} {
+		time.Sleep(w.StableThreshold / 2)
+		err = ioutil.WriteFile(filepath.Join(discDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	time.Sleep(w.StableThreshold * 4)
+	w.Close()
+	<-done
+
+	sort.Strings(gotEvents)
+	if want := []string{"Disc:true", "foo.mkv:false"}; fmt.Sprint(gotEvents) != fmt.Sprint(want) {
+		t.Fatalf("expected events for %v, got %v", want, gotEvents)
+	}
+}
+
+func TestCopyFileWatcher_StartupJitter(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	want := []string{"bar.mkv", "baz.mkv", "foo.mkv"}
+	for _, name := range want {
+		err = ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	if _, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithStartupJitter(-time.Second)); err == nil {
+		t.Fatal("expected a negative startup jitter to be rejected")
+	}
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithStartupJitter(testStableThreshold))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var mu sync.Mutex
+	var gotEvents []string
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			mu.Lock()
+			gotEvents = append(gotEvents, filepath.Base(e.Path))
+			mu.Unlock()
+		}
+		done <- true
+	}()
+
+	// Every existing file is still signaled, within the jitter
+	time.Sleep(w.StableThreshold*2 + w.StartupJitter)
+	w.Close()
+	<-done
+
+	sort.Strings(gotEvents)
+	if fmt.Sprint(gotEvents) != fmt.Sprint(want) {
+		t.Fatalf("expected events for %v, got %v", want, gotEvents)
+	}
+}
+
+func TestCopyFileWatcher_InitialIgnore(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"foo.mkv", "growing.mkv"} {
+		err = ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithInitialIgnore("growing.mkv"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var mu sync.Mutex
+	var gotEvents []string
+	done := make(chan bool)
+	go func() {
+		for e := range w.Events {
+			t.Log(e)
+			mu.Lock()
+			gotEvents = append(gotEvents, filepath.Base(e.Path))
+			mu.Unlock()
+		}
+		done <- true
+	}()
+
+	// Only the file that isn't ignored is signaled on startup
+	time.Sleep(w.StableThreshold * 2)
+	mu.Lock()
+	if fmt.Sprint(gotEvents) != "[foo.mkv]" {
+		t.Fatalf("expected only foo.mkv to be signaled on startup, got %v", gotEvents)
+	}
+	mu.Unlock()
+
+	// Changes to the ignored file after startup are handled as usual
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "growing.mkv"), []byte("growing.mkv, finished"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(w.StableThreshold * 2)
+	w.Close()
+	<-done
+
+	if fmt.Sprint(gotEvents) != "[foo.mkv growing.mkv]" {
+		t.Fatalf("expected growing.mkv to be signaled once it changed, got %v", gotEvents)
+	}
+}
+
+func TestCopyFileWatcher_EmitTimeout(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "foo.mkv"), []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if _, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithEmitTimeout(-time.Second)); err == nil {
+		t.Fatal("expected a negative emit timeout to be rejected")
+	}
+	w, err := NewStableFileWatcher(tmpDir, testStableThreshold, WithEmitTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// Nothing receives the events, so the stable file is rejected instead of blocking the watcher
+	select {
+	case r := <-w.Rejected:
+		if r.Reason != RejectEmitTimeout {
+			t.Fatalf("expected the file to be rejected because its event timed out, got %#v", r)
+		}
+	case <-time.After(w.StableThreshold * 3):
+		t.Fatal("expected the file to be rejected once its event timed out")
+	}
+}
+
+func TestStableFileWatcher_SetStableThreshold(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, time.Hour)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	// A file that is already being waited on keeps the original threshold
+	slowFile := filepath.Join(tmpDir, "slow.txt")
+	err = ioutil.WriteFile(slowFile, []byte("slow"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	w.SetStableThreshold(200 * time.Millisecond)
+	fastFile := filepath.Join(tmpDir, "fast.txt")
+	err = ioutil.WriteFile(fastFile, []byte("fast"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if e.Path != fastFile {
+			t.Fatalf("expected only %s to be signaled with the new threshold, got %s", fastFile, e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the new threshold to be used for the new file")
+	}
+}
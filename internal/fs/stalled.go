@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RejectStalled is a file that stopped growing while it was clearly incomplete, such as a copy
+// whose connection dropped partway, and is held until it grows again or is released.
+const RejectStalled RejectReason = "stalled"
+
+// WithStallDetection checks a file that stopped changing, in StabilitySize mode, before it is
+// signaled: a file with the extended attribute named expectedSizeXattr, when set, must be at
+// least the size in bytes that it holds, and checkComplete, when set, must not return why it is
+// incomplete, such as an MP4 without the moov box with its index. A file that fails the checks
+// is held as a stalled copy instead of being signaled, and is waited on again once it grows,
+// or it is released.
+func WithStallDetection(expectedSizeXattr string, checkComplete func(path string) error) Option {
+	return func(w *StableFileWatcher) error {
+		w.DetectStalls = true
+		w.ExpectedSizeXattr = expectedSizeXattr
+		w.CheckComplete = checkComplete
+		return nil
+	}
+}
+
+// WithStallAlert calls the alert with each file that is held as a stalled copy, and why it is incomplete.
+func WithStallAlert(alert func(path, reason string)) Option {
+	return func(w *StableFileWatcher) error {
+		w.OnStall = alert
+		return nil
+	}
+}
+
+// validateStallDetection checks that stalls can be detected, once the options are set.
+func (w *StableFileWatcher) validateStallDetection() error {
+	if w.DetectStalls && w.StabilityMode != StabilitySize {
+		return errors.Errorf("detecting stalled copies requires the %s stability mode", StabilitySize)
+	}
+	return nil
+}
+
+// growth tracks how fast a file grows while it is polled, to explain a stalled copy.
+type growth struct {
+	startedAt, lastGrewAt time.Time
+	startSize, size       int64
+}
+
+// observe records the size of the file when it is polled.
+func (g *growth) observe(size int64, now time.Time) {
+	if g.startedAt.IsZero() {
+		g.startedAt, g.lastGrewAt, g.startSize, g.size = now, now, size, size
+		return
+	}
+	if size != g.size {
+		g.lastGrewAt, g.size = now, size
+	}
+}
+
+// String summarizes the growth, such as "grew 1048576 bytes at 524288 bytes/s".
+func (g *growth) String() string {
+	grown := g.size - g.startSize
+	if grown <= 0 {
+		return "didn't grow while it was watched"
+	}
+	elapsed := g.lastGrewAt.Sub(g.startedAt).Seconds()
+	if elapsed <= 0 {
+		return fmt.Sprintf("grew %d bytes", grown)
+	}
+	return fmt.Sprintf("grew %d bytes at %.0f bytes/s", grown, float64(grown)/elapsed)
+}
+
+// incomplete determines why a file that stopped changing is clearly incomplete, when DetectStalls is set.
+func (w *StableFileWatcher) incomplete(path string, size int64) (string, bool) {
+	if !w.DetectStalls {
+		return "", false
+	}
+
+	if w.ExpectedSizeXattr != "" {
+		value, ok, err := Xattr(path, w.ExpectedSizeXattr)
+		if err != nil && err != ErrXattrUnsupported {
+			log.Println(err)
+		}
+		if ok {
+			expected, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				log.Printf("ignoring the invalid expected size %q of %s in %s\n", value, path, w.ExpectedSizeXattr)
+			} else if size < expected {
+				return fmt.Sprintf("it is only %d of its expected %d bytes (%.0f%%)", size, expected, 100*float64(size)/float64(expected)), true
+			}
+		}
+	}
+
+	if w.CheckComplete != nil {
+		if err := w.CheckComplete(path); err != nil {
+			return err.Error(), true
+		}
+	}
+	return "", false
+}
+
+// handleStalled holds a file that stopped growing while it is incomplete, once it is no longer
+// tracked, so that its changes are ignored until it grows again, or it is released.
+func (w *StableFileWatcher) handleStalled(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.heldFiles[path] = true
+	w.stalledFiles[path] = info.Size()
+	w.mu.Unlock()
+
+	reason, _ := w.incomplete(path, info.Size())
+	log.Printf("holding %s as a stalled copy at %d bytes, until it grows again or is released\n", path, info.Size())
+	w.reject(path, RejectStalled)
+	if w.OnStall != nil {
+		w.OnStall(path, reason)
+	}
+}
+
+// resumeStalled releases a file that was held as a stalled copy once it grows again, such as when
+// the copy resumed, so that it is waited on as usual.
+func (w *StableFileWatcher) resumeStalled(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stalledSize, ok := w.stalledFiles[path]
+	if !ok {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() == stalledSize {
+		return
+	}
+	log.Printf("%s grew again after it stalled at %d bytes, waiting for it to be stable\n", path, stalledSize)
+	delete(w.stalledFiles, path)
+	delete(w.heldFiles, path)
+}
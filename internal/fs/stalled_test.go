@@ -0,0 +1,110 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// checkIndexed reports a file as incomplete until it has a moov box, standing in for
+// ffprobe.CheckCopiedMP4, which can't be imported here.
+func checkIndexed(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	if !bytes.Contains(b, []byte("moov")) {
+		return errors.New("it doesn't have a moov box")
+	}
+	return nil
+}
+
+func TestStableFileWatcher_StalledCopy(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	alerts := make(chan string, 1)
+	w, err := NewStableFileWatcher(tmpDir, 200*time.Millisecond, WithStabilityMode(StabilitySize),
+		WithStallDetection("", checkIndexed), WithStallAlert(func(path, reason string) { alerts <- reason }))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	// The copy stalls partway through the video, before its index
+	mdat := make([]byte, 108)
+	binary.BigEndian.PutUint32(mdat, uint32(len(mdat)))
+	copy(mdat[4:], "mdat")
+	path := filepath.Join(tmpDir, "foo.mp4")
+	err = ioutil.WriteFile(path, mdat[:50], 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != path || r.Reason != RejectStalled {
+			t.Fatalf("expected %s to be rejected as stalled, got %#v", path, r)
+		}
+	case e := <-w.Events:
+		t.Fatalf("expected the stalled copy to not be signaled, got %s", e.Path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the stalled copy to be rejected")
+	}
+	if reason := <-alerts; reason == "" {
+		t.Fatal("expected the alert to explain why the copy is incomplete")
+	}
+	if held := w.Held(); len(held) != 1 || held[0] != path {
+		t.Fatalf("expected the stalled copy to be held, got %v", held)
+	}
+
+	// The copy resumes, and finishes with the index
+	moov := make([]byte, 16)
+	binary.BigEndian.PutUint32(moov, uint32(len(moov)))
+	copy(moov[4:], "moov")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	_, err = f.Write(append(mdat[50:], moov...))
+	f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if e.Path != path {
+			t.Fatalf("expected %s to be signaled, got %s", path, e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the copy to be signaled once it is complete")
+	}
+	if held := w.Held(); len(held) != 0 {
+		t.Fatalf("expected the copy to no longer be held, got %v", held)
+	}
+}
+
+func TestWithStallDetection_RequiresSizeMode(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, err = NewStableFileWatcher(tmpDir, time.Second, WithStabilityMode(StabilityEvents), WithStallDetection("", nil))
+	if err == nil {
+		t.Fatal("expected stall detection to require the size stability mode")
+	}
+}
@@ -0,0 +1,215 @@
+package fs
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// UnstablePolicy is what a StableFileWatcher does with a file that is still changing
+// after the MaxStabilizeWait. It may be used as a flag.
+type UnstablePolicy string
+
+const (
+	// UnstableEmit signals the file anyway, as if it was stable.
+	UnstableEmit UnstablePolicy = "emit"
+
+	// UnstableQuarantine skips the file, moving it to the QuarantineDir.
+	UnstableQuarantine UnstablePolicy = "quarantine"
+
+	// UnstableHold skips the file, and ignores its changes until it is released for
+	// manual review with Release, or it is removed. The OnHold alert is sent for it.
+	UnstableHold UnstablePolicy = "hold"
+)
+
+const (
+	// RejectUnstable is a file that was still changing after the MaxStabilizeWait, and was quarantined.
+	RejectUnstable RejectReason = "unstable"
+
+	// RejectHeld is a file that was still changing after the MaxStabilizeWait, and is held for manual review.
+	RejectHeld RejectReason = "held"
+)
+
+// stabilizeResult is how waiting for a file to be stable ended.
+type stabilizeResult int
+
+const (
+	// stabilizeStopped is a file that won't be signaled, because it was removed or
+	// rejected, or the watcher was closed.
+	stabilizeStopped stabilizeResult = iota
+
+	// stabilizeStable is a file that stopped changing.
+	stabilizeStable
+
+	// stabilizeExpired is a file that was still changing after the MaxStabilizeWait.
+	stabilizeExpired
+
+	// stabilizeStalled is a file that stopped changing, but is still incomplete, see WithStallDetection.
+	stabilizeStalled
+)
+
+// String returns the name of the policy.
+func (p *UnstablePolicy) String() string {
+	if p == nil {
+		return ""
+	}
+	return string(*p)
+}
+
+// Set validates the name of the policy.
+func (p *UnstablePolicy) Set(value string) error {
+	policy := UnstablePolicy(value)
+	if policy != UnstableEmit && policy != UnstableQuarantine && policy != UnstableHold {
+		return errors.Errorf("invalid unstable policy %q, must be %s, %s or %s", value, UnstableEmit, UnstableQuarantine, UnstableHold)
+	}
+	*p = policy
+	return nil
+}
+
+// WithMaxStabilizeWait stops waiting for a file that is still changing after the wait,
+// and handles it with the policy instead. UnstableQuarantine requires WithQuarantineDir.
+func WithMaxStabilizeWait(wait time.Duration, policy UnstablePolicy) Option {
+	return func(w *StableFileWatcher) error {
+		if wait < 0 {
+			return errors.Errorf("invalid max stabilize wait %s, must not be negative", wait)
+		}
+		if policy == "" {
+			policy = UnstableEmit
+		}
+		err := policy.Set(string(policy))
+		if err != nil {
+			return err
+		}
+		w.MaxStabilizeWait, w.UnstablePolicy = wait, policy
+		return nil
+	}
+}
+
+// WithQuarantineDir moves the files that are quarantined by the UnstablePolicy, or by WithQuarantineEmpty,
+// to the directory, at the same path that they had in the watch directory. The files in the directory are never signaled.
+func WithQuarantineDir(dir string) Option {
+	return func(w *StableFileWatcher) error {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return errors.Wrapf(err, "unable to resolve the absolute path of the quarantine directory %s", dir)
+		}
+		w.QuarantineDir = dir
+		w.IgnoredDirs = append(w.IgnoredDirs, dir)
+		return nil
+	}
+}
+
+// WithHoldAlert calls the alert with each file that is held by the UnstablePolicy.
+func WithHoldAlert(alert func(path string)) Option {
+	return func(w *StableFileWatcher) error {
+		w.OnHold = alert
+		return nil
+	}
+}
+
+// validateUnstablePolicy checks that the policy can be applied, once the options are set.
+func (w *StableFileWatcher) validateUnstablePolicy() error {
+	if w.MaxStabilizeWait > 0 && w.UnstablePolicy == UnstableQuarantine && w.QuarantineDir == "" {
+		return errors.Errorf("the %s unstable policy requires a quarantine directory", UnstableQuarantine)
+	}
+	return nil
+}
+
+// maxStabilizeWait returns a channel that receives once the file has been waited on for the
+// MaxStabilizeWait, or nil when there isn't one, and a func to stop the timer.
+func (w *StableFileWatcher) maxStabilizeWait() (<-chan time.Time, func()) {
+	if w.MaxStabilizeWait <= 0 {
+		return nil, func() {}
+	}
+	timer := time.NewTimer(w.MaxStabilizeWait)
+	return timer.C, func() { timer.Stop() }
+}
+
+// handleUnstable applies the UnstablePolicy to a file that was still changing after the
+// MaxStabilizeWait, once it is no longer tracked. Returns true when the file should be signaled anyway.
+func (w *StableFileWatcher) handleUnstable(path string) bool {
+	switch w.UnstablePolicy {
+	case UnstableQuarantine:
+		dest, err := w.quarantine(path)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "%s is still changing after %s, unable to quarantine it", path, w.MaxStabilizeWait))
+		} else {
+			log.Printf("%s is still changing after %s, quarantined it to %s\n", path, w.MaxStabilizeWait, dest)
+		}
+		w.reject(path, RejectUnstable)
+		return false
+	case UnstableHold:
+		w.mu.Lock()
+		w.heldFiles[path] = true
+		w.mu.Unlock()
+
+		log.Printf("%s is still changing after %s, holding it for manual review\n", path, w.MaxStabilizeWait)
+		w.reject(path, RejectHeld)
+		if w.OnHold != nil {
+			w.OnHold(path)
+		}
+		return false
+	default:
+		log.Printf("%s is still changing after %s, signaling it anyway\n", path, w.MaxStabilizeWait)
+		return true
+	}
+}
+
+// quarantine moves the file to the QuarantineDir, at the same path that it had in the watch
+// directory, returning where it was moved.
+func (w *StableFileWatcher) quarantine(path string) (string, error) {
+	dest := w.QuarantineDir
+	if rel, err := filepath.Rel(w.watchDir, path); err == nil {
+		dest = filepath.Join(w.QuarantineDir, rel)
+	}
+	return dest, w.Sandbox.MoveFile(path, dest)
+}
+
+// isHeld determines if the file is held for manual review by the UnstablePolicy.
+func (w *StableFileWatcher) isHeld(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.heldFiles[path]
+}
+
+// Held lists the files that are held for manual review by the UnstablePolicy, sorted by path.
+func (w *StableFileWatcher) Held() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	held := make([]string, 0, len(w.heldFiles))
+	for path := range w.heldFiles {
+		held = append(held, path)
+	}
+	sort.Strings(held)
+	return held
+}
+
+// Release waits again for a file that is held for manual review to be stable, and signals it
+// once it is. Returns false when the file isn't held, or the watcher stopped, in which case
+// the file stays held because it can no longer be signaled.
+func (w *StableFileWatcher) Release(path string) bool {
+	w.mu.Lock()
+	if w.stopped {
+		w.mu.Unlock()
+		return false
+	}
+	held := w.heldFiles[path]
+	delete(w.heldFiles, path)
+	delete(w.stalledFiles, path)
+	w.mu.Unlock()
+
+	if !held {
+		return false
+	}
+	if _, err := os.Stat(path); err != nil {
+		return true
+	}
+	log.Printf("released %s from manual review\n", path)
+	w.schedule(path)
+	return true
+}
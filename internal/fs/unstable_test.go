@@ -0,0 +1,234 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The files in these tests never stabilize, because the threshold is longer than the test
+
+func TestStableFileWatcher_UnstableEmit(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w, err := NewStableFileWatcher(tmpDir, time.Hour, WithMaxStabilizeWait(200*time.Millisecond, UnstableEmit))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-w.Events:
+		if e.Path != path {
+			t.Fatalf("expected %s to be signaled, got %s", path, e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the unstable file to be signaled anyway")
+	}
+}
+
+func TestStableFileWatcher_UnstableQuarantine(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	quarantineDir := filepath.Join(tmpDir, "quarantine")
+	w, err := NewStableFileWatcher(tmpDir, time.Hour, WithMaxStabilizeWait(200*time.Millisecond, UnstableQuarantine), WithQuarantineDir(quarantineDir))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join(tmpDir, "Movies", "foo.mkv")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != path || r.Reason != RejectUnstable {
+			t.Fatalf("expected %s to be rejected as unstable, got %#v", path, r)
+		}
+	case e := <-w.Events:
+		t.Fatalf("expected the unstable file to not be signaled, got %s", e.Path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the unstable file to be rejected")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("expected the unstable file to be moved out of the watch directory")
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, "Movies", "foo.mkv")); err != nil {
+		t.Fatalf("expected the unstable file to be quarantined: %s", err)
+	}
+	if !w.isIgnored(filepath.Join(quarantineDir, "Movies", "foo.mkv")) {
+		t.Fatal("expected the quarantined files to be ignored")
+	}
+}
+
+func TestStableFileWatcher_UnstableQuarantineSandbox(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	quarantineDir := filepath.Join(tmpDir, "quarantine")
+	os.MkdirAll(watchDir, 0755)
+	sandbox, err := NewSandbox(watchDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	w, err := NewStableFileWatcher(watchDir, time.Hour, WithMaxStabilizeWait(200*time.Millisecond, UnstableQuarantine),
+		WithQuarantineDir(quarantineDir), WithSandbox(sandbox))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join(watchDir, "foo.mkv")
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != path || r.Reason != RejectUnstable {
+			t.Fatalf("expected %s to be rejected as unstable, got %#v", path, r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the unstable file to be rejected")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the unstable file to be left in place, the quarantine directory is outside of the sandbox: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, "foo.mkv")); !os.IsNotExist(err) {
+		t.Fatal("expected the unstable file to not be quarantined outside of the sandbox")
+	}
+}
+
+func TestStableFileWatcher_UnstableHold(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	alerts := make(chan string, 1)
+	w, err := NewStableFileWatcher(tmpDir, time.Hour, WithMaxStabilizeWait(200*time.Millisecond, UnstableHold),
+		WithHoldAlert(func(path string) { alerts <- path }))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	path := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != path || r.Reason != RejectHeld {
+			t.Fatalf("expected %s to be held, got %#v", path, r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the unstable file to be held")
+	}
+	if got := <-alerts; got != path {
+		t.Fatalf("expected an alert for %s, got %s", path, got)
+	}
+	if held := w.Held(); len(held) != 1 || held[0] != path {
+		t.Fatalf("expected only %s to be held, got %v", path, held)
+	}
+
+	// Changes to a held file are ignored
+	err = ioutil.WriteFile(path, []byte("foo bar"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := w.Stabilizing(); n != 0 {
+		t.Fatalf("expected the held file to not be waited on, got %d", n)
+	}
+
+	// Once released, the file is waited on again
+	w.SetStableThreshold(100 * time.Millisecond)
+	if !w.Release(path) {
+		t.Fatal("expected the held file to be released")
+	}
+	select {
+	case e := <-w.Events:
+		if e.Path != path {
+			t.Fatalf("expected %s to be signaled, got %s", path, e.Path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the released file to be signaled")
+	}
+	if w.Release(path) {
+		t.Fatal("expected a file that isn't held to not be released")
+	}
+}
+
+func TestRelease_Stopped(t *testing.T) {
+	path := filepath.Join("watch", "foo.mkv")
+	w := &StableFileWatcher{heldFiles: map[string]bool{path: true}, stopped: true}
+
+	if w.Release(path) {
+		t.Fatal("expected a held file to not be released once the watcher stopped")
+	}
+	if held := w.Held(); len(held) != 1 || held[0] != path {
+		t.Fatalf("expected %s to stay held, got %v", path, held)
+	}
+}
+
+func TestWithMaxStabilizeWait_Invalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testcases := map[string][]Option{
+		"negative wait":                {WithMaxStabilizeWait(-time.Second, UnstableEmit)},
+		"unknown policy":               {WithMaxStabilizeWait(time.Second, UnstablePolicy("retry"))},
+		"quarantine without directory": {WithMaxStabilizeWait(time.Second, UnstableQuarantine)},
+	}
+	for name, opts := range testcases {
+		t.Run(name, func(t *testing.T) {
+			w, err := NewStableFileWatcher(tmpDir, time.Second, opts...)
+			if err == nil {
+				w.Close()
+				t.Fatal("expected the options to be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package fs
+
+import (
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// walkWatchFile returns the watched file, when it exists and should be signaled.
+func (w *StableFileWatcher) walkWatchFile(include func(path string) bool) []string {
+	info, err := os.Stat(w.watchFile)
+	if err != nil || !info.Mode().IsRegular() || !include(w.watchFile) {
+		return nil
+	}
+	return []string{w.watchFile}
+}
+
+// watchFileEvent waits for the watched file to be stable after it was written, or
+// replaced, ignoring the events of the other files in its directory. A replaced file
+// is fsnotify.Rename or fsnotify.Remove followed by fsnotify.Create, or only
+// fsnotify.Create when another file is renamed over it, and is waited on from the
+// beginning, instead of waiting on the file that it replaced. The file is watched through
+// the events of its directory, because a replaced file is a new inode, and a watch of the
+// old inode would never see the changes to the new file.
+func (w *StableFileWatcher) watchFileEvent(e fsnotify.Event) {
+	if e.Name != w.watchFile {
+		return
+	}
+	if e.Op&fsnotify.Create != 0 {
+		w.cancelWait(e.Name)
+	}
+	if e.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+		return
+	}
+
+	info, err := os.Stat(e.Name)
+	if (isFileInUse(err) || (err == nil && info.Mode().IsRegular())) && w.matches(e.Name) {
+		w.schedule(e.Name)
+	}
+}
@@ -0,0 +1,82 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStableFileWatcher_WatchFile(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "recording.mkv")
+	err = ioutil.WriteFile(path, []byte("first"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	threshold := 200 * time.Millisecond
+	w, err := NewStableFileWatcher(path, threshold)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer w.Close()
+
+	waitForEvent := func(step string) {
+		select {
+		case e := <-w.Events:
+			if e.Path != path {
+				t.Fatalf("expected only %s to be signaled, got %s", path, e.Path)
+			}
+			if e.WatchRoot != tmpDir {
+				t.Fatalf("expected the watch root to be the directory of the file, got %q", e.WatchRoot)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %s to be signaled when it was %s", path, step)
+		}
+	}
+	waitForEvent("found on startup")
+
+	// The other files in the directory are ignored
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "other.mkv"), []byte("other"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// Replaced atomically with a rename, which is a new inode
+	tmp := filepath.Join(tmpDir, ".recording.mkv.tmp")
+	err = ioutil.WriteFile(tmp, []byte("second"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForEvent("replaced")
+
+	// Removed and recreated
+	err = os.Remove(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(path, []byte("third"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	waitForEvent("recreated")
+
+	select {
+	case e := <-w.Events:
+		t.Fatalf("expected no other events, got %s", e.Path)
+	case <-time.After(2 * threshold):
+	}
+}
@@ -0,0 +1,49 @@
+package fs
+
+import (
+	"os"
+	"sort"
+	"strings"
+)
+
+// WatchedDirs lists the directories that are currently watched, which
+// changes as directories are created and removed in the watch directory.
+func (w *StableFileWatcher) WatchedDirs() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	dirs := make([]string, 0, len(w.watchedDirs))
+	for dir := range w.watchedDirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// addWatch starts watching the directory, recording it in the watched directories.
+func (w *StableFileWatcher) addWatch(dir string) error {
+	err := w.dirWatcher.Add(dir)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.watchedDirs[dir] = true
+	return nil
+}
+
+// removeWatch stops watching the removed directory and the directories
+// that were in it.
+func (w *StableFileWatcher) removeWatch(dir string) {
+	w.dirWatcher.Remove(dir)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prefix := dir + string(os.PathSeparator)
+	for d := range w.watchedDirs {
+		if d == dir || strings.HasPrefix(d, prefix) {
+			delete(w.watchedDirs, d)
+		}
+	}
+}
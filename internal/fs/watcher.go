@@ -0,0 +1,56 @@
+package fs
+
+// Watcher signals the files that are ready to be processed, from a directory
+// or another source such as an object store.
+type Watcher interface {
+	// Files signals each file that is ready to be processed, and is closed when the watcher is closed.
+	Files() <-chan FileEvent
+
+	// Rejections signals the files that were skipped.
+	Rejections() <-chan RejectedFile
+
+	// Close stops watching for files.
+	Close()
+}
+
+// Pauser is a Watcher that can stop looking for new files, without losing track
+// of the files that it already found. Files found while paused are checked on resume.
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
+// DirLister is a Watcher that can report the directories it is watching.
+type DirLister interface {
+	WatchedDirs() []string
+}
+
+// Stabilizer is a Watcher that can report how many files it is waiting on to stop changing.
+type Stabilizer interface {
+	Stabilizing() int
+}
+
+// Rescanner is a Watcher that can change which files it signals while it is running,
+// checking the files that it already found against the new filter.
+type Rescanner interface {
+	Rescan(pathRegex string) (int, error)
+}
+
+// Holder is a Watcher that holds files for manual review, such as files that never stop
+// changing, until they are released.
+type Holder interface {
+	Held() []string
+	Release(path string) bool
+}
+
+// Remover is a Watcher that can signal the files that were removed, such as to clean up
+// what was produced from them. Removed is nil when removals aren't signaled.
+type Remover interface {
+	Removed() <-chan FileEvent
+}
+
+// InitialScanner is a Watcher that reports when it has finished with the files that were
+// already there when it started, so that a backlog can be told apart from new files.
+type InitialScanner interface {
+	InitialScanDone() <-chan struct{}
+}
@@ -0,0 +1,7 @@
+package fs
+
+import "github.com/pkg/errors"
+
+// ErrXattrUnsupported is returned by Xattr when the platform, or the file system of the
+// file, doesn't support extended attributes.
+var ErrXattrUnsupported = errors.New("extended attributes are not supported")
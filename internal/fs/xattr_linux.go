@@ -0,0 +1,34 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Xattr reads the extended attribute of the file, returning false when the file doesn't
+// have it. Returns ErrXattrUnsupported when the file system doesn't support extended attributes.
+func Xattr(path, name string) (string, bool, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	for err == nil {
+		value := make([]byte, size)
+		var n int
+		n, err = unix.Getxattr(path, name, value)
+		if err == nil {
+			return string(value[:n]), true, nil
+		}
+		// The value grew since its size was read
+		if err == unix.ERANGE {
+			size, err = unix.Getxattr(path, name, nil)
+		}
+	}
+	switch err {
+	case unix.ENODATA:
+		return "", false, nil
+	case unix.ENOTSUP:
+		return "", false, ErrXattrUnsupported
+	}
+	return "", false, errors.Wrapf(err, "unable to read the extended attribute %s of %s", name, path)
+}
@@ -0,0 +1,43 @@
+//go:build linux
+// +build linux
+
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXattr(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(path, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	_, ok, err := Xattr(path, "user.processed")
+	if err == ErrXattrUnsupported {
+		t.Skip("the temp directory doesn't support extended attributes")
+	}
+	if err != nil || ok {
+		t.Fatalf("expected the file to not have the attribute, got %v, %#v", ok, err)
+	}
+
+	if err := unix.Setxattr(path, "user.processed", []byte("yes"), 0); err != nil {
+		t.Skipf("unable to set an extended attribute: %s", err)
+	}
+	value, ok, err := Xattr(path, "user.processed")
+	if err != nil || !ok || value != "yes" {
+		t.Fatalf("expected the attribute to be yes, got %q, %v, %#v", value, ok, err)
+	}
+}
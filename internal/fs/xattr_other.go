@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package fs
+
+// Xattr isn't supported on this platform, and always returns ErrXattrUnsupported.
+func Xattr(path, name string) (string, bool, error) {
+	return "", false, ErrXattrUnsupported
+}
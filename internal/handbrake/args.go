@@ -0,0 +1,29 @@
+package handbrake
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Args are HandBrakeCLI arguments. They may be used as a flag, with each use
+// adding one argument, for example -handbrake-arg=--all-audio -handbrake-arg=--markers.
+type Args []string
+
+// String formats the arguments, separated by spaces.
+func (a *Args) String() string {
+	if a == nil {
+		return ""
+	}
+	return strings.Join(*a, " ")
+}
+
+// Set adds an argument.
+func (a *Args) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return errors.New("invalid HandBrakeCLI argument, must not be empty")
+	}
+	*a = append(*a, value)
+	return nil
+}
@@ -0,0 +1,45 @@
+package handbrake
+
+import (
+	"sort"
+	"strings"
+)
+
+// containerFormats are the HandBrakeCLI --format of each supported output extension.
+var containerFormats = map[string]string{
+	".mp4":  "av_mp4",
+	".m4v":  "av_mp4",
+	".mkv":  "av_mkv",
+	".webm": "av_webm",
+}
+
+// Containers lists the supported output extensions, such as .mp4, sorted.
+func Containers() []string {
+	var exts []string
+	for ext := range containerFormats {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// IsContainer determines if the extension, such as .mp4, is a supported output container.
+func IsContainer(ext string) bool {
+	_, ok := containerFormats[strings.ToLower(ext)]
+	return ok
+}
+
+// ContainerArgs are the HandBrakeCLI arguments to write the container of the extension,
+// instead of the container of the preset. An MP4 is optimized for streaming, with the
+// index at the start of the file. Empty when the extension isn't a supported container.
+func ContainerArgs(ext string) []string {
+	format, ok := containerFormats[strings.ToLower(ext)]
+	if !ok {
+		return nil
+	}
+	args := []string{"--format", format}
+	if format == "av_mp4" {
+		args = append(args, "--optimize")
+	}
+	return args
+}
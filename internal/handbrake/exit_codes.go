@@ -0,0 +1,66 @@
+package handbrake
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ExitCodes are the nonzero exit codes of HandBrakeCLI that are treated as a
+// successful transcode, for example when an encoder wrapper exits with a
+// warning. Exit code 0 is always a success.
+type ExitCodes []int
+
+// String is a comma separated list of the exit codes.
+func (c ExitCodes) String() string {
+	codes := make([]string, len(c))
+	for i, code := range c {
+		codes[i] = strconv.Itoa(code)
+	}
+	return strings.Join(codes, ",")
+}
+
+// Set the exit codes from a comma separated list, such as 2,3.
+func (c *ExitCodes) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		code, err := strconv.Atoi(s)
+		if err != nil || code < 0 || code > 255 {
+			return errors.Errorf("invalid exit code %q, must be between 0 and 255", s)
+		}
+		*c = append(*c, code)
+	}
+	return nil
+}
+
+// Contains determines if the exit code is treated as a success.
+func (c ExitCodes) Contains(code int) bool {
+	if code == 0 {
+		return true
+	}
+	for _, ok := range c {
+		if ok == code {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCode of a command that exited unsuccessfully, or false when it couldn't run.
+func exitCode(err error) (int, bool) {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || status.Signaled() {
+		return 0, false
+	}
+	return status.ExitStatus(), true
+}
@@ -0,0 +1,26 @@
+package handbrake
+
+import "testing"
+
+func TestExitCodes_Set(t *testing.T) {
+	var codes ExitCodes
+	err := codes.Set("2, 3")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if codes.String() != "2,3" {
+		t.Fatalf("expected 2,3, got %s", codes.String())
+	}
+
+	for code, want := range map[int]bool{0: true, 1: false, 2: true, 3: true} {
+		if got := codes.Contains(code); got != want {
+			t.Fatalf("expected Contains(%d) to be %t", code, want)
+		}
+	}
+
+	for _, value := range []string{"warning", "-1", "256"} {
+		if err := codes.Set(value); err == nil {
+			t.Fatalf("expected %q to be an invalid exit code", value)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package handbrake
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PresetFile is a set of custom presets exported from HandBrake, e.g. presets.json.
+type PresetFile struct {
+	PresetList []Preset
+}
+
+// Preset is a HandBrake preset, or a folder of presets.
+type Preset struct {
+	PresetName    string
+	Folder        bool
+	ChildrenArray []Preset
+}
+
+// LoadPresetFile reads a HandBrake preset file.
+func LoadPresetFile(path string) (*PresetFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open preset file %s", path)
+	}
+	defer f.Close()
+
+	var presets PresetFile
+	err = json.NewDecoder(f).Decode(&presets)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse preset file %s", path)
+	}
+	return &presets, nil
+}
+
+// Names lists the presets in the file, including presets nested in folders.
+func (f PresetFile) Names() []string {
+	var names []string
+	var walk func(presets []Preset)
+	walk = func(presets []Preset) {
+		for _, p := range presets {
+			if p.Folder {
+				walk(p.ChildrenArray)
+			} else {
+				names = append(names, p.PresetName)
+			}
+		}
+	}
+	walk(f.PresetList)
+	return names
+}
+
+// HasPreset determines if the file defines the preset.
+func (f PresetFile) HasPreset(name string) bool {
+	for _, n := range f.Names() {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePreset checks that the preset is defined in the preset file.
+func ValidatePreset(presetFile, preset string) error {
+	presets, err := LoadPresetFile(presetFile)
+	if err != nil {
+		return err
+	}
+
+	if !presets.HasPreset(preset) {
+		return errors.Errorf("preset %q is not defined in %s, available presets: %v", preset, presetFile, presets.Names())
+	}
+	return nil
+}
+
+// BuiltinPresets lists the presets that are built into HandBrakeCLI.
+func BuiltinPresets(cli string) ([]string, error) {
+	output, err := exec.Command(cli, "--preset-list").CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the presets of %s: %s", cli, strings.TrimSpace(string(output)))
+	}
+	return parsePresetList(string(output)), nil
+}
+
+// parsePresetList reads the preset names from the output of HandBrakeCLI --preset-list, which
+// lists each category, then its presets indented by 4 spaces, each followed by its description
+// indented further. Log lines aren't indented.
+func parsePresetList(output string) []string {
+	var names []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "    ") && !strings.HasPrefix(line, "     ") {
+			names = append(names, strings.TrimSpace(line))
+		}
+	}
+	return names
+}
@@ -0,0 +1,65 @@
+package handbrake
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testPresetFile = filepath.Join("..", "..", "cmd", "handbrakecli", "presets.json")
+
+func TestValidatePreset(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Preset  string
+		WantErr bool
+	}{
+		{Name: "defined", Preset: "tivo"},
+		{Name: "undefined", Preset: "roku", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidatePreset(testPresetFile, tc.Preset)
+			if tc.WantErr && err == nil {
+				t.Fatal("expected the preset to be invalid")
+			}
+			if !tc.WantErr && err != nil {
+				t.Fatalf("%+v", err)
+			}
+		})
+	}
+}
+
+func TestPresetFile_Names_Nested(t *testing.T) {
+	presets := PresetFile{
+		PresetList: []Preset{
+			{PresetName: "tivo"},
+			{PresetName: "Mine", Folder: true, ChildrenArray: []Preset{{PresetName: "phone"}}},
+		},
+	}
+
+	names := presets.Names()
+	if len(names) != 2 || names[0] != "tivo" || names[1] != "phone" {
+		t.Fatalf("expected [tivo phone], got %v", names)
+	}
+}
+
+func TestParsePresetList(t *testing.T) {
+	output := `[12:00:00] hb_init: starting libhb thread
+General/
+    Very Fast 1080p30
+        Small H.264 video (up to 1080p30) and AAC stereo audio, in an
+        MP4 container.
+    Fast 480p30
+        H.264 video (up to 480p30) and AAC stereo audio, in an MP4 container.
+Devices/
+    Roku 2160p60 4K HEVC Surround
+        HEVC video (up to 2160p60) and AAC stereo audio, in an MKV container.
+`
+	got := parsePresetList(output)
+	want := []string{"Very Fast 1080p30", "Fast 480p30", "Roku 2160p60 4K HEVC Surround"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the presets %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,177 @@
+package handbrake
+
+import (
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Progress of an encode, parsed from the HandBrakeCLI output.
+type Progress struct {
+	// Task is the current pass of the encode, out of Tasks, e.g. 2 of 2 for the second pass.
+	Task  int `json:"task"`
+	Tasks int `json:"tasks"`
+
+	// Percent of the current task that is complete.
+	Percent float64 `json:"percent"`
+
+	// FPS is the current encoding speed, AvgFPS is the average speed of the task, and ETA is
+	// how long HandBrakeCLI estimates the task has left. They are 0 until HandBrakeCLI reports them.
+	FPS    float64       `json:"fps,omitempty"`
+	AvgFPS float64       `json:"avgFps,omitempty"`
+	ETA    time.Duration `json:"eta,omitempty"`
+}
+
+// progressPattern matches the status line that HandBrakeCLI rewrites as it encodes, e.g.
+// "Encoding: task 1 of 1, 45.12 % (63.98 fps, avg 64.10 fps, ETA 00h10m20s)"
+var progressPattern = regexp.MustCompile(`Encoding: task (\d+) of (\d+), (\d+(?:\.\d+)?) %` +
+	`(?: \((\d+(?:\.\d+)?) fps, avg (\d+(?:\.\d+)?) fps, ETA (\d+)h(\d+)m(\d+)s\))?`)
+
+// ParseProgress returns the last progress in the HandBrakeCLI output,
+// returning false when the output doesn't have any progress.
+func ParseProgress(output []byte) (Progress, bool) {
+	matches := progressPattern.FindAllSubmatch(output, -1)
+	if len(matches) == 0 {
+		return Progress{}, false
+	}
+
+	m := matches[len(matches)-1]
+	var p Progress
+	p.Task, _ = strconv.Atoi(string(m[1]))
+	p.Tasks, _ = strconv.Atoi(string(m[2]))
+	p.Percent, _ = strconv.ParseFloat(string(m[3]), 64)
+	if len(m[4]) > 0 {
+		p.FPS, _ = strconv.ParseFloat(string(m[4]), 64)
+		p.AvgFPS, _ = strconv.ParseFloat(string(m[5]), 64)
+		h, _ := strconv.Atoi(string(m[6]))
+		min, _ := strconv.Atoi(string(m[7]))
+		sec, _ := strconv.Atoi(string(m[8]))
+		p.ETA = time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec)*time.Second
+	}
+	return p, true
+}
+
+// sameStep determines if the encode is still at the same task and percent, regardless of its speed.
+func (p Progress) sameStep(other Progress) bool {
+	return p.Task == other.Task && p.Tasks == other.Tasks && p.Percent == other.Percent
+}
+
+// maxProgressTail is how much of the previous write is kept, in case a status line is split across writes.
+const maxProgressTail = 256
+
+// NewProgressWriter parses the progress from the HandBrakeCLI output written to it, such as
+// the logs of a transcode pod, and reports it.
+func NewProgressWriter(report func(Progress)) io.Writer {
+	return &progressWriter{report: report}
+}
+
+// progressWriter parses the progress from the HandBrakeCLI output as it is written.
+type progressWriter struct {
+	report func(Progress)
+	tail   []byte
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	buf := append(w.tail, b...)
+	if p, ok := ParseProgress(buf); ok {
+		w.report(p)
+	}
+
+	// HandBrakeCLI rewrites the status line with a carriage return, keep whatever is after the last one
+	for i := len(buf) - 1; i >= 0; i-- {
+		if buf[i] == '\r' || buf[i] == '\n' {
+			buf = buf[i+1:]
+			break
+		}
+	}
+	if len(buf) > maxProgressTail {
+		buf = buf[len(buf)-maxProgressTail:]
+	}
+	w.tail = append([]byte(nil), buf...)
+
+	return len(b), nil
+}
+
+// Watchdog detects an encode that is taking far longer than expected, because it
+// is stuck, thrashing or on the wrong hardware. Each condition is only alerted once,
+// except for a stall, which is alerted again if the encode stalls after it recovers.
+type Watchdog struct {
+	// ExpectedDuration is how long the encode should take, before it is considered long-running.
+	// 0 disables the check.
+	ExpectedDuration time.Duration
+
+	// StallTimeout is how long the progress may stay the same before the encode is considered stalled.
+	// It only applies once progress is reported, an encode without progress is only checked
+	// against ExpectedDuration. 0 disables the check.
+	StallTimeout time.Duration
+
+	mu           sync.Mutex
+	started      time.Time
+	progress     *Progress
+	advancedAt   time.Time
+	alertedLong  bool
+	alertedStall bool
+}
+
+// NewWatchdog watches an encode that started at the specified time.
+func NewWatchdog(started time.Time, expectedDuration, stallTimeout time.Duration) *Watchdog {
+	return &Watchdog{
+		ExpectedDuration: expectedDuration,
+		StallTimeout:     stallTimeout,
+		started:          started,
+		advancedAt:       started,
+	}
+}
+
+// Report records the latest progress of the encode.
+func (w *Watchdog) Report(p Progress, now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.progress == nil || !w.progress.sameStep(p) {
+		w.progress = &p
+		w.advancedAt = now
+		w.alertedStall = false
+	}
+}
+
+// Check returns an alert describing why the encode is taking too long,
+// returning false when it is on track or was already alerted.
+func (w *Watchdog) Check(now time.Time) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.StallTimeout > 0 && w.progress != nil && !w.alertedStall {
+		stalled := now.Sub(w.advancedAt)
+		if stalled >= w.StallTimeout {
+			w.alertedStall = true
+			return "progress has been stuck at " + formatProgress(*w.progress) +
+				" for " + stalled.Round(time.Second).String(), true
+		}
+	}
+
+	if w.ExpectedDuration > 0 && !w.alertedLong {
+		elapsed := now.Sub(w.started)
+		if elapsed >= w.ExpectedDuration {
+			w.alertedLong = true
+			msg := "has been encoding for " + elapsed.Round(time.Second).String() +
+				", longer than the expected " + w.ExpectedDuration.String()
+			if w.progress != nil {
+				msg += ", and is at " + formatProgress(*w.progress)
+			}
+			return msg, true
+		}
+	}
+
+	return "", false
+}
+
+func formatProgress(p Progress) string {
+	s := strconv.FormatFloat(p.Percent, 'f', 2, 64) + "%"
+	if p.Tasks > 1 {
+		s += " of task " + strconv.Itoa(p.Task) + " of " + strconv.Itoa(p.Tasks)
+	}
+	return s
+}
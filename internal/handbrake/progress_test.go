@@ -0,0 +1,81 @@
+package handbrake
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgress(t *testing.T) {
+	output := "Encoding: task 1 of 2, 99.50 % (60.12 fps, avg 61.00 fps, ETA 00h00m02s)\r" +
+		"Encoding: task 2 of 2, 3.25 % (58.40 fps, avg 59.80 fps, ETA 00h20m11s)\r"
+
+	p, ok := ParseProgress([]byte(output))
+	if !ok {
+		t.Fatal("expected the progress to be parsed")
+	}
+	want := Progress{Task: 2, Tasks: 2, Percent: 3.25, FPS: 58.4, AvgFPS: 59.8, ETA: 20*time.Minute + 11*time.Second}
+	if p != want {
+		t.Fatalf("expected %#v, got %#v", want, p)
+	}
+
+	_, ok = ParseProgress([]byte("[12:00:00] libhb: scan thread found 1 valid title(s)\n"))
+	if ok {
+		t.Fatal("expected output without progress to not be parsed")
+	}
+}
+
+func TestProgressWriter_SplitWrites(t *testing.T) {
+	var got []Progress
+	w := &progressWriter{report: func(p Progress) { got = append(got, p) }}
+
+	w.Write([]byte("Encoding: task 1 of 1, 10.00 %\rEncoding: task 1 of 1, 2"))
+	w.Write([]byte("0.50 % (60.00 fps)\r"))
+
+	if len(got) != 2 || got[1].Percent != 20.5 {
+		t.Fatalf("expected the progress split across writes to be parsed, got %#v", got)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("long-running", func(t *testing.T) {
+		dog := NewWatchdog(start, time.Hour, 0)
+		if _, ok := dog.Check(start.Add(59 * time.Minute)); ok {
+			t.Fatal("expected no alert before the expected duration")
+		}
+		alert, ok := dog.Check(start.Add(61 * time.Minute))
+		if !ok || !strings.Contains(alert, "longer than the expected 1h0m0s") {
+			t.Fatalf("expected a long-running alert, got %q", alert)
+		}
+		if _, ok := dog.Check(start.Add(2 * time.Hour)); ok {
+			t.Fatal("expected the long-running alert to only be sent once")
+		}
+	})
+
+	t.Run("stalled", func(t *testing.T) {
+		dog := NewWatchdog(start, 0, 10*time.Minute)
+		if _, ok := dog.Check(start.Add(time.Hour)); ok {
+			t.Fatal("expected no stall alert without any progress")
+		}
+
+		dog.Report(Progress{Task: 1, Tasks: 1, Percent: 45}, start.Add(time.Hour))
+		dog.Report(Progress{Task: 1, Tasks: 1, Percent: 45}, start.Add(time.Hour+5*time.Minute))
+		alert, ok := dog.Check(start.Add(time.Hour + 10*time.Minute))
+		if !ok || !strings.Contains(alert, "stuck at 45.00%") {
+			t.Fatalf("expected a stall alert, got %q", alert)
+		}
+		if _, ok := dog.Check(start.Add(time.Hour + 20*time.Minute)); ok {
+			t.Fatal("expected the stall alert to only be sent once per stall")
+		}
+
+		dog.Report(Progress{Task: 1, Tasks: 1, Percent: 46}, start.Add(time.Hour+30*time.Minute))
+		if _, ok := dog.Check(start.Add(time.Hour + 35*time.Minute)); ok {
+			t.Fatal("expected no alert once the progress advanced")
+		}
+		if _, ok := dog.Check(start.Add(time.Hour + 40*time.Minute)); !ok {
+			t.Fatal("expected another alert when the encode stalled again")
+		}
+	})
+}
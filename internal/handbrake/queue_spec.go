@@ -0,0 +1,89 @@
+package handbrake
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// QueueSpec is a job exported from the queue of the HandBrake GUI, with every setting
+// that the GUI can express, which is imported by HandBrakeCLI with --queue-import-file
+// instead of a preset. The source and destination of the exported job are replaced
+// with the paths of each video.
+type QueueSpec struct {
+	// Path of the exported queue.
+	Path string
+
+	job []byte
+}
+
+// LoadQueueSpec reads a queue exported from the HandBrake GUI, which must hold a single job.
+func LoadQueueSpec(path string) (*QueueSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read the queue spec %s", path)
+	}
+
+	var queue []json.RawMessage
+	err = json.Unmarshal(data, &queue)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid queue spec %s, must be a queue exported from HandBrake", path)
+	}
+	if len(queue) != 1 {
+		return nil, errors.Errorf("invalid queue spec %s, must hold a single job, got %d", path, len(queue))
+	}
+
+	q := &QueueSpec{Path: path, job: queue[0]}
+	if _, err := q.For("in", "out"); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// For returns the queue, with the source and destination of its job replaced with the paths.
+// Every other setting of the job is kept as it was exported.
+func (q *QueueSpec) For(inputPath, outputPath string) ([]byte, error) {
+	var item map[string]interface{}
+	err := json.Unmarshal(q.job, &item)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid queue spec %s", q.Path)
+	}
+	job, ok := item["Job"].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid queue spec %s, the job is missing its Job settings", q.Path)
+	}
+	source, ok := job["Source"].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid queue spec %s, the job is missing its Source settings", q.Path)
+	}
+	source["Path"] = inputPath
+	destination, ok := job["Destination"].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("invalid queue spec %s, the job is missing its Destination settings", q.Path)
+	}
+	destination["File"] = outputPath
+
+	data, err := json.MarshalIndent([]interface{}{item}, "", "  ")
+	return data, errors.Wrapf(err, "unable to serialize the queue spec %s", q.Path)
+}
+
+// WriteFor writes the queue for the paths to the spec path, see For.
+func (q *QueueSpec) WriteFor(specPath, inputPath, outputPath string) error {
+	data, err := q.For(inputPath, outputPath)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(specPath), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(specPath, data, 0644)
+	}
+	return errors.Wrapf(err, "unable to write the queue spec %s", specPath)
+}
+
+// QueueSpecPath is where the queue for a video is written, next to its output.
+func QueueSpecPath(outputPath string) string {
+	return filepath.Join(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".queue.json")
+}
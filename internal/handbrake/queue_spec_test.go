@@ -0,0 +1,93 @@
+package handbrake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadQueueSpec(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Queue   string
+		WantErr bool
+	}{
+		{Name: "single job", Queue: `[{"Job": {"Source": {"Path": "/gui/in.mkv", "Title": 2}, "Destination": {"File": "/gui/out.mp4", "Mux": "av_mp4"}}}]`},
+		{Name: "multiple jobs", Queue: `[{"Job": {"Source": {}, "Destination": {}}}, {"Job": {"Source": {}, "Destination": {}}}]`, WantErr: true},
+		{Name: "missing destination", Queue: `[{"Job": {"Source": {}}}]`, WantErr: true},
+		{Name: "preset file", Queue: `{"PresetList": []}`, WantErr: true},
+	}
+
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("queue%d.json", i))
+			err := ioutil.WriteFile(path, []byte(tc.Queue), 0644)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+
+			_, err = LoadQueueSpec(path)
+			if tc.WantErr && err == nil {
+				t.Fatal("expected the queue spec to be invalid")
+			}
+			if !tc.WantErr && err != nil {
+				t.Fatalf("%+v", err)
+			}
+		})
+	}
+}
+
+func TestQueueSpec_WriteFor(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := &QueueSpec{Path: "queue.json", job: []byte(`{"Job": {"Source": {"Path": "/gui/in.mkv", "Title": 2}, "Destination": {"File": "/gui/out.mp4", "Mux": "av_mp4"}}}`)}
+	specPath := QueueSpecPath(filepath.Join(dir, "movies", "out.mp4"))
+	err = q.WriteFor(specPath, "/work/claimed/in.mkv", "/work/transcoded/movies/out.mp4")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "movies", ".out.mp4.queue.json"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	var queue []struct {
+		Job struct {
+			Source struct {
+				Path  string
+				Title int
+			}
+			Destination struct {
+				File string
+				Mux  string
+			}
+		}
+	}
+	err = json.Unmarshal(data, &queue)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if len(queue) != 1 {
+		t.Fatalf("expected a single job, got %d", len(queue))
+	}
+	job := queue[0].Job
+	if job.Source.Path != "/work/claimed/in.mkv" || job.Destination.File != "/work/transcoded/movies/out.mp4" {
+		t.Fatalf("expected the source and destination to be replaced, got %s and %s", job.Source.Path, job.Destination.File)
+	}
+	if job.Source.Title != 2 || job.Destination.Mux != "av_mp4" {
+		t.Fatalf("expected the other settings to be kept, got %#v", job)
+	}
+}
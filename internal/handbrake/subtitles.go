@@ -0,0 +1,89 @@
+package handbrake
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// subtitleFormats are the HandBrakeCLI formats of the external subtitle files that it can import, by extension.
+var subtitleFormats = map[string]string{
+	".srt": "srt",
+	".ass": "ssa",
+	".ssa": "ssa",
+}
+
+// IsSubtitle determines if the file is an external subtitle file that HandBrakeCLI can import.
+func IsSubtitle(path string) bool {
+	_, ok := subtitleFormats[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// SubtitleExtensions are the extensions of the subtitle files that HandBrakeCLI can import, sorted.
+func SubtitleExtensions() []string {
+	exts := make([]string, 0, len(subtitleFormats))
+	for ext := range subtitleFormats {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// SubtitlesOf finds the subtitle files next to the video with the same base name, such as
+// foo.srt and foo.en.srt for foo.mkv, sorted by name.
+func SubtitlesOf(videoPath string) ([]string, error) {
+	dir := filepath.Dir(videoPath)
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the subtitles of %s", videoPath)
+	}
+	var subtitles []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !IsSubtitle(name) {
+			continue
+		}
+		// Allow a language, or other tag, such as foo.en.srt
+		nameBase := strings.TrimSuffix(name, filepath.Ext(name))
+		if nameBase == base || strings.HasPrefix(nameBase, base+".") {
+			subtitles = append(subtitles, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(subtitles)
+	return subtitles, nil
+}
+
+// SubtitleArgs are the HandBrakeCLI arguments that add the subtitle files to a video as
+// subtitle tracks, optionally burning the first one into the video instead.
+func SubtitleArgs(subtitles []string, burn bool) []string {
+	if len(subtitles) == 0 {
+		return nil
+	}
+
+	files := make(map[string][]string)
+	var formats []string
+	for _, path := range subtitles {
+		format := subtitleFormats[strings.ToLower(filepath.Ext(path))]
+		if format == "" {
+			continue
+		}
+		if len(files[format]) == 0 {
+			formats = append(formats, format)
+		}
+		files[format] = append(files[format], path)
+	}
+
+	var args []string
+	for _, format := range formats {
+		args = append(args, "--"+format+"-file", strings.Join(files[format], ","))
+	}
+	if burn && len(formats) > 0 {
+		args = append(args, "--"+formats[0]+"-burn=1")
+	}
+	return args
+}
@@ -0,0 +1,33 @@
+package handbrake
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSubtitlesOf(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"foo.mkv", "foo.srt", "foo.en.SRT", "foo.fr.ass", "foobar.srt", "foo.txt", "bar.srt"} {
+		err = ioutil.WriteFile(filepath.Join(tmpDir, name), nil, 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	got, err := SubtitlesOf(filepath.Join(tmpDir, "foo.mkv"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	want := []string{filepath.Join(tmpDir, "foo.en.SRT"), filepath.Join(tmpDir, "foo.fr.ass"), filepath.Join(tmpDir, "foo.srt")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the subtitles %v, got %v", want, got)
+	}
+}
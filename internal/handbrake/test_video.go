@@ -0,0 +1,56 @@
+package handbrake
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Dimensions of the test video, which are kept tiny so that it transcodes in a few seconds.
+const (
+	testVideoWidth  = 160
+	testVideoHeight = 120
+	testVideoFPS    = 25
+)
+
+// WriteTestVideo writes a short, uncompressed YUV4MPEG2 video of a moving gradient,
+// which HandBrakeCLI reads like any other video, to check that the encoder works.
+func WriteTestVideo(path string, seconds int) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create the directory of the test video %s", path)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create the test video %s", path)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "YUV4MPEG2 W%d H%d F%d:1 Ip A1:1 C420jpeg\n", testVideoWidth, testVideoHeight, testVideoFPS)
+	luma := make([]byte, testVideoWidth*testVideoHeight)
+	chroma := make([]byte, testVideoWidth*testVideoHeight/4)
+	for frame := 0; frame < seconds*testVideoFPS; frame++ {
+		for y := 0; y < testVideoHeight; y++ {
+			for x := 0; x < testVideoWidth; x++ {
+				luma[y*testVideoWidth+x] = byte(x + y + frame*4)
+			}
+		}
+		for i := range chroma {
+			chroma[i] = byte(128 + frame)
+		}
+		w.WriteString("FRAME\n")
+		w.Write(luma)
+		w.Write(chroma)
+		w.Write(chroma)
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return errors.Wrapf(err, "unable to write the test video %s", path)
+	}
+	return errors.Wrapf(f.Sync(), "unable to write the test video %s", path)
+}
@@ -0,0 +1,46 @@
+package handbrake
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTestVideo(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "selftest", "test.y4m")
+	err = WriteTestVideo(path, 2)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer f.Close()
+	header, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if !strings.HasPrefix(header, "YUV4MPEG2 W160 H120 F25:1") {
+		t.Fatalf("unexpected header %q", header)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	frameSize := len("FRAME\n") + testVideoWidth*testVideoHeight*3/2
+	if want := int64(len(header) + 50*frameSize); info.Size() != want {
+		t.Fatalf("expected 50 frames, %d bytes, got %d bytes", want, info.Size())
+	}
+}
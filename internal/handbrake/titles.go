@@ -0,0 +1,190 @@
+package handbrake
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LongestTitle is the default TitleRule, the longest title of a disc structure.
+const LongestTitle = "longest"
+
+// TitleRule selects which title of a source with several titles, such as a disc structure
+// with menus and extras, is transcoded. It may be used as a flag:
+//
+//	longest   the longest title of a disc structure, the default, a video file is left to HandBrakeCLI
+//	2         the title with that number
+//	40m-3h    the longest title whose duration is within the range, either end may be omitted
+//
+// The titles are found by scanning the source with HandBrakeCLI, which is skipped for a title number.
+type TitleRule struct {
+	// Number of the title, or 0 to select it by its duration.
+	Number int
+
+	// Min and Max bound the duration of the selected title. A zero Max is unbounded.
+	Min, Max time.Duration
+
+	value string
+}
+
+// String returns the rule as it was set.
+func (r *TitleRule) String() string {
+	if r == nil || r.value == "" {
+		return LongestTitle
+	}
+	return r.value
+}
+
+// Set parses the rule.
+func (r *TitleRule) Set(value string) error {
+	rule := TitleRule{value: value}
+	switch {
+	case value == "" || value == LongestTitle:
+		rule.value = ""
+	case !strings.Contains(value, "-"):
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return errors.Errorf("invalid title rule %q, must be longest, a title number or a duration range such as 40m-3h", value)
+		}
+		rule.Number = n
+	default:
+		parts := strings.SplitN(value, "-", 2)
+		var err error
+		if parts[0] != "" {
+			if rule.Min, err = time.ParseDuration(parts[0]); err != nil {
+				return errors.Wrapf(err, "invalid minimum duration in the title rule %q", value)
+			}
+		}
+		if parts[1] != "" {
+			if rule.Max, err = time.ParseDuration(parts[1]); err != nil {
+				return errors.Wrapf(err, "invalid maximum duration in the title rule %q", value)
+			}
+		}
+		if rule.Min < 0 || (rule.Max > 0 && rule.Max < rule.Min) || (rule.Min == 0 && rule.Max == 0) {
+			return errors.Errorf("invalid title rule %q, the duration range must be ascending", value)
+		}
+	}
+	*r = rule
+	return nil
+}
+
+// IsDefault determines if the rule selects the longest title.
+func (r TitleRule) IsDefault() bool {
+	return r.Number == 0 && r.Min == 0 && r.Max == 0
+}
+
+// NeedsScan determines if the titles of the source must be scanned to select one. Only the
+// disc structures are scanned for the longest title.
+func (r TitleRule) NeedsScan(isDir bool) bool {
+	if r.Number > 0 {
+		return false
+	}
+	return isDir || !r.IsDefault()
+}
+
+// Args are the HandBrakeCLI arguments for a title number, which doesn't need a scan.
+func (r TitleRule) Args() []string {
+	if r.Number == 0 {
+		return nil
+	}
+	return []string{"--title", strconv.Itoa(r.Number)}
+}
+
+// Title of a source, as reported by a HandBrakeCLI scan.
+type Title struct {
+	Number   int
+	Duration time.Duration
+}
+
+// Select the longest of the titles whose duration is within the range of the rule.
+func (r TitleRule) Select(titles []Title) (int, error) {
+	if r.Number > 0 {
+		return r.Number, nil
+	}
+
+	var selected Title
+	for _, t := range titles {
+		if t.Duration < r.Min || (r.Max > 0 && t.Duration > r.Max) {
+			continue
+		}
+		if selected.Number == 0 || t.Duration > selected.Duration {
+			selected = t
+		}
+	}
+	if selected.Number == 0 {
+		return 0, errors.Errorf("none of the %d titles match the title rule %s", len(titles), r.String())
+	}
+	return selected.Number, nil
+}
+
+// ScanTitles lists the titles of the source with HandBrakeCLI, without transcoding it.
+func ScanTitles(ctx context.Context, cli, inputPath string) ([]Title, error) {
+	// The scan is reported on stderr, along with the rest of the HandBrakeCLI logs
+	output, err := exec.CommandContext(ctx, cli, "-i", inputPath, "--title", "0", "--scan").CombinedOutput()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to scan the titles of %s", inputPath)
+	}
+	titles := parseTitles(output)
+	if len(titles) == 0 {
+		return nil, errors.Errorf("HandBrakeCLI did not find any titles in %s", inputPath)
+	}
+	return titles, nil
+}
+
+var (
+	scannedTitle    = regexp.MustCompile(`^\+ title (\d+):`)
+	scannedDuration = regexp.MustCompile(`^  \+ duration: (\d+):(\d\d):(\d\d)`)
+)
+
+// parseTitles reads the number and duration of each title from the output of a HandBrakeCLI
+// scan, from the "+ title 1:" line of each title, and the "  + duration: 01:45:02" line below it.
+func parseTitles(output []byte) []Title {
+	var titles []Title
+	lines := bufio.NewScanner(bytes.NewReader(output))
+	for lines.Scan() {
+		line := lines.Text()
+		if m := scannedTitle.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			titles = append(titles, Title{Number: n})
+			continue
+		}
+		if m := scannedDuration.FindStringSubmatch(line); m != nil && len(titles) > 0 {
+			h, _ := strconv.Atoi(m[1])
+			min, _ := strconv.Atoi(m[2])
+			s, _ := strconv.Atoi(m[3])
+			titles[len(titles)-1].Duration = time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + time.Duration(s)*time.Second
+		}
+	}
+	return titles
+}
+
+// selectTitle scans the titles of the source when the Title rule needs to, returning
+// the rule with the number of the selected title.
+func (e Encoder) selectTitle(ctx context.Context, inputPath string) (TitleRule, error) {
+	info, err := os.Stat(inputPath)
+	if err != nil || !e.Title.NeedsScan(info.IsDir()) {
+		return e.Title, nil
+	}
+
+	titles, err := ScanTitles(ctx, e.CLI, inputPath)
+	if err != nil {
+		return e.Title, err
+	}
+	n, err := e.Title.Select(titles)
+	if err != nil {
+		return e.Title, errors.Wrapf(err, "unable to select the title of %s", inputPath)
+	}
+	log.Printf("transcoding title %d of %s, selected by the title rule %s\n", n, inputPath, e.Title.String())
+	rule := e.Title
+	rule.Number = n
+	return rule, nil
+}
@@ -0,0 +1,107 @@
+package handbrake
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTitleRule_Set(t *testing.T) {
+	testcases := []struct {
+		Value   string
+		Want    TitleRule
+		WantErr bool
+	}{
+		{Value: "longest", Want: TitleRule{}},
+		{Value: "2", Want: TitleRule{Number: 2, value: "2"}},
+		{Value: "40m-3h", Want: TitleRule{Min: 40 * time.Minute, Max: 3 * time.Hour, value: "40m-3h"}},
+		{Value: "40m-", Want: TitleRule{Min: 40 * time.Minute, value: "40m-"}},
+		{Value: "0", WantErr: true},
+		{Value: "3h-40m", WantErr: true},
+		{Value: "-", WantErr: true},
+		{Value: "main", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Value, func(t *testing.T) {
+			var got TitleRule
+			err := got.Set(tc.Value)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatalf("expected the title rule to be invalid, got %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got != tc.Want {
+				t.Fatalf("expected %#v, got %#v", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestTitleRule_Select(t *testing.T) {
+	output := []byte(`[10:00:00] scan: DVD has 3 title(s)
++ title 1:
+  + vts 1, ttn 1, cells 0->7 (1234 blocks)
+  + duration: 00:02:10
++ title 2:
+  + duration: 01:45:02
++ title 3:
+  + duration: 03:30:00
+HandBrake has exited.
+`)
+	titles := parseTitles(output)
+	if len(titles) != 3 || titles[1] != (Title{Number: 2, Duration: time.Hour + 45*time.Minute + 2*time.Second}) {
+		t.Fatalf("expected the titles to be parsed from the scan, got %#v", titles)
+	}
+
+	testcases := []struct {
+		Rule    string
+		Want    int
+		WantErr bool
+	}{
+		{Rule: "longest", Want: 3},
+		{Rule: "40m-3h", Want: 2},
+		{Rule: "1", Want: 1},
+		{Rule: "4h-", WantErr: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Rule, func(t *testing.T) {
+			var rule TitleRule
+			err := rule.Set(tc.Rule)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			got, err := rule.Select(titles)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatalf("expected no title to match, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got != tc.Want {
+				t.Fatalf("expected title %d, got %d", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestTitleRule_NeedsScan(t *testing.T) {
+	var rule TitleRule
+	if rule.NeedsScan(false) || !rule.NeedsScan(true) {
+		t.Fatal("expected only the disc structures to be scanned for the longest title")
+	}
+	rule.Set("2")
+	if rule.NeedsScan(true) {
+		t.Fatal("expected a title number to not be scanned")
+	}
+	rule.Set("40m-3h")
+	if !rule.NeedsScan(false) {
+		t.Fatal("expected a duration range to be scanned")
+	}
+}
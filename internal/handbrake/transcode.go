@@ -0,0 +1,159 @@
+package handbrake
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Encoder runs HandBrakeCLI on the current host.
+type Encoder struct {
+	// CLI is the path to HandBrakeCLI.
+	CLI string
+
+	// Preset is the name of the HandBrake preset.
+	Preset string
+
+	// PresetFile is an optional file of custom presets, which must define the Preset.
+	PresetFile string
+
+	// StartAt and Duration optionally limit the transcode to a segment of the video,
+	// starting StartAt into it. A zero Duration transcodes until the end of the video.
+	StartAt, Duration time.Duration
+
+	// RawArgs optionally replace every other setting of the encoder, except DefaultArgs,
+	// and are used verbatim after the input and output.
+	RawArgs []string
+
+	// DefaultArgs are HandBrakeCLI arguments for every video, such as --all-audio or --markers.
+	// They come first, so the later arguments, such as the raw arguments, override them.
+	DefaultArgs []string
+
+	// ScanTimeout optionally stops HandBrakeCLI when it hasn't started encoding within this
+	// long, such as when its scan of a malformed video hangs.
+	ScanTimeout time.Duration
+
+	// Subtitles are external subtitle files that are added to the video as subtitle tracks,
+	// unless there are RawArgs. BurnSubtitles burns the first one into the video instead.
+	Subtitles     []string
+	BurnSubtitles bool
+
+	// Container is an optional output extension, such as .mp4, whose container is written
+	// instead of the container of the preset, unless there are RawArgs. See ContainerArgs.
+	Container string
+
+	// QueueSpec is an optional job exported from the HandBrake GUI, which is imported instead
+	// of the preset, container, segment and subtitles, unless there are RawArgs.
+	QueueSpec *QueueSpec
+
+	// Title selects the title of a source with several titles, unless there are RawArgs or a QueueSpec.
+	// The source is scanned before it is transcoded, when the rule needs to, see TitleRule.NeedsScan.
+	Title TitleRule
+
+	// SuccessExitCodes are the nonzero exit codes of HandBrakeCLI that are treated as a successful transcode.
+	SuccessExitCodes ExitCodes
+
+	// Progress is optionally called with the progress parsed from the HandBrakeCLI output.
+	Progress func(Progress)
+}
+
+// Args are the HandBrakeCLI arguments to transcode a video.
+func (e Encoder) Args(inputPath, outputPath string) []string {
+	args := append([]string(nil), e.DefaultArgs...)
+	if len(e.RawArgs) > 0 {
+		args = append(args, "-i", inputPath, "-o", outputPath)
+		return append(args, e.RawArgs...)
+	}
+	if e.QueueSpec != nil {
+		// The source and destination are set in the queue
+		return append(args, "--queue-import-file", QueueSpecPath(outputPath))
+	}
+
+	if e.PresetFile != "" {
+		args = append(args, "--preset-import-file", e.PresetFile)
+	}
+	args = append(args, "-i", inputPath, "-o", outputPath, "--preset", e.Preset)
+	args = append(args, e.Title.Args()...)
+	args = append(args, ContainerArgs(e.Container)...)
+	if e.StartAt > 0 {
+		args = append(args, "--start-at", fmt.Sprintf("seconds:%d", int64(e.StartAt/time.Second)))
+	}
+	if e.Duration > 0 {
+		// HandBrakeCLI stops relative to where it started
+		args = append(args, "--stop-at", fmt.Sprintf("seconds:%d", int64(e.Duration/time.Second)))
+	}
+	return append(args, SubtitleArgs(e.Subtitles, e.BurnSubtitles)...)
+}
+
+// Transcode a video, writing the HandBrakeCLI output to stdout and stderr.
+// The transcode is stopped when the context is cancelled, or when the scan
+// doesn't finish within the ScanTimeout.
+func (e Encoder) Transcode(ctx context.Context, inputPath, outputPath string) error {
+	err := os.MkdirAll(filepath.Dir(outputPath), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create the output directory for %s", outputPath)
+	}
+	if e.QueueSpec != nil && len(e.RawArgs) == 0 {
+		specPath := QueueSpecPath(outputPath)
+		err = e.QueueSpec.WriteFor(specPath, inputPath, outputPath)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(specPath)
+	}
+	if e.QueueSpec == nil && len(e.RawArgs) == 0 {
+		e.Title, err = e.selectTitle(ctx, inputPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	report := e.Progress
+	var scanTimer *time.Timer
+	scanTimedOut := make(chan struct{})
+	if e.ScanTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		scanTimer = time.AfterFunc(e.ScanTimeout, func() {
+			close(scanTimedOut)
+			cancel()
+		})
+		defer scanTimer.Stop()
+		// HandBrakeCLI only reports progress once it has finished scanning
+		report = func(p Progress) {
+			scanTimer.Stop()
+			if e.Progress != nil {
+				e.Progress(p)
+			}
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, e.CLI, e.Args(inputPath, outputPath)...)
+	cmd.Stdout = os.Stdout
+	if report != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, NewProgressWriter(report))
+	}
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	select {
+	case <-scanTimedOut:
+		return errors.Errorf("unable to transcode %s, HandBrakeCLI did not finish scanning it within %s", inputPath, e.ScanTimeout)
+	default:
+	}
+	if code, ok := exitCode(err); ok && e.SuccessExitCodes.Contains(code) {
+		log.Printf("HandBrakeCLI exited with %d while transcoding %s, treating it as success\n", code, inputPath)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to transcode %s", inputPath)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package handbrake
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncoder_Args(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Encoder Encoder
+		Want    []string
+	}{
+		{
+			Name:    "whole video",
+			Encoder: Encoder{Preset: "tivo"},
+			Want:    []string{"-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo"},
+		},
+		{
+			Name:    "sample",
+			Encoder: Encoder{Preset: "tivo", PresetFile: "presets.json", StartAt: 5 * time.Minute, Duration: 30 * time.Second},
+			Want: []string{"--preset-import-file", "presets.json", "-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo",
+				"--start-at", "seconds:300", "--stop-at", "seconds:30"},
+		},
+		{
+			Name:    "title",
+			Encoder: Encoder{Preset: "tivo", Title: TitleRule{Number: 2}},
+			Want:    []string{"-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo", "--title", "2"},
+		},
+		{
+			Name:    "subtitles",
+			Encoder: Encoder{Preset: "tivo", Subtitles: []string{"in.en.srt", "in.fr.ass", "in.srt"}, BurnSubtitles: true},
+			Want: []string{"-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo",
+				"--srt-file", "in.en.srt,in.srt", "--ssa-file", "in.fr.ass", "--srt-burn=1"},
+		},
+		{
+			Name:    "container",
+			Encoder: Encoder{Preset: "tivo", Container: ".mp4"},
+			Want:    []string{"-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo", "--format", "av_mp4", "--optimize"},
+		},
+		{
+			Name:    "queue spec",
+			Encoder: Encoder{Preset: "tivo", Container: ".mp4", QueueSpec: &QueueSpec{}, StartAt: time.Minute, Subtitles: []string{"in.srt"}},
+			Want:    []string{"--queue-import-file", ".out.mkv.queue.json"},
+		},
+		{
+			Name:    "raw args before queue spec",
+			Encoder: Encoder{QueueSpec: &QueueSpec{}, RawArgs: []string{"-e", "x264"}},
+			Want:    []string{"-i", "in.mkv", "-o", "out.mkv", "-e", "x264"},
+		},
+		{
+			Name:    "raw args",
+			Encoder: Encoder{Preset: "tivo", PresetFile: "presets.json", RawArgs: []string{"-e", "x264", "-q", "20"}},
+			Want:    []string{"-i", "in.mkv", "-o", "out.mkv", "-e", "x264", "-q", "20"},
+		},
+		{
+			Name:    "default args",
+			Encoder: Encoder{Preset: "tivo", DefaultArgs: []string{"--all-audio", "--markers"}},
+			Want:    []string{"--all-audio", "--markers", "-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo"},
+		},
+		{
+			Name:    "default args before raw args",
+			Encoder: Encoder{Preset: "tivo", DefaultArgs: []string{"--all-audio"}, RawArgs: []string{"-e", "x264"}},
+			Want:    []string{"--all-audio", "-i", "in.mkv", "-o", "out.mkv", "-e", "x264"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := tc.Encoder.Args("in.mkv", "out.mkv")
+			if !reflect.DeepEqual(got, tc.Want) {
+				t.Fatalf("expected %v, got %v", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestEncoder_ScanTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake HandBrakeCLI is a shell script")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testcases := []struct {
+		Name    string
+		Script  string
+		WantErr string
+	}{
+		{Name: "scan hangs", Script: "exec sleep 5", WantErr: "did not finish scanning"},
+		{Name: "encoding", Script: `echo "Encoding: task 1 of 1, 5.00 %"; sleep 1`},
+	}
+
+	for i, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cli := filepath.Join(tmpDir, fmt.Sprintf("HandBrakeCLI-%d", i))
+			err := ioutil.WriteFile(cli, []byte("#!/bin/sh\n"+tc.Script+"\n"), 0755)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+
+			e := Encoder{CLI: cli, Preset: "tivo", ScanTimeout: 500 * time.Millisecond}
+			start := time.Now()
+			err = e.Transcode(context.Background(), "in.mkv", filepath.Join(tmpDir, "out.mkv"))
+			if tc.WantErr == "" {
+				if err != nil {
+					t.Fatalf("expected the scan timeout to stop once encoding started, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.WantErr) {
+				t.Fatalf("expected the transcode to fail with %q, got %v", tc.WantErr, err)
+			}
+			if time.Since(start) > 4*time.Second {
+				t.Fatal("expected HandBrakeCLI to be stopped once the scan timed out")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Package httpclient sends the requests to the services that handbrk8s calls, such as Plex
+// and the notification webhooks, with a timeout and a small bounded retry.
+package httpclient
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MaxRetries is the most times that a request may be retried, so that a service that is
+// down doesn't hold up the video, or the alert, that is waiting on it for long.
+const MaxRetries = 10
+
+// Policy is how long a request may take, and how many times it is retried after it fails,
+// waiting the Backoff before the first retry and doubling it before each retry after that.
+type Policy struct {
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+}
+
+// DefaultPolicy gives up on a request after a few seconds, and retries it twice.
+var DefaultPolicy = Policy{Timeout: 5 * time.Second, Retries: 2, Backoff: 500 * time.Millisecond}
+
+// Validate checks that the policy has a positive timeout, at most MaxRetries retries,
+// and a positive backoff when it retries.
+func (p Policy) Validate() error {
+	if p.Timeout <= 0 {
+		return errors.Errorf("invalid request timeout %s, must be positive", p.Timeout)
+	}
+	if p.Retries < 0 || p.Retries > MaxRetries {
+		return errors.Errorf("invalid request retries %d, must be between 0 and %d", p.Retries, MaxRetries)
+	}
+	if p.Retries > 0 && p.Backoff <= 0 {
+		return errors.Errorf("invalid request backoff %s, must be positive", p.Backoff)
+	}
+	return nil
+}
+
+// Client sends requests with a Policy.
+type Client struct {
+	Policy
+	http *http.Client
+}
+
+// New creates a client that sends requests with the policy. The zero Policy is DefaultPolicy,
+// and a policy without a backoff uses the backoff of DefaultPolicy.
+func New(p Policy) *Client {
+	if p == (Policy{}) {
+		p = DefaultPolicy
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = DefaultPolicy.Backoff
+	}
+	return &Client{Policy: p, http: &http.Client{Timeout: p.Timeout}}
+}
+
+// Do sends the request, retrying an idempotent request, such as a GET, when it can't be sent,
+// times out, or the server returns 429 or a 5xx status. Other requests, such as a POST, are only
+// retried when the server returns 429 or 503, when it didn't handle the request, so that they
+// aren't repeated. The desc names the request in the logs, instead of its url, which may have
+// a secret in it. The response, or the error, of the last attempt is returned, and logged when
+// the request was retried.
+func (c *Client) Do(req *http.Request, desc string) (*http.Response, error) {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.http.Do(req)
+		if !c.shouldRetry(req, resp, err) {
+			if attempt > 0 && !succeeded(resp, err) {
+				log.Printf("giving up on %s after %d attempts: %s\n", desc, attempt+1, failure(resp, err))
+			}
+			return resp, err
+		}
+
+		if attempt == c.Retries {
+			log.Printf("giving up on %s after %d attempts: %s\n", desc, attempt+1, failure(resp, err))
+			return resp, err
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, err
+			}
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		log.Printf("retrying %s in %s: %s\n", desc, backoff, failure(resp, err))
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, errors.Wrapf(req.Context().Err(), "unable to retry %s", desc)
+		}
+		backoff *= 2
+	}
+}
+
+// shouldRetry determines if the failed attempt of the request is retried.
+func (c *Client) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	idempotent := req.Method == "" || req.Method == http.MethodGet || req.Method == http.MethodHead
+	if err != nil {
+		return idempotent
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusServiceUnavailable:
+		return true
+	case resp.StatusCode >= 500:
+		return idempotent
+	default:
+		return false
+	}
+}
+
+// succeeded determines if the attempt returned a 2xx status.
+func succeeded(resp *http.Response, err error) bool {
+	return err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// failure describes why the attempt failed, without its url.
+func failure(resp *http.Response, err error) string {
+	if err != nil {
+		// The url in the error may have a secret in it
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return err.Error()
+	}
+	return resp.Status
+}
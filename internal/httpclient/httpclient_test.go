@@ -0,0 +1,117 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Do(t *testing.T) {
+	testcases := []struct {
+		Name         string
+		Method       string
+		Statuses     []int
+		Retries      int
+		WantAttempts int
+		WantStatus   int
+	}{
+		{Name: "success", Method: http.MethodGet, Statuses: []int{200}, Retries: 2, WantAttempts: 1, WantStatus: 200},
+		{Name: "retry a server error", Method: http.MethodGet, Statuses: []int{500, 502, 200}, Retries: 2, WantAttempts: 3, WantStatus: 200},
+		{Name: "give up after the retries", Method: http.MethodGet, Statuses: []int{500, 500, 500, 200}, Retries: 2, WantAttempts: 3, WantStatus: 500},
+		{Name: "no retries", Method: http.MethodGet, Statuses: []int{500, 200}, Retries: 0, WantAttempts: 1, WantStatus: 500},
+		{Name: "client error", Method: http.MethodGet, Statuses: []int{404, 200}, Retries: 2, WantAttempts: 1, WantStatus: 404},
+		{Name: "post server error", Method: http.MethodPost, Statuses: []int{500, 200}, Retries: 2, WantAttempts: 1, WantStatus: 500},
+		{Name: "post unavailable", Method: http.MethodPost, Statuses: []int{503, 429, 200}, Retries: 2, WantAttempts: 3, WantStatus: 200},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var mu sync.Mutex
+			var attempts int
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				w.WriteHeader(tc.Statuses[attempts])
+				attempts++
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest(tc.Method, srv.URL, strings.NewReader("foo"))
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			c := New(Policy{Timeout: time.Second, Retries: tc.Retries, Backoff: time.Millisecond})
+			resp, err := c.Do(req, "the test request")
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			resp.Body.Close()
+
+			mu.Lock()
+			defer mu.Unlock()
+			if attempts != tc.WantAttempts || resp.StatusCode != tc.WantStatus {
+				t.Fatalf("expected %d attempts ending with %d, got %d attempts ending with %d", tc.WantAttempts, tc.WantStatus, attempts, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestClient_DoTimeout(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	c := New(Policy{Timeout: 50 * time.Millisecond, Retries: 1, Backoff: time.Millisecond})
+	_, err = c.Do(req, "the test request")
+	if err == nil {
+		t.Fatal("expected the request to time out")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Fatalf("expected the request to be retried once after it timed out, got %d attempts", attempts)
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Policy  Policy
+		WantErr bool
+	}{
+		{Name: "default", Policy: DefaultPolicy},
+		{Name: "no retries", Policy: Policy{Timeout: time.Second}},
+		{Name: "no timeout", Policy: Policy{Retries: 2, Backoff: time.Second}, WantErr: true},
+		{Name: "negative retries", Policy: Policy{Timeout: time.Second, Retries: -1}, WantErr: true},
+		{Name: "too many retries", Policy: Policy{Timeout: time.Second, Retries: MaxRetries + 1, Backoff: time.Second}, WantErr: true},
+		{Name: "no backoff", Policy: Policy{Timeout: time.Second, Retries: 2}, WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := tc.Policy.Validate()
+			if tc.WantErr != (err != nil) {
+				t.Fatalf("expected the error to be %t, got %v", tc.WantErr, err)
+			}
+		})
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"github.com/pkg/errors"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 // GetCurrentClusterClient gets a client for the current cluster upon which
 // we are currently executing upon. Only works when running in a cluster.
-func GetCurrentClusterClient() (*kubernetes.Clientset, error) {
+func GetCurrentClusterClient() (kubernetes.Interface, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, errors.Wrapf(err, "unable to retrieve the current cluster's configuration")
@@ -21,3 +22,23 @@ func GetCurrentClusterClient() (*kubernetes.Clientset, error) {
 
 	return clientset, nil
 }
+
+// GetClusterClient gets a client for a context in a kubeconfig file. When the
+// kubeconfig is empty, the default kubeconfig locations are used.
+func GetClusterClient(kubeconfig, kubeContext string) (kubernetes.Interface, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to load the %s context from the kubeconfig", kubeContext)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create a kubernetes client for the %s context", kubeContext)
+	}
+
+	return clientset, nil
+}
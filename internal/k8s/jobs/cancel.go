@@ -0,0 +1,126 @@
+package jobs
+
+import (
+	"log"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CancelAnnotation is set on a job when it is cancelled, before it is deleted, to what the
+// watcher does with its video: CancelRequeue or CancelDrop.
+const CancelAnnotation = "handbrk8s.io/cancel"
+
+// The values of the CancelAnnotation.
+const (
+	// CancelRequeue moves the claimed video back to the watch directory, to be processed again.
+	CancelRequeue = "requeue"
+
+	// CancelDrop moves the claimed video to the failed directory.
+	CancelDrop = "drop"
+)
+
+// ValidateCancel checks that the choice of what is done with the video of a cancelled job
+// is CancelRequeue or CancelDrop.
+func ValidateCancel(choice string) error {
+	if choice != CancelRequeue && choice != CancelDrop {
+		return errors.Errorf("invalid cancel choice %q, must be %s or %s", choice, CancelRequeue, CancelDrop)
+	}
+	return nil
+}
+
+// Cancel deletes a job and its pods, along with any jobs waiting for it to complete, such as
+// the upload job for a transcode job. The choice of what is done with their video is recorded
+// in the CancelAnnotation of each job before it is deleted, see WatchCancelled. Returns the
+// names of the jobs that were cancelled.
+func Cancel(clientset kubernetes.Interface, namespace, name, choice string) ([]string, error) {
+	err := ValidateCancel(choice)
+	if err != nil {
+		return nil, err
+	}
+	annotations := map[string]string{CancelAnnotation: choice}
+	jobclient := clientset.BatchV1().Jobs(namespace)
+
+	log.Printf("cancelling job: %s/%s, and choosing to %s its video\n", namespace, name, choice)
+	err = annotate(clientset, name, namespace, annotations)
+	if err != nil {
+		return nil, err
+	}
+	err = jobclient.Delete(name, DeleteOptions())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to delete %s/%s", namespace, name)
+	}
+	cancelled := []string{name}
+
+	selector := labels.SelectorFromSet(labels.Set{WaitsForLabel: name})
+	waiting, err := jobclient.List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return cancelled, errors.Wrapf(err, "unable to list the jobs waiting on %s/%s", namespace, name)
+	}
+	for _, j := range waiting.Items {
+		log.Printf("cancelling job waiting on %s: %s/%s\n", name, j.Namespace, j.Name)
+		err = annotate(clientset, j.Name, namespace, annotations)
+		if err == nil {
+			err = jobclient.Delete(j.Name, DeleteOptions())
+		}
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			continue
+		}
+		if err != nil {
+			return cancelled, errors.Wrapf(err, "unable to cancel %s/%s", namespace, j.Name)
+		}
+		cancelled = append(cancelled, j.Name)
+	}
+
+	return cancelled, nil
+}
+
+// WatchCancelled signals each job of a video, see SourceAnnotation, once it is deleted after
+// it was cancelled, on the current cluster. The jobs are watched again whenever the cluster
+// closes the watch, see watchJobs. Errors are signaled on the error channel until done is
+// closed.
+func WatchCancelled(done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error) {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		jobChan := make(chan *batchv1.Job)
+		close(jobChan)
+		return jobChan, failed(err)
+	}
+	return watchCancelled(clusterClient, done, namespace)
+}
+
+func (clusterClient) WatchCancelled(done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error) {
+	return WatchCancelled(done, namespace)
+}
+
+func (c clientsetClient) WatchCancelled(done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error) {
+	return watchCancelled(c.clientset, done, namespace)
+}
+
+func watchCancelled(clientset kubernetes.Interface, done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error) {
+	jobChan := make(chan *batchv1.Job)
+	errChan := make(chan error)
+
+	go func() {
+		defer close(jobChan)
+		defer close(errChan)
+
+		watchJobs(clientset, done, namespace, ManagedByLabel+"="+ManagedBy, "for cancellations", errChan, func(eventType watchapi.EventType, j *batchv1.Job) {
+			if eventType != watchapi.Deleted || j.Annotations[CancelAnnotation] == "" || j.Annotations[SourceAnnotation] == "" {
+				return
+			}
+			select {
+			case <-done:
+			case jobChan <- j:
+			}
+		})
+	}()
+
+	return jobChan, errChan
+}
@@ -0,0 +1,386 @@
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+func testJob(name string, labels map[string]string) *batchv1.Job {
+	return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "handbrk8s", Labels: labels}}
+}
+
+func TestClient_CreateOrReplace(t *testing.T) {
+	clientset := newFakeClientset()
+	c := NewClient(clientset)
+
+	name, err := c.CreateOrReplace(testJob("foo-transcode", map[string]string{"attempt": "1"}))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if name != "foo-transcode" {
+		t.Fatalf("expected the name of the created job, got %q", name)
+	}
+
+	_, err = c.CreateOrReplace(testJob("foo-transcode", map[string]string{"attempt": "2"}))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	j, err := c.Get("foo-transcode", "handbrk8s")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if j.Labels["attempt"] != "2" {
+		t.Fatalf("expected the existing job to be replaced, got %v", j.Labels)
+	}
+
+	err = c.Delete("foo-transcode", "handbrk8s")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if clientset.job("handbrk8s", "foo-transcode") != nil {
+		t.Fatal("expected the job to be deleted")
+	}
+	err = c.Delete("foo-transcode", "handbrk8s")
+	if err != nil {
+		t.Fatalf("expected deleting a missing job to succeed, got %+v", err)
+	}
+}
+
+func TestClient_ListAndAnnotate(t *testing.T) {
+	c := NewClient(newFakeClientset())
+
+	for _, j := range []*batchv1.Job{
+		testJob("foo-transcode", map[string]string{ManagedByLabel: ManagedBy}),
+		testJob("bar-transcode", nil),
+	} {
+		_, err := c.CreateOrReplace(j)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	managed, err := c.List("handbrk8s")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(managed) != 1 || managed[0].Name != "foo-transcode" {
+		t.Fatalf("expected only the managed job to be listed, got %#v", managed)
+	}
+
+	err = c.Annotate("foo-transcode", "handbrk8s", map[string]string{PostHookAnnotation: PostHookDone})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	j, _ := c.Get("foo-transcode", "handbrk8s")
+	if j.Annotations[PostHookAnnotation] != PostHookDone {
+		t.Fatalf("expected the job to be annotated, got %v", j.Annotations)
+	}
+}
+
+func TestWaitUntilComplete(t *testing.T) {
+	clientset := newFakeClientset()
+	NewClient(clientset).CreateOrReplace(testJob("foo-transcode", nil))
+
+	done := make(chan struct{})
+	defer close(done)
+	jobChan, errChan := waitUntilComplete(clientset, done, "handbrk8s", "foo-transcode")
+
+	clientset.waitForWatches(t, 1)
+	j := clientset.job("handbrk8s", "foo-transcode").DeepCopy()
+	j.Status.Succeeded = 1
+	clientset.update(j)
+
+	select {
+	case j := <-jobChan:
+		if j.Name != "foo-transcode" {
+			t.Fatalf("expected foo-transcode to complete, got %s", j.Name)
+		}
+	case err := <-errChan:
+		t.Fatalf("%+v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the job to complete")
+	}
+}
+
+func TestWaitUntilComplete_Missing(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	_, errChan := waitUntilComplete(newFakeClientset(), done, "handbrk8s", "foo-transcode")
+
+	if err := <-errChan; err == nil {
+		t.Fatal("expected waiting for a missing job to fail")
+	}
+}
+
+func TestRequeueDisrupted(t *testing.T) {
+	evicted := failedPod("Evicted")
+	evicted.Namespace = "handbrk8s"
+	evicted.Labels = map[string]string{"job-name": "foo-transcode"}
+	clientset := newFakeClientset(evicted)
+	NewClient(clientset).CreateOrReplace(testJob("foo-transcode", map[string]string{RequeueOnDisruptionLabel: "true"}))
+
+	done := make(chan struct{})
+	defer close(done)
+	errChan := requeueDisrupted(clientset, done, "handbrk8s", 3)
+
+	clientset.waitForWatches(t, 1)
+	j := clientset.job("handbrk8s", "foo-transcode").DeepCopy()
+	j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	clientset.update(j)
+
+	for i := 0; ; i++ {
+		if j := clientset.job("handbrk8s", "foo-transcode"); j != nil && j.Annotations[RequeuesAnnotation] == "1" {
+			break
+		}
+		select {
+		case err := <-errChan:
+			t.Fatalf("%+v", err)
+		default:
+		}
+		if i == 100 {
+			t.Fatal("expected the disrupted job to be requeued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequeueDisrupted_StaleEvents(t *testing.T) {
+	evicted := failedPod("Evicted")
+	evicted.Namespace = "handbrk8s"
+	evicted.Labels = map[string]string{"job-name": "foo-transcode"}
+	clientset := newFakeClientset(evicted)
+	NewClient(clientset).CreateOrReplace(testJob("foo-transcode", map[string]string{RequeueOnDisruptionLabel: "true"}))
+
+	done := make(chan struct{})
+	defer close(done)
+	errChan := requeueDisrupted(clientset, done, "handbrk8s", 3)
+
+	clientset.waitForWatches(t, 1)
+	disrupted := clientset.job("handbrk8s", "foo-transcode").DeepCopy()
+	disrupted.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	clientset.update(disrupted)
+
+	var replacement *batchv1.Job
+	for i := 0; ; i++ {
+		if j := clientset.job("handbrk8s", "foo-transcode"); j != nil && j.Annotations[RequeuesAnnotation] == "1" {
+			replacement = j
+			break
+		}
+		if i == 100 {
+			t.Fatal("expected the disrupted job to be requeued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The pods of the replaced job are still around when its late events are delivered
+	clientset.emit(watch.Deleted, disrupted)
+	clientset.emit(watch.Modified, disrupted)
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err := <-errChan:
+		t.Fatalf("%+v", err)
+	default:
+	}
+
+	j := clientset.job("handbrk8s", "foo-transcode")
+	if j == nil || j.UID != replacement.UID || j.Annotations[RequeuesAnnotation] != "1" {
+		t.Fatalf("expected the replacement job to be kept, got %#v", j)
+	}
+}
+
+func TestArchiveJobLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The logs of a job that was already archived are kept, without reading the logs of its pods
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "foo-transcode-a", Namespace: "handbrk8s", Labels: map[string]string{"job-name": "foo"}}}
+	clientset := newFakeClientset(pod)
+	podclient := clientset.CoreV1().Pods("handbrk8s")
+	archived := testJob("foo", nil)
+	logFile := LogFile(dir, archived.Namespace, archived.Name)
+	os.MkdirAll(filepath.Dir(logFile), 0755)
+	ioutil.WriteFile(logFile, []byte("attempt 1"), 0644)
+	err = archiveJobLogs(podclient, archived, dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	got, _ := ioutil.ReadFile(logFile)
+	if string(got) != "attempt 1" {
+		t.Fatalf("expected the archived logs to be kept, got %q", got)
+	}
+
+	// A job without any logs left to read isn't archived
+	removed := testJob("bar", nil)
+	err = archiveJobLogs(podclient, removed, dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := os.Stat(LogFile(dir, removed.Namespace, removed.Name)); !os.IsNotExist(err) {
+		t.Fatalf("expected an empty log file to not be archived, got %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	for _, choice := range []string{CancelRequeue, CancelDrop} {
+		t.Run(choice, func(t *testing.T) {
+			clientset := newFakeClientset()
+			jobclient := clientset.BatchV1().Jobs("handbrk8s")
+			managed := map[string]string{ManagedByLabel: ManagedBy}
+			jobclient.Create(testJob("foo-mkv-transcode", managed))
+			upload := testJob("foo-mkv-upload", map[string]string{ManagedByLabel: ManagedBy, WaitsForLabel: "foo-mkv-transcode"})
+			upload.Annotations = map[string]string{SourceAnnotation: "/watch/Movies/foo.mkv"}
+			jobclient.Create(upload)
+			jobclient.Create(testJob("bar-mkv-upload", map[string]string{ManagedByLabel: ManagedBy, WaitsForLabel: "bar-mkv-transcode"}))
+
+			done := make(chan struct{})
+			defer close(done)
+			jobChan, errChan := watchCancelled(clientset, done, "handbrk8s")
+			clientset.waitForWatches(t, 1)
+
+			cancelled, err := Cancel(clientset, "handbrk8s", "foo-mkv-transcode", choice)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if !reflect.DeepEqual(cancelled, []string{"foo-mkv-transcode", "foo-mkv-upload"}) {
+				t.Fatalf("expected the job and the job waiting on it to be cancelled, got %v", cancelled)
+			}
+			if clientset.job("handbrk8s", "foo-mkv-upload") != nil || clientset.job("handbrk8s", "bar-mkv-upload") == nil {
+				t.Fatal("expected only the jobs of the cancelled job to be deleted")
+			}
+
+			// Only the job of the video is signaled, with the choice of what is done with it
+			select {
+			case j := <-jobChan:
+				if j.Name != "foo-mkv-upload" || j.Annotations[CancelAnnotation] != choice {
+					t.Fatalf("expected the upload job to be signaled with the choice to %s its video, got %#v", choice, j)
+				}
+			case err := <-errChan:
+				t.Fatalf("%+v", err)
+			case <-time.After(time.Second):
+				t.Fatal("expected the cancelled upload job to be signaled")
+			}
+		})
+	}
+
+	_, err := Cancel(newFakeClientset(), "handbrk8s", "foo-mkv-transcode", "archive")
+	if err == nil {
+		t.Fatal("expected an invalid choice to be rejected")
+	}
+}
+
+func TestWatchCancelled_Rewatch(t *testing.T) {
+	defer func(backoff time.Duration) { minRewatchBackoff = backoff }(minRewatchBackoff)
+	minRewatchBackoff = 50 * time.Millisecond
+
+	clientset := newFakeClientset()
+	jobclient := clientset.BatchV1().Jobs("handbrk8s")
+	jobclient.Create(testJob("foo-mkv-transcode", map[string]string{ManagedByLabel: ManagedBy}))
+	upload := testJob("foo-mkv-upload", map[string]string{ManagedByLabel: ManagedBy, WaitsForLabel: "foo-mkv-transcode"})
+	upload.Annotations = map[string]string{SourceAnnotation: "/watch/Movies/foo.mkv"}
+	jobclient.Create(upload)
+
+	done := make(chan struct{})
+	defer close(done)
+	jobChan, errChan := watchCancelled(clientset, done, "handbrk8s")
+	clientset.waitForWatches(t, 1)
+
+	// The job is cancelled after the cluster closed the watch, before it is watched again
+	clientset.closeWatches()
+	_, err := Cancel(clientset, "handbrk8s", "foo-mkv-transcode", CancelRequeue)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	select {
+	case j := <-jobChan:
+		if j.Name != "foo-mkv-upload" || j.Annotations[CancelAnnotation] != CancelRequeue {
+			t.Fatalf("expected the upload job to be signaled once it is watched again, got %#v", j)
+		}
+	case err := <-errChan:
+		t.Fatalf("%+v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expected the job cancelled between the watches to be signaled")
+	}
+	clientset.waitForWatches(t, 1)
+}
+
+func TestWatchCancelled_Relist(t *testing.T) {
+	defer func(backoff time.Duration) { minRewatchBackoff = backoff }(minRewatchBackoff)
+	minRewatchBackoff = 50 * time.Millisecond
+
+	clientset := newFakeClientset()
+	jobclient := clientset.BatchV1().Jobs("handbrk8s")
+	upload := testJob("foo-mkv-upload", map[string]string{ManagedByLabel: ManagedBy})
+	upload.Annotations = map[string]string{SourceAnnotation: "/watch/Movies/foo.mkv", CancelAnnotation: CancelDrop}
+	jobclient.Create(upload)
+
+	done := make(chan struct{})
+	defer close(done)
+	jobChan, errChan := watchCancelled(clientset, done, "handbrk8s")
+	clientset.waitForWatches(t, 1)
+
+	// The job is deleted between the watches, and the cluster no longer has its deletion
+	clientset.closeWatches()
+	jobclient.Delete("foo-mkv-upload", DeleteOptions())
+	clientset.compact()
+
+	select {
+	case j := <-jobChan:
+		if j.Name != "foo-mkv-upload" || j.Annotations[CancelAnnotation] != CancelDrop {
+			t.Fatalf("expected the upload job to be signaled once the jobs are listed again, got %#v", j)
+		}
+	case err := <-errChan:
+		t.Fatalf("%+v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expected the job deleted between the watches to be signaled")
+	}
+}
+
+func TestRequeueDisrupted_Rewatch(t *testing.T) {
+	defer func(backoff time.Duration) { minRewatchBackoff = backoff }(minRewatchBackoff)
+	minRewatchBackoff = 50 * time.Millisecond
+
+	evicted := failedPod("Evicted")
+	evicted.Namespace = "handbrk8s"
+	evicted.Labels = map[string]string{"job-name": "foo-transcode"}
+	clientset := newFakeClientset(evicted)
+	NewClient(clientset).CreateOrReplace(testJob("foo-transcode", map[string]string{RequeueOnDisruptionLabel: "true"}))
+
+	done := make(chan struct{})
+	defer close(done)
+	errChan := requeueDisrupted(clientset, done, "handbrk8s", 3)
+
+	// The job is disrupted after the cluster closed the watch, before it is watched again
+	clientset.waitForWatches(t, 1)
+	clientset.closeWatches()
+	j := clientset.job("handbrk8s", "foo-transcode").DeepCopy()
+	j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	clientset.update(j)
+
+	for i := 0; ; i++ {
+		if j := clientset.job("handbrk8s", "foo-transcode"); j != nil && j.Annotations[RequeuesAnnotation] == "1" {
+			break
+		}
+		select {
+		case err := <-errChan:
+			t.Fatalf("%+v", err)
+		default:
+		}
+		if i == 100 {
+			t.Fatal("expected the job disrupted between the watches to be requeued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,296 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// fakeClientset keeps the jobs and pods of a cluster in memory. Only the jobs and pods
+// are implemented, every other group panics when it is used.
+type fakeClientset struct {
+	kubernetes.Interface
+
+	mu       sync.Mutex
+	jobs     map[string]*batchv1.Job
+	pods     []corev1.Pod
+	watchers []fakeWatch
+	created  int
+
+	// version is the resource version of the last change, and history is every change,
+	// so that a watch can resume from a resource version.
+	version   int
+	history   []watch.Event
+	compacted int
+}
+
+// fakeWatch is a watch of the jobs in a namespace, that match its options.
+type fakeWatch struct {
+	namespace string
+	opts      metav1.ListOptions
+	watcher   *watch.RaceFreeFakeWatcher
+}
+
+func newFakeClientset(pods ...corev1.Pod) *fakeClientset {
+	return &fakeClientset{jobs: make(map[string]*batchv1.Job), pods: pods}
+}
+
+func (c *fakeClientset) BatchV1() typedbatchv1.BatchV1Interface {
+	return fakeBatch{c: c}
+}
+
+func (c *fakeClientset) CoreV1() typedcorev1.CoreV1Interface {
+	return fakeCore{c: c}
+}
+
+// job returns the job, or nil when it doesn't exist.
+func (c *fakeClientset) job(namespace, name string) *batchv1.Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.jobs[namespace+"/"+name]
+}
+
+// update replaces the job, such as to change its status, and sends it to the watches.
+func (c *fakeClientset) update(j *batchv1.Job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jobs[j.Namespace+"/"+j.Name] = j
+	c.emitLocked(watch.Modified, j)
+}
+
+// emit sends the event of the job to the watches, such as an event delivered late.
+func (c *fakeClientset) emit(eventType watch.EventType, j *batchv1.Job) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.emitLocked(eventType, j)
+}
+
+// waitForWatches waits until there are n watches of the jobs.
+func (c *fakeClientset) waitForWatches(t *testing.T, n int) {
+	for i := 0; ; i++ {
+		c.mu.Lock()
+		watching := len(c.watchers)
+		c.mu.Unlock()
+		if watching >= n {
+			return
+		}
+		if i == 100 {
+			t.Fatalf("expected %d watches of the jobs, got %d", n, watching)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// compact forgets the changes so far, so that a watch can't resume from before them.
+func (c *fakeClientset) compact() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.compacted = c.version
+}
+
+// closeWatches closes every watch of the jobs, like the cluster does after their timeout.
+func (c *fakeClientset) closeWatches() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.watchers {
+		w.watcher.Stop()
+	}
+	c.watchers = nil
+}
+
+func (c *fakeClientset) emitLocked(eventType watch.EventType, j *batchv1.Job) {
+	c.version++
+	j.ResourceVersion = strconv.Itoa(c.version)
+	c.history = append(c.history, watch.Event{Type: eventType, Object: j.DeepCopy()})
+	for _, w := range c.watchers {
+		if w.namespace == j.Namespace && matchesListOptions(j, w.opts) {
+			w.watcher.Action(eventType, j.DeepCopy())
+		}
+	}
+}
+
+func matchesListOptions(j *batchv1.Job, opts metav1.ListOptions) bool {
+	if opts.LabelSelector != "" {
+		selector, err := labels.Parse(opts.LabelSelector)
+		if err != nil || !selector.Matches(labels.Set(j.Labels)) {
+			return false
+		}
+	}
+	if opts.FieldSelector != "" {
+		selector, err := fields.ParseSelector(opts.FieldSelector)
+		if err != nil || !selector.Matches(fields.Set{"metadata.name": j.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+type fakeBatch struct {
+	typedbatchv1.BatchV1Interface
+	c *fakeClientset
+}
+
+func (b fakeBatch) Jobs(namespace string) typedbatchv1.JobInterface {
+	return fakeJobs{c: b.c, namespace: namespace}
+}
+
+type fakeJobs struct {
+	typedbatchv1.JobInterface
+	c         *fakeClientset
+	namespace string
+}
+
+var jobsResource = schema.GroupResource{Group: "batch", Resource: "jobs"}
+
+func (f fakeJobs) Create(j *batchv1.Job) (*batchv1.Job, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	key := f.namespace + "/" + j.Name
+	if _, ok := f.c.jobs[key]; ok {
+		return nil, apierrors.NewAlreadyExists(jobsResource, j.Name)
+	}
+	j = j.DeepCopy()
+	j.Namespace = f.namespace
+	f.c.created++
+	j.UID = types.UID(fmt.Sprintf("%s-%d", key, f.c.created))
+	f.c.jobs[key] = j
+	f.c.emitLocked(watch.Added, j)
+	return j.DeepCopy(), nil
+}
+
+func (f fakeJobs) Delete(name string, options *metav1.DeleteOptions) error {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	key := f.namespace + "/" + name
+	j, ok := f.c.jobs[key]
+	if !ok {
+		return apierrors.NewNotFound(jobsResource, name)
+	}
+	delete(f.c.jobs, key)
+	f.c.emitLocked(watch.Deleted, j)
+	return nil
+}
+
+func (f fakeJobs) Get(name string, options metav1.GetOptions) (*batchv1.Job, error) {
+	j := f.c.job(f.namespace, name)
+	if j == nil {
+		return nil, apierrors.NewNotFound(jobsResource, name)
+	}
+	return j.DeepCopy(), nil
+}
+
+func (f fakeJobs) List(opts metav1.ListOptions) (*batchv1.JobList, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	list := &batchv1.JobList{ListMeta: metav1.ListMeta{ResourceVersion: strconv.Itoa(f.c.version)}}
+	for _, j := range f.c.jobs {
+		if j.Namespace == f.namespace && matchesListOptions(j, opts) {
+			list.Items = append(list.Items, *j.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+func (f fakeJobs) Watch(opts metav1.ListOptions) (watch.Interface, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	w := watch.NewRaceFreeFake()
+	if opts.ResourceVersion != "" {
+		// Replay the changes since the resource version
+		since, err := strconv.Atoi(opts.ResourceVersion)
+		if err != nil {
+			return nil, apierrors.NewBadRequest(err.Error())
+		}
+		if since < f.c.compacted {
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d", since))
+		}
+		for _, e := range f.c.history[since:] {
+			j := e.Object.(*batchv1.Job)
+			if j.Namespace == f.namespace && matchesListOptions(j, opts) {
+				w.Action(e.Type, j.DeepCopy())
+			}
+		}
+	}
+	f.c.watchers = append(f.c.watchers, fakeWatch{namespace: f.namespace, opts: opts, watcher: w})
+	return w, nil
+}
+
+// Patch only supports merging the annotations of the job.
+func (f fakeJobs) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (*batchv1.Job, error) {
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	j, ok := f.c.jobs[f.namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(jobsResource, name)
+	}
+	var patch struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &patch); err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	if j.Annotations == nil {
+		j.Annotations = make(map[string]string)
+	}
+	for key, value := range patch.Metadata.Annotations {
+		j.Annotations[key] = value
+	}
+	f.c.emitLocked(watch.Modified, j)
+	return j.DeepCopy(), nil
+}
+
+type fakeCore struct {
+	typedcorev1.CoreV1Interface
+	c *fakeClientset
+}
+
+func (c fakeCore) Pods(namespace string) typedcorev1.PodInterface {
+	return fakePods{c: c.c, namespace: namespace}
+}
+
+type fakePods struct {
+	typedcorev1.PodInterface
+	c         *fakeClientset
+	namespace string
+}
+
+func (f fakePods) List(opts metav1.ListOptions) (*corev1.PodList, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	f.c.mu.Lock()
+	defer f.c.mu.Unlock()
+
+	list := &corev1.PodList{}
+	for _, pod := range f.c.pods {
+		if pod.Namespace == f.namespace && selector.Matches(labels.Set(pod.Labels)) {
+			list.Items = append(list.Items, pod)
+		}
+	}
+	return list, nil
+}
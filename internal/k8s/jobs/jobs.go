@@ -4,13 +4,128 @@ import (
 	"log"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
 	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/carolynvs/handbrk8s/internal/notify"
 	"github.com/pkg/errors"
 	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 )
 
+// WaitsForLabel is set on a job that waits for another job to complete
+// before it runs, and is the name of the job that it waits for.
+const WaitsForLabel = "handbrk8s.io/waits-for"
+
+// Client creates, deletes and finds jobs on a cluster.
+type Client interface {
+	// CreateOrReplace creates the job, replacing it when it already exists.
+	CreateOrReplace(j *batchv1.Job) (jobName string, err error)
+
+	// Delete a job and its pods.
+	Delete(name, namespace string) error
+
+	// Get the current state of a job.
+	Get(name, namespace string) (*batchv1.Job, error)
+
+	// List the jobs in the namespace that have the ManagedByLabel.
+	List(namespace string) ([]batchv1.Job, error)
+
+	// Annotate merges the annotations into those of a job.
+	Annotate(name, namespace string, annotations map[string]string) error
+
+	// Progress returns the latest progress logged by the running pod of a transcode job, within
+	// since, returning false when it hasn't logged any progress.
+	Progress(name, namespace string, since time.Duration) (handbrake.Progress, bool, error)
+
+	// WatchCancelled signals each job of a video in the namespace once it is deleted after
+	// it was cancelled, until done is closed. See Cancel.
+	WatchCancelled(done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error)
+
+	// RequeueDisrupted recreates the jobs in the namespace that failed only because their
+	// pods were disrupted, until done is closed. See RequeueDisrupted.
+	RequeueDisrupted(done <-chan struct{}, namespace string, maxRequeues int) <-chan error
+
+	// ArchiveLogs writes the logs of the jobs in the namespace to the directory once they
+	// finish, until done is closed. See ArchiveLogs.
+	ArchiveLogs(done <-chan struct{}, namespace, dir string) <-chan error
+
+	// MonitorTranscodes alerts on the transcode jobs in the namespace that run long or
+	// stall, until done is closed. See MonitorTranscodes.
+	MonitorTranscodes(done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error
+}
+
+// NewClusterClient creates a client for jobs on the current cluster.
+func NewClusterClient() Client {
+	return clusterClient{}
+}
+
+type clusterClient struct{}
+
+func (clusterClient) CreateOrReplace(j *batchv1.Job) (string, error) {
+	return CreateOrReplace(j)
+}
+
+func (clusterClient) Delete(name, namespace string) error {
+	return Delete(name, namespace)
+}
+
+func (clusterClient) Get(name, namespace string) (*batchv1.Job, error) {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return getJob(clusterClient, name, namespace)
+}
+
+// NewContextClient creates a client for jobs on the cluster of a context in the kubeconfig.
+// When the kubeconfig is empty, the default kubeconfig locations are used.
+func NewContextClient(kubeconfig, kubeContext string) (Client, error) {
+	clientset, err := api.GetClusterClient(kubeconfig, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(clientset), nil
+}
+
+// NewClient creates a client for jobs on the cluster of the clientset, such as a fake
+// clientset in tests.
+func NewClient(clientset kubernetes.Interface) Client {
+	return clientsetClient{clientset: clientset}
+}
+
+type clientsetClient struct {
+	clientset kubernetes.Interface
+}
+
+func (c clientsetClient) CreateOrReplace(j *batchv1.Job) (string, error) {
+	return createOrReplace(c.clientset, j)
+}
+
+func (c clientsetClient) Delete(name, namespace string) error {
+	return deleteJob(c.clientset, name, namespace)
+}
+
+func (c clientsetClient) Get(name, namespace string) (*batchv1.Job, error) {
+	return getJob(c.clientset, name, namespace)
+}
+
+func (clusterClient) Progress(name, namespace string, since time.Duration) (handbrake.Progress, bool, error) {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return handbrake.Progress{}, false, err
+	}
+	return latestProgress(clusterClient, namespace, name, since)
+}
+
+func (c clientsetClient) Progress(name, namespace string, since time.Duration) (handbrake.Progress, bool, error) {
+	return latestProgress(c.clientset, namespace, name, since)
+}
+
 // SanitizeJobName replaces characters that aren't allowed in a k8s name with dashes.
 func SanitizeJobName(name string) string {
 
@@ -19,16 +134,49 @@ func SanitizeJobName(name string) string {
 	return re.ReplaceAllString(name, "-")
 }
 
-// Delete a job.
+// ValidateRetries checks how a job retries failed pods.
+//
+// The restart policy must be OnFailure, which restarts the failed container in the same pod,
+// or Never, which creates a new pod for each attempt so that the logs of failed attempts are kept.
+// With either policy, the job fails once the pods have failed backoffLimit times.
+func ValidateRetries(restartPolicy corev1.RestartPolicy, backoffLimit int32) error {
+	switch restartPolicy {
+	case corev1.RestartPolicyOnFailure, corev1.RestartPolicyNever:
+	case corev1.RestartPolicyAlways:
+		return errors.Errorf("invalid restart policy %s, jobs must use %s or %s",
+			restartPolicy, corev1.RestartPolicyOnFailure, corev1.RestartPolicyNever)
+	default:
+		return errors.Errorf("unknown restart policy %q, must be %s or %s",
+			restartPolicy, corev1.RestartPolicyOnFailure, corev1.RestartPolicyNever)
+	}
+
+	if backoffLimit < 0 {
+		return errors.Errorf("invalid backoff limit %d, must not be negative", backoffLimit)
+	}
+
+	return nil
+}
+
+// DeleteOptions removes a job's pods along with the job.
+func DeleteOptions() *metav1.DeleteOptions {
+	propagation := metav1.DeletePropagationBackground
+	return &metav1.DeleteOptions{PropagationPolicy: &propagation}
+}
+
+// Delete a job and its pods.
 func Delete(name, namespace string) error {
-	log.Printf("deleting job: %s/%s", namespace, name)
 	clusterClient, err := api.GetCurrentClusterClient()
 	if err != nil {
 		return err
 	}
-	jobclient := clusterClient.BatchV1().Jobs(namespace)
+	return deleteJob(clusterClient, name, namespace)
+}
 
-	err = jobclient.Delete(name, nil)
+func deleteJob(clientset kubernetes.Interface, name, namespace string) error {
+	log.Printf("deleting job: %s/%s", namespace, name)
+	jobclient := clientset.BatchV1().Jobs(namespace)
+
+	err := jobclient.Delete(name, DeleteOptions())
 	if !apierrors.IsNotFound(err) {
 		return errors.Wrapf(err, "unable to delete %s/%s", namespace, name)
 	}
@@ -36,6 +184,14 @@ func Delete(name, namespace string) error {
 	return nil
 }
 
+func getJob(clientset kubernetes.Interface, name, namespace string) (*batchv1.Job, error) {
+	j, err := clientset.BatchV1().Jobs(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get %s/%s", namespace, name)
+	}
+	return j, nil
+}
+
 // CreateFromTemplate creates a job on the current cluster from a template
 // and set of replacement values.
 func CreateFromTemplate(yamlTemplate string, values interface{}) (jobName string, err error) {
@@ -47,21 +203,26 @@ func CreateFromTemplate(yamlTemplate string, values interface{}) (jobName string
 	return CreateOrReplace(j)
 }
 
+// CreateOrReplace creates a job on the current cluster, replacing it when it already exists.
 func CreateOrReplace(j *batchv1.Job) (jobName string, err error) {
 	clusterClient, err := api.GetCurrentClusterClient()
 	if err != nil {
 		return "", err
 	}
-	jobclient := clusterClient.BatchV1().Jobs(j.Namespace)
+	return createOrReplace(clusterClient, j)
+}
+
+func createOrReplace(clientset kubernetes.Interface, j *batchv1.Job) (jobName string, err error) {
+	jobclient := clientset.BatchV1().Jobs(j.Namespace)
 
 	result, err := jobclient.Create(j)
 	if apierrors.IsAlreadyExists(err) {
-		delerr := Delete(j.Name, j.Namespace)
+		delerr := deleteJob(clientset, j.Name, j.Namespace)
 		if delerr != nil {
 			return "", errors.Wrapf(delerr, "unable to delete existing job %s so that it can be recreated", j.Name)
 		}
 
-		errChan := WaitUntilDeleted(nil, j.Namespace, j.Name)
+		errChan := waitUntilDeleted(clientset, nil, j.Namespace, j.Name)
 		select {
 		case delerr, waiting := <-errChan:
 			if waiting && delerr != nil {
@@ -69,7 +230,7 @@ func CreateOrReplace(j *batchv1.Job) (jobName string, err error) {
 			}
 		}
 
-		return CreateOrReplace(j)
+		return createOrReplace(clientset, j)
 	} else if err != nil {
 		yaml, _ := api.SerializeObject(j)
 		return "", errors.Wrapf(err, "unable to create job from:\n%s", yaml)
@@ -80,13 +241,18 @@ func CreateOrReplace(j *batchv1.Job) (jobName string, err error) {
 }
 
 // BuildFromTemplate builds a job definition from a template
-// and set of replacement values.
+// and set of replacement values. Returns a ValidationError when
+// the job is invalid, before it is sent to the cluster.
 func BuildFromTemplate(yamlTemplate string, values interface{}) (*batchv1.Job, error) {
 	yaml, err := api.ProcessTemplate(yamlTemplate, values)
 	if err != nil {
 		return nil, err
 	}
-	return Deserialize(yaml)
+	j, err := Deserialize(yaml)
+	if err != nil {
+		return nil, err
+	}
+	return j, Validate(j)
 }
 
 // Deserialize reads a job definition from yaml.
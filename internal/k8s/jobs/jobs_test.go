@@ -1,7 +1,13 @@
 package jobs
 
 import (
+	"reflect"
 	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func TestDeserializeJob(t *testing.T) {
@@ -28,3 +34,138 @@ spec:
 		t.Fatal("didn't deserialize into a job instance")
 	}
 }
+
+func TestValidateRetries(t *testing.T) {
+	testcases := []struct {
+		Name          string
+		RestartPolicy corev1.RestartPolicy
+		BackoffLimit  int32
+		WantErr       bool
+	}{
+		{Name: "on failure", RestartPolicy: corev1.RestartPolicyOnFailure, BackoffLimit: 20},
+		{Name: "never", RestartPolicy: corev1.RestartPolicyNever, BackoffLimit: 0},
+		{Name: "always", RestartPolicy: corev1.RestartPolicyAlways, BackoffLimit: 20, WantErr: true},
+		{Name: "unknown", RestartPolicy: "Sometimes", BackoffLimit: 20, WantErr: true},
+		{Name: "negative backoff", RestartPolicy: corev1.RestartPolicyNever, BackoffLimit: -1, WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := ValidateRetries(tc.RestartPolicy, tc.BackoffLimit)
+			if tc.WantErr && err == nil {
+				t.Fatal("expected the retry settings to be invalid")
+			}
+			if !tc.WantErr && err != nil {
+				t.Fatalf("%+v", err)
+			}
+		})
+	}
+}
+
+func failedPod(reason string) corev1.Pod {
+	return corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: reason}}
+}
+
+func TestShouldRequeue(t *testing.T) {
+	failedJob := func(requeues string) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "foo-transcode",
+				Labels:      map[string]string{RequeueOnDisruptionLabel: "true"},
+				Annotations: map[string]string{RequeuesAnnotation: requeues},
+			},
+			Status: batchv1.JobStatus{
+				Conditions: []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}},
+			},
+		}
+	}
+
+	testcases := []struct {
+		Name string
+		Job  *batchv1.Job
+		Pods []corev1.Pod
+		Want bool
+	}{
+		{Name: "evicted", Job: failedJob(""), Pods: []corev1.Pod{failedPod("Evicted")}, Want: true},
+		{Name: "preempted", Job: failedJob("1"), Pods: []corev1.Pod{failedPod("Preempting"), failedPod("Evicted")}, Want: true},
+		{Name: "disruption condition", Job: failedJob(""), Want: true, Pods: []corev1.Pod{{Status: corev1.PodStatus{
+			Phase:      corev1.PodFailed,
+			Conditions: []corev1.PodCondition{{Type: "DisruptionTarget", Status: corev1.ConditionTrue}},
+		}}}},
+		{Name: "encode failure", Job: failedJob(""), Pods: []corev1.Pod{failedPod("Evicted"), failedPod("")}, Want: false},
+		{Name: "no failed pods", Job: failedJob(""), Pods: []corev1.Pod{{Status: corev1.PodStatus{Phase: corev1.PodRunning}}}, Want: false},
+		{Name: "requeued too many times", Job: failedJob("3"), Pods: []corev1.Pod{failedPod("Evicted")}, Want: false},
+		{Name: "not failed", Job: &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{RequeueOnDisruptionLabel: "true"}}},
+			Pods: []corev1.Pod{failedPod("Evicted")}, Want: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := ShouldRequeue(tc.Job, tc.Pods, 3)
+			if got != tc.Want {
+				t.Fatalf("expected ShouldRequeue to be %t, got %t", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestRequeuedJob(t *testing.T) {
+	manual := true
+	j := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-transcode",
+			Namespace:       "handbrk8s",
+			ResourceVersion: "123",
+			Annotations:     map[string]string{RequeuesAnnotation: "1"},
+		},
+		Spec: batchv1.JobSpec{
+			Selector:       &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": "abc"}},
+			ManualSelector: &manual,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"controller-uid": "abc", "job-name": "foo-transcode", "app": "handbrake"}},
+			},
+		},
+		Status: batchv1.JobStatus{Failed: 3},
+	}
+
+	requeued := RequeuedJob(j)
+	if requeued.ResourceVersion != "" || requeued.Status.Failed != 0 {
+		t.Fatalf("expected the generated fields to be cleared, got %#v", requeued)
+	}
+	if requeued.Spec.Selector != nil || requeued.Spec.ManualSelector != nil {
+		t.Fatalf("expected the selector to be cleared, got %#v", requeued.Spec.Selector)
+	}
+	if len(requeued.Spec.Template.Labels) != 1 || requeued.Spec.Template.Labels["app"] != "handbrake" {
+		t.Fatalf("expected only the template's own labels, got %v", requeued.Spec.Template.Labels)
+	}
+	if requeued.Annotations[RequeuesAnnotation] != "2" {
+		t.Fatalf("expected the requeue count to be incremented, got %v", requeued.Annotations)
+	}
+	if j.Spec.Selector == nil || j.Annotations[RequeuesAnnotation] != "1" {
+		t.Fatal("expected the original job to be unchanged")
+	}
+}
+
+func TestPodLogRequests(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	pod := func(name string, created time.Duration, restarts int32) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(start.Add(created))},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "handbrake", RestartCount: restarts},
+			}},
+		}
+	}
+
+	// The pods are listed out of order, and the latest pod restarted its container
+	pods := []corev1.Pod{pod("foo-transcode-b", time.Hour, 2), pod("foo-transcode-a", 0, 0)}
+	got := podLogRequests(pods)
+	want := []podLogRequest{
+		{Pod: "foo-transcode-a"},
+		{Pod: "foo-transcode-b", Previous: true},
+		{Pod: "foo-transcode-b"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+}
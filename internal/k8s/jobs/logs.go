@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// LogFile is where the logs of a job are archived in the log directory.
+func LogFile(dir, namespace, name string) string {
+	return filepath.Join(dir, namespace, name+".log")
+}
+
+// IsFinished determines if the job has completed or permanently failed.
+func IsFinished(j *batchv1.Job) bool {
+	for _, c := range j.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// podLogRequest is an attempt of a job whose logs are archived. With the OnFailure
+// restart policy, the attempts are restarts of the container in the same pod, and
+// only the logs of the previous attempt are kept by the cluster.
+type podLogRequest struct {
+	Pod      string
+	Previous bool
+}
+
+// podLogRequests lists the attempts of a job that still have logs, oldest first.
+func podLogRequests(pods []corev1.Pod) []podLogRequest {
+	sorted := make([]corev1.Pod, len(pods))
+	copy(sorted, pods)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreationTimestamp.Before(&sorted[j].CreationTimestamp)
+	})
+
+	var requests []podLogRequest
+	for _, pod := range sorted {
+		restarted := false
+		for _, s := range pod.Status.ContainerStatuses {
+			if s.RestartCount > 0 {
+				restarted = true
+			}
+		}
+		if restarted {
+			requests = append(requests, podLogRequest{Pod: pod.Name, Previous: true})
+		}
+		requests = append(requests, podLogRequest{Pod: pod.Name})
+	}
+	return requests
+}
+
+// ArchiveLogs watches the jobs in the namespace, and once a job completes or fails, writes
+// the logs of every attempt to its LogFile in the directory, so that the HandBrakeCLI
+// output is kept after the pods are removed. Only the jobs with the ManagedByLabel are
+// archived, and a log file that was already archived, such as before the watcher restarted,
+// is kept because the pods of the earlier attempts may be gone. The jobs are watched again whenever
+// the cluster closes the watch, see watchJobs. Errors are signaled on the returned channel until done
+// is closed.
+func ArchiveLogs(done <-chan struct{}, namespace, dir string) <-chan error {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return failed(err)
+	}
+	return archiveLogs(clusterClient, done, namespace, dir)
+}
+
+func (clusterClient) ArchiveLogs(done <-chan struct{}, namespace, dir string) <-chan error {
+	return ArchiveLogs(done, namespace, dir)
+}
+
+func (c clientsetClient) ArchiveLogs(done <-chan struct{}, namespace, dir string) <-chan error {
+	return archiveLogs(c.clientset, done, namespace, dir)
+}
+
+func archiveLogs(clientset kubernetes.Interface, done <-chan struct{}, namespace, dir string) <-chan error {
+	errChan := make(chan error)
+
+	go func() {
+		defer close(errChan)
+
+		podclient := clientset.CoreV1().Pods(namespace)
+		archived := make(map[types.UID]bool)
+		watchJobs(clientset, done, namespace, ManagedByLabel+"="+ManagedBy, "to archive their logs", errChan, func(eventType watchapi.EventType, j *batchv1.Job) {
+			if !IsFinished(j) || archived[j.UID] {
+				return
+			}
+			archived[j.UID] = true
+
+			err := archiveJobLogs(podclient, j, dir)
+			if err != nil {
+				select {
+				case <-done:
+				case errChan <- err:
+				}
+			}
+		})
+	}()
+
+	return errChan
+}
+
+// archiveJobLogs concatenates the logs of each attempt of the job into its log file, unless
+// it was already archived. The log file is only written when there were logs to read, and
+// is renamed into place so that a partial file isn't left behind.
+func archiveJobLogs(podclient typedcorev1.PodInterface, j *batchv1.Job, dir string) error {
+	logFile := LogFile(dir, j.Namespace, j.Name)
+	if _, err := os.Stat(logFile); err == nil {
+		return nil
+	}
+
+	podSelector := labels.SelectorFromSet(labels.Set{"job-name": j.Name})
+	pods, err := podclient.List(metav1.ListOptions{LabelSelector: podSelector.String()})
+	if err != nil {
+		return errors.Wrapf(err, "unable to list the pods for %s/%s", j.Namespace, j.Name)
+	}
+
+	var buf bytes.Buffer
+	read := 0
+	for i, r := range podLogRequests(pods.Items) {
+		fmt.Fprintf(&buf, "==> attempt %d, pod %s <==\n", i+1, r.Pod)
+		logs, err := podclient.GetLogs(r.Pod, &corev1.PodLogOptions{Previous: r.Previous}).Do().Raw()
+		if err != nil {
+			fmt.Fprintf(&buf, "unable to read the logs: %s\n", err)
+			continue
+		}
+		buf.Write(logs)
+		read++
+	}
+	if read == 0 {
+		log.Printf("skipping archiving the logs of %s/%s, there are no logs left to read\n", j.Namespace, j.Name)
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(logFile), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create the log directory for %s", logFile)
+	}
+	tmpFile := logFile + ".tmp"
+	err = ioutil.WriteFile(tmpFile, buf.Bytes(), 0644)
+	if err != nil {
+		os.Remove(tmpFile)
+		return errors.Wrapf(err, "unable to archive the logs of %s/%s", j.Namespace, j.Name)
+	}
+	err = os.Rename(tmpFile, logFile)
+	if err != nil {
+		os.Remove(tmpFile)
+		return errors.Wrapf(err, "unable to archive the logs of %s/%s", j.Namespace, j.Name)
+	}
+	log.Printf("archived the logs of %s/%s to %s\n", j.Namespace, j.Name, logFile)
+	return nil
+}
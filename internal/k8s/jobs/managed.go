@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"encoding/json"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ManagedByLabel is set on every job created by the watcher, so that the jobs
+// left behind by a previous watcher can be found when it restarts.
+const ManagedByLabel = "app.kubernetes.io/managed-by"
+
+// ManagedBy is the value of the ManagedByLabel.
+const ManagedBy = "handbrk8s"
+
+// SourceAnnotation is the path of the video, in the watch directory, processed by a job.
+const SourceAnnotation = "handbrk8s.io/source"
+
+// OutputAnnotation is where an upload job uploads its video.
+const OutputAnnotation = "handbrk8s.io/output"
+
+// PostHookAnnotation records whether the post hook has run for the video of an upload job,
+// PostHookPending until it has, and then PostHookDone.
+const PostHookAnnotation = "handbrk8s.io/post-hook"
+
+// The values of the PostHookAnnotation.
+const (
+	PostHookPending = "pending"
+	PostHookDone    = "done"
+)
+
+func (clusterClient) List(namespace string) ([]batchv1.Job, error) {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	return listManaged(clusterClient, namespace)
+}
+
+func (clusterClient) Annotate(name, namespace string, annotations map[string]string) error {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return err
+	}
+	return annotate(clusterClient, name, namespace, annotations)
+}
+
+func (c clientsetClient) List(namespace string) ([]batchv1.Job, error) {
+	return listManaged(c.clientset, namespace)
+}
+
+func (c clientsetClient) Annotate(name, namespace string, annotations map[string]string) error {
+	return annotate(c.clientset, name, namespace, annotations)
+}
+
+func listManaged(clientset kubernetes.Interface, namespace string) ([]batchv1.Job, error) {
+	selector := ManagedByLabel + "=" + ManagedBy
+	list, err := clientset.BatchV1().Jobs(namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the jobs in %s", namespace)
+	}
+	return list.Items, nil
+}
+
+func annotate(clientset kubernetes.Interface, name, namespace string, annotations map[string]string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to annotate %s/%s", namespace, name)
+	}
+	_, err = clientset.BatchV1().Jobs(namespace).Patch(name, types.MergePatchType, patch)
+	return errors.Wrapf(err, "unable to annotate %s/%s", namespace, name)
+}
@@ -0,0 +1,202 @@
+package jobs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/carolynvs/handbrk8s/internal/notify"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// monitorInterval is how often the running transcode jobs are checked.
+const monitorInterval = time.Minute
+
+// deadlineAlertRatio is how far into a job's activeDeadlineSeconds it is considered
+// long-running, when an expected duration isn't set, so that the alert is sent before
+// the cluster stops the job.
+const deadlineAlertRatio = 0.8
+
+// MonitorTranscodes checks the running transcode jobs in the namespace, and sends an alert
+// when a job has run longer than the expected duration, or its progress in the HandBrakeCLI
+// output hasn't advanced for the stall timeout. A job without an expected duration is
+// compared against its activeDeadlineSeconds instead. Errors are signaled on the returned
+// channel until done is closed.
+func MonitorTranscodes(done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return failed(err)
+	}
+	return monitorTranscodes(clusterClient, done, namespace, expectedDuration, stallTimeout, notifier)
+}
+
+func (clusterClient) MonitorTranscodes(done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error {
+	return MonitorTranscodes(done, namespace, expectedDuration, stallTimeout, notifier)
+}
+
+func (c clientsetClient) MonitorTranscodes(done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error {
+	return monitorTranscodes(c.clientset, done, namespace, expectedDuration, stallTimeout, notifier)
+}
+
+func monitorTranscodes(clientset kubernetes.Interface, done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error {
+	errChan := make(chan error)
+
+	go func() {
+		defer close(errChan)
+
+		m := transcodeMonitor{
+			clientset:        clientset,
+			namespace:        namespace,
+			expectedDuration: expectedDuration,
+			stallTimeout:     stallTimeout,
+			notifier:         notifier,
+			watchdogs:        make(map[types.UID]*handbrake.Watchdog),
+		}
+
+		ticker := time.NewTicker(monitorInterval)
+		defer ticker.Stop()
+		for {
+			for _, err := range m.check(time.Now()) {
+				select {
+				case <-done:
+					return
+				case errChan <- err:
+				}
+			}
+
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return errChan
+}
+
+// transcodeMonitor keeps a watchdog for each running transcode job.
+type transcodeMonitor struct {
+	clientset        kubernetes.Interface
+	namespace        string
+	expectedDuration time.Duration
+	stallTimeout     time.Duration
+	notifier         notify.Notifier
+	watchdogs        map[types.UID]*handbrake.Watchdog
+}
+
+// check reports the latest progress of each running transcode job, and sends any alerts.
+func (m *transcodeMonitor) check(now time.Time) []error {
+	jobList, err := m.clientset.BatchV1().Jobs(m.namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return []error{errors.Wrapf(err, "unable to list %s:jobs to monitor", m.namespace)}
+	}
+
+	var errs []error
+	running := make(map[types.UID]bool)
+	for i := range jobList.Items {
+		j := &jobList.Items[i]
+		if !strings.HasSuffix(j.Name, "-transcode") || !isRunning(j) {
+			continue
+		}
+		running[j.UID] = true
+
+		dog, ok := m.watchdogs[j.UID]
+		if !ok {
+			dog = handbrake.NewWatchdog(j.Status.StartTime.Time, m.expectedDurationOf(j), m.stallTimeout)
+			m.watchdogs[j.UID] = dog
+		}
+
+		if m.stallTimeout > 0 {
+			p, ok, err := m.latestProgress(j)
+			if err != nil {
+				errs = append(errs, err)
+			} else if ok {
+				dog.Report(p, now)
+			}
+		}
+
+		alert, ok := dog.Check(now)
+		if !ok {
+			continue
+		}
+		err := m.notifier.Notify(fmt.Sprintf("%s/%s %s", m.namespace, j.Name, alert))
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "unable to alert that %s/%s is taking too long", m.namespace, j.Name))
+		}
+	}
+
+	// Forget the jobs that have finished
+	for uid := range m.watchdogs {
+		if !running[uid] {
+			delete(m.watchdogs, uid)
+		}
+	}
+
+	return errs
+}
+
+// expectedDurationOf is how long the job should take, falling back to its activeDeadlineSeconds.
+func (m *transcodeMonitor) expectedDurationOf(j *batchv1.Job) time.Duration {
+	if m.expectedDuration > 0 || j.Spec.ActiveDeadlineSeconds == nil {
+		return m.expectedDuration
+	}
+	deadline := time.Duration(*j.Spec.ActiveDeadlineSeconds) * time.Second
+	return time.Duration(float64(deadline) * deadlineAlertRatio)
+}
+
+// latestProgress parses the most recent progress from the logs of the job's running pod,
+// returning false when the pod hasn't logged any progress since the last check.
+func (m *transcodeMonitor) latestProgress(j *batchv1.Job) (handbrake.Progress, bool, error) {
+	// HandBrakeCLI writes its progress many times a second
+	return latestProgress(m.clientset, m.namespace, j.Name, monitorInterval+monitorInterval/2)
+}
+
+// latestProgress parses the most recent progress from the logs of the running pod of the job,
+// only reading what was logged within since, returning false when there isn't any progress.
+func latestProgress(clientset kubernetes.Interface, namespace, name string, since time.Duration) (handbrake.Progress, bool, error) {
+	podclient := clientset.CoreV1().Pods(namespace)
+	podSelector := labels.SelectorFromSet(labels.Set{"job-name": name})
+	pods, err := podclient.List(metav1.ListOptions{LabelSelector: podSelector.String()})
+	if err != nil {
+		return handbrake.Progress{}, false, errors.Wrapf(err, "unable to list the pods for %s/%s", namespace, name)
+	}
+
+	sinceSeconds := int64(since / time.Second)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		logs, err := podclient.GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container:    "handbrake",
+			SinceSeconds: &sinceSeconds,
+		}).Do().Raw()
+		if err != nil {
+			return handbrake.Progress{}, false, errors.Wrapf(err, "unable to read the logs of %s/%s", namespace, pod.Name)
+		}
+		p, ok := handbrake.ParseProgress(logs)
+		return p, ok, nil
+	}
+	return handbrake.Progress{}, false, nil
+}
+
+// isRunning determines if the job has started, and hasn't completed or failed.
+func isRunning(j *batchv1.Job) bool {
+	if j.Status.StartTime == nil || j.Status.CompletionTime != nil || IsFailed(j) {
+		return false
+	}
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobComplete && c.Status == corev1.ConditionTrue {
+			return false
+		}
+	}
+	return true
+}
@@ -6,12 +6,26 @@ import (
 	"github.com/carolynvs/handbrk8s/internal/k8s/api"
 	"github.com/pkg/errors"
 	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
 )
 
+// WaitUntilComplete signals the job on the returned channel once it has succeeded, on the
+// current cluster. Errors are signaled on the error channel until done is closed.
 func WaitUntilComplete(done <-chan struct{}, namespace, name string) (<-chan *batchv1.Job, <-chan error) {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		jobChan := make(chan *batchv1.Job)
+		close(jobChan)
+		return jobChan, failed(err)
+	}
+	return waitUntilComplete(clusterClient, done, namespace, name)
+}
+
+func waitUntilComplete(clientset kubernetes.Interface, done <-chan struct{}, namespace, name string) (<-chan *batchv1.Job, <-chan error) {
 	jobChan := make(chan *batchv1.Job)
 	errChan := make(chan error)
 
@@ -19,14 +33,15 @@ func WaitUntilComplete(done <-chan struct{}, namespace, name string) (<-chan *ba
 		defer close(jobChan)
 		defer close(errChan)
 
-		clusterClient, err := api.GetCurrentClusterClient()
+		jobclient := clientset.BatchV1().Jobs(namespace)
+
+		// The watch won't return any events for a job that doesn't exist
+		_, err := jobclient.Get(name, metav1.GetOptions{})
 		if err != nil {
-			errChan <- err
+			errChan <- errors.Wrapf(err, "unable to get %s/%s", namespace, name)
 			return
 		}
 
-		jobclient := clusterClient.BatchV1().Jobs(namespace)
-
 		opts := metav1.ListOptions{
 			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
 		}
@@ -42,7 +57,15 @@ func WaitUntilComplete(done <-chan struct{}, namespace, name string) (<-chan *ba
 			select {
 			case <-done:
 				return
-			case e := <-events:
+			case e, ok := <-events:
+				if !ok {
+					errChan <- errors.Errorf("stopped watching %s/%s before it completed", namespace, name)
+					return
+				}
+				if e.Type == watchapi.Deleted {
+					errChan <- errors.Errorf("%s/%s was deleted before it completed", namespace, name)
+					return
+				}
 				job, ok := e.Object.(*batchv1.Job)
 				if !ok {
 					errChan <- errors.Errorf("watch returned a non-job:\n%#v", e.Object)
@@ -60,19 +83,23 @@ func WaitUntilComplete(done <-chan struct{}, namespace, name string) (<-chan *ba
 	return jobChan, errChan
 }
 
+// WaitUntilDeleted closes the returned channel once the job is deleted from the current
+// cluster, or done is closed. Errors are signaled on the channel.
 func WaitUntilDeleted(done <-chan struct{}, namespace, name string) <-chan error {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return failed(err)
+	}
+	return waitUntilDeleted(clusterClient, done, namespace, name)
+}
+
+func waitUntilDeleted(clientset kubernetes.Interface, done <-chan struct{}, namespace, name string) <-chan error {
 	errChan := make(chan error)
 
 	go func() {
 		defer close(errChan)
 
-		clusterClient, err := api.GetCurrentClusterClient()
-		if err != nil {
-			errChan <- err
-			return
-		}
-
-		jobclient := clusterClient.BatchV1().Jobs(namespace)
+		jobclient := clientset.BatchV1().Jobs(namespace)
 
 		opts := metav1.ListOptions{
 			FieldSelector: fields.OneTermEqualSelector("metadata.name", name).String(),
@@ -85,6 +112,12 @@ func WaitUntilDeleted(done <-chan struct{}, namespace, name string) <-chan error
 		defer watch.Stop()
 		events := watch.ResultChan()
 
+		// The watch won't return any events for a job that was deleted before it started
+		_, err = jobclient.Get(name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return
+		}
+
 		for {
 			select {
 			case <-done:
@@ -99,3 +132,11 @@ func WaitUntilDeleted(done <-chan struct{}, namespace, name string) <-chan error
 
 	return errChan
 }
+
+// failed returns a closed channel with the error, for a watch that couldn't be started.
+func failed(err error) <-chan error {
+	errChan := make(chan error, 1)
+	errChan <- err
+	close(errChan)
+	return errChan
+}
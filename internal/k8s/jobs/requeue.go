@@ -0,0 +1,184 @@
+package jobs
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/api"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RequeueOnDisruptionLabel is set on a job that should be recreated when it fails
+// only because its pods were disrupted, e.g. evicted or preempted.
+const RequeueOnDisruptionLabel = "handbrk8s.io/requeue-on-disruption"
+
+// RequeuesAnnotation counts how many times a job was recreated after it was disrupted.
+const RequeuesAnnotation = "handbrk8s.io/requeues"
+
+// disruptionReasons are the pod status reasons set when a pod is stopped by the
+// cluster, instead of failing on its own.
+var disruptionReasons = map[string]bool{
+	"Evicted":                true,
+	"Preempting":             true,
+	"DeletionByTaintManager": true,
+	"Shutdown":               true,
+	"Terminated":             true,
+	"NodeLost":               true,
+}
+
+// IsDisrupted determines if the pod was stopped by the cluster, for example
+// evicted to reclaim resources or preempted on a spot node.
+func IsDisrupted(pod corev1.Pod) bool {
+	if disruptionReasons[pod.Status.Reason] {
+		return true
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == "DisruptionTarget" && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFailed determines if the job has permanently failed.
+func IsFailed(j *batchv1.Job) bool {
+	for _, c := range j.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldRequeue determines if a failed job should be recreated: every one of its
+// failed pods was disrupted, and it hasn't already been requeued maxRequeues times.
+// A genuine failure, such as a video that HandBrake can't transcode, is never requeued.
+func ShouldRequeue(j *batchv1.Job, pods []corev1.Pod, maxRequeues int) bool {
+	if !IsFailed(j) || j.Labels[RequeueOnDisruptionLabel] != "true" || requeues(j) >= maxRequeues {
+		return false
+	}
+
+	disrupted := false
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodFailed {
+			continue
+		}
+		if !IsDisrupted(pod) {
+			return false
+		}
+		disrupted = true
+	}
+	return disrupted
+}
+
+func requeues(j *batchv1.Job) int {
+	n, _ := strconv.Atoi(j.Annotations[RequeuesAnnotation])
+	return n
+}
+
+// RequeuedJob copies the definition of a job, without the fields generated by the
+// cluster, so that it can be created again. The requeue count is incremented.
+func RequeuedJob(j *batchv1.Job) *batchv1.Job {
+	requeued := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        j.Name,
+			Namespace:   j.Namespace,
+			Labels:      j.Labels,
+			Annotations: make(map[string]string),
+		},
+		Spec: *j.Spec.DeepCopy(),
+	}
+	for k, v := range j.Annotations {
+		requeued.Annotations[k] = v
+	}
+	requeued.Annotations[RequeuesAnnotation] = strconv.Itoa(requeues(j) + 1)
+
+	// Let the cluster generate a new selector for the new job
+	requeued.Spec.Selector = nil
+	requeued.Spec.ManualSelector = nil
+	podLabels := make(map[string]string)
+	for k, v := range j.Spec.Template.Labels {
+		if k != "controller-uid" && k != "job-name" {
+			podLabels[k] = v
+		}
+	}
+	requeued.Spec.Template.Labels = podLabels
+
+	return requeued
+}
+
+// RequeueDisrupted watches the jobs in the namespace with RequeueOnDisruptionLabel,
+// and recreates the jobs that failed only because their pods were disrupted. The jobs
+// are watched again whenever the cluster closes the watch, see watchJobs. Errors are
+// signaled on the returned channel until done is closed.
+func RequeueDisrupted(done <-chan struct{}, namespace string, maxRequeues int) <-chan error {
+	clusterClient, err := api.GetCurrentClusterClient()
+	if err != nil {
+		return failed(err)
+	}
+	return requeueDisrupted(clusterClient, done, namespace, maxRequeues)
+}
+
+func (clusterClient) RequeueDisrupted(done <-chan struct{}, namespace string, maxRequeues int) <-chan error {
+	return RequeueDisrupted(done, namespace, maxRequeues)
+}
+
+func (c clientsetClient) RequeueDisrupted(done <-chan struct{}, namespace string, maxRequeues int) <-chan error {
+	return requeueDisrupted(c.clientset, done, namespace, maxRequeues)
+}
+
+func requeueDisrupted(clientset kubernetes.Interface, done <-chan struct{}, namespace string, maxRequeues int) <-chan error {
+	errChan := make(chan error)
+
+	go func() {
+		defer close(errChan)
+
+		podclient := clientset.CoreV1().Pods(namespace)
+		signal := func(err error) {
+			select {
+			case <-done:
+			case errChan <- err:
+			}
+		}
+
+		// A stale event of a job that was already requeued, delivered after it was replaced,
+		// would otherwise requeue it again, deleting its replacement. It is kept across the
+		// watches, since a watch resumes with the events that were not handled yet.
+		requeued := make(map[types.UID]bool)
+
+		selector := labels.SelectorFromSet(labels.Set{RequeueOnDisruptionLabel: "true"})
+		watchJobs(clientset, done, namespace, selector.String(), "for disruptions", errChan, func(eventType watchapi.EventType, j *batchv1.Job) {
+			// A job that is being deleted, such as when it is requeued, is gone for good
+			if !IsFailed(j) || eventType == watchapi.Deleted || j.DeletionTimestamp != nil || requeued[j.UID] {
+				return
+			}
+
+			podSelector := labels.SelectorFromSet(labels.Set{"job-name": j.Name})
+			pods, err := podclient.List(metav1.ListOptions{LabelSelector: podSelector.String()})
+			if err != nil {
+				signal(errors.Wrapf(err, "unable to list the pods for %s/%s", namespace, j.Name))
+				return
+			}
+			if !ShouldRequeue(j, pods.Items, maxRequeues) {
+				return
+			}
+
+			log.Printf("requeuing %s/%s, its pods were disrupted (attempt %d of %d)", namespace, j.Name, requeues(j)+1, maxRequeues)
+			_, err = createOrReplace(clientset, RequeuedJob(j))
+			if err != nil {
+				signal(errors.Wrapf(err, "unable to requeue %s/%s", namespace, j.Name))
+				return
+			}
+			requeued[j.UID] = true
+		})
+	}()
+
+	return errChan
+}
@@ -0,0 +1,151 @@
+package jobs
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	watchapi "k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	typedbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
+)
+
+// minRewatchBackoff and maxRewatchBackoff bound how long to wait before the jobs are watched
+// again, once the cluster closed the watch, such as after its timeout, or watching failed.
+var (
+	minRewatchBackoff = time.Second
+	maxRewatchBackoff = time.Minute
+)
+
+// watchJobs calls handle with each change to the jobs in the namespace that match the label
+// selector, until done is closed. The cluster closes a watch after its timeout, so the jobs are
+// watched again, waiting twice as long each time, and resuming from the last change that was
+// handled so that the changes in between are replayed. When the cluster no longer has those
+// changes, the jobs are listed again and handled as added, and the jobs that are gone are
+// handled as deleted, with their last known state. Errors are signaled on the error channel,
+// with the reason the jobs are watched, such as "for disruptions".
+func watchJobs(clientset kubernetes.Interface, done <-chan struct{}, namespace, selector, reason string,
+	errChan chan<- error, handle func(watchapi.EventType, *batchv1.Job)) {
+	jobclient := clientset.BatchV1().Jobs(namespace)
+	w := jobWatch{jobclient: jobclient, done: done, selector: selector, known: make(map[types.UID]*batchv1.Job), handle: handle}
+
+	backoff := minRewatchBackoff
+	for {
+		started := time.Now()
+		err := w.relist()
+		if err == nil {
+			err = w.follow()
+		}
+		if err != nil {
+			// Start over from the current jobs, the changes since the last one may be gone
+			w.resourceVersion = ""
+			if !apierrors.IsResourceExpired(err) && !apierrors.IsGone(err) {
+				select {
+				case <-done:
+					return
+				case errChan <- errors.Wrapf(err, "unable to watch %s:jobs %s", namespace, reason):
+				}
+			}
+		}
+
+		if time.Since(started) > maxRewatchBackoff {
+			backoff = minRewatchBackoff
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = nextRewatchBackoff(backoff)
+	}
+}
+
+// nextRewatchBackoff doubles how long to wait before the jobs are watched again, up to maxRewatchBackoff.
+func nextRewatchBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxRewatchBackoff {
+		backoff = maxRewatchBackoff
+	}
+	return backoff
+}
+
+// jobWatch is the state of watchJobs that is kept between the watches.
+type jobWatch struct {
+	jobclient typedbatchv1.JobInterface
+	done      <-chan struct{}
+	selector  string
+	handle    func(watchapi.EventType, *batchv1.Job)
+
+	// resourceVersion is the last change that was handled, empty when the jobs must be listed.
+	resourceVersion string
+
+	// known is the last state of each job that wasn't deleted.
+	known map[types.UID]*batchv1.Job
+}
+
+// relist lists the jobs, when the changes can't be resumed from the last one that was handled.
+func (w *jobWatch) relist() error {
+	if w.resourceVersion != "" {
+		return nil
+	}
+	list, err := w.jobclient.List(metav1.ListOptions{LabelSelector: w.selector})
+	if err != nil {
+		return err
+	}
+
+	listed := make(map[types.UID]bool, len(list.Items))
+	for i := range list.Items {
+		j := &list.Items[i]
+		listed[j.UID] = true
+		w.known[j.UID] = j
+		w.handle(watchapi.Added, j)
+	}
+	for uid, j := range w.known {
+		if !listed[uid] {
+			delete(w.known, uid)
+			w.handle(watchapi.Deleted, j)
+		}
+	}
+	w.resourceVersion = list.ResourceVersion
+	return nil
+}
+
+// follow handles the changes to the jobs until the watch is closed, or done is closed.
+func (w *jobWatch) follow() error {
+	watch, err := w.jobclient.Watch(metav1.ListOptions{LabelSelector: w.selector, ResourceVersion: w.resourceVersion})
+	if err != nil {
+		return err
+	}
+	defer watch.Stop()
+	events := watch.ResultChan()
+
+	for {
+		select {
+		case <-w.done:
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if e.Type == watchapi.Error {
+				return apierrors.FromObject(e.Object)
+			}
+			j, ok := e.Object.(*batchv1.Job)
+			if !ok {
+				continue
+			}
+			if e.Type == watchapi.Deleted {
+				delete(w.known, j.UID)
+			} else {
+				w.known[j.UID] = j
+			}
+			if j.ResourceVersion != "" {
+				w.resourceVersion = j.ResourceVersion
+			}
+			w.handle(e.Type, j)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package jobs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// ValidationError lists every problem with a job definition, such as an invalid
+// name or a mount of a missing volume, found before the job is sent to the cluster.
+// Each error names the offending field and its value.
+type ValidationError struct {
+	// Job is the name of the invalid job.
+	Job string
+
+	// Errors are the problems with the job, in the order that they were found.
+	Errors field.ErrorList
+}
+
+func (e ValidationError) Error() string {
+	problems := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		problems[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid job %q: %s", e.Job, strings.Join(problems, "; "))
+}
+
+// Validate checks the job definition for the mistakes that would otherwise only be
+// reported by the cluster when the job is created, returning a ValidationError with
+// all of them. The checks are a subset of the validation done by the cluster.
+func Validate(j *batchv1.Job) error {
+	var errs field.ErrorList
+	errs = append(errs, validateMetadata(j.Name, j.Namespace, j.Labels, field.NewPath("metadata"))...)
+
+	specPath := field.NewPath("spec")
+	if j.Spec.BackoffLimit != nil && *j.Spec.BackoffLimit < 0 {
+		errs = append(errs, field.Invalid(specPath.Child("backoffLimit"), *j.Spec.BackoffLimit, "must not be negative"))
+	}
+	if j.Spec.ActiveDeadlineSeconds != nil && *j.Spec.ActiveDeadlineSeconds <= 0 {
+		errs = append(errs, field.Invalid(specPath.Child("activeDeadlineSeconds"), *j.Spec.ActiveDeadlineSeconds, "must be positive"))
+	}
+
+	templatePath := specPath.Child("template")
+	errs = append(errs, validateLabels(j.Spec.Template.Labels, templatePath.Child("metadata", "labels"))...)
+	errs = append(errs, validatePodSpec(j.Spec.Template.Spec, templatePath.Child("spec"))...)
+
+	if len(errs) > 0 {
+		return ValidationError{Job: j.Name, Errors: errs}
+	}
+	return nil
+}
+
+func validateMetadata(name, namespace string, labels map[string]string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	// The job controller labels its pods with the name, so it must be a valid label too
+	if name == "" {
+		errs = append(errs, field.Required(path.Child("name"), "the job must have a name"))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(name) {
+			errs = append(errs, field.Invalid(path.Child("name"), name, msg))
+		}
+	}
+	if namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			errs = append(errs, field.Invalid(path.Child("namespace"), namespace, msg))
+		}
+	}
+	return append(errs, validateLabels(labels, path.Child("labels"))...)
+}
+
+func validateLabels(labels map[string]string, path *field.Path) field.ErrorList {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs field.ErrorList
+	for _, key := range keys {
+		value := labels[key]
+		for _, msg := range validation.IsQualifiedName(key) {
+			errs = append(errs, field.Invalid(path, key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(value) {
+			errs = append(errs, field.Invalid(path.Key(key), value, msg))
+		}
+	}
+	return errs
+}
+
+func validatePodSpec(spec corev1.PodSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	switch spec.RestartPolicy {
+	case corev1.RestartPolicyOnFailure, corev1.RestartPolicyNever:
+	default:
+		errs = append(errs, field.NotSupported(path.Child("restartPolicy"), spec.RestartPolicy,
+			[]string{string(corev1.RestartPolicyOnFailure), string(corev1.RestartPolicyNever)}))
+	}
+
+	volumes := make(map[string]bool)
+	for i, v := range spec.Volumes {
+		volumePath := path.Child("volumes").Index(i).Child("name")
+		switch {
+		case v.Name == "":
+			errs = append(errs, field.Required(volumePath, "the volume must have a name"))
+		case volumes[v.Name]:
+			errs = append(errs, field.Duplicate(volumePath, v.Name))
+		}
+		volumes[v.Name] = true
+	}
+
+	if len(spec.Containers) == 0 {
+		errs = append(errs, field.Required(path.Child("containers"), "the job must have a container"))
+	}
+	containers := make(map[string]bool)
+	errs = append(errs, validateContainers(spec.InitContainers, volumes, containers, path.Child("initContainers"))...)
+	errs = append(errs, validateContainers(spec.Containers, volumes, containers, path.Child("containers"))...)
+	return errs
+}
+
+// validateContainers checks the containers, recording their names so that
+// the names of the init containers and containers are unique.
+func validateContainers(containers []corev1.Container, volumes, names map[string]bool, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, c := range containers {
+		containerPath := path.Index(i)
+		switch {
+		case c.Name == "":
+			errs = append(errs, field.Required(containerPath.Child("name"), "the container must have a name"))
+		case names[c.Name]:
+			errs = append(errs, field.Duplicate(containerPath.Child("name"), c.Name))
+		default:
+			for _, msg := range validation.IsDNS1123Label(c.Name) {
+				errs = append(errs, field.Invalid(containerPath.Child("name"), c.Name, msg))
+			}
+		}
+		names[c.Name] = true
+
+		if strings.TrimSpace(c.Image) == "" {
+			errs = append(errs, field.Required(containerPath.Child("image"), "the container must have an image"))
+		}
+
+		for j, m := range c.VolumeMounts {
+			if !volumes[m.Name] {
+				errs = append(errs, field.NotFound(containerPath.Child("volumeMounts").Index(j).Child("name"), m.Name))
+			}
+		}
+
+		for j, env := range c.Env {
+			for _, msg := range validation.IsEnvVarName(env.Name) {
+				errs = append(errs, field.Invalid(containerPath.Child("env").Index(j).Child("name"), env.Name, msg))
+			}
+		}
+
+		// A request above its limit can never be scheduled
+		for resource, request := range c.Resources.Requests {
+			limit, ok := c.Resources.Limits[resource]
+			if ok && request.Cmp(limit) > 0 {
+				errs = append(errs, field.Invalid(containerPath.Child("resources", "requests").Key(string(resource)),
+					request.String(), fmt.Sprintf("must be less than or equal to the %s limit, %s", resource, limit.String())))
+			}
+		}
+	}
+	return errs
+}
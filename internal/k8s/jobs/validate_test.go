@@ -0,0 +1,100 @@
+package jobs
+
+import (
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validJob() *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo-transcode", Namespace: "handbrk8s"},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyOnFailure,
+					Containers: []corev1.Container{{
+						Name:         "handbrake",
+						Image:        "carolynvs/handbrakecli",
+						VolumeMounts: []corev1.VolumeMount{{Name: "work", MountPath: "/work"}},
+					}},
+					Volumes: []corev1.Volume{{Name: "work"}},
+				},
+			},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testcases := []struct {
+		Name      string
+		Modify    func(j *batchv1.Job)
+		WantField string
+	}{
+		{Name: "valid", Modify: func(j *batchv1.Job) {}},
+		{Name: "invalid name", Modify: func(j *batchv1.Job) { j.Name = "Foo.mkv-transcode" }, WantField: "metadata.name"},
+		{Name: "long name", Modify: func(j *batchv1.Job) { j.Name = strings.Repeat("a", 64) }, WantField: "metadata.name"},
+		{Name: "missing name", Modify: func(j *batchv1.Job) { j.Name = "" }, WantField: "metadata.name"},
+		{Name: "invalid label", Modify: func(j *batchv1.Job) { j.Labels = map[string]string{"team": "kids!"} }, WantField: "metadata.labels[team]"},
+		{Name: "always restart", Modify: func(j *batchv1.Job) { j.Spec.Template.Spec.RestartPolicy = corev1.RestartPolicyAlways },
+			WantField: "spec.template.spec.restartPolicy"},
+		{Name: "missing volume", Modify: func(j *batchv1.Job) { j.Spec.Template.Spec.Volumes = nil },
+			WantField: "spec.template.spec.containers[0].volumeMounts[0].name"},
+		{Name: "missing image", Modify: func(j *batchv1.Job) { j.Spec.Template.Spec.Containers[0].Image = "" },
+			WantField: "spec.template.spec.containers[0].image"},
+		{Name: "duplicate container", Modify: func(j *batchv1.Job) {
+			j.Spec.Template.Spec.InitContainers = []corev1.Container{{Name: "handbrake", Image: "alpine"}}
+		}, WantField: "spec.template.spec.containers[0].name"},
+		{Name: "request above limit", Modify: func(j *batchv1.Job) {
+			j.Spec.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+			}
+		}, WantField: "spec.template.spec.containers[0].resources.requests[memory]"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			j := validJob()
+			tc.Modify(j)
+
+			err := Validate(j)
+			if tc.WantField == "" {
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+				return
+			}
+			verr, ok := err.(ValidationError)
+			if !ok {
+				t.Fatalf("expected a ValidationError, got %#v", err)
+			}
+			if len(verr.Errors) != 1 || verr.Errors[0].Field != tc.WantField {
+				t.Fatalf("expected an error for %s, got %v", tc.WantField, verr)
+			}
+		})
+	}
+}
+
+func TestValidate_AllErrors(t *testing.T) {
+	j := validJob()
+	j.Name = "foo_transcode"
+	j.Spec.Template.Spec.Containers[0].Image = ""
+	j.Spec.Template.Spec.Volumes = nil
+
+	err := Validate(j)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("expected a ValidationError, got %#v", err)
+	}
+	if len(verr.Errors) != 3 {
+		t.Fatalf("expected every problem to be reported, got %v", verr)
+	}
+	if !strings.Contains(err.Error(), `"foo_transcode"`) {
+		t.Fatalf("expected the error to include the invalid name, got %s", err)
+	}
+}
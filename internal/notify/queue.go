@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Queue sends notifications in the background, so that a slow webhook never holds
+// up the pipeline. Flush the queue during shutdown, so that the notifications
+// that are still pending are delivered.
+type Queue struct {
+	notifier Notifier
+	pending  chan string
+	done     chan struct{}
+
+	// mu protects closed, so that nothing is queued once the queue is flushed
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewQueue starts sending the notifications queued with Notify to the notifier.
+// When size notifications are already pending, new notifications are dropped.
+func NewQueue(notifier Notifier, size int) *Queue {
+	q := &Queue{
+		notifier: notifier,
+		pending:  make(chan string, size),
+		done:     make(chan struct{}),
+	}
+	go q.send()
+	return q
+}
+
+// Notify queues the message to be sent.
+func (q *Queue) Notify(message string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return errors.Errorf("unable to send notification %q, shutting down", message)
+	}
+	select {
+	case q.pending <- message:
+		return nil
+	default:
+		return errors.Errorf("unable to send notification %q, too many notifications are pending", message)
+	}
+}
+
+// Flush stops queuing notifications, and waits up to the timeout for the
+// pending notifications to be sent.
+func (q *Queue) Flush(timeout time.Duration) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.pending)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-time.After(timeout):
+		return errors.Errorf("gave up sending notifications after %s, %d were not sent", timeout, len(q.pending))
+	}
+}
+
+// send delivers each queued notification, logging the notifications that couldn't be sent.
+func (q *Queue) send() {
+	defer close(q.done)
+	for message := range q.pending {
+		err := q.notifier.Notify(message)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowNotifier records the messages it was sent, after a delay.
+type slowNotifier struct {
+	delay time.Duration
+
+	mu   sync.Mutex
+	sent []string
+}
+
+func (n *slowNotifier) Notify(message string) error {
+	time.Sleep(n.delay)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.sent = append(n.sent, message)
+	return nil
+}
+
+func (n *slowNotifier) count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.sent)
+}
+
+func TestQueue_Flush(t *testing.T) {
+	n := &slowNotifier{delay: 10 * time.Millisecond}
+	q := NewQueue(n, 10)
+	for _, message := range []string{"foo failed", "bar failed", "baz failed"} {
+		err := q.Notify(message)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	err := q.Flush(time.Second)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if n.count() != 3 {
+		t.Fatalf("expected the pending notifications to be sent before the flush returned, got %v", n.sent)
+	}
+
+	err = q.Notify("late")
+	if err == nil {
+		t.Fatal("expected notifications to be refused once the queue is flushed")
+	}
+}
+
+func TestQueue_FlushTimeout(t *testing.T) {
+	n := &slowNotifier{delay: time.Second}
+	q := NewQueue(n, 10)
+	q.Notify("foo failed")
+	q.Notify("bar failed")
+
+	start := time.Now()
+	err := q.Flush(50 * time.Millisecond)
+	if err == nil {
+		t.Fatal("expected the flush to give up when the notifications take too long")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the flush to give up after the timeout, took %s", elapsed)
+	}
+}
+
+func TestQueue_Full(t *testing.T) {
+	n := &slowNotifier{delay: time.Second}
+	q := NewQueue(n, 1)
+	defer q.Flush(0)
+
+	// The first notification is being sent, and the second is pending
+	q.Notify("foo failed")
+	time.Sleep(10 * time.Millisecond)
+	err := q.Notify("bar failed")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	err = q.Notify("baz failed")
+	if err == nil {
+		t.Fatal("expected a notification to be dropped when the queue is full")
+	}
+}
@@ -0,0 +1,69 @@
+// Package notify sends alerts about the videos being processed.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
+	"github.com/pkg/errors"
+)
+
+// Notifier sends an alert.
+type Notifier interface {
+	Notify(message string) error
+}
+
+// Webhook posts alerts to a URL as JSON, {"text": "MESSAGE"}, which is accepted
+// by Slack incoming webhooks, and is simple to handle with any other service.
+type Webhook struct {
+	URL  string
+	http *httpclient.Client
+}
+
+// NewWebhook creates a notifier that posts to the URL, with the timeout and retries of the policy.
+// A notification is only retried when the webhook is rate limited or unavailable, so that it isn't
+// sent twice. The zero Policy is httpclient.DefaultPolicy.
+func NewWebhook(webhookURL string, policy httpclient.Policy) *Webhook {
+	return &Webhook{
+		URL:  webhookURL,
+		http: httpclient.New(policy),
+	}
+}
+
+// Notify posts the message to the webhook.
+func (w *Webhook) Notify(message string) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{message})
+	if err != nil {
+		return errors.Wrap(err, "unable to serialize the notification")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New("unable to send a notification to the webhook, invalid url")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.http.Do(req, "the notification to the webhook")
+	if err != nil {
+		// The URL usually has a secret in it, so keep it out of the error
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return errors.Wrap(err, "unable to send a notification to the webhook")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("unable to send a notification to the webhook, it returned %s: %s",
+			resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
+)
+
+func TestWebhook_Notify(t *testing.T) {
+	var got struct{ Text string }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL, httpclient.Policy{}).Notify("foo.mkv is taking too long")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if got.Text != "foo.mkv is taking too long" {
+		t.Fatalf("expected the message in the text field, got %#v", got)
+	}
+}
+
+func TestWebhook_NotifyError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	err := NewWebhook(srv.URL+"/secret", httpclient.Policy{}).Notify("foo")
+	if err == nil {
+		t.Fatal("expected an error when the webhook rejects the notification")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("expected the webhook url to be kept out of the error, got %v", err)
+	}
+}
+
+func TestWebhook_NotifyRetry(t *testing.T) {
+	var attempts int
+	var got struct{ Text string }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	policy := httpclient.Policy{Timeout: time.Second, Retries: 2, Backoff: time.Millisecond}
+	err := NewWebhook(srv.URL, policy).Notify("foo")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if attempts != 2 || got.Text != "foo" {
+		t.Fatalf("expected the notification to be sent again once the webhook stopped rate limiting it, got %d attempts and %#v", attempts, got)
+	}
+}
@@ -8,14 +8,18 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
 	"github.com/pkg/errors"
 )
 
 // ServerConfig is the set of information necessary to connect to a Plex server.
+// The HTTP policy is the timeout and retries of the requests, which defaults to httpclient.DefaultPolicy.
 type ServerConfig struct {
 	URL   string
 	Token string
+	HTTP  httpclient.Policy
 }
 
 // LibraryConfig is the set of information necessary to upload videos to a Plex library.
@@ -27,17 +31,33 @@ type LibraryConfig struct {
 
 type Client struct {
 	ServerConfig
+	http *httpclient.Client
 }
 
 func NewClient(cfg ServerConfig) Client {
-	return Client{ServerConfig: cfg}
+	return Client{ServerConfig: cfg, http: httpclient.New(cfg.HTTP)}
+}
+
+// httpClient sends the requests with the HTTP policy of the server.
+func (c Client) httpClient() *httpclient.Client {
+	if c.http == nil {
+		return httpclient.New(c.HTTP)
+	}
+	return c.http
 }
 
 type Library struct {
-	c    Client
-	Id   string    `xml:"key,attr"`
-	Name string    `xml:"title,attr"`
-	Type MediaType `xml:"type,attr"`
+	c          Client
+	Id         string    `xml:"key,attr"`
+	Name       string    `xml:"title,attr"`
+	Type       MediaType `xml:"type,attr"`
+	Refreshing bool      `xml:"refreshing,attr"`
+	ScannedAt  int64     `xml:"scannedAt,attr"`
+}
+
+// LastScanned is when Plex last scanned the library for changes.
+func (l Library) LastScanned() time.Time {
+	return time.Unix(l.ScannedAt, 0)
 }
 
 type MediaType string
@@ -108,43 +128,65 @@ func (c Client) Get(format string, query map[string]string, result interface{},
 	for key, val := range query {
 		qs.Add(key, val)
 	}
+	// Log the url without the token
+	logUrl := *u
+	logUrl.RawQuery = qs.Encode()
 	qs.Add("X-Plex-Token", c.Token)
 	u.RawQuery = qs.Encode()
 
-	resp, err := http.Get(u.String())
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
-		return errors.Wrapf(err, "unable to get %s", u)
+		return errors.Wrapf(err, "invalid url %s", logUrl.String())
 	}
+	resp, err := c.httpClient().Do(req, logUrl.String())
+	if err != nil {
+		// The url in the error includes the token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return errors.Wrapf(err, "unable to get %s", logUrl.String())
+	}
+	defer resp.Body.Close()
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return errors.Errorf("%d(%s) %s", resp.StatusCode, resp.Status, u)
+		return errors.Errorf("%d(%s) %s", resp.StatusCode, resp.Status, logUrl.String())
 	} else {
-		log.Printf("%d(%s) %s", resp.StatusCode, resp.Status, u)
+		log.Printf("%d(%s) %s", resp.StatusCode, resp.Status, logUrl.String())
 	}
-	defer resp.Body.Close()
 
 	if result != nil {
 		err = xml.NewDecoder(resp.Body).Decode(result)
 		if err != nil {
-			return errors.Wrapf(err, "Cannot decode result from %s into %T", u, result)
+			return errors.Wrapf(err, "Cannot decode result from %s into %T", logUrl.String(), result)
 		}
 	}
 
 	return nil
 }
 
-// lookup library id from name
-func (c Client) FindLibrary(name string) (library Library, err error) {
+// Libraries lists the libraries on the Plex server.
+func (c Client) Libraries() ([]Library, error) {
 	var result struct {
 		Libraries []Library `xml:"Directory"`
 	}
-	err = c.Get("library/sections", nil, &result)
+	err := c.Get("library/sections", nil, &result)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list Plex libraries")
+	}
+	for i := range result.Libraries {
+		result.Libraries[i].c = c
+	}
+	return result.Libraries, nil
+}
+
+// lookup library id from name
+func (c Client) FindLibrary(name string) (library Library, err error) {
+	libraries, err := c.Libraries()
 	if err != nil {
-		return library, errors.Wrap(err, "unable to list Plex libraries")
+		return library, err
 	}
 
-	for _, l := range result.Libraries {
+	for _, l := range libraries {
 		if l.Name == name {
-			l.c = c
 			return l, nil
 		}
 	}
@@ -219,3 +261,71 @@ func (l *Library) Update() error {
 	err := l.c.Get("library/sections/%s/refresh", nil, nil, l.Id)
 	return errors.Wrapf(err, "unable to update the %s library", l.Name)
 }
+
+// refreshPollInterval is how often to check if a library is done refreshing.
+var refreshPollInterval = time.Second
+
+// maxRefreshWait is how long to wait for a refresh started elsewhere to complete.
+var maxRefreshWait = 5 * time.Minute
+
+// UpdateDebounced refreshes the library, coalescing refreshes requested around
+// the same time, e.g. by other uploads. It waits for the window, and then only
+// refreshes the library when it hasn't been scanned since the change was made.
+// When a refresh is already in progress, the call waits for it to complete instead.
+func (l *Library) UpdateDebounced(changedAt time.Time, window time.Duration) error {
+	return l.UpdateThrottled(changedAt, window, 0)
+}
+
+// UpdateThrottled refreshes the library like UpdateDebounced, and also never scans the
+// library again within minInterval of its last scan, even when the changes are from
+// separate bursts of uploads. A refresh that is due sooner waits until the interval has
+// passed, and is skipped when the library was scanned since the change in the meantime,
+// so the changes made within the interval are coalesced into the next scan. The last
+// scan is recorded by Plex, so the interval is shared by everything that refreshes the
+// library, including scans started by Plex itself. 0 disables the interval.
+func (l *Library) UpdateThrottled(changedAt time.Time, window, minInterval time.Duration) error {
+	time.Sleep(window)
+
+	latest, scanned, err := l.waitForRefresh(changedAt)
+	if err != nil || scanned {
+		return err
+	}
+
+	if next := latest.LastScanned().Add(minInterval); minInterval > 0 && time.Now().Before(next) {
+		log.Printf("the %s library was scanned at %s, waiting until %s to refresh it again", l.Name, latest.LastScanned(), next)
+		time.Sleep(time.Until(next))
+
+		_, scanned, err = l.waitForRefresh(changedAt)
+		if err != nil || scanned {
+			return err
+		}
+	}
+
+	return l.Update()
+}
+
+// waitForRefresh waits for a refresh in progress to complete, and then determines
+// if the library was scanned since the change was made.
+func (l *Library) waitForRefresh(changedAt time.Time) (Library, bool, error) {
+	latest, err := l.c.FindLibrary(l.Name)
+	if err != nil {
+		return latest, false, err
+	}
+
+	deadline := time.Now().Add(maxRefreshWait)
+	for latest.Refreshing && time.Now().Before(deadline) {
+		log.Printf("the %s library is already refreshing, waiting for it to complete...", l.Name)
+		time.Sleep(refreshPollInterval)
+		latest, err = l.c.FindLibrary(l.Name)
+		if err != nil {
+			return latest, false, err
+		}
+	}
+
+	// Plex only records when a scan happened to the second
+	if !latest.Refreshing && !latest.LastScanned().Before(changedAt.Truncate(time.Second)) {
+		log.Printf("the %s library was refreshed at %s, skipping update", l.Name, latest.LastScanned())
+		return latest, true, nil
+	}
+	return latest, false, nil
+}
@@ -1,8 +1,13 @@
 package plex
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 func buildClient(t *testing.T) (c Client) {
@@ -134,3 +139,134 @@ func TestLibrary_Update(t *testing.T) {
 		t.Fatalf("%#v", err)
 	}
 }
+
+// fakePlex serves the library sections, counting library refreshes.
+type fakePlex struct {
+	mu         sync.Mutex
+	scannedAt  time.Time
+	refreshing int // number of section listings to report refreshing
+	refreshes  int
+
+	// rescannedAt replaces scannedAt once the sections were listed rescanAfter times,
+	// as if the library was scanned by someone else in the meantime.
+	rescannedAt time.Time
+	rescanAfter int
+	listings    int
+}
+
+func (p *fakePlex) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch r.URL.Path {
+	case "/library/sections":
+		p.listings++
+		if p.rescanAfter > 0 && p.listings > p.rescanAfter {
+			p.scannedAt = p.rescannedAt
+		}
+		refreshing := 0
+		if p.refreshing > 0 {
+			p.refreshing--
+			refreshing = 1
+		}
+		fmt.Fprintf(w, `<MediaContainer><Directory key="1" title="Movies" type="movie" refreshing="%d" scannedAt="%d"/></MediaContainer>`,
+			refreshing, p.scannedAt.Unix())
+	case "/library/sections/1/refresh":
+		p.refreshes++
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestLibrary_UpdateDebounced(t *testing.T) {
+	refreshPollInterval = time.Millisecond
+	changedAt := time.Now()
+
+	testcases := []struct {
+		Name          string
+		ScannedAt     time.Time
+		Refreshing    int
+		WantRefreshes int
+	}{
+		{Name: "not scanned since the change", ScannedAt: changedAt.Add(-time.Hour), WantRefreshes: 1},
+		{Name: "scanned since the change", ScannedAt: changedAt.Add(time.Second)},
+		{Name: "refreshing", ScannedAt: changedAt.Add(time.Second), Refreshing: 3},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			fake := &fakePlex{scannedAt: tc.ScannedAt, refreshing: tc.Refreshing}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			c := NewClient(ServerConfig{URL: srv.URL, Token: "abc123"})
+			lib, err := c.FindLibrary("Movies")
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			err = lib.UpdateDebounced(changedAt, time.Millisecond)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.refreshes != tc.WantRefreshes {
+				t.Fatalf("expected %d refreshes, got %d", tc.WantRefreshes, fake.refreshes)
+			}
+		})
+	}
+}
+
+func TestLibrary_UpdateThrottled(t *testing.T) {
+	refreshPollInterval = time.Millisecond
+	minInterval := 2500 * time.Millisecond
+
+	// Plex records the scans to the second, so they are between 1s and 2s before the change
+	testcases := []struct {
+		Name          string
+		ScannedAgo    time.Duration
+		Rescanned     bool
+		WantWait      bool
+		WantRefreshes int
+	}{
+		{Name: "scanned before the interval", ScannedAgo: time.Hour, WantRefreshes: 1},
+		{Name: "scanned within the interval", ScannedAgo: time.Second, WantWait: true, WantRefreshes: 1},
+		{Name: "scanned while waiting", ScannedAgo: time.Second, Rescanned: true, WantWait: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			changedAt := time.Now()
+			fake := &fakePlex{scannedAt: changedAt.Add(-tc.ScannedAgo).Truncate(time.Second)}
+			if tc.Rescanned {
+				// Once for FindLibrary, and once before waiting for the interval
+				fake.rescannedAt, fake.rescanAfter = changedAt.Add(time.Second), 2
+			}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			c := NewClient(ServerConfig{URL: srv.URL, Token: "abc123"})
+			lib, err := c.FindLibrary("Movies")
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			start := time.Now()
+			err = lib.UpdateThrottled(changedAt, 0, minInterval)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			if waited := time.Since(start) > 250*time.Millisecond; waited != tc.WantWait {
+				t.Fatalf("expected waiting for the interval to be %t", tc.WantWait)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.refreshes != tc.WantRefreshes {
+				t.Fatalf("expected %d refreshes, got %d", tc.WantRefreshes, fake.refreshes)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// Package s3 lists and downloads objects from an S3 compatible object store,
+// such as AWS S3 or MinIO, using path-style requests.
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Config has the connection information for a bucket.
+type Config struct {
+	// Endpoint is the base URL of the object store, for example https://s3.us-east-1.amazonaws.com
+	Endpoint string
+
+	// Region of the bucket, used to sign requests.
+	Region string
+
+	// Bucket containing the objects.
+	Bucket string
+
+	// AccessKey and SecretKey authenticate requests.
+	AccessKey, SecretKey string
+}
+
+// Client makes requests to a bucket.
+type Client struct {
+	Config
+	http *http.Client
+
+	// uploads is used for requests with a body, such as a video, which can take
+	// far longer than the timeout of the other requests. Each upload has a deadline
+	// from its size instead, see uploadTimeout.
+	uploads *http.Client
+}
+
+// MaxPutSize is the largest object that Put uploads in a single request, which is the
+// limit of AWS S3.
+const MaxPutSize = 5 * 1024 * 1024 * 1024
+
+// minUploadRate is the slowest that an upload may be sent, in bytes per second, before
+// it is abandoned, so that a stalled upload doesn't hang forever.
+const minUploadRate = 1024 * 1024
+
+// uploadTimeout is how long an upload of the size may take, at the minUploadRate, plus the
+// timeout of the other requests for the object store to accept it.
+func uploadTimeout(size int64) time.Duration {
+	return requestTimeout + time.Duration(size/minUploadRate)*time.Second
+}
+
+// requestTimeout is how long a request without a body may take.
+const requestTimeout = 5 * time.Minute
+
+// metadataHeaderPrefix is the prefix of the headers with the user metadata of an object.
+const metadataHeaderPrefix = "x-amz-meta-"
+
+// Object is an object in the bucket.
+type Object struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+
+	// Metadata is the user metadata of the object, by its lowercase name.
+	// Only set by Head, listing objects doesn't return it.
+	Metadata map[string]string
+}
+
+// StatusError is a request that the object store didn't accept.
+type StatusError struct {
+	Method, Path string
+	Status       string
+	StatusCode   int
+	Body         string
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+// IsNotFound determines if the error is from a request for an object that doesn't exist.
+func IsNotFound(err error) bool {
+	statusErr, ok := errors.Cause(err).(StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+// NewClient creates a client for the bucket.
+func NewClient(cfg Config) *Client {
+	return &Client{
+		Config:  cfg,
+		http:    &http.Client{Timeout: requestTimeout},
+		uploads: &http.Client{},
+	}
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List returns the objects with the prefix that sort after startAfter, in key order.
+func (c *Client) List(prefix, startAfter string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		if startAfter != "" {
+			query.Set("start-after", startAfter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do("GET", "", query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to list the objects in %s/%s", c.Bucket, prefix)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse the objects in %s/%s", c.Bucket, prefix)
+		}
+
+		for _, o := range result.Contents {
+			objects = append(objects, Object{
+				Key:          o.Key,
+				Size:         o.Size,
+				ETag:         strings.Trim(o.ETag, `"`),
+				LastModified: o.LastModified,
+			})
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get returns the contents of an object, which must be closed.
+func (c *Client) Get(key string) (io.ReadCloser, error) {
+	resp, err := c.do("GET", key, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get %s/%s", c.Bucket, key)
+	}
+	return resp.Body, nil
+}
+
+// Head returns the size and metadata of an object, without its contents.
+// Check for an object that doesn't exist with IsNotFound.
+func (c *Client) Head(key string) (Object, error) {
+	resp, err := c.do("HEAD", key, nil)
+	if err != nil {
+		return Object{}, errors.Wrapf(err, "unable to stat %s/%s", c.Bucket, key)
+	}
+	resp.Body.Close()
+
+	o := Object{
+		Key:      key,
+		Size:     resp.ContentLength,
+		ETag:     strings.Trim(resp.Header.Get("ETag"), `"`),
+		Metadata: make(map[string]string),
+	}
+	o.LastModified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	for name := range resp.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, metadataHeaderPrefix) {
+			o.Metadata[strings.TrimPrefix(name, metadataHeaderPrefix)] = resp.Header.Get(name)
+		}
+	}
+	return o, nil
+}
+
+// Put uploads an object with the user metadata. The body has the size, and sum is its
+// hex encoded SHA256, so that the object store rejects an object that was corrupted on the way.
+// An object larger than MaxPutSize is rejected, since it can't be uploaded in a single request.
+func (c *Client) Put(key string, body io.Reader, size int64, sum string, metadata map[string]string) error {
+	if size > MaxPutSize {
+		return errors.Errorf("unable to put %s/%s, its size %d is larger than the limit of %d for a single upload",
+			c.Bucket, key, size, int64(MaxPutSize))
+	}
+	headers := make(map[string]string, len(metadata))
+	for name, value := range metadata {
+		headers[metadataHeaderPrefix+strings.ToLower(name)] = value
+	}
+	resp, err := c.send("PUT", key, nil, body, size, sum, headers)
+	if err != nil {
+		return errors.Wrapf(err, "unable to put %s/%s", c.Bucket, key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Delete removes an object.
+func (c *Client) Delete(key string) error {
+	resp, err := c.do("DELETE", key, nil)
+	if err != nil {
+		return errors.Wrapf(err, "unable to delete %s/%s", c.Bucket, key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for the bucket, or an object in the bucket, returning
+// an error when the response is not successful.
+func (c *Client) do(method, key string, query url.Values) (*http.Response, error) {
+	return c.send(method, key, query, nil, 0, emptyPayloadHash, nil)
+}
+
+// send a signed request with a body of the size, whose hex encoded SHA256 is payloadHash,
+// and the extra headers, returning a StatusError when the response is not successful.
+func (c *Client) send(method, key string, query url.Values, body io.Reader, size int64, payloadHash string, headers map[string]string) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid endpoint %s", c.Endpoint)
+	}
+	u.Path = "/" + c.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	// Escape the path the same way that it is signed
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	signPayload(req, c.AccessKey, c.SecretKey, c.Region, time.Now(), payloadHash)
+
+	client := c.http
+	cancel := func() {}
+	if body != nil {
+		client = c.uploads
+		var ctx context.Context
+		ctx, cancel = context.WithTimeout(context.Background(), uploadTimeout(size))
+		req = req.WithContext(ctx)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, StatusError{Method: method, Path: u.Path, Status: resp.Status, StatusCode: resp.StatusCode,
+			Body: strings.TrimSpace(string(body))}
+	}
+	return resp, nil
+}
+
+// cancelOnClose cancels the deadline of a request once its response is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel func()
+}
+
+func (b cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_PutHead(t *testing.T) {
+	bucket, srv, client := newFakeBucket()
+	defer srv.Close()
+
+	_, err := client.Head("Movies/foo.mkv")
+	if !IsNotFound(err) {
+		t.Fatalf("expected a missing object to not be found, got %#v", err)
+	}
+
+	contents := "foo video"
+	sum := sha256.Sum256([]byte(contents))
+	err = client.Put("Movies/foo.mkv", strings.NewReader(contents), int64(len(contents)), hex.EncodeToString(sum[:]),
+		map[string]string{"SHA256": "abc123"})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if bucket.objects["Movies/foo.mkv"] != contents {
+		t.Fatalf("expected the object to be uploaded, got %q", bucket.objects["Movies/foo.mkv"])
+	}
+
+	o, err := client.Head("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if o.Size != int64(len(contents)) {
+		t.Fatalf("expected the size of the object to be %d, got %d", len(contents), o.Size)
+	}
+	if o.Metadata["sha256"] != "abc123" {
+		t.Fatalf("expected the metadata of the object, got %v", o.Metadata)
+	}
+
+	// The object store rejects a body that doesn't match its signed hash
+	err = client.Put("Movies/bar.mkv", strings.NewReader("corrupted"), int64(len("corrupted")), hex.EncodeToString(sum[:]), nil)
+	if err == nil {
+		t.Fatal("expected an object that doesn't match its hash to be rejected")
+	}
+}
+
+func TestClient_PutTooLarge(t *testing.T) {
+	bucket, srv, client := newFakeBucket()
+	defer srv.Close()
+
+	err := client.Put("Movies/foo.mkv", strings.NewReader(""), MaxPutSize+1, emptyPayloadHash, nil)
+	if err == nil {
+		t.Fatal("expected an object larger than a single upload to be rejected")
+	}
+	if _, ok := bucket.objects["Movies/foo.mkv"]; ok {
+		t.Fatal("expected the object to not be uploaded")
+	}
+}
+
+func TestUploadTimeout(t *testing.T) {
+	if got := uploadTimeout(0); got != requestTimeout {
+		t.Fatalf("expected an empty upload to have the request timeout, got %s", got)
+	}
+	if got := uploadTimeout(MaxPutSize); got != requestTimeout+5120*time.Second {
+		t.Fatalf("expected the largest upload to have time to be sent at the minimum rate, got %s", got)
+	}
+}
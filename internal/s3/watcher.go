@@ -0,0 +1,507 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// RejectInvalidKey is an object with a key that can't be downloaded into the local directory.
+const RejectInvalidKey fs.RejectReason = "invalid-key"
+
+// Watcher polls a prefix in a bucket for new objects, waiting for each object
+// to stop changing before downloading it to a local directory and signaling an
+// event for the local file. Objects that are still being uploaded with a
+// multipart upload aren't listed until the upload completes.
+type Watcher struct {
+	client   *Client
+	prefix   string
+	localDir string
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	// marker is the last key where every object up to and including it was handled,
+	// so that each poll only lists the objects after it.
+	marker string
+
+	// resyncedAt is when every object with the prefix was last listed, see ResyncInterval.
+	resyncedAt time.Time
+
+	// pending are the objects waiting to stop changing.
+	pending map[string]pendingObject
+
+	// downloaded are the ETags of the objects that were already downloaded, by key, so that
+	// an object is only downloaded again when it changes.
+	downloaded map[string]string
+
+	// pausedMu protects paused, which skips polling until the watcher is resumed.
+	pausedMu sync.Mutex
+	paused   bool
+
+	// PollInterval is how often the bucket is listed.
+	PollInterval time.Duration
+
+	// ResyncInterval is how often every object with the prefix is listed, instead of only the
+	// objects after the marker, to find the new objects whose keys sort before the marker, and
+	// the objects that changed. 0 lists every object on each poll.
+	ResyncInterval time.Duration
+
+	// StableThreshold is the duration that an object must not change before it is downloaded.
+	StableThreshold time.Duration
+
+	// Prober optionally reads the metadata of each downloaded file before signaling its event.
+	// Defaults to nil, which disables probing.
+	Prober *ffprobe.Prober
+
+	// Scratch optionally holds the objects while they are downloaded, instead of
+	// a temporary file in the local directory.
+	Scratch *fs.Scratch
+
+	// Delete removes an object from the bucket once it is downloaded.
+	Delete bool
+
+	// StateFile optionally keeps the downloaded objects and the marker, so that they aren't
+	// downloaded or listed again when the watcher restarts.
+	StateFile string
+
+	// Cipher optionally encrypts the StateFile, since it has the key of every object.
+	Cipher *fs.Cipher
+
+	// Events signal when an object was downloaded.
+	Events chan fs.FileEvent
+
+	// Rejected signals when an object was skipped. Rejections
+	// are dropped when the channel is full, so they never block watching.
+	Rejected chan fs.RejectedFile
+}
+
+// pendingObject records when an object was first seen with its current size and etag,
+// and when it was first seen at all.
+type pendingObject struct {
+	Object
+	seenAt      time.Time
+	firstSeenAt time.Time
+}
+
+// Option configures a Watcher before it starts watching.
+type Option func(w *Watcher) error
+
+// WithPollInterval lists the bucket at the interval, instead of every 30 seconds.
+func WithPollInterval(interval time.Duration) Option {
+	return func(w *Watcher) error {
+		if interval <= 0 {
+			return errors.Errorf("invalid poll interval %s, must be positive", interval)
+		}
+		w.PollInterval = interval
+		return nil
+	}
+}
+
+// WithResyncInterval lists every object with the prefix at the interval, instead of every hour,
+// to find the objects that were added with a key that sorts before the last key that was handled.
+func WithResyncInterval(interval time.Duration) Option {
+	return func(w *Watcher) error {
+		if interval <= 0 {
+			return errors.Errorf("invalid resync interval %s, must be positive", interval)
+		}
+		w.ResyncInterval = interval
+		return nil
+	}
+}
+
+// WithProber reads the metadata of each downloaded file with ffprobe, including it in the event.
+func WithProber(p *ffprobe.Prober) Option {
+	return func(w *Watcher) error {
+		w.Prober = p
+		return nil
+	}
+}
+
+// WithScratch downloads the objects into the scratch directory, waiting
+// for scratch space, and then moves them to the local directory.
+func WithScratch(s *fs.Scratch) Option {
+	return func(w *Watcher) error {
+		w.Scratch = s
+		return nil
+	}
+}
+
+// WithDelete removes each object from the bucket after it is downloaded.
+func WithDelete() Option {
+	return func(w *Watcher) error {
+		w.Delete = true
+		return nil
+	}
+}
+
+// WithStateFile keeps the objects that were downloaded, and the marker, in the file, encrypted
+// with the cipher when it isn't nil, so that they aren't downloaded or listed again when the
+// watcher restarts.
+func WithStateFile(path string, cipher *fs.Cipher) Option {
+	return func(w *Watcher) error {
+		w.StateFile = path
+		w.Cipher = cipher
+		return nil
+	}
+}
+
+// NewWatcher watches the objects with the prefix, downloading them into the local directory
+// at the same path relative to the prefix.
+func NewWatcher(client *Client, prefix, localDir string, stableThreshold time.Duration, opts ...Option) (*Watcher, error) {
+	localDir, err := filepath.Abs(localDir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to resolve the absolute path of the local directory %s", localDir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Watcher{
+		client:          client,
+		prefix:          prefix,
+		localDir:        localDir,
+		ctx:             ctx,
+		cancel:          cancel,
+		pending:         make(map[string]pendingObject),
+		downloaded:      make(map[string]string),
+		PollInterval:    30 * time.Second,
+		ResyncInterval:  time.Hour,
+		StableThreshold: stableThreshold,
+		Events:          make(chan fs.FileEvent),
+		Rejected:        make(chan fs.RejectedFile, 100),
+	}
+
+	for _, opt := range opts {
+		err = opt(w)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	}
+	err = w.loadState()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go w.start()
+
+	return w, nil
+}
+
+// Files signals when an object was downloaded.
+func (w *Watcher) Files() <-chan fs.FileEvent {
+	return w.Events
+}
+
+// Rejections signals when an object was skipped.
+func (w *Watcher) Rejections() <-chan fs.RejectedFile {
+	return w.Rejected
+}
+
+// Close stops polling the bucket.
+func (w *Watcher) Close() {
+	w.cancel()
+}
+
+// Pause stops listing the bucket until the watcher is resumed. New objects are
+// found by the first poll after the watcher resumes.
+func (w *Watcher) Pause() {
+	w.pausedMu.Lock()
+	defer w.pausedMu.Unlock()
+	w.paused = true
+}
+
+// Resume lists the bucket again, starting with the next poll.
+func (w *Watcher) Resume() {
+	w.pausedMu.Lock()
+	defer w.pausedMu.Unlock()
+	w.paused = false
+}
+
+func (w *Watcher) isPaused() bool {
+	w.pausedMu.Lock()
+	defer w.pausedMu.Unlock()
+	return w.paused
+}
+
+func (w *Watcher) start() {
+	defer close(w.Events)
+
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if !w.isPaused() {
+			w.poll(time.Now())
+		}
+
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll lists the objects after the marker, or every object with the prefix once the ResyncInterval
+// passed, downloading the objects that haven't changed since they were first seen at least
+// StableThreshold ago. An object that was already downloaded is skipped, unless its ETag changed.
+func (w *Watcher) poll(now time.Time) {
+	startAfter := w.marker
+	if now.Sub(w.resyncedAt) >= w.ResyncInterval {
+		startAfter = ""
+	}
+	objects, err := w.client.List(w.prefix, startAfter)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	changed := false
+	if startAfter == "" {
+		w.resyncedAt = now
+		changed = true
+	}
+
+	marker := startAfter
+	advance := true
+	listed := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		listed[o.Key] = true
+		before, was := w.downloaded[o.Key]
+		if !w.handle(o, now) {
+			advance = false
+		}
+		if advance {
+			marker = o.Key
+		}
+		if after, is := w.downloaded[o.Key]; is != was || after != before {
+			changed = true
+		}
+	}
+	if marker != w.marker {
+		w.marker = marker
+		changed = true
+	}
+
+	// Forget the objects that were removed from the bucket, of the keys that were listed
+	for key := range w.pending {
+		if key > startAfter && !listed[key] {
+			delete(w.pending, key)
+		}
+	}
+	for key := range w.downloaded {
+		if key > startAfter && !listed[key] {
+			delete(w.downloaded, key)
+			changed = true
+		}
+	}
+	if changed {
+		w.saveState()
+	}
+}
+
+// watcherState is the format of the StateFile.
+type watcherState struct {
+	Marker     string            `json:"marker,omitempty"`
+	ResyncedAt time.Time         `json:"resyncedAt"`
+	Downloaded map[string]string `json:"downloaded"`
+}
+
+// loadState reads the downloaded objects and the marker from the StateFile. A state
+// file that doesn't exist yet is empty.
+func (w *Watcher) loadState() error {
+	if w.StateFile == "" {
+		return nil
+	}
+	data, err := w.Cipher.ReadFile(w.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "unable to read the downloaded objects from %s", w.StateFile)
+	}
+	var state watcherState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return errors.Wrapf(err, "unable to read the downloaded objects from %s", w.StateFile)
+	}
+	w.marker, w.resyncedAt = state.Marker, state.ResyncedAt
+	if state.Downloaded != nil {
+		w.downloaded = state.Downloaded
+	}
+	return nil
+}
+
+// saveState writes the downloaded objects and the marker to the StateFile, through a temporary file
+// so that it is never seen partially written. Errors are logged, and it is saved again by the next poll.
+func (w *Watcher) saveState() {
+	if w.StateFile == "" {
+		return
+	}
+	data, err := json.Marshal(watcherState{Marker: w.marker, ResyncedAt: w.resyncedAt, Downloaded: w.downloaded})
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to serialize the downloaded objects"))
+		return
+	}
+	tmp := w.StateFile + ".tmp"
+	err = w.Cipher.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, w.StateFile)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Println(errors.Wrapf(err, "unable to save the downloaded objects to %s", w.StateFile))
+	}
+}
+
+// handle downloads the object once it is stable, returning if the object is finished,
+// either downloaded or skipped, and doesn't need to be checked again.
+func (w *Watcher) handle(o Object, now time.Time) bool {
+	// Skip the placeholders that some tools create for directories
+	if strings.HasSuffix(o.Key, "/") {
+		return true
+	}
+
+	if etag, ok := w.downloaded[o.Key]; ok && etag == o.ETag {
+		return true
+	}
+
+	p, ok := w.pending[o.Key]
+	if !ok || p.Size != o.Size || p.ETag != o.ETag {
+		firstSeenAt := now
+		if ok {
+			firstSeenAt = p.firstSeenAt
+		}
+		w.pending[o.Key] = pendingObject{Object: o, seenAt: now, firstSeenAt: firstSeenAt}
+		return false
+	}
+	if now.Sub(p.seenAt) < w.StableThreshold {
+		return false
+	}
+
+	path, ok := w.localPath(o.Key)
+	if !ok {
+		log.Printf("%s is outside of %s, skipping\n", o.Key, w.prefix)
+		delete(w.pending, o.Key)
+		w.reject(o.Key, RejectInvalidKey)
+		return true
+	}
+
+	// Retry on the next poll when the download fails
+	err := w.download(o.Key, path)
+	if err != nil {
+		log.Println(err)
+		return false
+	}
+	delete(w.pending, o.Key)
+	w.downloaded[o.Key] = o.ETag
+
+	if w.Delete {
+		err = w.client.Delete(o.Key)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	e := w.newEvent(path)
+	e.DetectedAt, e.StableAt = p.firstSeenAt, now
+	select {
+	case <-w.ctx.Done():
+	case w.Events <- e:
+	}
+	return true
+}
+
+// localPath is where the object is downloaded, returning false when the key would
+// be outside of the local directory.
+func (w *Watcher) localPath(key string) (string, bool) {
+	rel := filepath.FromSlash(strings.TrimPrefix(strings.TrimPrefix(key, w.prefix), "/"))
+	path := filepath.Join(w.localDir, rel)
+	if rel == "" || !strings.HasPrefix(path, w.localDir+string(filepath.Separator)) {
+		return "", false
+	}
+	return path, true
+}
+
+// download copies the object to a temporary file, either in the scratch directory or
+// hidden next to the path, and then moves it, so that a partially downloaded file is never processed.
+func (w *Watcher) download(key, path string) error {
+	log.Printf("downloading %s to %s\n", key, path)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrapf(err, "unable to create directory %s", filepath.Dir(path))
+	}
+
+	tmpPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".download")
+	if w.Scratch != nil {
+		tmpPath, err = w.Scratch.Acquire(w.ctx, strings.TrimPrefix(key, w.prefix))
+		if err != nil {
+			return err
+		}
+		defer w.Scratch.Release(tmpPath)
+	}
+
+	body, err := w.client.Get(key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create %s", tmpPath)
+	}
+	_, err = io.Copy(f, body)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "unable to download %s to %s", key, tmpPath)
+	}
+
+	// The scratch directory may be on another file system
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		err = fs.MoveFile(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrapf(err, "unable to move %s to %s", tmpPath, path)
+	}
+	return nil
+}
+
+// reject signals that the object was skipped, dropping the rejection when nothing is receiving them.
+func (w *Watcher) reject(key string, reason fs.RejectReason) {
+	select {
+	case w.Rejected <- fs.RejectedFile{Path: key, Reason: reason}:
+	default:
+	}
+}
+
+// newEvent creates the event for a downloaded file, probing it when enabled.
+// A file that can't be probed is still signaled, without metadata.
+func (w *Watcher) newEvent(path string) fs.FileEvent {
+	e := fs.FileEvent{Path: path, WatchRoot: w.localDir}
+	e.Size, _ = fs.Size(path)
+	if w.Prober != nil {
+		m, err := w.Prober.Probe(path)
+		if err != nil {
+			log.Println(err)
+		} else {
+			e.Metadata = m
+		}
+	}
+	return e
+}
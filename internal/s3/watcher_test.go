@@ -0,0 +1,347 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+const testStableThreshold = 10 * time.Millisecond
+
+// fakeBucket serves the list, head, get, put and delete requests for a single bucket.
+type fakeBucket struct {
+	mu       sync.Mutex
+	objects  map[string]string
+	metadata map[string]http.Header
+	lists    []string
+}
+
+func newFakeBucket() (*fakeBucket, *httptest.Server, *Client) {
+	b := &fakeBucket{objects: make(map[string]string), metadata: make(map[string]http.Header)}
+	srv := httptest.NewServer(b)
+
+	c := NewClient(Config{Endpoint: srv.URL, Region: "us-east-1", Bucket: "videos", AccessKey: "key", SecretKey: "secret"})
+	return b, srv, c
+}
+
+func (b *fakeBucket) put(key, contents string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = contents
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/videos/")
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/videos":
+		startAfter := r.URL.Query().Get("start-after")
+		b.lists = append(b.lists, startAfter)
+
+		var keys []string
+		for k := range b.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > startAfter {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		var result listBucketResult
+		for _, k := range keys {
+			result.Contents = append(result.Contents, struct {
+				Key          string
+				Size         int64
+				ETag         string
+				LastModified time.Time
+			}{Key: k, Size: int64(len(b.objects[k])), ETag: `"` + b.objects[k] + `"`})
+		}
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == "HEAD":
+		contents, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range b.metadata[key] {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+	case r.Method == "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b.objects[key] = string(body)
+		b.metadata[key] = make(http.Header)
+		for name, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), metadataHeaderPrefix) {
+				b.metadata[key][name] = values
+			}
+		}
+	case r.Method == "GET":
+		contents, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(contents))
+	case r.Method == "DELETE":
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bucket, srv, client := newFakeBucket()
+	defer srv.Close()
+	bucket.put("incoming/Movies/foo.mkv", "foo")
+	bucket.put("other/bar.mkv", "bar")
+
+	w, err := NewWatcher(client, "incoming/", tmpDir, testStableThreshold, WithPollInterval(testStableThreshold), WithDelete())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer w.Close()
+
+	wantPath := filepath.Join(tmpDir, "Movies", "foo.mkv")
+	select {
+	case e := <-w.Files():
+		if e.Path != wantPath {
+			t.Fatalf("expected an event for %s, got %s", wantPath, e.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the object to be downloaded")
+	}
+
+	contents, err := ioutil.ReadFile(wantPath)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if string(contents) != "foo" {
+		t.Fatalf("expected the object to be downloaded, got %q", string(contents))
+	}
+
+	// Wait for a poll after the download
+	time.Sleep(5 * testStableThreshold)
+
+	bucket.mu.Lock()
+	defer bucket.mu.Unlock()
+	if _, ok := bucket.objects["incoming/Movies/foo.mkv"]; ok {
+		t.Fatal("expected the object to be deleted after it was downloaded")
+	}
+	if got := bucket.lists[len(bucket.lists)-1]; got != "incoming/Movies/foo.mkv" {
+		t.Fatalf("expected the next list to start after the downloaded object, got %q", got)
+	}
+}
+
+func TestWatcher_Unstable(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bucket, srv, client := newFakeBucket()
+	defer srv.Close()
+
+	// Poll manually, without starting the watcher
+	w := &Watcher{
+		client:          client,
+		localDir:        tmpDir,
+		pending:         make(map[string]pendingObject),
+		downloaded:      make(map[string]string),
+		StableThreshold: time.Hour,
+	}
+
+	now := time.Now()
+	bucket.put("foo.mkv", "f")
+	w.poll(now)
+	bucket.put("foo.mkv", "fo")
+	w.poll(now.Add(2 * time.Hour))
+
+	if _, ok := w.pending["foo.mkv"]; !ok {
+		t.Fatal("expected the changed object to still be pending")
+	}
+	if w.marker != "" {
+		t.Fatalf("expected the changed object to not be handled, got marker %q", w.marker)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "foo.mkv")); !os.IsNotExist(err) {
+		t.Fatalf("expected the changed object to not be downloaded, got %#v", err)
+	}
+}
+
+func TestWatcher_Poll(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bucket, srv, client := newFakeBucket()
+	defer srv.Close()
+
+	// Poll manually, without starting the watcher
+	stateFile := filepath.Join(tmpDir, "downloaded.json")
+	newWatcher := func() *Watcher {
+		w := &Watcher{
+			client:          client,
+			localDir:        filepath.Join(tmpDir, "watch"),
+			ctx:             context.Background(),
+			pending:         make(map[string]pendingObject),
+			downloaded:      make(map[string]string),
+			StableThreshold: time.Minute,
+			ResyncInterval:  time.Hour,
+			StateFile:       stateFile,
+			Events:          make(chan fs.FileEvent, 10),
+		}
+		if err := w.loadState(); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return w
+	}
+	pollTwice := func(w *Watcher, now time.Time) []string {
+		w.poll(now)
+		w.poll(now.Add(2 * time.Minute))
+		var got []string
+		for len(w.Events) > 0 {
+			got = append(got, (<-w.Events).Path)
+		}
+		return got
+	}
+
+	lastList := func() string {
+		bucket.mu.Lock()
+		defer bucket.mu.Unlock()
+		return bucket.lists[len(bucket.lists)-1]
+	}
+
+	w := newWatcher()
+	now := time.Now()
+	bucket.put("m.mkv", "m")
+	if got := pollTwice(w, now); len(got) != 1 {
+		t.Fatalf("expected the object to be downloaded, got %v", got)
+	}
+
+	// Only the objects after the last key that was handled are listed
+	bucket.put("a.mkv", "a")
+	if got := pollTwice(w, now.Add(10*time.Minute)); len(got) != 0 {
+		t.Fatalf("expected an object that sorts before the marker to wait for the resync, got %v", got)
+	}
+	if startAfter := lastList(); startAfter != "m.mkv" {
+		t.Fatalf("expected the objects after m.mkv to be listed, got %q", startAfter)
+	}
+
+	// An object that sorts before the downloaded object is found by the resync
+	got := pollTwice(w, now.Add(2*time.Hour))
+	if len(got) != 1 || got[0] != filepath.Join(tmpDir, "watch", "a.mkv") {
+		t.Fatalf("expected only the new object to be downloaded, got %v", got)
+	}
+
+	// After a restart, the objects after the marker are listed, and only the new object is downloaded
+	bucket.put("z.mkv", "z")
+	w = newWatcher()
+	got = pollTwice(w, now.Add(2*time.Hour+10*time.Minute))
+	if len(got) != 1 || got[0] != filepath.Join(tmpDir, "watch", "z.mkv") {
+		t.Fatalf("expected only the new object to be downloaded after a restart, got %v", got)
+	}
+	if startAfter := lastList(); startAfter != "m.mkv" {
+		t.Fatalf("expected the marker to be kept after a restart, listing after m.mkv, got %q", startAfter)
+	}
+
+	// A changed object is downloaded again by the resync
+	bucket.put("m.mkv", "changed")
+	got = pollTwice(w, now.Add(4*time.Hour))
+	if len(got) != 1 || got[0] != filepath.Join(tmpDir, "watch", "m.mkv") {
+		t.Fatalf("expected only the changed object to be downloaded again, got %v", got)
+	}
+}
+
+func TestWatcher_EncryptedState(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cipher, err := fs.NewCipher("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	stateFile := filepath.Join(tmpDir, "downloaded.json")
+	w := &Watcher{StateFile: stateFile, Cipher: cipher, marker: "Movies/foo.mkv", downloaded: map[string]string{"Movies/foo.mkv": `"foo"`}}
+	w.saveState()
+
+	data, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if strings.Contains(string(data), "foo.mkv") {
+		t.Fatalf("expected the keys of the objects to be encrypted, got %q", data)
+	}
+
+	loaded := &Watcher{StateFile: stateFile, Cipher: cipher, downloaded: make(map[string]string)}
+	err = loaded.loadState()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if loaded.marker != w.marker || loaded.downloaded["Movies/foo.mkv"] != `"foo"` {
+		t.Fatalf("expected the state to be read back, got marker %q and %v", loaded.marker, loaded.downloaded)
+	}
+
+	// The state can't be read without the key
+	err = (&Watcher{StateFile: stateFile, downloaded: make(map[string]string)}).loadState()
+	if err == nil {
+		t.Fatal("expected the encrypted state to not be read without the key")
+	}
+}
+
+func TestWatcher_LocalPath(t *testing.T) {
+	w := &Watcher{prefix: "incoming/", localDir: "/watch"}
+
+	testcases := []struct {
+		Key      string
+		WantPath string
+		WantOK   bool
+	}{
+		{"incoming/Movies/foo.mkv", "/watch/Movies/foo.mkv", true},
+		{"incoming/../../etc/passwd", "", false},
+		{"incoming/", "", false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Key, func(t *testing.T) {
+			path, ok := w.localPath(tc.Key)
+			if ok != tc.WantOK || path != tc.WantPath {
+				t.Fatalf("expected (%q, %v), got (%q, %v)", tc.WantPath, tc.WantOK, path, ok)
+			}
+		})
+	}
+}
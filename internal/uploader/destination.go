@@ -0,0 +1,226 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/s3"
+	"github.com/pkg/errors"
+)
+
+// checksumMetadata is the name of the object metadata with the SHA-256 of a video uploaded to a bucket.
+const checksumMetadata = "sha256"
+
+// Destination is where the transcoded videos are stored once they are uploaded. Each
+// video is identified by its relative path, such as the result of the organize template.
+type Destination interface {
+	// Stat returns the uploaded video, and false when it hasn't been uploaded.
+	Stat(pathSuffix string) (Uploaded, bool, error)
+
+	// Put copies the transcoded video to the destination.
+	Put(transcodedPath, pathSuffix string) error
+
+	// Checksum returns the hex encoded SHA-256 of the uploaded video.
+	Checksum(pathSuffix string) (string, error)
+
+	// WriteChecksum writes the checksum next to the uploaded video, in the format used
+	// by sha256sum, returning where it was written.
+	WriteChecksum(pathSuffix, sum string) (string, error)
+
+	// ReadChecksum returns the checksum written next to the uploaded video, see
+	// WriteChecksum, and false when it doesn't have one.
+	ReadChecksum(pathSuffix string) (string, bool, error)
+
+	// Location of the uploaded video, for logs and hooks.
+	Location(pathSuffix string) string
+}
+
+// Uploaded is a video in a Destination.
+type Uploaded struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// LocalDestination stores the videos in a directory, such as the Plex share.
+type LocalDestination struct {
+	// Dir is the root directory of the videos.
+	Dir string
+
+	// Sandbox optionally refuses to write outside of its allowed directories.
+	Sandbox *fs.Sandbox
+}
+
+// Stat the uploaded video.
+func (d LocalDestination) Stat(pathSuffix string) (Uploaded, bool, error) {
+	path := d.Location(pathSuffix)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return Uploaded{}, false, nil
+	}
+	if err != nil {
+		return Uploaded{}, false, errors.Wrapf(err, "cannot stat %s", path)
+	}
+	return Uploaded{Size: info.Size(), ModTime: info.ModTime()}, true, nil
+}
+
+// Put copies the transcoded video into the directory.
+func (d LocalDestination) Put(transcodedPath, pathSuffix string) error {
+	return d.Sandbox.CopyFile(transcodedPath, d.Location(pathSuffix))
+}
+
+// Checksum reads the whole uploaded video.
+func (d LocalDestination) Checksum(pathSuffix string) (string, error) {
+	return fs.Checksum(d.Location(pathSuffix))
+}
+
+// WriteChecksum writes the checksum to a sidecar file next to the uploaded video.
+func (d LocalDestination) WriteChecksum(pathSuffix, sum string) (string, error) {
+	path := d.Location(pathSuffix)
+	err := d.Sandbox.Check(path + fs.ChecksumExt)
+	if err != nil {
+		return "", err
+	}
+	return fs.WriteChecksumFile(path, sum)
+}
+
+// ReadChecksum reads the checksum from the sidecar file next to the uploaded video.
+func (d LocalDestination) ReadChecksum(pathSuffix string) (string, bool, error) {
+	sidecarPath := d.Location(pathSuffix) + fs.ChecksumExt
+	line, err := ioutil.ReadFile(sidecarPath)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.Wrapf(err, "unable to read the checksum from %s", sidecarPath)
+	}
+	return parseChecksumLine(sidecarPath, string(line))
+}
+
+// Location is the path of the uploaded video.
+func (d LocalDestination) Location(pathSuffix string) string {
+	return filepath.Join(d.Dir, pathSuffix)
+}
+
+// BucketDestination stores the videos in an S3 compatible bucket, keyed by
+// the prefix followed by their relative path, with forward slashes.
+type BucketDestination struct {
+	Client *s3.Client
+
+	// Prefix of the keys, for example videos/
+	Prefix string
+}
+
+func (d BucketDestination) key(pathSuffix string) string {
+	return d.Prefix + filepath.ToSlash(pathSuffix)
+}
+
+// Stat the uploaded object.
+func (d BucketDestination) Stat(pathSuffix string) (Uploaded, bool, error) {
+	o, err := d.Client.Head(d.key(pathSuffix))
+	if s3.IsNotFound(err) {
+		return Uploaded{}, false, nil
+	}
+	if err != nil {
+		return Uploaded{}, false, err
+	}
+	return Uploaded{Size: o.Size, ModTime: o.LastModified}, true, nil
+}
+
+// Put uploads the transcoded video, signed with its checksum so that the object store rejects
+// a corrupted upload. The checksum is kept in the metadata of the object. A video larger than
+// s3.MaxPutSize is rejected before it is read.
+func (d BucketDestination) Put(transcodedPath, pathSuffix string) error {
+	f, err := os.Open(transcodedPath)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open %s", transcodedPath)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "cannot stat %s", transcodedPath)
+	}
+	if info.Size() > s3.MaxPutSize {
+		return errors.Errorf("cannot upload %s to %s, its size %d is larger than the limit of %d for a single upload",
+			transcodedPath, d.Location(pathSuffix), info.Size(), int64(s3.MaxPutSize))
+	}
+
+	sum, err := fs.Checksum(transcodedPath)
+	if err != nil {
+		return err
+	}
+
+	return d.Client.Put(d.key(pathSuffix), f, info.Size(), sum, map[string]string{checksumMetadata: sum})
+}
+
+// Checksum returns the checksum in the metadata of the uploaded object, which was verified
+// by the object store when it was uploaded, instead of downloading the whole video.
+func (d BucketDestination) Checksum(pathSuffix string) (string, error) {
+	key := d.key(pathSuffix)
+	o, err := d.Client.Head(key)
+	if err != nil {
+		return "", err
+	}
+	sum := o.Metadata[checksumMetadata]
+	if sum == "" {
+		return "", errors.Errorf("%s has no %s metadata, it wasn't uploaded by handbrk8s", d.Location(pathSuffix), checksumMetadata)
+	}
+	return sum, nil
+}
+
+// WriteChecksum uploads the checksum to an object next to the uploaded video.
+func (d BucketDestination) WriteChecksum(pathSuffix, sum string) (string, error) {
+	key := d.key(pathSuffix) + fs.ChecksumExt
+	line := fs.ChecksumLine(pathSuffix, sum)
+	lineSum := sha256.Sum256([]byte(line))
+	err := d.Client.Put(key, strings.NewReader(line), int64(len(line)), hex.EncodeToString(lineSum[:]), nil)
+	if err != nil {
+		return "", err
+	}
+	return d.Location(pathSuffix) + fs.ChecksumExt, nil
+}
+
+// ReadChecksum downloads the checksum from the object next to the uploaded video.
+func (d BucketDestination) ReadChecksum(pathSuffix string) (string, bool, error) {
+	key := d.key(pathSuffix) + fs.ChecksumExt
+	body, err := d.Client.Get(key)
+	if s3.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	defer body.Close()
+	line, err := ioutil.ReadAll(io.LimitReader(body, maxChecksumLine))
+	if err != nil {
+		return "", false, errors.Wrapf(err, "unable to read the checksum from %s", d.Location(pathSuffix)+fs.ChecksumExt)
+	}
+	return parseChecksumLine(d.Location(pathSuffix)+fs.ChecksumExt, string(line))
+}
+
+// maxChecksumLine limits how much of a checksum object is read, it only has one line.
+const maxChecksumLine = 4096
+
+// parseChecksumLine returns the checksum from a line in the format used by sha256sum, see fs.ChecksumLine.
+func parseChecksumLine(sidecarPath, line string) (string, bool, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields[0]) != hex.EncodedLen(sha256.Size) {
+		return "", false, errors.Errorf("invalid checksum in %s", sidecarPath)
+	}
+	if _, err := hex.DecodeString(fields[0]); err != nil {
+		return "", false, errors.Errorf("invalid checksum in %s", sidecarPath)
+	}
+	return strings.ToLower(fields[0]), true, nil
+}
+
+// Location is the s3 URL of the uploaded object.
+func (d BucketDestination) Location(pathSuffix string) string {
+	return fmt.Sprintf("s3://%s/%s", d.Client.Bucket, d.key(pathSuffix))
+}
@@ -0,0 +1,105 @@
+package uploader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/s3"
+)
+
+// fakeBucket serves the head and put requests for the objects of a single bucket.
+type fakeBucket struct {
+	mu       sync.Mutex
+	objects  map[string]string
+	metadata map[string]http.Header
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/videos/")
+	switch r.Method {
+	case "HEAD":
+		contents, ok := b.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		for name, values := range b.metadata[key] {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		b.objects[key] = string(body)
+		b.metadata[key] = make(http.Header)
+		for name, values := range r.Header {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				b.metadata[key][name] = values
+			}
+		}
+	}
+}
+
+func TestBucketDestination(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	bucket := &fakeBucket{objects: make(map[string]string), metadata: make(map[string]http.Header)}
+	srv := httptest.NewServer(bucket)
+	defer srv.Close()
+	client := s3.NewClient(s3.Config{Endpoint: srv.URL, Region: "us-east-1", Bucket: "videos", AccessKey: "key", SecretKey: "secret"})
+	dest := BucketDestination{Client: client, Prefix: "plex/"}
+
+	transcodedPath := filepath.Join(tmpDir, "foo.mkv")
+	writeFile(t, transcodedPath, 10)
+	pathSuffix := filepath.Join("Movies", "foo.mkv")
+
+	if _, exists, err := dest.Stat(pathSuffix); err != nil || exists {
+		t.Fatalf("expected the video to not be uploaded yet, got %t %#v", exists, err)
+	}
+
+	err = dest.Put(transcodedPath, pathSuffix)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	uploaded, exists, err := dest.Stat(pathSuffix)
+	if err != nil || !exists || uploaded.Size != 10 {
+		t.Fatalf("expected the video to be uploaded to plex/Movies/foo.mkv, got %v %t %#v", uploaded, exists, err)
+	}
+
+	wantSum, err := fs.Checksum(transcodedPath)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	sum, err := dest.Checksum(pathSuffix)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if sum != wantSum {
+		t.Fatalf("expected the checksum %s from the metadata of the object, got %s", wantSum, sum)
+	}
+
+	location, err := dest.WriteChecksum(pathSuffix, sum)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if location != "s3://videos/plex/Movies/foo.mkv.sha256" {
+		t.Fatalf("unexpected location of the checksum, got %s", location)
+	}
+	if want := sum + "  foo.mkv\n"; bucket.objects["plex/Movies/foo.mkv.sha256"] != want {
+		t.Fatalf("expected the checksum to be uploaded next to the video as %q, got %q", want, bucket.objects["plex/Movies/foo.mkv.sha256"])
+	}
+}
@@ -0,0 +1,503 @@
+package uploader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+// Options for uploading a transcoded video to Plex.
+type Options struct {
+	// Library is the Plex library where the video is uploaded.
+	Library plex.LibraryConfig
+
+	// TranscodedPath is the transcoded video file to upload to Plex.
+	TranscodedPath string
+
+	// PathSuffix is the relative path of the video in the Plex share.
+	PathSuffix string
+
+	// RawPath is the original raw video file to cleanup.
+	RawPath string
+
+	// RefreshDebounce waits before refreshing the Plex library,
+	// skipping the refresh when another upload already refreshed it.
+	RefreshDebounce time.Duration
+
+	// MinScanInterval holds the refresh until the Plex library was last scanned at least
+	// this long ago, coalescing the changes made in the meantime into a single scan. 0 disables it.
+	MinScanInterval time.Duration
+
+	// ArchivePath is where the raw video file is moved after it is uploaded.
+	// When empty, the raw video file is removed.
+	ArchivePath string
+
+	// ArchiveRollback moves the raw video file back out of the archive
+	// when the Plex library can't be refreshed.
+	ArchiveRollback bool
+
+	// VerifyArchive checksums the raw video file before it is moved to the archive, and only
+	// removes the original once the copy in the archive has the same checksum. Hashing reads
+	// the video twice, so it is opt-in.
+	VerifyArchive bool
+
+	// MaxSizeRatio rejects a transcoded video that is larger than this ratio of
+	// the raw video's size, for example 1.0 rejects any video that grew. 0 disables the check.
+	MaxSizeRatio float64
+
+	// FailedPath is where the raw video file is moved for review when the
+	// transcoded video is rejected. When empty, the raw video file is left in place.
+	FailedPath string
+
+	// Checksum logs the SHA-256 of the video once it is on the Plex share, so that
+	// the archive can be verified later. Hashing reads the whole video, so it is opt-in.
+	Checksum bool
+
+	// Sandbox optionally refuses to modify any file outside of its allowed directories.
+	Sandbox *fs.Sandbox
+
+	// ChecksumSidecar also writes the checksum next to the video on the Plex share,
+	// with the fs.ChecksumExt extension. Implies Checksum.
+	ChecksumSidecar bool
+
+	// Destination is where the video is uploaded, at PathSuffix. When nil, the video
+	// is copied to the Library's share.
+	Destination Destination
+
+	// Subtitles also cleans up the external subtitle files next to the raw video, which
+	// were transcoded into the video. They are archived next to ArchivePath, or removed.
+	Subtitles bool
+
+	// KeepRaw leaves the raw video file, and its subtitles, in place once the video is uploaded,
+	// for a video with several outputs, whose raw video file is only cleaned up, or archived,
+	// by the upload of its last output. The raw video file isn't moved for review either.
+	KeepRaw bool
+}
+
+// moveRaw moves a raw video file, or one of its subtitles, verifying the checksum of the copy with VerifyArchive.
+func (opts Options) moveRaw(src, dest string) error {
+	if opts.VerifyArchive {
+		err := opts.Sandbox.VerifiedMoveFile(src, dest)
+		if mismatch, ok := errors.Cause(err).(fs.ChecksumMismatchError); ok {
+			fmt.Printf("ALERT: %s was corrupted while it was copied, leaving the original in place. %s\n", src, mismatch)
+		}
+		return err
+	}
+	return opts.Sandbox.MoveFile(src, dest)
+}
+
+// destination is where the video is uploaded, defaulting to the library share.
+func (opts Options) destination() Destination {
+	if opts.Destination != nil {
+		return opts.Destination
+	}
+	return LocalDestination{Dir: opts.Library.Share, Sandbox: opts.Sandbox}
+}
+
+// OversizedError is returned when the transcoded video is larger than allowed by Options.MaxSizeRatio.
+type OversizedError struct {
+	RawSize, TranscodedSize int64
+}
+
+func (e OversizedError) Error() string {
+	return fmt.Sprintf("the transcoded video is larger than the raw video (%s > %s)",
+		humanize.Bytes(uint64(e.TranscodedSize)), humanize.Bytes(uint64(e.RawSize)))
+}
+
+// BatchFailedError is returned when the video failed to transcode in its batch, which the
+// batch transcode job marks with a .failed file next to the transcoded video.
+type BatchFailedError struct {
+	TranscodedPath string
+}
+
+func (e BatchFailedError) Error() string {
+	return fmt.Sprintf("%s failed to transcode in its batch", e.TranscodedPath)
+}
+
+// Upload a transcoded video to Plex.
+//
+// Gracefully handle restarts between upload steps, continuing to the next step
+// when the previous is already complete. A video that failed to transcode in a batch
+// isn't uploaded, and a BatchFailedError is returned. A transcoded video that is too much
+// larger than the raw video is treated as a failed transcode, and an OversizedError is returned.
+// The raw video file of a failed transcode is moved to the failed path for review.
+// 1. Upload the transcoded video file to the destination, the Plex share by default, and optionally record its checksum.
+// 2. When archiving, verify the upload and move the original raw video file to the archive, optionally verifying its checksum.
+// 3. Refresh the Plex library to include the new video.
+// 4. Remove the transcoded video file.
+// 5. Remove the original raw video file, when not archiving, unless KeepRaw is set.
+func Upload(opts Options) error {
+	transcodedPath := opts.TranscodedPath
+	rawPath := opts.RawPath
+
+	dest := opts.destination()
+	uploadPath := dest.Location(opts.PathSuffix)
+
+	// Batch transcode jobs mark the videos that failed instead of failing the batch
+	failedMarker := transcodedPath + ".failed"
+	if _, err := os.Stat(failedMarker); err == nil {
+		rejectFailed(opts)
+		return BatchFailedError{TranscodedPath: transcodedPath}
+	}
+
+	if opts.MaxSizeRatio > 0 {
+		err := checkSize(rawPath, transcodedPath, opts.MaxSizeRatio)
+		if oversized, ok := err.(OversizedError); ok {
+			rejectFailed(opts)
+			return oversized
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Determine if the file should be uploaded
+	shouldUpload := false
+	uploaded, destExists, destErr := dest.Stat(opts.PathSuffix)
+	if destErr != nil {
+		return destErr
+	}
+	if !destExists {
+		fmt.Printf("the video is not in %s and must be uploaded.\n", uploadPath)
+		shouldUpload = true
+	}
+
+	srcStat, srcErr := os.Stat(transcodedPath)
+	if srcErr != nil {
+		if os.IsNotExist(srcErr) {
+			if shouldUpload {
+				return errors.Wrapf(srcErr, "cannot stat the transcoded video file '%s'", transcodedPath)
+			}
+			fmt.Println("the transcoded video file is gone and was found on the Plex share. Skipping upload.")
+		} else {
+			return errors.Wrapf(srcErr, "cannot stat %s", transcodedPath)
+		}
+	} else if !shouldUpload {
+		srcSize := srcStat.Size()
+		if uploaded.Size != srcSize {
+			shouldUpload = true
+			fmt.Printf("an existing video file was found at %s, and is a different size than the source video file (%s != %s) and must be re-uploaded.\n",
+				uploadPath, humanize.Bytes(uint64(uploaded.Size)), humanize.Bytes(uint64(srcSize)))
+		}
+	}
+
+	shouldRefresh := true
+	if shouldUpload {
+		shouldRefresh = true
+		fmt.Printf("uploading the video to %s...\n", uploadPath)
+		err := dest.Put(transcodedPath, opts.PathSuffix)
+		if err != nil {
+			return err
+		}
+	}
+	changedAt := time.Now()
+
+	if opts.Checksum || opts.ChecksumSidecar {
+		err := recordChecksum(dest, opts.PathSuffix, opts.ChecksumSidecar)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only archive the original raw file once the transcoded video is safely on the Plex share
+	archived := false
+	if opts.ArchivePath != "" && !opts.KeepRaw {
+		err := verifyUpload(dest, transcodedPath, opts.PathSuffix)
+		if err != nil {
+			return err
+		}
+
+		archived, err = archiveFile(opts, rawPath, opts.ArchivePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := refreshLibrary(opts, shouldRefresh, changedAt)
+	if err != nil {
+		if archived && opts.ArchiveRollback {
+			fmt.Printf("restoring %s from the archive\n", rawPath)
+			restoreErr := opts.Sandbox.MoveFile(opts.ArchivePath, rawPath)
+			if restoreErr != nil {
+				fmt.Println(errors.Wrapf(restoreErr, "unable to restore %s from the archive %s", rawPath, opts.ArchivePath))
+			}
+		}
+		return err
+	}
+
+	// Determine if the transcoded file should be removed
+	_, err = os.Stat(transcodedPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return errors.Wrapf(err, "cannot stat %s", transcodedPath)
+		}
+	} else {
+		fmt.Printf("removing %s\n", transcodedPath)
+		err = opts.Sandbox.Remove(transcodedPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The raw file is cleaned up by the upload of the last output
+	if opts.KeepRaw {
+		return nil
+	}
+
+	if opts.Subtitles {
+		err = cleanupSubtitles(opts, rawPath, opts.ArchivePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The raw file was already moved to the archive
+	if opts.ArchivePath != "" {
+		return nil
+	}
+
+	// Determine if the original raw file should be removed
+	_, err = os.Stat(rawPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return errors.Wrapf(err, "cannot stat %s", transcodedPath)
+		}
+	} else {
+		fmt.Printf("removing %s\n", rawPath)
+		err = opts.Sandbox.Remove(rawPath)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSize returns an OversizedError when the transcoded video is larger than maxRatio of the raw video.
+// The check is skipped when either file is gone after a previous attempt.
+func checkSize(rawPath, transcodedPath string, maxRatio float64) error {
+	// A raw video may be a directory, such as a disc structure
+	rawSize, err := fs.Size(rawPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "cannot stat %s", rawPath)
+	}
+
+	transcodedStat, err := os.Stat(transcodedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "cannot stat %s", transcodedPath)
+	}
+
+	if float64(transcodedStat.Size()) > float64(rawSize)*maxRatio {
+		return OversizedError{RawSize: rawSize, TranscodedSize: transcodedStat.Size()}
+	}
+	return nil
+}
+
+// rejectFailed moves the raw video file of a failed transcode to the failed path for review,
+// and removes the transcoded video, when there is one, so that it isn't uploaded by a retry.
+func rejectFailed(opts Options) {
+	if opts.FailedPath == "" || opts.KeepRaw {
+		return
+	}
+
+	fmt.Printf("moving %s to %s for review\n", opts.RawPath, opts.FailedPath)
+	err := opts.Sandbox.MoveFile(opts.RawPath, opts.FailedPath)
+	if err != nil {
+		fmt.Println(errors.Wrapf(err, "unable to move %s to %s", opts.RawPath, opts.FailedPath))
+		return
+	}
+
+	err = opts.Sandbox.Remove(opts.TranscodedPath)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println(errors.Wrapf(err, "unable to remove %s", opts.TranscodedPath))
+	}
+}
+
+// recordChecksum logs the checksum of the uploaded video, and optionally writes it to a sidecar file.
+func recordChecksum(dest Destination, pathSuffix string, sidecar bool) error {
+	fmt.Println("calculating the checksum of the video...")
+	sum, err := dest.Checksum(pathSuffix)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("sha256 %s  %s\n", sum, dest.Location(pathSuffix))
+
+	if !sidecar {
+		return nil
+	}
+	sidecarPath, err := dest.WriteChecksum(pathSuffix, sum)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote the checksum to %s\n", sidecarPath)
+	return nil
+}
+
+// VerifyChecksum checks that the uploaded video still matches the checksum written next to it,
+// see WriteChecksum, catching an upload that was corrupted or replaced since. The video is read
+// again to hash it. A video without a checksum next to it isn't checked, and neither is a video
+// in a bucket, whose checksum is only the one recorded in its metadata when it was uploaded.
+func VerifyChecksum(dest Destination, pathSuffix string) error {
+	if _, ok := dest.(BucketDestination); ok {
+		return nil
+	}
+	want, ok, err := dest.ReadChecksum(pathSuffix)
+	if err != nil || !ok {
+		return err
+	}
+	got, err := dest.Checksum(pathSuffix)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return errors.Errorf("the uploaded video %s doesn't match its checksum, it is %s instead of %s", dest.Location(pathSuffix), got, want)
+	}
+	return nil
+}
+
+// refreshLibrary updates the Plex library, when necessary, and checks that the video is in the library.
+func refreshLibrary(opts Options, shouldRefresh bool, changedAt time.Time) error {
+	plexC := plex.NewClient(opts.Library.ServerConfig)
+	lib, err := plexC.FindLibrary(opts.Library.Name)
+	if err != nil {
+		return err
+	}
+
+	// Determine if the Plex library should be refreshed
+	dirName := parentDir(opts.PathSuffix)
+	filename := filepath.Base(opts.PathSuffix)
+	if !shouldRefresh {
+		fmt.Println("checking for the video in the Plex library...")
+		exists, err := lib.HasVideo(dirName, filename)
+		if err != nil {
+			return err
+		}
+		shouldRefresh = !exists
+	}
+
+	if !shouldRefresh {
+		fmt.Println("the video is already in the Plex library. Skipping update.")
+		return nil
+	}
+
+	fmt.Println("updating the Plex library index...")
+	if opts.RefreshDebounce > 0 || opts.MinScanInterval > 0 {
+		err = lib.UpdateThrottled(changedAt, opts.RefreshDebounce, opts.MinScanInterval)
+	} else {
+		err = lib.Update()
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("checking that the video in now in the Plex library...")
+	exists := false
+	for i := 0; i < 3; i++ {
+		time.Sleep(1 * time.Second)
+		exists, err = lib.HasVideo(dirName, filename)
+		if err != nil {
+			continue
+		}
+		if exists {
+			break
+		}
+	}
+	if !exists {
+		return errors.New("plex was updated but the video is still not in the library")
+	}
+	return nil
+}
+
+// verifyUpload checks that the transcoded video was completely copied to the destination.
+func verifyUpload(dest Destination, transcodedPath, pathSuffix string) error {
+	uploadPath := dest.Location(pathSuffix)
+	uploaded, exists, err := dest.Stat(pathSuffix)
+	if err != nil {
+		return errors.Wrapf(err, "cannot verify the upload of %s", uploadPath)
+	}
+	if !exists {
+		return errors.Errorf("cannot verify the upload, %s doesn't exist", uploadPath)
+	}
+
+	srcStat, err := os.Stat(transcodedPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The transcoded file is only removed after a previous upload was verified
+			return nil
+		}
+		return errors.Wrapf(err, "cannot verify the upload, unable to stat %s", transcodedPath)
+	}
+
+	if uploaded.Size != srcStat.Size() {
+		return errors.Errorf("the uploaded video %s is a different size than the transcoded video %s (%s != %s)",
+			uploadPath, transcodedPath, humanize.Bytes(uint64(uploaded.Size)), humanize.Bytes(uint64(srcStat.Size())))
+	}
+	return nil
+}
+
+// archiveFile moves the file to the archive, returning if the file is now in the archive.
+func archiveFile(opts Options, path, archivePath string) (bool, error) {
+	_, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return false, errors.Wrapf(err, "cannot stat %s", path)
+		}
+
+		// Check if a previous attempt already archived the file
+		_, archiveErr := os.Stat(archivePath)
+		if archiveErr != nil {
+			return false, errors.Wrapf(err, "cannot archive %s", path)
+		}
+		fmt.Printf("%s is already archived. Skipping archive.\n", path)
+		return true, nil
+	}
+
+	fmt.Printf("archiving %s to %s\n", path, archivePath)
+	err = opts.moveRaw(path, archivePath)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to archive %s", path)
+	}
+	return true, nil
+}
+
+// cleanupSubtitles moves the subtitle files of the raw video next to its archive,
+// or removes them when the raw video isn't archived.
+func cleanupSubtitles(opts Options, rawPath, archivePath string) error {
+	subtitles, err := handbrake.SubtitlesOf(rawPath)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+		return err
+	}
+	for _, sub := range subtitles {
+		if archivePath == "" {
+			fmt.Printf("removing %s\n", sub)
+			err = opts.Sandbox.Remove(sub)
+		} else {
+			subArchivePath := filepath.Join(filepath.Dir(archivePath), filepath.Base(sub))
+			fmt.Printf("archiving %s to %s\n", sub, subArchivePath)
+			err = opts.moveRaw(sub, subArchivePath)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "unable to cleanup the subtitle %s", sub)
+		}
+	}
+	return nil
+}
+
+func parentDir(path string) string {
+	return filepath.Base(filepath.Dir(path))
+}
@@ -0,0 +1,147 @@
+package uploader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, size int) {
+	err := ioutil.WriteFile(path, []byte(strings.Repeat("a", size)), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func TestUpload_Oversized(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opts := Options{
+		RawPath:        filepath.Join(tmpDir, "raw", "foo.mkv"),
+		TranscodedPath: filepath.Join(tmpDir, "transcoded", "foo.mkv"),
+		FailedPath:     filepath.Join(tmpDir, "failed", "Movies", "foo.mkv"),
+		PathSuffix:     "Movies/foo.mkv",
+		MaxSizeRatio:   1.0,
+	}
+	opts.Library.Share = filepath.Join(tmpDir, "plex")
+	os.MkdirAll(filepath.Dir(opts.RawPath), 0755)
+	os.MkdirAll(filepath.Dir(opts.TranscodedPath), 0755)
+	writeFile(t, opts.RawPath, 10)
+	writeFile(t, opts.TranscodedPath, 11)
+
+	err = Upload(opts)
+	if _, ok := err.(OversizedError); !ok {
+		t.Fatalf("expected an OversizedError, got %#v", err)
+	}
+
+	if _, err := os.Stat(opts.FailedPath); err != nil {
+		t.Fatalf("expected the raw video to be moved for review, got %#v", err)
+	}
+	if _, err := os.Stat(opts.TranscodedPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the oversized video to be removed, got %#v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.Library.Share, opts.PathSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected the oversized video to not be uploaded, got %#v", err)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rawPath := filepath.Join(tmpDir, "raw.mkv")
+	transcodedPath := filepath.Join(tmpDir, "transcoded.mkv")
+	writeFile(t, rawPath, 100)
+	writeFile(t, transcodedPath, 120)
+
+	testcases := []struct {
+		Name    string
+		Ratio   float64
+		WantErr bool
+	}{
+		{"smaller than the ratio", 1.5, false},
+		{"equal to the ratio", 1.2, false},
+		{"larger than the ratio", 1.0, true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := checkSize(rawPath, transcodedPath, tc.Ratio)
+			if tc.WantErr != (err != nil) {
+				t.Fatalf("expected error %v, got %#v", tc.WantErr, err)
+			}
+		})
+	}
+
+	err = checkSize(filepath.Join(tmpDir, "missing.mkv"), transcodedPath, 1.0)
+	if err != nil {
+		t.Fatalf("expected the check to be skipped when the raw video is gone, got %#v", err)
+	}
+}
+
+func TestCleanupSubtitles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rawPath := filepath.Join(tmpDir, "claimed", "foo.mkv")
+	archivePath := filepath.Join(tmpDir, "archive", "Movies", "foo.mkv")
+	os.MkdirAll(filepath.Dir(rawPath), 0755)
+	os.MkdirAll(filepath.Dir(archivePath), 0755)
+	writeFile(t, filepath.Join(tmpDir, "claimed", "foo.en.srt"), 1)
+	writeFile(t, filepath.Join(tmpDir, "claimed", "foo.ass"), 1)
+
+	err = cleanupSubtitles(Options{}, rawPath, archivePath)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for _, name := range []string{"foo.en.srt", "foo.ass"} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(archivePath), name)); err != nil {
+			t.Fatalf("expected %s to be archived next to the video, got %#v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "claimed", name)); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be moved out of the claim dir, got %#v", name, err)
+		}
+	}
+}
+
+func TestUpload_BatchFailed(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opts := Options{
+		RawPath:        filepath.Join(tmpDir, "raw", "foo.mkv"),
+		TranscodedPath: filepath.Join(tmpDir, "transcoded", "foo.mkv"),
+		FailedPath:     filepath.Join(tmpDir, "failed", "Movies", "foo.mkv"),
+		PathSuffix:     "Movies/foo.mkv",
+	}
+	opts.Library.Share = filepath.Join(tmpDir, "plex")
+	os.MkdirAll(filepath.Dir(opts.RawPath), 0755)
+	os.MkdirAll(filepath.Dir(opts.TranscodedPath), 0755)
+	writeFile(t, opts.RawPath, 10)
+	writeFile(t, opts.TranscodedPath+".failed", 0)
+
+	err = Upload(opts)
+	if _, ok := err.(BatchFailedError); !ok {
+		t.Fatalf("expected a BatchFailedError, got %#v", err)
+	}
+	if _, err := os.Stat(opts.FailedPath); err != nil {
+		t.Fatalf("expected the raw video to be moved for review, got %#v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.Library.Share, opts.PathSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("expected the failed video to not be uploaded, got %#v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package watcher
+
+import (
+	"log"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// BacklogDrained is closed once the videos that were already in the watch directory when
+// the watcher started, along with the videos resumed from the work queue, have been handled
+// by the sinks, and the watcher is only handling new videos. A video of the backlog that is
+// queued while the watcher is paused, or outside of its schedule, must be handled first.
+// When the directory watcher doesn't report its initial scan, such as the bucket watcher,
+// there is no backlog to wait for.
+func (w *VideoWatcher) BacklogDrained() <-chan struct{} {
+	return w.backlogDrained
+}
+
+// initialScanDone returns a channel that is closed once the directory watcher has signaled
+// the files that were already there when it started, or a closed channel when it doesn't
+// report its initial scan.
+func initialScanDone(dirWatcher fs.Watcher) <-chan struct{} {
+	if s, ok := dirWatcher.(fs.InitialScanner); ok {
+		return s.InitialScanDone()
+	}
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// finishInitialScan records that the directory watcher has signaled its backlog, so that
+// only the videos that were already dispatched are waited on.
+func (w *VideoWatcher) finishInitialScan() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.initialScan = false
+	w.checkBacklogLocked()
+}
+
+// addBacklogLocked adds a video to the backlog, while the directory watcher is still
+// signaling the files that were already there. The caller must hold mu.
+func (w *VideoWatcher) addBacklogLocked(path string) {
+	if w.initialScan {
+		w.backlog[path] = true
+	}
+}
+
+// finishBacklog removes a video from the backlog, once the sinks are done with it.
+func (w *VideoWatcher) finishBacklog(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.backlog, path)
+	w.checkBacklogLocked()
+}
+
+// checkBacklogLocked closes BacklogDrained once the initial scan is done, and every video
+// of the backlog was handled. The caller must hold mu.
+func (w *VideoWatcher) checkBacklogLocked() {
+	if w.initialScan || len(w.backlog) > 0 || w.isBacklogDrainedLocked() {
+		return
+	}
+	log.Println("handled the backlog of videos found on startup, now handling new videos")
+	close(w.backlogDrained)
+}
+
+// isBacklogDrainedLocked determines if BacklogDrained is closed. The caller must hold mu.
+func (w *VideoWatcher) isBacklogDrainedLocked() bool {
+	select {
+	case <-w.backlogDrained:
+		return true
+	default:
+		return false
+	}
+}
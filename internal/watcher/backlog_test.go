@@ -0,0 +1,63 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// gatedSink doesn't finish handling a video until the gate is closed.
+type gatedSink struct {
+	started chan string
+	gate    chan struct{}
+}
+
+func (s gatedSink) Handle(ctx context.Context, e fs.FileEvent) error {
+	s.started <- e.Path
+	<-s.gate
+	return nil
+}
+
+func TestVideoWatcher_BacklogDrained(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	createFile(t, video)
+
+	sink := gatedSink{started: make(chan string, 1), gate: make(chan struct{})}
+	w := newTestVideoWatcher(t, tmpDir, sink)
+	defer w.Close()
+
+	select {
+	case path := <-sink.started:
+		if path != video {
+			t.Fatalf("expected the existing video to be handled, got %s", path)
+		}
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the existing video to be handled")
+	}
+	if w.Status().BacklogDrained {
+		t.Fatal("expected the backlog to be draining while the existing video is handled")
+	}
+
+	close(sink.gate)
+	select {
+	case <-w.BacklogDrained():
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the backlog to be drained once the existing video was handled")
+	}
+	if !w.Status().BacklogDrained {
+		t.Fatal("expected the status to report that the backlog was drained")
+	}
+}
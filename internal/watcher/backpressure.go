@@ -0,0 +1,61 @@
+package watcher
+
+import (
+	"log"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// backpressureCheckInterval is how often the load is checked against the backpressure threshold.
+var backpressureCheckInterval = time.Second
+
+// UseBackpressure stops checking new files once maxPending videos are held by the watcher,
+// counting the files that are still changing, the videos that are queued, and the videos
+// that were handed to the sinks and that they haven't finished with yet, such as videos
+// waiting for a local transcode. This bounds the goroutines and memory used during a large
+// import, when the videos are found faster than they can be handled. While throttled, the
+// directory watcher only records the new files, like when the watcher is paused, and checks
+// them once the load drops below maxPending. Requires a directory watcher that is an fs.Pauser.
+func (w *VideoWatcher) UseBackpressure(maxPending int) {
+	go func() {
+		ticker := time.NewTicker(backpressureCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+				w.checkBackpressure(maxPending)
+			}
+		}
+	}()
+}
+
+// checkBackpressure throttles the directory watcher when too many videos are pending,
+// and stops throttling it once they drop below the threshold.
+func (w *VideoWatcher) checkBackpressure(maxPending int) {
+	p, ok := w.dirWatcher.(fs.Pauser)
+	if !ok {
+		return
+	}
+	pending := w.Load().Pending()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	throttled := pending >= maxPending
+	if throttled == w.throttled {
+		return
+	}
+	w.throttled = throttled
+	if throttled {
+		log.Printf("%d videos are pending, not checking new files until fewer than %d are pending\n", pending, maxPending)
+		p.Pause()
+		return
+	}
+	log.Printf("%d videos are pending, checking new files again\n", pending)
+	if !w.paused {
+		p.Resume()
+	}
+}
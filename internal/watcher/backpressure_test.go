@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"sync"
+	"testing"
+)
+
+// pausingWatcher is a directory watcher that reports how many files are stabilizing, and if it is paused.
+type pausingWatcher struct {
+	fakeWatcher
+
+	mu          sync.Mutex
+	paused      bool
+	stabilizing int
+}
+
+func (w *pausingWatcher) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = true
+}
+
+func (w *pausingWatcher) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.paused = false
+}
+
+func (w *pausingWatcher) Stabilizing() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stabilizing
+}
+
+func (w *pausingWatcher) isPaused() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.paused
+}
+
+func (w *pausingWatcher) setStabilizing(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stabilizing = n
+}
+
+func TestVideoWatcher_Backpressure(t *testing.T) {
+	dirWatcher := &pausingWatcher{}
+	w := NewVideoWatcher(dirWatcher, newRecordingSink(nil))
+	defer w.Close()
+
+	dirWatcher.setStabilizing(5)
+	w.checkBackpressure(3)
+	if !dirWatcher.isPaused() || !w.Status().Throttled {
+		t.Fatal("expected new files to not be checked while too many videos are pending")
+	}
+
+	// A pause stays in effect once the load drops
+	w.Pause()
+	dirWatcher.setStabilizing(1)
+	w.checkBackpressure(3)
+	if !dirWatcher.isPaused() {
+		t.Fatal("expected the directory watcher to stay paused until the watcher is resumed")
+	}
+	if w.Status().Throttled {
+		t.Fatal("expected the watcher to no longer be throttled")
+	}
+
+	w.Resume()
+	if dirWatcher.isPaused() {
+		t.Fatal("expected new files to be checked again once resumed")
+	}
+
+	// Resuming while throttled leaves the directory watcher paused
+	dirWatcher.setStabilizing(3)
+	w.checkBackpressure(3)
+	w.Pause()
+	w.Resume()
+	if !dirWatcher.isPaused() {
+		t.Fatal("expected new files to not be checked while still throttled")
+	}
+}
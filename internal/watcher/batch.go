@@ -0,0 +1,220 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// batchVideo is a claimed video waiting to be transcoded in a batch.
+type batchVideo struct {
+	Target                                JobTarget
+	Profile                               JobProfile
+	Path                                  string
+	Metadata                              *ffprobe.Metadata
+	ClaimPath, TranscodedPath, PathSuffix string
+	DestSuffix                            string
+	Preset                                string
+	Timing                                *Timing
+
+	// releaseLock releases the lock of the video once its jobs finish, see keepVideoLock.
+	releaseLock func()
+}
+
+// event is the event of the video, for naming its jobs.
+func (v batchVideo) event() fs.FileEvent {
+	return fs.FileEvent{Path: v.Path, Metadata: v.Metadata}
+}
+
+// videoBatch collects small videos so that they are transcoded by a single job.
+type videoBatch struct {
+	videos []batchVideo
+
+	// full is closed when the batch has reached the maximum size.
+	full chan struct{}
+}
+
+// batchTranscodeJobValues are the set of values to replace in transcode-batch.yaml
+type batchTranscodeJobValues struct {
+	Name          string
+	Namespace     string
+	Videos        []batchVideo
+	PresetFile    string
+	RestartPolicy corev1.RestartPolicy
+	BackoffLimit  int32
+
+	ActiveDeadlineSeconds int64
+	Profile               JobProfile
+	SuccessExitCodes      handbrake.ExitCodes
+}
+
+// batchKey groups the videos for the same job target and profile into a batch.
+type batchKey struct {
+	Target  JobTarget
+	Profile string
+}
+
+// shouldBatch determines if a claimed video is small enough to be transcoded in a batch.
+func (s *JobSink) shouldBatch(claimPath string) bool {
+	if s.BatchMaxFileSize <= 0 {
+		return false
+	}
+
+	info, err := os.Stat(claimPath)
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to stat %s, transcoding it on its own", claimPath))
+		return false
+	}
+	return info.Size() <= s.BatchMaxFileSize
+}
+
+// addToBatch queues a video to be transcoded with other small videos for the same job target and profile.
+// The first video in a batch waits for the batch to fill, or for the batch window
+// to pass, and then creates the jobs for the entire batch.
+func (s *JobSink) addToBatch(ctx context.Context, v batchVideo) error {
+	// The video is handled once it is queued, but stays locked until the jobs of the batch finish
+	v.releaseLock = keepVideoLock(ctx)
+	key := batchKey{Target: v.Target, Profile: v.Profile.Name}
+	s.batchMu.Lock()
+	if s.batches == nil {
+		s.batches = make(map[batchKey]*videoBatch)
+	}
+	b := s.batches[key]
+	owner := b == nil
+	if owner {
+		b = &videoBatch{full: make(chan struct{})}
+		s.batches[key] = b
+	}
+	b.videos = append(b.videos, v)
+	logf(ctx, "queued %s for the next batch (%d videos)\n", v.PathSuffix, len(b.videos))
+	if len(b.videos) >= s.BatchSize {
+		close(b.full)
+		delete(s.batches, key)
+	}
+	s.batchMu.Unlock()
+
+	if !owner {
+		return nil
+	}
+
+	// Always create the jobs, even when shutting down, so that the claimed videos aren't stranded
+	select {
+	case <-b.full:
+	case <-time.After(s.BatchWindow):
+	case <-ctx.Done():
+	}
+
+	s.batchMu.Lock()
+	if s.batches[key] == b {
+		delete(s.batches, key)
+	}
+	videos := b.videos
+	s.batchMu.Unlock()
+
+	return s.createBatchJobs(ctx, v.Target, videos)
+}
+
+// finishBatchVideo releases the space reserved for a video of a batch, and its lock, once its
+// jobs finish or couldn't be created.
+func (s *JobSink) finishBatchVideo(v batchVideo) {
+	s.SpaceCheck.Release(v.Path)
+	if v.releaseLock != nil {
+		v.releaseLock()
+	}
+}
+
+// jobVideos copies the videos of a batch with their paths as the transcode job sees them.
+func (s *JobSink) jobVideos(videos []batchVideo) []batchVideo {
+	rewritten := make([]batchVideo, len(videos))
+	for i, v := range videos {
+		v.ClaimPath = s.PathRewrites.Rewrite(v.ClaimPath)
+		v.TranscodedPath = s.PathRewrites.Rewrite(v.TranscodedPath)
+		rewritten[i] = v
+	}
+	return rewritten
+}
+
+// createBatchJobs creates a job to transcode the batch of videos,
+// and a job to upload each video once the batch is complete.
+func (s *JobSink) createBatchJobs(ctx context.Context, target JobTarget, videos []batchVideo) error {
+	name, err := validNames(s.Names).JobName(videos[0].event(), videos[0].ClaimPath)
+	if err == nil {
+		name = "batch-" + name
+		err = validateJobName(name, videos[0].ClaimPath)
+	}
+	if err != nil {
+		for _, v := range videos {
+			s.cleanupFailedClaim(v.ClaimPath)
+			s.finishBatchVideo(v)
+		}
+		return err
+	}
+
+	logf(ctx, "creating batch transcode job for %d videos\n", len(videos))
+	values := batchTranscodeJobValues{
+		Name:          name,
+		Namespace:     target.Namespace,
+		Videos:        s.jobVideos(videos),
+		PresetFile:    s.PresetFile,
+		RestartPolicy: s.RestartPolicy,
+		BackoffLimit:  s.BackoffLimit,
+
+		ActiveDeadlineSeconds: int64(s.TranscodeDeadline / time.Second),
+		Profile:               videos[0].Profile,
+		SuccessExitCodes:      s.SuccessExitCodes,
+	}
+	transcodeJobName, err := s.createJobFromTemplate(ctx, target, "transcode-batch.yaml", values)
+	if err != nil {
+		for _, v := range videos {
+			s.cleanupFailedClaim(v.ClaimPath)
+			s.finishBatchVideo(v)
+		}
+		return err
+	}
+
+	go s.waitForJobs(ctx, target, videos[0].Path, "", transcodeJobName)
+
+	// Every video in the batch is encoded by the same job
+	var batchSize int64
+	for _, v := range videos {
+		if v.Timing != nil {
+			batchSize += v.Timing.EncodedSize
+		}
+	}
+
+	// A failed upload job only affects its own video
+	var uploadErr error
+	for _, v := range videos {
+		uploadJobName, err := s.createUploadJob(ctx, target, v.event(), transcodeJobName, v.TranscodedPath, v.ClaimPath, v.PathSuffix, v.DestSuffix, libraryName(v.PathSuffix), false)
+		if err != nil {
+			logln(ctx, err)
+			s.cleanupFailedClaim(v.ClaimPath)
+			s.finishBatchVideo(v)
+			uploadErr = errors.Wrapf(err, "unable to create the upload job for %s in batch %s", v.PathSuffix, transcodeJobName)
+			continue
+		}
+		Publish(ctx, PipelineEvent{Type: EventJobCreated, Path: v.Path, Job: transcodeJobName})
+		Publish(ctx, PipelineEvent{Type: EventJobCreated, Path: v.Path, Job: uploadJobName})
+		go func(v batchVideo) {
+			s.waitForJobs(ctx, target, v.Path, "", uploadJobName)
+			s.finishBatchVideo(v)
+		}(v)
+		if s.PostHook.IsSet() {
+			go s.runPostHookAfterUpload(ctx, target, uploadJobName, s.postHookValues(v.Path, libraryName(v.PathSuffix), v.DestSuffix))
+		}
+		if s.Timings && v.Timing != nil {
+			v.Timing.QueuedAt = time.Now()
+			v.Timing.LogFile = s.logFile(target, transcodeJobName)
+			v.Timing.EncodedSize = batchSize
+			go s.trackJobTiming(ctx, target, v.Timing, transcodeJobName, uploadJobName)
+		}
+	}
+	return uploadErr
+}
@@ -0,0 +1,77 @@
+package watcher
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// finishCancelled finishes the video of each job on the target that is cancelled, see
+// jobs.Cancel, until the context is done.
+func (s *JobSink) finishCancelled(ctx context.Context, target JobTarget) {
+	jobChan, errChan := s.jobsClient(target).WatchCancelled(ctx.Done(), target.Namespace)
+	for jobChan != nil || errChan != nil {
+		select {
+		case <-ctx.Done():
+			return
+		case j, ok := <-jobChan:
+			if !ok {
+				jobChan = nil
+				continue
+			}
+			s.finishCancelledJob(ctx, j)
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			logln(ctx, err)
+		}
+	}
+}
+
+// finishCancelledJob moves the claimed video of a cancelled job back to the watch directory,
+// to be processed again, or to the failed directory, as chosen when it was cancelled. The
+// cancellation is then recorded in the timing of the video. When the watch directory is
+// read-only, the claimed copy is removed instead, and the video is processed again once it
+// is rescanned. A video that was already finished, such as by the cancelled upload job of
+// another output, is left alone.
+func (s *JobSink) finishCancelledJob(ctx context.Context, j *batchv1.Job) {
+	path := j.Annotations[jobs.SourceAnnotation]
+	pathSuffix, err := filepath.Rel(s.WatchDir, path)
+	if err != nil || !filepath.IsAbs(path) || pathSuffix == ".." || strings.HasPrefix(pathSuffix, ".."+string(filepath.Separator)) {
+		logf(ctx, "ignoring the cancelled job %s, its video %q isn't in the watch directory %s\n", j.Name, path, s.WatchDir)
+		return
+	}
+	claimPath := filepath.Join(s.ClaimDir, pathSuffix)
+	if isRemoved(claimPath) {
+		return
+	}
+
+	choice := j.Annotations[jobs.CancelAnnotation]
+	if choice == jobs.CancelRequeue {
+		logf(ctx, "%s was cancelled, moving %s back to be processed again\n", j.Name, claimPath)
+		if s.ReadOnlySource {
+			err = s.Sandbox.Remove(claimPath)
+		} else {
+			err = s.Sandbox.MoveFile(claimPath, path)
+		}
+		if err != nil {
+			logln(ctx, errors.Wrapf(err, "unable to requeue the cancelled video %s", path))
+		}
+	} else {
+		logf(ctx, "%s was cancelled, dropping %s\n", j.Name, path)
+		s.cleanupFailedClaim(claimPath)
+	}
+	s.Marker.Remove(path)
+
+	Publish(ctx, PipelineEvent{Type: EventCancelled, Path: path, Job: j.Name})
+	if s.Timings {
+		queuedAt := j.CreationTimestamp.Time
+		emitTiming(ctx, &Timing{Path: path, DetectedAt: queuedAt, StableAt: queuedAt, QueuedAt: queuedAt, Cancelled: true})
+	}
+}
@@ -0,0 +1,108 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+func TestJobSink_finishCancelledJob(t *testing.T) {
+	testcases := []struct {
+		choice   string
+		wantPath func(s *JobSink) string
+	}{
+		{jobs.CancelRequeue, func(s *JobSink) string { return filepath.Join(s.WatchDir, "Movies", "foo.mkv") }},
+		{jobs.CancelDrop, func(s *JobSink) string { return filepath.Join(s.FailedDir, "Movies", "foo.mkv") }},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.choice, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "TestJobSink_finishCancelledJob")
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			s := newTestJobSink(t, tmpDir)
+			s.Timings = true
+			claimPath := filepath.Join(s.ClaimDir, "Movies", "foo.mkv")
+			os.MkdirAll(filepath.Dir(claimPath), 0755)
+			ioutil.WriteFile(claimPath, []byte("foo"), 0644)
+
+			events := &broker{}
+			ctx := context.WithValue(context.Background(), brokerKey{}, events)
+			sub := events.subscribe()
+
+			upload := &batchv1.Job{}
+			upload.Name = "foo-mkv-upload"
+			upload.Annotations = map[string]string{
+				jobs.SourceAnnotation: filepath.Join(s.WatchDir, "Movies", "foo.mkv"),
+				jobs.CancelAnnotation: tc.choice,
+			}
+			s.finishCancelledJob(ctx, upload)
+
+			if _, err := os.Stat(tc.wantPath(s)); err != nil {
+				t.Fatalf("expected the cancelled video to be moved to %s, got %v", tc.wantPath(s), err)
+			}
+			if !isRemoved(claimPath) {
+				t.Fatal("expected the claimed video to be removed")
+			}
+
+			e := <-sub
+			if e.Type != EventCancelled || e.Job != "foo-mkv-upload" {
+				t.Fatalf("expected the video to be reported as cancelled, got %#v", e)
+			}
+			e = <-sub
+			if e.Type != EventTiming {
+				t.Fatalf("expected the timing of the cancelled video, got %#v", e)
+			}
+			if r := e.Timing.Record(); !r.Cancelled || r.Failed {
+				t.Fatalf("expected the cancellation to be recorded instead of a failure, got %#v", r)
+			}
+
+			// The other jobs of the video were cancelled along with it
+			s.finishCancelledJob(ctx, upload)
+			select {
+			case e := <-sub:
+				t.Fatalf("expected a video that was already finished to be left alone, got %#v", e)
+			default:
+			}
+		})
+	}
+}
+
+func TestJobSink_finishCancelledJobOutsideWatchDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	outside := filepath.Join(tmpDir, "foo.mkv")
+	ioutil.WriteFile(outside, []byte("foo"), 0644)
+
+	events := &broker{}
+	ctx := context.WithValue(context.Background(), brokerKey{}, events)
+	sub := events.subscribe()
+
+	upload := &batchv1.Job{}
+	upload.Name = "foo-mkv-upload"
+	upload.Annotations = map[string]string{
+		jobs.SourceAnnotation: s.WatchDir + "/../../../foo.mkv",
+		jobs.CancelAnnotation: jobs.CancelDrop,
+	}
+	s.finishCancelledJob(ctx, upload)
+	if isRemoved(outside) {
+		t.Fatal("expected a video outside of the watch directory to be left alone")
+	}
+	select {
+	case e := <-sub:
+		t.Fatalf("expected a video outside of the watch directory to be ignored, got %#v", e)
+	default:
+	}
+}
@@ -0,0 +1,189 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/uploader"
+	"github.com/pkg/errors"
+)
+
+// RejectUpToDate is a video that was already uploaded to Plex since it was last modified.
+const RejectUpToDate fs.RejectReason = "up-to-date"
+
+// RejectClaimed is a video in a read-only watch directory that was already copied to the
+// claim directory, and is still being processed.
+const RejectClaimed fs.RejectReason = "claimed"
+
+// DirectoryOutputExt is the extension of the video transcoded from a directory,
+// such as a disc structure, which HandBrakeCLI reads as a single title.
+const DirectoryOutputExt = ".mkv"
+
+// outputSuffix is the path of the transcoded video, relative to the transcoded directory,
+// for a video at the path suffix in the watch directory. The extension is replaced with
+// the container, such as .mp4, selected by a preset rule.
+func outputSuffix(pathSuffix string, e fs.FileEvent, container string) string {
+	if container != "" {
+		if !e.IsDir {
+			pathSuffix = strings.TrimSuffix(pathSuffix, filepath.Ext(pathSuffix))
+		}
+		return pathSuffix + container
+	}
+	if e.IsDir {
+		return pathSuffix + DirectoryOutputExt
+	}
+	return pathSuffix
+}
+
+// watchRoot is the watch directory that the video was found under, or the watch directory
+// of the sink when the event doesn't have one.
+func watchRoot(watchDir string, e fs.FileEvent) string {
+	if e.WatchRoot != "" {
+		return e.WatchRoot
+	}
+	return watchDir
+}
+
+// isUpToDate determines if the video was already uploaded to the Plex share, or the output bucket, after
+// it was last modified. A video transcoded for several devices is only up-to-date once it was uploaded
+// to the output of each device. An empty upload is treated as failed, and is not up-to-date, and so is one
+// that doesn't match the checksum written next to it, when verify is set.
+func isUpToDate(watchDir string, outputs LibraryOutputs, share string, organize OrganizeTemplate, bucket OutputBucket, rules PresetRules, devices DeviceProfiles, names NameStrategy, verify bool, e fs.FileEvent) bool {
+	pathSuffix, err := filepath.Rel(watchDir, e.Path)
+	if err != nil {
+		return false
+	}
+	src, err := os.Stat(e.Path)
+	if err != nil {
+		return false
+	}
+	share, organize = outputs.For(libraryName(pathSuffix), share, organize)
+
+	if selected := devices.For(pathSuffix, e.Metadata); len(selected) > 0 {
+		deviceOuts, err := deviceOutputs(selected, pathSuffix, organize, names, e)
+		if err != nil {
+			return false
+		}
+		for _, o := range deviceOuts {
+			if !uploadedSince(bucket.destination(o.Device.Output, nil), o.DestSuffix, src, verify) {
+				return false
+			}
+		}
+		return true
+	}
+
+	outputName, err := validNames(names).OutputName(e, pathSuffix, rules.Container(pathSuffix, e.Metadata))
+	if err != nil {
+		return false
+	}
+	destSuffix, err := organize.Destination(outputName, e.Metadata)
+	if err != nil {
+		return false
+	}
+	return uploadedSince(bucket.destination(share, nil), destSuffix, src, verify)
+}
+
+// uploadedSince determines if the video at the destination path isn't empty, and was uploaded after the
+// source was last modified. When verify is set, it must also still match its checksum, when one was written
+// next to it, which reads the whole video again, see uploader.VerifyChecksum.
+func uploadedSince(dest uploader.Destination, destSuffix string, src os.FileInfo, verify bool) bool {
+	uploaded, exists, err := dest.Stat(destSuffix)
+	if err != nil || !exists {
+		return false
+	}
+	if uploaded.Size == 0 || uploaded.ModTime.Before(src.ModTime()) {
+		return false
+	}
+	if !verify {
+		return true
+	}
+	err = uploader.VerifyChecksum(dest, destSuffix)
+	if err != nil {
+		log.Println(errors.Wrapf(err, "processing %s again", src.Name()))
+		return false
+	}
+	return true
+}
+
+// isHidden determines if a video should be ignored because it is a hidden file.
+func isHidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
+
+// claimVideo moves the video out of the watch directory into the claim directory,
+// preventing attempts to process it a second time. A video that was removed since
+// it was found is rejected with RejectSourceRemoved. When the watch directory is
+// read-only, the video is copied instead, and a video that was already copied is
+// rejected with RejectClaimed.
+func claimVideo(ctx context.Context, sandbox *fs.Sandbox, watchDir, claimDir, path string, readOnly bool) (pathSuffix, claimPath string, err error) {
+	// Preserve the directory nesting of the video relative to the watch directory
+	// Example: /watch/Movies/Foo/bar.mkv -> Movies/Foo/bar.mkv
+	pathSuffix, err = filepath.Rel(watchDir, path)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "unable to determine path suffix of %s, skipping for now", path)
+	}
+
+	claimPath = filepath.Join(claimDir, pathSuffix)
+	logf(ctx, "attempting to claim %s\n", path)
+	if readOnly {
+		if _, err := os.Stat(claimPath); err == nil {
+			return "", "", Reject(RejectClaimed)
+		}
+		err = sandbox.CopyFile(path, claimPath)
+		if _, outside := err.(fs.OutsideSandboxError); err != nil && !outside {
+			// Remove the partial copy, so that the video is claimed again
+			sandbox.Remove(claimPath)
+		}
+	} else {
+		err = sandbox.MoveFile(path, claimPath)
+	}
+	if err != nil && isRemoved(path) {
+		logf(ctx, "%s was removed before it was claimed, skipping\n", path)
+		return "", "", Reject(RejectSourceRemoved)
+	}
+	if err != nil {
+		return "", "", errors.Wrapf(err, "unable to claim %s as %s, skipping for now", path, claimPath)
+	}
+
+	return pathSuffix, claimPath, nil
+}
+
+// claimSubtitles moves the subtitles of a video into the claim directory, next to the
+// claimed video, returning the claimed subtitles. A removed subtitle is skipped.
+func claimSubtitles(ctx context.Context, sandbox *fs.Sandbox, watchDir, claimDir string, subtitles []string, readOnly bool) ([]string, error) {
+	var claimed []string
+	for _, subtitle := range subtitles {
+		_, claimPath, err := claimVideo(ctx, sandbox, watchDir, claimDir, subtitle, readOnly)
+		if rejectErr, ok := err.(RejectError); ok && (rejectErr.Reason == RejectSourceRemoved || rejectErr.Reason == RejectClaimed) {
+			continue
+		}
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, claimPath)
+	}
+	return claimed, nil
+}
+
+// cleanupFailedClaim moves a claimed video, and its claimed subtitles, to the failed directory.
+func cleanupFailedClaim(sandbox *fs.Sandbox, claimDir, failedDir, claimPath string) {
+	paths := []string{claimPath}
+	if subtitles, err := handbrake.SubtitlesOf(claimPath); err == nil {
+		paths = append(paths, subtitles...)
+	}
+
+	log.Printf("cleaning up failed claim: %s\n", claimPath)
+	for _, path := range paths {
+		pathSuffix := strings.Replace(path, claimDir, "", 1)
+		failedPath := filepath.Join(failedDir, pathSuffix)
+		err := sandbox.MoveFile(path, failedPath)
+		if err != nil {
+			log.Println(errors.Wrap(err, "unable to cleanup failed claim"))
+		}
+	}
+}
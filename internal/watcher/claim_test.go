@@ -0,0 +1,210 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+func TestIsUpToDate(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	share := filepath.Join(tmpDir, "plex")
+	now := time.Now()
+
+	testcases := []struct {
+		Name       string
+		Output     string
+		OutputTime time.Time
+		Checksum   string
+		Verify     bool
+		Want       bool
+	}{
+		{Name: "missing output", Want: false},
+		{Name: "newer output", Output: "transcoded", OutputTime: now.Add(time.Hour), Want: true},
+		{Name: "stale output", Output: "transcoded", OutputTime: now.Add(-time.Hour), Want: false},
+		{Name: "empty output", Output: "", OutputTime: now.Add(time.Hour), Want: false},
+		{Name: "verified output", Output: "transcoded", OutputTime: now.Add(time.Hour), Checksum: "transcoded", Verify: true, Want: true},
+		{Name: "corrupted output", Output: "transcoded", OutputTime: now.Add(time.Hour), Checksum: "something else", Verify: true, Want: false},
+		{Name: "unverified output", Output: "transcoded", OutputTime: now.Add(time.Hour), Checksum: "something else", Want: true},
+	}
+
+	for i, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			name := filepath.Join("Movies", strconv.Itoa(i)+".mkv")
+			src := filepath.Join(watchDir, name)
+			writeTestFile(t, src, "raw", now)
+			if !tc.OutputTime.IsZero() {
+				writeTestFile(t, filepath.Join(share, name), tc.Output, tc.OutputTime)
+			}
+			if tc.Checksum != "" {
+				sum := sha256.Sum256([]byte(tc.Checksum))
+				_, err := fs.WriteChecksumFile(filepath.Join(share, name), hex.EncodeToString(sum[:]))
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+			}
+
+			got := isUpToDate(watchDir, nil, share, OrganizeTemplate{}, OutputBucket{}, nil, DeviceProfiles{}, nil, tc.Verify, fs.FileEvent{Path: src})
+			if got != tc.Want {
+				t.Fatalf("expected isUpToDate to be %t, got %t", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestJobSink_Hidden(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	hidden := filepath.Join(s.WatchDir, "Movies", ".foo.mkv")
+	writeTestFile(t, hidden, "raw", time.Now())
+
+	err = s.Handle(context.Background(), fs.FileEvent{Path: hidden})
+	if rejectErr, ok := errors.Cause(err).(RejectError); !ok || rejectErr.Reason != RejectHidden {
+		t.Fatalf("expected the hidden file to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(hidden); err != nil {
+		t.Fatalf("expected the hidden file to be left in the watch directory, %#v", err)
+	}
+	if !isHidden("/watch/Movies/.foo.mkv") || isHidden("/watch/.Movies/foo.mkv") || isHidden("/watch/Movies/foo.mkv") {
+		t.Fatal("expected only the files named with a leading dot to be hidden")
+	}
+}
+
+func TestClaimVideo_OutsideSandbox(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claimed")
+	src := filepath.Join(watchDir, "Movies", "foo.mkv")
+	writeTestFile(t, src, "raw", time.Now())
+
+	sandbox, err := fs.NewSandbox(watchDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	_, _, err = claimVideo(context.Background(), sandbox, watchDir, claimDir, src, false)
+	if err == nil {
+		t.Fatal("expected claiming a video into a directory outside of the sandbox to fail")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("expected the video to be left in the watch directory, %#v", err)
+	}
+}
+
+func TestClaimVideo_SourceRemoved(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claimed")
+	src := filepath.Join(watchDir, "Movies", "foo.mkv")
+
+	_, _, err = claimVideo(context.Background(), nil, watchDir, claimDir, src, false)
+	if rejectErr, ok := errors.Cause(err).(RejectError); !ok || rejectErr.Reason != RejectSourceRemoved {
+		t.Fatalf("expected a removed video to be rejected as %s, got %#v", RejectSourceRemoved, err)
+	}
+}
+
+func TestClaimVideo_ReadOnly(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claimed")
+	src := filepath.Join(watchDir, "Movies", "foo.mkv")
+	writeTestFile(t, src, "raw", time.Now())
+
+	_, claimPath, err := claimVideo(context.Background(), nil, watchDir, claimDir, src, true)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("expected the video to be left in the watch directory, %#v", err)
+	}
+	if b, err := ioutil.ReadFile(claimPath); err != nil || string(b) != "raw" {
+		t.Fatalf("expected the video to be copied to the claim directory, got %q %#v", b, err)
+	}
+
+	_, _, err = claimVideo(context.Background(), nil, watchDir, claimDir, src, true)
+	if rejectErr, ok := errors.Cause(err).(RejectError); !ok || rejectErr.Reason != RejectClaimed {
+		t.Fatalf("expected a video that was already copied to be rejected as %s, got %#v", RejectClaimed, err)
+	}
+}
+
+func TestVideoWatcher_PruneRemoved(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	kept := filepath.Join(tmpDir, "foo.mkv")
+	writeTestFile(t, kept, "raw", time.Now())
+	removed := filepath.Join(tmpDir, "bar.mkv")
+
+	files := make(chan fs.FileEvent)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, newRecordingSink(nil))
+	defer w.Close()
+
+	w.Pause()
+	files <- fs.FileEvent{Path: kept}
+	files <- fs.FileEvent{Path: removed}
+	for i := 0; w.Status().Queued < 2 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	w.pruneRemoved()
+	if queued := w.Status().Queued; queued != 1 {
+		t.Fatalf("expected only the video that still exists to be queued, got %d", queued)
+	}
+	r := <-w.Rejected
+	if r.Path != removed || r.Reason != RejectSourceRemoved {
+		t.Fatalf("expected the removed video to be rejected as %s, got %#v", RejectSourceRemoved, r)
+	}
+}
+
+func writeTestFile(t *testing.T, path string, contents string, modTime time.Time) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = os.Chtimes(path, modTime, modTime)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
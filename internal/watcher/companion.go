@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// RejectCompanionMarker is a video that another tool marked as already processed, see CompanionMarkers.
+const RejectCompanionMarker fs.RejectReason = "companion-marker"
+
+// CompanionMarkerKind is how another tool marks a video that it already processed.
+type CompanionMarkerKind string
+
+const (
+	// CompanionSuffix is a video whose name ends with the marker, e.g. foo.mkv.done
+	CompanionSuffix CompanionMarkerKind = "suffix"
+
+	// CompanionSidecar is a video with a file next to it, named with the marker appended to
+	// the name of the video, e.g. foo.mkv.processed
+	CompanionSidecar CompanionMarkerKind = "sidecar"
+
+	// CompanionXattr is a video with the extended attribute, optionally set to the value.
+	CompanionXattr CompanionMarkerKind = "xattr"
+)
+
+// CompanionMarker is how another tool marks the videos that it already processed.
+type CompanionMarker struct {
+	Kind CompanionMarkerKind
+
+	// Name is the suffix of the video or of its sidecar, or the name of the extended attribute.
+	Name string
+
+	// Value that the extended attribute must have. When empty, any value matches.
+	Value string
+}
+
+// String returns the marker as it is defined as a flag, e.g. xattr:user.processed=yes
+func (m CompanionMarker) String() string {
+	s := string(m.Kind) + ":" + m.Name
+	if m.Value != "" {
+		s += "=" + m.Value
+	}
+	return s
+}
+
+// CompanionMarkers skip the videos that other tools already processed, such as when a
+// library that was already handled is imported again. A video is skipped when it has any
+// of the markers. It may be used as a flag, which may be repeated.
+type CompanionMarkers []CompanionMarker
+
+// xattrUnsupported is logged once, the first time that a file system doesn't support extended attributes.
+var xattrUnsupported sync.Once
+
+// String joins the markers with commas.
+func (m *CompanionMarkers) String() string {
+	if m == nil {
+		return ""
+	}
+	var markers []string
+	for _, marker := range *m {
+		markers = append(markers, marker.String())
+	}
+	return strings.Join(markers, ",")
+}
+
+// Set parses a marker, KIND:NAME, or xattr:NAME=VALUE for an extended attribute with a value.
+func (m *CompanionMarkers) Set(value string) error {
+	i := strings.Index(value, ":")
+	if i == -1 {
+		return errors.Errorf("invalid companion marker %q, must be suffix:SUFFIX, sidecar:SUFFIX, xattr:NAME or xattr:NAME=VALUE", value)
+	}
+	marker := CompanionMarker{Kind: CompanionMarkerKind(value[:i]), Name: value[i+1:]}
+	switch marker.Kind {
+	case CompanionSuffix, CompanionSidecar:
+		if strings.ContainsAny(marker.Name, `/\`) {
+			return errors.Errorf("invalid companion marker %q, the suffix must not contain a path separator", value)
+		}
+	case CompanionXattr:
+		if j := strings.Index(marker.Name, "="); j != -1 {
+			marker.Name, marker.Value = marker.Name[:j], marker.Name[j+1:]
+		}
+	default:
+		return errors.Errorf("invalid companion marker %q, the kind must be %s, %s or %s", value, CompanionSuffix, CompanionSidecar, CompanionXattr)
+	}
+	if marker.Name == "" {
+		return errors.Errorf("invalid companion marker %q, the name is required", value)
+	}
+	*m = append(*m, marker)
+	return nil
+}
+
+// IsMarked determines if another tool marked the video as already processed. When the
+// file system doesn't support extended attributes, the xattr markers never match.
+func (m CompanionMarkers) IsMarked(path string) bool {
+	for _, marker := range m {
+		switch marker.Kind {
+		case CompanionSuffix:
+			if strings.HasSuffix(path, marker.Name) {
+				return true
+			}
+		case CompanionSidecar:
+			if _, err := os.Stat(path + marker.Name); err == nil {
+				return true
+			}
+		case CompanionXattr:
+			value, ok, err := fs.Xattr(path, marker.Name)
+			if err == fs.ErrXattrUnsupported {
+				xattrUnsupported.Do(func() {
+					log.Printf("ignoring the companion marker %s, extended attributes are not supported for %s\n", marker, path)
+				})
+				continue
+			}
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if ok && (marker.Value == "" || value == marker.Value) {
+				return true
+			}
+		}
+	}
+	return false
+}
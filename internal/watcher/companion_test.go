@@ -0,0 +1,83 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompanionMarkers_Set(t *testing.T) {
+	testcases := map[string]struct {
+		value   string
+		want    CompanionMarker
+		wantErr bool
+	}{
+		"suffix":           {value: "suffix:.done", want: CompanionMarker{Kind: CompanionSuffix, Name: ".done"}},
+		"sidecar":          {value: "sidecar:.processed", want: CompanionMarker{Kind: CompanionSidecar, Name: ".processed"}},
+		"xattr":            {value: "xattr:user.processed", want: CompanionMarker{Kind: CompanionXattr, Name: "user.processed"}},
+		"xattr with value": {value: "xattr:user.tool=done", want: CompanionMarker{Kind: CompanionXattr, Name: "user.tool", Value: "done"}},
+		"missing kind":     {value: ".done", wantErr: true},
+		"unknown kind":     {value: "tag:done", wantErr: true},
+		"missing name":     {value: "xattr:=done", wantErr: true},
+		"path separator":   {value: "sidecar:/done", wantErr: true},
+	}
+	for name, tc := range testcases {
+		t.Run(name, func(t *testing.T) {
+			var markers CompanionMarkers
+			err := markers.Set(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected %q to be rejected", tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if len(markers) != 1 || markers[0] != tc.want {
+				t.Fatalf("expected %#v, got %#v", tc.want, markers)
+			}
+			if markers.String() != tc.value {
+				t.Fatalf("expected the marker to be printed as %q, got %q", tc.value, markers.String())
+			}
+		})
+	}
+}
+
+func TestCompanionMarkers_IsMarked(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var markers CompanionMarkers
+	for _, value := range []string{"suffix:.done", "sidecar:.processed", "xattr:user.handbrk8s-test"} {
+		if err := markers.Set(value); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	write := func(name string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := ioutil.WriteFile(path, []byte("video"), 0644); err != nil {
+			t.Fatalf("%#v", err)
+		}
+		return path
+	}
+	foo := write("foo.mkv")
+	bar := write("bar.mkv.done")
+	baz := write("baz.mkv")
+	write("baz.mkv.processed")
+
+	if markers.IsMarked(foo) {
+		t.Fatal("expected a video without a marker to be processed")
+	}
+	if !markers.IsMarked(bar) {
+		t.Fatal("expected a video with the suffix to be skipped")
+	}
+	if !markers.IsMarked(baz) {
+		t.Fatal("expected a video with the sidecar to be skipped")
+	}
+}
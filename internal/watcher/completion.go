@@ -0,0 +1,178 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// DefaultCompletionName is the name of a completion marker, unless it is overridden.
+const DefaultCompletionName = "{{.Name}}.{{.ID}}.json"
+
+// CompletionStatus is how processing a video finished.
+type CompletionStatus string
+
+const (
+	// CompletionUploaded is a video that was transcoded and uploaded.
+	CompletionUploaded CompletionStatus = "uploaded"
+
+	// CompletionFailed is a video that could not be transcoded or uploaded.
+	CompletionFailed CompletionStatus = "failed"
+)
+
+// Completion is the JSON written to a completion marker, when a video is uploaded or fails.
+type Completion struct {
+	ID     string           `json:"id"`
+	Status CompletionStatus `json:"status"`
+	Input  string           `json:"input"`
+	Output string           `json:"output,omitempty"`
+
+	// InputSize is the size of the video when it was detected, and OutputSize is
+	// the size of the uploaded video, when the watcher can read it.
+	InputSize  int64 `json:"inputSize,omitempty"`
+	OutputSize int64 `json:"outputSize,omitempty"`
+
+	Error string    `json:"error,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// CompletionNameValues are available to the template of the name of a completion marker.
+type CompletionNameValues struct {
+	// Name of the video without the extension, e.g. Foo.2019.1080p
+	Name string
+
+	// Ext is the extension of the video, including the dot, e.g. .mkv
+	Ext string
+
+	// ID is the CorrelationID of the video.
+	ID string
+
+	// Status is how processing the video finished.
+	Status CompletionStatus
+}
+
+// CompletionMarkers writes a marker file to a directory when each video is uploaded or
+// fails, so that another tool watching the directory can pick up the result.
+type CompletionMarkers struct {
+	// Dir is where the markers are written.
+	Dir string
+
+	// Sandbox optionally restricts where the markers may be written.
+	Sandbox *fs.Sandbox
+
+	name *template.Template
+}
+
+// NewCompletionMarkers writes the markers to the directory, named with the template of the
+// CompletionNameValues, for example "{{.Name}}.{{.Status}}.json". An empty name uses
+// DefaultCompletionName.
+func NewCompletionMarkers(dir, name string) (*CompletionMarkers, error) {
+	if name == "" {
+		name = DefaultCompletionName
+	}
+	tmpl, err := template.New("completion").Option("missingkey=error").Parse(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid completion marker name %q", name)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create the completion marker directory %s", dir)
+	}
+	return &CompletionMarkers{Dir: dir, name: tmpl}, nil
+}
+
+// UseCompletionMarkers writes a completion marker for each video that is uploaded, or fails.
+func (w *VideoWatcher) UseCompletionMarkers(m *CompletionMarkers) {
+	go m.record(w.Subscribe())
+}
+
+// record writes a marker for each video that is uploaded or fails, with the size of the
+// video when it was detected. The size is forgotten once the video is finished, including
+// when it is skipped or cancelled, without a marker.
+func (m *CompletionMarkers) record(events <-chan PipelineEvent) {
+	sizes := make(map[string]int64)
+	for e := range events {
+		var c Completion
+		switch e.Type {
+		case EventDetected:
+			sizes[e.ID] = e.Size
+			continue
+		case EventSkipped, EventCancelled:
+			delete(sizes, e.ID)
+			continue
+		case EventUploaded:
+			c = Completion{Status: CompletionUploaded, Output: e.Output}
+			if size, err := fs.Size(e.Output); err == nil {
+				c.OutputSize = size
+			}
+		case EventFailed:
+			c = Completion{Status: CompletionFailed}
+			if e.Err != nil {
+				c.Error = e.Err.Error()
+			}
+		default:
+			continue
+		}
+		c.ID, c.Input, c.InputSize, c.At = e.ID, e.Path, sizes[e.ID], e.At
+		delete(sizes, e.ID)
+
+		ctx := context.WithValue(context.Background(), correlationKey{}, e.ID)
+		path, err := m.Write(c)
+		if err != nil {
+			logln(ctx, err)
+			continue
+		}
+		logf(ctx, "wrote the completion marker %s\n", path)
+	}
+}
+
+// Write the completion marker of a video, returning its path. The marker is written to a
+// temporary file first, so that it is never seen partially written.
+func (m *CompletionMarkers) Write(c Completion) (string, error) {
+	ext := filepath.Ext(c.Input)
+	values := CompletionNameValues{
+		Name:   strings.TrimSuffix(filepath.Base(c.Input), ext),
+		Ext:    ext,
+		ID:     c.ID,
+		Status: c.Status,
+	}
+	var name bytes.Buffer
+	err := m.name.Execute(&name, values)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to render the completion marker name for %s", c.Input)
+	}
+	if name.String() == "" || name.String() == "." || name.String() == ".." || strings.ContainsRune(name.String(), filepath.Separator) {
+		return "", errors.Errorf("invalid completion marker name %q for %s", name.String(), c.Input)
+	}
+
+	path := filepath.Join(m.Dir, name.String())
+	if m.Sandbox != nil {
+		if err := m.Sandbox.Check(path); err != nil {
+			return "", err
+		}
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to serialize the completion marker for %s", c.Input)
+	}
+	tmp := filepath.Join(m.Dir, "."+name.String()+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to write the completion marker for %s", c.Input)
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return "", errors.Wrapf(err, "unable to write the completion marker for %s", c.Input)
+	}
+	return path, nil
+}
@@ -0,0 +1,156 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func readCompletion(t *testing.T, path string) Completion {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	var c Completion
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	return c
+}
+
+func TestCompletionMarkers_record(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	output := filepath.Join(tmpDir, "plex", "foo.mkv")
+	os.MkdirAll(filepath.Dir(output), 0755)
+	err = ioutil.WriteFile(output, []byte("transcoded"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	dir := filepath.Join(tmpDir, "completed")
+	m, err := NewCompletionMarkers(dir, "{{.Name}}{{.Ext}}.{{.Status}}.json")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	fooPath, barPath := "/watch/Movies/foo.mkv", "/watch/Movies/bar.mp4"
+	events := make(chan PipelineEvent, 4)
+	events <- PipelineEvent{Type: EventDetected, ID: CorrelationID(fooPath), Path: fooPath, Size: 1024}
+	events <- PipelineEvent{Type: EventUploaded, ID: CorrelationID(fooPath), Path: fooPath, Output: output}
+	events <- PipelineEvent{Type: EventFailed, ID: CorrelationID(barPath), Path: barPath, Err: errors.New("unable to transcode")}
+	close(events)
+	m.record(events)
+
+	uploaded := readCompletion(t, filepath.Join(dir, "foo.mkv.uploaded.json"))
+	want := Completion{ID: CorrelationID(fooPath), Status: CompletionUploaded, Input: fooPath, Output: output, InputSize: 1024, OutputSize: 10}
+	if uploaded != want {
+		t.Fatalf("expected the completion of foo to be %#v, got %#v", want, uploaded)
+	}
+
+	failed := readCompletion(t, filepath.Join(dir, "bar.mp4.failed.json"))
+	if failed.Status != CompletionFailed || failed.Input != barPath || failed.Error != "unable to transcode" {
+		t.Fatalf("expected the failure of bar to be recorded, got %#v", failed)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected only the markers to be left in %s, got %d files", dir, len(files))
+	}
+}
+
+func TestCompletionMarkers_recordSkipped(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	m, err := NewCompletionMarkers(tmpDir, "{{.Name}}{{.Ext}}.{{.Status}}.json")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// The size of a skipped or cancelled video is forgotten, instead of being kept until it fails or is uploaded
+	fooPath, barPath := "/watch/Movies/foo.mkv", "/watch/Movies/bar.mkv"
+	events := make(chan PipelineEvent, 6)
+	events <- PipelineEvent{Type: EventDetected, ID: CorrelationID(fooPath), Path: fooPath, Size: 1024}
+	events <- PipelineEvent{Type: EventSkipped, ID: CorrelationID(fooPath), Path: fooPath}
+	events <- PipelineEvent{Type: EventDetected, ID: CorrelationID(barPath), Path: barPath, Size: 2048}
+	events <- PipelineEvent{Type: EventCancelled, ID: CorrelationID(barPath), Path: barPath}
+	events <- PipelineEvent{Type: EventFailed, ID: CorrelationID(fooPath), Path: fooPath}
+	events <- PipelineEvent{Type: EventFailed, ID: CorrelationID(barPath), Path: barPath}
+	close(events)
+	m.record(events)
+
+	for _, name := range []string{"foo.mkv.failed.json", "bar.mkv.failed.json"} {
+		if c := readCompletion(t, filepath.Join(tmpDir, name)); c.InputSize != 0 {
+			t.Fatalf("expected the size of the finished video to be forgotten, got %#v", c)
+		}
+	}
+}
+
+func TestCompletionMarkers_InvalidName(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, err = NewCompletionMarkers(tmpDir, "{{.Name")
+	if err == nil {
+		t.Fatal("expected an invalid template to be rejected")
+	}
+
+	m, err := NewCompletionMarkers(tmpDir, "../{{.Name}}.json")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_, err = m.Write(Completion{Input: "/watch/Movies/foo.mkv"})
+	if err == nil {
+		t.Fatal("expected a marker outside of the directory to be rejected")
+	}
+}
+
+func TestPublishJobResult(t *testing.T) {
+	events := &broker{}
+	ctx := context.WithValue(context.Background(), brokerKey{}, events)
+	sub := events.subscribe()
+
+	upload := &batchv1.Job{}
+	upload.Name = "foo-mkv-upload"
+	upload.Annotations = map[string]string{jobs.SourceAnnotation: "/watch/Movies/foo.mkv", jobs.OutputAnnotation: "/plex/Movies/foo.mkv"}
+	publishJobResult(ctx, "/watch/Movies/foo.mkv", completeJob(upload))
+
+	e := <-sub
+	if e.Type != EventUploaded || e.Output != "/plex/Movies/foo.mkv" {
+		t.Fatalf("expected the completed upload job to be reported as uploaded, got %#v", e)
+	}
+
+	transcode := &batchv1.Job{}
+	transcode.Name = "foo-mkv-transcode"
+	publishJobResult(ctx, "/watch/Movies/foo.mkv", transcode)
+	transcode.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue}}
+	publishJobResult(ctx, "/watch/Movies/foo.mkv", transcode)
+
+	e = <-sub
+	if e.Type != EventFailed || e.Job != "foo-mkv-transcode" || e.Err == nil {
+		t.Fatalf("expected only the failed transcode job to be reported as failed, got %#v", e)
+	}
+}
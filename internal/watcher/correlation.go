@@ -0,0 +1,50 @@
+package watcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+)
+
+// CorrelationID identifies a video in the logs, events and notifications from when it is
+// detected until it is uploaded or fails, so that one video can be followed through a busy
+// log with grep. It is derived from the path where the video was found, so that it is the
+// same for every step, even after the video is claimed or the watcher restarts.
+func CorrelationID(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:4])
+}
+
+type correlationKey struct{}
+
+// withCorrelationID tags the log lines, events and notifications of the context with the
+// correlation id of the video found at the path.
+func withCorrelationID(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, CorrelationID(path))
+}
+
+// correlationID of the video being handled with the context, or empty when there isn't one.
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// tag prefixes the message with the correlation id of the video being handled, when there is one.
+func tag(ctx context.Context, message string) string {
+	if id := correlationID(ctx); id != "" {
+		return "[" + id + "] " + message
+	}
+	return message
+}
+
+// logf logs the message, tagged with the correlation id of the video being handled.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	log.Print(tag(ctx, fmt.Sprintf(format, args...)))
+}
+
+// logln logs the values, tagged with the correlation id of the video being handled.
+func logln(ctx context.Context, args ...interface{}) {
+	log.Print(tag(ctx, fmt.Sprintln(args...)))
+}
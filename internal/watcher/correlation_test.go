@@ -0,0 +1,60 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCorrelationID(t *testing.T) {
+	id := CorrelationID("/watch/Movies/foo.mkv")
+	if len(id) != 8 {
+		t.Fatalf("expected a short id, got %q", id)
+	}
+	if CorrelationID("/watch/Movies/foo.mkv") != id {
+		t.Fatal("expected the id to be the same for the same path")
+	}
+	if CorrelationID("/watch/Movies/bar.mkv") == id {
+		t.Fatal("expected a different id for a different path")
+	}
+}
+
+func TestCorrelationID_Logs(t *testing.T) {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := withCorrelationID(context.Background(), "/watch/Movies/foo.mkv")
+	logf(ctx, "transcoding %s\n", "foo.mkv")
+	logf(context.Background(), "nothing to tag\n")
+
+	out := b.String()
+	want := "[" + CorrelationID("/watch/Movies/foo.mkv") + "] transcoding foo.mkv"
+	if !strings.Contains(out, want) {
+		t.Fatalf("expected the log line to be tagged with the correlation id, got %q", out)
+	}
+	if strings.Contains(out, "] nothing to tag") {
+		t.Fatalf("expected the log line without a video to be left alone, got %q", out)
+	}
+}
+
+func TestCorrelationID_Events(t *testing.T) {
+	w := NewVideoWatcher(&fakeWatcher{})
+	defer w.Close()
+	events := w.Subscribe()
+
+	// The sinks publish events for the video that they are handling, under any path
+	ctx := withCorrelationID(w.ctx, "/watch/Movies/foo.mkv")
+	Publish(ctx, PipelineEvent{Type: EventTranscoded, Path: "/work/claim/Movies/foo.mkv"})
+	if e := <-events; e.ID != CorrelationID("/watch/Movies/foo.mkv") {
+		t.Fatalf("expected the event to have the correlation id of the video, got %q", e.ID)
+	}
+
+	w.events.publish(PipelineEvent{Type: EventDetected, Path: "/watch/Movies/foo.mkv"})
+	if e := <-events; e.ID != CorrelationID("/watch/Movies/foo.mkv") {
+		t.Fatalf("expected the event to have the correlation id of its path, got %q", e.ID)
+	}
+}
@@ -0,0 +1,201 @@
+package watcher
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// ReviewExt flags a video in the DeletionQueue for review, so that it isn't deleted. Create
+// a file with the path of the video and the extension, e.g. Movies/foo.mkv.review, or with
+// the path of one of its directories to flag every video in it.
+const ReviewExt = ".review"
+
+// deletionStateFile keeps when each video was queued, in the directory of the DeletionQueue.
+const deletionStateFile = ".handbrk8s-deletions.json"
+
+// deletionSweepInterval is how often the DeletionQueue removes the videos whose grace period elapsed.
+const deletionSweepInterval = time.Minute
+
+// DeletionQueue keeps the original videos for a grace period once they are uploaded, instead
+// of removing them right away, so that a bad transcode can be caught while the original is
+// still around. The videos are moved to the directory by the upload, instead of being removed,
+// and are removed once they have been in the queue for the grace period, unless they are
+// flagged for review with ReviewExt. When each video was queued is kept in the directory, so
+// that the grace period continues after a restart.
+type DeletionQueue struct {
+	// Dir is where the videos wait to be removed, at their path in the watch directory.
+	Dir string
+
+	// Grace is how long a video is kept in the queue.
+	Grace time.Duration
+
+	// Sandbox optionally refuses to remove any file outside of its allowed directories.
+	Sandbox *fs.Sandbox
+
+	// Cipher optionally encrypts when the videos were queued, so that the paths are kept private.
+	Cipher *fs.Cipher
+
+	mu     sync.Mutex
+	queued map[string]time.Time
+}
+
+// NewDeletionQueue creates the directory of the queue, and loads when its videos were queued,
+// decrypting it with the cipher.
+func NewDeletionQueue(dir string, grace time.Duration, cipher *fs.Cipher) (*DeletionQueue, error) {
+	if grace <= 0 {
+		return nil, errors.Errorf("invalid deletion grace period %s, must be positive", grace)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create the deletion queue %s", dir)
+	}
+
+	q := &DeletionQueue{Dir: dir, Grace: grace, Cipher: cipher, queued: make(map[string]time.Time)}
+	data, err := cipher.ReadFile(filepath.Join(dir, deletionStateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "unable to read the deletion queue %s", dir)
+	}
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &q.queued)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read the deletion queue %s", dir)
+		}
+	}
+	return q, nil
+}
+
+// Path is where the upload moves the video, instead of removing it.
+func (q *DeletionQueue) Path(pathSuffix string) string {
+	return filepath.Join(q.Dir, pathSuffix)
+}
+
+// UseDeletionQueue removes the videos whose grace period elapsed from the queue, every minute,
+// until the watcher is closed.
+func (w *VideoWatcher) UseDeletionQueue(q *DeletionQueue) {
+	go func() {
+		ticker := time.NewTicker(deletionSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			q.Sweep(time.Now())
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Sweep records when the new videos in the queue were queued, and removes the videos that
+// were queued more than the grace period ago, unless they are flagged for review. Returns
+// the paths of the removed videos.
+func (q *DeletionQueue) Sweep(now time.Time) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var files, flagged []string
+	filepath.Walk(q.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(q.Dir, path)
+		if err != nil || rel == deletionStateFile || strings.HasPrefix(filepath.Base(rel), "."+deletionStateFile) {
+			return nil
+		}
+		if strings.HasSuffix(rel, ReviewExt) {
+			flagged = append(flagged, strings.TrimSuffix(rel, ReviewExt))
+			return nil
+		}
+		files = append(files, rel)
+		return nil
+	})
+
+	present := make(map[string]bool, len(files))
+	var removed []string
+	for _, rel := range files {
+		present[rel] = true
+		queuedAt, ok := q.queued[rel]
+		if !ok {
+			q.queued[rel] = now
+			continue
+		}
+		if now.Sub(queuedAt) < q.Grace || isFlagged(rel, flagged) {
+			continue
+		}
+
+		path := filepath.Join(q.Dir, rel)
+		log.Printf("removing %s, its deletion grace period of %s elapsed\n", path, q.Grace)
+		err := q.Sandbox.Check(path)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			log.Println(errors.Wrapf(err, "unable to remove %s from the deletion queue", path))
+			continue
+		}
+		delete(present, rel)
+		removed = append(removed, path)
+		q.removeEmptyDirs(filepath.Dir(path))
+	}
+
+	// Forget the videos that were restored, or removed by someone else
+	for rel := range q.queued {
+		if !present[rel] {
+			delete(q.queued, rel)
+		}
+	}
+	q.save()
+
+	sort.Strings(removed)
+	return removed
+}
+
+// isFlagged determines if the video, or one of its directories, is flagged for review.
+func isFlagged(rel string, flagged []string) bool {
+	for _, f := range flagged {
+		if rel == f || strings.HasPrefix(rel, f+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEmptyDirs removes the directory, and its parents, once they are empty, up to the Dir.
+func (q *DeletionQueue) removeEmptyDirs(dir string) {
+	for dir != q.Dir && strings.HasPrefix(dir, q.Dir+string(os.PathSeparator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// save when the videos were queued, writing a temporary file first so that it is never
+// seen partially written. Errors are logged, and it is saved again by the next sweep.
+func (q *DeletionQueue) save() {
+	data, err := json.Marshal(q.queued)
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to serialize the deletion queue %s", q.Dir))
+		return
+	}
+	path := filepath.Join(q.Dir, deletionStateFile)
+	tmp := filepath.Join(q.Dir, "."+deletionStateFile+".tmp")
+	err = q.Cipher.WriteFile(tmp, data, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Println(errors.Wrapf(err, "unable to save the deletion queue %s", q.Dir))
+	}
+}
@@ -0,0 +1,124 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestDeletionQueue_Sweep(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	q, err := NewDeletionQueue(tmpDir, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	write := func(pathSuffix string) string {
+		path := q.Path(pathSuffix)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(pathSuffix), 0644); err != nil {
+			t.Fatalf("%#v", err)
+		}
+		return path
+	}
+	foo := write("Movies/foo.mkv")
+	bar := write("Movies/bar.mkv")
+	write("Movies/bar.mkv" + ReviewExt)
+	baz := write("TV/Baz/VIDEO_TS/VTS_01_1.VOB")
+	write("TV/Baz" + ReviewExt)
+
+	queuedAt := time.Now()
+	if removed := q.Sweep(queuedAt); len(removed) != 0 {
+		t.Fatalf("expected the new videos to be kept, got %v", removed)
+	}
+	if removed := q.Sweep(queuedAt.Add(time.Hour)); len(removed) != 0 {
+		t.Fatalf("expected the videos to be kept during the grace period, got %v", removed)
+	}
+
+	// The grace period continues after a restart
+	q, err = NewDeletionQueue(tmpDir, 24*time.Hour, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	removed := q.Sweep(queuedAt.Add(25 * time.Hour))
+	if len(removed) != 1 || removed[0] != foo {
+		t.Fatalf("expected only %s to be removed once its grace period elapsed, got %v", foo, removed)
+	}
+	if _, err := os.Stat(foo); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", foo)
+	}
+	for _, path := range []string{bar, baz} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept for review: %s", path, err)
+		}
+	}
+
+	// Once the review flag is removed, the video is removed
+	os.Remove(q.Path("TV/Baz" + ReviewExt))
+	removed = q.Sweep(queuedAt.Add(26 * time.Hour))
+	if len(removed) != 1 || removed[0] != baz {
+		t.Fatalf("expected %s to be removed once it isn't flagged, got %v", baz, removed)
+	}
+	if _, err := os.Stat(q.Path("TV")); !os.IsNotExist(err) {
+		t.Fatal("expected the empty directories to be removed")
+	}
+}
+
+func TestDeletionQueue_Encrypted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cipher, err := fs.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	q, err := NewDeletionQueue(tmpDir, 24*time.Hour, cipher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	path := q.Path("Movies/foo.mkv")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := ioutil.WriteFile(path, []byte("raw"), 0644); err != nil {
+		t.Fatalf("%#v", err)
+	}
+	queuedAt := time.Now()
+	q.Sweep(queuedAt)
+
+	raw, err := ioutil.ReadFile(filepath.Join(tmpDir, deletionStateFile))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if strings.Contains(string(raw), "foo.mkv") {
+		t.Fatalf("expected the deletion queue to be encrypted, got %q", raw)
+	}
+
+	q, err = NewDeletionQueue(tmpDir, 24*time.Hour, cipher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if removed := q.Sweep(queuedAt.Add(25 * time.Hour)); len(removed) != 1 || removed[0] != path {
+		t.Fatalf("expected the grace period to continue after a restart, got %v", removed)
+	}
+
+	// An unencrypted deletion queue isn't trusted
+	err = ioutil.WriteFile(filepath.Join(tmpDir, deletionStateFile), []byte(`{"Movies/bar.mkv":"2000-01-01T00:00:00Z"}`), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if _, err = NewDeletionQueue(tmpDir, 24*time.Hour, cipher); err == nil {
+		t.Fatal("expected an unencrypted deletion queue to be refused")
+	}
+}
@@ -0,0 +1,424 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/uploader"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// DeviceProfile is a named output of a video, such as a high bitrate encode for the
+// living-room TV, or a small encode for phones, that is uploaded to its own directory.
+type DeviceProfile struct {
+	// Name of the device, used to select it from a rule.
+	Name string `json:"name"`
+
+	// Preset used to transcode the video for the device.
+	Preset string `json:"preset"`
+
+	// Container is the extension of the transcoded video, for example mp4. When empty,
+	// the transcoded video keeps the extension of the original video.
+	Container string `json:"container,omitempty"`
+
+	// Output is the root directory of the device's videos, such as the share of a
+	// Plex library for phones. The videos are organized the same as in the Plex share.
+	Output string `json:"output"`
+
+	// Library is the Plex library refreshed once a video is uploaded to the Output.
+	// Defaults to the library of the video in the watch directory.
+	Library string `json:"library,omitempty"`
+}
+
+// DeviceRule selects the devices that a video is transcoded for.
+type DeviceRule struct {
+	// Match is the conditions on the video, as in a preset rule, for example
+	// "path=Movies/*,height>=2160". An empty Match selects every video.
+	Match string `json:"match,omitempty"`
+
+	// Devices are the names of the device profiles that the video is transcoded for.
+	Devices []string `json:"devices"`
+
+	conditions []PresetCondition
+}
+
+// DeviceProfiles fan out a video to the outputs of several devices, selected by the first
+// rule that matches the video. A video that doesn't match any rule is transcoded once, as usual.
+type DeviceProfiles struct {
+	Devices []DeviceProfile `json:"devices"`
+	Rules   []DeviceRule    `json:"rules"`
+}
+
+// LoadDeviceProfiles reads a YAML file with the devices, and the rules that select them, for example:
+//
+//	devices:
+//	- name: tv
+//	  preset: H.265 MKV 2160p60
+//	  output: /plex/tv
+//	- name: phone
+//	  preset: Fast 480p30
+//	  container: mp4
+//	  output: /plex/mobile
+//	  library: Mobile
+//	rules:
+//	- match: path=Movies/*
+//	  devices: [tv, phone]
+func LoadDeviceProfiles(path string) (DeviceProfiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return DeviceProfiles{}, errors.Wrapf(err, "unable to read device profiles file %s", path)
+	}
+
+	var d DeviceProfiles
+	err = yaml.Unmarshal(b, &d)
+	if err != nil {
+		return DeviceProfiles{}, errors.Wrapf(err, "unable to parse device profiles file %s", path)
+	}
+
+	err = d.validate()
+	if err != nil {
+		return DeviceProfiles{}, errors.Wrapf(err, "invalid device profiles file %s", path)
+	}
+	return d, nil
+}
+
+// validate checks that the devices have unique names, presets, outputs and valid containers,
+// and that the rules have valid conditions and only select defined devices.
+func (d *DeviceProfiles) validate() error {
+	names := make(map[string]bool)
+	for i := range d.Devices {
+		device := &d.Devices[i]
+		if device.Name == "" {
+			return errors.New("a device is missing its name")
+		}
+		if names[device.Name] {
+			return errors.Errorf("device %s is defined more than once", device.Name)
+		}
+		names[device.Name] = true
+
+		if device.Preset == "" {
+			return errors.Errorf("device %s is missing its preset", device.Name)
+		}
+		if device.Output == "" {
+			return errors.Errorf("device %s is missing its output", device.Name)
+		}
+		device.Output = filepath.Clean(device.Output)
+		if device.Container != "" {
+			device.Container = "." + strings.ToLower(strings.TrimPrefix(device.Container, "."))
+			if !handbrake.IsContainer(device.Container) {
+				return errors.Errorf("invalid container %s in device %s, must be one of %s", device.Container, device.Name, strings.Join(handbrake.Containers(), ", "))
+			}
+		}
+	}
+
+	for i := range d.Rules {
+		rule := &d.Rules[i]
+		if len(rule.Devices) == 0 {
+			return errors.Errorf("the rule %q doesn't select any devices", rule.Match)
+		}
+		for _, name := range rule.Devices {
+			if !names[name] {
+				return errors.Errorf("the rule %q selects the device %s, which is not defined", rule.Match, name)
+			}
+		}
+		for _, entry := range strings.Split(rule.Match, ",") {
+			entry = strings.TrimSpace(entry)
+			if entry == "" {
+				continue
+			}
+			c, err := parsePresetCondition(entry)
+			if err != nil {
+				return errors.Wrapf(err, "invalid rule %q", rule.Match)
+			}
+			rule.conditions = append(rule.conditions, c)
+		}
+	}
+	return nil
+}
+
+// For returns the devices selected by the first rule that matches the video, or none
+// when the video is only transcoded once. Rules with metadata conditions never match a
+// video without metadata.
+func (d DeviceProfiles) For(pathSuffix string, m *ffprobe.Metadata) []DeviceProfile {
+	for _, rule := range d.Rules {
+		if !(PresetRule{Conditions: rule.conditions}).matches(pathSuffix, m) {
+			continue
+		}
+		devices := make([]DeviceProfile, 0, len(rule.Devices))
+		for _, name := range rule.Devices {
+			for _, device := range d.Devices {
+				if device.Name == name {
+					devices = append(devices, device)
+				}
+			}
+		}
+		return devices
+	}
+	return nil
+}
+
+// Presets lists the presets used by the devices.
+func (d DeviceProfiles) Presets() []string {
+	presets := make([]string, len(d.Devices))
+	for i, device := range d.Devices {
+		presets[i] = device.Preset
+	}
+	return presets
+}
+
+// Dirs lists the outputs of the devices.
+func (d DeviceProfiles) Dirs() []string {
+	dirs := make([]string, len(d.Devices))
+	for i, device := range d.Devices {
+		dirs[i] = device.Output
+	}
+	return dirs
+}
+
+// deviceOutput is where a video is transcoded, and uploaded, for one of its devices.
+type deviceOutput struct {
+	Device DeviceProfile
+
+	// TranscodedSuffix is the path of the transcoded video, relative to the transcoded
+	// directory, in a directory named after the device.
+	TranscodedSuffix string
+
+	// DestSuffix is the path of the video in the output of the device.
+	DestSuffix string
+
+	// Library is the Plex library refreshed once the video is uploaded.
+	Library string
+}
+
+// deviceOutputs are the outputs of the video for each of its devices, organized as in the Plex share.
+func deviceOutputs(devices []DeviceProfile, pathSuffix string, organize OrganizeTemplate, names NameStrategy, e fs.FileEvent) ([]deviceOutput, error) {
+	outputs := make([]deviceOutput, len(devices))
+	for i, device := range devices {
+		suffix, err := validNames(names).OutputName(e, pathSuffix, device.Container)
+		if err != nil {
+			return nil, err
+		}
+		destSuffix, err := organize.Destination(suffix, e.Metadata)
+		if err != nil {
+			return nil, err
+		}
+
+		library := device.Library
+		if library == "" {
+			library = libraryName(pathSuffix)
+		}
+		outputs[i] = deviceOutput{
+			Device:           device,
+			TranscodedSuffix: filepath.Join(device.Name, suffix),
+			DestSuffix:       destSuffix,
+			Library:          library,
+		}
+	}
+	return outputs, nil
+}
+
+// devicePresets is the preset of the timing of a video transcoded for several devices, such
+// as "H.265 MKV 2160p60+Fast 480p30", so that each combination of devices is estimated separately.
+func devicePresets(outputs []deviceOutput) string {
+	presets := make([]string, len(outputs))
+	for i, o := range outputs {
+		presets[i] = o.Device.Preset
+	}
+	return strings.Join(presets, "+")
+}
+
+// handleDevices transcodes the claimed video for each of its devices, one at a time, and uploads
+// it to the output of each device. The claimed video is only cleaned up by the upload for the
+// last device, once the video was uploaded for every other device.
+func (s *LocalSink) handleDevices(ctx context.Context, e fs.FileEvent, devices []DeviceProfile, claimPath, pathSuffix string, subtitles []string, timing *Timing) error {
+	_, organize := s.Outputs.For(libraryName(pathSuffix), s.PlexCfg.Share, s.Organize)
+	outputs, err := deviceOutputs(devices, pathSuffix, organize, s.Names, e)
+	if err != nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+		return err
+	}
+
+	firstPath := filepath.Join(s.TranscodedDir, outputs[0].TranscodedSuffix)
+	err = s.PreHook.Run(ctx, HookValues{Input: claimPath, Output: firstPath})
+	if err != nil {
+		s.cleanup(claimPath, firstPath)
+		return err
+	}
+
+	size := e.Size
+	if size == 0 {
+		size, _ = fs.Size(claimPath)
+	}
+	timing.Preset, timing.EncodedSize = devicePresets(outputs), size
+	for i, o := range outputs {
+		err = s.handleDevice(ctx, e, o, claimPath, pathSuffix, subtitles, size, timing, i == len(outputs)-1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleDevice transcodes the claimed video for a device, and uploads it to the output of the
+// device. Only the upload for the last device cleans up the claimed video.
+func (s *LocalSink) handleDevice(ctx context.Context, e fs.FileEvent, o deviceOutput, claimPath, pathSuffix string, subtitles []string, size int64, timing *Timing, last bool) error {
+	var err error
+	transcodedPath := filepath.Join(s.TranscodedDir, o.TranscodedSuffix)
+	if s.Scratch != nil {
+		transcodedPath, err = s.Scratch.Acquire(ctx, o.TranscodedSuffix)
+		if err != nil {
+			cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+			return err
+		}
+		defer s.Scratch.Release(transcodedPath)
+	}
+
+	logf(ctx, "transcoding %s for the %s device\n", pathSuffix, o.Device.Name)
+	err = s.transcode(ctx, e.Path, claimPath, transcodedPath, o.Device.Preset, o.Device.Container, nil, subtitles, size, timing)
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return err
+	}
+
+	opts, err := s.uploadOptions(e, claimPath, transcodedPath, pathSuffix, o.DestSuffix, o.Library, o.Device.Output, len(subtitles) > 0)
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return err
+	}
+	opts.KeepRaw = !last
+
+	logf(ctx, "uploading %s for the %s device\n", pathSuffix, o.Device.Name)
+	err = uploader.Upload(opts)
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return errors.Wrapf(err, "unable to upload %s for the %s device", pathSuffix, o.Device.Name)
+	}
+	output := opts.Destination.Location(o.DestSuffix)
+	runPostHook(ctx, s.PostHook, HookValues{Input: e.Path, Output: output}, s.Notifier)
+
+	// The video is only finished once it was uploaded for every device
+	if last {
+		Publish(ctx, PipelineEvent{Type: EventTranscoded, Path: e.Path})
+		timing.CompletedAt = time.Now()
+		Publish(ctx, PipelineEvent{Type: EventUploaded, Path: e.Path, Output: output})
+		Publish(ctx, PipelineEvent{Type: EventCompleted, Path: e.Path})
+	}
+	return nil
+}
+
+// createDeviceJobs creates a transcode and an upload job for each of the devices of the claimed
+// video. The upload job of the last device cleans up the claimed video, and waits for the upload
+// jobs of every other device to complete first, which leave the claimed video in place.
+func (s *JobSink) createDeviceJobs(ctx context.Context, e fs.FileEvent, devices []DeviceProfile, claimPath, pathSuffix string, subtitles []string, timing *Timing) error {
+	library := libraryName(pathSuffix)
+	_, organize := s.Outputs.For(library, s.PlexCfg.Share, s.Organize)
+	outputs, err := deviceOutputs(devices, pathSuffix, organize, s.Names, e)
+	if err != nil {
+		s.cleanupFailedClaim(claimPath)
+		return err
+	}
+
+	err = s.PreHook.Run(ctx, HookValues{Input: claimPath, Output: filepath.Join(s.TranscodedDir, outputs[0].TranscodedSuffix)})
+	if err != nil {
+		s.cleanupFailedClaim(claimPath)
+		return err
+	}
+
+	profile, _ := s.selectProfile(library, pathSuffix, e)
+	timing.Preset = devicePresets(outputs)
+	profile, tier := s.ResourceTiers.Size(profile, e.Size, e.Metadata)
+	if tier != "" {
+		logf(ctx, "sizing the transcode jobs for %s with the %s resource tier\n", pathSuffix, tier)
+	}
+	target := profile.Target(s.Targets.For(library))
+
+	var jobNames, uploadJobNames []string
+	for i, o := range outputs {
+		transcodeJobName, uploadJobName, err := s.createDeviceJob(ctx, target, profile, e, o, claimPath, pathSuffix, subtitles, uploadJobNames, i == len(outputs)-1)
+		if err != nil {
+			for _, name := range jobNames {
+				if delerr := s.jobsClient(target).Delete(name, target.Namespace); delerr != nil {
+					logln(ctx, delerr)
+				}
+			}
+			s.cleanupFailedClaim(claimPath)
+			return err
+		}
+		jobNames = append(jobNames, transcodeJobName, uploadJobName)
+		uploadJobNames = append(uploadJobNames, uploadJobName)
+	}
+
+	for _, name := range jobNames {
+		Publish(ctx, PipelineEvent{Type: EventJobCreated, Path: e.Path, Job: name})
+	}
+	releaseLock := keepVideoLock(ctx)
+	go func() {
+		s.waitForJobs(ctx, target, e.Path, claimPath, jobNames...)
+		s.SpaceCheck.Release(e.Path)
+		releaseLock()
+	}()
+	if s.Timings {
+		timing.QueuedAt = time.Now()
+		timing.LogFile = s.logFile(target, jobNames[0])
+		go s.trackJobTiming(ctx, target, timing, jobNames[0], jobNames[len(jobNames)-1])
+	}
+	return nil
+}
+
+// createDeviceJob creates the transcode and upload jobs of the claimed video for a device, named
+// after the device. The upload for the last device waits for the uploads of the other devices,
+// and is the only one that cleans up the claimed video, and records its source.
+func (s *JobSink) createDeviceJob(ctx context.Context, target JobTarget, profile JobProfile, e fs.FileEvent, o deviceOutput, claimPath, pathSuffix string, subtitles []string, otherUploads []string, last bool) (transcodeJobName, uploadJobName string, err error) {
+	transcodedPath := filepath.Join(s.TranscodedDir, o.TranscodedSuffix)
+	name, err := validNames(s.Names).JobName(e, claimPath)
+	if err != nil {
+		return "", "", err
+	}
+	name = jobs.SanitizeJobName(name + "-" + o.Device.Name)
+	err = validateJobName(name, claimPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	transcode, err := s.transcodeJobValues(ctx, target, profile, e, claimPath, transcodedPath, o.Device.Preset, o.Device.Container, nil, subtitles)
+	if err != nil {
+		return "", "", err
+	}
+	transcode.Name = name
+	transcodeJobName, err = s.createJobFromTemplate(ctx, target, "transcode.yaml", transcode)
+	if err != nil {
+		return "", "", err
+	}
+
+	upload, err := s.uploadJobValues(ctx, target, e, transcodeJobName, transcodedPath, claimPath, pathSuffix, o.DestSuffix, o.Library, o.Device.Output, len(subtitles) > 0 && last)
+	if err != nil {
+		return transcodeJobName, "", err
+	}
+	upload.Name = name
+	upload.Output = s.OutputBucket.destination(o.Device.Output, nil).Location(o.DestSuffix)
+	if !last {
+		upload.KeepRaw, upload.Source = true, ""
+	} else {
+		upload.AlsoWaitFor = otherUploads
+	}
+	uploadJobName, err = s.createJobFromTemplate(ctx, target, "upload.yaml", upload)
+	if err != nil {
+		if delerr := s.jobsClient(target).Delete(transcodeJobName, target.Namespace); delerr != nil {
+			logln(ctx, delerr)
+		}
+		return "", "", err
+	}
+
+	if s.PostHook.IsSet() {
+		go s.runPostHookAfterUpload(ctx, target, uploadJobName, HookValues{Input: e.Path, Output: upload.Output})
+	}
+	return transcodeJobName, uploadJobName, nil
+}
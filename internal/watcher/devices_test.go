@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestLoadDeviceProfiles(t *testing.T) {
+	path := writeJobProfiles(t, `
+devices:
+- name: tv
+  preset: H.265 MKV 2160p60
+  output: /plex/tv/
+- name: phone
+  preset: Fast 480p30
+  container: MP4
+  output: /plex/mobile
+  library: Mobile
+rules:
+- match: path=Movies/*,height>=2160
+  devices: [tv, phone]
+- match: path=TV/*
+  devices: [phone]
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	d, err := LoadDeviceProfiles(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	devices := d.For("Movies/foo.mkv", &ffprobe.Metadata{Height: 2160})
+	if len(devices) != 2 || devices[0].Name != "tv" || devices[1].Name != "phone" {
+		t.Fatalf("expected the tv and phone devices, got %#v", devices)
+	}
+	if devices[0].Output != "/plex/tv" || devices[1].Container != ".mp4" {
+		t.Fatalf("expected the output and container to be normalized, got %#v", devices)
+	}
+	if devices := d.For("Movies/foo.mkv", &ffprobe.Metadata{Height: 1080}); len(devices) != 0 {
+		t.Fatalf("expected a video that doesn't match a rule to have no devices, got %#v", devices)
+	}
+	if devices := d.For("TV/foo.mkv", nil); len(devices) != 1 || devices[0].Name != "phone" {
+		t.Fatalf("expected the phone device, got %#v", devices)
+	}
+}
+
+func TestLoadDeviceProfiles_Invalid(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Contents string
+	}{
+		{Name: "missing name", Contents: "devices:\n- preset: Fast 480p30\n  output: /plex/mobile\n"},
+		{Name: "duplicate name", Contents: "devices:\n- name: tv\n  preset: Fast 480p30\n  output: /plex/tv\n- name: tv\n  preset: Fast 480p30\n  output: /plex/mobile\n"},
+		{Name: "missing preset", Contents: "devices:\n- name: tv\n  output: /plex/tv\n"},
+		{Name: "missing output", Contents: "devices:\n- name: tv\n  preset: Fast 480p30\n"},
+		{Name: "invalid container", Contents: "devices:\n- name: tv\n  preset: Fast 480p30\n  output: /plex/tv\n  container: avi\n"},
+		{Name: "undefined device", Contents: "devices:\n- name: tv\n  preset: Fast 480p30\n  output: /plex/tv\nrules:\n- devices: [phone]\n"},
+		{Name: "no devices", Contents: "devices:\n- name: tv\n  preset: Fast 480p30\n  output: /plex/tv\nrules:\n- match: path=Movies/*\n"},
+		{Name: "invalid match", Contents: "devices:\n- name: tv\n  preset: Fast 480p30\n  output: /plex/tv\nrules:\n- match: size\n  devices: [tv]\n"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := writeJobProfiles(t, tc.Contents)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			_, err := LoadDeviceProfiles(path)
+			if err == nil {
+				t.Fatal("expected the device profiles to be invalid")
+			}
+		})
+	}
+}
+
+func TestIsUpToDate_Devices(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	now := time.Now()
+	devices := DeviceProfiles{
+		Devices: []DeviceProfile{
+			{Name: "tv", Preset: "H.265 MKV 2160p60", Output: filepath.Join(tmpDir, "tv")},
+			{Name: "phone", Preset: "Fast 480p30", Container: ".mp4", Output: filepath.Join(tmpDir, "mobile")},
+		},
+		Rules: []DeviceRule{{Devices: []string{"tv", "phone"}}},
+	}
+	if err := devices.validate(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	src := filepath.Join(watchDir, "Movies", "foo.mkv")
+	writeTestFile(t, src, "raw", now)
+	writeTestFile(t, filepath.Join(tmpDir, "tv", "Movies", "foo.mkv"), "transcoded", now.Add(time.Hour))
+	if isUpToDate(watchDir, nil, filepath.Join(tmpDir, "plex"), OrganizeTemplate{}, OutputBucket{}, nil, devices, nil, false, fs.FileEvent{Path: src}) {
+		t.Fatal("expected the video to not be up to date until it is uploaded for every device")
+	}
+
+	writeTestFile(t, filepath.Join(tmpDir, "mobile", "Movies", "foo.mp4"), "transcoded", now.Add(time.Hour))
+	if !isUpToDate(watchDir, nil, filepath.Join(tmpDir, "plex"), OrganizeTemplate{}, OutputBucket{}, nil, devices, nil, false, fs.FileEvent{Path: src}) {
+		t.Fatal("expected the video to be up to date once it is uploaded for every device")
+	}
+}
@@ -0,0 +1,231 @@
+package watcher
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// EncodeEstimator estimates how long a video takes to encode from its size, using the
+// encode times of the videos that were already transcoded with the same preset. Each
+// preset is fit with a line through its history of size to encode time, so the estimate
+// improves as the history grows.
+type EncodeEstimator struct {
+	// HistoryFile optionally keeps the timing records that the estimates are fit to, as
+	// lines of JSON, so that the history is kept when the watcher restarts.
+	HistoryFile string
+
+	// Cipher optionally encrypts the records in the HistoryFile, so that the paths are kept private.
+	Cipher *fs.Cipher
+
+	// DefaultPreset is the preset of the videos that haven't been handed to the sinks
+	// yet, which is the preset of the videos unless a rule or profile selects another.
+	DefaultPreset string
+
+	// Retention is how long the records are kept, by when their video was detected, and
+	// MaxRecords is how many of the newest records are kept. The history is compacted
+	// when either is exceeded, see Compact, and is kept forever when both are 0.
+	Retention  time.Duration
+	MaxRecords int
+
+	mu          sync.Mutex
+	records     []TimingRecord
+	compactedAt time.Time
+	presets     map[string]*encodeFit
+	all         encodeFit
+}
+
+// encodeFit is the least squares fit of encode seconds to the size in bytes, kept as running sums.
+type encodeFit struct {
+	n, sumX, sumY, sumXX, sumXY float64
+}
+
+// NewEncodeEstimator loads the history from the file, when set, decrypting it with the cipher.
+// A history file that doesn't exist yet is empty.
+func NewEncodeEstimator(historyFile, defaultPreset string, cipher *fs.Cipher) (*EncodeEstimator, error) {
+	e := &EncodeEstimator{HistoryFile: historyFile, Cipher: cipher, DefaultPreset: defaultPreset, presets: make(map[string]*encodeFit)}
+	if historyFile == "" {
+		return e, nil
+	}
+
+	f, err := os.Open(historyFile)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open the encode history %s", historyFile)
+	}
+	defer f.Close()
+
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		var r TimingRecord
+		line, err := cipher.OpenLine(lines.Bytes())
+		if err == nil {
+			err = json.Unmarshal(line, &r)
+		}
+		if err != nil {
+			log.Printf("skipping an invalid record in the encode history %s: %s\n", historyFile, err)
+			continue
+		}
+		e.records = append(e.records, r)
+		e.fit(r)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, errors.Wrapf(err, "unable to read the encode history %s", historyFile)
+	}
+	return e, nil
+}
+
+// UseEncodeEstimator estimates the encode time of the queued videos, reported by Queue,
+// Status and Load, and adds the timing of each transcoded video to the history. It
+// requires the sinks to emit timings.
+func (w *VideoWatcher) UseEncodeEstimator(e *EncodeEstimator) {
+	w.mu.Lock()
+	w.estimator = e
+	w.mu.Unlock()
+
+	go e.record(w.Subscribe())
+}
+
+// record adds the timing of each video that was encoded to the history.
+func (e *EncodeEstimator) record(events <-chan PipelineEvent) {
+	for event := range events {
+		if event.Type != EventTiming || event.Timing == nil {
+			continue
+		}
+		err := e.Add(event.Timing.Record())
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Add the timing of a video to the history, writing it to the HistoryFile. Every video is
+// kept, so that failures can be queried, but a video that wasn't encoded, or whose size isn't
+// known, isn't used by the estimates.
+func (e *EncodeEstimator) Add(r TimingRecord) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrapf(err, "unable to serialize the timing of %s", r.Path)
+	}
+	line, err = e.Cipher.SealLine(line)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encrypt the timing of %s", r.Path)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.records = append(e.records, r)
+	e.fit(r)
+	if e.HistoryFile == "" {
+		return e.compactIfNeededLocked(time.Now())
+	}
+
+	f, err := os.OpenFile(e.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "unable to open the encode history %s", e.HistoryFile)
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	if err != nil {
+		return errors.Wrapf(err, "unable to write the timing of %s to the encode history %s", r.Path, e.HistoryFile)
+	}
+	return e.compactIfNeededLocked(time.Now())
+}
+
+// fit the timing to the estimates, unless it can't be used. The caller must hold mu,
+// except while the estimator is created.
+func (e *EncodeEstimator) fit(r TimingRecord) {
+	if r.Encode <= 0 || r.EncodedSize <= 0 {
+		return
+	}
+
+	fit, ok := e.presets[r.Preset]
+	if !ok {
+		fit = &encodeFit{}
+		e.presets[r.Preset] = fit
+	}
+	x := float64(r.EncodedSize)
+	fit.add(x, r.Encode)
+	e.all.add(x, r.Encode)
+}
+
+// Estimate how long the video of the size takes to encode with the preset. When the preset
+// has no history, the history of every preset is used. Returns false when there is no history.
+func (e *EncodeEstimator) Estimate(preset string, size int64) (time.Duration, bool) {
+	if size <= 0 {
+		return 0, false
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fit, ok := e.presets[preset]
+	if !ok {
+		fit = &e.all
+	}
+	seconds, ok := fit.estimate(float64(size))
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func (f *encodeFit) add(x, y float64) {
+	f.n++
+	f.sumX += x
+	f.sumY += y
+	f.sumXX += x * x
+	f.sumXY += x * y
+}
+
+// estimate the encode seconds of the size with the fitted line. Until the history has videos
+// of different sizes, or when the line doesn't grow with the size, the average throughput is used instead.
+func (f *encodeFit) estimate(x float64) (float64, bool) {
+	if f.n == 0 || f.sumX == 0 {
+		return 0, false
+	}
+
+	variance := f.n*f.sumXX - f.sumX*f.sumX
+	if f.n >= 2 && variance > 0 {
+		slope := (f.n*f.sumXY - f.sumX*f.sumY) / variance
+		if slope > 0 {
+			intercept := (f.sumY - slope*f.sumX) / f.n
+			if y := intercept + slope*x; y > 0 {
+				return y, true
+			}
+		}
+	}
+	return f.sumY / f.sumX * x, true
+}
+
+// estimateLocked the encode time of a video that hasn't been handed to the sinks,
+// or 0 when there is no estimate. The caller must hold mu.
+func (w *VideoWatcher) estimateLocked(size int64) time.Duration {
+	if w.estimator == nil {
+		return 0
+	}
+	estimate, _ := w.estimator.Estimate(w.estimator.DefaultPreset, size)
+	return estimate
+}
+
+// QueueETA estimates how long the stable videos that haven't been submitted yet, including
+// the queued videos, take to encode one after the other. It is 0 without an EncodeEstimator,
+// and doesn't include the videos that have no estimate.
+func (w *VideoWatcher) QueueETA() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var eta time.Duration
+	for _, size := range w.latency.waitingSizes() {
+		eta += w.estimateLocked(size)
+	}
+	return eta
+}
@@ -0,0 +1,109 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+const gigabyte = 1 << 30
+
+func TestEncodeEstimator_Estimate(t *testing.T) {
+	e, err := NewEncodeEstimator("", "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, ok := e.Estimate("tivo", gigabyte); ok {
+		t.Fatal("expected no estimate without a history")
+	}
+
+	// A single video estimates with its throughput
+	e.Add(TimingRecord{Path: "/watch/foo.mkv", Preset: "tivo", EncodedSize: gigabyte, Encode: 600})
+	if got, _ := e.Estimate("tivo", 2*gigabyte); got != 20*time.Minute {
+		t.Fatalf("expected 2GB to take 20m from the throughput, got %s", got)
+	}
+
+	// Videos of different sizes are fit with a line, with a fixed overhead of 2m
+	e.Add(TimingRecord{Path: "/watch/bar.mkv", Preset: "tivo", EncodedSize: 3 * gigabyte, Encode: 1320})
+	if got, _ := e.Estimate("tivo", 2*gigabyte); got != 16*time.Minute {
+		t.Fatalf("expected 2GB to take 16m from the fit, got %s", got)
+	}
+
+	e.Add(TimingRecord{Path: "/watch/baz.mkv", Preset: "slow", EncodedSize: gigabyte, Encode: 3600})
+	if got, _ := e.Estimate("slow", gigabyte); got != time.Hour {
+		t.Fatalf("expected each preset to be estimated separately, got %s", got)
+	}
+	if _, ok := e.Estimate("fast", gigabyte); !ok {
+		t.Fatal("expected a preset without a history to be estimated from every preset")
+	}
+
+	// Videos that weren't encoded are ignored
+	e.Add(TimingRecord{Path: "/watch/failed.mkv", Preset: "slow", EncodedSize: gigabyte})
+	if got, _ := e.Estimate("slow", gigabyte); got != time.Hour {
+		t.Fatalf("expected a video that wasn't encoded to be ignored, got %s", got)
+	}
+}
+
+func TestEncodeEstimator_HistoryFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	historyFile := filepath.Join(tmpDir, "history.jsonl")
+	e, err := NewEncodeEstimator(historyFile, "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	err = e.Add(TimingRecord{Path: "/watch/foo.mkv", Preset: "tivo", EncodedSize: gigabyte, Encode: 600})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	restarted, err := NewEncodeEstimator(historyFile, "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, _ := restarted.Estimate("tivo", gigabyte); got != 10*time.Minute {
+		t.Fatalf("expected the history to be kept after a restart, got %s", got)
+	}
+}
+
+func TestVideoWatcher_QueueETA(t *testing.T) {
+	files := make(chan fs.FileEvent)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, newRecordingSink(nil))
+	defer w.Close()
+
+	e, err := NewEncodeEstimator("", "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	e.Add(TimingRecord{Path: "/watch/foo.mkv", Preset: "tivo", EncodedSize: gigabyte, Encode: 600})
+	w.UseEncodeEstimator(e)
+
+	w.Pause()
+	files <- fs.FileEvent{Path: "/watch/bar.mkv", Size: gigabyte}
+	files <- fs.FileEvent{Path: "/watch/baz.mkv", Size: 2 * gigabyte}
+	for i := 0; w.Status().Queued != 2; i++ {
+		if i == 100 {
+			t.Fatalf("expected the videos to be queued, got %#v", w.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := w.QueueETA(); got != 30*time.Minute {
+		t.Fatalf("expected the queue to take 30m to encode, got %s", got)
+	}
+	if got := w.Status().QueueETA; got != 1800 {
+		t.Fatalf("expected the status to report the queue ETA, got %g", got)
+	}
+	if got := w.Queue()[1].EstimatedEncode; got != 1200 {
+		t.Fatalf("expected baz to take 20m to encode, got %g", got)
+	}
+}
@@ -0,0 +1,145 @@
+package watcher
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/bus"
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/pkg/errors"
+)
+
+// EventBusStats counts the pipeline events sent to the message bus.
+type EventBusStats struct {
+	// Published is the number of events accepted by the bus.
+	Published int
+
+	// Dropped is the number of events discarded, without being sent, because too many were pending.
+	Dropped int
+
+	// Failed is the number of events that the bus did not accept.
+	Failed int
+}
+
+// EventMessage is a PipelineEvent as it is published to the message bus, as JSON.
+type EventMessage struct {
+	Type     PipelineEventType   `json:"type"`
+	ID       string              `json:"id"`
+	Path     string              `json:"path"`
+	At       time.Time           `json:"at"`
+	Metadata *ffprobe.Metadata   `json:"metadata,omitempty"`
+	Size     int64               `json:"size,omitempty"`
+	Reason   fs.RejectReason     `json:"reason,omitempty"`
+	Job      string              `json:"job,omitempty"`
+	Output   string              `json:"output,omitempty"`
+	Progress *handbrake.Progress `json:"progress,omitempty"`
+	Error    string              `json:"error,omitempty"`
+	Timing   *TimingRecord       `json:"timing,omitempty"`
+}
+
+// NewEventMessage converts the event to the message published to the bus.
+func NewEventMessage(e PipelineEvent) EventMessage {
+	m := EventMessage{Type: e.Type, ID: e.ID, Path: e.Path, At: e.At, Metadata: e.Metadata, Size: e.Size,
+		Reason: e.Reason, Job: e.Job, Output: e.Output, Progress: e.Progress}
+	if e.Err != nil {
+		m.Error = e.Err.Error()
+	}
+	if e.Timing != nil {
+		r := e.Timing.Record()
+		m.Timing = &r
+	}
+	return m
+}
+
+// eventBus forwards the pipeline events to a publisher in the background, holding a bounded
+// number of pending events so that a slow bus never holds up the pipeline.
+type eventBus struct {
+	publisher bus.Publisher
+	subject   string
+	pending   chan PipelineEvent
+
+	mu    sync.Mutex
+	stats EventBusStats
+}
+
+// UseEventBus publishes every pipeline event to the subject, or topic, of the message bus
+// as an EventMessage, so that other services can react to the videos as they are detected,
+// transcoded, uploaded or fail. Publishing is best-effort: when buffer events are already
+// pending, new events are dropped. The publisher is closed along with the watcher.
+func (w *VideoWatcher) UseEventBus(publisher bus.Publisher, subject string, buffer int) {
+	b := &eventBus{
+		publisher: publisher,
+		subject:   subject,
+		pending:   make(chan PipelineEvent, buffer),
+	}
+
+	w.mu.Lock()
+	w.eventBus = b
+	w.mu.Unlock()
+
+	// The events that the broker drops, when the queue falls behind, are counted too
+	go b.queue(w.events.subscribeDropped(b.dropped))
+	go b.send()
+}
+
+// EventBus reports how many events were sent to the message bus, dropped, or not accepted.
+func (w *VideoWatcher) EventBus() EventBusStats {
+	w.mu.Lock()
+	b := w.eventBus
+	w.mu.Unlock()
+
+	if b == nil {
+		return EventBusStats{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// queue holds each event until it is sent, dropping it when too many are pending.
+func (b *eventBus) queue(events <-chan PipelineEvent) {
+	defer close(b.pending)
+	for e := range events {
+		select {
+		case b.pending <- e:
+		default:
+			b.dropped()
+		}
+	}
+}
+
+func (b *eventBus) dropped() {
+	b.count(func(s *EventBusStats) { s.Dropped++ })
+}
+
+// send publishes each pending event, logging the events that the bus did not accept.
+func (b *eventBus) send() {
+	defer b.publisher.Close()
+	for e := range b.pending {
+		err := b.publish(e)
+		if err != nil {
+			log.Println(err)
+			b.count(func(s *EventBusStats) { s.Failed++ })
+			continue
+		}
+		b.count(func(s *EventBusStats) { s.Published++ })
+	}
+}
+
+func (b *eventBus) publish(e PipelineEvent) error {
+	message, err := json.Marshal(NewEventMessage(e))
+	if err != nil {
+		return errors.Wrapf(err, "unable to serialize the %s event for %s", e.Type, e.Path)
+	}
+	return b.publisher.Publish(b.subject, message)
+}
+
+func (b *eventBus) count(update func(s *EventBusStats)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	update(&b.stats)
+}
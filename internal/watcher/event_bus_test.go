@@ -0,0 +1,129 @@
+package watcher
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// recordingPublisher records the messages published to each subject, failing when err is set.
+// When block is set, Publish waits until it is closed.
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages []string
+	err      error
+	block    chan struct{}
+	closed   bool
+}
+
+func (p *recordingPublisher) Publish(subject string, message []byte) error {
+	if p.block != nil {
+		<-p.block
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.messages = append(p.messages, subject+" "+string(message))
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func waitForEventBus(t *testing.T, w *VideoWatcher, done func(EventBusStats) bool) EventBusStats {
+	for i := 0; i < 100; i++ {
+		if stats := w.EventBus(); done(stats) {
+			return stats
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected the events to be sent to the bus, got %#v", w.EventBus())
+	return EventBusStats{}
+}
+
+func TestVideoWatcher_UseEventBus(t *testing.T) {
+	w := NewVideoWatcher(&fakeWatcher{files: make(chan fs.FileEvent)}, newRecordingSink(nil))
+	p := &recordingPublisher{}
+	w.UseEventBus(p, "handbrk8s.events", 10)
+
+	at := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: "/watch/Movies/foo.mkv", Job: "foo-mkv-transcode", At: at})
+	w.events.publish(PipelineEvent{Type: EventFailed, Path: "/watch/Movies/bar.mkv", Err: errors.New("unable to claim"), At: at})
+	waitForEventBus(t, w, func(s EventBusStats) bool { return s.Published == 2 })
+
+	p.mu.Lock()
+	messages := p.messages
+	p.mu.Unlock()
+	var m EventMessage
+	err := json.Unmarshal([]byte(messages[1][len("handbrk8s.events "):]), &m)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if m.Type != EventFailed || m.Path != "/watch/Movies/bar.mkv" || m.Error != "unable to claim" || !m.At.Equal(at) {
+		t.Fatalf("expected the failed event to be published with its error, got %s", messages[1])
+	}
+	if messages[0] != `handbrk8s.events {"type":"job-created","id":"6af147cb","path":"/watch/Movies/foo.mkv","at":"2018-01-02T03:04:05Z","job":"foo-mkv-transcode"}` {
+		t.Fatalf("unexpected message for the created job, got %s", messages[0])
+	}
+
+	// Failures are counted, and don't stop the events that follow
+	p.mu.Lock()
+	p.err = errors.New("nats: connection refused")
+	p.mu.Unlock()
+	w.events.publish(PipelineEvent{Type: EventDetected, Path: "/watch/Movies/baz.mkv"})
+	waitForEventBus(t, w, func(s EventBusStats) bool { return s.Failed == 1 })
+
+	w.Close()
+	for i := 0; i < 100; i++ {
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected the publisher to be closed with the watcher")
+}
+
+func TestVideoWatcher_UseEventBus_Dropped(t *testing.T) {
+	w := NewVideoWatcher(&fakeWatcher{files: make(chan fs.FileEvent)}, newRecordingSink(nil))
+	defer w.Close()
+	p := &recordingPublisher{block: make(chan struct{})}
+	w.UseEventBus(p, "handbrk8s.events", 2)
+
+	// A slow bus holds one event while it is sent, and two more are pending
+	for i := 0; i < 5; i++ {
+		w.events.publish(PipelineEvent{Type: EventDetected, Path: "/watch/Movies/foo.mkv"})
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(p.block)
+	stats := waitForEventBus(t, w, func(s EventBusStats) bool { return s.Published+s.Dropped == 5 })
+	if stats.Published != 3 || stats.Dropped != 2 {
+		t.Fatalf("expected the events beyond the buffer to be dropped, got %#v", stats)
+	}
+}
+
+func TestBroker_SubscribeDropped(t *testing.T) {
+	var b broker
+	var dropped int
+	events := b.subscribeDropped(func() { dropped++ })
+
+	// The subscriber never reads, so the events beyond its buffer are dropped
+	for i := 0; i < subscriberBuffer+3; i++ {
+		b.publish(PipelineEvent{Type: EventDetected, Path: "/watch/Movies/foo.mkv"})
+	}
+	if len(events) != subscriberBuffer || dropped != 3 {
+		t.Fatalf("expected the 3 events beyond the buffer to be dropped, got %d pending and %d dropped", len(events), dropped)
+	}
+}
@@ -0,0 +1,192 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+)
+
+// PipelineEventType identifies what happened to a video.
+type PipelineEventType string
+
+const (
+	// EventDetected is a video that is ready to be processed.
+	EventDetected PipelineEventType = "detected"
+
+	// EventSkipped is a video that was rejected, see PipelineEvent.Reason.
+	EventSkipped PipelineEventType = "skipped"
+
+	// EventJobCreated is a job created on the cluster for a video, see PipelineEvent.Job.
+	EventJobCreated PipelineEventType = "job-created"
+
+	// EventJobFinished is a job created for a video that completed, failed or was removed, see PipelineEvent.Job.
+	EventJobFinished PipelineEventType = "job-finished"
+
+	// EventTranscodeStarted is a video whose transcode started on the current host.
+	EventTranscodeStarted PipelineEventType = "transcode-started"
+
+	// EventProgress is the progress of the transcode of a video, see PipelineEvent.Progress. It is
+	// sent every few seconds on the current host, and each time the jobs are checked for the
+	// JobSink, with the progress of a batch sent for its first video.
+	EventProgress PipelineEventType = "progress"
+
+	// EventTranscoded is a video that was transcoded on the current host.
+	EventTranscoded PipelineEventType = "transcoded"
+
+	// EventUploaded is a video that was uploaded, see PipelineEvent.Output. For the
+	// JobSink, it is sent once the upload job of the video completes.
+	EventUploaded PipelineEventType = "uploaded"
+
+	// EventCompleted is a video that was transcoded and uploaded to every output. For the
+	// JobSink, it is sent once every job of the video completed.
+	EventCompleted PipelineEventType = "completed"
+
+	// EventHandled is a video that every sink handled. For the JobSink, the jobs
+	// were created but may not have completed yet.
+	EventHandled PipelineEventType = "handled"
+
+	// EventFailed is a video that a sink was unable to handle, or whose job failed, see PipelineEvent.Err.
+	EventFailed PipelineEventType = "failed"
+
+	// EventCancelled is a video whose jobs were cancelled, through the dashboard api, see PipelineEvent.Job.
+	EventCancelled PipelineEventType = "cancelled"
+
+	// EventTiming is the breakdown of how long each step took for a video, once it is
+	// uploaded or fails, when enabled on the sink. See PipelineEvent.Timing.
+	EventTiming PipelineEventType = "timing"
+)
+
+// PipelineEvent describes a step in processing a video. The fields used depend on the Type.
+type PipelineEvent struct {
+	Type PipelineEventType
+	Path string
+	At   time.Time
+
+	// ID is the CorrelationID of the video, which is the same for each of its events.
+	ID string
+
+	// Metadata of a detected video, when probing is enabled.
+	Metadata *ffprobe.Metadata
+
+	// Size of a detected video, in bytes.
+	Size int64
+
+	// Reason a video was skipped.
+	Reason fs.RejectReason
+
+	// Job created for the video.
+	Job string
+
+	// Output is where an uploaded video was uploaded.
+	Output string
+
+	// Progress of the transcode of the video.
+	Progress *handbrake.Progress
+
+	// Err that caused the video to fail.
+	Err error
+
+	// Timing of each step for the video.
+	Timing *Timing
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before events are dropped.
+const subscriberBuffer = 100
+
+// broker fans out pipeline events to every subscriber.
+type broker struct {
+	mu          sync.Mutex
+	closed      bool
+	subscribers []subscriber
+
+	// observe is optionally called with every event, before it is sent to the subscribers.
+	observe func(PipelineEvent)
+}
+
+// subscriber receives the events on its channel, and is told about the events dropped for it.
+type subscriber struct {
+	events  chan PipelineEvent
+	dropped func()
+}
+
+// subscribe returns a new channel that receives every event published after it subscribed.
+func (b *broker) subscribe() <-chan PipelineEvent {
+	return b.subscribeDropped(nil)
+}
+
+// subscribeDropped subscribes, calling dropped, when set, with each event that is dropped
+// because the subscriber has fallen behind.
+func (b *broker) subscribeDropped(dropped func()) <-chan PipelineEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan PipelineEvent, subscriberBuffer)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers = append(b.subscribers, subscriber{events: ch, dropped: dropped})
+	return ch
+}
+
+// publish sends the event to every subscriber, dropping it for subscribers
+// that have fallen behind so that processing videos is never blocked.
+func (b *broker) publish(e PipelineEvent) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if e.ID == "" {
+		e.ID = CorrelationID(e.Path)
+	}
+	if b.observe != nil {
+		b.observe(e)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	for _, s := range b.subscribers {
+		select {
+		case s.events <- e:
+		default:
+			if s.dropped != nil {
+				s.dropped()
+			}
+		}
+	}
+}
+
+// close closes every subscriber's channel.
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, s := range b.subscribers {
+		close(s.events)
+	}
+}
+
+type brokerKey struct{}
+
+// Publish sends an event to the subscribers of the VideoWatcher that is calling
+// the sink, with the correlation id of the video that the sink is handling. It does
+// nothing when the context is not from a VideoWatcher.
+func Publish(ctx context.Context, e PipelineEvent) {
+	if b, ok := ctx.Value(brokerKey{}).(*broker); ok {
+		if e.ID == "" {
+			e.ID = correlationID(ctx)
+		}
+		b.publish(e)
+	}
+}
@@ -0,0 +1,66 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+)
+
+// FastLane reserves transcode slots for small videos in local mode, so that they don't
+// wait for hours behind a large video, such as a 4K remux, that is occupying the main
+// transcode slot. A small video uses the main slot when it is free, and otherwise the
+// fast lane. Larger videos only use the main slot.
+type FastLane struct {
+	// MaxSize is the largest video, in bytes, that may use the fast lane. 0 disables the fast lane.
+	MaxSize int64
+
+	// Slots is how many small videos may be transcoded in the fast lane at once,
+	// alongside the video in the main slot.
+	Slots int
+}
+
+// Allows determines if a video of the size, in bytes, may use the fast lane.
+func (l FastLane) Allows(size int64) bool {
+	return l.MaxSize > 0 && l.Slots > 0 && size <= l.MaxSize
+}
+
+// transcodeSlots limits how many videos are transcoded at once, one in the main slot,
+// and the small videos in the slots of the fast lane.
+type transcodeSlots struct {
+	once       sync.Once
+	main, fast chan struct{}
+}
+
+// acquire waits for a slot for the video, returning a function that releases it,
+// and if the slot is in the fast lane. Stops waiting when the context is done.
+func (t *transcodeSlots) acquire(ctx context.Context, lane FastLane, size int64) (func(), bool, error) {
+	t.once.Do(func() {
+		t.main = make(chan struct{}, 1)
+		if lane.Slots > 0 {
+			t.fast = make(chan struct{}, lane.Slots)
+		}
+	})
+	release := func(slot chan struct{}) func() {
+		return func() { <-slot }
+	}
+
+	// A nil channel never receives, so the fast lane is only used by small videos
+	var fast chan struct{}
+	if lane.Allows(size) {
+		fast = t.fast
+	}
+
+	// Prefer the main slot when it is free
+	select {
+	case t.main <- struct{}{}:
+		return release(t.main), false, nil
+	default:
+	}
+	select {
+	case t.main <- struct{}{}:
+		return release(t.main), false, nil
+	case fast <- struct{}{}:
+		return release(fast), true, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTranscodeSlots_FastLane(t *testing.T) {
+	lane := FastLane{MaxSize: 100, Slots: 1}
+	var slots transcodeSlots
+
+	releaseLarge, fast, err := slots.acquire(context.Background(), lane, 1000)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if fast {
+		t.Fatal("expected the large video to use the main slot")
+	}
+
+	// A small video doesn't wait for the large one
+	releaseSmall, fast, err := slots.acquire(context.Background(), lane, 50)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if !fast {
+		t.Fatal("expected the small video to use the fast lane")
+	}
+
+	// Another large video, or small video once the fast lane is full, waits
+	for _, size := range []int64{1000, 50} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, _, err = slots.acquire(ctx, lane, size)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected a video of %d bytes to wait for a slot, got %#v", size, err)
+		}
+	}
+
+	releaseSmall()
+	releaseLarge()
+	release, fast, err := slots.acquire(context.Background(), lane, 50)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if fast {
+		t.Fatal("expected the small video to use the free main slot")
+	}
+	release()
+}
+
+func TestFastLane_Allows(t *testing.T) {
+	testcases := []struct {
+		name string
+		lane FastLane
+		size int64
+		want bool
+	}{
+		{"disabled", FastLane{Slots: 1}, 10, false},
+		{"no slots", FastLane{MaxSize: 100}, 10, false},
+		{"small", FastLane{MaxSize: 100, Slots: 1}, 100, true},
+		{"large", FastLane{MaxSize: 100, Slots: 1}, 101, false},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.lane.Allows(tc.size); got != tc.want {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package watcher
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// historyCompactInterval is how often the history is compacted to drop the records older
+// than the Retention, and historyCompactSlack is how many records past MaxRecords, as a
+// fraction of it, are appended before the oldest are dropped, so that the file isn't
+// rewritten with each record.
+const (
+	historyCompactInterval = 24 * time.Hour
+	historyCompactSlack    = 10
+)
+
+// HistoryQuery selects the records of the encode history.
+type HistoryQuery struct {
+	// Status is "completed" or "failed" to only select the videos that were or weren't
+	// uploaded, "cancelled" to only select the videos whose jobs were cancelled, or empty
+	// for every video.
+	Status string
+
+	// Since and Until bound when the videos were detected, when set.
+	Since, Until time.Time
+
+	// Path is a pattern, see filepath.Match, that the paths of the videos must match, for
+	// example /watch/TV/*/*. Empty selects every video.
+	Path string
+
+	// Sort is the order of the records: "newest", the default, "slowest" by the total time,
+	// or "savings" by the bytes saved by the transcode.
+	Sort string
+
+	// Limit is how many records are returned, or 0 for every record. The summary always
+	// covers every selected record.
+	Limit int
+}
+
+// HistoryResult is the records selected by a HistoryQuery, with their summary.
+type HistoryResult struct {
+	Records []TimingRecord `json:"records"`
+	Summary HistorySummary `json:"summary"`
+}
+
+// HistorySummary aggregates the selected records. The sizes only include the videos whose size
+// is known, and SavedBytes only the videos whose size is known before and after the transcode.
+type HistorySummary struct {
+	Videos        int     `json:"videos"`
+	Failed        int     `json:"failed"`
+	Cancelled     int     `json:"cancelled"`
+	EncodeSeconds float64 `json:"encodeSeconds"`
+	TotalSeconds  float64 `json:"totalSeconds"`
+	EncodedBytes  int64   `json:"encodedBytes"`
+	OutputBytes   int64   `json:"outputBytes"`
+	SavedBytes    int64   `json:"savedBytes"`
+}
+
+// savedBytes is how much smaller the transcode made the video, or 0 when a size isn't known.
+func (r TimingRecord) savedBytes() int64 {
+	if r.EncodedSize <= 0 || r.OutputSize <= 0 {
+		return 0
+	}
+	return r.EncodedSize - r.OutputSize
+}
+
+// validate the status, sort and path pattern of the query.
+func (q HistoryQuery) validate() error {
+	switch q.Status {
+	case "", "completed", "failed", "cancelled":
+	default:
+		return errors.Errorf("invalid status %q, must be completed, failed or cancelled", q.Status)
+	}
+	switch q.Sort {
+	case "", "newest", "slowest", "savings":
+	default:
+		return errors.Errorf("invalid sort %q, must be newest, slowest or savings", q.Sort)
+	}
+	if _, err := filepath.Match(q.Path, ""); err != nil {
+		return errors.Wrapf(err, "invalid path pattern %q", q.Path)
+	}
+	if q.Limit < 0 {
+		return errors.Errorf("invalid limit %d, must be at least 0", q.Limit)
+	}
+	return nil
+}
+
+// matches determines if the record is selected by the query.
+func (q HistoryQuery) matches(r TimingRecord) bool {
+	completed := !r.Failed && !r.Cancelled
+	if (q.Status == "completed" && !completed) || (q.Status == "failed" && !r.Failed) || (q.Status == "cancelled" && !r.Cancelled) {
+		return false
+	}
+	if !q.Since.IsZero() && r.DetectedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && r.DetectedAt.After(q.Until) {
+		return false
+	}
+	if q.Path != "" {
+		if ok, _ := filepath.Match(q.Path, r.Path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Query selects the records of the history, sorted and limited by the query, and summarizes them.
+func (e *EncodeEstimator) Query(q HistoryQuery) (HistoryResult, error) {
+	if err := q.validate(); err != nil {
+		return HistoryResult{}, err
+	}
+
+	result := HistoryResult{Records: []TimingRecord{}}
+	e.mu.Lock()
+	for _, r := range e.records {
+		if !q.matches(r) {
+			continue
+		}
+		result.Records = append(result.Records, r)
+
+		s := &result.Summary
+		s.Videos++
+		if r.Failed {
+			s.Failed++
+		}
+		if r.Cancelled {
+			s.Cancelled++
+		}
+		s.EncodeSeconds += r.Encode
+		s.TotalSeconds += r.Total
+		s.EncodedBytes += r.EncodedSize
+		s.OutputBytes += r.OutputSize
+		s.SavedBytes += r.savedBytes()
+	}
+	e.mu.Unlock()
+
+	records := result.Records
+	switch q.Sort {
+	case "slowest":
+		sort.SliceStable(records, func(i, j int) bool { return records[i].Total > records[j].Total })
+	case "savings":
+		sort.SliceStable(records, func(i, j int) bool { return records[i].savedBytes() > records[j].savedBytes() })
+	default:
+		// The records are kept in the order that the videos finished
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+	if q.Limit > 0 && len(records) > q.Limit {
+		result.Records = records[:q.Limit]
+	}
+	return result, nil
+}
+
+// Compact drops the records older than the Retention, and all but the newest MaxRecords,
+// rewriting the HistoryFile with the records that are kept, and fitting the estimates to them.
+func (e *EncodeEstimator) Compact() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.compactLocked(time.Now())
+}
+
+// compactIfNeededLocked compacts the history once it has grown well past MaxRecords, or
+// when it wasn't compacted for a day and has a Retention. The caller must hold mu.
+func (e *EncodeEstimator) compactIfNeededLocked(now time.Time) error {
+	overMax := e.MaxRecords > 0 && len(e.records) > e.MaxRecords+e.MaxRecords/historyCompactSlack
+	expired := e.Retention > 0 && now.Sub(e.compactedAt) > historyCompactInterval
+	if !overMax && !expired {
+		return nil
+	}
+	return e.compactLocked(now)
+}
+
+// compactLocked the history, see Compact. The caller must hold mu.
+func (e *EncodeEstimator) compactLocked(now time.Time) error {
+	e.compactedAt = now
+
+	var kept []TimingRecord
+	for _, r := range e.records {
+		if e.Retention > 0 && !r.DetectedAt.IsZero() && now.Sub(r.DetectedAt) > e.Retention {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	if e.MaxRecords > 0 && len(kept) > e.MaxRecords {
+		kept = kept[len(kept)-e.MaxRecords:]
+	}
+	if len(kept) == len(e.records) {
+		return nil
+	}
+
+	e.records = kept
+	e.presets, e.all = make(map[string]*encodeFit), encodeFit{}
+	for _, r := range kept {
+		e.fit(r)
+	}
+	if e.HistoryFile == "" {
+		return nil
+	}
+	return errors.Wrapf(rewriteHistory(e.HistoryFile, e.Cipher, kept), "unable to compact the encode history %s", e.HistoryFile)
+}
+
+// rewriteHistory replaces the history file with the records, through a temporary file in
+// the same directory, so that the history isn't lost when the watcher stops mid-write.
+func rewriteHistory(historyFile string, cipher *fs.Cipher, records []TimingRecord) error {
+	f, err := ioutil.TempFile(filepath.Dir(historyFile), "."+filepath.Base(historyFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	for _, r := range records {
+		var line []byte
+		line, err = json.Marshal(r)
+		if err == nil {
+			line, err = cipher.SealLine(line)
+		}
+		if err == nil {
+			_, err = f.Write(append(line, '\n'))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), historyFile)
+}
+
+// History selects the records of the encode history, see EncodeEstimator.Query.
+// Without an EncodeEstimator, there is no history to query.
+func (w *VideoWatcher) History(q HistoryQuery) (HistoryResult, error) {
+	w.mu.Lock()
+	e := w.estimator
+	w.mu.Unlock()
+
+	if e == nil {
+		return HistoryResult{}, errors.New("the encode history isn't kept, see -encode-history")
+	}
+	return e.Query(q)
+}
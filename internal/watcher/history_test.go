@@ -0,0 +1,161 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestEncodeEstimator_Query(t *testing.T) {
+	e, err := NewEncodeEstimator("", "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.Add(TimingRecord{Path: "/watch/Movies/foo.mkv", DetectedAt: start, Preset: "tivo", EncodedSize: 4 * gigabyte, OutputSize: gigabyte, Encode: 600, Total: 700})
+	e.Add(TimingRecord{Path: "/watch/TV/Show/s01e01.mkv", DetectedAt: start.Add(time.Hour), Preset: "tivo", EncodedSize: 2 * gigabyte, Failed: true, Total: 30})
+	e.Add(TimingRecord{Path: "/watch/TV/Show/s01e02.mkv", DetectedAt: start.Add(2 * time.Hour), Preset: "tivo", EncodedSize: 2 * gigabyte, OutputSize: gigabyte / 2, Encode: 300, Total: 900})
+
+	testcases := []struct {
+		Name      string
+		Query     HistoryQuery
+		WantPaths []string
+		WantErr   bool
+	}{
+		{Name: "newest first", WantPaths: []string{"/watch/TV/Show/s01e02.mkv", "/watch/TV/Show/s01e01.mkv", "/watch/Movies/foo.mkv"}},
+		{Name: "failed", Query: HistoryQuery{Status: "failed"}, WantPaths: []string{"/watch/TV/Show/s01e01.mkv"}},
+		{Name: "completed", Query: HistoryQuery{Status: "completed", Sort: "slowest"}, WantPaths: []string{"/watch/TV/Show/s01e02.mkv", "/watch/Movies/foo.mkv"}},
+		{Name: "time range", Query: HistoryQuery{Since: start.Add(time.Minute), Until: start.Add(90 * time.Minute)}, WantPaths: []string{"/watch/TV/Show/s01e01.mkv"}},
+		{Name: "path pattern", Query: HistoryQuery{Path: "/watch/TV/*/*", Sort: "savings", Limit: 1}, WantPaths: []string{"/watch/TV/Show/s01e02.mkv"}},
+		{Name: "invalid status", Query: HistoryQuery{Status: "running"}, WantErr: true},
+		{Name: "invalid pattern", Query: HistoryQuery{Path: "/watch/["}, WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			result, err := e.Query(tc.Query)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatal("expected the query to be invalid")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			var paths []string
+			for _, r := range result.Records {
+				paths = append(paths, r.Path)
+			}
+			if len(paths) != len(tc.WantPaths) {
+				t.Fatalf("expected %v, got %v", tc.WantPaths, paths)
+			}
+			for i := range paths {
+				if paths[i] != tc.WantPaths[i] {
+					t.Fatalf("expected %v, got %v", tc.WantPaths, paths)
+				}
+			}
+		})
+	}
+
+	result, err := e.Query(HistoryQuery{Limit: 1})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := HistorySummary{Videos: 3, Failed: 1, EncodeSeconds: 900, TotalSeconds: 1630, EncodedBytes: 8 * gigabyte, OutputBytes: gigabyte * 3 / 2, SavedBytes: gigabyte * 9 / 2}
+	if result.Summary != want {
+		t.Fatalf("expected the summary to cover every selected video, got %#v", result.Summary)
+	}
+}
+
+func TestEncodeEstimator_Compact(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	historyFile := filepath.Join(tmpDir, "history.jsonl")
+	e, err := NewEncodeEstimator(historyFile, "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	now := time.Now()
+	e.Add(TimingRecord{Path: "/watch/old.mkv", DetectedAt: now.Add(-48 * time.Hour), Preset: "tivo", EncodedSize: gigabyte, Encode: 3600})
+	e.Add(TimingRecord{Path: "/watch/foo.mkv", DetectedAt: now.Add(-2 * time.Hour), Preset: "tivo", EncodedSize: gigabyte, Encode: 600})
+	e.Add(TimingRecord{Path: "/watch/bar.mkv", DetectedAt: now.Add(-time.Hour), Preset: "tivo", EncodedSize: gigabyte, Failed: true})
+	e.Add(TimingRecord{Path: "/watch/baz.mkv", DetectedAt: now, Preset: "tivo", EncodedSize: gigabyte, Encode: 600})
+
+	e.Retention, e.MaxRecords = 24*time.Hour, 2
+	err = e.Compact()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	restarted, err := NewEncodeEstimator(historyFile, "tivo", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	result, err := restarted.Query(HistoryQuery{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(result.Records) != 2 || result.Records[0].Path != "/watch/baz.mkv" || result.Records[1].Path != "/watch/bar.mkv" {
+		t.Fatalf("expected the newest 2 videos to be kept, got %#v", result.Records)
+	}
+	if got, _ := e.Estimate("tivo", gigabyte); got != 10*time.Minute {
+		t.Fatalf("expected the estimates to be fit to the videos that were kept, got %s", got)
+	}
+}
+
+func TestEncodeEstimator_Encrypted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cipher, err := fs.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	historyFile := filepath.Join(tmpDir, "history.jsonl")
+	e, err := NewEncodeEstimator(historyFile, "tivo", cipher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	e.Add(TimingRecord{Path: "/watch/foo.mkv", DetectedAt: time.Now(), Preset: "tivo", EncodedSize: gigabyte, Encode: 600})
+
+	// A record appended by anyone else isn't trusted
+	f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	f.WriteString(`{"path":"/watch/forged.mkv"}` + "\n")
+	f.Close()
+
+	raw, err := ioutil.ReadFile(historyFile)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if strings.Contains(string(raw), "foo.mkv") {
+		t.Fatalf("expected the history to be encrypted, got %q", raw)
+	}
+
+	restarted, err := NewEncodeEstimator(historyFile, "tivo", cipher)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	result, err := restarted.Query(HistoryQuery{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(result.Records) != 1 || result.Records[0].Path != "/watch/foo.mkv" {
+		t.Fatalf("expected only the encrypted record to be loaded, got %#v", result.Records)
+	}
+}
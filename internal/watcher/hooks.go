@@ -0,0 +1,167 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/notify"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Hook is a command that is run for each video, such as a virus scan before it is
+// transcoded, or a script that updates a database after it is uploaded. The command
+// is split on spaces, and each argument is a template of the HookValues, for example
+// `clamscan --no-summary {{.Input}}`.
+type Hook struct {
+	// Command is the unparsed command, used to identify the hook in errors.
+	Command string
+
+	// Timeout is how long the command may run before it is killed and fails. 0 disables the timeout.
+	Timeout time.Duration
+
+	args []*template.Template
+}
+
+// HookValues are the paths of a video that are available to a hook.
+type HookValues struct {
+	// Input is the raw video. Before the transcode it is the claimed video, and afterwards
+	// it is where the video was found in the watch directory, which may no longer exist.
+	Input string
+
+	// Output is the transcoded video. Before the transcode it does not exist yet, and afterwards
+	// it is the video uploaded to the Plex share.
+	Output string
+}
+
+// ParseHook parses the command of a hook. An empty command is a hook that does nothing.
+func ParseHook(command string) (Hook, error) {
+	h := Hook{Command: command}
+	for _, field := range strings.Fields(command) {
+		arg, err := template.New(field).Option("missingkey=error").Parse(field)
+		if err != nil {
+			return Hook{}, errors.Wrapf(err, "unable to parse the hook %q", command)
+		}
+		h.args = append(h.args, arg)
+	}
+	return h, nil
+}
+
+// IsSet determines if the hook has a command to run.
+func (h Hook) IsSet() bool {
+	return len(h.args) > 0
+}
+
+// Run the command of the hook with the values of a video. Fails when the
+// command fails, with its output. Does nothing when the hook isn't set.
+func (h Hook) Run(ctx context.Context, values HookValues) error {
+	if !h.IsSet() {
+		return nil
+	}
+
+	args := make([]string, len(h.args))
+	for i, arg := range h.args {
+		var b bytes.Buffer
+		err := arg.Execute(&b, values)
+		if err != nil {
+			return errors.Wrapf(err, "unable to render the hook %q", h.Command)
+		}
+		args[i] = b.String()
+	}
+
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "the hook %q failed for %s: %s", h.Command, values.Input, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runPostHook runs the hook after a video was uploaded. A failed hook doesn't undo
+// the upload, it is logged, and sent to the notifier when there is one.
+func runPostHook(ctx context.Context, hook Hook, values HookValues, notifier notify.Notifier) {
+	err := hook.Run(ctx, values)
+	if err == nil {
+		return
+	}
+	logln(ctx, err)
+	if notifier != nil {
+		if nerr := notifier.Notify(tag(ctx, err.Error())); nerr != nil {
+			logln(ctx, nerr)
+		}
+	}
+}
+
+// postHookValues are the paths of a video, found at path in the watch directory,
+// after it is uploaded to the destination path in the library's share, or the output bucket.
+func (s *JobSink) postHookValues(path, library, destSuffix string) HookValues {
+	share, _ := s.Outputs.For(library, s.PlexCfg.Share, s.Organize)
+	return HookValues{Input: path, Output: s.OutputBucket.destination(share, nil).Location(destSuffix)}
+}
+
+// maxPostHookRetries is how many times in a row the upload job of a video may fail to be checked,
+// backing off each time, before the post hook is left for the watcher to run once it restarts.
+const maxPostHookRetries = 5
+
+// runPostHookAfterUpload waits for the upload job of a video to complete, and then
+// runs the post hook. Stops without running the hook when the job fails, is removed,
+// or the watcher is closed. When the job can't be checked, it is checked again with a
+// backoff, and after maxPostHookRetries the job is left with its PostHookPending annotation,
+// so that the hook is run by Reconcile once the watcher restarts.
+func (s *JobSink) runPostHookAfterUpload(ctx context.Context, target JobTarget, uploadJobName string, values HookValues) {
+	client := s.jobsClient(target)
+	wait := jobPollInterval
+	failures := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		upload, err := client.Get(uploadJobName, target.Namespace)
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			logln(ctx, err)
+			return
+		}
+		if err != nil {
+			failures++
+			if failures > maxPostHookRetries {
+				logln(ctx, errors.Wrapf(err, "giving up on the post hook of %s until the watcher restarts", uploadJobName))
+				return
+			}
+			wait *= 2
+			logln(ctx, errors.Wrapf(err, "unable to check %s for its post hook, checking again in %s", uploadJobName, wait))
+			continue
+		}
+		wait, failures = jobPollInterval, 0
+
+		if jobs.IsFailed(upload) {
+			return
+		}
+		if upload.Status.CompletionTime != nil {
+			s.finishPostHook(ctx, target, uploadJobName, values)
+			return
+		}
+	}
+}
+
+// finishPostHook runs the post hook for the video of a completed upload job, and then
+// records on the job that it ran, so that it isn't run again when the watcher restarts.
+func (s *JobSink) finishPostHook(ctx context.Context, target JobTarget, uploadJobName string, values HookValues) {
+	runPostHook(ctx, s.PostHook, values, s.Notifier)
+	err := s.jobsClient(target).Annotate(uploadJobName, target.Namespace, map[string]string{jobs.PostHookAnnotation: jobs.PostHookDone})
+	if err != nil {
+		logln(ctx, err)
+	}
+}
@@ -0,0 +1,145 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseHook(t *testing.T) {
+	testcases := []struct {
+		Name, Command string
+		WantSet       bool
+		WantErr       bool
+	}{
+		{Name: "empty", Command: ""},
+		{Name: "template", Command: "clamscan --no-summary {{.Input}}", WantSet: true},
+		{Name: "invalid template", Command: "clamscan {{.Input", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := ParseHook(tc.Command)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatal("expected the hook to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if h.IsSet() != tc.WantSet {
+				t.Fatalf("expected IsSet to be %t", tc.WantSet)
+			}
+		})
+	}
+}
+
+func TestHook_Run(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	output := filepath.Join(tmpDir, "foo.mkv")
+	h, err := ParseHook("touch {{.Output}}")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = h.Run(context.Background(), HookValues{Input: "/watch/foo.mkv", Output: output})
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("expected the hook to run with the output path: %s", err)
+	}
+
+	// A failed hook reports its output
+	h, err = ParseHook("ls {{.Input}}")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = h.Run(context.Background(), HookValues{Input: filepath.Join(tmpDir, "missing.mkv")})
+	if err == nil || !strings.Contains(err.Error(), "missing.mkv") {
+		t.Fatalf("expected the hook to fail with its output, got %v", err)
+	}
+
+	// A hook that runs too long is stopped
+	h, err = ParseHook("sleep 5")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	h.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if err := h.Run(context.Background(), HookValues{}); err == nil {
+		t.Fatal("expected the hook to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the hook to be stopped after its timeout, took %s", elapsed)
+	}
+
+	// An unset hook does nothing
+	if err := (Hook{}).Run(context.Background(), HookValues{}); err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func TestJobSink_runPostHookAfterUpload(t *testing.T) {
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 10 * time.Millisecond
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	s.PostHook, err = ParseHook("touch {{.Output}}")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	upload := &batchv1.Job{}
+	upload.Name = "foo-mkv-upload"
+	upload.Annotations = map[string]string{jobs.PostHookAnnotation: jobs.PostHookPending}
+	completed := metav1.Now()
+	upload.Status.CompletionTime = &completed
+	cluster.jobs[upload.Name] = upload
+
+	// The upload job is checked again after it couldn't be checked
+	cluster.getFailures = maxPostHookRetries
+	output := filepath.Join(tmpDir, "foo.mkv")
+	s.runPostHookAfterUpload(context.Background(), s.Targets.Default, upload.Name, HookValues{Output: output})
+	if _, err := os.Stat(output); err != nil {
+		t.Fatalf("expected the post hook to run once the upload job could be checked: %s", err)
+	}
+	if j, _ := cluster.Get(upload.Name, ""); j.Annotations[jobs.PostHookAnnotation] != jobs.PostHookDone {
+		t.Fatalf("expected the post hook to be recorded as done, got %v", j.Annotations)
+	}
+
+	// The post hook is left pending when the upload job can't be checked for too long
+	os.Remove(output)
+	upload.Annotations = map[string]string{jobs.PostHookAnnotation: jobs.PostHookPending}
+	cluster.jobs[upload.Name] = upload
+	cluster.getFailures = maxPostHookRetries + 1
+	s.runPostHookAfterUpload(context.Background(), s.Targets.Default, upload.Name, HookValues{Output: output})
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Fatalf("expected the post hook to not run, got %v", err)
+	}
+	if j, _ := cluster.Get(upload.Name, ""); j.Annotations[jobs.PostHookAnnotation] != jobs.PostHookPending {
+		t.Fatalf("expected the post hook to be left pending, got %v", j.Annotations)
+	}
+}
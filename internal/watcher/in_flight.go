@@ -0,0 +1,176 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// Load is the work currently in the pipeline, to scale the cluster, or alert when it is saturated.
+type Load struct {
+	// Stabilizing is the number of files that the directory watcher is waiting on to
+	// stop changing, when the directory watcher reports them.
+	Stabilizing int
+
+	// Queued is the number of stable videos held while the watcher is paused, or outside of its schedule.
+	Queued int
+
+	// Handling is the number of stable videos that were handed to the sinks, and that the sinks
+	// haven't finished with yet, such as the videos waiting for a local transcode.
+	Handling int
+
+	// InFlight is the number of jobs that were created for the videos and haven't finished,
+	// or of videos being transcoded and uploaded on the current host.
+	InFlight int
+
+	// QueueETA is the estimated time to encode the stable videos that haven't been submitted yet,
+	// when an EncodeEstimator is used.
+	QueueETA time.Duration
+}
+
+// inFlightKey identifies a job by its name, or a video processed on the current host by its path.
+// A batch job is counted once, even though it is created for each video in the batch.
+type inFlightKey struct {
+	job, path string
+}
+
+// inFlightTracker counts the jobs and local transcodes that haven't finished.
+type inFlightTracker struct {
+	mu      sync.Mutex
+	running map[inFlightKey]bool
+}
+
+func (t *inFlightTracker) start(key inFlightKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running == nil {
+		t.running = make(map[inFlightKey]bool)
+	}
+	t.running[key] = true
+}
+
+func (t *inFlightTracker) stop(key inFlightKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.running, key)
+}
+
+func (t *inFlightTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.running)
+}
+
+// Load reports how many files are still changing, how many videos are queued or
+// being handled, and how many jobs or local transcodes are in flight.
+func (w *VideoWatcher) Load() Load {
+	w.mu.Lock()
+	load := Load{Queued: len(w.queued)}
+	w.mu.Unlock()
+
+	if s, ok := w.dirWatcher.(fs.Stabilizer); ok {
+		load.Stabilizing = s.Stabilizing()
+	}
+	load.InFlight = w.inFlight.count()
+	load.Handling = int(atomic.LoadInt64(&w.handling))
+	load.QueueETA = w.QueueETA()
+	return load
+}
+
+// Pending is the number of videos held by the watcher: still changing, queued, or being handled by the sinks.
+func (l Load) Pending() int {
+	return l.Stabilizing + l.Queued + l.Handling
+}
+
+// jobPollInterval is how often the jobs for a video are checked, to report their progress and
+// when they finish.
+var jobPollInterval = timingPollInterval
+
+// waitForJobs publishes an EventJobFinished for each job of a video once it finishes, or is removed,
+// and the progress of its running transcode jobs each time they are checked. Once every job completed,
+// and the video was uploaded, an EventCompleted is published. When the claimed video is set, and it is
+// removed before the first job, its transcode, finishes, the jobs are deleted. Stops when the watcher
+// is closed.
+func (s *JobSink) waitForJobs(ctx context.Context, target JobTarget, path, claimPath string, jobNames ...string) {
+	client := s.jobsClient(target)
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	transcodeJobName := jobNames[0]
+	succeeded, uploaded := true, false
+	for len(jobNames) > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if claimPath != "" && s.cancelRemovedClaim(ctx, target, path, claimPath, transcodeJobName, jobNames) {
+			return
+		}
+
+		var running []string
+		for _, name := range jobNames {
+			j, err := client.Get(name, target.Namespace)
+			if err != nil {
+				// Stop counting a job that can't be checked, instead of reporting it in flight forever
+				logln(ctx, err)
+				succeeded = false
+			} else if !jobs.IsFinished(j) {
+				running = append(running, name)
+				publishJobProgress(ctx, client, target.Namespace, path, j)
+				continue
+			}
+			Publish(ctx, PipelineEvent{Type: EventJobFinished, Path: path, Job: name})
+			if err == nil {
+				publishJobResult(ctx, path, j)
+				succeeded = succeeded && !jobs.IsFailed(j)
+				uploaded = uploaded || j.Annotations[jobs.SourceAnnotation] != ""
+			}
+			// The upload job removes the claimed video once it is transcoded
+			if name == transcodeJobName {
+				claimPath = ""
+			}
+		}
+		jobNames = running
+	}
+
+	if succeeded && uploaded {
+		Publish(ctx, PipelineEvent{Type: EventCompleted, Path: path})
+	}
+}
+
+// publishJobProgress sends the latest progress of a running transcode job, logged since it was
+// last checked.
+func publishJobProgress(ctx context.Context, client jobs.Client, namespace, path string, j *batchv1.Job) {
+	// An upload job has the source of its video, and doesn't log any progress
+	if j.Annotations[jobs.SourceAnnotation] != "" {
+		return
+	}
+	progress, ok, err := client.Progress(j.Name, namespace, jobPollInterval+jobPollInterval/2)
+	if err != nil {
+		logln(ctx, err)
+		return
+	}
+	if ok {
+		Publish(ctx, PipelineEvent{Type: EventProgress, Path: path, Job: j.Name, Progress: &progress})
+	}
+}
+
+// publishJobResult sends an event for a failed job, or for the video of a completed upload
+// job, which records where its video was uploaded.
+func publishJobResult(ctx context.Context, path string, j *batchv1.Job) {
+	switch {
+	case jobs.IsFailed(j):
+		Publish(ctx, PipelineEvent{Type: EventFailed, Path: path, Job: j.Name, Err: errors.Errorf("the job %s failed", j.Name)})
+	case j.Annotations[jobs.SourceAnnotation] != "":
+		Publish(ctx, PipelineEvent{Type: EventUploaded, Path: path, Job: j.Name, Output: j.Annotations[jobs.OutputAnnotation]})
+	}
+}
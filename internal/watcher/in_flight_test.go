@@ -0,0 +1,113 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestVideoWatcher_Load(t *testing.T) {
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+
+	w.Pause()
+	files <- fs.FileEvent{Path: "/watch/Movies/foo.mkv"}
+	for i := 0; w.Status().Queued == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if load := w.Load(); load.Queued != 1 || load.InFlight != 0 {
+		t.Fatalf("expected the paused video to be queued, got %#v", load)
+	}
+
+	// A batch transcode job is only counted once
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: "/watch/Movies/foo.mkv", Job: "batch-transcode"})
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: "/watch/Movies/foo.mkv", Job: "foo-upload"})
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: "/watch/Movies/bar.mkv", Job: "batch-transcode"})
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: "/watch/Movies/bar.mkv", Job: "bar-upload"})
+	w.events.publish(PipelineEvent{Type: EventTranscodeStarted, Path: "/watch/Movies/baz.mkv"})
+	if load := w.Load(); load.InFlight != 4 {
+		t.Fatalf("expected 3 jobs and a local transcode to be in flight, got %#v", load)
+	}
+
+	w.events.publish(PipelineEvent{Type: EventJobFinished, Path: "/watch/Movies/foo.mkv", Job: "batch-transcode"})
+	w.events.publish(PipelineEvent{Type: EventJobFinished, Path: "/watch/Movies/foo.mkv", Job: "foo-upload"})
+	w.finish(fs.FileEvent{Path: "/watch/Movies/baz.mkv"}, false)
+	if load := w.Load(); load.InFlight != 1 {
+		t.Fatalf("expected only the bar-upload job to be in flight, got %#v", load)
+	}
+}
+
+func TestJobSink_waitForJobs(t *testing.T) {
+	defer func(interval time.Duration) { jobPollInterval = interval }(jobPollInterval)
+	jobPollInterval = 10 * time.Millisecond
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	cluster.progress = &handbrake.Progress{Task: 1, Tasks: 1, Percent: 42}
+	s.Jobs = cluster
+
+	transcode := &batchv1.Job{}
+	transcode.Name = "foo-mkv-transcode"
+	upload := &batchv1.Job{}
+	upload.Name = "foo-mkv-upload"
+	upload.Annotations = map[string]string{jobs.SourceAnnotation: "/watch/Movies/foo.mkv", jobs.OutputAnnotation: "/plex/Movies/foo.mkv"}
+	cluster.jobs[transcode.Name] = transcode
+	cluster.jobs[upload.Name] = upload
+
+	events := &broker{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), brokerKey{}, events))
+	defer cancel()
+	sub := events.subscribe()
+	go s.waitForJobs(ctx, JobTarget{}, "/watch/Movies/foo.mkv", "", transcode.Name, upload.Name)
+
+	e := nextEvent(t, sub, EventProgress)
+	if e.Job != transcode.Name || e.Progress == nil || e.Progress.Percent != 42 {
+		t.Fatalf("expected the progress of the transcode job, got %#v", e)
+	}
+
+	completed := batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}
+	cluster.mu.Lock()
+	for _, name := range []string{transcode.Name, upload.Name} {
+		j := cluster.jobs[name].DeepCopy()
+		j.Status.Conditions = []batchv1.JobCondition{completed}
+		cluster.jobs[name] = j
+	}
+	cluster.mu.Unlock()
+
+	nextEvent(t, sub, EventUploaded)
+	e = nextEvent(t, sub, EventCompleted)
+	if e.Path != "/watch/Movies/foo.mkv" {
+		t.Fatalf("expected the video to be completed, got %#v", e)
+	}
+}
+
+// nextEvent waits for the next event of the type, skipping the events of other types.
+func nextEvent(t *testing.T, sub <-chan PipelineEvent, eventType PipelineEventType) PipelineEvent {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-sub:
+			if e.Type == eventType {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %s event", eventType)
+		}
+	}
+}
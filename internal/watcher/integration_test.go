@@ -0,0 +1,663 @@
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/notify"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// fakeEncoder stands in for HandBrakeCLI, copying the input to the output.
+const fakeEncoder = `#!/bin/sh
+while [ $# -gt 0 ]; do
+  case "$1" in
+    -i) input="$2" ;;
+    -o) output="$2" ;;
+  esac
+  shift
+done
+sleep 0.1
+mkdir -p "$(dirname "$output")"
+cp "$input" "$output"
+`
+
+// fakeCluster runs jobs in memory. Transcode jobs are run with a fake encoder,
+// and the jobs that wait for them are run with a fake uploader once they complete.
+type fakeCluster struct {
+	t       *testing.T
+	encoder string
+	err     error
+
+	// progress is reported for every running job, when it is set.
+	progress *handbrake.Progress
+
+	// getFailures is how many of the next checks of a job fail.
+	getFailures int
+
+	mu   sync.Mutex
+	jobs map[string]*batchv1.Job
+
+	// completed is closed when a transcode job completes.
+	completed map[string]chan struct{}
+
+	// uploaded signals the path of each video uploaded to Plex.
+	uploaded chan string
+}
+
+func newFakeCluster(t *testing.T, dir string) *fakeCluster {
+	encoder := filepath.Join(dir, "HandBrakeCLI")
+	err := ioutil.WriteFile(encoder, []byte(fakeEncoder), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	return &fakeCluster{
+		t:         t,
+		encoder:   encoder,
+		jobs:      make(map[string]*batchv1.Job),
+		completed: make(map[string]chan struct{}),
+		uploaded:  make(chan string, 10),
+	}
+}
+
+func (c *fakeCluster) CreateOrReplace(j *batchv1.Job) (string, error) {
+	if c.err != nil {
+		return "", c.err
+	}
+
+	c.mu.Lock()
+	c.jobs[j.Name] = j
+	var completed chan struct{}
+	if strings.HasSuffix(j.Name, "-transcode") {
+		completed = make(chan struct{})
+		c.completed[j.Name] = completed
+	}
+	waitFor, ok := c.completed[j.Labels[jobs.WaitsForLabel]]
+	c.mu.Unlock()
+
+	if completed != nil {
+		go c.transcode(j, completed)
+	}
+	if ok {
+		go func() {
+			<-waitFor
+			c.upload(j)
+		}()
+	}
+	return j.Name, nil
+}
+
+func (c *fakeCluster) Delete(name, namespace string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.jobs, name)
+	return nil
+}
+
+func (c *fakeCluster) Get(name, namespace string) (*batchv1.Job, error) {
+	c.mu.Lock()
+	if c.getFailures > 0 {
+		c.getFailures--
+		c.mu.Unlock()
+		return nil, errors.Errorf("unable to get %s/%s: the server is unavailable", namespace, name)
+	}
+	c.mu.Unlock()
+
+	if j := c.getJob(name); j != nil {
+		return j, nil
+	}
+	return nil, errors.Errorf("%s/%s not found", namespace, name)
+}
+
+func (c *fakeCluster) List(namespace string) ([]batchv1.Job, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var list []batchv1.Job
+	for _, j := range c.jobs {
+		if j.Labels[jobs.ManagedByLabel] == jobs.ManagedBy {
+			list = append(list, *j)
+		}
+	}
+	return list, nil
+}
+
+func (c *fakeCluster) Annotate(name, namespace string, annotations map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	j, ok := c.jobs[name]
+	if !ok {
+		return errors.Errorf("%s/%s not found", namespace, name)
+	}
+	annotated := j.DeepCopy()
+	if annotated.Annotations == nil {
+		annotated.Annotations = make(map[string]string)
+	}
+	for k, v := range annotations {
+		annotated.Annotations[k] = v
+	}
+	c.jobs[name] = annotated
+	return nil
+}
+
+func (c *fakeCluster) Progress(name, namespace string, since time.Duration) (handbrake.Progress, bool, error) {
+	if c.progress == nil {
+		return handbrake.Progress{}, false, nil
+	}
+	return *c.progress, true, nil
+}
+
+// WatchCancelled never signals a cancelled job, the tests finish them with finishCancelledJob.
+func (c *fakeCluster) WatchCancelled(done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error) {
+	return nil, nil
+}
+
+// RequeueDisrupted, ArchiveLogs and MonitorTranscodes stop right away, the fake jobs are
+// never disrupted and have no logs.
+func (c *fakeCluster) RequeueDisrupted(done <-chan struct{}, namespace string, maxRequeues int) <-chan error {
+	return stopped()
+}
+
+func (c *fakeCluster) ArchiveLogs(done <-chan struct{}, namespace, dir string) <-chan error {
+	return stopped()
+}
+
+func (c *fakeCluster) MonitorTranscodes(done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error {
+	return stopped()
+}
+
+func stopped() <-chan error {
+	errChan := make(chan error)
+	close(errChan)
+	return errChan
+}
+
+func (c *fakeCluster) getJob(name string) *batchv1.Job {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.jobs[name]
+}
+
+// listJobs returns the names of the jobs with the suffix.
+func (c *fakeCluster) listJobs(suffix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var names []string
+	for name := range c.jobs {
+		if strings.HasSuffix(name, suffix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// transcode runs the container with the fake encoder in place of HandBrakeCLI.
+func (c *fakeCluster) transcode(j *batchv1.Job, completed chan struct{}) {
+	defer close(completed)
+
+	container := j.Spec.Template.Spec.Containers[0]
+	var cmd *exec.Cmd
+	if len(container.Command) > 0 {
+		cmd = exec.Command(container.Command[0], append(container.Command[1:], container.Args...)...)
+		cmd.Env = append(os.Environ(), "PATH="+filepath.Dir(c.encoder)+":"+os.Getenv("PATH"))
+		for _, env := range container.Env {
+			cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+		}
+	} else {
+		cmd = exec.Command(c.encoder, container.Args...)
+	}
+
+	output, err := cmd.CombinedOutput()
+	condition := batchv1.JobComplete
+	if err != nil {
+		c.t.Errorf("transcode failed: %s\n%s", err, output)
+		condition = batchv1.JobFailed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.jobs[j.Name]; ok {
+		finished := j.DeepCopy()
+		finished.Status.Conditions = append(finished.Status.Conditions, batchv1.JobCondition{Type: condition, Status: corev1.ConditionTrue})
+		c.jobs[j.Name] = finished
+	}
+}
+
+// upload mimics the uploader, moving the transcoded file to the Plex share
+// and removing the raw file.
+func (c *fakeCluster) upload(j *batchv1.Job) {
+	flags := parseArgs(j.Spec.Template.Spec.Containers[0].Args)
+	uploadPath := filepath.Join(flags["--plex-share"], flags["--suffix"])
+	err := fs.MoveFile(flags["-f"], uploadPath)
+	if err != nil {
+		c.t.Errorf("%+v", err)
+		return
+	}
+	err = os.Remove(flags["--raw"])
+	if err != nil {
+		c.t.Errorf("%+v", err)
+		return
+	}
+	c.uploaded <- uploadPath
+}
+
+// parseArgs converts a list of flags and values into a map
+func parseArgs(args []string) map[string]string {
+	flags := make(map[string]string)
+	for i := 0; i+1 < len(args); i += 2 {
+		flags[args[i]] = args[i+1]
+	}
+	return flags
+}
+
+// newTestJobSink creates the volumes used by the watcher in a temporary directory.
+func newTestJobSink(t *testing.T, tmpDir string) *JobSink {
+	configVolume := filepath.Join(tmpDir, "config")
+	watchVolume := filepath.Join(tmpDir, "watch")
+	workVolume := filepath.Join(tmpDir, "work")
+	plexVolume := filepath.Join(tmpDir, "plex")
+	for _, dir := range []string{configVolume, watchVolume, workVolume, plexVolume} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	templates, err := filepath.Glob(filepath.Join(templatesDir, "*.yaml"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	for _, template := range templates {
+		err = fs.CopyFile(template, filepath.Join(configVolume, "templates", filepath.Base(template)))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	plexCfg := plex.LibraryConfig{Share: plexVolume}
+	plexCfg.URL = "http://plex:32400"
+	plexCfg.Token = "abc123"
+	s, err := NewJobSink(configVolume, watchVolume, workVolume, "tivo", plexCfg)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return s
+}
+
+func TestIntegration_TranscodeAndUpload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+
+	rawPath := filepath.Join(s.WatchDir, "Movies", "foo.mkv")
+	err = os.MkdirAll(filepath.Dir(rawPath), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w := newTestVideoWatcher(t, s.WatchDir, s)
+	defer w.Close()
+
+	err = ioutil.WriteFile(rawPath, []byte("raw video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var uploadPath string
+	select {
+	case uploadPath = <-cluster.uploaded:
+	case err := <-w.Errors:
+		t.Fatalf("%+v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the video to be uploaded to Plex")
+	}
+
+	wantUploadPath := filepath.Join(s.PlexCfg.Share, "Movies", "foo.mkv")
+	if uploadPath != wantUploadPath {
+		t.Fatalf("expected the video to be uploaded to %s, got %s", wantUploadPath, uploadPath)
+	}
+	contents, err := ioutil.ReadFile(uploadPath)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if string(contents) != "raw video" {
+		t.Fatalf("expected the transcoded video to be uploaded, got %q", contents)
+	}
+
+	for _, path := range []string{
+		rawPath,
+		filepath.Join(s.ClaimDir, "Movies", "foo.mkv"),
+		filepath.Join(s.TranscodedDir, "Movies", "foo.mkv"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be cleaned up", path)
+		}
+	}
+
+	transcodeJob := cluster.getJob("foo-mkv-transcode")
+	if transcodeJob == nil {
+		t.Fatal("expected a transcode job")
+	}
+	flags := parseArgs(transcodeJob.Spec.Template.Spec.Containers[0].Args)
+	if flags["--preset"] != "tivo" {
+		t.Fatalf("expected the tivo preset, got %s", flags["--preset"])
+	}
+	if cluster.getJob("foo-mkv-upload") == nil {
+		t.Fatal("expected an upload job")
+	}
+}
+
+func TestIntegration_Batch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	s.BatchMaxFileSize = 1024
+	s.BatchSize = 2
+	s.BatchWindow = time.Minute
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+
+	tvDir := filepath.Join(s.WatchDir, "TV")
+	err = os.MkdirAll(tvDir, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	w := newTestVideoWatcher(t, s.WatchDir, s)
+	defer w.Close()
+
+	for _, name := range []string{"one.mkv", "two.mkv"} {
+		err = ioutil.WriteFile(filepath.Join(tvDir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	uploads := make(map[string]bool)
+	for len(uploads) < 2 {
+		select {
+		case uploadPath := <-cluster.uploaded:
+			uploads[uploadPath] = true
+		case err := <-w.Errors:
+			t.Fatalf("%+v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected both videos to be uploaded to Plex, got %v", uploads)
+		}
+	}
+
+	for _, name := range []string{"one.mkv", "two.mkv"} {
+		uploadPath := filepath.Join(s.PlexCfg.Share, "TV", name)
+		contents, err := ioutil.ReadFile(uploadPath)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		if string(contents) != name {
+			t.Fatalf("expected the transcoded %s to be uploaded, got %q", name, contents)
+		}
+	}
+
+	transcodeJobs := cluster.listJobs("-transcode")
+	if len(transcodeJobs) != 1 {
+		t.Fatalf("expected a single batch transcode job, got %v", transcodeJobs)
+	}
+	j := cluster.getJob(transcodeJobs[0])
+	if len(j.Spec.Template.Spec.Containers[0].Args) != 8 {
+		t.Fatalf("expected both videos in the batch, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+	if uploadJobs := cluster.listJobs("-upload"); len(uploadJobs) != 2 {
+		t.Fatalf("expected an upload job for each video, got %v", uploadJobs)
+	}
+}
+
+func TestIntegration_CreateJobFailed(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	cluster.err = errors.New("cluster is unavailable")
+	s.Jobs = cluster
+
+	w := newTestVideoWatcher(t, s.WatchDir, s)
+	defer w.Close()
+
+	createFile(t, filepath.Join(s.WatchDir, "foo.mkv"))
+
+	select {
+	case <-w.Errors:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the failure to be reported")
+	}
+
+	failedPath := filepath.Join(s.FailedDir, "foo.mkv")
+	if _, err := os.Stat(failedPath); err != nil {
+		t.Fatalf("expected the video to be moved to %s: %s", failedPath, err)
+	}
+}
+
+// newFakePlex serves a Movies library, which contains every video on the shares.
+func newFakePlex(shares ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/library/sections":
+			fmt.Fprint(w, `<MediaContainer><Directory key="1" title="Movies" type="movie"/></MediaContainer>`)
+		case "/library/sections/1/refresh":
+		case "/library/sections/1/all":
+			var videos []string
+			for _, share := range shares {
+				found, _ := filepath.Glob(filepath.Join(share, "Movies", "*"))
+				videos = append(videos, found...)
+			}
+			fmt.Fprint(w, "<MediaContainer>")
+			for i, video := range videos {
+				fmt.Fprintf(w, `<Video key="/library/metadata/%d"><Media><Part file="%s"/></Media></Video>`, i, video)
+			}
+			fmt.Fprint(w, "</MediaContainer>")
+		default:
+			// The details of a video are checked for extras, when a different video is in its directory
+			if strings.HasPrefix(r.URL.Path, "/library/metadata/") {
+				fmt.Fprintf(w, `<MediaContainer><Video key="%s"/></MediaContainer>`, r.URL.Path)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestIntegration_Local(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	js := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	plexSrv := newFakePlex(js.PlexCfg.Share)
+	defer plexSrv.Close()
+
+	plexCfg := js.PlexCfg
+	plexCfg.URL = plexSrv.URL
+	s, err := NewLocalSink(filepath.Join(tmpDir, "watch"), filepath.Join(tmpDir, "work"), "tivo", plexCfg)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	s.Encoder.CLI = cluster.encoder
+
+	rawPath := filepath.Join(s.WatchDir, "Movies", "foo.mkv")
+	err = os.MkdirAll(filepath.Dir(rawPath), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	handled := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, s.WatchDir, s, handled)
+	defer w.Close()
+
+	err = ioutil.WriteFile(rawPath, []byte("raw video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	uploadPath := filepath.Join(s.PlexCfg.Share, "Movies", "foo.mkv")
+	select {
+	case <-handled.events:
+	case err := <-w.Errors:
+		t.Fatalf("%+v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the video to be uploaded to Plex")
+	}
+
+	contents, err := ioutil.ReadFile(uploadPath)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if string(contents) != "raw video" {
+		t.Fatalf("expected the transcoded video to be uploaded, got %q", contents)
+	}
+
+	for _, path := range []string{
+		rawPath,
+		filepath.Join(s.ClaimDir, "Movies", "foo.mkv"),
+		filepath.Join(s.TranscodedDir, "Movies", "foo.mkv"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be cleaned up", path)
+		}
+	}
+}
+
+func TestIntegration_LocalDevices(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	js := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	tvDir, phoneDir := filepath.Join(tmpDir, "tv"), filepath.Join(tmpDir, "phone")
+	plexSrv := newFakePlex(tvDir, phoneDir)
+	defer plexSrv.Close()
+
+	plexCfg := js.PlexCfg
+	plexCfg.URL = plexSrv.URL
+	s, err := NewLocalSink(filepath.Join(tmpDir, "watch"), filepath.Join(tmpDir, "work"), "tivo", plexCfg)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	s.Encoder.CLI = cluster.encoder
+	s.Devices = DeviceProfiles{
+		Devices: []DeviceProfile{
+			{Name: "tv", Preset: "H.265 MKV 2160p60", Output: tvDir},
+			{Name: "phone", Preset: "Fast 480p30", Container: ".mp4", Output: phoneDir},
+		},
+		Rules: []DeviceRule{{Devices: []string{"tv", "phone"}}},
+	}
+
+	rawPath := filepath.Join(s.WatchDir, "Movies", "foo.mkv")
+	err = os.MkdirAll(filepath.Dir(rawPath), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	handled := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, s.WatchDir, s, handled)
+	defer w.Close()
+
+	err = ioutil.WriteFile(rawPath, []byte("raw video"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case <-handled.events:
+	case err := <-w.Errors:
+		t.Fatalf("%+v", err)
+	case <-time.After(20 * time.Second):
+		t.Fatal("expected the video to be uploaded for each device")
+	}
+
+	for _, uploadPath := range []string{
+		filepath.Join(tvDir, "Movies", "foo.mkv"),
+		filepath.Join(phoneDir, "Movies", "foo.mp4"),
+	} {
+		contents, err := ioutil.ReadFile(uploadPath)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		if string(contents) != "raw video" {
+			t.Fatalf("expected the transcoded video to be uploaded to %s, got %q", uploadPath, contents)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.PlexCfg.Share, "Movies", "foo.mkv")); !os.IsNotExist(err) {
+		t.Fatal("expected the video to only be uploaded to the outputs of its devices")
+	}
+
+	for _, path := range []string{
+		rawPath,
+		filepath.Join(s.ClaimDir, "Movies", "foo.mkv"),
+		filepath.Join(s.TranscodedDir, "tv", "Movies", "foo.mkv"),
+		filepath.Join(s.TranscodedDir, "phone", "Movies", "foo.mp4"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be cleaned up", path)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// DefaultIntegrityTolerance is how much shorter than its reported duration a video may be read.
+const DefaultIntegrityTolerance = 5 * time.Second
+
+// FailedReasonSuffix is appended to the path of a video in the failed directory for the
+// file that explains why it failed, when the reason is known before it is transcoded.
+const FailedReasonSuffix = ".reason"
+
+// IntegrityCheck verifies that each claimed video is complete before it is transcoded,
+// catching a video that is stable but broken, such as an interrupted download.
+type IntegrityCheck struct {
+	// Prober runs ffprobe on the videos. When nil, videos are not checked.
+	Prober *ffprobe.Prober
+
+	// Tolerance is how much shorter than its reported duration a video may be read.
+	Tolerance time.Duration
+}
+
+// Check the video, returning an ffprobe.IncompleteError when it is incomplete.
+func (c IntegrityCheck) Check(path string) error {
+	if c.Prober == nil {
+		return nil
+	}
+	return c.Prober.CheckIntegrity(path, c.Tolerance)
+}
+
+// quarantineClaim moves a claimed video to the failed directory, next to a file with the reason.
+func quarantineClaim(sandbox *fs.Sandbox, claimDir, failedDir, claimPath string, reason error) {
+	cleanupFailedClaim(sandbox, claimDir, failedDir, claimPath)
+
+	pathSuffix := strings.Replace(claimPath, claimDir, "", 1)
+	reasonPath := filepath.Join(failedDir, pathSuffix) + FailedReasonSuffix
+	err := sandbox.Check(reasonPath)
+	if err == nil {
+		err = ioutil.WriteFile(reasonPath, []byte(reason.Error()+"\n"), 0644)
+	}
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to write the reason that %s failed", claimPath))
+	}
+}
@@ -0,0 +1,648 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/notify"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const Namespace = "handbrk8s"
+
+// JobSink claims each video and creates the jobs to transcode it and upload it to Plex.
+type JobSink struct {
+	// WatchDir contains raw (untranscoded) video files.
+	WatchDir string
+
+	// ClaimDir temporarily holds raw video files while they are being transcoded.
+	ClaimDir string
+
+	// TranscodedDir contains completed (transcoded) video files.
+	TranscodedDir string
+
+	// TemplatesDir contains templates for jobs that are created by the watcher.
+	TemplatesDir string
+
+	FailedDir string
+
+	// VideoPreset is the name of a HandBrake preset.
+	VideoPreset string
+
+	// PresetRules select a different preset than VideoPreset for matching videos.
+	PresetRules PresetRules
+
+	// PresetFile is an optional file of custom HandBrake presets, as seen by the transcode job,
+	// which must define the VideoPreset. When empty, the VideoPreset is a built-in preset.
+	PresetFile string
+
+	// QueueSpec is an optional job exported from the HandBrake GUI, which is imported by the
+	// transcode jobs instead of the preset, unless a video has raw args. Videos are never
+	// batched when it is set.
+	QueueSpec *handbrake.QueueSpec
+
+	// Title selects the title of a source with several titles, such as a disc structure. The
+	// transcode jobs scan the source when the rule needs to. Videos are only batched with the
+	// default rule.
+	Title handbrake.TitleRule
+
+	// RestartPolicy of the transcode pods, OnFailure or Never.
+	// See jobs.ValidateRetries for how it interacts with the BackoffLimit.
+	RestartPolicy corev1.RestartPolicy
+
+	// BackoffLimit is the number of failed attempts before a transcode job fails.
+	BackoffLimit int32
+
+	// SuccessExitCodes are the nonzero exit codes of HandBrakeCLI that are treated as a
+	// successful transcode, instead of failing the transcode job.
+	SuccessExitCodes handbrake.ExitCodes
+
+	// DefaultArgs are HandBrakeCLI arguments for every video. They come first, so the args of
+	// the job profile selected by a library or a preset rule, and then the raw args of a video,
+	// override them. Videos are never batched when there are default args.
+	DefaultArgs []string
+
+	// ScanTimeout optionally stops HandBrakeCLI when it hasn't started encoding within this
+	// long, such as when its scan of a malformed video hangs. Videos are never batched when set.
+	ScanTimeout time.Duration
+
+	// TranscodeDeadline is how long a transcode job may run before the cluster stops it
+	// and the job fails. 0 disables the deadline.
+	TranscodeDeadline time.Duration
+
+	// PlexCfg contains connection information upload a file to a Plex server.
+	PlexCfg plex.LibraryConfig
+
+	// PlexRefreshDebounce is how long upload jobs wait to refresh a Plex library,
+	// coalescing the refreshes for videos uploaded around the same time.
+	PlexRefreshDebounce LibraryDurations
+
+	// PlexMinScanInterval is the minimum time between scans of a Plex library, even for
+	// refreshes in separate bursts of uploads. The refreshes due sooner wait for the interval.
+	PlexMinScanInterval LibraryDurations
+
+	// Jobs creates the jobs on the cluster where the watcher is running.
+	Jobs jobs.Client
+
+	// Targets are the namespace, and optionally the kubeconfig context of another
+	// cluster, where the jobs for each library are created.
+	Targets JobTargets
+
+	// Kubeconfig is used to connect to the clusters of targets with a context.
+	// When empty, the default kubeconfig locations are used.
+	Kubeconfig string
+
+	clientsMu      sync.Mutex
+	contextClients map[string]jobs.Client
+
+	// processing is set when the videos are claimed into a processing directory, see UseProcessingDir.
+	processing bool
+
+	// PlexTokenSecret is the name of a secret containing the Plex token.
+	// When set, upload jobs read the token from the secret instead of
+	// having it embedded in the job definition.
+	PlexTokenSecret string
+
+	// PathRewrites maps the paths that the watcher sees to the paths that the jobs see,
+	// for the videos, directories and subtitles passed to the jobs, when the media is
+	// mounted at a different path in the pods than in the watcher.
+	PathRewrites PathRewrites
+
+	// ArchiveDir is an optional directory, as seen by the upload job, where the raw
+	// video files are moved after they are uploaded. When empty, raw video files are removed.
+	ArchiveDir string
+
+	// Processed optionally moves the raw video files back to the watch directory with a
+	// new name after they are uploaded, instead of removing them. Used instead of ArchiveDir.
+	Processed ProcessedName
+
+	// DeletionQueue optionally keeps the raw video files for a grace period after they are
+	// uploaded, instead of removing them. Used when neither ArchiveDir nor Processed are set.
+	DeletionQueue *DeletionQueue
+
+	// CompanionMarkers skip the videos that other tools marked as already processed.
+	CompanionMarkers CompanionMarkers
+
+	// SkipUpToDate leaves a video in the watch directory, instead of processing it, when
+	// it was already uploaded to the Plex share after it was last modified.
+	SkipUpToDate bool
+
+	// VerifyUpToDate hashes an up-to-date video again, when it has a checksum file next to it,
+	// and processes it again when it no longer matches. The videos in a bucket aren't verified.
+	VerifyUpToDate bool
+
+	// ReadOnlySource is set when the watch directory is read-only. The videos are copied
+	// to the claim directory instead of moved, and are only read, the failed videos are
+	// kept on the work volume, and the videos that were already uploaded are skipped.
+	ReadOnlySource bool
+
+	// Organize optionally uploads videos to a different path in the Plex share,
+	// instead of the same path that they had in the watch directory.
+	Organize OrganizeTemplate
+
+	// ArchiveRollback moves the raw video file back out of the archive
+	// when the upload job can't refresh the Plex library.
+	ArchiveRollback bool
+
+	// VerifyArchive only removes a raw video file moved to the archive, or renamed, once the
+	// checksum of the copy matches the original. On a mismatch the original is left in place.
+	VerifyArchive bool
+
+	// MaxSizeRatio treats a transcoded video larger than this ratio of the raw
+	// video's size as a failed transcode, flagging the raw video for review in
+	// the failed directory. 0 disables the check.
+	MaxSizeRatio float64
+
+	// Marker optionally creates a marker file next to each video in the watch directory while it is processed.
+	Marker ProcessingMarker
+
+	// Timings logs how long each step took for every video, as a line of JSON, and publishes it as an EventTiming.
+	Timings bool
+
+	// LogDir is an optional directory where the logs of each job are archived once it
+	// finishes, see ArchiveLogs. The timing of a video references the log file of its
+	// transcode job.
+	LogDir string
+
+	// SpaceCheck holds each video until the transcoded directory has enough free space.
+	SpaceCheck SpaceCheck
+
+	// Integrity optionally moves a claimed video that is incomplete to the failed
+	// directory, with a file explaining why, instead of transcoding it.
+	Integrity IntegrityCheck
+
+	// BurnSubtitles burns the first external subtitle of a video into it, instead of adding
+	// the subtitles as tracks. See VideoWatcher.UseSubtitles.
+	BurnSubtitles bool
+
+	// Checksum records the SHA-256 of each video uploaded to the Plex share in the upload log.
+	Checksum bool
+
+	// ChecksumSidecar also writes the checksum to a .sha256 file next to each uploaded video.
+	ChecksumSidecar bool
+
+	// Profiles are the job profiles selected for the videos by library, or by a preset rule.
+	Profiles JobProfiles
+
+	// ResourceTiers optionally size the transcode pods of the larger videos, by their size or
+	// probed height, overriding the resources of their job profile. Sized videos are never batched.
+	ResourceTiers ResourceTiers
+
+	// Outputs optionally upload the videos of a library to its own directory, instead of the Plex share.
+	Outputs LibraryOutputs
+
+	// OutputBucket optionally uploads the videos to a bucket, instead of the Plex share or the Outputs.
+	OutputBucket OutputBucket
+
+	// Names optionally names the jobs and the transcoded videos, see NameStrategy. Defaults to SanitizedNames.
+	Names NameStrategy
+
+	// Devices optionally transcode the videos that match their rules once for each of their
+	// devices, uploading each to the output of its device, instead of the Plex share.
+	Devices DeviceProfiles
+
+	// Sandbox optionally refuses to modify any file outside of its allowed directories.
+	Sandbox *fs.Sandbox
+
+	// BatchMaxFileSize is the largest video, in bytes, that is transcoded in a batch
+	// with other small videos, in a single pod. When zero, videos are not batched.
+	BatchMaxFileSize int64
+
+	// BatchSize is the maximum number of videos transcoded in a batch.
+	BatchSize int
+
+	// BatchWindow is how long to wait for more small videos before transcoding a batch.
+	BatchWindow time.Duration
+
+	// SubmitInterval is the minimum time between creating jobs, to smooth the load
+	// on the cluster when many videos are found at once. 0 creates jobs immediately.
+	SubmitInterval time.Duration
+	submits        submitThrottle
+
+	// PreHook is run for each claimed video before its jobs are created. When it fails,
+	// the video is moved to the failed directory instead of being transcoded.
+	PreHook Hook
+
+	// PostHook is run for each video once its upload job completes. When it fails,
+	// the failure is logged and sent to the Notifier, and the upload is kept.
+	PostHook Hook
+
+	// Notifier optionally sends an alert when the PostHook fails.
+	Notifier notify.Notifier
+
+	batchMu sync.Mutex
+	batches map[batchKey]*videoBatch
+}
+
+// NewJobSink validates the volumes and creates the directories used to process videos.
+func NewJobSink(configVolume, watchVolume, workVolume string, videoPreset string, plexCfg plex.LibraryConfig) (*JobSink, error) {
+	if _, err := os.Stat(configVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("config volume, %s, is not mounted", configVolume)
+	}
+
+	if _, err := os.Stat(watchVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("watch volume, %s, is not mounted", watchVolume)
+	}
+
+	if _, err := os.Stat(workVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("work volume, %s, is not mounted", workVolume)
+	}
+
+	s := &JobSink{
+		WatchDir:      filepath.Join(watchVolume, "watch"),
+		FailedDir:     filepath.Join(watchVolume, "fail"),
+		ClaimDir:      filepath.Join(workVolume, "claim"),
+		TranscodedDir: filepath.Join(workVolume, "work"),
+		TemplatesDir:  filepath.Join(configVolume, "templates"),
+		VideoPreset:   videoPreset,
+		RestartPolicy: corev1.RestartPolicyOnFailure,
+		BackoffLimit:  20,
+		PlexCfg:       plexCfg,
+		Jobs:          jobs.NewClusterClient(),
+		Targets:       JobTargets{Default: JobTarget{Namespace: Namespace}},
+		BatchSize:     10,
+		BatchWindow:   30 * time.Second,
+	}
+
+	err := os.MkdirAll(s.WatchDir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create watch directory %s", s.WatchDir)
+	}
+
+	s.ReadOnlySource, err = fs.IsReadOnly(s.WatchDir)
+	if err != nil {
+		return nil, err
+	}
+	if s.ReadOnlySource {
+		s.FailedDir = filepath.Join(workVolume, "fail")
+	}
+
+	err = os.MkdirAll(s.FailedDir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create failed directory %s", s.FailedDir)
+	}
+
+	err = os.MkdirAll(s.ClaimDir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create claim directory %s", s.ClaimDir)
+	}
+
+	err = os.MkdirAll(s.TranscodedDir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create transcoded directory %s", s.TranscodedDir)
+	}
+
+	return s, nil
+}
+
+// Handle claims the video and creates jobs to transcode and upload it.
+func (s *JobSink) Handle(ctx context.Context, e fs.FileEvent) (err error) {
+	path := e.Path
+	timing := newTiming(e)
+
+	if isHidden(path) {
+		return Reject(RejectHidden)
+	}
+
+	if s.Marker.IsMarker(path) {
+		return Reject(RejectMarker)
+	}
+
+	if s.Processed.IsProcessed(path) {
+		return Reject(RejectProcessed)
+	}
+
+	if s.CompanionMarkers.IsMarked(path) {
+		return Reject(RejectCompanionMarker)
+	}
+
+	if isRawArgs(path) {
+		return Reject(RejectRawArgs)
+	}
+
+	err = s.Sandbox.Check(path)
+	if err != nil {
+		return err
+	}
+
+	// Leave the video in the watch directory until its raw args are fixed
+	rawArgs, hasRawArgs, err := loadRawArgs(path)
+	if err != nil {
+		return err
+	}
+
+	if (s.SkipUpToDate || s.ReadOnlySource) && isUpToDate(watchRoot(s.WatchDir, e), s.Outputs, s.PlexCfg.Share, s.Organize, s.OutputBucket, s.PresetRules, s.Devices, s.Names, s.VerifyUpToDate, e) {
+		return Reject(RejectUpToDate)
+	}
+
+	// Leave the video in the watch directory until there is space to transcode it
+	err = s.SpaceCheck.Wait(ctx, s.TranscodedDir, path)
+	if err != nil {
+		return err
+	}
+	// The space stays reserved until the jobs of the video finish, see waitForJobs
+	defer func() {
+		if err != nil {
+			s.SpaceCheck.Release(path)
+		}
+	}()
+
+	pathSuffix, claimPath, err := claimVideo(ctx, s.Sandbox, watchRoot(s.WatchDir, e), s.ClaimDir, path, s.ReadOnlySource)
+	if err != nil {
+		return err
+	}
+	// The marker is removed by SweepUntil, once the upload job removes the claimed video
+	s.Marker.Create(path, claimPath)
+
+	subtitles, err := claimSubtitles(ctx, s.Sandbox, watchRoot(s.WatchDir, e), s.ClaimDir, e.Subtitles, s.ReadOnlySource)
+	if err != nil {
+		s.cleanupFailedClaim(claimPath)
+		return err
+	}
+
+	if !e.IsDir {
+		err = s.Integrity.Check(claimPath)
+		if err != nil {
+			quarantineClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath, err)
+			return err
+		}
+	}
+
+	// The raw args replace the preset, so the video is only transcoded once with them
+	if devices := s.Devices.For(pathSuffix, e.Metadata); len(devices) > 0 && !hasRawArgs {
+		return s.createDeviceJobs(ctx, e, devices, claimPath, pathSuffix, subtitles, timing)
+	}
+
+	library := libraryName(pathSuffix)
+	_, organize := s.Outputs.For(library, s.PlexCfg.Share, s.Organize)
+	container := s.PresetRules.Container(pathSuffix, e.Metadata)
+	outputName, err := validNames(s.Names).OutputName(e, pathSuffix, container)
+	if err != nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+		return err
+	}
+	destSuffix, err := organize.Destination(outputName, e.Metadata)
+	if err != nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+		return err
+	}
+
+	transcodedPath := filepath.Join(s.TranscodedDir, outputName)
+	err = s.PreHook.Run(ctx, HookValues{Input: claimPath, Output: transcodedPath})
+	if err != nil {
+		s.cleanupFailedClaim(claimPath)
+		return err
+	}
+
+	profile, preset := s.selectProfile(library, pathSuffix, e)
+	timing.Preset = preset
+	profile, tier := s.ResourceTiers.Size(profile, e.Size, e.Metadata)
+	if tier != "" {
+		logf(ctx, "sizing the transcode job for %s with the %s resource tier\n", pathSuffix, tier)
+	}
+	target := profile.Target(s.Targets.For(library))
+	if len(profile.Args) == 0 && tier == "" && len(s.DefaultArgs) == 0 && s.ScanTimeout == 0 && !hasRawArgs && len(subtitles) == 0 && !e.IsDir && container == "" && s.QueueSpec == nil && s.Title.IsDefault() && s.shouldBatch(claimPath) {
+		return s.addToBatch(ctx, batchVideo{Target: target, Profile: profile, Path: path, Metadata: e.Metadata, ClaimPath: claimPath, TranscodedPath: transcodedPath, PathSuffix: pathSuffix, DestSuffix: destSuffix, Preset: preset, Timing: timing})
+	}
+
+	transcodeJobName, err := s.createTranscodeJob(ctx, target, profile, e, claimPath, transcodedPath, preset, container, rawArgs, subtitles)
+	if err != nil {
+		s.cleanupFailedClaim(claimPath)
+		return err
+	}
+
+	uploadJobName, err := s.createUploadJob(ctx, target, e, transcodeJobName, transcodedPath, claimPath, pathSuffix, destSuffix, library, len(subtitles) > 0)
+	if err != nil {
+		delerr := s.jobsClient(target).Delete(transcodeJobName, target.Namespace)
+		if delerr != nil {
+			logln(ctx, delerr)
+		}
+		s.cleanupFailedClaim(claimPath)
+		return err
+	}
+
+	Publish(ctx, PipelineEvent{Type: EventJobCreated, Path: path, Job: transcodeJobName})
+	Publish(ctx, PipelineEvent{Type: EventJobCreated, Path: path, Job: uploadJobName})
+	releaseLock := keepVideoLock(ctx)
+	go func() {
+		s.waitForJobs(ctx, target, path, claimPath, transcodeJobName, uploadJobName)
+		s.SpaceCheck.Release(path)
+		releaseLock()
+	}()
+	if s.QueueSpec != nil && !hasRawArgs {
+		go s.removeQueueSpecAfter(ctx, target, transcodeJobName, handbrake.QueueSpecPath(transcodedPath))
+	}
+	if hasRawArgs && !s.ReadOnlySource {
+		removeRawArgs(s.Sandbox, path)
+	}
+	if s.PostHook.IsSet() {
+		go s.runPostHookAfterUpload(ctx, target, uploadJobName, s.postHookValues(path, library, destSuffix))
+	}
+	if s.Timings {
+		timing.QueuedAt = time.Now()
+		timing.LogFile = s.logFile(target, transcodeJobName)
+		go s.trackJobTiming(ctx, target, timing, transcodeJobName, uploadJobName)
+	}
+
+	return nil
+}
+
+// selectProfile returns the job profile and preset for a video. A preset rule that
+// matches the video takes precedence over the profile selected by its library, and
+// the preset of the rule takes precedence over the preset of the profile.
+func (s *JobSink) selectProfile(library, pathSuffix string, e fs.FileEvent) (JobProfile, string) {
+	rule, matched := s.PresetRules.Match(pathSuffix, e.Metadata)
+
+	profile := s.Profiles.For(library)
+	if matched && rule.Profile != "" {
+		if p, ok := s.Profiles.Named(rule.Profile); ok {
+			profile = p
+		} else {
+			log.Printf("unknown job profile %s in the preset rule for %s, using the defaults\n", rule.Profile, pathSuffix)
+		}
+	}
+
+	preset := s.VideoPreset
+	if profile.Preset != "" {
+		preset = profile.Preset
+	}
+	if matched && rule.Preset != "" {
+		preset = rule.Preset
+	}
+	return profile, preset
+}
+
+// logFile is where the logs of a job are archived, or empty when they aren't archived.
+func (s *JobSink) logFile(target JobTarget, jobName string) string {
+	if s.LogDir == "" {
+		return ""
+	}
+	return jobs.LogFile(s.logDir(target), target.Namespace, jobName)
+}
+
+// logDir is where the logs of the jobs on the cluster of the target are archived. The
+// logs from another cluster are kept in @CONTEXT, which can't be mistaken for a namespace.
+func (s *JobSink) logDir(target JobTarget) string {
+	if target.Context == "" {
+		return s.LogDir
+	}
+	return filepath.Join(s.LogDir, "@"+target.Context)
+}
+
+// ArchiveLogs archives the logs of the jobs on every target to the LogDir once they
+// finish, see jobs.ArchiveLogs. Errors are signaled on the returned channel until
+// done is closed.
+func (s *JobSink) ArchiveLogs(done <-chan struct{}) <-chan error {
+	return s.WatchTargets(func(target JobTarget, client jobs.Client) <-chan error {
+		return client.ArchiveLogs(done, target.Namespace, s.logDir(target))
+	})
+}
+
+// WatchTargets starts watching the jobs on every target where they may be created, the
+// default, each library and each profile, through the client for the cluster of the
+// target. Their errors are merged into a single channel, which is closed once they
+// have all stopped.
+func (s *JobSink) WatchTargets(watch func(target JobTarget, client jobs.Client) <-chan error) <-chan error {
+	merged := make(chan error)
+	var wg sync.WaitGroup
+	for _, target := range s.reconcileTargets() {
+		wg.Add(1)
+		go func(target JobTarget, errs <-chan error) {
+			defer wg.Done()
+			for err := range errs {
+				if target.Context != "" {
+					err = errors.Wrapf(err, "on %s", target)
+				}
+				merged <- err
+			}
+		}(target, watch(target, s.jobsClient(target)))
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged
+}
+
+// jobsClient returns the client for the cluster of the target, connecting to the cluster
+// of its context the first time it is used. A cluster that can't be connected to is
+// retried the next time the client is used.
+func (s *JobSink) jobsClient(target JobTarget) jobs.Client {
+	if target.Context == "" {
+		return s.Jobs
+	}
+
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	if c, ok := s.contextClients[target.Context]; ok {
+		return c
+	}
+	c, err := jobs.NewContextClient(s.Kubeconfig, target.Context)
+	if err != nil {
+		return unavailableClient{err}
+	}
+	if s.contextClients == nil {
+		s.contextClients = make(map[string]jobs.Client)
+	}
+	s.contextClients[target.Context] = c
+	return c
+}
+
+// unavailableClient returns the error from connecting to a cluster.
+type unavailableClient struct {
+	err error
+}
+
+func (c unavailableClient) CreateOrReplace(j *batchv1.Job) (string, error) {
+	return "", c.err
+}
+
+func (c unavailableClient) Delete(name, namespace string) error {
+	return c.err
+}
+
+func (c unavailableClient) Get(name, namespace string) (*batchv1.Job, error) {
+	return nil, c.err
+}
+
+func (c unavailableClient) List(namespace string) ([]batchv1.Job, error) {
+	return nil, c.err
+}
+
+func (c unavailableClient) Annotate(name, namespace string, annotations map[string]string) error {
+	return c.err
+}
+
+func (c unavailableClient) Progress(name, namespace string, since time.Duration) (handbrake.Progress, bool, error) {
+	return handbrake.Progress{}, false, c.err
+}
+
+func (c unavailableClient) WatchCancelled(done <-chan struct{}, namespace string) (<-chan *batchv1.Job, <-chan error) {
+	jobChan, errChan := make(chan *batchv1.Job), make(chan error, 1)
+	errChan <- c.err
+	close(jobChan)
+	close(errChan)
+	return jobChan, errChan
+}
+
+func (c unavailableClient) RequeueDisrupted(done <-chan struct{}, namespace string, maxRequeues int) <-chan error {
+	return c.failed()
+}
+
+func (c unavailableClient) ArchiveLogs(done <-chan struct{}, namespace, dir string) <-chan error {
+	return c.failed()
+}
+
+func (c unavailableClient) MonitorTranscodes(done <-chan struct{}, namespace string, expectedDuration, stallTimeout time.Duration, notifier notify.Notifier) <-chan error {
+	return c.failed()
+}
+
+// failed signals the error once, on a closed channel.
+func (c unavailableClient) failed() <-chan error {
+	errChan := make(chan error, 1)
+	errChan <- c.err
+	close(errChan)
+	return errChan
+}
+
+// createJobFromTemplate creates a job from a template in the templates directory, on the cluster of the target.
+func (s *JobSink) createJobFromTemplate(ctx context.Context, target JobTarget, templateName string, values interface{}) (jobName string, err error) {
+	templateFile := filepath.Join(s.TemplatesDir, templateName)
+	template, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read %s", templateFile)
+	}
+
+	j, err := jobs.BuildFromTemplate(string(template), values)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.submits.wait(ctx, s.SubmitInterval)
+	if err != nil {
+		return "", errors.Wrapf(err, "gave up waiting to create %s", j.Name)
+	}
+	return s.jobsClient(target).CreateOrReplace(j)
+}
+
+// libraryName assumes that the library is the first segment of the path, e.g. /watch/LIBRARY/../video.mkv
+func libraryName(pathSuffix string) string {
+	return strings.Split(pathSuffix, string(os.PathSeparator))[0]
+}
+
+func (s *JobSink) cleanupFailedClaim(claimPath string) {
+	cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+}
@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// Latency measures how long stable videos wait before they are submitted, that is, before
+// their jobs are created or, on the current host, before their transcode starts.
+type Latency struct {
+	// OldestWaiting is how long the video that has been stable the longest, without being
+	// submitted, has waited. It is 0 when no videos are waiting.
+	OldestWaiting time.Duration
+
+	// Waiting is the number of stable videos that haven't been submitted yet.
+	Waiting int
+
+	// SubmitSeconds is the total time, for every submitted video, from when it was first
+	// found until it was submitted, and Submitted is the number of submitted videos. A video
+	// that joined the batch of another video is counted by the video that started the batch.
+	SubmitSeconds float64
+	Submitted     int
+}
+
+// latencyTracker records the videos waiting to be submitted, and how long the submitted videos waited.
+type latencyTracker struct {
+	mu            sync.Mutex
+	waiting       map[string]fs.FileEvent
+	submitSeconds float64
+	submitted     int
+}
+
+// wait records that the stable video is waiting to be submitted.
+func (l *latencyTracker) wait(file fs.FileEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.waiting == nil {
+		l.waiting = make(map[string]fs.FileEvent)
+	}
+	if file.StableAt.IsZero() {
+		file.StableAt = time.Now()
+	}
+	l.waiting[file.Path] = file
+}
+
+// submit records how long the video waited, from when it was first found. Only
+// the first submission of a video is recorded, such as its first job.
+func (l *latencyTracker) submit(path string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, ok := l.waiting[path]
+	if !ok {
+		return
+	}
+	delete(l.waiting, path)
+
+	foundAt := file.DetectedAt
+	if foundAt.IsZero() {
+		foundAt = file.StableAt
+	}
+	l.submitSeconds += secondsBetween(foundAt, at)
+	l.submitted++
+}
+
+// done stops waiting for a video that was handled, rejected or failed without being submitted.
+func (l *latencyTracker) done(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.waiting, path)
+}
+
+// waitingSizes lists the size of each video waiting to be submitted.
+func (l *latencyTracker) waitingSizes() []int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sizes := make([]int64, 0, len(l.waiting))
+	for _, file := range l.waiting {
+		sizes = append(sizes, file.Size)
+	}
+	return sizes
+}
+
+// snapshot of the latency at the time.
+func (l *latencyTracker) snapshot(now time.Time) Latency {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	latency := Latency{Waiting: len(l.waiting), SubmitSeconds: l.submitSeconds, Submitted: l.submitted}
+	for _, file := range l.waiting {
+		if age := now.Sub(file.StableAt); age > latency.OldestWaiting {
+			latency.OldestWaiting = age
+		}
+	}
+	return latency
+}
@@ -0,0 +1,40 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestVideoWatcher_Latency(t *testing.T) {
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+
+	// Hold the video, so that it waits to be submitted
+	w.Pause()
+	stableAt := time.Now().Add(-time.Minute)
+	video := fs.FileEvent{Path: "/watch/Movies/foo.mkv", DetectedAt: stableAt.Add(-time.Minute), StableAt: stableAt}
+	files <- video
+	for i := 0; w.Status().Queued == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l := w.Latency()
+	if l.Waiting != 1 || l.OldestWaiting < time.Minute {
+		t.Fatalf("expected the paused video to be waiting for at least a minute, got %#v", l)
+	}
+
+	// Creating its first job submits the video
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: video.Path, At: stableAt.Add(time.Minute)})
+	w.events.publish(PipelineEvent{Type: EventJobCreated, Path: video.Path, At: stableAt.Add(2 * time.Minute)})
+	l = w.Latency()
+	if l.Waiting != 0 || l.OldestWaiting != 0 {
+		t.Fatalf("expected no videos to be waiting once the video was submitted, got %#v", l)
+	}
+	if l.Submitted != 1 || l.SubmitSeconds != 120 {
+		t.Fatalf("expected the video to be submitted 2 minutes after it was found, got %#v", l)
+	}
+}
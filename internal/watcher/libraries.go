@@ -0,0 +1,274 @@
+package watcher
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LibraryDurations is a duration that may be overridden for specific Plex
+// libraries, for example "30s,TV=2m". It may be used as a flag.
+type LibraryDurations struct {
+	// Default applies to libraries without an override.
+	Default time.Duration
+
+	// Libraries override the duration for a library, by name.
+	Libraries map[string]time.Duration
+}
+
+// For returns the duration to use for a library.
+func (d LibraryDurations) For(library string) time.Duration {
+	if v, ok := d.Libraries[library]; ok {
+		return v
+	}
+	return d.Default
+}
+
+// String formats the durations, for example "30s,TV=2m0s".
+func (d *LibraryDurations) String() string {
+	if d == nil {
+		return ""
+	}
+
+	values := []string{d.Default.String()}
+	var libraries []string
+	for library := range d.Libraries {
+		libraries = append(libraries, library)
+	}
+	sort.Strings(libraries)
+	for _, library := range libraries {
+		values = append(values, fmt.Sprintf("%s=%s", library, d.Libraries[library]))
+	}
+	return strings.Join(values, ",")
+}
+
+// Set parses a comma separated list of durations. Entries with a library name,
+// LIBRARY=DURATION, override the default for that library.
+func (d *LibraryDurations) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		library := ""
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			library, entry = entry[:i], entry[i+1:]
+		}
+
+		v, err := time.ParseDuration(entry)
+		if err != nil {
+			return errors.Wrapf(err, "invalid duration %q", entry)
+		}
+
+		if library == "" {
+			d.Default = v
+			continue
+		}
+		if d.Libraries == nil {
+			d.Libraries = make(map[string]time.Duration)
+		}
+		d.Libraries[library] = v
+	}
+	return nil
+}
+
+// JobTarget is where the jobs for a video are created.
+type JobTarget struct {
+	// Namespace of the jobs.
+	Namespace string
+
+	// Context in the kubeconfig of the cluster for the jobs. When empty,
+	// the jobs are created on the cluster where the watcher is running.
+	Context string
+}
+
+// String formats the target, NAMESPACE[@CONTEXT].
+func (t JobTarget) String() string {
+	if t.Context == "" {
+		return t.Namespace
+	}
+	return t.Namespace + "@" + t.Context
+}
+
+// JobTargets is where jobs are created, which may be overridden for specific
+// Plex libraries, for example "handbrk8s,TV=tv@gpu-cluster". It may be used as a flag.
+type JobTargets struct {
+	// Default applies to libraries without an override.
+	Default JobTarget
+
+	// Libraries override the target for a library, by name.
+	Libraries map[string]JobTarget
+}
+
+// For returns the target to use for a library.
+func (t JobTargets) For(library string) JobTarget {
+	if v, ok := t.Libraries[library]; ok {
+		return v
+	}
+	return t.Default
+}
+
+// String formats the targets, for example "handbrk8s,TV=tv@gpu-cluster".
+func (t *JobTargets) String() string {
+	if t == nil {
+		return ""
+	}
+
+	values := []string{t.Default.String()}
+	var libraries []string
+	for library := range t.Libraries {
+		libraries = append(libraries, library)
+	}
+	sort.Strings(libraries)
+	for _, library := range libraries {
+		values = append(values, fmt.Sprintf("%s=%s", library, t.Libraries[library]))
+	}
+	return strings.Join(values, ",")
+}
+
+// Set parses a comma separated list of targets, NAMESPACE[@CONTEXT]. Entries with a
+// library name, LIBRARY=NAMESPACE[@CONTEXT], override the default for that library.
+func (t *JobTargets) Set(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		library := ""
+		if i := strings.LastIndex(entry, "="); i >= 0 {
+			library, entry = entry[:i], entry[i+1:]
+		}
+
+		var target JobTarget
+		target.Namespace = entry
+		if i := strings.Index(entry, "@"); i >= 0 {
+			target.Namespace, target.Context = entry[:i], entry[i+1:]
+			if target.Context == "" {
+				return errors.Errorf("invalid job target %q, missing the context after @", entry)
+			}
+		}
+		if target.Namespace == "" {
+			return errors.Errorf("invalid job target %q, missing the namespace", entry)
+		}
+
+		if library == "" {
+			t.Default = target
+			continue
+		}
+		if t.Libraries == nil {
+			t.Libraries = make(map[string]JobTarget)
+		}
+		t.Libraries[library] = target
+	}
+	return nil
+}
+
+// targets lists the library overrides, sorted by library.
+func (t JobTargets) targets() []JobTarget {
+	var libraries []string
+	for library := range t.Libraries {
+		libraries = append(libraries, library)
+	}
+	sort.Strings(libraries)
+
+	targets := make([]JobTarget, len(libraries))
+	for i, library := range libraries {
+		targets[i] = t.Libraries[library]
+	}
+	return targets
+}
+
+// LibraryOutput is where the videos of a library are uploaded, instead of the
+// Plex share, and optionally how they are organized there.
+type LibraryOutput struct {
+	// Dir is the root directory of the library's videos.
+	Dir string
+
+	// Organize overrides the organize template for the library, when set.
+	Organize OrganizeTemplate
+}
+
+// LibraryOutputs routes the videos of each library to its own output directory. It may
+// be used as a flag, with each use adding a library, LIBRARY=DIR[=TEMPLATE], for example
+// "TV=/plex/tv={{.Dir}}/{{.Name}}{{.Ext}}".
+type LibraryOutputs map[string]LibraryOutput
+
+// For returns the output directory and organize template for a library,
+// or the defaults when the library isn't overridden.
+func (o LibraryOutputs) For(library, defaultDir string, defaultOrganize OrganizeTemplate) (string, OrganizeTemplate) {
+	output, ok := o[library]
+	if !ok {
+		return defaultDir, defaultOrganize
+	}
+	if output.Organize.tmpl == nil {
+		output.Organize = defaultOrganize
+	}
+	return output.Dir, output.Organize
+}
+
+// Dirs lists the output directories, sorted by library.
+func (o LibraryOutputs) Dirs() []string {
+	var libraries []string
+	for library := range o {
+		libraries = append(libraries, library)
+	}
+	sort.Strings(libraries)
+
+	dirs := make([]string, len(libraries))
+	for i, library := range libraries {
+		dirs[i] = o[library].Dir
+	}
+	return dirs
+}
+
+// String formats the outputs, separated by semicolons.
+func (o *LibraryOutputs) String() string {
+	if o == nil {
+		return ""
+	}
+
+	var libraries []string
+	for library := range *o {
+		libraries = append(libraries, library)
+	}
+	sort.Strings(libraries)
+
+	values := make([]string, len(libraries))
+	for i, library := range libraries {
+		output := (*o)[library]
+		values[i] = library + "=" + output.Dir
+		if text := output.Organize.String(); text != "" {
+			values[i] += "=" + text
+		}
+	}
+	return strings.Join(values, ";")
+}
+
+// Set parses the output of a library, LIBRARY=DIR[=TEMPLATE], and adds it to the outputs.
+func (o *LibraryOutputs) Set(value string) error {
+	parts := strings.SplitN(value, "=", 3)
+	if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return errors.Errorf("invalid library output %q, must be LIBRARY=DIR[=TEMPLATE]", value)
+	}
+
+	library := strings.TrimSpace(parts[0])
+	output := LibraryOutput{Dir: filepath.Clean(strings.TrimSpace(parts[1]))}
+	if len(parts) == 3 {
+		err := output.Organize.Set(parts[2])
+		if err != nil {
+			return errors.Wrapf(err, "invalid library output %q", value)
+		}
+	}
+
+	if *o == nil {
+		*o = make(LibraryOutputs)
+	}
+	(*o)[library] = output
+	return nil
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLibraryDurations_Set(t *testing.T) {
+	var d LibraryDurations
+	err := d.Set("30s, TV Shows=2m")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if got := d.For("Movies"); got != 30*time.Second {
+		t.Fatalf("expected the default for Movies, got %s", got)
+	}
+	if got := d.For("TV Shows"); got != 2*time.Minute {
+		t.Fatalf("expected the override for TV Shows, got %s", got)
+	}
+	if got := d.String(); got != "30s,TV Shows=2m0s" {
+		t.Fatalf("unexpected string representation %s", got)
+	}
+}
+
+func TestLibraryDurations_SetInvalid(t *testing.T) {
+	var d LibraryDurations
+	err := d.Set("TV=soon")
+	if err == nil {
+		t.Fatal("expected an invalid duration to be rejected")
+	}
+}
+
+func TestJobTargets_Set(t *testing.T) {
+	var targets JobTargets
+	err := targets.Set("handbrk8s, TV Shows=tv@gpu-cluster, Movies=movies")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if got := targets.For("Music"); got != (JobTarget{Namespace: "handbrk8s"}) {
+		t.Fatalf("expected the default for Music, got %#v", got)
+	}
+	if got := targets.For("TV Shows"); got != (JobTarget{Namespace: "tv", Context: "gpu-cluster"}) {
+		t.Fatalf("expected the override for TV Shows, got %#v", got)
+	}
+	if got := targets.String(); got != "handbrk8s,Movies=movies,TV Shows=tv@gpu-cluster" {
+		t.Fatalf("unexpected string representation %s", got)
+	}
+}
+
+func TestJobTargets_SetInvalid(t *testing.T) {
+	for _, value := range []string{"TV=@gpu-cluster", "TV=tv@"} {
+		var targets JobTargets
+		err := targets.Set(value)
+		if err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestLibraryOutputs_Set(t *testing.T) {
+	var outputs LibraryOutputs
+	for _, value := range []string{"Movies=/plex/movies", "TV=/plex/tv={{.Dir}}/{{.Name}}{{.Ext}}"} {
+		err := outputs.Set(value)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	want := "Movies=/plex/movies;TV=/plex/tv={{.Dir}}/{{.Name}}{{.Ext}}"
+	if outputs.String() != want {
+		t.Fatalf("expected %q, got %q", want, outputs.String())
+	}
+
+	var defaultOrganize OrganizeTemplate
+	err := defaultOrganize.Set("{{.Title}}{{.Ext}}")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	testcases := []struct {
+		PathSuffix string
+		WantDir    string
+		WantDest   string
+	}{
+		{PathSuffix: "TV/Show/foo.S01E02.mkv", WantDir: "/plex/tv", WantDest: "Show/foo.S01E02.mkv"},
+		{PathSuffix: "Movies/Foo.2019.mkv", WantDir: "/plex/movies", WantDest: "Foo.mkv"},
+		{PathSuffix: "Kids/Foo.2019.mkv", WantDir: "/plex", WantDest: "Foo.mkv"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.PathSuffix, func(t *testing.T) {
+			dir, organize := outputs.For(libraryName(tc.PathSuffix), "/plex", defaultOrganize)
+			dest, err := organize.Destination(tc.PathSuffix, nil)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if dir != tc.WantDir || dest != tc.WantDest {
+				t.Fatalf("expected %s in %s, got %s in %s", tc.WantDest, tc.WantDir, dest, dir)
+			}
+		})
+	}
+}
+
+func TestLibraryOutputs_SetInvalid(t *testing.T) {
+	for _, value := range []string{"/plex/tv", "TV=", "=/plex/tv", "TV=/plex/tv={{.Missing"} {
+		var outputs LibraryOutputs
+		err := outputs.Set(value)
+		if err == nil {
+			t.Fatalf("expected %q to be invalid", value)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package watcher
+
+import (
+	"log"
+	"time"
+)
+
+// libraryPollInterval is how often WaitForLibrary checks if the watcher is idle,
+// and libraryProgressInterval is how often it logs its progress.
+var (
+	libraryPollInterval     = time.Second
+	libraryProgressInterval = 30 * time.Second
+)
+
+// LibraryReport counts what happened to the videos while the library was reconciled.
+type LibraryReport struct {
+	// Uploaded is the number of videos that were transcoded and uploaded.
+	Uploaded int
+
+	// Failed is the number of videos that a sink was unable to handle, or whose job failed.
+	Failed int
+
+	// Skipped is the number of videos that were rejected, such as the videos that were
+	// already uploaded when the sinks skip them, see JobSink.SkipUpToDate.
+	Skipped int
+}
+
+// WaitForLibrary reports what happened to the videos once the backlog is drained, see
+// BacklogDrained, and every video has finished: nothing is stabilizing, queued, handled by
+// the sinks or in flight. The progress is logged until then. Only the videos that finish
+// after it is called are counted, so call it as soon as the watcher is created.
+func (w *VideoWatcher) WaitForLibrary() <-chan LibraryReport {
+	events := w.Subscribe()
+	reports := make(chan LibraryReport, 1)
+	go func() {
+		defer close(reports)
+
+		poll := time.NewTicker(libraryPollInterval)
+		defer poll.Stop()
+		progress := time.NewTicker(libraryProgressInterval)
+		defer progress.Stop()
+
+		var report LibraryReport
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+				switch e.Type {
+				case EventUploaded:
+					report.Uploaded++
+				case EventFailed:
+					report.Failed++
+				case EventSkipped:
+					report.Skipped++
+				}
+			case <-progress.C:
+				load := w.Load()
+				log.Printf("reconciling the library: %d uploaded, %d failed, %d skipped, %d stabilizing, %d queued, %d handling, %d in flight\n",
+					report.Uploaded, report.Failed, report.Skipped, load.Stabilizing, load.Queued, load.Handling, load.InFlight)
+			case <-poll.C:
+				if w.isIdle() {
+					reports <- report
+					return
+				}
+			}
+		}
+	}()
+	return reports
+}
+
+// isIdle determines if the backlog is drained, and no video is left in the pipeline.
+func (w *VideoWatcher) isIdle() bool {
+	select {
+	case <-w.BacklogDrained():
+	default:
+		return false
+	}
+	load := w.Load()
+	return load.Pending() == 0 && load.InFlight == 0
+}
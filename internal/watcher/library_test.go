@@ -0,0 +1,52 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// uploadingSink reports that each video was uploaded.
+type uploadingSink struct{}
+
+func (s uploadingSink) Handle(ctx context.Context, e fs.FileEvent) error {
+	Publish(ctx, PipelineEvent{Type: EventUploaded, Path: e.Path})
+	return nil
+}
+
+func TestVideoWatcher_WaitForLibrary(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.Mkdir(filepath.Join(tmpDir, "Movies"), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	createFile(t, filepath.Join(tmpDir, "foo.mkv"))
+	createFile(t, filepath.Join(tmpDir, "Movies", "bar.mkv"))
+
+	w := newTestVideoWatcher(t, tmpDir, uploadingSink{})
+	defer w.Close()
+
+	select {
+	case report := <-w.WaitForLibrary():
+		if report.Uploaded != 2 || report.Failed != 0 {
+			t.Fatalf("expected both videos to be uploaded, got %#v", report)
+		}
+		if w.Load().Pending() != 0 {
+			t.Fatalf("expected nothing to be left in the pipeline, got %#v", w.Load())
+		}
+	case <-time.After(5*testStableThreshold + 2*libraryPollInterval):
+		t.Fatal("expected the library to be reconciled")
+	}
+}
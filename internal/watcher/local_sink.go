@@ -0,0 +1,493 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/notify"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/carolynvs/handbrk8s/internal/uploader"
+	"github.com/pkg/errors"
+)
+
+// LocalSink claims each video, and then transcodes it and uploads it to Plex on
+// the current host, without Kubernetes. Videos are transcoded one at a time,
+// because HandBrake already uses every core available, unless the FastLane lets
+// small videos be transcoded alongside a large one.
+type LocalSink struct {
+	// WatchDir contains raw (untranscoded) video files.
+	WatchDir string
+
+	// ClaimDir temporarily holds raw video files while they are being transcoded.
+	ClaimDir string
+
+	// TranscodedDir contains completed (transcoded) video files.
+	TranscodedDir string
+
+	FailedDir string
+
+	// Encoder runs HandBrakeCLI, with the default preset.
+	Encoder handbrake.Encoder
+
+	// PresetRules select a different preset than the default for matching videos.
+	PresetRules PresetRules
+
+	// PlexCfg contains connection information upload a file to a Plex server.
+	PlexCfg plex.LibraryConfig
+
+	// PlexRefreshDebounce is how long to wait to refresh a Plex library,
+	// coalescing the refreshes for videos uploaded around the same time.
+	PlexRefreshDebounce LibraryDurations
+
+	// PlexMinScanInterval is the minimum time between scans of a Plex library, even for
+	// refreshes in separate bursts of uploads. The refreshes due sooner wait for the interval.
+	PlexMinScanInterval LibraryDurations
+
+	// ArchiveDir is an optional directory where the raw video files are moved
+	// after they are uploaded. When empty, raw video files are removed.
+	ArchiveDir string
+
+	// Processed optionally moves the raw video files back to the watch directory with a
+	// new name after they are uploaded, instead of removing them. Used instead of ArchiveDir.
+	Processed ProcessedName
+
+	// DeletionQueue optionally keeps the raw video files for a grace period after they are
+	// uploaded, instead of removing them. Used when neither ArchiveDir nor Processed are set.
+	DeletionQueue *DeletionQueue
+
+	// CompanionMarkers skip the videos that other tools marked as already processed.
+	CompanionMarkers CompanionMarkers
+
+	// SkipUpToDate leaves a video in the watch directory, instead of processing it, when
+	// it was already uploaded to the Plex share after it was last modified.
+	SkipUpToDate bool
+
+	// VerifyUpToDate hashes an up-to-date video again, when it has a checksum file next to it,
+	// and processes it again when it no longer matches. The videos in a bucket aren't verified.
+	VerifyUpToDate bool
+
+	// ReadOnlySource is set when the watch directory is read-only. The videos are copied
+	// to the claim directory instead of moved, and are only read, the failed videos are
+	// kept on the work volume, and the videos that were already uploaded are skipped.
+	ReadOnlySource bool
+
+	// Organize optionally uploads videos to a different path in the Plex share,
+	// instead of the same path that they had in the watch directory.
+	Organize OrganizeTemplate
+
+	// ArchiveRollback moves the raw video file back out of the archive
+	// when the Plex library can't be refreshed.
+	ArchiveRollback bool
+
+	// VerifyArchive only removes a raw video file moved to the archive, or renamed, once the
+	// checksum of the copy matches the original. On a mismatch the original is left in place.
+	VerifyArchive bool
+
+	// MaxSizeRatio treats a transcoded video larger than this ratio of the raw
+	// video's size as a failed transcode, flagging the raw video for review in
+	// the failed directory. 0 disables the check.
+	MaxSizeRatio float64
+
+	// Marker optionally creates a marker file next to each video in the watch directory while it is processed.
+	Marker ProcessingMarker
+
+	// Timings logs how long each step took for every video, as a line of JSON, and publishes it as an EventTiming.
+	Timings bool
+
+	// SpaceCheck holds each video until the transcoded directory has enough free space.
+	SpaceCheck SpaceCheck
+
+	// Integrity optionally moves a claimed video that is incomplete to the failed
+	// directory, with a file explaining why, instead of transcoding it.
+	Integrity IntegrityCheck
+
+	// Checksum records the SHA-256 of each video uploaded to the Plex share in the upload log.
+	Checksum bool
+
+	// ChecksumSidecar also writes the checksum to a .sha256 file next to each uploaded video.
+	ChecksumSidecar bool
+
+	// Outputs optionally upload the videos of a library to its own directory, instead of the Plex share.
+	Outputs LibraryOutputs
+
+	// OutputBucket optionally uploads the videos to a bucket, instead of the Plex share or the Outputs.
+	OutputBucket OutputBucket
+
+	// Names optionally names the transcoded videos, see NameStrategy. Defaults to SanitizedNames.
+	Names NameStrategy
+
+	// Devices optionally transcode the videos that match their rules once for each of their
+	// devices, uploading each to the output of its device, instead of the Plex share.
+	Devices DeviceProfiles
+
+	// Sandbox optionally refuses to modify any file outside of its allowed directories.
+	Sandbox *fs.Sandbox
+
+	// Scratch optionally holds the transcoded videos until they are uploaded, instead of
+	// the transcoded directory, waiting for scratch space before transcoding.
+	Scratch *fs.Scratch
+
+	// Notifier optionally sends an alert when a transcode runs longer than EncodeAlertAfter,
+	// or its progress hasn't advanced for EncodeStallTimeout, and when the PostHook fails.
+	Notifier notify.Notifier
+
+	// EncodeAlertAfter is how long a transcode should take. 0 disables the alert.
+	EncodeAlertAfter time.Duration
+
+	// EncodeStallTimeout is how long the progress of a transcode may stay the same. 0 disables the alert.
+	EncodeStallTimeout time.Duration
+
+	// PreHook is run for each claimed video before it is transcoded. When it fails,
+	// the video is moved to the failed directory instead of being transcoded.
+	PreHook Hook
+
+	// PostHook is run for each video once it is uploaded. When it fails, the failure
+	// is logged and sent to the Notifier, and the upload is kept.
+	PostHook Hook
+
+	// FastLane optionally lets small videos be transcoded while a large video occupies the main slot.
+	FastLane FastLane
+
+	slots transcodeSlots
+
+	// processing is set when the videos are claimed into a processing directory, see UseProcessingDir.
+	processing bool
+}
+
+// encodeCheckInterval is how often a transcode is checked for taking too long.
+const encodeCheckInterval = time.Minute
+
+// progressInterval is the least time between the progress events of a transcode on the
+// current host, unless it moves on to its next task.
+const progressInterval = 10 * time.Second
+
+// NewLocalSink validates the volumes and creates the directories used to process videos.
+func NewLocalSink(watchVolume, workVolume string, videoPreset string, plexCfg plex.LibraryConfig) (*LocalSink, error) {
+	if _, err := os.Stat(watchVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("watch volume, %s, is not mounted", watchVolume)
+	}
+
+	if _, err := os.Stat(workVolume); os.IsNotExist(err) {
+		return nil, errors.Errorf("work volume, %s, is not mounted", workVolume)
+	}
+
+	s := &LocalSink{
+		WatchDir:      filepath.Join(watchVolume, "watch"),
+		FailedDir:     filepath.Join(watchVolume, "fail"),
+		ClaimDir:      filepath.Join(workVolume, "claim"),
+		TranscodedDir: filepath.Join(workVolume, "work"),
+		Encoder:       handbrake.Encoder{CLI: "HandBrakeCLI", Preset: videoPreset},
+		PlexCfg:       plexCfg,
+	}
+
+	err := os.MkdirAll(s.WatchDir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create directory %s", s.WatchDir)
+	}
+	s.ReadOnlySource, err = fs.IsReadOnly(s.WatchDir)
+	if err != nil {
+		return nil, err
+	}
+	if s.ReadOnlySource {
+		s.FailedDir = filepath.Join(workVolume, "fail")
+	}
+
+	for _, dir := range []string{s.FailedDir, s.ClaimDir, s.TranscodedDir} {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create directory %s", dir)
+		}
+	}
+
+	return s, nil
+}
+
+// Handle claims the video, and then transcodes and uploads it.
+func (s *LocalSink) Handle(ctx context.Context, e fs.FileEvent) error {
+	path := e.Path
+	timing := newTiming(e)
+
+	if isHidden(path) {
+		return Reject(RejectHidden)
+	}
+
+	if s.Marker.IsMarker(path) {
+		return Reject(RejectMarker)
+	}
+
+	if s.Processed.IsProcessed(path) {
+		return Reject(RejectProcessed)
+	}
+
+	if s.CompanionMarkers.IsMarked(path) {
+		return Reject(RejectCompanionMarker)
+	}
+
+	if isRawArgs(path) {
+		return Reject(RejectRawArgs)
+	}
+
+	err := s.Sandbox.Check(path)
+	if err != nil {
+		return err
+	}
+
+	// Leave the video in the watch directory until its raw args are fixed
+	rawArgs, hasRawArgs, err := loadRawArgs(path)
+	if err != nil {
+		return err
+	}
+
+	if (s.SkipUpToDate || s.ReadOnlySource) && isUpToDate(watchRoot(s.WatchDir, e), s.Outputs, s.PlexCfg.Share, s.Organize, s.OutputBucket, s.PresetRules, s.Devices, s.Names, s.VerifyUpToDate, e) {
+		return Reject(RejectUpToDate)
+	}
+
+	// Leave the video in the watch directory until there is space to transcode it
+	outputDir := s.TranscodedDir
+	if s.Scratch != nil {
+		outputDir = s.Scratch.Dir
+	}
+	err = s.SpaceCheck.Wait(ctx, outputDir, path)
+	if err != nil {
+		return err
+	}
+	defer s.SpaceCheck.Release(path)
+
+	pathSuffix, claimPath, err := claimVideo(ctx, s.Sandbox, watchRoot(s.WatchDir, e), s.ClaimDir, path, s.ReadOnlySource)
+	if err != nil {
+		return err
+	}
+	s.Marker.Create(path, claimPath)
+	defer s.Marker.Remove(path)
+	timing.QueuedAt = time.Now()
+
+	subtitles, err := claimSubtitles(ctx, s.Sandbox, watchRoot(s.WatchDir, e), s.ClaimDir, e.Subtitles, s.ReadOnlySource)
+	if err != nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+		return err
+	}
+	if s.Timings {
+		defer emitTiming(ctx, timing)
+	}
+
+	if !e.IsDir {
+		err = s.Integrity.Check(claimPath)
+		if err != nil {
+			quarantineClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath, err)
+			return err
+		}
+	}
+
+	// The raw args replace the preset, so the video is only transcoded once with them
+	if devices := s.Devices.For(pathSuffix, e.Metadata); len(devices) > 0 && !hasRawArgs {
+		return s.handleDevices(ctx, e, devices, claimPath, pathSuffix, subtitles, timing)
+	}
+
+	library := libraryName(pathSuffix)
+	outputDir, organize := s.Outputs.For(library, s.PlexCfg.Share, s.Organize)
+	container := s.PresetRules.Container(pathSuffix, e.Metadata)
+	outputName, err := validNames(s.Names).OutputName(e, pathSuffix, container)
+	if err != nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+		return err
+	}
+	destSuffix, err := organize.Destination(outputName, e.Metadata)
+	if err != nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+		return err
+	}
+
+	transcodedPath := filepath.Join(s.TranscodedDir, outputName)
+	if s.Scratch != nil {
+		transcodedPath, err = s.Scratch.Acquire(ctx, outputName)
+		if err != nil {
+			cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+			return err
+		}
+		defer s.Scratch.Release(transcodedPath)
+	}
+
+	err = s.PreHook.Run(ctx, HookValues{Input: claimPath, Output: transcodedPath})
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return err
+	}
+
+	preset := s.PresetRules.For(pathSuffix, e.Metadata, s.Encoder.Preset)
+	size := e.Size
+	if size == 0 {
+		size, _ = fs.Size(claimPath)
+	}
+	timing.Preset, timing.EncodedSize = preset, size
+	err = s.transcode(ctx, path, claimPath, transcodedPath, preset, container, rawArgs, subtitles, size, timing)
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return err
+	}
+	Publish(ctx, PipelineEvent{Type: EventTranscoded, Path: path})
+	if hasRawArgs && !s.ReadOnlySource {
+		removeRawArgs(s.Sandbox, path)
+	}
+
+	opts, err := s.uploadOptions(e, claimPath, transcodedPath, pathSuffix, destSuffix, library, outputDir, len(subtitles) > 0)
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return err
+	}
+
+	logf(ctx, "uploading %s\n", pathSuffix)
+	err = uploader.Upload(opts)
+	if err != nil {
+		s.cleanup(claimPath, transcodedPath)
+		return errors.Wrapf(err, "unable to upload %s", pathSuffix)
+	}
+	timing.CompletedAt = time.Now()
+	Publish(ctx, PipelineEvent{Type: EventUploaded, Path: path, Output: opts.Destination.Location(destSuffix)})
+	Publish(ctx, PipelineEvent{Type: EventCompleted, Path: path})
+	runPostHook(ctx, s.PostHook, HookValues{Input: path, Output: opts.Destination.Location(destSuffix)}, s.Notifier)
+
+	return nil
+}
+
+// uploadOptions are how the transcoded video is uploaded to the output directory, and
+// how the claimed video is cleaned up once it is uploaded.
+func (s *LocalSink) uploadOptions(e fs.FileEvent, claimPath, transcodedPath, pathSuffix, destSuffix, library, outputDir string, subtitles bool) (uploader.Options, error) {
+	opts := uploader.Options{
+		Library:         s.PlexCfg,
+		TranscodedPath:  transcodedPath,
+		PathSuffix:      destSuffix,
+		RawPath:         claimPath,
+		RefreshDebounce: s.PlexRefreshDebounce.For(library),
+		MinScanInterval: s.PlexMinScanInterval.For(library),
+		ArchiveRollback: s.ArchiveRollback,
+		VerifyArchive:   s.VerifyArchive,
+		MaxSizeRatio:    s.MaxSizeRatio,
+		Checksum:        s.Checksum,
+		ChecksumSidecar: s.ChecksumSidecar,
+		Sandbox:         s.Sandbox,
+		Destination:     s.OutputBucket.destination(outputDir, s.Sandbox),
+		Subtitles:       subtitles,
+	}
+	opts.Library.Name = library
+	opts.Library.Share = outputDir
+	if s.ArchiveDir != "" {
+		opts.ArchivePath = filepath.Join(s.ArchiveDir, pathSuffix)
+	} else if s.DeletionQueue != nil && !s.Processed.IsSet() {
+		opts.ArchivePath = s.DeletionQueue.Path(pathSuffix)
+	}
+	if s.Processed.IsSet() {
+		var err error
+		opts.ArchivePath, err = s.Processed.Rename(watchRoot(s.WatchDir, e), pathSuffix)
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// transcode the video once a transcode slot is free for its size, recording when it started and
+// completed. The raw args, when there are any, are used instead of the preset and subtitles.
+func (s *LocalSink) transcode(ctx context.Context, path, claimPath, transcodedPath, preset, container string, rawArgs, subtitles []string, size int64, timing *Timing) error {
+	release, fast, err := s.slots.acquire(ctx, s.FastLane, size)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if fast {
+		logf(ctx, "transcoding %s in the fast lane, while a larger video is being transcoded\n", claimPath)
+	}
+	// A video transcoded for several devices started with its first transcode
+	if timing.StartedAt.IsZero() {
+		timing.StartedAt = time.Now()
+	}
+	Publish(ctx, PipelineEvent{Type: EventTranscodeStarted, Path: path})
+
+	encoder := s.Encoder
+	encoder.Preset = preset
+	encoder.Container = container
+	encoder.RawArgs = rawArgs
+	encoder.Subtitles = subtitles
+	if len(rawArgs) > 0 {
+		logf(ctx, "transcoding %s with raw args\n", claimPath)
+	} else {
+		logf(ctx, "transcoding %s with the %s preset\n", claimPath, preset)
+	}
+	progress := &progressPublisher{path: path}
+	var dog *handbrake.Watchdog
+	if s.Notifier != nil && (s.EncodeAlertAfter > 0 || s.EncodeStallTimeout > 0) {
+		dog = handbrake.NewWatchdog(time.Now(), s.EncodeAlertAfter, s.EncodeStallTimeout)
+		done := make(chan struct{})
+		defer close(done)
+		go s.watchEncode(ctx, done, dog, claimPath)
+	}
+	encoder.Progress = func(p handbrake.Progress) {
+		now := time.Now()
+		if dog != nil {
+			dog.Report(p, now)
+		}
+		progress.report(ctx, p, now)
+	}
+	err = encoder.Transcode(ctx, claimPath, transcodedPath)
+	if err == nil {
+		timing.EncodedAt = time.Now()
+		timing.OutputSize, _ = fs.Size(transcodedPath)
+	}
+	return err
+}
+
+// progressPublisher publishes the progress of a transcode on the current host, at most every
+// progressInterval, unless the encode moved on to its next task.
+type progressPublisher struct {
+	path string
+	task int
+	last time.Time
+}
+
+func (p *progressPublisher) report(ctx context.Context, progress handbrake.Progress, now time.Time) {
+	if progress.Task == p.task && now.Sub(p.last) < progressInterval {
+		return
+	}
+	p.task, p.last = progress.Task, now
+	Publish(ctx, PipelineEvent{Type: EventProgress, Path: p.path, Progress: &progress})
+}
+
+// watchEncode sends an alert when the transcode is taking too long, until done is closed.
+func (s *LocalSink) watchEncode(ctx context.Context, done <-chan struct{}, dog *handbrake.Watchdog, claimPath string) {
+	ticker := time.NewTicker(encodeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			alert, ok := dog.Check(now)
+			if !ok {
+				continue
+			}
+			err := s.Notifier.Notify(tag(ctx, fmt.Sprintf("transcoding %s %s", filepath.Base(claimPath), alert)))
+			if err != nil {
+				logln(ctx, err)
+			}
+		}
+	}
+}
+
+// cleanup moves a video that couldn't be processed to the failed directory,
+// and removes its transcoded video file.
+func (s *LocalSink) cleanup(claimPath, transcodedPath string) {
+	if _, err := os.Stat(claimPath); err == nil {
+		cleanupFailedClaim(s.Sandbox, s.ClaimDir, s.FailedDir, claimPath)
+	}
+
+	err := s.Sandbox.Remove(transcodedPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(errors.Wrapf(err, "unable to remove %s", transcodedPath))
+	}
+}
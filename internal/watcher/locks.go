@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// RejectLock is a file in the lock directory, instead of a video.
+const RejectLock fs.RejectReason = "lock"
+
+// minLockRetry is the shortest wait before trying again to lock a video that another process holds.
+const minLockRetry = 10 * time.Second
+
+// videoLocks coordinates processing the videos of a shared watch directory between watchers.
+type videoLocks struct {
+	dir *fs.LockDir
+
+	// watchDir is where the videos are found, the locks use the path of a video relative to it,
+	// so that watchers that mount the watch directory at different paths share the locks.
+	watchDir string
+}
+
+// UseLocks locks each video in the lock directory before it is handed to the sinks, and releases
+// the lock once the sinks are done with it, so that multiple watchers, or a watcher that restarted,
+// sharing the watch directory don't process the same video twice. The JobSink keeps the lock until
+// the jobs of the video finish, see keepVideoLock. A video that is locked by another watcher is
+// tried again once its lock would be stale, and is skipped when it was removed by then.
+func (w *VideoWatcher) UseLocks(dir *fs.LockDir, watchDir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.locks = &videoLocks{dir: dir, watchDir: watchDir}
+}
+
+// key of the video in the lock directory.
+func (l *videoLocks) key(path string) string {
+	rel, err := filepath.Rel(l.watchDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// lockVideo acquires the lock for a video. Returns false when the video was not locked, because it
+// is a lock file, another watcher holds the lock, or the lock failed, which was already handled.
+func (w *VideoWatcher) lockVideo(locks *videoLocks, file fs.FileEvent) (*fs.Lock, bool) {
+	if locks.dir.IsLock(file.Path) {
+		w.finish(file, false)
+		w.reject(fs.RejectedFile{Path: file.Path, Reason: RejectLock})
+		return nil, false
+	}
+
+	lock, err := locks.dir.Acquire(locks.key(file.Path))
+	if lockedErr, ok := err.(fs.LockedError); ok {
+		retry := time.Until(lockedErr.TouchedAt.Add(locks.dir.Stale))
+		if retry < minLockRetry {
+			retry = minLockRetry
+		}
+		log.Printf("%s, trying again in %s\n", lockedErr, retry.Round(time.Second))
+		// Keep the video in the work queue until it is handled
+		w.finish(file, true)
+		time.AfterFunc(retry, func() { w.retryLocked(file) })
+		return nil, false
+	}
+	if err != nil {
+		w.finish(file, true)
+		w.events.publish(PipelineEvent{Type: EventFailed, Path: file.Path, Err: err})
+		w.reportError(errors.Wrapf(err, "unable to lock %s", file.Path))
+		return nil, false
+	}
+	return lock, true
+}
+
+type videoLockKey struct{}
+
+// videoLock is the lock of the video that the sinks are handling.
+type videoLock struct {
+	lock *fs.Lock
+
+	// kept is set once a sink keeps the lock after it handled the video, see keepVideoLock.
+	kept bool
+}
+
+// withVideoLock passes the lock of the video to the sinks, which may keep it.
+func withVideoLock(ctx context.Context, lock *fs.Lock) (context.Context, *videoLock) {
+	l := &videoLock{lock: lock}
+	return context.WithValue(ctx, videoLockKey{}, l), l
+}
+
+// keepVideoLock keeps the lock of the video that the sink is handling, when the watcher uses
+// locks, once the sink handled the video, such as until its jobs finish. The lock is touched
+// until the returned function releases it. A video that the sink failed to handle is released
+// by the watcher anyway.
+func keepVideoLock(ctx context.Context) func() {
+	l, ok := ctx.Value(videoLockKey{}).(*videoLock)
+	if !ok {
+		return func() {}
+	}
+	l.kept = true
+	return l.release
+}
+
+func (l *videoLock) release() {
+	err := l.lock.Release()
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// retryLocked hands a video that was locked by another watcher to the sinks again, unless
+// the other watcher already claimed it, or the video watcher is closed.
+func (w *VideoWatcher) retryLocked(file fs.FileEvent) {
+	if w.ctx.Err() != nil {
+		return
+	}
+	if isRemoved(file.Path) {
+		log.Printf("%s was handled by the watcher that locked it, skipping\n", file.Path)
+		w.finish(file, false)
+		w.reject(fs.RejectedFile{Path: file.Path, Reason: RejectSourceRemoved})
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dispatchLocked(file, false)
+}
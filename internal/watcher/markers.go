@@ -0,0 +1,191 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// RejectMarker is a processing marker created by the watcher, instead of a video.
+const RejectMarker fs.RejectReason = "marker"
+
+// markerSweepInterval is how often the markers of finished videos are removed, when the
+// videos are processed by jobs.
+const markerSweepInterval = time.Minute
+
+// ProcessingMarker creates a marker file next to a video in the watch directory while the video
+// is processed, so that other tools and people can see that it is being handled. The marker
+// contains the path of the claimed video, and is removed once the claimed video is gone.
+type ProcessingMarker struct {
+	// Suffix is appended to the path of the video for its marker, e.g. ".processing".
+	// When empty, markers are not created.
+	Suffix string
+
+	// Cipher optionally encrypts the contents of the markers, so that the paths are kept private.
+	Cipher *fs.Cipher
+
+	// Dir optionally keeps the markers in this directory, at the path of the video relative
+	// to WatchDir, instead of next to the video, such as when the watch directory is read-only.
+	Dir, WatchDir string
+
+	// ClaimDir is where the videos are claimed. A sweep only moves a claimed video named by a
+	// marker when it is in this directory, since anyone who can write to the watch directory
+	// can write a marker.
+	ClaimDir string
+
+	// Sandbox optionally refuses to move or remove a claimed video outside of its allowed directories.
+	Sandbox *fs.Sandbox
+}
+
+// IsMarker determines if the path is a marker, instead of a video.
+func (m ProcessingMarker) IsMarker(path string) bool {
+	return m.Suffix != "" && strings.HasSuffix(path, m.Suffix)
+}
+
+// markerPath is the path of the marker for a video in the watch directory.
+func (m ProcessingMarker) markerPath(path string) string {
+	if m.Dir != "" {
+		if rel, err := filepath.Rel(m.WatchDir, path); err == nil {
+			return filepath.Join(m.Dir, rel) + m.Suffix
+		}
+	}
+	return path + m.Suffix
+}
+
+// Create the marker for a video that was claimed. Errors are logged, a missing
+// marker doesn't stop the video from being processed.
+func (m ProcessingMarker) Create(path, claimPath string) {
+	if m.Suffix == "" {
+		return
+	}
+
+	markerPath := m.markerPath(path)
+	if m.Dir != "" {
+		err := os.MkdirAll(filepath.Dir(markerPath), 0755)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "unable to create the directory of the processing marker %s", markerPath))
+			return
+		}
+	}
+	err := m.Cipher.WriteFile(markerPath, []byte(claimPath+"\n"), 0644)
+	if err != nil {
+		log.Println(errors.Wrapf(err, "unable to create the processing marker %s", markerPath))
+	}
+}
+
+// Remove the marker for a video.
+func (m ProcessingMarker) Remove(path string) {
+	if m.Suffix == "" {
+		return
+	}
+
+	markerPath := m.markerPath(path)
+	err := os.Remove(markerPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.Println(errors.Wrapf(err, "unable to remove the processing marker %s", markerPath))
+	}
+}
+
+// Sweep checks the markers in the watch directory, removing the markers of videos that
+// are no longer claimed. When requeue is true, a marker for a video that is still claimed
+// is interrupted work, and the claimed video is moved back to the watch directory to be
+// processed again. When the video is still in the watch directory, because it was copied
+// from a read-only watch directory, the claimed copy is removed instead.
+func (m ProcessingMarker) Sweep(watchDir string, requeue bool) {
+	if m.Suffix == "" {
+		return
+	}
+
+	markerDir := watchDir
+	if m.Dir != "" {
+		markerDir = m.Dir
+	}
+	filepath.Walk(markerDir, func(markerPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !m.IsMarker(markerPath) {
+			return nil
+		}
+
+		contents, err := m.Cipher.ReadFile(markerPath)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "unable to read the processing marker %s", markerPath))
+			return nil
+		}
+		path := strings.TrimSuffix(markerPath, m.Suffix)
+		if m.Dir != "" {
+			rel, _ := filepath.Rel(m.Dir, path)
+			path = filepath.Join(watchDir, rel)
+		}
+		claimPath, err := m.claimedPath(contents)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "ignoring the processing marker %s", markerPath))
+			return nil
+		}
+
+		if info, err := os.Lstat(claimPath); err == nil {
+			if !requeue {
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				log.Printf("ignoring the processing marker %s, the claimed video %s isn't a regular file\n", markerPath, claimPath)
+				return nil
+			}
+			if _, err := os.Stat(path); err == nil {
+				log.Printf("found interrupted work for %s, removing the claimed copy %s\n", path, claimPath)
+				err = m.Sandbox.Remove(claimPath)
+				if err != nil {
+					log.Println(errors.Wrapf(err, "unable to remove %s", claimPath))
+					return nil
+				}
+				m.Remove(path)
+				return nil
+			}
+			log.Printf("found interrupted work for %s, moving %s back to be processed again\n", path, claimPath)
+			err = m.Sandbox.MoveFile(claimPath, path)
+			if err != nil {
+				log.Println(errors.Wrapf(err, "unable to move %s back to %s", claimPath, path))
+				return nil
+			}
+		}
+
+		m.Remove(path)
+		return nil
+	})
+}
+
+// claimedPath is the claimed video named by the contents of a marker, when it is a clean
+// absolute path in the claim directory.
+func (m ProcessingMarker) claimedPath(contents []byte) (string, error) {
+	claimPath := strings.TrimSpace(string(contents))
+	if m.ClaimDir == "" {
+		return "", errors.Errorf("unable to check the claimed video %s, the claim directory is unknown", claimPath)
+	}
+	claimDir := filepath.Clean(m.ClaimDir)
+	if !filepath.IsAbs(claimPath) || filepath.Clean(claimPath) != claimPath || !strings.HasPrefix(claimPath, claimDir+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid claimed video %q, must be in the claim directory %s", claimPath, claimDir)
+	}
+	return claimPath, nil
+}
+
+// SweepUntil sweeps the markers in the watch directory periodically, until done is closed.
+func (m ProcessingMarker) SweepUntil(done <-chan struct{}, watchDir string) {
+	if m.Suffix == "" {
+		return
+	}
+
+	ticker := time.NewTicker(markerSweepInterval)
+	defer ticker.Stop()
+	for {
+		m.Sweep(watchDir, false)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
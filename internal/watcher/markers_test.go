@@ -0,0 +1,212 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestProcessingMarker_Sweep(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claim")
+	for _, dir := range []string{watchDir, claimDir} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	m := ProcessingMarker{Suffix: ".processing", ClaimDir: claimDir}
+	finished := filepath.Join(watchDir, "finished.mkv")
+	m.Create(finished, filepath.Join(claimDir, "finished.mkv"))
+
+	interrupted := filepath.Join(watchDir, "interrupted.mkv")
+	interruptedClaim := filepath.Join(claimDir, "interrupted.mkv")
+	createFile(t, interruptedClaim)
+	m.Create(interrupted, interruptedClaim)
+
+	if !m.IsMarker(interrupted + ".processing") {
+		t.Fatal("expected the marker to be recognized")
+	}
+
+	// Jobs may still be processing a claimed video
+	m.Sweep(watchDir, false)
+	if _, err := os.Stat(finished + ".processing"); !os.IsNotExist(err) {
+		t.Fatal("expected the marker of the finished video to be removed")
+	}
+	if _, err := os.Stat(interrupted + ".processing"); err != nil {
+		t.Fatalf("expected the marker of the claimed video to be kept, %v", err)
+	}
+
+	// After a restart, a claimed video was interrupted
+	m.Sweep(watchDir, true)
+	if _, err := os.Stat(interrupted + ".processing"); !os.IsNotExist(err) {
+		t.Fatal("expected the marker of the interrupted video to be removed")
+	}
+	if _, err := os.Stat(interrupted); err != nil {
+		t.Fatalf("expected the interrupted video to be moved back to the watch directory, %v", err)
+	}
+}
+
+func TestProcessingMarker_SweepDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	markerDir := filepath.Join(tmpDir, "markers")
+	claimDir := filepath.Join(tmpDir, "claim")
+
+	m := ProcessingMarker{Suffix: ".processing", Dir: markerDir, WatchDir: watchDir, ClaimDir: claimDir}
+	video := filepath.Join(watchDir, "Movies", "foo.mkv")
+	claimPath := filepath.Join(claimDir, "Movies", "foo.mkv")
+	writeTestFile(t, video, "raw", time.Now())
+	writeTestFile(t, claimPath, "raw", time.Now())
+	m.Create(video, claimPath)
+
+	markerPath := filepath.Join(markerDir, "Movies", "foo.mkv.processing")
+	if _, err := os.Stat(markerPath); err != nil {
+		t.Fatalf("expected the marker to be created in the marker directory, %v", err)
+	}
+	if _, err := os.Stat(video + ".processing"); !os.IsNotExist(err) {
+		t.Fatal("expected no marker next to the video")
+	}
+
+	// The video was copied from a read-only watch directory, so only the copy is removed
+	m.Sweep(watchDir, true)
+	if _, err := os.Stat(markerPath); !os.IsNotExist(err) {
+		t.Fatal("expected the marker of the interrupted video to be removed")
+	}
+	if _, err := os.Stat(claimPath); !os.IsNotExist(err) {
+		t.Fatal("expected the claimed copy of the interrupted video to be removed")
+	}
+	if _, err := os.Stat(video); err != nil {
+		t.Fatalf("expected the video to be left in the watch directory, %v", err)
+	}
+}
+
+func TestProcessingMarker_SweepForgedMove(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claim")
+	m := ProcessingMarker{Suffix: ".processing", ClaimDir: claimDir}
+
+	// Markers naming a file elsewhere, directly or by escaping the claim directory
+	secret := filepath.Join(tmpDir, "secret.txt")
+	writeTestFile(t, secret, "secret", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "foo.mkv.processing"), secret+"\n", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "bar.mkv.processing"), claimDir+"/../secret.txt\n", time.Now())
+
+	m.Sweep(watchDir, true)
+	if _, err := os.Stat(secret); err != nil {
+		t.Fatalf("expected the file named by a forged marker to be left alone, %v", err)
+	}
+	for _, name := range []string{"foo.mkv", "bar.mkv"} {
+		if _, err := os.Stat(filepath.Join(watchDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("expected nothing to be moved to %s", name)
+		}
+	}
+}
+
+func TestProcessingMarker_SweepForgedRemove(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claim")
+	workDir := filepath.Join(tmpDir, "work")
+	m := ProcessingMarker{Suffix: ".processing", ClaimDir: claimDir}
+
+	// The videos are still in the watch directory, so a sweep would remove the claimed copies
+	writeTestFile(t, filepath.Join(workDir, "state.json"), "{}", time.Now())
+	writeTestFile(t, filepath.Join(claimDir, "Movies", "bar.mkv"), "raw", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "foo.mkv"), "raw", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "foo.mkv.processing"), workDir+"\n", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "bar.mkv"), "raw", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "bar.mkv.processing"), filepath.Join(claimDir, "Movies")+"\n", time.Now())
+
+	m.Sweep(watchDir, true)
+	if _, err := os.Stat(filepath.Join(workDir, "state.json")); err != nil {
+		t.Fatalf("expected the directory named by a forged marker outside of the claim directory to be left alone, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(claimDir, "Movies", "bar.mkv")); err != nil {
+		t.Fatalf("expected a directory in the claim directory to never be removed, %v", err)
+	}
+}
+
+func TestProcessingMarker_SweepSandbox(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claim")
+	sandbox, err := fs.NewSandbox(claimDir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	m := ProcessingMarker{Suffix: ".processing", ClaimDir: claimDir, Sandbox: sandbox}
+
+	// The watch directory is outside of the sandbox, so the video can't be moved back to it
+	video := filepath.Join(watchDir, "foo.mkv")
+	claimPath := filepath.Join(claimDir, "foo.mkv")
+	writeTestFile(t, claimPath, "raw", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, ".keep"), "", time.Now())
+	m.Create(video, claimPath)
+
+	m.Sweep(watchDir, true)
+	if _, err := os.Stat(claimPath); err != nil {
+		t.Fatalf("expected the claimed video to be left in place, %v", err)
+	}
+	if _, err := os.Stat(video); !os.IsNotExist(err) {
+		t.Fatal("expected the claimed video to not be moved outside of the sandbox")
+	}
+}
+
+func TestProcessingMarker_SweepUnencrypted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	claimDir := filepath.Join(tmpDir, "claim")
+	cipher, err := fs.NewCipher("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	m := ProcessingMarker{Suffix: ".processing", ClaimDir: claimDir, Cipher: cipher}
+
+	// A plaintext marker wasn't written by the watcher once the markers are encrypted
+	claimPath := filepath.Join(claimDir, "foo.mkv")
+	writeTestFile(t, claimPath, "raw", time.Now())
+	writeTestFile(t, filepath.Join(watchDir, "foo.mkv.processing"), claimPath+"\n", time.Now())
+
+	m.Sweep(watchDir, true)
+	if _, err := os.Stat(claimPath); err != nil {
+		t.Fatalf("expected the claimed video named by an unencrypted marker to be left alone, %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package watcher
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// NameStrategy names the jobs of a video, and its transcoded video, so that a deployment with its
+// own conventions, such as a UUID or a timestamp prefix on the job names, or the Plex naming scheme
+// for the videos, can supply its own. The names are checked by ValidatedNames before they are used.
+type NameStrategy interface {
+	// JobName is the name of a job for the video, before the -transcode or -upload suffix of its
+	// template. The path is the file that the job reads: the claimed video for a transcode job,
+	// and the transcoded video for an upload job.
+	JobName(e fs.FileEvent, path string) (string, error)
+
+	// OutputName is the path of the transcoded video, relative to the transcoded directory, for
+	// the video at the path suffix in the watch directory. The container, such as .mp4, is empty
+	// when the video keeps its own. The video is uploaded to the same path, unless it is organized.
+	OutputName(e fs.FileEvent, pathSuffix, container string) (string, error)
+}
+
+// SanitizedNames is the default NameStrategy. The jobs are named after the file that they read,
+// with the characters that aren't allowed in a k8s name replaced with dashes, and the transcoded
+// video keeps the path of the video, with the extension of its container.
+type SanitizedNames struct{}
+
+// JobName is the sanitized name of the file that the job reads.
+func (SanitizedNames) JobName(e fs.FileEvent, path string) (string, error) {
+	return jobs.SanitizeJobName(filepath.Base(path)), nil
+}
+
+// OutputName is the path suffix of the video, with the extension of its container.
+func (SanitizedNames) OutputName(e fs.FileEvent, pathSuffix, container string) (string, error) {
+	return outputSuffix(pathSuffix, e, container), nil
+}
+
+// longestJobSuffix is the longest suffix that a job template adds to the name of a job.
+const longestJobSuffix = "-transcode"
+
+// ValidatedNames wraps a NameStrategy, rejecting the job names that aren't valid DNS-1123 labels
+// once a template adds its suffix, which k8s requires of the job-name label of the pods, and the
+// output names that aren't relative paths in the transcoded directory.
+type ValidatedNames struct {
+	Names NameStrategy
+}
+
+// JobName is the name of the wrapped strategy, when it is a valid job name.
+func (v ValidatedNames) JobName(e fs.FileEvent, path string) (string, error) {
+	name, err := v.Names.JobName(e, path)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to name the jobs of %s", path)
+	}
+	return name, validateJobName(name, path)
+}
+
+// validateJobName checks that the name of the jobs of the video at the path is a valid DNS-1123
+// label once a template adds its suffix. A name that the JobSink changes, such as the batch- prefix
+// of a batch, or the device of a device job, is checked again once it is changed.
+func validateJobName(name, path string) error {
+	if msgs := validation.IsDNS1123Label(name + longestJobSuffix); len(msgs) > 0 {
+		return errors.Errorf("invalid job name %q for %s: %s", name, path, strings.Join(msgs, ", "))
+	}
+	return nil
+}
+
+// OutputName is the name of the wrapped strategy, when it is a relative path in the transcoded directory.
+func (v ValidatedNames) OutputName(e fs.FileEvent, pathSuffix, container string) (string, error) {
+	name, err := v.Names.OutputName(e, pathSuffix, container)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to name the transcoded video of %s", pathSuffix)
+	}
+	clean := filepath.Clean(name)
+	if name == "" || filepath.IsAbs(name) || clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("invalid output name %q for %s, must be a relative path in the transcoded directory", name, pathSuffix)
+	}
+	return clean, nil
+}
+
+// validNames validates the names of the strategy, which defaults to SanitizedNames.
+func validNames(names NameStrategy) NameStrategy {
+	if names == nil {
+		names = SanitizedNames{}
+	}
+	return ValidatedNames{Names: names}
+}
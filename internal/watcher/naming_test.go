@@ -0,0 +1,150 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// fixedNames names every job and transcoded video the same, to test the validation.
+type fixedNames struct {
+	job, output string
+}
+
+func (n fixedNames) JobName(e fs.FileEvent, path string) (string, error) {
+	return n.job, nil
+}
+
+func (n fixedNames) OutputName(e fs.FileEvent, pathSuffix, container string) (string, error) {
+	return n.output, nil
+}
+
+func TestValidatedNames(t *testing.T) {
+	testcases := []struct {
+		Name          string
+		Names         fixedNames
+		WantJobErr    bool
+		WantOutputErr bool
+	}{
+		{Name: "valid", Names: fixedNames{job: "vid-1234", output: "Movies/foo.mkv"}},
+		{Name: "uppercase job", Names: fixedNames{job: "Foo", output: "foo.mkv"}, WantJobErr: true},
+		{Name: "leading dash", Names: fixedNames{job: "-foo", output: "foo.mkv"}, WantJobErr: true},
+		{Name: "too long with its suffix", Names: fixedNames{job: strings.Repeat("a", 54), output: "foo.mkv"}, WantJobErr: true},
+		{Name: "empty output", Names: fixedNames{job: "foo", output: ""}, WantOutputErr: true},
+		{Name: "absolute output", Names: fixedNames{job: "foo", output: "/plex/foo.mkv"}, WantOutputErr: true},
+		{Name: "escaping output", Names: fixedNames{job: "foo", output: "../foo.mkv"}, WantOutputErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			names := validNames(tc.Names)
+			e := fs.FileEvent{Path: "/watch/Movies/foo.mkv"}
+
+			_, err := names.JobName(e, e.Path)
+			if tc.WantJobErr != (err != nil) {
+				t.Fatalf("expected the job name error to be %t, got %v", tc.WantJobErr, err)
+			}
+			_, err = names.OutputName(e, "Movies/foo.mkv", "")
+			if tc.WantOutputErr != (err != nil) {
+				t.Fatalf("expected the output name error to be %t, got %v", tc.WantOutputErr, err)
+			}
+		})
+	}
+}
+
+func TestSanitizedNames(t *testing.T) {
+	names := validNames(nil)
+	e := fs.FileEvent{Path: "/watch/Movies/Foo Bar.mkv"}
+
+	name, err := names.JobName(e, e.Path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if name != "foo-bar-mkv" {
+		t.Fatalf("expected the sanitized file name, got %s", name)
+	}
+
+	output, err := names.OutputName(e, "Movies/Foo Bar.mkv", ".mp4")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if output != filepath.Join("Movies", "Foo Bar.mp4") {
+		t.Fatalf("expected the path of the video with its container, got %s", output)
+	}
+}
+
+func TestJobSink_Names(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	s.Names = fixedNames{job: "vid-1234", output: "Movies/foo.mkv"}
+
+	transcoded := filepath.Join(tmpDir, "work", "transcoded", "Movies", "foo.mkv")
+	claimed := filepath.Join(tmpDir, "work", "claimed", "Movies", "foo.mkv")
+	name, err := s.createUploadJob(context.Background(), s.Targets.Default, fs.FileEvent{Path: claimed}, "", transcoded, claimed, "Movies/foo.mkv", "Movies/foo.mkv", "Movies", false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if name != "vid-1234-upload" {
+		t.Fatalf("expected the job to be named by the strategy, got %s", name)
+	}
+
+	s.Names = fixedNames{job: "Invalid_Name", output: "Movies/foo.mkv"}
+	_, err = s.createUploadJob(context.Background(), s.Targets.Default, fs.FileEvent{Path: claimed}, "", transcoded, claimed, "Movies/foo.mkv", "Movies/foo.mkv", "Movies", false)
+	if err == nil {
+		t.Fatal("expected an invalid job name to be rejected")
+	}
+}
+
+func TestJobSink_NamesOfBatchAndDeviceJobs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	// The name is valid on its own, but too long once the batch prefix or the device is added
+	s.Names = fixedNames{job: strings.Repeat("a", 50), output: "Movies/foo.mkv"}
+
+	claimed := filepath.Join(s.ClaimDir, "Movies", "foo.mkv")
+	err = os.MkdirAll(filepath.Dir(claimed), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = ioutil.WriteFile(claimed, []byte("foo"), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	o := deviceOutput{Device: DeviceProfile{Name: "tablet", Preset: "tivo"}, TranscodedSuffix: "tablet/Movies/foo.mkv", DestSuffix: "Movies/foo.mkv", Library: "Movies"}
+	_, _, err = s.createDeviceJob(context.Background(), s.Targets.Default, JobProfile{}, fs.FileEvent{Path: claimed}, o, claimed, "Movies/foo.mkv", nil, nil, true)
+	if err == nil || !strings.Contains(err.Error(), "invalid job name") {
+		t.Fatalf("expected the device job name to be rejected, got %v", err)
+	}
+
+	v := batchVideo{Target: s.Targets.Default, Path: filepath.Join(s.WatchDir, "Movies", "foo.mkv"), ClaimPath: claimed, PathSuffix: "Movies/foo.mkv", DestSuffix: "Movies/foo.mkv"}
+	err = s.createBatchJobs(context.Background(), s.Targets.Default, []batchVideo{v})
+	if err == nil || !strings.Contains(err.Error(), "invalid job name") {
+		t.Fatalf("expected the batch job name to be rejected, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.FailedDir, "Movies", "foo.mkv")); err != nil {
+		t.Fatalf("expected the videos of the batch to be moved to the failed directory, got %v", err)
+	}
+	if created, _ := cluster.List(""); len(created) > 0 {
+		t.Fatalf("expected no jobs to be created, got %v", created)
+	}
+}
@@ -0,0 +1,132 @@
+package watcher
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/pkg/errors"
+)
+
+// OrganizeTemplate is a template for where a video is uploaded in the Plex
+// share, for example "{{.Library}}/{{.Title}} ({{.Year}})/{{.Title}} ({{.Year}}){{.Ext}}".
+// It may be used as a flag. When empty, videos are uploaded to the same path
+// that they had in the watch directory.
+type OrganizeTemplate struct {
+	text string
+	tmpl *template.Template
+}
+
+// OrganizeValues are available to an OrganizeTemplate.
+type OrganizeValues struct {
+	// Path of the video relative to the watch directory, e.g. Movies/Foo.2019.1080p.mkv
+	Path string
+
+	// Library is the first directory in the path, e.g. Movies
+	Library string
+
+	// Dir is the directory of the video in the library, e.g. "" or Foo
+	Dir string
+
+	// Name of the video without the extension, e.g. Foo.2019.1080p
+	Name string
+
+	// Ext is the extension of the video, including the dot, e.g. .mkv
+	Ext string
+
+	// Title parsed from the name, before the year or episode, e.g. Foo
+	Title string
+
+	// Year parsed from the name, e.g. 2019, or empty
+	Year string
+
+	// Season and Episode numbers parsed from the name, e.g. S01E02, or zero
+	Season, Episode int
+
+	// Metadata of the video, when it was probed, otherwise nil.
+	Metadata *ffprobe.Metadata
+}
+
+var (
+	// Names often use underscores between words, so \b can't be used as a word boundary
+	yearPattern    = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])[(\[]?((?:19|20)\d\d)[)\]]?(?:$|[^a-z0-9])`)
+	episodePattern = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])S(\d{1,2})E(\d{1,3})(?:$|[^0-9])`)
+	titleSeparator = regexp.MustCompile(`[._\s]+`)
+)
+
+// String returns the template text.
+func (o *OrganizeTemplate) String() string {
+	if o == nil {
+		return ""
+	}
+	return o.text
+}
+
+// Set parses the template.
+func (o *OrganizeTemplate) Set(value string) error {
+	tmpl, err := template.New("organize").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid organize template %q", value)
+	}
+	o.text, o.tmpl = value, tmpl
+	return nil
+}
+
+// Destination returns where the video is uploaded, relative to the Plex share.
+func (o OrganizeTemplate) Destination(pathSuffix string, m *ffprobe.Metadata) (string, error) {
+	if o.tmpl == nil {
+		return pathSuffix, nil
+	}
+
+	var buf bytes.Buffer
+	err := o.tmpl.Execute(&buf, NewOrganizeValues(pathSuffix, m))
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to organize %s", pathSuffix)
+	}
+
+	dest := filepath.Clean(strings.TrimSpace(buf.String()))
+	if dest == "." || filepath.IsAbs(dest) || dest == ".." || strings.HasPrefix(dest, ".."+string(os.PathSeparator)) {
+		return "", errors.Errorf("unable to organize %s, %q must be a file in the Plex share", pathSuffix, dest)
+	}
+	return dest, nil
+}
+
+// NewOrganizeValues parses the parts of the video's path.
+func NewOrganizeValues(pathSuffix string, m *ffprobe.Metadata) OrganizeValues {
+	v := OrganizeValues{
+		Path:     pathSuffix,
+		Library:  libraryName(pathSuffix),
+		Ext:      filepath.Ext(pathSuffix),
+		Metadata: m,
+	}
+	v.Name = strings.TrimSuffix(filepath.Base(pathSuffix), v.Ext)
+	if dir := filepath.Dir(pathSuffix); dir != v.Library && dir != "." {
+		v.Dir, _ = filepath.Rel(v.Library, dir)
+	}
+
+	// The title is everything before the first year or episode
+	titleEnd := len(v.Name)
+	if loc := episodePattern.FindStringSubmatchIndex(v.Name); loc != nil {
+		v.Season, _ = strconv.Atoi(v.Name[loc[2]:loc[3]])
+		v.Episode, _ = strconv.Atoi(v.Name[loc[4]:loc[5]])
+		titleEnd = loc[0]
+	}
+	if loc := yearPattern.FindStringSubmatchIndex(v.Name); loc != nil && loc[0] > 0 {
+		v.Year = v.Name[loc[2]:loc[3]]
+		if loc[0] < titleEnd {
+			titleEnd = loc[0]
+		}
+	}
+
+	title := titleSeparator.ReplaceAllString(v.Name[:titleEnd], " ")
+	v.Title = strings.TrimSpace(strings.Trim(strings.TrimSpace(title), "-([ "))
+	if v.Title == "" {
+		v.Title = v.Name
+	}
+	return v
+}
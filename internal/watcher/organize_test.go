@@ -0,0 +1,65 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+)
+
+func TestNewOrganizeValues(t *testing.T) {
+	testcases := []struct {
+		Path                    string
+		WantTitle, WantYear     string
+		WantSeason, WantEpisode int
+		WantDir                 string
+	}{
+		{Path: "Movies/The.Matrix.1999.1080p.BluRay.mkv", WantTitle: "The Matrix", WantYear: "1999"},
+		{Path: "Movies/Heat (1995)/Heat (1995).mkv", WantTitle: "Heat", WantYear: "1995", WantDir: "Heat (1995)"},
+		{Path: "TV/Show/Some_Show_S02E10_720p.mkv", WantTitle: "Some Show", WantSeason: 2, WantEpisode: 10, WantDir: "Show"},
+		{Path: "Movies/2012.mkv", WantTitle: "2012"},
+		{Path: "Movies/home video.mkv", WantTitle: "home video"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Path, func(t *testing.T) {
+			v := NewOrganizeValues(filepath.FromSlash(tc.Path), nil)
+			if v.Title != tc.WantTitle || v.Year != tc.WantYear || v.Season != tc.WantSeason || v.Episode != tc.WantEpisode {
+				t.Fatalf("unexpected values %#v", v)
+			}
+			if v.Dir != tc.WantDir || v.Ext != ".mkv" {
+				t.Fatalf("unexpected path values %#v", v)
+			}
+		})
+	}
+}
+
+func TestOrganizeTemplate_Destination(t *testing.T) {
+	var o OrganizeTemplate
+	err := o.Set(`{{.Library}}/{{.Title}}{{if .Year}} ({{.Year}}){{end}}/{{.Title}}{{if .Metadata}} {{.Metadata.Height}}p{{end}}{{.Ext}}`)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	got, err := o.Destination(filepath.FromSlash("Movies/The.Matrix.1999.mkv"), &ffprobe.Metadata{Height: 1080})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := filepath.FromSlash("Movies/The Matrix (1999)/The Matrix 1080p.mkv")
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+
+	var escape OrganizeTemplate
+	escape.Set("../{{.Name}}{{.Ext}}")
+	_, err = escape.Destination("Movies/foo.mkv", nil)
+	if err == nil {
+		t.Fatal("expected a destination outside of the Plex share to be rejected")
+	}
+
+	var none OrganizeTemplate
+	got, err = none.Destination("Movies/foo.mkv", nil)
+	if err != nil || got != "Movies/foo.mkv" {
+		t.Fatalf("expected the path to be unchanged without a template, got %s, %v", got, err)
+	}
+}
@@ -0,0 +1,34 @@
+package watcher
+
+import (
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/s3"
+	"github.com/carolynvs/handbrk8s/internal/uploader"
+)
+
+// OutputBucket is an S3 compatible bucket where the transcoded videos are uploaded,
+// instead of the Plex share, for example so that the finished videos live in object
+// storage while the transcodes run on the cluster.
+type OutputBucket struct {
+	s3.Config
+
+	// Prefix is prepended to the key of each video, for example videos/
+	Prefix string
+
+	// Secret is the name of a secret with the access-key and secret-key of the bucket,
+	// which are read by the upload jobs. When empty, the keys are embedded in the jobs.
+	Secret string
+}
+
+// IsSet determines if the videos are uploaded to the bucket.
+func (b OutputBucket) IsSet() bool {
+	return b.Bucket != ""
+}
+
+// destination is where the videos are uploaded, the bucket when it is set, otherwise the share.
+func (b OutputBucket) destination(share string, sandbox *fs.Sandbox) uploader.Destination {
+	if b.IsSet() {
+		return uploader.BucketDestination{Client: s3.NewClient(b.Config), Prefix: b.Prefix}
+	}
+	return uploader.LocalDestination{Dir: share, Sandbox: sandbox}
+}
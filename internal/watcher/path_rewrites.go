@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PathRewrite replaces the From prefix of a path, as the watcher sees it, with the To prefix,
+// where the same directory is mounted in the pods of the jobs.
+type PathRewrite struct {
+	From, To string
+}
+
+// PathRewrites maps the paths that the watcher sees to the paths that the jobs see, for when
+// the media is mounted at different paths in the watcher and in the pods of the jobs.
+type PathRewrites []PathRewrite
+
+// Rewrite replaces the longest prefix of the path that matches a rewrite, or returns the path
+// as-is when none match. A prefix only matches whole directories, so /media doesn't match /media2.
+func (r PathRewrites) Rewrite(path string) string {
+	match := -1
+	for i, rewrite := range r {
+		if !hasPathPrefix(path, rewrite.From) {
+			continue
+		}
+		if match == -1 || len(rewrite.From) > len(r[match].From) {
+			match = i
+		}
+	}
+	if match == -1 {
+		return path
+	}
+	rewrite := r[match]
+	return filepath.Join(rewrite.To, strings.TrimPrefix(path, rewrite.From))
+}
+
+// RewriteAll rewrites each of the paths.
+func (r PathRewrites) RewriteAll(paths []string) []string {
+	if len(r) == 0 || paths == nil {
+		return paths
+	}
+	rewritten := make([]string, len(paths))
+	for i, path := range paths {
+		rewritten[i] = r.Rewrite(path)
+	}
+	return rewritten
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, string(os.PathSeparator)) ||
+		path[len(prefix)] == os.PathSeparator
+}
+
+// String formats the rewrites, separated by semicolons.
+func (r *PathRewrites) String() string {
+	if r == nil {
+		return ""
+	}
+	values := make([]string, len(*r))
+	for i, rewrite := range *r {
+		values[i] = rewrite.From + "=" + rewrite.To
+	}
+	return strings.Join(values, ";")
+}
+
+// Set parses a rewrite, WATCHER_PREFIX=JOB_PREFIX, and adds it to the rewrites.
+func (r *PathRewrites) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+		return errors.Errorf("invalid path rewrite %q, must be WATCHER_PREFIX=JOB_PREFIX", value)
+	}
+
+	from, to := filepath.Clean(strings.TrimSpace(parts[0])), filepath.Clean(strings.TrimSpace(parts[1]))
+	if !filepath.IsAbs(from) || !filepath.IsAbs(to) {
+		return errors.Errorf("invalid path rewrite %q, both prefixes must be absolute paths", value)
+	}
+	for _, rewrite := range *r {
+		if rewrite.From == from {
+			return errors.Errorf("invalid path rewrite %q, %s is already rewritten to %s", value, from, rewrite.To)
+		}
+	}
+	*r = append(*r, PathRewrite{From: from, To: to})
+	return nil
+}
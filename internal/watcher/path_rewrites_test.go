@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestPathRewrites_Rewrite(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the rewrites use unix paths")
+	}
+
+	var r PathRewrites
+	for _, value := range []string{"/mnt/media=/watch", "/mnt/media/work=/work/", "/=/host"} {
+		err := r.Set(value)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	testcases := []struct {
+		path, want string
+	}{
+		{"/mnt/media/Movies/foo.mkv", "/watch/Movies/foo.mkv"},
+		{"/mnt/media", "/watch"},
+		{"/mnt/media/work/claimed/foo.mkv", "/work/claimed/foo.mkv"},
+		{"/mnt/media2/foo.mkv", "/host/mnt/media2/foo.mkv"},
+	}
+	for _, tc := range testcases {
+		if got := r.Rewrite(tc.path); got != tc.want {
+			t.Errorf("expected %s to be rewritten to %s, got %s", tc.path, tc.want, got)
+		}
+	}
+
+	if got := r.String(); got != "/mnt/media=/watch;/mnt/media/work=/work;/=/host" {
+		t.Fatalf("unexpected string representation %s", got)
+	}
+	if got := (PathRewrites{}).Rewrite("/mnt/media/foo.mkv"); got != "/mnt/media/foo.mkv" {
+		t.Fatalf("expected the path to be unchanged without any rewrites, got %s", got)
+	}
+}
+
+func TestPathRewrites_SetInvalid(t *testing.T) {
+	for _, value := range []string{"/mnt/media", "=/watch", "/mnt/media=", "media=/watch", "/mnt/media=watch"} {
+		var r PathRewrites
+		err := r.Set(value)
+		if err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+
+	var r PathRewrites
+	r.Set("/mnt/media=/watch")
+	err := r.Set("/mnt/media/=/other")
+	if err == nil {
+		t.Fatal("expected a prefix that is already rewritten to be rejected")
+	}
+}
+
+func TestJobSink_PathRewrites(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the rewrites use unix paths")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	err = s.PathRewrites.Set(filepath.Join(tmpDir, "work") + "=/work")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	transcoded := filepath.Join(tmpDir, "work", "transcoded", "Movies", "foo.mkv")
+	claimed := filepath.Join(tmpDir, "work", "claimed", "Movies", "foo.mkv")
+	name, err := s.createUploadJob(context.Background(), s.Targets.Default, fs.FileEvent{Path: claimed}, "", transcoded, claimed, "Movies/foo.mkv", "Movies/foo.mkv", "Movies", false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	j := cluster.getJob(name)
+	flags := parseArgs(j.Spec.Template.Spec.Containers[0].Args)
+	if flags["-f"] != "/work/transcoded/Movies/foo.mkv" || flags["--raw"] != "/work/claimed/Movies/foo.mkv" {
+		t.Fatalf("expected the paths to be rewritten for the job, got %v", flags)
+	}
+}
+
+func TestJobSink_PathRewritesArchive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the rewrites use unix paths")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	s.ArchiveDir = filepath.Join(tmpDir, "work", "archive")
+	err = s.PathRewrites.Set(filepath.Join(tmpDir, "work") + "=/work")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	transcoded := filepath.Join(tmpDir, "work", "transcoded", "Movies", "foo.mkv")
+	claimed := filepath.Join(tmpDir, "work", "claimed", "Movies", "foo.mkv")
+	name, err := s.createUploadJob(context.Background(), s.Targets.Default, fs.FileEvent{Path: claimed}, "", transcoded, claimed, "Movies/foo.mkv", "Movies/foo.mkv", "Movies", false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	j := cluster.getJob(name)
+	flags := parseArgs(j.Spec.Template.Spec.Containers[0].Args)
+	if flags["--archive"] != "/work/archive/Movies/foo.mkv" {
+		t.Fatalf("expected the archive path to be rewritten for the job, got %v", flags)
+	}
+}
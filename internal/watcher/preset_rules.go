@@ -0,0 +1,244 @@
+package watcher
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/pkg/errors"
+)
+
+// PresetRules select the HandBrake preset for a video, using the first rule
+// that matches. They may be used as a flag, with each use adding a rule,
+// for example "path=TV/*,height<720=>Fast 480p30". A rule may also select a
+// job profile, for example "path=Kids/*=>Fast 480p30@kids" or "path=Kids/*=>@kids",
+// and an output container, for example "path=Web/*=>Fast 1080p30:mp4" or "path=Archive/*=>:mkv".
+type PresetRules []PresetRule
+
+// PresetRule selects a preset, and optionally a job profile and output container, for the
+// videos that match all of its conditions.
+type PresetRule struct {
+	Conditions []PresetCondition
+	Preset     string
+	Profile    string
+
+	// Container is the extension of the transcoded video, such as .mp4, whose container is
+	// written instead of the container of the preset. When empty, the transcoded video keeps
+	// the extension of the original video.
+	Container string
+}
+
+// PresetCondition compares the path of a video, relative to the watch directory,
+// or its probed metadata: width, height or codec, to a value.
+type PresetCondition struct {
+	Field, Op, Value string
+}
+
+// presetOps are the supported comparisons, longest first so that >= is not parsed as >.
+var presetOps = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// For returns the preset of the first rule that matches the video, or the default preset.
+// Rules with metadata conditions never match a video without metadata.
+func (r PresetRules) For(pathSuffix string, m *ffprobe.Metadata, defaultPreset string) string {
+	if rule, ok := r.Match(pathSuffix, m); ok && rule.Preset != "" {
+		return rule.Preset
+	}
+	return defaultPreset
+}
+
+// Match returns the first rule that matches the video.
+func (r PresetRules) Match(pathSuffix string, m *ffprobe.Metadata) (PresetRule, bool) {
+	for _, rule := range r {
+		if rule.matches(pathSuffix, m) {
+			return rule, true
+		}
+	}
+	return PresetRule{}, false
+}
+
+// Container returns the output container of the first rule that matches the video, or
+// empty when the video keeps its extension.
+func (r PresetRules) Container(pathSuffix string, m *ffprobe.Metadata) string {
+	if rule, ok := r.Match(pathSuffix, m); ok {
+		return rule.Container
+	}
+	return ""
+}
+
+// Presets lists the presets used by the rules.
+func (r PresetRules) Presets() []string {
+	var presets []string
+	for _, rule := range r {
+		if rule.Preset != "" {
+			presets = append(presets, rule.Preset)
+		}
+	}
+	return presets
+}
+
+// Profiles lists the job profiles used by the rules.
+func (r PresetRules) Profiles() []string {
+	var profiles []string
+	for _, rule := range r {
+		if rule.Profile != "" {
+			profiles = append(profiles, rule.Profile)
+		}
+	}
+	return profiles
+}
+
+// String formats the rules, separated by semicolons.
+func (r *PresetRules) String() string {
+	if r == nil {
+		return ""
+	}
+
+	rules := make([]string, len(*r))
+	for i, rule := range *r {
+		conditions := make([]string, len(rule.Conditions))
+		for j, c := range rule.Conditions {
+			conditions[j] = c.Field + c.Op + c.Value
+		}
+		target := rule.Preset
+		if rule.Profile != "" {
+			target += "@" + rule.Profile
+		}
+		if rule.Container != "" {
+			target += ":" + strings.TrimPrefix(rule.Container, ".")
+		}
+		rules[i] = fmt.Sprintf("%s=>%s", strings.Join(conditions, ","), target)
+	}
+	return strings.Join(rules, ";")
+}
+
+// Set parses a rule, CONDITION[,CONDITION...]=>[PRESET][@PROFILE][:CONTAINER], and adds it to the rules.
+func (r *PresetRules) Set(value string) error {
+	i := strings.Index(value, "=>")
+	if i < 0 {
+		return errors.Errorf("invalid preset rule %q, must be CONDITION[,CONDITION...]=>[PRESET][@PROFILE][:CONTAINER]", value)
+	}
+
+	rule := PresetRule{Preset: strings.TrimSpace(value[i+2:])}
+	if j := strings.LastIndex(rule.Preset, ":"); j >= 0 {
+		rule.Preset, rule.Container = strings.TrimSpace(rule.Preset[:j]), "."+strings.ToLower(strings.TrimSpace(rule.Preset[j+1:]))
+		if !handbrake.IsContainer(rule.Container) {
+			return errors.Errorf("invalid preset rule %q, the container must be one of %s", value, strings.Join(handbrake.Containers(), ", "))
+		}
+	}
+	if j := strings.LastIndex(rule.Preset, "@"); j >= 0 {
+		rule.Preset, rule.Profile = strings.TrimSpace(rule.Preset[:j]), strings.TrimSpace(rule.Preset[j+1:])
+		if rule.Profile == "" {
+			return errors.Errorf("invalid preset rule %q, missing the profile after @", value)
+		}
+	}
+	if rule.Preset == "" && rule.Profile == "" && rule.Container == "" {
+		return errors.Errorf("invalid preset rule %q, missing the preset", value)
+	}
+
+	for _, entry := range strings.Split(value[:i], ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		c, err := parsePresetCondition(entry)
+		if err != nil {
+			return errors.Wrapf(err, "invalid preset rule %q", value)
+		}
+		rule.Conditions = append(rule.Conditions, c)
+	}
+	if len(rule.Conditions) == 0 {
+		return errors.Errorf("invalid preset rule %q, missing a condition", value)
+	}
+
+	*r = append(*r, rule)
+	return nil
+}
+
+func parsePresetCondition(entry string) (PresetCondition, error) {
+	for _, op := range presetOps {
+		i := strings.Index(entry, op)
+		if i < 0 {
+			continue
+		}
+
+		c := PresetCondition{
+			Field: strings.TrimSpace(entry[:i]),
+			Op:    op,
+			Value: strings.TrimSpace(entry[i+len(op):]),
+		}
+		switch c.Field {
+		case "path":
+			if _, err := path.Match(c.Value, ""); err != nil {
+				return c, errors.Wrapf(err, "invalid path pattern %q", c.Value)
+			}
+			fallthrough
+		case "codec":
+			if op != "=" && op != "!=" {
+				return c, errors.Errorf("%s only supports = and !=", c.Field)
+			}
+		case "width", "height":
+			if _, err := strconv.Atoi(c.Value); err != nil {
+				return c, errors.Errorf("%s must be compared to a number, got %q", c.Field, c.Value)
+			}
+		default:
+			return c, errors.Errorf("unknown field %q, must be path, width, height or codec", c.Field)
+		}
+		return c, nil
+	}
+	return PresetCondition{}, errors.Errorf("invalid condition %q, must be FIELD OPERATOR VALUE", entry)
+}
+
+func (rule PresetRule) matches(pathSuffix string, m *ffprobe.Metadata) bool {
+	for _, c := range rule.Conditions {
+		if !c.matches(pathSuffix, m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c PresetCondition) matches(pathSuffix string, m *ffprobe.Metadata) bool {
+	if c.Field == "path" {
+		// Patterns always use forward slashes, even on Windows
+		matched, _ := path.Match(c.Value, filepath.ToSlash(pathSuffix))
+		return matched == (c.Op == "=")
+	}
+
+	if m == nil {
+		return false
+	}
+
+	switch c.Field {
+	case "codec":
+		return (m.VideoCodec == c.Value) == (c.Op == "=")
+	case "width":
+		return compareInt(m.Width, c.Op, c.Value)
+	case "height":
+		return compareInt(m.Height, c.Op, c.Value)
+	}
+	return false
+}
+
+func compareInt(got int, op string, value string) bool {
+	want, _ := strconv.Atoi(value)
+	switch op {
+	case ">=":
+		return got >= want
+	case "<=":
+		return got <= want
+	case ">":
+		return got > want
+	case "<":
+		return got < want
+	case "=":
+		return got == want
+	case "!=":
+		return got != want
+	}
+	return false
+}
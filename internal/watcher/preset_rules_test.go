@@ -0,0 +1,110 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+)
+
+func TestPresetRules_Set(t *testing.T) {
+	testcases := []struct {
+		Name    string
+		Value   string
+		WantErr bool
+	}{
+		{Name: "path", Value: "path=TV/*/*=>Fast 480p30"},
+		{Name: "metadata", Value: "height>=2160,codec!=hevc=>H.265 MKV 1080p30"},
+		{Name: "profile", Value: "path=Kids/*=>Fast 480p30@kids"},
+		{Name: "profile only", Value: "path=Kids/*=>@kids"},
+		{Name: "container", Value: "path=Web/*=>Fast 1080p30@web:mp4"},
+		{Name: "container only", Value: "path=Archive/*=>:mkv"},
+		{Name: "unsupported container", Value: "path=Web/*=>Fast 1080p30:avi", WantErr: true},
+		{Name: "missing preset", Value: "height>=2160=>", WantErr: true},
+		{Name: "missing profile", Value: "height>=2160=>tivo@", WantErr: true},
+		{Name: "missing arrow", Value: "height>=2160", WantErr: true},
+		{Name: "missing condition", Value: "=>tivo", WantErr: true},
+		{Name: "unknown field", Value: "bitrate>1000=>tivo", WantErr: true},
+		{Name: "non-numeric height", Value: "height>=4k=>tivo", WantErr: true},
+		{Name: "unsupported path operator", Value: "path>TV=>tivo", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var rules PresetRules
+			err := rules.Set(tc.Value)
+			if tc.WantErr != (err != nil) {
+				t.Fatalf("expected WantErr to be %t, got %v", tc.WantErr, err)
+			}
+			if err == nil && rules.String() != tc.Value {
+				t.Fatalf("expected the rule to format as %q, got %q", tc.Value, rules.String())
+			}
+		})
+	}
+}
+
+func TestPresetRules_For(t *testing.T) {
+	var rules PresetRules
+	for _, rule := range []string{
+		"height>=2160=>4K to 1080p",
+		"path=TV/*/*=>Fast 480p30",
+	} {
+		err := rules.Set(rule)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	testcases := []struct {
+		Name       string
+		PathSuffix string
+		Metadata   *ffprobe.Metadata
+		Want       string
+	}{
+		{Name: "4K movie", PathSuffix: "Movies/foo.mkv", Metadata: &ffprobe.Metadata{Height: 2160}, Want: "4K to 1080p"},
+		{Name: "4K episode uses the first rule", PathSuffix: "TV/Show/foo.mkv", Metadata: &ffprobe.Metadata{Height: 2160}, Want: "4K to 1080p"},
+		{Name: "episode without metadata", PathSuffix: "TV/Show/foo.mkv", Want: "Fast 480p30"},
+		{Name: "movie without metadata", PathSuffix: "Movies/2160p.mkv", Want: "tivo"},
+		{Name: "1080p movie", PathSuffix: "Movies/foo.mkv", Metadata: &ffprobe.Metadata{Height: 1080}, Want: "tivo"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got := rules.For(tc.PathSuffix, tc.Metadata, "tivo")
+			if got != tc.Want {
+				t.Fatalf("expected the %s preset, got %s", tc.Want, got)
+			}
+		})
+	}
+}
+
+func TestPresetRules_Container(t *testing.T) {
+	var rules PresetRules
+	for _, rule := range []string{
+		"path=Web/*=>Fast 1080p30:MP4",
+		"path=Archive/*=>:mkv",
+		"path=TV/*=>Fast 480p30",
+	} {
+		err := rules.Set(rule)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	testcases := []struct {
+		PathSuffix string
+		Want       string
+	}{
+		{PathSuffix: "Web/foo.mkv", Want: ".mp4"},
+		{PathSuffix: "Archive/foo.mp4", Want: ".mkv"},
+		{PathSuffix: "TV/foo.mkv", Want: ""},
+		{PathSuffix: "Movies/foo.mkv", Want: ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.PathSuffix, func(t *testing.T) {
+			if got := rules.Container(tc.PathSuffix, nil); got != tc.Want {
+				t.Fatalf("expected the container %q, got %q", tc.Want, got)
+			}
+		})
+	}
+}
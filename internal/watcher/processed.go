@@ -0,0 +1,99 @@
+package watcher
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// RejectProcessed is a video that was already processed, and renamed by a ProcessedName.
+const RejectProcessed fs.RejectReason = "processed"
+
+// Placeholders for the values of a ProcessedName, used to find the names that it creates.
+const (
+	processedNamePlaceholder = "\x00name\x00"
+	processedExtPlaceholder  = "\x00ext\x00"
+)
+
+// ProcessedName is a template for the new name of a video, once it was transcoded and
+// uploaded, instead of removing it. The video is moved back to its directory in the
+// watch directory with the new name, for example "{{.Name}}{{.Ext}}.done" renames
+// Movies/foo.mkv to Movies/foo.mkv.done. The template may use the Name and Ext of
+// OrganizeValues. The renamed videos are skipped by the watcher. It may be used as a
+// flag. When empty, videos are not renamed.
+type ProcessedName struct {
+	text    string
+	tmpl    *template.Template
+	pattern *regexp.Regexp
+}
+
+// String returns the template text.
+func (p *ProcessedName) String() string {
+	if p == nil {
+		return ""
+	}
+	return p.text
+}
+
+// Set parses the template, refusing a template that moves the video to another
+// directory, or that creates names that look like videos that weren't processed.
+func (p *ProcessedName) Set(value string) error {
+	tmpl, err := template.New("processed").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid processed name template %q", value)
+	}
+
+	// Match any name that the template creates, by replacing the values with wildcards
+	placeholders, err := renderProcessedName(tmpl, processedNamePlaceholder, processedExtPlaceholder)
+	if err != nil {
+		return errors.Wrapf(err, "invalid processed name template %q", value)
+	}
+	if strings.ContainsAny(placeholders, `/\`) {
+		return errors.Errorf("invalid processed name template %q, the video must be renamed in its directory", value)
+	}
+	expr := regexp.QuoteMeta(placeholders)
+	expr = strings.Replace(expr, processedNamePlaceholder, ".+", -1)
+	expr = strings.Replace(expr, processedExtPlaceholder, `(\.[^.]*)?`, -1)
+	pattern := regexp.MustCompile("^" + expr + "$")
+
+	if pattern.MatchString("video.mkv") {
+		return errors.Errorf("invalid processed name template %q, the renamed videos would be processed again", value)
+	}
+	p.text, p.tmpl, p.pattern = value, tmpl, pattern
+	return nil
+}
+
+// IsSet determines if videos are renamed once they are processed.
+func (p ProcessedName) IsSet() bool {
+	return p.tmpl != nil
+}
+
+// IsProcessed determines if the path is a video that was renamed once it was processed.
+func (p ProcessedName) IsProcessed(path string) bool {
+	return p.pattern != nil && p.pattern.MatchString(filepath.Base(path))
+}
+
+// Rename returns the new path of a video in the watch directory, once it was processed.
+func (p ProcessedName) Rename(watchDir, pathSuffix string) (string, error) {
+	v := NewOrganizeValues(pathSuffix, nil)
+	name, err := renderProcessedName(p.tmpl, v.Name, v.Ext)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to rename %s once it is processed", pathSuffix)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, `/\`) || !p.IsProcessed(name) {
+		return "", errors.Errorf("unable to rename %s once it is processed, %q must be a file in its directory", pathSuffix, name)
+	}
+	return filepath.Join(watchDir, filepath.Dir(pathSuffix), name), nil
+}
+
+func renderProcessedName(tmpl *template.Template, name, ext string) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, OrganizeValues{Name: name, Ext: ext})
+	return buf.String(), err
+}
@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProcessedName(t *testing.T) {
+	testcases := []struct {
+		Template      string
+		Path          string
+		WantRenamed   string
+		WantProcessed []string
+		WantIgnored   []string
+	}{
+		{Template: "{{.Name}}{{.Ext}}.done", Path: "Movies/Foo (2019).mkv", WantRenamed: "Movies/Foo (2019).mkv.done",
+			WantProcessed: []string{"Movies/Bar.mp4.done"}, WantIgnored: []string{"Movies/Bar.mp4", "Movies/done.mkv"}},
+		{Template: "{{.Name}}.processed{{.Ext}}", Path: "TV/Show/S01E02.mkv", WantRenamed: "TV/Show/S01E02.processed.mkv",
+			WantProcessed: []string{"Movies/VIDEO_TS.processed"}, WantIgnored: []string{"Movies/processed.mkv"}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Template, func(t *testing.T) {
+			var p ProcessedName
+			err := p.Set(tc.Template)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+
+			renamed, err := p.Rename("/watch", filepath.FromSlash(tc.Path))
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if want := filepath.Join("/watch", filepath.FromSlash(tc.WantRenamed)); renamed != want {
+				t.Fatalf("expected %s to be renamed to %s, got %s", tc.Path, want, renamed)
+			}
+			for _, path := range append(tc.WantProcessed, renamed) {
+				if !p.IsProcessed(path) {
+					t.Fatalf("expected %s to be skipped as processed", path)
+				}
+			}
+			for _, path := range tc.WantIgnored {
+				if p.IsProcessed(path) {
+					t.Fatalf("expected %s to not be skipped as processed", path)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessedName_Invalid(t *testing.T) {
+	for _, tmpl := range []string{"{{.Name}}{{.Ext}}", "done/{{.Name}}{{.Ext}}", "{{.Name"} {
+		var p ProcessedName
+		if err := p.Set(tmpl); err == nil {
+			t.Fatalf("expected %q to be refused", tmpl)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/pkg/errors"
+)
+
+// processingDir is where the videos are claimed while they are processed, when the sink
+// uses a processing directory in the watch directory, instead of the claim directory on
+// the work volume, so that the videos in progress can be seen next to those waiting. The
+// directory must be ignored when watching the watch directory, so that the claimed videos
+// aren't found again.
+func processingDir(watchDir, name string, readOnly bool) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", errors.Errorf("invalid processing directory %q, must be the name of a directory in the watch directory", name)
+	}
+	if readOnly {
+		return "", errors.Errorf("unable to use the processing directory %s, the watch directory %s is read-only", name, watchDir)
+	}
+
+	dir := filepath.Join(watchDir, name)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to create the processing directory %s", dir)
+	}
+	return dir, nil
+}
+
+// UseProcessingDir claims the videos into the directory, in the watch directory, instead of
+// the claim directory on the work volume. The jobs mount the watch volume to read them. When
+// the watcher restarts, the videos left in it without running jobs are processed again.
+func (s *JobSink) UseProcessingDir(name string) error {
+	dir, err := processingDir(s.WatchDir, name, s.ReadOnlySource)
+	if err != nil {
+		return err
+	}
+	s.ClaimDir, s.processing = dir, true
+	return nil
+}
+
+// UseProcessingDir claims the videos into the directory, in the watch directory, instead of
+// the claim directory on the work volume. When the watcher restarts, the videos left in it
+// are processed again.
+func (s *LocalSink) UseProcessingDir(name string) error {
+	dir, err := processingDir(s.WatchDir, name, s.ReadOnlySource)
+	if err != nil {
+		return err
+	}
+	s.ClaimDir, s.processing = dir, true
+	return nil
+}
+
+// Reconcile processes the videos left in the processing directory again, since nothing
+// else processes the claimed videos of a local sink.
+func (s *LocalSink) Reconcile(ctx context.Context) error {
+	if s.processing {
+		requeueInterrupted(s.Sandbox, s.ClaimDir, s.WatchDir, nil)
+	}
+	return nil
+}
+
+// requeueInterrupted moves the videos left in the processing directory when the watcher
+// stopped back to the same path in the watch directory, so that they are processed again.
+// The videos that are still being processed, by their path relative to the watch directory,
+// are left alone, along with a video that is back in the watch directory. Returns the paths
+// of the requeued videos.
+func requeueInterrupted(sandbox *fs.Sandbox, processingDir, watchDir string, inProgress map[string]bool) []string {
+	// A subtitle is in progress along with its video
+	skip := make(map[string]bool, len(inProgress))
+	for pathSuffix := range inProgress {
+		skip[pathSuffix] = true
+		subtitles, _ := handbrake.SubtitlesOf(filepath.Join(processingDir, pathSuffix))
+		for _, subtitle := range subtitles {
+			if rel, err := filepath.Rel(processingDir, subtitle); err == nil {
+				skip[rel] = true
+			}
+		}
+	}
+
+	var requeued []string
+	filepath.Walk(processingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		pathSuffix, err := filepath.Rel(processingDir, path)
+		if err != nil || isInProgress(pathSuffix, skip) {
+			return nil
+		}
+
+		dest := filepath.Join(watchDir, pathSuffix)
+		if _, err := os.Stat(dest); err == nil {
+			log.Printf("found interrupted work for %s, leaving %s because the video is back in the watch directory\n", dest, path)
+			return nil
+		}
+		log.Printf("found interrupted work for %s, moving %s back to be processed again\n", dest, path)
+		err = sandbox.MoveFile(path, dest)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "unable to move %s back to %s", path, dest))
+			return nil
+		}
+		requeued = append(requeued, dest)
+		return nil
+	})
+	removeEmptyDirs(processingDir)
+	sort.Strings(requeued)
+	return requeued
+}
+
+// isInProgress determines if the claimed file, or the directory with it, such as a disc
+// structure, is still being processed.
+func isInProgress(pathSuffix string, inProgress map[string]bool) bool {
+	for p := range inProgress {
+		if pathSuffix == p || strings.HasPrefix(pathSuffix, p+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEmptyDirs removes the empty directories in the directory, keeping the directory.
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && path != dir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// Remove the deepest directories first, so that their parents are empty
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
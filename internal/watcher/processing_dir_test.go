@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProcessingDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testcases := []struct {
+		Name     string
+		Dir      string
+		ReadOnly bool
+		WantErr  bool
+	}{
+		{Name: "hidden directory", Dir: ".processing"},
+		{Name: "nested directory", Dir: "a/b", WantErr: true},
+		{Name: "parent directory", Dir: "..", WantErr: true},
+		{Name: "read-only watch directory", Dir: ".processing", ReadOnly: true, WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir, err := processingDir(tmpDir, tc.Dir, tc.ReadOnly)
+			if tc.WantErr != (err != nil) {
+				t.Fatalf("expected WantErr to be %t, got %v", tc.WantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				t.Fatalf("expected the processing directory %s to be created", dir)
+			}
+		})
+	}
+}
+
+func TestRequeueInterrupted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	watchDir := filepath.Join(tmpDir, "watch")
+	dir, err := processingDir(watchDir, ".processing", false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	now := time.Now()
+	for _, pathSuffix := range []string{
+		"Movies/foo.mkv",
+		"Movies/foo.en.srt",
+		"Movies/running.mkv",
+		"Movies/running.en.srt",
+		"TV/Show/S01E01.mkv",
+		"TV/Show/back.mkv",
+	} {
+		writeTestFile(t, filepath.Join(dir, pathSuffix), "claimed", now)
+	}
+	writeTestFile(t, filepath.Join(watchDir, "TV/Show/back.mkv"), "new", now)
+
+	inProgress := map[string]bool{filepath.Join("Movies", "running.mkv"): true}
+	got := requeueInterrupted(nil, dir, watchDir, inProgress)
+	want := []string{
+		filepath.Join(watchDir, "Movies", "foo.en.srt"),
+		filepath.Join(watchDir, "Movies", "foo.mkv"),
+		filepath.Join(watchDir, "TV", "Show", "S01E01.mkv"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the interrupted videos to be requeued %v, got %v", want, got)
+	}
+
+	for _, pathSuffix := range []string{"Movies/running.mkv", "Movies/running.en.srt", "TV/Show/back.mkv"} {
+		if _, err := os.Stat(filepath.Join(dir, pathSuffix)); err != nil {
+			t.Fatalf("expected %s to be left in the processing directory", pathSuffix)
+		}
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(watchDir, "TV/Show/back.mkv")); string(contents) != "new" {
+		t.Fatal("expected the video that is back in the watch directory to be kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "TV", "Show", "S01E01.mkv")); !os.IsNotExist(err) {
+		t.Fatal("expected the requeued video to be moved out of the processing directory")
+	}
+}
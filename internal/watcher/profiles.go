@@ -0,0 +1,158 @@
+package watcher
+
+import (
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// JobProfile is a named set of settings for the transcode jobs of some videos,
+// such as the videos of one team sharing the cluster. Empty settings use the
+// defaults of the watcher.
+type JobProfile struct {
+	// Name of the profile, used to select it from a preset rule.
+	Name string `json:"name"`
+
+	// Libraries selects the profile for the videos in these directories of the watch directory.
+	Libraries []string `json:"libraries,omitempty"`
+
+	// Namespace and Context override where the jobs are created.
+	Namespace string `json:"namespace,omitempty"`
+	Context   string `json:"context,omitempty"`
+
+	// Image of HandBrakeCLI used by the transcode jobs.
+	Image string `json:"image,omitempty"`
+
+	// Preset used for the videos, unless a preset rule picks a different preset.
+	Preset string `json:"preset,omitempty"`
+
+	// Args are additional HandBrakeCLI arguments. Videos with args are never batched.
+	Args []string `json:"args,omitempty"`
+
+	// CPU and Memory are the resources requested by the transcode jobs, and
+	// CPULimit and MemoryLimit are their limits, for example "4" and "2Gi".
+	CPU         string `json:"cpu,omitempty"`
+	Memory      string `json:"memory,omitempty"`
+	CPULimit    string `json:"cpuLimit,omitempty"`
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+
+	// Labels are added to the transcode jobs and their pods.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// JobProfiles are the profiles that may be selected for a video.
+type JobProfiles []JobProfile
+
+// jobProfilesFile is the format of a job profiles file.
+type jobProfilesFile struct {
+	Profiles JobProfiles `json:"profiles"`
+}
+
+// LoadJobProfiles reads a YAML file with a list of profiles, for example:
+//
+//	profiles:
+//	- name: kids
+//	  libraries: [Kids]
+//	  namespace: team-kids
+//	  preset: Fast 480p30
+//	  cpu: "2"
+//	  labels:
+//	    team: kids
+func LoadJobProfiles(path string) (JobProfiles, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read job profiles file %s", path)
+	}
+
+	var f jobProfilesFile
+	err = yaml.Unmarshal(b, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse job profiles file %s", path)
+	}
+
+	err = f.Profiles.validate()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid job profiles file %s", path)
+	}
+	return f.Profiles, nil
+}
+
+// validate checks that the profiles have unique names, that each library is
+// selected by at most one profile, and that the resources are valid quantities.
+func (p JobProfiles) validate() error {
+	names := make(map[string]bool)
+	libraries := make(map[string]string)
+	for _, profile := range p {
+		if profile.Name == "" {
+			return errors.New("a profile is missing its name")
+		}
+		if names[profile.Name] {
+			return errors.Errorf("profile %s is defined more than once", profile.Name)
+		}
+		names[profile.Name] = true
+
+		for _, library := range profile.Libraries {
+			if other, ok := libraries[library]; ok {
+				return errors.Errorf("library %s is selected by both the %s and %s profiles", library, other, profile.Name)
+			}
+			libraries[library] = profile.Name
+		}
+
+		for _, q := range []string{profile.CPU, profile.Memory, profile.CPULimit, profile.MemoryLimit} {
+			if q == "" {
+				continue
+			}
+			if _, err := resource.ParseQuantity(q); err != nil {
+				return errors.Wrapf(err, "invalid resource %q in profile %s", q, profile.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Named returns the profile with the name.
+func (p JobProfiles) Named(name string) (JobProfile, bool) {
+	for _, profile := range p {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return JobProfile{}, false
+}
+
+// For returns the profile that selects the library, or an empty profile.
+func (p JobProfiles) For(library string) JobProfile {
+	for _, profile := range p {
+		for _, l := range profile.Libraries {
+			if l == library {
+				return profile
+			}
+		}
+	}
+	return JobProfile{}
+}
+
+// Presets lists the presets used by the profiles.
+func (p JobProfiles) Presets() []string {
+	var presets []string
+	for _, profile := range p {
+		if profile.Preset != "" {
+			presets = append(presets, profile.Preset)
+		}
+	}
+	return presets
+}
+
+// Target returns where the jobs of the profile are created, overriding the default target.
+func (profile JobProfile) Target(defaultTarget JobTarget) JobTarget {
+	target := defaultTarget
+	if profile.Namespace != "" {
+		target.Namespace = profile.Namespace
+	}
+	if profile.Context != "" {
+		target.Context = profile.Context
+	}
+	return target
+}
@@ -0,0 +1,125 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func writeJobProfiles(t *testing.T, contents string) string {
+	tmpDir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	path := filepath.Join(tmpDir, "profiles.yaml")
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	return path
+}
+
+func TestLoadJobProfiles(t *testing.T) {
+	path := writeJobProfiles(t, `
+profiles:
+- name: kids
+  libraries: [Kids]
+  namespace: team-kids
+  preset: Fast 480p30
+  cpu: "2"
+  labels:
+    team: kids
+- name: archive
+  context: gpu-cluster
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	profiles, err := LoadJobProfiles(path)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got := profiles.For("Kids"); got.Name != "kids" || got.Labels["team"] != "kids" {
+		t.Fatalf("expected the kids library to use the kids profile, got %#v", got)
+	}
+	if got := profiles.For("Movies"); got.Name != "" {
+		t.Fatalf("expected an empty profile for a library without one, got %#v", got)
+	}
+
+	defaultTarget := JobTarget{Namespace: "handbrk8s"}
+	if got := profiles.For("Kids").Target(defaultTarget); got != (JobTarget{Namespace: "team-kids"}) {
+		t.Fatalf("expected the kids jobs in the team-kids namespace, got %s", got)
+	}
+	archive, _ := profiles.Named("archive")
+	if got := archive.Target(defaultTarget); got != (JobTarget{Namespace: "handbrk8s", Context: "gpu-cluster"}) {
+		t.Fatalf("expected the archive jobs on the gpu cluster, got %s", got)
+	}
+}
+
+func TestLoadJobProfiles_Invalid(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Contents string
+	}{
+		{Name: "missing name", Contents: "profiles:\n- namespace: foo\n"},
+		{Name: "duplicate name", Contents: "profiles:\n- name: foo\n- name: foo\n"},
+		{Name: "duplicate library", Contents: "profiles:\n- name: foo\n  libraries: [TV]\n- name: bar\n  libraries: [TV]\n"},
+		{Name: "invalid resource", Contents: "profiles:\n- name: foo\n  memory: lots\n"},
+		{Name: "invalid yaml", Contents: "profiles: [\n"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := writeJobProfiles(t, tc.Contents)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			_, err := LoadJobProfiles(path)
+			if err == nil {
+				t.Fatal("expected the profiles to be invalid")
+			}
+		})
+	}
+}
+
+func TestJobSink_SelectProfile(t *testing.T) {
+	var rules PresetRules
+	for _, rule := range []string{
+		"path=Kids/Shows/*=>@shows",
+		"path=Kids/Extras/*=>tivo@shows",
+	} {
+		err := rules.Set(rule)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	s := &JobSink{
+		VideoPreset: "default",
+		PresetRules: rules,
+		Profiles: JobProfiles{
+			{Name: "kids", Libraries: []string{"Kids"}, Preset: "Fast 480p30"},
+			{Name: "shows", Preset: "Fast 720p30"},
+		},
+	}
+
+	testcases := []struct {
+		PathSuffix  string
+		WantProfile string
+		WantPreset  string
+	}{
+		{PathSuffix: "Movies/foo.mkv", WantProfile: "", WantPreset: "default"},
+		{PathSuffix: "Kids/foo.mkv", WantProfile: "kids", WantPreset: "Fast 480p30"},
+		{PathSuffix: "Kids/Shows/foo.mkv", WantProfile: "shows", WantPreset: "Fast 720p30"},
+		{PathSuffix: "Kids/Extras/foo.mkv", WantProfile: "shows", WantPreset: "tivo"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.PathSuffix, func(t *testing.T) {
+			profile, preset := s.selectProfile(libraryName(tc.PathSuffix), tc.PathSuffix, fs.FileEvent{})
+			if profile.Name != tc.WantProfile || preset != tc.WantPreset {
+				t.Fatalf("expected the %q profile and %q preset, got %q and %q", tc.WantProfile, tc.WantPreset, profile.Name, preset)
+			}
+		})
+	}
+}
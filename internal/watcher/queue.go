@@ -0,0 +1,94 @@
+package watcher
+
+import (
+	"log"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// RejectDequeued is a queued video that was removed from the queue, see Dequeue.
+const RejectDequeued fs.RejectReason = "dequeued"
+
+// QueuedVideo is a stable video that is held while the watcher is paused, or outside of its schedule.
+type QueuedVideo struct {
+	// ID is the CorrelationID of the video, which identifies it in the queue.
+	ID         string    `json:"id"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size,omitempty"`
+	DetectedAt time.Time `json:"detectedAt"`
+	StableAt   time.Time `json:"stableAt"`
+
+	// EstimatedEncode is the estimated time, in seconds, to encode the video, when an EncodeEstimator is used.
+	EstimatedEncode float64 `json:"estimatedEncodeSeconds,omitempty"`
+}
+
+// Queue lists the queued videos, in the order that they are handed to the sinks once the
+// watcher resumes, or its processing window opens.
+func (w *VideoWatcher) Queue() []QueuedVideo {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queue := make([]QueuedVideo, len(w.queued))
+	for i, file := range w.queued {
+		queue[i] = QueuedVideo{ID: CorrelationID(file.Path), Path: file.Path, Size: file.Size, DetectedAt: file.DetectedAt, StableAt: file.StableAt,
+			EstimatedEncode: w.estimateLocked(file.Size).Seconds()}
+	}
+	return queue
+}
+
+// Dequeue removes a queued video, so that it isn't handled, and removes it from the work
+// queue. The video is left in the watch directory, and is found again when it changes, or
+// when the watcher restarts. Returns false when the video isn't queued.
+func (w *VideoWatcher) Dequeue(id string) bool {
+	w.mu.Lock()
+	i := w.queuedIndexLocked(id)
+	if i == -1 {
+		w.mu.Unlock()
+		return false
+	}
+	file := w.queued[i]
+	w.queued = append(w.queued[:i], w.queued[i+1:]...)
+	w.mu.Unlock()
+
+	log.Printf("[%s] removed %s from the queue\n", id, file.Path)
+	w.finish(file, false)
+	w.reject(fs.RejectedFile{Path: file.Path, Reason: RejectDequeued})
+	return true
+}
+
+// Prioritize moves a queued video to the position in the queue, where 0 is the front of the
+// queue, and a position past the end is the back. The new order isn't kept when the watcher
+// restarts. Returns false when the video isn't queued.
+func (w *VideoWatcher) Prioritize(id string, position int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	i := w.queuedIndexLocked(id)
+	if i == -1 {
+		return false
+	}
+	file := w.queued[i]
+	w.queued = append(w.queued[:i], w.queued[i+1:]...)
+
+	if position < 0 {
+		position = 0
+	}
+	if position > len(w.queued) {
+		position = len(w.queued)
+	}
+	w.queued = append(w.queued, fs.FileEvent{})
+	copy(w.queued[position+1:], w.queued[position:])
+	w.queued[position] = file
+	return true
+}
+
+// queuedIndexLocked finds the video in the queue by its id, or returns -1. The caller must hold mu.
+func (w *VideoWatcher) queuedIndexLocked(id string) int {
+	for i, file := range w.queued {
+		if CorrelationID(file.Path) == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,88 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestVideoWatcher_Queue(t *testing.T) {
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+	rejected := w.Subscribe()
+
+	w.Pause()
+	paths := []string{"/watch/foo.mkv", "/watch/bar.mkv", "/watch/baz.mkv"}
+	for _, path := range paths {
+		files <- fs.FileEvent{Path: path}
+	}
+	for i := 0; w.Status().Queued != len(paths); i++ {
+		if i == 100 {
+			t.Fatalf("expected %d videos to be queued, got %#v", len(paths), w.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	queuedPaths := func() []string {
+		var got []string
+		for _, v := range w.Queue() {
+			if v.ID != CorrelationID(v.Path) {
+				t.Fatalf("expected the video to be identified by its correlation id, got %#v", v)
+			}
+			got = append(got, v.Path)
+		}
+		return got
+	}
+	assertQueue := func(want ...string) {
+		got := queuedPaths()
+		if len(got) != len(want) {
+			t.Fatalf("expected the queue %v, got %v", want, got)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("expected the queue %v, got %v", want, got)
+			}
+		}
+	}
+	assertQueue(paths...)
+
+	if !w.Prioritize(CorrelationID("/watch/baz.mkv"), 0) {
+		t.Fatal("expected baz to be moved to the front of the queue")
+	}
+	assertQueue("/watch/baz.mkv", "/watch/foo.mkv", "/watch/bar.mkv")
+	if !w.Prioritize(CorrelationID("/watch/baz.mkv"), 10) {
+		t.Fatal("expected baz to be moved to the back of the queue")
+	}
+	assertQueue("/watch/foo.mkv", "/watch/bar.mkv", "/watch/baz.mkv")
+
+	if !w.Dequeue(CorrelationID("/watch/foo.mkv")) {
+		t.Fatal("expected foo to be removed from the queue")
+	}
+	assertQueue("/watch/bar.mkv", "/watch/baz.mkv")
+	if w.Dequeue(CorrelationID("/watch/foo.mkv")) || w.Prioritize(CorrelationID("/watch/foo.mkv"), 0) {
+		t.Fatal("expected a video that isn't queued to not be found")
+	}
+	for e := range rejected {
+		if e.Type == EventSkipped {
+			if e.Path != "/watch/foo.mkv" || e.Reason != RejectDequeued {
+				t.Fatalf("expected foo to be skipped as dequeued, got %#v", e)
+			}
+			break
+		}
+	}
+
+	w.Resume()
+	for range []string{"bar", "baz"} {
+		select {
+		case e := <-sink.events:
+			if e.Path == "/watch/foo.mkv" {
+				t.Fatal("expected the dequeued video to not be handled")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the queued videos to be handled on resume")
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// RawArgsSuffix is appended to the path of a video for the file with its raw HandBrakeCLI
+// arguments, for example Movies/foo.mkv.handbrake-args. The file has one argument per line,
+// skipping empty lines and lines starting with #. The raw arguments are used verbatim after
+// the input and output, instead of the preset, the preset file and the profile args. Only the
+// default args come before them, so the raw arguments override the default args.
+const RawArgsSuffix = ".handbrake-args"
+
+// RejectRawArgs is the raw arguments file of a video, instead of a video.
+const RejectRawArgs fs.RejectReason = "raw-args"
+
+// refusedRawArgs are HandBrakeCLI flags that may not be used in raw arguments, because the
+// watcher sets the input and output, and the other flags write files outside of the sandbox,
+// or read the output from an imported queue. A short flag is also refused with its value
+// attached, such as -o/etc/foo.mkv, and a long flag by any abbreviation that getopt accepts,
+// such as --outp.
+var refusedRawArgs = []string{"-i", "--input", "-o", "--output", "--preset-export", "--preset-export-file", "--queue-import-file"}
+
+// exactRawArgs are the long flags that are allowed although they abbreviate a refused flag,
+// because getopt matches them exactly.
+var exactRawArgs = map[string]bool{"--preset": true}
+
+// isRawArgs determines if the path is the raw arguments file of a video, instead of a video.
+func isRawArgs(path string) bool {
+	return strings.HasSuffix(path, RawArgsSuffix)
+}
+
+// loadRawArgs reads the raw HandBrakeCLI arguments of the video, returning false when
+// the video has none. Arguments that would redirect the output are refused.
+func loadRawArgs(path string) ([]string, bool, error) {
+	argsPath := path + RawArgsSuffix
+	b, err := ioutil.ReadFile(argsPath)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "unable to read the raw args file %s", argsPath)
+	}
+
+	var args []string
+	for _, line := range strings.Split(string(b), "\n") {
+		arg := strings.TrimSpace(line)
+		if arg == "" || strings.HasPrefix(arg, "#") {
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	err = checkRawArgs(args)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "invalid raw args file %s", argsPath)
+	}
+	log.Printf("using the raw HandBrakeCLI args %q from %s for %s\n", args, argsPath, path)
+	return args, true, nil
+}
+
+// CheckDefaultArgs refuses the default args that set the input or output, or export presets,
+// like the raw arguments of a video.
+func CheckDefaultArgs(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	return errors.Wrap(checkRawArgs(args), "invalid default args")
+}
+
+// checkRawArgs refuses the arguments that set the input or output, export presets or import a queue.
+func checkRawArgs(args []string) error {
+	if len(args) == 0 {
+		return errors.New("no arguments")
+	}
+	for _, arg := range args {
+		if refused, ok := refusedRawArg(arg); ok {
+			return errors.Errorf("the %s argument is not allowed, the watcher sets the input and output", refused)
+		}
+	}
+	return nil
+}
+
+// refusedRawArg returns the refused flag that the argument sets, see refusedRawArgs.
+func refusedRawArg(arg string) (string, bool) {
+	if strings.HasPrefix(arg, "--") {
+		name := strings.SplitN(arg, "=", 2)[0]
+		if name == "--" || exactRawArgs[name] {
+			return "", false
+		}
+		for _, refused := range refusedRawArgs {
+			if strings.HasPrefix(refused, "--") && strings.HasPrefix(refused, name) {
+				return refused, true
+			}
+		}
+		return "", false
+	}
+	for _, refused := range refusedRawArgs {
+		if !strings.HasPrefix(refused, "--") && strings.HasPrefix(arg, refused) {
+			return refused, true
+		}
+	}
+	return "", false
+}
+
+// removeRawArgs removes the raw arguments file of a video, once the video was handled.
+func removeRawArgs(sandbox *fs.Sandbox, path string) {
+	argsPath := path + RawArgsSuffix
+	err := sandbox.Remove(argsPath)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		log.Println(errors.Wrapf(err, "unable to remove the raw args file %s", argsPath))
+	}
+}
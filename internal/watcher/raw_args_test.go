@@ -0,0 +1,64 @@
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRawArgs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testcases := []struct {
+		Name, Contents string
+		Want           []string
+		WantErr        bool
+	}{
+		{Name: "args", Contents: "# deinterlace this one\n-e\nx264\n\n--deinterlace\n", Want: []string{"-e", "x264", "--deinterlace"}},
+		{Name: "output", Contents: "-e\nx264\n-o\n/etc/foo.mkv\n", WantErr: true},
+		{Name: "output value", Contents: "--output=/etc/foo.mkv\n", WantErr: true},
+		{Name: "queue import", Contents: "--queue-import-file\n/etc/queue.json\n", WantErr: true},
+		{Name: "attached output", Contents: "-o/etc/passwd\n", WantErr: true},
+		{Name: "attached input", Contents: "-i/x\n", WantErr: true},
+		{Name: "abbreviated output", Contents: "--outp\n/etc/foo.mkv\n", WantErr: true},
+		{Name: "abbreviated input value", Contents: "--inp=/x\n", WantErr: true},
+		{Name: "abbreviated preset export", Contents: "--preset-exp\n/etc/foo.json\n", WantErr: true},
+		{Name: "preset", Contents: "--preset\nFast 1080p30\n", Want: []string{"--preset", "Fast 1080p30"}},
+		{Name: "empty", Contents: "# nothing\n", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, tc.Name+".mkv")
+			err := ioutil.WriteFile(path+RawArgsSuffix, []byte(tc.Contents), 0644)
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+
+			got, ok, err := loadRawArgs(path)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatalf("expected the raw args to be refused, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%#v", err)
+			}
+			if !ok || fmt.Sprint(got) != fmt.Sprint(tc.Want) {
+				t.Fatalf("expected %q, got %q", tc.Want, got)
+			}
+		})
+	}
+
+	// A video without a raw args file uses the structured config
+	if _, ok, err := loadRawArgs(filepath.Join(tmpDir, "foo.mkv")); ok || err != nil {
+		t.Fatalf("expected no raw args, got %t and %v", ok, err)
+	}
+}
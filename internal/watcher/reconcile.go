@@ -0,0 +1,123 @@
+package watcher
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// Reconciler picks up the work left behind by a previous watcher, when it restarts.
+type Reconciler interface {
+	// Reconcile resumes tracking the work that was in progress, until the context is done.
+	Reconcile(ctx context.Context) error
+}
+
+// Reconcile finds the jobs created by a previous watcher, on every target, before it restarted.
+// The jobs that are still running are counted in flight again until they finish, and the post
+// hook is run for each video whose upload completed, or completes, without it. A target that
+// can't be listed doesn't stop the others from being reconciled. With a processing directory,
+// the videos left in it without running jobs are then processed again, once every target
+// was reconciled. The videos of the jobs cancelled from then on are finished, on every target,
+// see finishCancelledJob.
+func (s *JobSink) Reconcile(ctx context.Context) error {
+	var failed []string
+	inProgress := make(map[string]bool)
+	for _, target := range s.reconcileTargets() {
+		go s.finishCancelled(ctx, target)
+		err := s.reconcileTarget(ctx, target, inProgress)
+		if err != nil {
+			logln(ctx, err)
+			failed = append(failed, target.String())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("unable to reconcile the jobs in %s", strings.Join(failed, ", "))
+	}
+	if s.processing {
+		requeueInterrupted(s.Sandbox, s.ClaimDir, s.WatchDir, inProgress)
+	}
+	return nil
+}
+
+// reconcileTargets lists every target where jobs may have been created, once each.
+func (s *JobSink) reconcileTargets() []JobTarget {
+	seen := make(map[JobTarget]bool)
+	var targets []JobTarget
+	add := func(target JobTarget) {
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	for _, base := range append([]JobTarget{s.Targets.Default}, s.Targets.targets()...) {
+		add(base)
+		for _, profile := range s.Profiles {
+			add(profile.Target(base))
+		}
+	}
+	return targets
+}
+
+// reconcileTarget resumes the videos of the upload jobs on the target, which record the
+// path of their video, along with the transcode jobs that they wait for. The videos with
+// running jobs are added to in progress, by their path relative to the watch directory.
+func (s *JobSink) reconcileTarget(ctx context.Context, target JobTarget, inProgress map[string]bool) error {
+	client := s.jobsClient(target)
+	list, err := client.List(target.Namespace)
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]*batchv1.Job, len(list))
+	for i := range list {
+		byName[list[i].Name] = &list[i]
+	}
+
+	var resumed int
+	for i := range list {
+		upload := &list[i]
+		source := upload.Annotations[jobs.SourceAnnotation]
+		if source == "" {
+			continue
+		}
+		videoCtx := withCorrelationID(ctx, source)
+
+		var running []string
+		if transcode, ok := byName[upload.Labels[jobs.WaitsForLabel]]; ok && !jobs.IsFinished(transcode) {
+			running = append(running, transcode.Name)
+		}
+		if !jobs.IsFinished(upload) {
+			running = append(running, upload.Name)
+		}
+		if len(running) > 0 {
+			resumed++
+			if pathSuffix, err := filepath.Rel(s.WatchDir, source); err == nil {
+				inProgress[pathSuffix] = true
+			}
+			for _, name := range running {
+				Publish(videoCtx, PipelineEvent{Type: EventJobCreated, Path: source, Job: name})
+			}
+			go s.waitForJobs(videoCtx, target, source, "", running...)
+		}
+
+		if s.PostHook.IsSet() && upload.Annotations[jobs.PostHookAnnotation] == jobs.PostHookPending {
+			values := HookValues{Input: source, Output: upload.Annotations[jobs.OutputAnnotation]}
+			switch {
+			case jobs.IsFailed(upload):
+			case upload.Status.CompletionTime != nil:
+				logf(videoCtx, "running the post hook for %s, uploaded while the watcher was stopped\n", source)
+				go s.finishPostHook(videoCtx, target, upload.Name, values)
+			default:
+				go s.runPostHookAfterUpload(videoCtx, target, upload.Name, values)
+			}
+		}
+	}
+	if resumed > 0 {
+		logf(ctx, "resumed tracking %d videos with jobs left running in %s\n", resumed, target)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func completeJob(j *batchv1.Job) *batchv1.Job {
+	now := metav1.Now()
+	j.Status.CompletionTime = &now
+	j.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	return j
+}
+
+func TestJobSink_Reconcile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the post hook uses touch")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	s.PostHook, err = ParseHook("touch {{.Output}}")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// foo is still transcoding, bar was uploaded while the watcher was stopped,
+	// and the post hook already ran for baz
+	uploaded := filepath.Join(tmpDir, "bar.mkv")
+	hooked := filepath.Join(tmpDir, "baz.mkv")
+	for _, j := range []*batchv1.Job{
+		buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo-mkv", Preset: "tivo"}),
+		buildJob(t, "upload.yaml", uploadJobValues{Name: "foo-mkv", WaitForJob: "foo-mkv-transcode", Source: "/watch/watch/Movies/foo.mkv", PostHook: true}),
+		completeJob(buildJob(t, "transcode.yaml", transcodeJobValues{Name: "bar-mkv", Preset: "tivo"})),
+		completeJob(buildJob(t, "upload.yaml", uploadJobValues{Name: "bar-mkv", WaitForJob: "bar-mkv-transcode", Source: "/watch/watch/Movies/bar.mkv", Output: uploaded, PostHook: true})),
+		completeJob(buildJob(t, "upload.yaml", uploadJobValues{Name: "baz-mkv", Source: "/watch/watch/Movies/baz.mkv", Output: hooked, PostHook: true})),
+	} {
+		cluster.jobs[j.Name] = j
+	}
+	cluster.jobs["baz-mkv-upload"].Annotations[jobs.PostHookAnnotation] = jobs.PostHookDone
+
+	w := NewVideoWatcher(&fakeWatcher{files: make(chan fs.FileEvent)}, s)
+	defer w.Close()
+	err = w.Reconcile()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if load := w.Load(); load.InFlight != 2 {
+		t.Fatalf("expected the jobs of foo to be in flight again, got %#v", load)
+	}
+
+	for i := 0; cluster.getJob("bar-mkv-upload").Annotations[jobs.PostHookAnnotation] != jobs.PostHookDone; i++ {
+		if i == 100 {
+			t.Fatal("expected the post hook for bar to be recorded as done")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, err := os.Stat(uploaded); err != nil {
+		t.Fatalf("expected the post hook to run for bar: %s", err)
+	}
+	if _, err := os.Stat(hooked); !os.IsNotExist(err) {
+		t.Fatal("expected the post hook to not run again for baz")
+	}
+}
+
+func TestJobSink_WatchTargets(t *testing.T) {
+	s := &JobSink{LogDir: "/work/logs", Jobs: &fakeCluster{}}
+	err := s.Targets.Set("handbrk8s,TV=tv@gpu-cluster")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	s.Profiles = JobProfiles{{Name: "kids", Libraries: []string{"Kids"}, Namespace: "team-kids"}}
+	s.contextClients = map[string]jobs.Client{"gpu-cluster": unavailableClient{errors.New("no route to gpu-cluster")}}
+
+	var watched []string
+	watchErrs := s.WatchTargets(func(target JobTarget, client jobs.Client) <-chan error {
+		watched = append(watched, target.String())
+		return client.ArchiveLogs(nil, target.Namespace, s.logDir(target))
+	})
+	var errs []error
+	for err := range watchErrs {
+		errs = append(errs, err)
+	}
+
+	want := []string{"handbrk8s", "team-kids", "tv@gpu-cluster", "team-kids@gpu-cluster"}
+	if len(watched) != len(want) {
+		t.Fatalf("expected the jobs to be watched on %v, got %v", want, watched)
+	}
+	for i := range want {
+		if watched[i] != want[i] {
+			t.Fatalf("expected the jobs to be watched on %v, got %v", want, watched)
+		}
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0].Error(), "@gpu-cluster: no route to gpu-cluster") {
+		t.Fatalf("expected the errors of the gpu cluster with its target, got %v", errs)
+	}
+
+	got := s.logFile(JobTarget{Namespace: "tv", Context: "gpu-cluster"}, "foo-mkv-transcode")
+	if wantLog := filepath.Join("/work/logs", "@gpu-cluster", "tv", "foo-mkv-transcode.log"); got != wantLog {
+		t.Fatalf("expected the logs of the gpu cluster in %s, got %s", wantLog, got)
+	}
+}
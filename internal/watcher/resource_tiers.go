@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"io/ioutil"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ResourceTier sizes the transcode pods of the videos that are at least as large as its
+// minimums, overriding the resources of the job profile that it sets.
+type ResourceTier struct {
+	// Name of the tier, which is logged when it sizes a video.
+	Name string `json:"name"`
+
+	// MinSize is the size of the smallest video in the tier, for example "20Gi", and
+	// MinHeight is the smallest height read by -ffprobe, for example 2160. A tier with
+	// both only applies to the videos that meet both, and a video that wasn't probed
+	// never meets a MinHeight.
+	MinSize   string `json:"minSize,omitempty"`
+	MinHeight int    `json:"minHeight,omitempty"`
+
+	// CPU and Memory are the resources requested by the transcode jobs, and
+	// CPULimit and MemoryLimit are their limits, for example "6" and "8Gi".
+	CPU         string `json:"cpu,omitempty"`
+	Memory      string `json:"memory,omitempty"`
+	CPULimit    string `json:"cpuLimit,omitempty"`
+	MemoryLimit string `json:"memoryLimit,omitempty"`
+
+	minSize int64
+}
+
+// ResourceTiers are the tiers that may size a video, the first matching tier is used.
+type ResourceTiers []ResourceTier
+
+// resourceTiersFile is the format of a resource tiers file.
+type resourceTiersFile struct {
+	Tiers ResourceTiers `json:"tiers"`
+}
+
+// LoadResourceTiers reads a YAML file with a list of tiers, largest first, for example:
+//
+//	tiers:
+//	- name: 4k
+//	  minHeight: 2160
+//	  cpu: "6"
+//	  memory: 8Gi
+//	- name: remux
+//	  minSize: 20Gi
+//	  cpu: "4"
+//	  memory: 4Gi
+func LoadResourceTiers(path string) (ResourceTiers, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read resource tiers file %s", path)
+	}
+
+	var f resourceTiersFile
+	err = yaml.Unmarshal(b, &f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse resource tiers file %s", path)
+	}
+
+	err = f.Tiers.validate()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid resource tiers file %s", path)
+	}
+	return f.Tiers, nil
+}
+
+// validate checks that the tiers have names, minimums and valid quantities.
+func (t ResourceTiers) validate() error {
+	for i := range t {
+		tier := &t[i]
+		if tier.Name == "" {
+			return errors.New("a tier is missing its name")
+		}
+		if tier.MinSize == "" && tier.MinHeight <= 0 {
+			return errors.Errorf("tier %s must set minSize or minHeight", tier.Name)
+		}
+		if tier.MinSize != "" {
+			q, err := resource.ParseQuantity(tier.MinSize)
+			if err != nil {
+				return errors.Wrapf(err, "invalid minSize %q in tier %s", tier.MinSize, tier.Name)
+			}
+			tier.minSize = q.Value()
+		}
+
+		for _, q := range []string{tier.CPU, tier.Memory, tier.CPULimit, tier.MemoryLimit} {
+			if q == "" {
+				continue
+			}
+			if _, err := resource.ParseQuantity(q); err != nil {
+				return errors.Wrapf(err, "invalid resource %q in tier %s", q, tier.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// matches determines if the video is at least as large as the minimums of the tier.
+// A video of an unknown size doesn't meet a MinSize.
+func (tier ResourceTier) matches(size int64, m *ffprobe.Metadata) bool {
+	if tier.MinSize != "" && (size <= 0 || size < tier.minSize) {
+		return false
+	}
+	if tier.MinHeight > 0 && (m == nil || m.Height < tier.MinHeight) {
+		return false
+	}
+	return true
+}
+
+// Size returns the profile with the resources of the first tier that matches the video,
+// and the name of the tier. Without a matching tier, the profile is returned unchanged.
+func (t ResourceTiers) Size(profile JobProfile, size int64, m *ffprobe.Metadata) (JobProfile, string) {
+	for _, tier := range t {
+		if !tier.matches(size, m) {
+			continue
+		}
+		if tier.CPU != "" {
+			profile.CPU = tier.CPU
+		}
+		if tier.Memory != "" {
+			profile.Memory = tier.Memory
+		}
+		if tier.CPULimit != "" {
+			profile.CPULimit = tier.CPULimit
+		}
+		if tier.MemoryLimit != "" {
+			profile.MemoryLimit = tier.MemoryLimit
+		}
+		return profile, tier.Name
+	}
+	return profile, ""
+}
@@ -0,0 +1,76 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/carolynvs/handbrk8s/internal/ffprobe"
+)
+
+func TestLoadResourceTiers(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Contents string
+		WantErr  bool
+	}{
+		{Name: "valid", Contents: "tiers:\n- name: 4k\n  minHeight: 2160\n  cpu: \"6\"\n- name: remux\n  minSize: 20Gi\n  memory: 8Gi\n"},
+		{Name: "missing name", Contents: "tiers:\n- minHeight: 2160\n", WantErr: true},
+		{Name: "missing minimums", Contents: "tiers:\n- name: all\n  cpu: \"6\"\n", WantErr: true},
+		{Name: "invalid size", Contents: "tiers:\n- name: remux\n  minSize: big\n", WantErr: true},
+		{Name: "invalid resource", Contents: "tiers:\n- name: 4k\n  minHeight: 2160\n  memory: lots\n", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			path := writeJobProfiles(t, tc.Contents)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			_, err := LoadResourceTiers(path)
+			if tc.WantErr && err == nil {
+				t.Fatal("expected the resource tiers to be invalid")
+			}
+			if !tc.WantErr && err != nil {
+				t.Fatalf("%+v", err)
+			}
+		})
+	}
+}
+
+func TestResourceTiers_Size(t *testing.T) {
+	tiers := ResourceTiers{
+		{Name: "4k", MinHeight: 2160, CPU: "6", Memory: "8Gi"},
+		{Name: "remux", MinSize: "20Gi", CPU: "4", MemoryLimit: "6Gi"},
+	}
+	if err := tiers.validate(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	profile := JobProfile{Name: "kids", CPU: "2", Memory: "1Gi"}
+
+	testcases := []struct {
+		Name     string
+		Size     int64
+		Metadata *ffprobe.Metadata
+		WantTier string
+		Want     JobProfile
+	}{
+		{Name: "small", Size: 1 << 30, Metadata: &ffprobe.Metadata{Height: 480}, Want: profile},
+		{Name: "4k", Size: 1 << 30, Metadata: &ffprobe.Metadata{Height: 2160}, WantTier: "4k",
+			Want: JobProfile{Name: "kids", CPU: "6", Memory: "8Gi"}},
+		{Name: "remux", Size: 30 << 30, WantTier: "remux",
+			Want: JobProfile{Name: "kids", CPU: "4", Memory: "1Gi", MemoryLimit: "6Gi"}},
+		{Name: "unknown size", Want: profile},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			got, tier := tiers.Size(profile, tc.Size, tc.Metadata)
+			if tier != tc.WantTier {
+				t.Fatalf("expected the %q tier, got %q", tc.WantTier, tier)
+			}
+			if got.Name != tc.Want.Name || got.CPU != tc.Want.CPU || got.Memory != tc.Want.Memory || got.MemoryLimit != tc.Want.MemoryLimit {
+				t.Fatalf("expected %#v, got %#v", tc.Want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package watcher
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// scheduleCheckInterval is how often the watcher checks if a processing window opened or closed.
+const scheduleCheckInterval = time.Minute
+
+// minutesPerDay is the end of a window that lasts until midnight, 24:00.
+const minutesPerDay = 24 * 60
+
+// weekdays are the abbreviations of the days in a TimeWindow.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// TimeWindow is a daily range of time, in the local time zone, optionally only on
+// some days of the week. A window that ends before it starts continues past midnight,
+// into the next day, and a window that ends when it starts lasts the whole day.
+type TimeWindow struct {
+	// Days of the week that the window starts on. When empty, the window is every day.
+	Days []time.Weekday
+
+	// Start and End are minutes since midnight, End may be 24:00.
+	Start, End int
+}
+
+// ParseTimeWindow parses a window such as 22:00-06:00, mon-fri 22:00-06:00 or sat,sun 00:00-24:00.
+func ParseTimeWindow(value string) (TimeWindow, error) {
+	var w TimeWindow
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, errors.Errorf("invalid time window %q, expected [DAYS] HH:MM-HH:MM", value)
+	}
+	if len(fields) == 2 {
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return w, errors.Wrapf(err, "invalid time window %q", value)
+		}
+		w.Days = days
+	}
+
+	times := strings.Split(fields[len(fields)-1], "-")
+	if len(times) != 2 {
+		return w, errors.Errorf("invalid time window %q, expected HH:MM-HH:MM", value)
+	}
+	var err error
+	w.Start, err = parseClock(times[0])
+	if err == nil {
+		w.End, err = parseClock(times[1])
+	}
+	if err != nil {
+		return w, errors.Wrapf(err, "invalid time window %q", value)
+	}
+	if w.Start == minutesPerDay {
+		return w, errors.Errorf("invalid time window %q, it can't start at 24:00", value)
+	}
+	return w, nil
+}
+
+// parseDays parses a comma separated list of days or ranges of days, such as mon-fri,sun.
+func parseDays(value string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, item := range strings.Split(strings.ToLower(value), ",") {
+		bounds := strings.Split(item, "-")
+		if len(bounds) > 2 {
+			return nil, errors.Errorf("invalid days %q", item)
+		}
+		first, err := parseDay(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = parseDay(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+		// A range may wrap around the end of the week, such as fri-mon
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseDay(value string) (time.Weekday, error) {
+	for i, day := range weekdays {
+		if value == day {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, errors.Errorf("invalid day %q, expected one of %s", value, strings.Join(weekdays, ", "))
+}
+
+// parseClock parses a time of day, HH:MM, into minutes since midnight.
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	clock := hours*60 + minutes
+	if hours < 0 || minutes < 0 || minutes > 59 || clock > minutesPerDay {
+		return 0, errors.Errorf("invalid time %q, expected 00:00 to 24:00", value)
+	}
+	return clock, nil
+}
+
+func (w TimeWindow) String() string {
+	clock := fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+	if len(w.Days) == 0 {
+		return clock
+	}
+	days := make([]string, len(w.Days))
+	for i, d := range w.Days {
+		days[i] = weekdays[d]
+	}
+	return strings.Join(days, ",") + " " + clock
+}
+
+// startsOn determines if the window starts on the day.
+func (w TimeWindow) startsOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains determines if the time is within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	clock := t.Hour()*60 + t.Minute()
+	switch {
+	case w.Start == w.End:
+		return w.startsOn(t.Weekday())
+	case w.Start < w.End:
+		return w.startsOn(t.Weekday()) && clock >= w.Start && clock < w.End
+	default:
+		// The window started yesterday, and continues past midnight
+		yesterday := (t.Weekday() + 6) % 7
+		return (w.startsOn(t.Weekday()) && clock >= w.Start) || (w.startsOn(yesterday) && clock < w.End)
+	}
+}
+
+// Schedule is when videos are handed to the sinks. Outside of its windows, videos are
+// held until the next window opens. An empty schedule is always open.
+type Schedule []TimeWindow
+
+// String prints the windows, separated by semicolons.
+func (s *Schedule) String() string {
+	windows := make([]string, len(*s))
+	for i, w := range *s {
+		windows[i] = w.String()
+	}
+	return strings.Join(windows, "; ")
+}
+
+// Set adds a window to the schedule, so that the flag can be repeated.
+func (s *Schedule) Set(value string) error {
+	w, err := ParseTimeWindow(value)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, w)
+	return nil
+}
+
+// IsOpen determines if the time is within any of the windows.
+func (s Schedule) IsOpen(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+	for _, w := range s {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
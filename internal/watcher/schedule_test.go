@@ -0,0 +1,107 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	testcases := []struct {
+		Value   string
+		Want    string
+		WantErr bool
+	}{
+		{Value: "22:00-06:00", Want: "22:00-06:00"},
+		{Value: "mon-fri 9:30-17:00", Want: "mon,tue,wed,thu,fri 09:30-17:00"},
+		{Value: "Fri-Mon,wed 00:00-24:00", Want: "fri,sat,sun,mon,wed 00:00-24:00"},
+		{Value: "22:00", WantErr: true},
+		{Value: "someday 22:00-06:00", WantErr: true},
+		{Value: "22:00-24:30", WantErr: true},
+		{Value: "24:00-06:00", WantErr: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Value, func(t *testing.T) {
+			w, err := ParseTimeWindow(tc.Value)
+			if tc.WantErr {
+				if err == nil {
+					t.Fatalf("expected %q to be rejected, got %s", tc.Value, w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if w.String() != tc.Want {
+				t.Fatalf("expected %s, got %s", tc.Want, w)
+			}
+		})
+	}
+}
+
+func TestSchedule_IsOpen(t *testing.T) {
+	var s Schedule
+	for _, value := range []string{"mon-fri 22:00-06:00", "sat,sun 10:00-10:00"} {
+		if err := s.Set(value); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	// 2026-10-12 is a Monday
+	at := func(day, hour, minute int) time.Time { return time.Date(2026, 10, day, hour, minute, 0, 0, time.Local) }
+	testcases := []struct {
+		Name string
+		At   time.Time
+		Want bool
+	}{
+		{Name: "monday evening", At: at(12, 23, 0), Want: true},
+		{Name: "tuesday morning, after a monday night", At: at(13, 5, 59), Want: true},
+		{Name: "tuesday day", At: at(13, 6, 0), Want: false},
+		{Name: "monday morning, after a sunday", At: at(12, 1, 0), Want: false},
+		{Name: "saturday morning, after a friday night", At: at(17, 2, 0), Want: true},
+		{Name: "saturday day", At: at(17, 15, 0), Want: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			if got := s.IsOpen(tc.At); got != tc.Want {
+				t.Fatalf("expected IsOpen to be %t at %s", tc.Want, tc.At)
+			}
+		})
+	}
+
+	if !(Schedule{}).IsOpen(at(13, 12, 0)) {
+		t.Fatal("expected an empty schedule to always be open")
+	}
+}
+
+func TestVideoWatcher_Schedule(t *testing.T) {
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+
+	// Hold the videos until the window opens
+	w.schedule = Schedule{{Start: 22 * 60, End: 6 * 60}}
+	w.checkSchedule(time.Date(2026, 10, 13, 12, 0, 0, 0, time.Local))
+	files <- fs.FileEvent{Path: "/watch/Movies/foo.mkv"}
+	select {
+	case e := <-sink.events:
+		t.Fatalf("expected no videos to be handled outside of the window, got %s", e.Path)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if status := w.Status(); !status.OutsideSchedule || status.Queued != 1 {
+		t.Fatalf("expected the video to be queued outside of the window, got %#v", status)
+	}
+
+	w.checkSchedule(time.Date(2026, 10, 13, 22, 0, 0, 0, time.Local))
+	select {
+	case e := <-sink.events:
+		if e.Path != "/watch/Movies/foo.mkv" {
+			t.Fatalf("expected the queued video to be handled, got %s", e.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the queued video to be handled once the window opened")
+	}
+}
@@ -0,0 +1,136 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/carolynvs/handbrk8s/internal/plex"
+	"github.com/pkg/errors"
+)
+
+// selfTestName names the synthetic video, and the directories that hold it, of a self-test.
+const selfTestName = "handbrk8s-selftest"
+
+// selfTestSeconds is the length of the synthetic video of a self-test.
+const selfTestSeconds = 2
+
+// selfTestPollInterval is how often the transcode job of a self-test is checked.
+const selfTestPollInterval = 2 * time.Second
+
+// SelfTestStep is a step of a self-test, with how long it took.
+type SelfTestStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// SelfTestReport lists the steps of a self-test, which stops at the first step that fails.
+type SelfTestReport struct {
+	Steps []SelfTestStep
+}
+
+// Passed determines if every step of the self-test succeeded.
+func (r SelfTestReport) Passed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// run the step and record how long it took, unless a previous step failed.
+func (r *SelfTestReport) run(name string, step func() error) {
+	if !r.Passed() {
+		return
+	}
+	start := time.Now()
+	err := step()
+	r.Steps = append(r.Steps, SelfTestStep{Name: name, Duration: time.Since(start), Err: err})
+}
+
+// runSelfTest transcodes a synthetic video from the claim directory into the transcoded
+// directory, checks the output, and then checks that the Plex server can be reached.
+// Nothing is uploaded to Plex, and the synthetic video and its output are removed.
+func runSelfTest(claimDir, transcodedDir string, plexCfg plex.ServerConfig, transcode func(inputPath, outputPath string) error) SelfTestReport {
+	inputPath := filepath.Join(claimDir, selfTestName, selfTestName+".y4m")
+	outputPath := filepath.Join(transcodedDir, selfTestName, selfTestName+DirectoryOutputExt)
+	defer os.RemoveAll(filepath.Dir(inputPath))
+	defer os.RemoveAll(filepath.Dir(outputPath))
+
+	var r SelfTestReport
+	r.run("generate a test video", func() error {
+		return handbrake.WriteTestVideo(inputPath, selfTestSeconds)
+	})
+	r.run("transcode the test video", func() error {
+		return transcode(inputPath, outputPath)
+	})
+	r.run("check the transcoded video", func() error {
+		size, err := fs.Size(outputPath)
+		if err != nil {
+			return errors.Wrap(err, "the test video was not transcoded")
+		}
+		if size == 0 {
+			return errors.Errorf("the transcoded test video %s is empty", outputPath)
+		}
+		return nil
+	})
+	r.run("connect to Plex", func() error {
+		_, err := plex.NewClient(plexCfg).Libraries()
+		return err
+	})
+	return r
+}
+
+// SelfTest transcodes a synthetic video with the encoder and the default preset, to check the
+// encoder and the configuration of the sink before videos are processed.
+func (s *LocalSink) SelfTest(ctx context.Context) SelfTestReport {
+	return runSelfTest(s.ClaimDir, s.TranscodedDir, s.PlexCfg.ServerConfig, func(inputPath, outputPath string) error {
+		encoder := s.Encoder
+		encoder.Preset = s.PresetRules.For(filepath.Base(inputPath), nil, s.Encoder.Preset)
+		return encoder.Transcode(ctx, inputPath, outputPath)
+	})
+}
+
+// SelfTest transcodes a synthetic video with a transcode job on the default target, and waits
+// for the job to finish, to check the cluster, the templates and the encoder before videos
+// are processed. The job is removed once it finishes.
+func (s *JobSink) SelfTest(ctx context.Context) SelfTestReport {
+	return runSelfTest(s.ClaimDir, s.TranscodedDir, s.PlexCfg.ServerConfig, func(inputPath, outputPath string) error {
+		e := fs.FileEvent{Path: inputPath}
+		profile, preset := s.selectProfile("", filepath.Base(inputPath), e)
+		target := profile.Target(s.Targets.For(""))
+		jobName, err := s.createTranscodeJob(ctx, target, profile, e, inputPath, outputPath, preset, "", nil, nil)
+		if err != nil {
+			return err
+		}
+		client := s.jobsClient(target)
+		defer client.Delete(jobName, target.Namespace)
+
+		ticker := time.NewTicker(selfTestPollInterval)
+		defer ticker.Stop()
+		for {
+			j, err := client.Get(jobName, target.Namespace)
+			if err != nil {
+				return err
+			}
+			if jobs.IsFailed(j) {
+				return errors.Errorf("the transcode job %s/%s failed", target.Namespace, jobName)
+			}
+			if jobs.IsFinished(j) {
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return errors.Wrapf(ctx.Err(), "the transcode job %s/%s did not finish", target.Namespace, jobName)
+			case <-ticker.C:
+			}
+		}
+	})
+}
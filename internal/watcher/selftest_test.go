@@ -0,0 +1,89 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLocalSink_SelfTest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	js := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	plexSrv := newFakePlex(js.PlexCfg.Share)
+	defer plexSrv.Close()
+
+	plexCfg := js.PlexCfg
+	plexCfg.URL = plexSrv.URL
+	s, err := NewLocalSink(filepath.Join(tmpDir, "watch"), filepath.Join(tmpDir, "work"), "tivo", plexCfg)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	s.Encoder.CLI = cluster.encoder
+
+	report := s.SelfTest(context.Background())
+	if !report.Passed() || len(report.Steps) != 4 {
+		t.Fatalf("expected every step of the self-test to pass, got %#v", report.Steps)
+	}
+	assertSelfTestCleanedUp(t, s.ClaimDir, s.TranscodedDir)
+
+	// An unreachable Plex server fails the last step
+	s.PlexCfg.URL = "http://127.0.0.1:1"
+	report = s.SelfTest(context.Background())
+	if report.Passed() || report.Steps[len(report.Steps)-1].Err == nil {
+		t.Fatalf("expected the self-test to fail to connect to Plex, got %#v", report.Steps)
+	}
+}
+
+func TestJobSink_SelfTest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := newTestJobSink(t, tmpDir)
+	cluster := newFakeCluster(t, tmpDir)
+	s.Jobs = cluster
+	plexSrv := newFakePlex(s.PlexCfg.Share)
+	defer plexSrv.Close()
+	s.PlexCfg.URL = plexSrv.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	report := s.SelfTest(ctx)
+	if !report.Passed() || len(report.Steps) != 4 {
+		t.Fatalf("expected every step of the self-test to pass, got %#v", report.Steps)
+	}
+	if jobs := cluster.listJobs("-transcode"); len(jobs) != 0 {
+		t.Fatalf("expected the transcode job to be removed, got %v", jobs)
+	}
+	assertSelfTestCleanedUp(t, s.ClaimDir, s.TranscodedDir)
+}
+
+func assertSelfTestCleanedUp(t *testing.T, claimDir, transcodedDir string) {
+	for _, dir := range []string{claimDir, transcodedDir} {
+		if _, err := os.Stat(filepath.Join(dir, selfTestName)); !os.IsNotExist(err) {
+			t.Fatalf("expected the self-test files in %s to be removed", dir)
+		}
+	}
+}
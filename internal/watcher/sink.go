@@ -0,0 +1,46 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+// EventSink processes videos that are ready to be transcoded.
+type EventSink interface {
+	// Handle processes a video, returning an error when it could not be handled.
+	Handle(ctx context.Context, e fs.FileEvent) error
+}
+
+// RejectHidden is a hidden file, which is never processed.
+const RejectHidden fs.RejectReason = "hidden"
+
+// RejectError is returned by a sink that deliberately skips a video,
+// which stops the video from being passed to the remaining sinks.
+type RejectError struct {
+	Reason fs.RejectReason
+}
+
+// Reject skips a video for the reason.
+func Reject(reason fs.RejectReason) error {
+	return RejectError{Reason: reason}
+}
+
+func (e RejectError) Error() string {
+	return fmt.Sprintf("rejected: %s", e.Reason)
+}
+
+// LogSink logs each video and otherwise does nothing.
+type LogSink struct{}
+
+// Handle logs the video.
+func (LogSink) Handle(ctx context.Context, e fs.FileEvent) error {
+	if e.Metadata != nil {
+		log.Printf("video is ready: %s (%s)\n", e.Path, e.Metadata)
+	} else {
+		log.Printf("video is ready: %s\n", e.Path)
+	}
+	return nil
+}
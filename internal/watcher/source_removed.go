@@ -0,0 +1,73 @@
+package watcher
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	"github.com/pkg/errors"
+)
+
+// RejectSourceRemoved is a video that was removed after it was found, before it was transcoded,
+// such as a video that was deleted by mistake while the pipeline was paused.
+const RejectSourceRemoved fs.RejectReason = "source-removed"
+
+// sourceCheckInterval is how often the queued videos are checked for being removed.
+const sourceCheckInterval = 30 * time.Second
+
+// isRemoved determines if the video no longer exists.
+func isRemoved(path string) bool {
+	_, err := os.Lstat(path)
+	return os.IsNotExist(err)
+}
+
+// pruneRemoved drops the queued videos that were removed while they waited for the
+// pipeline to resume, or for the next processing window.
+func (w *VideoWatcher) pruneRemoved() {
+	w.mu.Lock()
+	var removed []fs.FileEvent
+	queued := w.queued[:0]
+	for _, file := range w.queued {
+		if isRemoved(file.Path) {
+			removed = append(removed, file)
+			continue
+		}
+		queued = append(queued, file)
+	}
+	w.queued = queued
+	w.mu.Unlock()
+
+	for _, file := range removed {
+		log.Printf("%s was removed while it was queued, skipping\n", file.Path)
+		w.finish(file, false)
+		w.reject(fs.RejectedFile{Path: file.Path, Reason: RejectSourceRemoved})
+	}
+}
+
+// cancelRemovedClaim deletes the jobs of a video whose claimed video was removed before its
+// transcode job finished, instead of letting the transcode fail on the missing video.
+// Returns if the jobs were deleted.
+func (s *JobSink) cancelRemovedClaim(ctx context.Context, target JobTarget, path, claimPath, transcodeJobName string, jobNames []string) bool {
+	if !isRemoved(claimPath) {
+		return false
+	}
+	client := s.jobsClient(target)
+	transcode, err := client.Get(transcodeJobName, target.Namespace)
+	if err != nil || jobs.IsFinished(transcode) {
+		return false
+	}
+
+	logf(ctx, "%s was removed before it was transcoded, deleting its jobs\n", claimPath)
+	for _, name := range jobNames {
+		err := client.Delete(name, target.Namespace)
+		if err != nil {
+			logln(ctx, errors.Wrapf(err, "unable to delete the job %s of the removed video %s", name, path))
+		}
+		Publish(ctx, PipelineEvent{Type: EventJobFinished, Path: path, Job: name})
+	}
+	Publish(ctx, PipelineEvent{Type: EventSkipped, Path: path, Reason: RejectSourceRemoved})
+	return true
+}
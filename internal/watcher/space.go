@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/notify"
+	"github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+// SpaceCheck holds a video until the output volume has enough free space to transcode it,
+// instead of starting a transcode that fails with a truncated output when the volume fills up.
+// The space is reserved for the video until it is released, so that videos that are checked
+// at the same time don't all count the same free space.
+type SpaceCheck struct {
+	// MinFree is the space in bytes that must remain free on the output volume,
+	// after the transcoded video is written. 0 disables the check.
+	MinFree int64
+
+	// EstimateRatio estimates the size of the transcoded video as this ratio of the
+	// raw video's size. 0 doesn't reserve any space for the transcoded video.
+	EstimateRatio float64
+
+	// Interval is how often the free space is checked again while a video is held.
+	Interval time.Duration
+
+	// Notifier optionally sends an alert when a video is held.
+	Notifier notify.Notifier
+
+	// freeSpace reads the free space of the output volume, defaults to fs.FreeSpace.
+	freeSpace func(path string) (int64, error)
+}
+
+// defaultSpaceCheckInterval is how often a held video checks the free space again, when SpaceCheck.Interval isn't set.
+const defaultSpaceCheckInterval = time.Minute
+
+// spaceReservation is the space reserved in an output directory for a video that is in flight.
+type spaceReservation struct {
+	dir  string
+	size int64
+}
+
+// reservedSpace is the space reserved for each video that is in flight, by its path. It is
+// shared by every sink, because the free space belongs to the output volume, not the sink.
+var reservedSpace = struct {
+	sync.Mutex
+	videos map[string]spaceReservation
+}{videos: make(map[string]spaceReservation)}
+
+// Wait blocks until the output directory has enough free space to transcode the video, or
+// the context is cancelled. The check is skipped when the free space cannot be read. Once
+// there is enough space, the estimated size of the transcoded video is reserved for it,
+// until Release is called.
+func (c SpaceCheck) Wait(ctx context.Context, outputDir, inputPath string) error {
+	if c.MinFree <= 0 {
+		return nil
+	}
+
+	var estimate int64
+	if size, err := fs.Size(inputPath); err == nil {
+		estimate = int64(float64(size) * c.EstimateRatio)
+	}
+	needed := c.MinFree + estimate
+
+	freeSpace := c.freeSpace
+	if freeSpace == nil {
+		freeSpace = fs.FreeSpace
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = defaultSpaceCheckInterval
+	}
+
+	held := false
+	for {
+		free, err := freeSpace(outputDir)
+		if err != nil {
+			logln(ctx, errors.Wrapf(err, "skipping the free space check for %s", inputPath))
+			return nil
+		}
+		free, ok := reserve(outputDir, inputPath, free, needed, estimate)
+		if ok {
+			if held {
+				logf(ctx, "%s has enough free space for %s, continuing\n", outputDir, inputPath)
+			}
+			return nil
+		}
+
+		if !held {
+			held = true
+			msg := fmt.Sprintf("holding %s until %s has %s free, only %s is available",
+				inputPath, outputDir, humanize.Bytes(uint64(needed)), humanize.Bytes(uint64(free)))
+			logln(ctx, msg)
+			if c.Notifier != nil {
+				err = c.Notifier.Notify(tag(ctx, msg))
+				if err != nil {
+					logln(ctx, err)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "gave up waiting for free space for %s", inputPath)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Release frees the space reserved for the video, once its transcoded video was uploaded, or it failed.
+func (c SpaceCheck) Release(inputPath string) {
+	reservedSpace.Lock()
+	defer reservedSpace.Unlock()
+	delete(reservedSpace.videos, inputPath)
+}
+
+// reserve the estimated size of the transcoded video in the output directory, when the free
+// space that isn't reserved for other videos is at least what is needed. Returns the free
+// space that isn't reserved.
+func reserve(outputDir, inputPath string, free, needed, estimate int64) (int64, bool) {
+	reservedSpace.Lock()
+	defer reservedSpace.Unlock()
+
+	for path, r := range reservedSpace.videos {
+		if r.dir == outputDir && path != inputPath {
+			free -= r.size
+		}
+	}
+	if free < needed {
+		return free, false
+	}
+	reservedSpace.videos[inputPath] = spaceReservation{dir: outputDir, size: estimate}
+	return free, true
+}
@@ -0,0 +1,128 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier remembers each alert.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []string
+}
+
+func (n *recordingNotifier) Notify(message string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, message)
+	return nil
+}
+
+func TestSpaceCheck_Wait(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	err = ioutil.WriteFile(video, make([]byte, 100), 0644)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// The volume is full until the third check
+	var mu sync.Mutex
+	checks := 0
+	notifier := &recordingNotifier{}
+	c := SpaceCheck{
+		MinFree:       1000,
+		EstimateRatio: 0.5,
+		Interval:      10 * time.Millisecond,
+		Notifier:      notifier,
+		freeSpace: func(path string) (int64, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			checks++
+			if checks < 3 {
+				return 1049, nil
+			}
+			return 1050, nil
+		},
+	}
+
+	err = c.Wait(context.Background(), tmpDir, video)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if checks != 3 {
+		t.Fatalf("expected the video to be held until the third check, got %d checks", checks)
+	}
+	if len(notifier.alerts) != 1 {
+		t.Fatalf("expected a single alert when the video was held, got %v", notifier.alerts)
+	}
+}
+
+func TestSpaceCheck_WaitCancelled(t *testing.T) {
+	c := SpaceCheck{
+		MinFree:   1000,
+		Interval:  10 * time.Millisecond,
+		freeSpace: func(path string) (int64, error) { return 0, nil },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := c.Wait(ctx, "/work", "/watch/foo.mkv")
+	if err == nil {
+		t.Fatal("expected an error when the context is cancelled while waiting for space")
+	}
+}
+
+func TestSpaceCheck_WaitReserves(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	foo := filepath.Join(tmpDir, "foo.mkv")
+	bar := filepath.Join(tmpDir, "bar.mkv")
+	for _, video := range []string{foo, bar} {
+		err = ioutil.WriteFile(video, make([]byte, 100), 0644)
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	// There is only enough free space for one of the videos
+	c := SpaceCheck{
+		MinFree:       1000,
+		EstimateRatio: 1,
+		Interval:      10 * time.Millisecond,
+		freeSpace:     func(path string) (int64, error) { return 1150, nil },
+	}
+	err = c.Wait(context.Background(), tmpDir, foo)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer c.Release(foo)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = c.Wait(ctx, tmpDir, bar)
+	if err == nil {
+		t.Fatal("expected the second video to be held while the space is reserved for the first")
+	}
+
+	c.Release(foo)
+	err = c.Wait(context.Background(), tmpDir, bar)
+	if err != nil {
+		t.Fatalf("expected the second video to continue once the space was released, got %+v", err)
+	}
+	c.Release(bar)
+}
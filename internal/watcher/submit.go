@@ -0,0 +1,39 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// submitThrottle spaces out job creations, so that a backlog of videos doesn't
+// create all of its jobs at the same instant.
+type submitThrottle struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until at least the interval has passed since the previous
+// submission was allowed, or the context is done. Concurrent callers are each
+// given their own slot.
+func (t *submitThrottle) wait(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	at := t.next
+	if at.Before(now) {
+		at = now
+	}
+	t.next = at.Add(interval)
+	t.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Until(at)):
+		return nil
+	}
+}
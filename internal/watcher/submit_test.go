@@ -0,0 +1,58 @@
+package watcher
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubmitThrottle_Wait(t *testing.T) {
+	var throttle submitThrottle
+	interval := 50 * time.Millisecond
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			throttle.wait(context.Background(), interval)
+		}()
+	}
+	wg.Wait()
+
+	// The first submission is immediate, and each of the others waits its turn
+	if elapsed := time.Since(start); elapsed < 2*interval {
+		t.Fatalf("expected 3 submissions to take at least %s, took %s", 2*interval, elapsed)
+	}
+
+	start = time.Now()
+	var disabled submitThrottle
+	for i := 0; i < 3; i++ {
+		disabled.wait(context.Background(), 0)
+	}
+	if elapsed := time.Since(start); elapsed >= interval {
+		t.Fatalf("expected submissions without an interval to be immediate, took %s", elapsed)
+	}
+}
+
+func TestSubmitThrottle_WaitCancelled(t *testing.T) {
+	var throttle submitThrottle
+	interval := time.Minute
+	err := throttle.wait(context.Background(), interval)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = throttle.wait(ctx, interval)
+	if err == nil {
+		t.Fatal("expected an error when the context is done while waiting for the next slot")
+	}
+	if elapsed := time.Since(start); elapsed >= interval {
+		t.Fatalf("expected the wait to stop once the context was done, took %s", elapsed)
+	}
+}
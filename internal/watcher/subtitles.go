@@ -0,0 +1,123 @@
+package watcher
+
+import (
+	"log"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+)
+
+// RejectSubtitle is an external subtitle file, which is transcoded along with its video.
+const RejectSubtitle fs.RejectReason = "subtitle"
+
+// subtitleGroups holds each video until the subtitle files next to it are stable too,
+// so that they are transcoded together. It is protected by the mutex of the VideoWatcher.
+type subtitleGroups struct {
+	// grace is how long a video waits for its subtitles to be stable.
+	grace time.Duration
+
+	// stable are the subtitle files that were signaled, and aren't grouped with a video yet.
+	stable map[string]bool
+
+	// waiting are the videos whose subtitles aren't stable yet, by path.
+	waiting map[string]*subtitledVideo
+}
+
+// subtitledVideo is a video that is waiting for its subtitles.
+type subtitledVideo struct {
+	file      fs.FileEvent
+	subtitles []string
+	timer     *time.Timer
+}
+
+// UseSubtitles groups each video with the external subtitle files next to it, with the same
+// base name, such as foo.en.srt for foo.mkv, so that the subtitles are added to the transcoded
+// video. A video waits until its subtitles are stable too, or for the grace period, after
+// which it is processed with the subtitles that are stable. The subtitle files are not
+// handed to the sinks on their own, and must be signaled by the directory watcher.
+func (w *VideoWatcher) UseSubtitles(grace time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.subtitles = &subtitleGroups{
+		grace:   grace,
+		stable:  make(map[string]bool),
+		waiting: make(map[string]*subtitledVideo),
+	}
+}
+
+// holdLocked records a stable subtitle file, handing the video waiting for it to the sinks once
+// all of its subtitles are stable, or holds a video until its subtitles are stable. Returns
+// true when the file was held, and must not be dispatched. The caller must hold mu.
+func (g *subtitleGroups) holdLocked(w *VideoWatcher, file fs.FileEvent) bool {
+	if handbrake.IsSubtitle(file.Path) {
+		g.stable[file.Path] = true
+		for path, v := range g.waiting {
+			if g.isStable(v.subtitles) {
+				v.timer.Stop()
+				delete(g.waiting, path)
+				w.dispatchLocked(g.group(v.file, v.subtitles), true)
+			}
+		}
+		w.reject(fs.RejectedFile{Path: file.Path, Reason: RejectSubtitle})
+		return true
+	}
+
+	subtitles, err := handbrake.SubtitlesOf(file.Path)
+	if err != nil {
+		log.Println(err)
+	}
+	if g.isStable(subtitles) {
+		w.dispatchLocked(g.group(file, subtitles), true)
+		return true
+	}
+
+	log.Printf("waiting up to %s for the subtitles of %s to be stable\n", g.grace, file.Path)
+	v := &subtitledVideo{file: file, subtitles: subtitles}
+	v.timer = time.AfterFunc(g.grace, func() { w.stopWaitingForSubtitles(file.Path) })
+	g.waiting[file.Path] = v
+	return true
+}
+
+// stopWaitingForSubtitles hands the video to the sinks with the subtitles that are stable,
+// once its grace period is over.
+func (w *VideoWatcher) stopWaitingForSubtitles(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	g := w.subtitles
+	v, ok := g.waiting[path]
+	if !ok {
+		return
+	}
+	delete(g.waiting, path)
+
+	var stable []string
+	for _, subtitle := range v.subtitles {
+		if g.stable[subtitle] {
+			stable = append(stable, subtitle)
+		} else {
+			log.Printf("%s is still not stable, processing %s without it\n", subtitle, path)
+		}
+	}
+	w.dispatchLocked(g.group(v.file, stable), true)
+}
+
+func (g *subtitleGroups) isStable(subtitles []string) bool {
+	for _, subtitle := range subtitles {
+		if !g.stable[subtitle] {
+			return false
+		}
+	}
+	return true
+}
+
+// group the subtitles with the video, so that they are no longer tracked on their own.
+func (g *subtitleGroups) group(file fs.FileEvent, subtitles []string) fs.FileEvent {
+	for _, subtitle := range subtitles {
+		delete(g.stable, subtitle)
+	}
+	file.Subtitles = subtitles
+	return file
+}
@@ -0,0 +1,84 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+)
+
+func TestVideoWatcher_UseSubtitles(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	subtitle := filepath.Join(tmpDir, "foo.en.srt")
+	createFile(t, video)
+	createFile(t, subtitle)
+
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+	w.UseSubtitles(time.Minute)
+
+	files <- fs.FileEvent{Path: video}
+	select {
+	case e := <-sink.events:
+		t.Fatalf("expected the video to wait for its subtitles, got %s", e.Path)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	files <- fs.FileEvent{Path: subtitle}
+	select {
+	case e := <-sink.events:
+		if e.Path != video || !reflect.DeepEqual(e.Subtitles, []string{subtitle}) {
+			t.Fatalf("expected %s to be handled with its subtitles, got %#v", video, e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the video to be handled once its subtitles were stable")
+	}
+	r := <-w.Rejected
+	if r.Path != subtitle || r.Reason != RejectSubtitle {
+		t.Fatalf("expected the subtitle to be skipped as %s, got %#v", RejectSubtitle, r)
+	}
+}
+
+func TestVideoWatcher_UseSubtitles_Grace(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	createFile(t, video)
+	createFile(t, filepath.Join(tmpDir, "foo.srt"))
+
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+	w.UseSubtitles(100 * time.Millisecond)
+
+	files <- fs.FileEvent{Path: video}
+	select {
+	case e := <-sink.events:
+		if e.Path != video || len(e.Subtitles) != 0 {
+			t.Fatalf("expected %s to be handled without the subtitle that isn't stable, got %#v", video, e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the video to be handled once the grace period was over")
+	}
+}
@@ -0,0 +1,564 @@
+package watcher
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var templatesDir = filepath.Join("..", "..", "manifests", "job-templates")
+
+func buildJob(t *testing.T, templateName string, values interface{}) *batchv1.Job {
+	template, err := ioutil.ReadFile(filepath.Join(templatesDir, templateName))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	j, err := jobs.BuildFromTemplate(string(template), values)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return j
+}
+
+func TestUploadTemplate_PlexToken(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", PlexToken: "abc123"})
+
+	env := j.Spec.Template.Spec.Containers[0].Env[0]
+	if env.Value != "abc123" {
+		t.Fatalf("expected the token to be embedded in the job, got %#v", env)
+	}
+}
+
+func TestUploadTemplate_PlexTokenSecret(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", PlexTokenSecret: "plex"})
+
+	env := j.Spec.Template.Spec.Containers[0].Env[0]
+	if env.Value != "" {
+		t.Fatalf("expected the token to not be embedded in the job, got %#v", env)
+	}
+	if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil || env.ValueFrom.SecretKeyRef.Name != "plex" {
+		t.Fatalf("expected the token to be read from the plex secret, got %#v", env.ValueFrom)
+	}
+}
+
+func TestTranscodeTemplate_PresetFile(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", PresetFile: "/config/ghb/presets.json"})
+
+	flags := parseArgs(j.Spec.Template.Spec.Containers[0].Args)
+	if flags["--preset-import-file"] != "/config/ghb/presets.json" {
+		t.Fatalf("expected the custom presets to be imported, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+}
+
+func TestTranscodeTemplate_BuiltinPreset(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "Fast 1080p30"})
+
+	flags := parseArgs(j.Spec.Template.Spec.Containers[0].Args)
+	if _, ok := flags["--preset-import-file"]; ok {
+		t.Fatalf("expected no presets to be imported, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+	if flags["--preset"] != "Fast 1080p30" {
+		t.Fatalf("expected the built-in preset, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+}
+
+func TestTranscodeTemplate_Container(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", OutputPath: "/work/transcoded/foo.mp4", ContainerArgs: handbrake.ContainerArgs(".mp4")})
+
+	args := strings.Join(j.Spec.Template.Spec.Containers[0].Args, " ")
+	if !strings.Contains(args, "-o /work/transcoded/foo.mp4 --preset tivo --format av_mp4 --optimize") {
+		t.Fatalf("expected the MP4 container to be written, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+}
+
+func TestTranscodeTemplate_QueueSpec(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", QueueSpecFile: "/work/transcoded/.foo.mkv.queue.json",
+		SubtitleArgs: []string{"--srt-file", "/work/claimed/foo.srt"}})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	if strings.Join(args, " ") != "--queue-import-file /work/transcoded/.foo.mkv.queue.json" {
+		t.Fatalf("expected the queue spec to be imported instead of the preset, got %v", args)
+	}
+}
+
+func TestTranscodeTemplate_Retries(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", RestartPolicy: corev1.RestartPolicyNever, BackoffLimit: 3})
+
+	if j.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Fatalf("expected the Never restart policy, got %s", j.Spec.Template.Spec.RestartPolicy)
+	}
+	if j.Spec.BackoffLimit == nil || *j.Spec.BackoffLimit != 3 {
+		t.Fatalf("expected a backoff limit of 3, got %v", j.Spec.BackoffLimit)
+	}
+}
+
+func TestUploadTemplate_Archive(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", ArchivePath: "/work/archive/Movies/foo.mkv"})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	flags := parseArgs(args)
+	if flags["--archive"] != "/work/archive/Movies/foo.mkv" {
+		t.Fatalf("expected the raw video to be archived, got %v", args)
+	}
+	if args[len(args)-1] != "--archive-rollback=false" {
+		t.Fatalf("expected rollback to be disabled, got %v", args)
+	}
+}
+
+func TestUploadTemplate_NoArchive(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo"})
+
+	flags := parseArgs(j.Spec.Template.Spec.Containers[0].Args)
+	if _, ok := flags["--archive"]; ok {
+		t.Fatalf("expected the raw video to not be archived, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+}
+
+func TestUploadTemplate_MaxSizeRatio(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", MaxSizeRatio: 1.5, FailedFile: "/work/failed/Movies/foo.mkv"})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	flags := parseArgs(args)
+	if flags["--max-size-ratio"] != "1.5" {
+		t.Fatalf("expected the size ratio to be passed to the uploader, got %v", args)
+	}
+	if flags["--failed"] != "/work/failed/Movies/foo.mkv" {
+		t.Fatalf("expected the failed path to be passed to the uploader, got %v", args)
+	}
+}
+
+func TestUploadTemplate_MinScanInterval(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", PlexMinScanInterval: 10 * time.Minute})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	if flags := parseArgs(args); flags["--min-scan-interval"] != "10m0s" {
+		t.Fatalf("expected the minimum scan interval to be passed to the uploader, got %v", args)
+	}
+
+	j = buildJob(t, "upload.yaml", uploadJobValues{Name: "foo"})
+	for _, arg := range j.Spec.Template.Spec.Containers[0].Args {
+		if arg == "--min-scan-interval" {
+			t.Fatalf("expected no minimum scan interval by default, got %v", j.Spec.Template.Spec.Containers[0].Args)
+		}
+	}
+}
+
+func TestUploadTemplate_PlexHTTP(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", PlexHTTP: httpclient.Policy{Timeout: 10 * time.Second, Retries: 3}})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	if flags := parseArgs(args); flags["--plex-timeout"] != "10s" || flags["--plex-retries"] != "3" {
+		t.Fatalf("expected the Plex timeout and retries to be passed to the uploader, got %v", args)
+	}
+
+	j = buildJob(t, "upload.yaml", uploadJobValues{Name: "foo"})
+	for _, arg := range j.Spec.Template.Spec.Containers[0].Args {
+		if arg == "--plex-timeout" {
+			t.Fatalf("expected the uploader to use its default Plex timeout, got %v", j.Spec.Template.Spec.Containers[0].Args)
+		}
+	}
+}
+
+func TestUploadTemplate_VerifyArchive(t *testing.T) {
+	hasFlag := func(args []string) bool {
+		for _, arg := range args {
+			if arg == "--verify-archive" {
+				return true
+			}
+		}
+		return false
+	}
+
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", ArchivePath: "/archive/foo.mkv", VerifyArchive: true})
+	if args := j.Spec.Template.Spec.Containers[0].Args; !hasFlag(args) {
+		t.Fatalf("expected the uploader to verify the archive, got %v", args)
+	}
+
+	j = buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", ArchivePath: "/archive/foo.mkv"})
+	if args := j.Spec.Template.Spec.Containers[0].Args; hasFlag(args) {
+		t.Fatalf("expected the archive to not be verified by default, got %v", args)
+	}
+}
+
+func TestUploadTemplate_Namespace(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", Namespace: "tv", WaitForJob: "foo-transcode"})
+
+	if j.Namespace != "tv" {
+		t.Fatalf("expected the job to be created in the tv namespace, got %q", j.Namespace)
+	}
+	flags := parseArgs(j.Spec.Template.Spec.InitContainers[0].Args)
+	if flags["--namespace"] != "tv" {
+		t.Fatalf("expected to wait for the transcode job in the tv namespace, got %v", j.Spec.Template.Spec.InitContainers[0].Args)
+	}
+}
+
+func TestTranscodeTemplate_ActiveDeadline(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo"})
+	if j.Spec.ActiveDeadlineSeconds != nil {
+		t.Fatalf("expected no deadline by default, got %d", *j.Spec.ActiveDeadlineSeconds)
+	}
+
+	j = buildJob(t, "transcode-batch.yaml", batchTranscodeJobValues{Name: "foo", ActiveDeadlineSeconds: 7200})
+	if j.Spec.ActiveDeadlineSeconds == nil || *j.Spec.ActiveDeadlineSeconds != 7200 {
+		t.Fatalf("expected the deadline to be set on the job, got %v", j.Spec.ActiveDeadlineSeconds)
+	}
+}
+
+func TestUploadTemplate_Checksum(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", ChecksumSidecar: true})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	if args[len(args)-1] != "--checksum-sidecar" {
+		t.Fatalf("expected the checksum to be written to a sidecar file, got %v", args)
+	}
+	for _, arg := range args {
+		if arg == "--checksum" {
+			t.Fatalf("expected --checksum to be omitted, got %v", args)
+		}
+	}
+}
+
+func TestUploadTemplate_AllowedDirs(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", AllowedDirs: "/watch,/work,/plex"})
+
+	args := j.Spec.Template.Spec.Containers[0].Args
+	flags := parseArgs(args)
+	if flags["--allowed-dirs"] != "/watch,/work,/plex" {
+		t.Fatalf("expected the allowed directories to be passed to the uploader, got %v", args)
+	}
+}
+
+func TestUploadTemplate_OutputBucket(t *testing.T) {
+	bucket := OutputBucket{Prefix: "videos/", Secret: "output-bucket"}
+	bucket.Endpoint = "http://minio:9000"
+	bucket.Bucket = "plex"
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", OutputBucket: bucket})
+
+	container := j.Spec.Template.Spec.Containers[0]
+	flags := parseArgs(container.Args)
+	if flags["--output-s3-bucket"] != "plex" || flags["--output-s3-prefix"] != "videos/" || flags["--output-s3-endpoint"] != "http://minio:9000" {
+		t.Fatalf("expected the output bucket to be passed to the uploader, got %v", container.Args)
+	}
+	for _, env := range container.Env[1:] {
+		if env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil || env.ValueFrom.SecretKeyRef.Name != "output-bucket" {
+			t.Fatalf("expected %s to be read from the output-bucket secret, got %#v", env.Name, env)
+		}
+	}
+
+	j = buildJob(t, "upload.yaml", uploadJobValues{Name: "foo"})
+	if len(j.Spec.Template.Spec.Containers[0].Env) != 1 {
+		t.Fatalf("expected no bucket keys without an output bucket, got %#v", j.Spec.Template.Spec.Containers[0].Env)
+	}
+}
+
+func TestUploadTemplate_MountWatchVolume(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", ArchivePath: "/watch/watch/Movies/foo.mkv.done", MountWatchVolume: true})
+
+	mounts := j.Spec.Template.Spec.Containers[0].VolumeMounts
+	if last := mounts[len(mounts)-1]; last.MountPath != "/watch" || last.Name != "handbrk8s" {
+		t.Fatalf("expected the watch volume to be mounted, got %#v", mounts)
+	}
+}
+
+func TestTranscodeTemplate_MountWatchVolume(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", InputPath: "/watch/watch/.processing/Movies/foo.mkv", MountWatchVolume: true})
+
+	mounts := j.Spec.Template.Spec.Containers[0].VolumeMounts
+	var mounted bool
+	for _, m := range mounts {
+		mounted = mounted || (m.MountPath == "/watch" && m.Name == "handbrk8s")
+	}
+	if !mounted {
+		t.Fatalf("expected the watch volume to be mounted, got %#v", mounts)
+	}
+}
+
+func TestTemplates_ManagedBy(t *testing.T) {
+	for _, j := range []*batchv1.Job{
+		buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo"}),
+		buildJob(t, "transcode-batch.yaml", batchTranscodeJobValues{Name: "foo"}),
+		buildJob(t, "upload.yaml", uploadJobValues{Name: "foo"}),
+	} {
+		if j.Labels[jobs.ManagedByLabel] != jobs.ManagedBy {
+			t.Fatalf("expected %s to be labeled as managed by handbrk8s, got %v", j.Name, j.Labels)
+		}
+	}
+}
+
+func TestUploadTemplate_Annotations(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo", Source: `/watch/watch/Movies/"foo".mkv`, Output: "/plex/Movies/foo.mkv", PostHook: true})
+
+	if got := j.Annotations[jobs.SourceAnnotation]; got != `/watch/watch/Movies/"foo".mkv` {
+		t.Fatalf("expected the source to be recorded on the job, got %q", got)
+	}
+	if got := j.Annotations[jobs.OutputAnnotation]; got != "/plex/Movies/foo.mkv" {
+		t.Fatalf("expected the output to be recorded on the job, got %q", got)
+	}
+	if got := j.Annotations[jobs.PostHookAnnotation]; got != jobs.PostHookPending {
+		t.Fatalf("expected the post hook to be pending, got %q", got)
+	}
+
+	j = buildJob(t, "upload.yaml", uploadJobValues{Name: "foo"})
+	if _, ok := j.Annotations[jobs.PostHookAnnotation]; ok {
+		t.Fatalf("expected no post hook annotation without a post hook, got %v", j.Annotations)
+	}
+}
+
+func TestTranscodeTemplate_Profile(t *testing.T) {
+	profile := JobProfile{
+		Name:        "kids",
+		Image:       "example/handbrakecli:nightly",
+		Args:        []string{"--encoder-preset", "slow"},
+		CPU:         "2",
+		MemoryLimit: "4Gi",
+		Labels:      map[string]string{"team": "kids"},
+	}
+	for _, j := range []*batchv1.Job{
+		buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", Profile: profile}),
+		buildJob(t, "transcode-batch.yaml", batchTranscodeJobValues{Name: "foo", Profile: profile}),
+	} {
+		if j.Labels["team"] != "kids" || j.Spec.Template.Labels["team"] != "kids" {
+			t.Fatalf("expected the profile labels on the job and its pods, got %v and %v", j.Labels, j.Spec.Template.Labels)
+		}
+		c := j.Spec.Template.Spec.Containers[0]
+		if c.Image != profile.Image {
+			t.Fatalf("expected the profile image, got %s", c.Image)
+		}
+		if cpu := c.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "2" {
+			t.Fatalf("expected the profile cpu request, got %s", cpu.String())
+		}
+		if mem := c.Resources.Limits[corev1.ResourceMemory]; mem.String() != "4Gi" {
+			t.Fatalf("expected the profile memory limit, got %s", mem.String())
+		}
+	}
+
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", Profile: profile})
+	args := j.Spec.Template.Spec.Containers[0].Args
+	if parseArgs(args)["--encoder-preset"] != "slow" {
+		t.Fatalf("expected the profile args to be passed to HandBrakeCLI, got %v", args)
+	}
+}
+
+func TestTranscodeTemplate_ProfileQuoted(t *testing.T) {
+	profile := JobProfile{
+		Name: "kids",
+		Args: []string{"--encopts", "x264: \"fast\" # keep\nname: injected"},
+	}
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", Profile: profile})
+	args := j.Spec.Template.Spec.Containers[0].Args
+	if parseArgs(args)["--encopts"] != profile.Args[1] {
+		t.Fatalf("expected the profile args to be passed to HandBrakeCLI as is, got %v", args)
+	}
+
+	// A label value that isn't valid is rejected as one value, instead of injecting fields
+	profile.Labels = map[string]string{"team": "kids\nname: injected"}
+	for templateName, values := range map[string]interface{}{
+		"transcode.yaml":       transcodeJobValues{Name: "foo", Preset: "tivo", Profile: profile},
+		"transcode-batch.yaml": batchTranscodeJobValues{Name: "foo", Profile: profile},
+	} {
+		template, err := ioutil.ReadFile(filepath.Join(templatesDir, templateName))
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		_, err = jobs.BuildFromTemplate(string(template), values)
+		if err == nil || !strings.Contains(err.Error(), `metadata.labels[team]: Invalid value: "kids\nname: injected"`) {
+			t.Fatalf("expected the label value to be rejected by %s, got %v", templateName, err)
+		}
+	}
+}
+
+func TestTranscodeTemplate_DefaultProfile(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo"})
+
+	c := j.Spec.Template.Spec.Containers[0]
+	if c.Image != "carolynvs/handbrakecli:1.2.0" {
+		t.Fatalf("expected the default image, got %s", c.Image)
+	}
+	if cpu := c.Resources.Requests[corev1.ResourceCPU]; cpu.String() != "3" {
+		t.Fatalf("expected the default cpu request, got %s", cpu.String())
+	}
+	if len(c.Resources.Limits) != 0 || len(j.Spec.Template.Labels) != 0 {
+		t.Fatalf("expected no limits or pod labels by default, got %v and %v", c.Resources.Limits, j.Spec.Template.Labels)
+	}
+}
+
+func TestTranscodeTemplate_SuccessExitCodes(t *testing.T) {
+	codes := handbrake.ExitCodes{2, 3}
+	for _, j := range []*batchv1.Job{
+		buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", SuccessExitCodes: codes}),
+		buildJob(t, "transcode-batch.yaml", batchTranscodeJobValues{Name: "foo", SuccessExitCodes: codes}),
+	} {
+		c := j.Spec.Template.Spec.Containers[0]
+		var got string
+		for _, env := range c.Env {
+			if env.Name == "SUCCESS_EXIT_CODES" {
+				got = env.Value
+			}
+		}
+		if got != "2 3 " {
+			t.Fatalf("expected the success exit codes to be passed to the transcode, got %q", got)
+		}
+		if len(c.Command) == 0 || c.Command[0] != "sh" {
+			t.Fatalf("expected the exit code to be checked by a shell, got %v", c.Command)
+		}
+	}
+
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo"})
+	if c := j.Spec.Template.Spec.Containers[0]; len(c.Command) != 0 || len(c.Env) != 0 {
+		t.Fatalf("expected HandBrakeCLI to run directly by default, got %v and %v", c.Command, c.Env)
+	}
+}
+
+func TestTranscodeTemplate_RawArgs(t *testing.T) {
+	rawArgs := []string{"-e", "x264", "--encopts", `b-adapt="2"`}
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", InputPath: "in.mkv", OutputPath: "out.mkv",
+		Preset: "tivo", PresetFile: "/config/ghb/presets.json", Profile: JobProfile{Args: []string{"--all-audio"}}, RawArgs: rawArgs})
+
+	got := j.Spec.Template.Spec.Containers[0].Args
+	want := append([]string{"-i", "in.mkv", "-o", "out.mkv"}, rawArgs...)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the raw args to be used verbatim after the input and output, got %q", got)
+	}
+}
+
+func TestTranscodeTemplate_DefaultArgs(t *testing.T) {
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", InputPath: "in.mkv", OutputPath: "out.mkv", Preset: "tivo",
+		DefaultArgs: []string{"--all-audio", "--markers"}, Profile: JobProfile{Args: []string{"--encoder-preset", "slow"}}})
+
+	got := j.Spec.Template.Spec.Containers[0].Args
+	want := []string{"--all-audio", "--markers", "--encoder-preset", "slow", "-i", "in.mkv", "-o", "out.mkv", "--preset", "tivo"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the default args before the profile args, got %q", got)
+	}
+
+	j = buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", InputPath: "in.mkv", OutputPath: "out.mkv", Preset: "tivo",
+		DefaultArgs: []string{"--all-audio"}, RawArgs: []string{"-e", "x264"}})
+	got = j.Spec.Template.Spec.Containers[0].Args
+	want = []string{"--all-audio", "-i", "in.mkv", "-o", "out.mkv", "-e", "x264"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expected the default args before the raw args, got %q", got)
+	}
+}
+
+func TestTranscodeTemplate_ScanTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// HandBrakeCLI hangs while scanning
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "HandBrakeCLI"), []byte("#!/bin/sh\nsleep 10\n"), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", Preset: "tivo", ScanTimeoutSeconds: 1})
+	c := j.Spec.Template.Spec.Containers[0]
+	if len(c.Command) == 0 || c.Command[0] != "sh" || len(c.Env) != 1 || c.Env[0].Name != "SCAN_TIMEOUT" || c.Env[0].Value != "1" {
+		t.Fatalf("expected the scan to be timed by a shell, got %v and %v", c.Command, c.Env)
+	}
+
+	cmd := exec.Command(c.Command[0], append(c.Command[1:], c.Args...)...)
+	cmd.Env = append(os.Environ(), "PATH="+tmpDir+":"+os.Getenv("PATH"), "SCAN_TIMEOUT=1")
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		t.Fatalf("expected the transcode to fail when the scan times out\n%s", output)
+	}
+	if !strings.Contains(string(output), "did not finish scanning") || time.Since(start) > 8*time.Second {
+		t.Fatalf("expected HandBrakeCLI to be stopped once the scan timed out\n%s", output)
+	}
+}
+
+func TestTranscodeTemplate_TitleScan(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping: the fake encoder is a shell script")
+	}
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// HandBrakeCLI reports the titles when scanning, and otherwise prints its arguments
+	script := `#!/bin/sh
+case "$*" in
+*--scan*)
+  printf '+ title 1:\n  + duration: 00:02:10\n+ title 2:\n  + duration: 01:45:02\n+ title 3:\n  + duration: 03:30:00\n' >&2 ;;
+*)
+  echo "args: $*" ;;
+esac
+`
+	err = ioutil.WriteFile(filepath.Join(tmpDir, "HandBrakeCLI"), []byte(script), 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	j := buildJob(t, "transcode.yaml", transcodeJobValues{Name: "foo", InputPath: "/work/claimed/foo", OutputPath: "/work/transcoded/foo.mkv", Preset: "tivo",
+		TitleScan: true, TitleMinSeconds: 40 * 60, TitleMaxSeconds: 3 * 60 * 60})
+	c := j.Spec.Template.Spec.Containers[0]
+	if len(c.Command) == 0 || c.Command[0] != "sh" {
+		t.Fatalf("expected the titles to be scanned by a shell, got %v", c.Command)
+	}
+
+	cmd := exec.Command(c.Command[0], append(c.Command[1:], c.Args...)...)
+	cmd.Env = append(os.Environ(), "PATH="+tmpDir+":"+os.Getenv("PATH"))
+	for _, env := range c.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%#v\n%s", err, output)
+	}
+	if !strings.Contains(string(output), "args: -i /work/claimed/foo -o /work/transcoded/foo.mkv --preset tivo --title 2") {
+		t.Fatalf("expected the longest title within the range to be transcoded\n%s", output)
+	}
+}
+
+func TestUploadTemplate_Devices(t *testing.T) {
+	j := buildJob(t, "upload.yaml", uploadJobValues{Name: "foo-phone", KeepRaw: true})
+	if !containsArg(j.Spec.Template.Spec.Containers[0].Args, "--keep-raw") {
+		t.Fatalf("expected the raw video to be kept for the other devices, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+
+	j = buildJob(t, "upload.yaml", uploadJobValues{Name: "foo-tv", Namespace: "handbrk8s", AlsoWaitFor: []string{"foo-phone-upload"}})
+	if containsArg(j.Spec.Template.Spec.Containers[0].Args, "--keep-raw") {
+		t.Fatalf("expected the last device to clean up the raw video, got %v", j.Spec.Template.Spec.Containers[0].Args)
+	}
+	initContainers := j.Spec.Template.Spec.InitContainers
+	if len(initContainers) != 2 {
+		t.Fatalf("expected to also wait for the upload of the other device, got %#v", initContainers)
+	}
+	flags := parseArgs(initContainers[1].Args)
+	if initContainers[1].Name != "jobchain-0" || flags["--name"] != "foo-phone-upload" || flags["--namespace"] != "handbrk8s" {
+		t.Fatalf("expected to wait for foo-phone-upload, got %#v", initContainers[1])
+	}
+}
+
+func containsArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
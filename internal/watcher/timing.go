@@ -0,0 +1,162 @@
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
+)
+
+// timingPollInterval is how often the jobs for a video are checked, to record when they started and completed.
+const timingPollInterval = 30 * time.Second
+
+// Timing records when a video reached each step of the pipeline.
+// A step that wasn't reached, for example because the video failed, is zero.
+type Timing struct {
+	Path string
+
+	// DetectedAt is when the video was first found, and StableAt is when it stopped changing.
+	DetectedAt, StableAt time.Time
+
+	// QueuedAt is when the jobs were created, or the video was claimed to be transcoded on the current host.
+	QueuedAt time.Time
+
+	// StartedAt is when the transcode started, and EncodedAt is when it completed.
+	StartedAt, EncodedAt time.Time
+
+	// CompletedAt is when the video was uploaded to Plex.
+	CompletedAt time.Time
+
+	// LogFile is where the logs of the transcode job are archived, when job logs are archived.
+	LogFile string
+
+	// Preset that the video was transcoded with.
+	Preset string
+
+	// EncodedSize is the size of what the transcode encoded, in bytes: the video, or every
+	// video in its batch. 0 when unknown.
+	EncodedSize int64
+
+	// OutputSize is the size of the transcoded video, in bytes. 0 when unknown, such as
+	// when it was uploaded to a bucket.
+	OutputSize int64
+
+	// Cancelled is set when the jobs of the video were cancelled, instead of failing.
+	Cancelled bool
+}
+
+// TimingRecord is the compact breakdown of a Timing, in seconds, so that it is easy to aggregate.
+type TimingRecord struct {
+	Path        string    `json:"path"`
+	DetectedAt  time.Time `json:"detectedAt"`
+	Stabilize   float64   `json:"stabilizeSeconds"`
+	Queue       float64   `json:"queueSeconds"`
+	Schedule    float64   `json:"scheduleSeconds"`
+	Encode      float64   `json:"encodeSeconds"`
+	PostProcess float64   `json:"postProcessSeconds"`
+	Total       float64   `json:"totalSeconds"`
+	LogFile     string    `json:"logFile,omitempty"`
+	Preset      string    `json:"preset,omitempty"`
+	EncodedSize int64     `json:"encodedSize,omitempty"`
+	OutputSize  int64     `json:"outputSize,omitempty"`
+	Failed      bool      `json:"failed,omitempty"`
+	Cancelled   bool      `json:"cancelled,omitempty"`
+}
+
+// newTiming starts the timing for a video.
+func newTiming(e fs.FileEvent) *Timing {
+	t := &Timing{Path: e.Path, DetectedAt: e.DetectedAt, StableAt: e.StableAt, EncodedSize: e.Size}
+	if t.StableAt.IsZero() {
+		t.StableAt = time.Now()
+	}
+	if t.DetectedAt.IsZero() {
+		t.DetectedAt = t.StableAt
+	}
+	return t
+}
+
+// Record breaks down how long each step took: detected to stable, stable to queued,
+// queued to started, the encode, and encoded to uploaded. Steps that weren't reached are 0,
+// and a video that wasn't uploaded failed, unless it was cancelled.
+func (t Timing) Record() TimingRecord {
+	r := TimingRecord{
+		Path:        t.Path,
+		DetectedAt:  t.DetectedAt,
+		Stabilize:   secondsBetween(t.DetectedAt, t.StableAt),
+		Queue:       secondsBetween(t.StableAt, t.QueuedAt),
+		Schedule:    secondsBetween(t.QueuedAt, t.StartedAt),
+		Encode:      secondsBetween(t.StartedAt, t.EncodedAt),
+		PostProcess: secondsBetween(t.EncodedAt, t.CompletedAt),
+		LogFile:     t.LogFile,
+		Preset:      t.Preset,
+		EncodedSize: t.EncodedSize,
+		OutputSize:  t.OutputSize,
+		Failed:      t.CompletedAt.IsZero() && !t.Cancelled,
+		Cancelled:   t.Cancelled,
+	}
+	r.Total = r.Stabilize + r.Queue + r.Schedule + r.Encode + r.PostProcess
+	return r
+}
+
+func secondsBetween(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return 0
+	}
+	return end.Sub(start).Seconds()
+}
+
+// emitTiming logs the timing record as a single line of JSON, and publishes it to the subscribers.
+func emitTiming(ctx context.Context, t *Timing) {
+	record, err := json.Marshal(t.Record())
+	if err == nil {
+		logf(ctx, "timing %s\n", record)
+	}
+	Publish(ctx, PipelineEvent{Type: EventTiming, Path: t.Path, Timing: t})
+}
+
+// trackJobTiming waits for the jobs of a video to complete, recording when the transcode started and
+// completed, and when the upload completed, and then emits the timing. Stops early when a job fails,
+// is removed, or the watcher is closed.
+func (s *JobSink) trackJobTiming(ctx context.Context, target JobTarget, t *Timing, transcodeJobName, uploadJobName string) {
+	client := s.jobsClient(target)
+	ticker := time.NewTicker(timingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		transcode, err := client.Get(transcodeJobName, target.Namespace)
+		if err != nil {
+			logln(ctx, err)
+			return
+		}
+		if t.StartedAt.IsZero() && transcode.Status.StartTime != nil {
+			t.StartedAt = transcode.Status.StartTime.Time
+		}
+		if t.EncodedAt.IsZero() && transcode.Status.CompletionTime != nil {
+			t.EncodedAt = transcode.Status.CompletionTime.Time
+		}
+
+		upload, err := client.Get(uploadJobName, target.Namespace)
+		if err != nil {
+			logln(ctx, err)
+			return
+		}
+		if upload.Status.CompletionTime != nil {
+			t.CompletedAt = upload.Status.CompletionTime.Time
+			// The size is only known when the watcher mounts where the video was uploaded
+			t.OutputSize, _ = fs.Size(upload.Annotations[jobs.OutputAnnotation])
+		}
+
+		if !t.CompletedAt.IsZero() || jobs.IsFailed(transcode) || jobs.IsFailed(upload) {
+			emitTiming(ctx, t)
+			return
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTiming_Record(t *testing.T) {
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	timing := Timing{
+		Path:        "/watch/Movies/foo.mkv",
+		DetectedAt:  start,
+		StableAt:    start.Add(10 * time.Second),
+		QueuedAt:    start.Add(11 * time.Second),
+		StartedAt:   start.Add(41 * time.Second),
+		EncodedAt:   start.Add(641 * time.Second),
+		CompletedAt: start.Add(661 * time.Second),
+	}
+
+	got := timing.Record()
+	want := TimingRecord{
+		Path:        "/watch/Movies/foo.mkv",
+		DetectedAt:  start,
+		Stabilize:   10,
+		Queue:       1,
+		Schedule:    30,
+		Encode:      600,
+		PostProcess: 20,
+		Total:       661,
+	}
+	if got != want {
+		t.Fatalf("expected %#v, got %#v", want, got)
+	}
+
+	// A video that failed to transcode only has the steps that it reached
+	timing.EncodedAt, timing.CompletedAt = time.Time{}, time.Time{}
+	got = timing.Record()
+	if got.Encode != 0 || got.PostProcess != 0 || got.Total != 41 {
+		t.Fatalf("expected the steps that weren't reached to be 0, got %#v", got)
+	}
+}
@@ -1,36 +1,133 @@
 package watcher
 
 import (
-	"io/ioutil"
-	"log"
+	"context"
+	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/handbrake"
 	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
-	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
 )
 
 // TranscodeJobValues are the set of values to replace in transcodeJobYaml
 type transcodeJobValues struct {
-	Name, InputPath, OutputDir, OutputPath, Preset string
+	Name, Namespace                  string
+	InputPath, OutputDir, OutputPath string
+	Preset, PresetFile               string
+	QueueSpecFile                    string
+	RestartPolicy                    corev1.RestartPolicy
+	BackoffLimit                     int32
+	ActiveDeadlineSeconds            int64
+	Profile                          JobProfile
+	SuccessExitCodes                 handbrake.ExitCodes
+	RawArgs                          []string
+	SubtitleArgs                     []string
+	ContainerArgs                    []string
+	DefaultArgs                      []string
+	ScanTimeoutSeconds               int64
+	TitleArgs                        []string
+	TitleScan                        bool
+	TitleMinSeconds, TitleMaxSeconds int64
+	MountWatchVolume                 bool
 }
 
-// CreateTranscodeJob creates a job to transcode a video
-func (w *VideoWatcher) createTranscodeJob(inputPath string, outputPath string) (jobName string, err error) {
-	templateFile := filepath.Join(w.TemplatesDir, "transcode.yaml")
-	template, err := ioutil.ReadFile(templateFile)
+// CreateTranscodeJob creates a job to transcode a video with the preset, or the raw args
+// when there are any, adding the container and the external subtitles unless there are raw args.
+// When there is a queue spec, it is written next to the output for the job to import, instead
+// of the preset, container and subtitles.
+func (s *JobSink) createTranscodeJob(ctx context.Context, target JobTarget, profile JobProfile, e fs.FileEvent, inputPath, outputPath, preset, container string, rawArgs, subtitles []string) (jobName string, err error) {
+	values, err := s.transcodeJobValues(ctx, target, profile, e, inputPath, outputPath, preset, container, rawArgs, subtitles)
 	if err != nil {
-		return "", errors.Wrapf(err, "could not read %s", templateFile)
+		return "", err
 	}
+	return s.createJobFromTemplate(ctx, target, "transcode.yaml", values)
+}
 
+// transcodeJobValues are the values of the transcode job for a video, see createTranscodeJob.
+func (s *JobSink) transcodeJobValues(ctx context.Context, target JobTarget, profile JobProfile, e fs.FileEvent, inputPath, outputPath, preset, container string, rawArgs, subtitles []string) (transcodeJobValues, error) {
 	filename := filepath.Base(inputPath)
 
-	log.Printf("creating transcode job for %s\n", filename)
+	var queueSpecFile string
+	if len(rawArgs) > 0 {
+		logf(ctx, "creating transcode job for %s with raw args\n", filename)
+	} else if s.QueueSpec != nil {
+		logf(ctx, "creating transcode job for %s with the queue spec %s\n", filename, s.QueueSpec.Path)
+		specPath := handbrake.QueueSpecPath(outputPath)
+		err := s.QueueSpec.WriteFor(specPath, s.PathRewrites.Rewrite(inputPath), s.PathRewrites.Rewrite(outputPath))
+		if err != nil {
+			return transcodeJobValues{}, err
+		}
+		queueSpecFile = s.PathRewrites.Rewrite(specPath)
+	} else {
+		logf(ctx, "creating transcode job for %s with the %s preset\n", filename, preset)
+	}
+	// The raw args and the queue spec select their own title
+	var title handbrake.TitleRule
+	if len(rawArgs) == 0 && s.QueueSpec == nil {
+		title = s.Title
+	}
+	info, err := os.Stat(inputPath)
+	isDir := err == nil && info.IsDir()
+	name, err := validNames(s.Names).JobName(e, inputPath)
+	if err != nil {
+		return transcodeJobValues{}, err
+	}
+
 	values := transcodeJobValues{
-		Name:       jobs.SanitizeJobName(filename),
-		InputPath:  inputPath,
-		OutputDir:  filepath.Dir(outputPath),
-		OutputPath: outputPath,
-		Preset:     w.VideoPreset,
+		Name:       name,
+		Namespace:  target.Namespace,
+		InputPath:  s.PathRewrites.Rewrite(inputPath),
+		OutputDir:  s.PathRewrites.Rewrite(filepath.Dir(outputPath)),
+		OutputPath: s.PathRewrites.Rewrite(outputPath),
+		Preset:     preset,
+		PresetFile: s.PresetFile,
+
+		QueueSpecFile: queueSpecFile,
+
+		RestartPolicy: s.RestartPolicy,
+		BackoffLimit:  s.BackoffLimit,
+
+		ActiveDeadlineSeconds: int64(s.TranscodeDeadline / time.Second),
+		Profile:               profile,
+		SuccessExitCodes:      s.SuccessExitCodes,
+		RawArgs:               rawArgs,
+		SubtitleArgs:          handbrake.SubtitleArgs(s.PathRewrites.RewriteAll(subtitles), s.BurnSubtitles),
+		ContainerArgs:         handbrake.ContainerArgs(container),
+		DefaultArgs:           s.DefaultArgs,
+		ScanTimeoutSeconds:    int64(s.ScanTimeout / time.Second),
+		TitleArgs:             title.Args(),
+		TitleScan:             title.NeedsScan(isDir),
+		TitleMinSeconds:       int64(title.Min / time.Second),
+		TitleMaxSeconds:       int64(title.Max / time.Second),
+		MountWatchVolume:      s.processing,
+	}
+	return values, nil
+}
+
+// removeQueueSpecAfter removes the queue spec written for a transcode job once the job is finished.
+func (s *JobSink) removeQueueSpecAfter(ctx context.Context, target JobTarget, transcodeJobName, specPath string) {
+	client := s.jobsClient(target)
+	ticker := time.NewTicker(timingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		j, err := client.Get(transcodeJobName, target.Namespace)
+		if err != nil {
+			logln(ctx, err)
+			return
+		}
+		if jobs.IsFinished(j) {
+			s.Sandbox.Remove(specPath)
+			return
+		}
 	}
-	return jobs.CreateFromTemplate(string(template), values)
 }
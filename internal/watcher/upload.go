@@ -1,43 +1,127 @@
 package watcher
 
 import (
-	"io/ioutil"
-	"log"
+	"context"
 	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
-	"github.com/pkg/errors"
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/carolynvs/handbrk8s/internal/httpclient"
 )
 
 type uploadJobValues struct {
-	WaitForJob                    string
-	Name, TranscodedFile, RawFile string
-	DestinationSuffix             string
-	PlexServer, PlexToken         string
-	PlexLibrary, PlexShare        string
+	WaitForJob              string
+	Name, Namespace         string
+	TranscodedFile, RawFile string
+	DestinationSuffix       string
+	PlexServer, PlexToken   string
+	PlexTokenSecret         string
+	PlexLibrary, PlexShare  string
+	PlexRefreshDebounce     time.Duration
+	PlexMinScanInterval     time.Duration
+	PlexHTTP                httpclient.Policy
+	ArchivePath             string
+	ArchiveRollback         bool
+	VerifyArchive           bool
+	MaxSizeRatio            float64
+	FailedFile              string
+	Checksum                bool
+	ChecksumSidecar         bool
+	AllowedDirs             string
+	OutputBucket            OutputBucket
+	Subtitles               bool
+	MountWatchVolume        bool
+
+	// KeepRaw leaves the raw video for the upload of another output, and AlsoWaitFor are the
+	// other jobs that must complete before this upload, such as the uploads of the other outputs.
+	KeepRaw     bool
+	AlsoWaitFor []string
+
+	// Source and Output are recorded on the job, so that its video can be finished after a restart
+	Source, Output string
+	PostHook       bool
 }
 
-// CreateUploadJob creates a job to upload a video to Plex
-func (w *VideoWatcher) createUploadJob(waitForJob, transcodedFile, rawFile, pathSuffix, library string) (jobName string, err error) {
-	templateFile := filepath.Join(w.TemplatesDir, "upload.yaml")
-	template, err := ioutil.ReadFile(templateFile)
+// CreateUploadJob creates a job to upload a video to Plex, at the destination path in the library share.
+// When the video has subtitles, they are cleaned up along with the raw video.
+func (s *JobSink) createUploadJob(ctx context.Context, target JobTarget, e fs.FileEvent, waitForJob, transcodedFile, rawFile, pathSuffix, destSuffix, library string, subtitles bool) (jobName string, err error) {
+	share, _ := s.Outputs.For(library, s.PlexCfg.Share, s.Organize)
+	values, err := s.uploadJobValues(ctx, target, e, waitForJob, transcodedFile, rawFile, pathSuffix, destSuffix, library, share, subtitles)
 	if err != nil {
-		return "", errors.Wrapf(err, "could not read %s", templateFile)
+		return "", err
 	}
+	return s.createJobFromTemplate(ctx, target, "upload.yaml", values)
+}
 
+// uploadJobValues are the values of the upload job for a video, uploaded to the share, see createUploadJob.
+func (s *JobSink) uploadJobValues(ctx context.Context, target JobTarget, e fs.FileEvent, waitForJob, transcodedFile, rawFile, pathSuffix, destSuffix, library, share string, subtitles bool) (uploadJobValues, error) {
 	filename := filepath.Base(transcodedFile)
+	name, err := validNames(s.Names).JobName(e, transcodedFile)
+	if err != nil {
+		return uploadJobValues{}, err
+	}
 
-	log.Printf("creating upload job for %s\n", filename)
+	logf(ctx, "creating upload job for %s\n", filename)
 	values := uploadJobValues{
-		Name:              jobs.SanitizeJobName(filename),
-		WaitForJob:        waitForJob,
-		TranscodedFile:    transcodedFile,
-		RawFile:           rawFile,
-		DestinationSuffix: pathSuffix,
-		PlexServer:        w.PlexCfg.URL,
-		PlexToken:         w.PlexCfg.Token,
-		PlexLibrary:       library,
-		PlexShare:         w.PlexCfg.Share, // Assume that the library name is the share path
-	}
-	return jobs.CreateFromTemplate(string(template), values)
+		Name:                name,
+		Namespace:           target.Namespace,
+		WaitForJob:          waitForJob,
+		TranscodedFile:      s.PathRewrites.Rewrite(transcodedFile),
+		RawFile:             s.PathRewrites.Rewrite(rawFile),
+		DestinationSuffix:   destSuffix,
+		PlexServer:          s.PlexCfg.URL,
+		PlexLibrary:         library,
+		PlexShare:           share, // Assume that the library name is the share path
+		PlexRefreshDebounce: s.PlexRefreshDebounce.For(library),
+		PlexMinScanInterval: s.PlexMinScanInterval.For(library),
+		PlexHTTP:            s.PlexCfg.HTTP,
+		Checksum:            s.Checksum,
+		ChecksumSidecar:     s.ChecksumSidecar,
+		OutputBucket:        s.OutputBucket,
+		Subtitles:           subtitles,
+		VerifyArchive:       s.VerifyArchive,
+		Source:              filepath.Join(s.WatchDir, pathSuffix),
+		Output:              s.postHookValues("", library, destSuffix).Output,
+		PostHook:            s.PostHook.IsSet(),
+	}
+	// Only embed the token in the job when it can't be read from a secret
+	if s.PlexTokenSecret != "" {
+		values.PlexTokenSecret = s.PlexTokenSecret
+	} else {
+		values.PlexToken = s.PlexCfg.Token
+	}
+	if s.ArchiveDir != "" {
+		values.ArchivePath = s.PathRewrites.Rewrite(filepath.Join(s.ArchiveDir, pathSuffix))
+		values.ArchiveRollback = s.ArchiveRollback
+	}
+	// The renamed video is moved back to the watch directory, at the same path as the watcher sees it
+	if s.Processed.IsSet() {
+		archivePath, err := s.Processed.Rename(s.WatchDir, pathSuffix)
+		if err != nil {
+			return uploadJobValues{}, err
+		}
+		values.ArchivePath = s.PathRewrites.Rewrite(archivePath)
+		values.ArchiveRollback = s.ArchiveRollback
+		values.MountWatchVolume = true
+	}
+	// The claimed video is in the processing directory of the watch volume
+	if s.processing {
+		values.MountWatchVolume = true
+	}
+	if s.DeletionQueue != nil && values.ArchivePath == "" {
+		values.ArchivePath = s.PathRewrites.Rewrite(s.DeletionQueue.Path(pathSuffix))
+		values.ArchiveRollback = s.ArchiveRollback
+	}
+	if s.Sandbox != nil {
+		values.AllowedDirs = strings.Join(s.PathRewrites.RewriteAll(s.Sandbox.Roots()), ",")
+	}
+	if s.MaxSizeRatio > 0 {
+		values.MaxSizeRatio = s.MaxSizeRatio
+	}
+	// A video that failed to transcode, in its batch or by being oversized, is moved for review
+	if s.FailedDir != "" {
+		values.FailedFile = s.PathRewrites.Rewrite(filepath.Join(s.FailedDir, pathSuffix))
+	}
+	return values, nil
 }
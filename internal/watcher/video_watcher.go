@@ -1,171 +1,471 @@
 package watcher
 
 import (
+	"context"
 	"log"
 	"os"
-	"path/filepath"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/carolynvs/handbrk8s/internal/fs"
-	"github.com/carolynvs/handbrk8s/internal/k8s/jobs"
-	"github.com/carolynvs/handbrk8s/internal/plex"
 	"github.com/pkg/errors"
 )
 
-const Namespace = "handbrk8s"
-
+// VideoWatcher watches a directory for new videos and passes each one to its sinks.
 type VideoWatcher struct {
-	done chan struct{}
+	ctx        context.Context
+	cancel     context.CancelFunc
+	dirWatcher fs.Watcher
+	events     *broker
 
-	// WatchDir contains raw (untranscoded) video files.
-	WatchDir string
+	// mu protects paused, throttled, outsideSchedule, schedule, queued, workQueue, restored, subtitles, locks, eventBus, estimator,
+	// initialScan and backlog
+	mu              sync.Mutex
+	paused          bool
+	throttled       bool
+	outsideSchedule bool
+	schedule        Schedule
+	queued          []fs.FileEvent
+	workQueue       *WorkQueue
+	restored        map[string]bool
+	subtitles       *subtitleGroups
+	locks           *videoLocks
+	eventBus        *eventBus
+	estimator       *EncodeEstimator
 
-	// ClaimDir temporarily holds raw video files while they are being transcoded.
-	ClaimDir string
+	// initialScan is true until the directory watcher has signaled the files that were already
+	// there, and backlog are the paths of the videos dispatched until then, see BacklogDrained.
+	initialScan    bool
+	backlog        map[string]bool
+	backlogDrained chan struct{}
 
-	// TranscodedDir contains completed (transcoded) video files.
-	TranscodedDir string
+	latency  latencyTracker
+	inFlight inFlightTracker
 
-	// TemplatesDir contains templates for jobs that are created by the watcher.
-	TemplatesDir string
+	// handling is the number of videos that were handed to the sinks, and that the sinks haven't finished with
+	handling int64
 
-	FailedDir string
+	// Sinks process each video, in order.
+	Sinks []EventSink
 
-	// VideoPreset is the name of a HandBrake preset.
-	VideoPreset string
+	// Errors signal when a sink was unable to handle a video.
+	Errors chan error
 
-	// PlexCfg contains connection information upload a file to a Plex server.
-	PlexCfg plex.LibraryConfig
+	// Rejected signals when a video was skipped, either by the directory watcher
+	// or by a sink. Rejections are dropped when the channel is full.
+	Rejected chan fs.RejectedFile
 }
 
-// NewVideoWatcher begins watching for new videos to transcode.
-func NewVideoWatcher(configVolume, watchVolume, workVolume string, videoPreset string, plexCfg plex.LibraryConfig) (*VideoWatcher, error) {
-	if _, err := os.Stat(configVolume); os.IsNotExist(err) {
-		return nil, errors.Errorf("config volume, %s, is not mounted", configVolume)
+// NewVideoWatcher begins handling new videos from the directory watcher, or another
+// source of videos, handing each to the sinks. When no sinks are specified, videos
+// are logged. The directory watcher is closed along with the video watcher.
+func NewVideoWatcher(dirWatcher fs.Watcher, sinks ...EventSink) *VideoWatcher {
+	if len(sinks) == 0 {
+		sinks = []EventSink{LogSink{}}
 	}
 
-	if _, err := os.Stat(watchVolume); os.IsNotExist(err) {
-		return nil, errors.Errorf("watch volume, %s, is not mounted", watchVolume)
+	events := &broker{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), brokerKey{}, events))
+	w := &VideoWatcher{
+		ctx:        ctx,
+		cancel:     cancel,
+		dirWatcher: dirWatcher,
+		events:     events,
+		Sinks:      sinks,
+		Errors:     make(chan error),
+		Rejected:   make(chan fs.RejectedFile, 100),
+
+		initialScan:    true,
+		backlog:        make(map[string]bool),
+		backlogDrained: make(chan struct{}),
 	}
+	events.observe = w.observe
+
+	go w.start()
+	return w
+}
+
+func (w *VideoWatcher) start() {
+	defer w.events.close()
+	defer w.dirWatcher.Close()
+
+	sourceCheck := time.NewTicker(sourceCheckInterval)
+	defer sourceCheck.Stop()
 
-	if _, err := os.Stat(workVolume); os.IsNotExist(err) {
-		return nil, errors.Errorf("work volume, %s, is not mounted", workVolume)
+	scanDone := initialScanDone(w.dirWatcher)
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-scanDone:
+			scanDone = nil
+			w.finishInitialScan()
+		case <-sourceCheck.C:
+			w.pruneRemoved()
+		case file, ok := <-w.dirWatcher.Files():
+			if !ok {
+				return
+			}
+			w.dispatch(file)
+		case r := <-w.dirWatcher.Rejections():
+			w.reject(r)
+		}
 	}
+}
 
-	w := &VideoWatcher{
-		done:          make(chan struct{}),
-		WatchDir:      filepath.Join(watchVolume, "watch"),
-		FailedDir:     filepath.Join(watchVolume, "fail"),
-		ClaimDir:      filepath.Join(workVolume, "claim"),
-		TranscodedDir: filepath.Join(workVolume, "work"),
-		TemplatesDir:  filepath.Join(configVolume, "templates"),
-		VideoPreset:   videoPreset,
-		PlexCfg:       plexCfg,
+// Subscribe returns a channel of every pipeline event from now on: videos
+// detected, skipped, handled or failed, along with the events published by
+// the sinks such as jobs created. Each subscriber gets its own channel, which
+// drops events when the subscriber falls behind, and is closed with the watcher.
+func (w *VideoWatcher) Subscribe() <-chan PipelineEvent {
+	return w.events.subscribe()
+}
+
+// Status of the pipeline.
+type Status struct {
+	// Paused is true when new videos are queued, instead of handled.
+	Paused bool `json:"paused"`
+
+	// Throttled is true when new files aren't checked, because too many videos are pending, see UseBackpressure.
+	Throttled bool `json:"throttled,omitempty"`
+
+	// OutsideSchedule is true when new videos are queued until the next processing window opens.
+	OutsideSchedule bool `json:"outsideSchedule,omitempty"`
+
+	// Queued is the number of videos waiting for the pipeline to resume, or for the processing window.
+	Queued int `json:"queued"`
+
+	// WatchedDirs are the directories that are currently watched, when the
+	// directory watcher reports them.
+	WatchedDirs []string `json:"watchedDirs,omitempty"`
+
+	// Held are the files held for manual review by the directory watcher, such as files
+	// that never stopped changing, when the directory watcher holds files.
+	Held []string `json:"held,omitempty"`
+
+	// BacklogDrained is true once the videos found on startup were handled, see BacklogDrained.
+	BacklogDrained bool `json:"backlogDrained"`
+
+	// QueueETA is the estimated time, in seconds, to encode the stable videos that haven't
+	// been submitted yet, when an EncodeEstimator is used. See QueueETA.
+	QueueETA float64 `json:"queueEtaSeconds,omitempty"`
+}
+
+// Pause stops handing new videos to the sinks, for example so that no jobs are
+// created during cluster maintenance. Videos that are already being handled continue,
+// and new videos are queued until the watcher is resumed. When the directory watcher
+// supports it, it stops checking new files until it is resumed too.
+func (w *VideoWatcher) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.paused {
+		return
 	}
+	log.Println("pausing, new videos are queued until resumed")
+	w.paused = true
+	if p, ok := w.dirWatcher.(fs.Pauser); ok {
+		p.Pause()
+	}
+}
 
-	err := os.MkdirAll(w.WatchDir, 0755)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to create watch directory %s", w.WatchDir)
+// Resume hands the queued videos to the sinks, and then handles new videos as they are found.
+func (w *VideoWatcher) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.paused {
+		return
+	}
+	log.Printf("resuming, with %d queued videos\n", len(w.queued))
+	w.paused = false
+	w.releaseLocked()
+	if p, ok := w.dirWatcher.(fs.Pauser); ok && !w.throttled {
+		p.Resume()
 	}
+}
 
-	err = os.MkdirAll(w.FailedDir, 0755)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to create failed directory %s", w.FailedDir)
+// UseSchedule only hands videos to the sinks during the windows of the schedule, for
+// example to only transcode overnight. Outside of the windows, videos are still checked
+// until they are stable, and then queued until the next window opens.
+func (w *VideoWatcher) UseSchedule(s Schedule) {
+	w.mu.Lock()
+	w.schedule = s
+	w.mu.Unlock()
+
+	w.checkSchedule(time.Now())
+	go func() {
+		ticker := time.NewTicker(scheduleCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.ctx.Done():
+				return
+			case now := <-ticker.C:
+				w.checkSchedule(now)
+			}
+		}
+	}()
+}
+
+// checkSchedule queues new videos when the processing window closed, and hands
+// the queued videos to the sinks when it opened, unless the watcher is paused.
+func (w *VideoWatcher) checkSchedule(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	outside := !w.schedule.IsOpen(now)
+	if outside == w.outsideSchedule {
+		return
 	}
+	w.outsideSchedule = outside
+	if outside {
+		log.Println("the processing window closed, new videos are queued until it opens")
+		return
+	}
+	log.Printf("the processing window opened, with %d queued videos\n", len(w.queued))
+	w.releaseLocked()
+}
 
-	err = os.MkdirAll(w.ClaimDir, 0755)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to create claim directory %s", w.ClaimDir)
+// releaseLocked hands the queued videos to the sinks, unless the watcher is paused
+// or outside of its schedule. The caller must hold mu.
+func (w *VideoWatcher) releaseLocked() {
+	if w.paused || w.outsideSchedule {
+		return
+	}
+	for _, file := range w.queued {
+		go w.handleVideo(file)
 	}
+	w.queued = nil
+}
 
-	err = os.MkdirAll(w.TranscodedDir, 0755)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to create transcoded directory %s", w.TranscodedDir)
+// Status reports if the watcher is paused, how many videos are queued, and
+// the directories that are watched.
+func (w *VideoWatcher) Status() Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := Status{Paused: w.paused, Throttled: w.throttled, OutsideSchedule: w.outsideSchedule, Queued: len(w.queued),
+		BacklogDrained: w.isBacklogDrainedLocked()}
+	if l, ok := w.dirWatcher.(fs.DirLister); ok {
+		status.WatchedDirs = l.WatchedDirs()
+	}
+	if h, ok := w.dirWatcher.(fs.Holder); ok {
+		status.Held = h.Held()
 	}
+	for _, size := range w.latency.waitingSizes() {
+		status.QueueETA += w.estimateLocked(size).Seconds()
+	}
+	return status
+}
 
-	log.Printf("watching %s for new videos\n", w.WatchDir)
-	go w.start()
-	return w, nil
+// Latency reports how long the stable videos have waited to be submitted, and how long
+// the submitted videos took from when they were found until they were submitted.
+func (w *VideoWatcher) Latency() Latency {
+	return w.latency.snapshot(time.Now())
 }
 
-func (w *VideoWatcher) start() {
-	dirWatcher, err := fs.NewStableFileWatcher(w.WatchDir, 5*time.Second)
-	if err != nil {
-		log.Fatal(errors.Wrapf(err, "unable to watch %s", w.WatchDir))
+// observe records when a video is submitted, and which jobs or transcodes are in flight,
+// from the events published by the sinks.
+func (w *VideoWatcher) observe(e PipelineEvent) {
+	switch e.Type {
+	case EventJobCreated:
+		w.latency.submit(e.Path, e.At)
+		w.inFlight.start(inFlightKey{job: e.Job})
+	case EventTranscodeStarted:
+		w.latency.submit(e.Path, e.At)
+		w.inFlight.start(inFlightKey{path: e.Path})
+	case EventJobFinished:
+		w.inFlight.stop(inFlightKey{job: e.Job})
 	}
-	defer dirWatcher.Close()
+}
 
-	for {
-		select {
-		case <-w.done:
-			return
-		case file := <-dirWatcher.Events:
-			go w.handleVideo(file.Path)
-		}
+// Rescan changes which videos the directory watcher signals, and checks the videos already
+// in the watch directory against the new filter, returning how many were newly found.
+func (w *VideoWatcher) Rescan(pathRegex string) (int, error) {
+	r, ok := w.dirWatcher.(fs.Rescanner)
+	if !ok {
+		return 0, errors.New("the source of videos can't be rescanned")
 	}
+	return r.Rescan(pathRegex)
 }
 
-func (w *VideoWatcher) Close() {
-	close(w.done)
+// Release has the directory watcher check a file that it held for manual review again, and
+// signal it once it is stable. Returns false when the file isn't held.
+func (w *VideoWatcher) Release(path string) (bool, error) {
+	h, ok := w.dirWatcher.(fs.Holder)
+	if !ok {
+		return false, errors.New("the source of videos doesn't hold files")
+	}
+	return h.Release(path), nil
 }
 
-func (w *VideoWatcher) handleVideo(path string) {
-	// Ignore hidden files
-	if strings.HasPrefix(".", filepath.Base(path)) {
-		return
+// Reconcile has each sink that is a Reconciler pick up the work left behind before the
+// watcher restarted, such as jobs that are still running, and tracks it until the watcher
+// is closed. Every sink is reconciled, returning the first error.
+func (w *VideoWatcher) Reconcile() error {
+	var firstErr error
+	for _, sink := range w.Sinks {
+		r, ok := sink.(Reconciler)
+		if !ok {
+			continue
+		}
+		err := r.Reconcile(w.ctx)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	// Preserve the directory nesting of the video relative to the watch directory
-	// Example: /watch/Movies/Foo/bar.mkv -> Movies/Foo/bar.mkv
-	pathSuffix, err := filepath.Rel(w.WatchDir, path)
+// UseWorkQueue persists each video in the work queue until the sinks have handled it,
+// and handles the videos that were left in the queue when the watcher last stopped.
+// A queued video that is no longer there, for example because it was claimed by a
+// sink before the restart, is removed from the queue.
+func (w *VideoWatcher) UseWorkQueue(q *WorkQueue) error {
+	pending, err := q.Pending()
 	if err != nil {
-		log.Println(errors.Wrapf(err, "unable to determine path suffix of %s, skipping for now",
-			path))
-		return
+		return err
 	}
 
-	// Claim the file by moving it out of the watch directory,
-	// prevents attempts to process it a second time
-	claimPath := filepath.Join(w.ClaimDir, pathSuffix)
-	log.Printf("attempting to claim %s\n", path)
-	err = fs.MoveFile(path, claimPath)
-	if err != nil {
-		log.Println(errors.Wrapf(err, "unable to move %s to %s, skipping for now",
-			path, claimPath))
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.workQueue = q
+	w.restored = make(map[string]bool)
+	for _, file := range pending {
+		if _, err := os.Stat(file.Path); err != nil {
+			w.logError(q.Remove(file.Path))
+			continue
+		}
+		log.Printf("resuming %s from the work queue\n", file.Path)
+		w.restored[file.Path] = true
+		w.dispatchLocked(file, false)
+	}
+	return nil
+}
+
+// dispatch handles the video, or queues it when the watcher is paused or outside of its schedule.
+func (w *VideoWatcher) dispatch(file fs.FileEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// The directory watcher finds the videos from the work queue again after a restart
+	if w.restored[file.Path] {
 		return
 	}
+	if w.subtitles != nil && w.subtitles.holdLocked(w, file) {
+		return
+	}
+	w.dispatchLocked(file, true)
+}
 
-	transcodedPath := filepath.Join(w.TranscodedDir, pathSuffix)
-	transcodeJobName, err := w.createTranscodeJob(claimPath, transcodedPath)
-	if err != nil {
-		log.Println(err)
-		w.cleanupFailedClaim(claimPath)
+// dispatchLocked handles the video, or queues it when the watcher is paused or outside
+// of its schedule, optionally adding it to the work queue first. The caller must hold mu.
+func (w *VideoWatcher) dispatchLocked(file fs.FileEvent, persist bool) {
+	if persist && w.workQueue != nil {
+		w.logError(w.workQueue.Add(file))
+	}
+	w.latency.wait(file)
+	w.addBacklogLocked(file.Path)
+
+	if w.paused || w.outsideSchedule {
+		w.queued = append(w.queued, file)
 		return
 	}
+	go w.handleVideo(file)
+}
 
-	// Assume that the library is the first segment of the path, e.g. /watch/LIBRARY/../video.mkv
-	library := strings.Split(pathSuffix, string(os.PathSeparator))[0]
+// finish removes a video from the work queue, once the sinks have handled or rejected it.
+// A video that failed is left in the queue, to be tried again after a restart.
+func (w *VideoWatcher) finish(file fs.FileEvent, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	_, err = w.createUploadJob(transcodeJobName, transcodedPath, claimPath, pathSuffix, library)
+	delete(w.restored, file.Path)
+	w.latency.done(file.Path)
+	w.inFlight.stop(inFlightKey{path: file.Path})
+	if !failed && w.workQueue != nil {
+		w.logError(w.workQueue.Remove(file.Path))
+	}
+}
+
+// logError logs an error that doesn't stop a video from being handled.
+func (w *VideoWatcher) logError(err error) {
 	if err != nil {
 		log.Println(err)
-		err = jobs.Delete(transcodeJobName, Namespace)
+	}
+}
+
+// Close stops watching for new videos.
+func (w *VideoWatcher) Close() {
+	w.cancel()
+}
+
+// handleVideo passes the video to each sink, stopping at the first sink that fails or rejects it.
+// The sinks tag their logs and events with the correlation id of the video.
+func (w *VideoWatcher) handleVideo(file fs.FileEvent) {
+	atomic.AddInt64(&w.handling, 1)
+	defer atomic.AddInt64(&w.handling, -1)
+	defer w.finishBacklog(file.Path)
+
+	ctx := withCorrelationID(w.ctx, file.Path)
+	handled := false
+	w.mu.Lock()
+	locks := w.locks
+	w.mu.Unlock()
+	if locks != nil {
+		lock, ok := w.lockVideo(locks, file)
+		if !ok {
+			return
+		}
+		var l *videoLock
+		ctx, l = withVideoLock(ctx, lock)
+		defer func() {
+			if !handled || !l.kept {
+				l.release()
+			}
+		}()
+	}
+
+	w.events.publish(PipelineEvent{Type: EventDetected, Path: file.Path, Metadata: file.Metadata, Size: file.Size})
+
+	for _, sink := range w.Sinks {
+		err := sink.Handle(ctx, file)
+		if rejectErr, ok := errors.Cause(err).(RejectError); ok {
+			w.finish(file, false)
+			w.reject(fs.RejectedFile{Path: file.Path, Reason: rejectErr.Reason})
+			return
+		}
 		if err != nil {
-			log.Println(err)
+			w.finish(file, true)
+			w.events.publish(PipelineEvent{Type: EventFailed, Path: file.Path, Err: err})
+			w.reportError(errors.Wrapf(err, "unable to handle %s", file.Path))
+			return
 		}
-		w.cleanupFailedClaim(claimPath)
-		return
 	}
+
+	handled = true
+	w.finish(file, false)
+	w.events.publish(PipelineEvent{Type: EventHandled, Path: file.Path})
 }
 
-func (w *VideoWatcher) cleanupFailedClaim(claimPath string) {
-	pathSuffix := strings.Replace(claimPath, w.ClaimDir, "", 1)
+// reject signals that the video was skipped, dropping the rejection when nothing is receiving them.
+func (w *VideoWatcher) reject(r fs.RejectedFile) {
+	w.events.publish(PipelineEvent{Type: EventSkipped, Path: r.Path, Reason: r.Reason})
 
-	log.Printf("cleaning up failed claim: %s\n", claimPath)
-	failedPath := filepath.Join(w.FailedDir, pathSuffix)
-	err := fs.MoveFile(claimPath, failedPath)
-	if err != nil {
-		log.Println(errors.Wrap(err, "unable to cleanup failed claim"))
+	select {
+	case w.Rejected <- r:
+	default:
+	}
+}
+
+// reportError sends the error to Errors, giving up when the watcher is closed.
+func (w *VideoWatcher) reportError(err error) {
+	select {
+	case <-w.ctx.Done():
+		log.Println(err)
+	case w.Errors <- err:
 	}
 }
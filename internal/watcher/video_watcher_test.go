@@ -0,0 +1,350 @@
+package watcher
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+var testStableThreshold = 500 * time.Millisecond
+
+// recordingSink remembers each video that it handles.
+type recordingSink struct {
+	events chan fs.FileEvent
+	err    error
+}
+
+func newRecordingSink(err error) *recordingSink {
+	return &recordingSink{events: make(chan fs.FileEvent, 10), err: err}
+}
+
+func (s *recordingSink) Handle(ctx context.Context, e fs.FileEvent) error {
+	s.events <- e
+	return s.err
+}
+
+// keepingSink keeps the lock of each video, like the JobSink until the jobs of the video finish.
+type keepingSink struct {
+	releases chan func()
+	err      error
+}
+
+func (s *keepingSink) Handle(ctx context.Context, e fs.FileEvent) error {
+	s.releases <- keepVideoLock(ctx)
+	return s.err
+}
+
+func newTestVideoWatcher(t *testing.T, dir string, sinks ...EventSink) *VideoWatcher {
+	dirWatcher, err := fs.NewStableFileWatcher(dir, testStableThreshold)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	return NewVideoWatcher(dirWatcher, sinks...)
+}
+
+func createFile(t *testing.T, path string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	// Empty files are never signaled
+	_, err = f.WriteString("video")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	err = f.Close()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+}
+
+func TestVideoWatcher_Sinks(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	first := newRecordingSink(nil)
+	second := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, tmpDir, first, second)
+	defer w.Close()
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	createFile(t, video)
+
+	for _, s := range []*recordingSink{first, second} {
+		select {
+		case e := <-s.events:
+			if e.Path != video {
+				t.Fatalf("expected an event for %s, got %s", video, e.Path)
+			}
+		case err := <-w.Errors:
+			t.Fatalf("%+v", err)
+		case <-time.After(5 * testStableThreshold):
+			t.Fatal("expected each sink to handle the video")
+		}
+	}
+}
+
+func TestVideoWatcher_SinkError(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	failing := newRecordingSink(errors.New("boom"))
+	skipped := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, tmpDir, failing, skipped)
+	defer w.Close()
+
+	createFile(t, filepath.Join(tmpDir, "foo.mkv"))
+
+	select {
+	case <-w.Errors:
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the sink error to be reported")
+	}
+
+	select {
+	case e := <-skipped.events:
+		t.Fatalf("expected sinks after a failure to be skipped, got %v", e)
+	default:
+	}
+}
+
+func TestVideoWatcher_SinkRejected(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	rejecting := newRecordingSink(errors.Wrap(Reject(RejectHidden), "filtered"))
+	skipped := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, tmpDir, rejecting, skipped)
+	defer w.Close()
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	createFile(t, video)
+
+	select {
+	case r := <-w.Rejected:
+		if r.Path != video || r.Reason != RejectHidden {
+			t.Fatalf("expected %s to be rejected as hidden, got %#v", video, r)
+		}
+	case err := <-w.Errors:
+		t.Fatalf("expected a rejection instead of an error, got %+v", err)
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the rejection to be reported")
+	}
+
+	select {
+	case e := <-skipped.events:
+		t.Fatalf("expected sinks after a rejection to be skipped, got %v", e)
+	default:
+	}
+}
+
+func TestVideoWatcher_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sink := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, tmpDir, sink)
+	first := w.Subscribe()
+	second := w.Subscribe()
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	createFile(t, video)
+
+	for _, events := range []<-chan PipelineEvent{first, second} {
+		for _, want := range []PipelineEventType{EventDetected, EventHandled} {
+			select {
+			case e := <-events:
+				if e.Type != want || e.Path != video {
+					t.Fatalf("expected a %s event for %s, got %#v", want, video, e)
+				}
+			case <-time.After(5 * testStableThreshold):
+				t.Fatalf("expected a %s event", want)
+			}
+		}
+	}
+
+	w.Close()
+	for _, events := range []<-chan PipelineEvent{first, second} {
+		select {
+		case e, ok := <-events:
+			if ok {
+				t.Fatalf("expected no more events, got %#v", e)
+			}
+		case <-time.After(5 * testStableThreshold):
+			t.Fatal("expected the subscription to be closed with the watcher")
+		}
+	}
+}
+
+func TestVideoWatcher_PauseResume(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	sink := newRecordingSink(nil)
+	w := newTestVideoWatcher(t, tmpDir, sink)
+	defer w.Close()
+
+	w.Pause()
+	if !w.Status().Paused {
+		t.Fatal("expected the status to report that the watcher is paused")
+	}
+
+	video := filepath.Join(tmpDir, "foo.mkv")
+	createFile(t, video)
+
+	select {
+	case e := <-sink.events:
+		t.Fatalf("expected no videos to be handled while paused, got %s", e.Path)
+	case <-time.After(3 * testStableThreshold):
+	}
+
+	w.Resume()
+	select {
+	case e := <-sink.events:
+		if e.Path != video {
+			t.Fatalf("expected an event for %s, got %s", video, e.Path)
+		}
+	case err := <-w.Errors:
+		t.Fatalf("%+v", err)
+	case <-time.After(5 * testStableThreshold):
+		t.Fatal("expected the video found while paused to be handled on resume")
+	}
+	if w.Status().Paused {
+		t.Fatal("expected the status to report that the watcher resumed")
+	}
+}
+
+func TestVideoWatcher_UseLocks(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	locks, err := fs.NewLockDir(filepath.Join(tmpDir, ".handbrk8s-locks"), time.Minute)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	// Another watcher is processing the video
+	held, err := locks.Acquire("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer held.Release()
+
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+	w.UseLocks(locks, tmpDir)
+
+	files <- fs.FileEvent{Path: filepath.Join(tmpDir, "Movies", "foo.mkv")}
+	files <- fs.FileEvent{Path: filepath.Join(locks.Dir, "abc.lock")}
+	r := <-w.Rejected
+	if r.Reason != RejectLock {
+		t.Fatalf("expected the lock file to be skipped as %s, got %#v", RejectLock, r)
+	}
+
+	bar := filepath.Join(tmpDir, "Movies", "bar.mkv")
+	files <- fs.FileEvent{Path: bar}
+	e := <-sink.events
+	if e.Path != bar {
+		t.Fatalf("expected only the video that isn't locked to be handled, got %s", e.Path)
+	}
+	if _, err := locks.Acquire("Movies/foo.mkv"); err == nil {
+		t.Fatal("expected the lock of the other watcher to be kept")
+	}
+}
+
+func TestVideoWatcher_UseLocks_Kept(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	locks, err := fs.NewLockDir(filepath.Join(tmpDir, ".handbrk8s-locks"), time.Minute)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	files := make(chan fs.FileEvent)
+	sink := &keepingSink{releases: make(chan func(), 1)}
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+	w.UseLocks(locks, tmpDir)
+	events := w.Subscribe()
+
+	waitFor := func(eventType PipelineEventType) {
+		for e := range events {
+			if e.Type == eventType {
+				return
+			}
+		}
+	}
+
+	// The sink handled the video, and keeps it locked until it releases it
+	files <- fs.FileEvent{Path: filepath.Join(tmpDir, "Movies", "foo.mkv")}
+	release := <-sink.releases
+	waitFor(EventHandled)
+	if _, err := locks.Acquire("Movies/foo.mkv"); err == nil {
+		t.Fatal("expected the lock to be kept once the video was handled")
+	}
+	release()
+	lock, err := locks.Acquire("Movies/foo.mkv")
+	if err != nil {
+		t.Fatalf("expected the lock to be released by the sink, got %+v", err)
+	}
+	lock.Release()
+
+	// A video that the sink failed to handle is released anyway
+	sink.err = errors.New("unable to create the jobs")
+	files <- fs.FileEvent{Path: filepath.Join(tmpDir, "Movies", "bar.mkv")}
+	<-sink.releases
+	<-w.Errors
+	for i := 0; ; i++ {
+		lock, err = locks.Acquire("Movies/bar.mkv")
+		if err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("expected the lock of the failed video to be released, got %+v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lock.Release()
+}
@@ -0,0 +1,105 @@
+package watcher
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+// workQueueExt is the extension of each entry in a work queue directory.
+const workQueueExt = ".json"
+
+// WorkQueue persists the videos that were found, until the sinks have handled them,
+// so that the videos that were waiting when the watcher stopped are handled after
+// it restarts. Each video is a file in the queue directory.
+type WorkQueue struct {
+	// Dir holds an entry for each video in the queue.
+	Dir string
+
+	// Cipher optionally encrypts the entries, so that the paths are kept private.
+	Cipher *fs.Cipher
+}
+
+// NewWorkQueue creates the queue directory.
+func NewWorkQueue(dir string) (*WorkQueue, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to create work queue directory %s", dir)
+	}
+	return &WorkQueue{Dir: dir}, nil
+}
+
+// entryPath is where the video is recorded in the queue.
+func (q *WorkQueue) entryPath(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(q.Dir, hex.EncodeToString(sum[:])+workQueueExt)
+}
+
+// Add records the video in the queue. The entry is written to a temporary
+// file first, so that a partial entry is never read back.
+func (q *WorkQueue) Add(e fs.FileEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrapf(err, "unable to serialize the work queue entry for %s", e.Path)
+	}
+
+	entryPath := q.entryPath(e.Path)
+	tmpPath := entryPath + ".tmp"
+	err = q.Cipher.WriteFile(tmpPath, b, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write the work queue entry for %s", e.Path)
+	}
+	err = os.Rename(tmpPath, entryPath)
+	if err != nil {
+		return errors.Wrapf(err, "unable to write the work queue entry for %s", e.Path)
+	}
+	return nil
+}
+
+// Remove the video from the queue.
+func (q *WorkQueue) Remove(path string) error {
+	err := os.Remove(q.entryPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "unable to remove the work queue entry for %s", path)
+	}
+	return nil
+}
+
+// Pending lists the videos in the queue, in the order that they were found.
+func (q *WorkQueue) Pending() ([]fs.FileEvent, error) {
+	files, err := ioutil.ReadDir(q.Dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list the work queue %s", q.Dir)
+	}
+
+	var pending []fs.FileEvent
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), workQueueExt) {
+			continue
+		}
+		entryPath := filepath.Join(q.Dir, f.Name())
+		b, err := q.Cipher.ReadFile(entryPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read the work queue entry %s", entryPath)
+		}
+		var e fs.FileEvent
+		err = json.Unmarshal(b, &e)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse the work queue entry %s", entryPath)
+		}
+		pending = append(pending, e)
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].DetectedAt.Before(pending[j].DetectedAt)
+	})
+	return pending, nil
+}
@@ -0,0 +1,149 @@
+package watcher
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/handbrk8s/internal/fs"
+	"github.com/pkg/errors"
+)
+
+func TestWorkQueue(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	q, err := NewWorkQueue(filepath.Join(tmpDir, "queue"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, path := range []string{"/watch/TV/bar.mkv", "/watch/Movies/foo.mkv"} {
+		err = q.Add(fs.FileEvent{Path: path, DetectedAt: start.Add(time.Duration(i) * time.Minute)})
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+	err = q.Remove("/watch/TV/bar.mkv")
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	pending, err := q.Pending()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if len(pending) != 1 || pending[0].Path != "/watch/Movies/foo.mkv" || !pending[0].DetectedAt.Equal(start.Add(time.Minute)) {
+		t.Fatalf("expected only foo.mkv to be pending, got %#v", pending)
+	}
+}
+
+func TestVideoWatcher_UseWorkQueue(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	watchDir := filepath.Join(tmpDir, "watch")
+	err = os.Mkdir(watchDir, 0755)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	q, err := NewWorkQueue(filepath.Join(tmpDir, "queue"))
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	// foo.mkv was waiting when the watcher stopped, and bar.mkv was already claimed
+	fooPath := filepath.Join(watchDir, "foo.mkv")
+	createFile(t, fooPath)
+	for _, path := range []string{fooPath, filepath.Join(watchDir, "bar.mkv")} {
+		err = q.Add(fs.FileEvent{Path: path})
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+	}
+
+	sink := newRecordingSink(nil)
+	w := NewVideoWatcher(&fakeWatcher{files: make(chan fs.FileEvent)}, sink)
+	defer w.Close()
+	err = w.UseWorkQueue(q)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	select {
+	case e := <-sink.events:
+		if e.Path != fooPath {
+			t.Fatalf("expected the waiting video to be resumed, got %s", e.Path)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the waiting video to be resumed from the work queue")
+	}
+
+	// Once handled, nothing is left in the queue
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		pending, err := q.Pending()
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+		if len(pending) == 0 {
+			return
+		}
+	}
+	t.Fatal("expected the work queue to be empty once the videos were handled")
+}
+
+func TestVideoWatcher_WorkQueueKeepsFailedVideos(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	q, err := NewWorkQueue(tmpDir)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	files := make(chan fs.FileEvent)
+	sink := newRecordingSink(errors.New("unable to create the jobs"))
+	w := NewVideoWatcher(&fakeWatcher{files: files}, sink)
+	defer w.Close()
+	err = w.UseWorkQueue(q)
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	files <- fs.FileEvent{Path: "/watch/Movies/foo.mkv"}
+	<-sink.events
+	<-w.Errors
+
+	pending, err := q.Pending()
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("expected the failed video to stay in the work queue, got %#v", pending)
+	}
+}
+
+// fakeWatcher signals the files sent by the test.
+type fakeWatcher struct {
+	files chan fs.FileEvent
+}
+
+func (w *fakeWatcher) Files() <-chan fs.FileEvent         { return w.files }
+func (w *fakeWatcher) Rejections() <-chan fs.RejectedFile { return nil }
+func (w *fakeWatcher) Close()                             {}